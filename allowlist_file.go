@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// AllowlistFile holds the entries from a file listing one entry per line.
+// The entries are reloaded whenever the file is updated or the process
+// receives a SIGHUP.
+type AllowlistFile struct {
+	filename string
+	entries  atomic.Value
+}
+
+// NewAllowlistFile loads the entries from the file and watches it for updates.
+// onUpdate is called with the new entries after each successful reload.
+// If a reload fails, the previous entries are kept.
+func NewAllowlistFile(filename string, done <-chan bool, onUpdate func([]string)) (*AllowlistFile, error) {
+	entries, err := loadAllowlistFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AllowlistFile{filename: filename}
+	a.entries.Store(entries)
+
+	reload := func() {
+		entries, err := loadAllowlistFile(filename)
+		if err != nil {
+			logger.Errorf("error reloading %s, keeping the previous entries: %v", filename, err)
+			return
+		}
+		a.entries.Store(entries)
+		logger.Printf("reloaded %d entries from %s", len(entries), filename)
+		onUpdate(entries)
+	}
+	WatchForUpdates(filename, done, reload)
+	ReloadOnSignal(done, reload)
+
+	return a, nil
+}
+
+// Entries returns the entries most recently loaded from the file
+func (a *AllowlistFile) Entries() []string {
+	return a.entries.Load().([]string)
+}
+
+// loadAllowlistFile reads the entries from the file, ignoring blank lines
+// and lines starting with '#'
+func loadAllowlistFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReloadOnSignal performs an action every time the process receives a SIGHUP
+func ReloadOnSignal(done <-chan bool, action func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sighup)
+		for {
+			select {
+			case <-done:
+				return
+			case <-sighup:
+				logger.Printf("reloading after signal: SIGHUP")
+				action()
+			}
+		}
+	}()
+}
+
+// combineAllowlists returns the entries from the options followed by the
+// entries from a file, without modifying either list
+func combineAllowlists(entries []string, fileEntries []string) []string {
+	combined := make([]string, 0, len(entries)+len(fileEntries))
+	combined = append(combined, entries...)
+	return append(combined, fileEntries...)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
+	. "github.com/onsi/gomega"
+)
+
+func writeAllowlistFile(t *testing.T, filename string, contents string) {
+	if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write allowlist file: %v", err)
+	}
+}
+
+func TestLoadAllowlistFile(t *testing.T) {
+	g := NewWithT(t)
+	dir, err := ioutil.TempDir("", "allowlist")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "domains")
+	writeAllowlistFile(t, filename, "# allowed domains\nfoo.example.com\n\n  .bar.example.com  \n")
+
+	entries, err := loadAllowlistFile(filename)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(Equal([]string{"foo.example.com", ".bar.example.com"}))
+
+	_, err = loadAllowlistFile(filepath.Join(dir, "missing"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestAllowlistFileReloadsOnUpdate(t *testing.T) {
+	g := NewWithT(t)
+	dir, err := ioutil.TempDir("", "allowlist")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "groups")
+	writeAllowlistFile(t, filename, "admins\n")
+
+	done := make(chan bool)
+	defer close(done)
+	updated := make(chan []string, 10)
+	allowlist, err := NewAllowlistFile(filename, done, func(entries []string) {
+		updated <- entries
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(allowlist.Entries()).To(Equal([]string{"admins"}))
+
+	writeAllowlistFile(t, filename, "admins\ndevelopers\n")
+	g.Eventually(updated, 5*time.Second).Should(Receive(Equal([]string{"admins", "developers"})))
+	g.Expect(allowlist.Entries()).To(Equal([]string{"admins", "developers"}))
+}
+
+func TestNewAllowlistFileWithMissingFile(t *testing.T) {
+	g := NewWithT(t)
+	_, err := NewAllowlistFile("/does/not/exist", nil, func([]string) {})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestAllowedGroupsUpdaterKeepsGroupsWhenFileIsEmpty(t *testing.T) {
+	g := NewWithT(t)
+	providerData := &providers.ProviderData{}
+	update := allowedGroupsUpdater(providerData, []string{"admins"}, "groups")
+
+	update([]string{"developers"})
+	g.Expect(providerData.AllowedGroups).To(Equal(map[string]struct{}{
+		"admins":     {},
+		"developers": {},
+	}))
+
+	update([]string{})
+	g.Expect(providerData.AllowedGroups).To(Equal(map[string]struct{}{
+		"admins":     {},
+		"developers": {},
+	}))
+}
+
+func TestWatchAllowedGroupsFileWithEmptyFile(t *testing.T) {
+	g := NewWithT(t)
+	dir, err := ioutil.TempDir("", "allowlist")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "groups")
+	writeAllowlistFile(t, filename, "# no groups yet\n")
+
+	opts := baseTestOptions()
+	g.Expect(validation.Validate(opts)).To(Succeed())
+	opts.Providers[0].AllowedGroupsFile = filename
+	g.Expect(watchAllowedGroupsFile(opts)).To(MatchError("allowed groups file " + filename + " does not list any groups"))
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	g := NewWithT(t)
+	done := make(chan bool)
+	defer close(done)
+
+	reloaded := make(chan bool, 1)
+	ReloadOnSignal(done, func() { reloaded <- true })
+
+	g.Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+	g.Eventually(reloaded, 5*time.Second).Should(Receive())
+}
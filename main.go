@@ -9,6 +9,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	proxyhttp "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/http"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
 	"github.com/spf13/pflag"
@@ -26,6 +27,7 @@ func main() {
 	config := configFlagSet.String("config", "", "path to config file")
 	alphaConfig := configFlagSet.String("alpha-config", "", "path to alpha config file (use at your own risk - the structure in this config file may change between minor releases)")
 	convertConfig := configFlagSet.Bool("convert-config-to-alpha", false, "if true, the proxy will load configuration as normal and convert existing configuration to the alpha config structure, and print it to stdout")
+	validateConfig := configFlagSet.Bool("validate-config", false, "if true, the proxy will load and validate configuration as normal, reporting any problems, and exit without starting the server")
 	showVersion := configFlagSet.Bool("version", false, "print version string")
 	configFlagSet.Parse(os.Args[1:])
 
@@ -38,6 +40,10 @@ func main() {
 		logger.Fatal("cannot use alpha-config and conver-config-to-alpha together")
 	}
 
+	if *convertConfig && *validateConfig {
+		logger.Fatal("cannot use convert-config-to-alpha and validate-config together")
+	}
+
 	opts, err := loadConfiguration(*config, *alphaConfig, configFlagSet, os.Args[1:])
 	if err != nil {
 		logger.Fatalf("ERROR: %v", err)
@@ -50,6 +56,14 @@ func main() {
 		return
 	}
 
+	if *validateConfig {
+		if err := validateConfiguration(opts); err != nil {
+			logger.Fatalf("%s", err)
+		}
+		fmt.Println("configuration is valid")
+		return
+	}
+
 	if err = validation.Validate(opts); err != nil {
 		logger.Fatalf("%s", err)
 	}
@@ -67,6 +81,30 @@ func main() {
 	}
 }
 
+// validateConfiguration validates the options and then builds the proxy, so
+// that problems found while building the proxy are reported too.
+// The server is not set up, so the server addresses are not bound, but the
+// TLS configuration of the servers is parsed.
+func validateConfiguration(opts *options.Options) error {
+	if err := validation.Validate(opts); err != nil {
+		return err
+	}
+	if err := proxyhttp.ValidateTLS(proxyhttp.Opts{SecureBindAddress: opts.Server.SecureBindAddress, TLS: opts.Server.TLS}); err != nil {
+		return fmt.Errorf("invalid app server TLS configuration: %v", err)
+	}
+	if err := proxyhttp.ValidateTLS(proxyhttp.Opts{SecureBindAddress: opts.MetricsServer.SecureBindAddress, TLS: opts.MetricsServer.TLS}); err != nil {
+		return fmt.Errorf("invalid metrics server TLS configuration: %v", err)
+	}
+
+	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
+	oauthproxy, err := newOAuthProxy(opts, validator)
+	if err != nil {
+		return fmt.Errorf("ERROR: Failed to initialise OAuth2 Proxy: %v", err)
+	}
+	oauthproxy.close()
+	return nil
+}
+
 // loadConfiguration will load in the user's configuration.
 // It will either load the alpha configuration (if alphaConfig is given)
 // or the legacy configuration.
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"time"
 
@@ -240,3 +241,45 @@ redirect_url="http://localhost:4180/oauth2/callback"
 		}),
 	)
 })
+
+var _ = Describe("Configuration Validation Suite", func() {
+	It("accepts valid configuration without binding the server address", func() {
+		// The address is already in use, so the proxy could not be started
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		opts := baseTestOptions()
+		opts.Server.BindAddress = listener.Addr().String()
+		Expect(validateConfiguration(opts)).To(Succeed())
+	})
+
+	It("rejects configuration that fails validation", func() {
+		opts := baseTestOptions()
+		opts.Cookie.Secret = ""
+
+		err := validateConfiguration(opts)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("missing setting: cookie-secret"))
+	})
+
+	It("rejects an invalid TLS configuration", func() {
+		opts := baseTestOptions()
+		opts.Server.SecureBindAddress = "127.0.0.1:0"
+		opts.Server.TLS = &options.TLS{
+			MinVersion:   "TLS1.2",
+			CipherSuites: []string{"TLS_RSA_WITH_RC4_128_MD5"},
+		}
+
+		err := validateConfiguration(opts)
+		Expect(err).To(MatchError(`invalid app server TLS configuration: unknown TLS cipher suite "TLS_RSA_WITH_RC4_128_MD5"`))
+	})
+
+	It("rejects configuration that fails when the proxy is built", func() {
+		opts := baseTestOptions()
+		opts.HtpasswdFile = "/does/not/exist/htpasswd"
+
+		err := validateConfiguration(opts)
+		Expect(err).To(MatchError(ContainSubstring("could not load htpasswdfile")))
+	})
+})
@@ -5,13 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,8 +26,11 @@ import (
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/redirect"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/replay"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/upstreamtoken"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/basic"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/header"
 	proxyhttp "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/http"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
@@ -32,6 +38,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 )
@@ -48,6 +55,26 @@ const (
 	oauthCallbackPath = "/callback"
 	authOnlyPath      = "/auth"
 	userInfoPath      = "/userinfo"
+	debugHeadersPath  = "/debug/headers"
+
+	upstreamTokenPath     = "/upstream_token"
+	upstreamTokenJWKSPath = "/upstream_token/jwks"
+
+	// redactedHeaderValue replaces the values of headers containing tokens or
+	// secrets on the debug headers endpoint
+	redactedHeaderValue = "[REDACTED]"
+
+	// loginRetryParam carries the number of retries of the login flow to the
+	// start of the login
+	loginRetryParam = "login_retry"
+
+	// stateRetriesSeparator separates the number of retries of the login flow
+	// and the step-up method from the nonce in unsigned OAuth states
+	stateRetriesSeparator = "."
+
+	// stepUpAnyMethod checks the step-up routes for every request method,
+	// when the method of the request is not known
+	stepUpAnyMethod = "*"
 )
 
 var (
@@ -56,6 +83,15 @@ var (
 
 	// ErrAccessDenied means the user should receive a 401 Unauthorized response
 	ErrAccessDenied = errors.New("access denied")
+
+	// ErrNeedsStepUp means the user should be redirected to authenticate
+	// again with a stronger method
+	ErrNeedsStepUp = errors.New("redirect to step-up login")
+
+	// ErrUnknownStepUpRequest means the request being authenticated by the
+	// auth endpoint isn't known, so it can't be checked against the step-up
+	// routes and the user should receive a 403 Forbidden response
+	ErrUnknownStepUpRequest = errors.New("unknown request to check step-up routes for")
 )
 
 // allowedRoute manages method + path based allowlists
@@ -64,6 +100,21 @@ type allowedRoute struct {
 	pathRegex *regexp.Regexp
 }
 
+// stepUpRoute requires requests matching the method + path to be
+// authenticated with one of the authentication methods
+type stepUpRoute struct {
+	method    string
+	pathRegex *regexp.Regexp
+	amr       []string
+}
+
+// landingRedirect is the page users in the group are redirected to after
+// login when no redirect is requested
+type landingRedirect struct {
+	group    string
+	redirect string
+}
+
 // OAuthProxy is the main authentication proxy
 type OAuthProxy struct {
 	CookieOptions *options.Cookie
@@ -72,6 +123,11 @@ type OAuthProxy struct {
 	SignInPath string
 
 	allowedRoutes       []allowedRoute
+	stepUpRoutes        []stepUpRoute
+	stepUpAcrValues     string
+	stepUpPrompt        string
+	landingRedirects    []landingRedirect
+	normalizeSlashes    bool
 	redirectURL         *url.URL // the url to receive requests at
 	whitelistDomains    []string
 	provider            providers.Provider
@@ -80,23 +136,57 @@ type OAuthProxy struct {
 	basicAuthValidator  basic.Validator
 	SkipProviderButton  bool
 	skipAuthPreflight   bool
+	headRequestHandling string
 	skipJwtBearerTokens bool
 	realClientIPParser  ipapi.RealClientIPParser
 	trustedIPs          *ip.NetSet
-
-	sessionChain      alice.Chain
-	headersChain      alice.Chain
-	preAuthChain      alice.Chain
-	pageWriter        pagewriter.Writer
-	server            proxyhttp.Server
-	upstreamProxy     http.Handler
-	serveMux          *mux.Router
-	redirectValidator redirect.Validator
-	appDirector       redirect.AppDirector
+	sessionBinder       *middleware.SessionBinder
+	claimTransformer    *middleware.ClaimTransformer
+	featureFlags        *middleware.FeatureFlagEvaluator
+
+	sessionChain       alice.Chain
+	headersChain       alice.Chain
+	authOnlyChain      alice.Chain
+	preAuthChain       alice.Chain
+	pageWriter         pagewriter.Writer
+	server             proxyhttp.Server
+	upstreamProxy      http.Handler
+	upstreamCloser     io.Closer
+	serveMux           *mux.Router
+	redirectValidator  redirect.Validator
+	tenantClaim        string
+	appDirector        redirect.AppDirector
+	postLogoutRedirect string
+	upstreamTokens     *upstreamtoken.Minter
+	requestStash       *replay.Stash
+	debugHeaders       *debugHeaders
+	metricsPath        string
+	metricsHandler     http.Handler
+	oauthState         options.OAuthState
+	providerID         string
+
+	providerErrorMessages     map[string]string
+	providerErrorRetryPrompts map[string]string
+	loginRetryLimit           int
 }
 
 // NewOAuthProxy creates a new instance of OAuthProxy from the options provided
 func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthProxy, error) {
+	p, err := newOAuthProxy(opts, validator)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.setupServer(opts); err != nil {
+		return nil, fmt.Errorf("error setting up server: %v", err)
+	}
+
+	return p, nil
+}
+
+// newOAuthProxy creates the OAuthProxy without setting up its server, so
+// that the server addresses are not bound
+func newOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthProxy, error) {
 	sessionStore, err := sessions.NewSessionStore(&opts.Session, &opts.Cookie)
 	if err != nil {
 		return nil, fmt.Errorf("error initialising session store: %v", err)
@@ -113,24 +203,30 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 	}
 
 	pageWriter, err := pagewriter.NewWriter(pagewriter.Opts{
-		TemplatesPath:    opts.Templates.Path,
-		CustomLogo:       opts.Templates.CustomLogo,
-		ProxyPrefix:      opts.ProxyPrefix,
-		Footer:           opts.Templates.Footer,
-		Version:          VERSION,
-		Debug:            opts.Templates.Debug,
-		ProviderName:     buildProviderName(opts.GetProvider(), opts.Providers[0].Name),
-		SignInMessage:    buildSignInMessage(opts),
-		DisplayLoginForm: basicAuthValidator != nil && opts.Templates.DisplayLoginForm,
+		TemplatesPath:             opts.Templates.Path,
+		UpstreamErrorTemplatePath: opts.Templates.UpstreamErrorPath,
+		CustomLogo:                opts.Templates.CustomLogo,
+		ProxyPrefix:               opts.ProxyPrefix,
+		Footer:                    opts.Templates.Footer,
+		Version:                   VERSION,
+		Debug:                     opts.Templates.Debug,
+		ProviderName:              buildProviderName(opts.GetProvider(), opts.Providers[0].Name),
+		SignInMessage:             buildSignInMessage(opts),
+		DisplayLoginForm:          basicAuthValidator != nil && opts.Templates.DisplayLoginForm,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error initialising page writer: %v", err)
 	}
 
-	upstreamProxy, err := upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), pageWriter)
+	upstreamProxy, err := upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), pageWriter, opts.TrailingSlashPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("error initialising upstream proxy: %v", err)
 	}
+	// The upstream proxy is closed to stop the health checks of the upstreams
+	upstreamCloser, _ := upstreamProxy.(io.Closer)
+	if opts.Compression.Enabled {
+		upstreamProxy = middleware.NewCompression(opts.Compression)(upstreamProxy)
+	}
 
 	if opts.SkipJwtBearerTokens {
 		logger.Printf("Skipping JWT tokens from configured OIDC issuer: %q", opts.Providers[0].OIDCConfig.IssuerURL)
@@ -165,17 +261,75 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		return nil, err
 	}
 
+	stepUpRoutes, err := buildStepUpRoutes(opts.StepUp)
+	if err != nil {
+		return nil, err
+	}
+
 	preAuthChain, err := buildPreAuthChain(opts)
 	if err != nil {
 		return nil, fmt.Errorf("could not build pre-auth chain: %v", err)
 	}
-	sessionChain := buildSessionChain(opts, sessionStore, basicAuthValidator)
-	headersChain, err := buildHeadersChain(opts)
+	sessionBinder := middleware.NewSessionBinder(opts.Session, opts.Cookie.Secret, opts.GetRealClientIPParser())
+	claimTransformer, err := middleware.NewClaimTransformer(opts.ClaimTransformations)
+	if err != nil {
+		return nil, fmt.Errorf("could not build claim transformer: %v", err)
+	}
+	featureFlags, err := middleware.NewFeatureFlagEvaluator(opts.FeatureFlags)
+	if err != nil {
+		return nil, fmt.Errorf("could not build feature flag evaluator: %v", err)
+	}
+	sessionChain := buildSessionChain(opts, sessionStore, sessionBinder, claimTransformer, featureFlags, basicAuthValidator)
+	headersChain, err := buildHeadersChain(opts, pageWriter)
 	if err != nil {
 		return nil, fmt.Errorf("could not build headers chain: %v", err)
 	}
+	authOnlyChain, err := buildAuthOnlyChain(opts, headersChain)
+	if err != nil {
+		return nil, fmt.Errorf("could not build auth only chain: %v", err)
+	}
+
+	redirectValidator, err := buildRedirectValidator(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Checked against the same validator as the sign out redirect so the
+	// whitelist domains file and tenant redirects are taken into account.
+	if opts.PostLogoutRedirectURL != "" && !redirectValidator.IsValidRedirect(opts.PostLogoutRedirectURL) {
+		return nil, fmt.Errorf("post_logout_redirect_url %q is not a valid redirect: absolute URLs must be within a whitelist domain or a tenant's redirect domains", opts.PostLogoutRedirectURL)
+	}
+	if err := watchAllowedGroupsFile(opts); err != nil {
+		return nil, err
+	}
+
+	var upstreamTokens *upstreamtoken.Minter
+	if opts.UpstreamToken.KeyFile != "" {
+		upstreamTokens, err = upstreamtoken.NewMinter(opts.UpstreamToken, opts.Cookie.Expire)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialise upstream tokens: %v", err)
+		}
+	}
+
+	debug, err := buildDebugHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var metricsHandler http.Handler
+	if opts.Metrics.Path != "" {
+		metricsHandler = buildMetricsHandler(opts.Metrics)
+	}
+
+	var requestStash *replay.Stash
+	if opts.RequestReplay.Enabled {
+		// Requests are stashed alongside the sessions in the persistent store
+		manager, ok := sessionStore.(*persistence.Manager)
+		if !ok {
+			return nil, errors.New("replaying POST requests requires a persistent session store")
+		}
+		requestStash = replay.NewStash(manager.Store, opts.RequestReplay, &opts.Cookie)
+	}
 
-	redirectValidator := redirect.NewValidator(opts.WhitelistDomains)
 	appDirector := redirect.NewAppDirector(redirect.AppDirectorOpts{
 		ProxyPrefix: opts.ProxyPrefix,
 		Validator:   redirectValidator,
@@ -192,27 +346,47 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		sessionStore:        sessionStore,
 		redirectURL:         redirectURL,
 		allowedRoutes:       allowedRoutes,
+		stepUpRoutes:        stepUpRoutes,
+		stepUpAcrValues:     opts.StepUp.AcrValues,
+		stepUpPrompt:        opts.StepUp.Prompt,
+		landingRedirects:    buildLandingRedirects(opts.LandingRedirects),
+		normalizeSlashes:    opts.TrailingSlashPolicy == options.TrailingSlashNormalize,
 		whitelistDomains:    opts.WhitelistDomains,
 		skipAuthPreflight:   opts.SkipAuthPreflight,
+		headRequestHandling: opts.HeadRequestHandling,
+		oauthState:          opts.OAuthState,
+		providerID:          opts.Providers[0].ID,
 		skipJwtBearerTokens: opts.SkipJwtBearerTokens,
 		realClientIPParser:  opts.GetRealClientIPParser(),
 		SkipProviderButton:  opts.SkipProviderButton,
 		trustedIPs:          trustedIPs,
+		sessionBinder:       sessionBinder,
+		claimTransformer:    claimTransformer,
+		featureFlags:        featureFlags,
 
 		basicAuthValidator: basicAuthValidator,
 		sessionChain:       sessionChain,
 		headersChain:       headersChain,
+		authOnlyChain:      authOnlyChain,
 		preAuthChain:       preAuthChain,
 		pageWriter:         pageWriter,
 		upstreamProxy:      upstreamProxy,
+		upstreamCloser:     upstreamCloser,
 		redirectValidator:  redirectValidator,
+		tenantClaim:        opts.TenantRedirectClaim,
 		appDirector:        appDirector,
-	}
-	p.buildServeMux(opts.ProxyPrefix)
+		postLogoutRedirect: opts.PostLogoutRedirectURL,
+		upstreamTokens:     upstreamTokens,
+		requestStash:       requestStash,
+		debugHeaders:       debug,
+		metricsPath:        opts.Metrics.Path,
+		metricsHandler:     metricsHandler,
 
-	if err := p.setupServer(opts); err != nil {
-		return nil, fmt.Errorf("error setting up server: %v", err)
+		providerErrorMessages:     buildProviderErrorMapping(opts.ProviderErrorMessages),
+		providerErrorRetryPrompts: buildProviderErrorMapping(opts.ProviderErrorRetryPrompts),
+		loginRetryLimit:           opts.LoginRetryLimit,
 	}
+	p.buildServeMux(opts.ProxyPrefix)
 
 	return p, nil
 }
@@ -234,15 +408,36 @@ func (p *OAuthProxy) Start() error {
 		cancel() // cancel the context
 	}()
 
-	return p.server.Start(ctx)
+	err := p.server.Start(ctx)
+
+	// The servers have drained, so the upstreams and session store are no
+	// longer needed
+	p.close()
+	return err
+}
+
+// close stops the health checks of the upstreams and closes the session store
+func (p *OAuthProxy) close() {
+	if p.upstreamCloser != nil {
+		if err := p.upstreamCloser.Close(); err != nil {
+			logger.Errorf("Error closing the upstream proxy: %v", err)
+		}
+	}
+	if closer, ok := p.sessionStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Errorf("Error closing the session store: %v", err)
+		}
+	}
 }
 
 func (p *OAuthProxy) setupServer(opts *options.Options) error {
 	serverOpts := proxyhttp.Opts{
-		Handler:           p,
-		BindAddress:       opts.Server.BindAddress,
-		SecureBindAddress: opts.Server.SecureBindAddress,
-		TLS:               opts.Server.TLS,
+		Handler:                 p,
+		BindAddress:             opts.Server.BindAddress,
+		SecureBindAddress:       opts.Server.SecureBindAddress,
+		TLS:                     opts.Server.TLS,
+		ShutdownTimeout:         opts.Shutdown.Timeout,
+		ShutdownWebSocketPolicy: opts.Shutdown.WebSocketPolicy,
 	}
 
 	appServer, err := proxyhttp.NewServer(serverOpts)
@@ -251,10 +446,12 @@ func (p *OAuthProxy) setupServer(opts *options.Options) error {
 	}
 
 	metricsServer, err := proxyhttp.NewServer(proxyhttp.Opts{
-		Handler:           middleware.DefaultMetricsHandler,
-		BindAddress:       opts.MetricsServer.BindAddress,
-		SecureBindAddress: opts.MetricsServer.SecureBindAddress,
-		TLS:               opts.MetricsServer.TLS,
+		Handler:                 middleware.DefaultMetricsHandler,
+		BindAddress:             opts.MetricsServer.BindAddress,
+		SecureBindAddress:       opts.MetricsServer.SecureBindAddress,
+		TLS:                     opts.MetricsServer.TLS,
+		ShutdownTimeout:         opts.Shutdown.Timeout,
+		ShutdownWebSocketPolicy: opts.Shutdown.WebSocketPolicy,
 	})
 	if err != nil {
 		return fmt.Errorf("could not build metrics server: %v", err)
@@ -272,6 +469,12 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 	// Register the robots path writer
 	r.Path(robotsPath).HandlerFunc(p.pageWriter.WriteRobotsTxt)
 
+	// Metrics are read by scrapers without a session, so they are served
+	// before any route that could start the OAuth flow
+	if p.metricsHandler != nil {
+		r.Path(p.metricsPath).Handler(p.metricsHandler)
+	}
+
 	// The authonly path should be registered separately to prevent it from getting no-cache headers.
 	// We do this to allow users to have a short cache (via nginx) of the response to reduce the
 	// likelihood of multiple reuests trying to referesh sessions simultaneously.
@@ -297,6 +500,28 @@ func (p *OAuthProxy) buildProxySubrouter(s *mux.Router) {
 
 	// The userinfo endpoint needs to load sessions before handling the request
 	s.Path(userInfoPath).Handler(p.sessionChain.ThenFunc(p.UserInfo))
+
+	if p.debugHeaders != nil {
+		// The debug headers endpoint needs to load sessions before handling the request
+		s.Path(debugHeadersPath).Methods(http.MethodGet).Handler(p.sessionChain.ThenFunc(p.DebugHeaders))
+	}
+
+	if p.upstreamTokens != nil {
+		// The upstream token endpoint needs to load sessions before handling the
+		// request, the key set is public so it can be fetched by any API
+		s.Path(upstreamTokenPath).Methods(http.MethodPost).Handler(p.sessionChain.ThenFunc(p.UpstreamToken))
+		s.Path(upstreamTokenJWKSPath).Methods(http.MethodGet).HandlerFunc(p.UpstreamTokenJWKS)
+	}
+}
+
+// buildMetricsHandler builds the handler for the metrics served on the main
+// server, protected by the bearer token if one is configured
+func buildMetricsHandler(opts options.Metrics) http.Handler {
+	if opts.BearerToken == "" {
+		logger.Printf("WARNING: metrics are served on %s without a bearer token, so they can be read by any client", opts.Path)
+		return middleware.DefaultMetricsHandler
+	}
+	return middleware.NewMetricsTokenAuth(opts.BearerToken)(middleware.DefaultMetricsHandler)
 }
 
 // buildPreAuthChain constructs a chain that should process every request before
@@ -337,10 +562,90 @@ func buildPreAuthChain(opts *options.Options) (alice.Chain, error) {
 
 	chain = chain.Append(middleware.NewRequestMetricsWithDefaultRegistry())
 
+	// The IP filter is registered after the health check handler so that load
+	// balancers outside of the allowlist can still check the proxy is healthy
+	if len(opts.IPFilter.Allowlist) > 0 || len(opts.IPFilter.Denylist) > 0 {
+		ipFilter, err := buildIPFilter(opts)
+		if err != nil {
+			return alice.Chain{}, err
+		}
+		chain = chain.Append(ipFilter)
+	}
+
+	if opts.ServerTiming.Enabled || opts.ServerTiming.RequestHeader != "" {
+		serverTimingEnabled, err := buildServerTimingEnabled(opts)
+		if err != nil {
+			return alice.Chain{}, err
+		}
+		chain = chain.Append(middleware.NewServerTiming(serverTimingEnabled))
+	}
+
 	return chain, nil
 }
 
-func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionStore, validator basic.Validator) alice.Chain {
+// buildIPFilter constructs the middleware that denies requests from clients
+// outside of the IP allowlist, or within the IP denylist.
+func buildIPFilter(opts *options.Options) (alice.Constructor, error) {
+	var allowlist, denylist *ip.NetSet
+	var err error
+	if len(opts.IPFilter.Allowlist) > 0 {
+		allowlist, err = parseNetSet("IP allowlist", opts.IPFilter.Allowlist)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.IPFilter.Denylist) > 0 {
+		denylist, err = parseNetSet("IP denylist", opts.IPFilter.Denylist)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return middleware.NewIPFilter(allowlist, denylist, opts.GetRealClientIPParser()), nil
+}
+
+// buildServerTimingEnabled determines which requests should receive the
+// Server-Timing header. Either all requests, when enabled, or requests from
+// trusted clients that send the configured request header.
+func buildServerTimingEnabled(opts *options.Options) (func(*http.Request) bool, error) {
+	if opts.ServerTiming.Enabled {
+		return func(*http.Request) bool { return true }, nil
+	}
+
+	trustedIPs, err := parseNetSet("server timing IP", opts.ServerTiming.TrustedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	realClientIPParser := opts.GetRealClientIPParser()
+	return func(req *http.Request) bool {
+		if req.Header.Get(opts.ServerTiming.RequestHeader) == "" {
+			return false
+		}
+		remoteAddr, err := ip.GetClientIP(realClientIPParser, req)
+		if err != nil {
+			logger.Errorf("Error obtaining real IP for server timing: %v", err)
+			return false
+		}
+		return remoteAddr != nil && trustedIPs.Has(remoteAddr)
+	}, nil
+}
+
+// parseNetSet parses the IP addresses and networks of the list into an
+// ip.NetSet. The name of the list is used in the error for an invalid entry.
+func parseNetSet(name string, list []string) (*ip.NetSet, error) {
+	netSet := ip.NewNetSet()
+	for _, ipStr := range list {
+		ipNet := ip.ParseIPNet(ipStr)
+		if ipNet == nil {
+			return nil, fmt.Errorf("could not parse %s network (%s)", name, ipStr)
+		}
+		netSet.AddIPNet(*ipNet)
+	}
+	return netSet, nil
+}
+
+func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionStore, sessionBinder *middleware.SessionBinder, claimTransformer *middleware.ClaimTransformer, featureFlags *middleware.FeatureFlagEvaluator, validator basic.Validator) alice.Chain {
 	chain := alice.New()
 
 	if opts.SkipJwtBearerTokens {
@@ -360,18 +665,31 @@ func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 		chain = chain.Append(middleware.NewBasicAuthSessionLoader(validator, opts.HtpasswdUserGroups, opts.LegacyPreferEmailToUser))
 	}
 
+	// Sessions are checked for revocation by refreshing them, unless they are
+	// introspected
+	var checkRevocation func(context.Context, *sessionsapi.SessionState) (bool, error)
+	if opts.Session.RevocationCheckMethod == options.IntrospectionRevocationMethod {
+		checkRevocation = opts.GetProvider().Data().IntrospectRefreshToken
+	}
+
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
-		SessionStore:    sessionStore,
-		RefreshPeriod:   opts.Cookie.Refresh,
-		RefreshSession:  opts.GetProvider().RefreshSession,
-		ValidateSession: opts.GetProvider().ValidateSession,
+		SessionStore:            sessionStore,
+		RefreshPeriod:           opts.Cookie.Refresh,
+		RefreshSession:          opts.GetProvider().RefreshSession,
+		ValidateSession:         opts.GetProvider().ValidateSession,
+		RefreshDedupTTL:         opts.Session.RefreshDedupTTL,
+		SessionBinder:           sessionBinder,
+		ClaimTransformer:        claimTransformer,
+		FeatureFlags:            featureFlags,
+		RevocationCheckInterval: opts.Session.RevocationCheckInterval,
+		CheckRevocation:         checkRevocation,
 	}))
 
 	return chain
 }
 
-func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
-	requestInjector, err := middleware.NewRequestHeaderInjector(opts.InjectRequestHeaders)
+func buildHeadersChain(opts *options.Options, writer pagewriter.Writer) (alice.Chain, error) {
+	requestInjector, err := middleware.NewRequestHeaderInjector(opts.InjectRequestHeaders, opts.SkipHeaderInjectRegex, opts.HeaderSize, writer)
 	if err != nil {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
 	}
@@ -381,7 +699,89 @@ func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
 	}
 
-	return alice.New(requestInjector, responseInjector), nil
+	chain := alice.New(requestInjector, responseInjector)
+	if opts.Session.DegradedHeader != "" {
+		chain = chain.Append(middleware.NewDegradedSessionHeaderInjector(opts.Session.DegradedHeader))
+	}
+	return chain, nil
+}
+
+// buildAuthOnlyChain builds the chain that injects the headers into the
+// responses of the auth endpoint. The configured auth response headers
+// replace the injected response headers, otherwise the same headers are
+// injected as for proxied requests. The degraded session header is injected
+// either way.
+func buildAuthOnlyChain(opts *options.Options, headersChain alice.Chain) (alice.Chain, error) {
+	if len(opts.AuthResponseHeaders) == 0 {
+		return headersChain, nil
+	}
+
+	responseInjector, err := middleware.NewResponseHeaderInjector(opts.AuthResponseHeaders)
+	if err != nil {
+		return alice.Chain{}, fmt.Errorf("error constructing auth response header injector: %v", err)
+	}
+
+	chain := alice.New(responseInjector)
+	if opts.Session.DegradedHeader != "" {
+		chain = chain.Append(middleware.NewDegradedSessionHeaderInjector(opts.Session.DegradedHeader))
+	}
+	return chain, nil
+}
+
+// debugHeaders holds what is needed to show users the headers injected into
+// their requests to the upstream
+type debugHeaders struct {
+	injector   header.Injector
+	redacted   map[string]struct{}
+	showTokens bool
+}
+
+// buildDebugHeaders builds the injector for the debug headers endpoint, and
+// works out which of the injected headers contain tokens or secrets.
+// If the endpoint is not enabled, nil is returned.
+func buildDebugHeaders(opts *options.Options) (*debugHeaders, error) {
+	if !opts.Debug.HeadersEndpoint {
+		return nil, nil
+	}
+
+	injector, err := header.NewInjector(opts.InjectRequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing debug headers injector: %v", err)
+	}
+
+	redacted := make(map[string]struct{})
+	for _, h := range opts.InjectRequestHeaders {
+		if isSecretHeader(h) {
+			redacted[http.CanonicalHeaderKey(h.Name)] = struct{}{}
+		}
+	}
+
+	return &debugHeaders{
+		injector:   injector,
+		redacted:   redacted,
+		showTokens: opts.Debug.ShowTokens,
+	}, nil
+}
+
+// isSecretHeader reports whether any of the header's values come from a
+// token or a secret
+func isSecretHeader(h options.Header) bool {
+	for _, value := range h.Values {
+		if value.SecretSource != nil {
+			return true
+		}
+		if value.ClaimSource == nil {
+			continue
+		}
+		if value.ClaimSource.BasicAuthPassword != nil {
+			return true
+		}
+		switch value.ClaimSource.Claim {
+		case "access_token", "id_token", "refresh_token":
+			return true
+		}
+	}
+	return false
 }
 
 func buildSignInMessage(opts *options.Options) string {
@@ -409,6 +809,197 @@ func buildProviderName(p providers.Provider, override string) string {
 	return p.Data().ProviderName
 }
 
+// buildRedirectValidator builds the redirect validator from the whitelist
+// domains, including any listed in the WhitelistDomainsFile, and the domains
+// of each tenant listed in the TenantRedirectsFile
+func buildRedirectValidator(opts *options.Options) (redirect.Validator, error) {
+	allowedDomains := func() []string { return opts.WhitelistDomains }
+	if opts.WhitelistDomainsFile != "" {
+		logger.Printf("using whitelist domains file %s", opts.WhitelistDomainsFile)
+		domainsFile, err := NewAllowlistFile(opts.WhitelistDomainsFile, nil, func([]string) {})
+		if err != nil {
+			return nil, fmt.Errorf("could not load whitelist domains file: %v", err)
+		}
+		allowedDomains = func() []string {
+			return combineAllowlists(opts.WhitelistDomains, domainsFile.Entries())
+		}
+	}
+
+	if opts.TenantRedirectsFile == "" {
+		return redirect.NewDynamicValidator(allowedDomains), nil
+	}
+
+	logger.Printf("using tenant redirects file %s", opts.TenantRedirectsFile)
+	var tenantDomains atomic.Value
+	tenantsFile, err := NewAllowlistFile(opts.TenantRedirectsFile, nil, func(entries []string) {
+		tenantDomains.Store(parseTenantRedirects(entries))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load tenant redirects file: %v", err)
+	}
+	tenantDomains.Store(parseTenantRedirects(tenantsFile.Entries()))
+
+	var tenantFromRequest func(*http.Request) string
+	if opts.TenantRedirectClaim == "" {
+		tenantFromRequest = requestTenant
+	}
+	return redirect.NewTenantValidator(redirect.TenantValidatorOpts{
+		AllowedDomains: allowedDomains,
+		TenantDomains: func() map[string][]string {
+			return tenantDomains.Load().(map[string][]string)
+		},
+		TenantFromRequest: tenantFromRequest,
+	}), nil
+}
+
+// parseTenantRedirects parses the entries of the TenantRedirectsFile, which
+// each list a tenant followed by the domains allowed for it
+func parseTenantRedirects(entries []string) map[string][]string {
+	tenants := make(map[string][]string)
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			logger.Errorf("ignoring tenant %q in tenant redirects file: no allowed domains given", fields[0])
+			continue
+		}
+		tenants[fields[0]] = append(tenants[fields[0]], fields[1:]...)
+	}
+	return tenants
+}
+
+// requestTenant identifies the tenant of the request by its host, without
+// the port
+func requestTenant(req *http.Request) string {
+	host := requestutil.GetRequestHost(req)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// getAppRedirect determines the redirect to return users to after login.
+// With landing redirects, only a redirect requested by the client is used, so
+// that the landing redirect can be chosen for the user's groups after login.
+func (p *OAuthProxy) getAppRedirect(req *http.Request) (string, error) {
+	if len(p.landingRedirects) > 0 {
+		return p.appDirector.GetRequestedRedirect(req)
+	}
+	return p.appDirector.GetRedirect(req)
+}
+
+// getLandingRedirect returns the first valid landing redirect for the groups
+// of the session, or `/` when none of them match.
+func (p *OAuthProxy) getLandingRedirect(validator redirect.Validator, session *sessionsapi.SessionState) string {
+	for _, landing := range p.landingRedirects {
+		if !hasGroup(session, landing.group) {
+			continue
+		}
+		if validator.IsValidRedirect(landing.redirect) {
+			return landing.redirect
+		}
+		logger.Errorf("Invalid landing redirect for group %s: %s", landing.group, landing.redirect)
+	}
+	return "/"
+}
+
+// hasGroup checks whether the session is a member of the group
+func hasGroup(session *sessionsapi.SessionState, group string) bool {
+	for _, sessionGroup := range session.Groups {
+		if sessionGroup == group {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectValidatorFor returns the validator for the redirects of a request.
+// With a TenantRedirectsFile, this validates against the domains of the
+// tenant of the request, or of the session when tenants are identified by a
+// claim. The session may be nil before the user has logged in, in which case
+// the redirect is validated against the domains of every tenant, and must be
+// validated again once the session is known.
+func (p *OAuthProxy) redirectValidatorFor(req *http.Request, session *sessionsapi.SessionState) redirect.Validator {
+	tenantValidator, ok := p.redirectValidator.(*redirect.TenantValidator)
+	if !ok {
+		return p.redirectValidator
+	}
+	if p.tenantClaim == "" || session == nil {
+		return tenantValidator.ForRequest(req)
+	}
+	return tenantValidator.ForTenant(p.sessionTenant(session))
+}
+
+// sessionTenant returns the tenant of a session from the TenantRedirectClaim
+func (p *OAuthProxy) sessionTenant(session *sessionsapi.SessionState) string {
+	if session == nil {
+		return ""
+	}
+	if values := session.GetClaim(p.tenantClaim); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// signOutRedirect returns the redirect after signing out, or the
+// PostLogoutRedirectURL when none is requested.
+// With tenants identified by a claim, there is no login to validate the
+// redirect again after, so it is validated against the domains of the tenant
+// of the session being signed out, or without a session, only the domains
+// allowed for every tenant.
+func (p *OAuthProxy) signOutRedirect(req *http.Request) (string, error) {
+	validator := p.redirectValidatorFor(req, nil)
+	if tenantValidator, ok := p.redirectValidator.(*redirect.TenantValidator); ok && p.tenantClaim != "" {
+		session, err := p.LoadCookiedSession(req)
+		if err != nil {
+			session = nil
+		}
+		validator = tenantValidator.ForTenant(p.sessionTenant(session))
+	}
+
+	return redirect.NewAppDirector(redirect.AppDirectorOpts{
+		ProxyPrefix:     p.ProxyPrefix,
+		Validator:       validator,
+		DefaultRedirect: p.postLogoutRedirect,
+	}).GetRedirect(req)
+}
+
+// watchAllowedGroupsFile adds the groups listed in the AllowedGroupsFile to
+// the allowed groups of the provider, and updates them whenever the file
+// is reloaded
+func watchAllowedGroupsFile(opts *options.Options) error {
+	filename := opts.Providers[0].AllowedGroupsFile
+	if filename == "" {
+		return nil
+	}
+
+	logger.Printf("using allowed groups file %s", filename)
+	providerData := opts.GetProvider().Data()
+	updateGroups := allowedGroupsUpdater(providerData, opts.Providers[0].AllowedGroups, filename)
+	groupsFile, err := NewAllowlistFile(filename, nil, updateGroups)
+	if err != nil {
+		return fmt.Errorf("could not load allowed groups file: %v", err)
+	}
+	if len(groupsFile.Entries()) == 0 {
+		return fmt.Errorf("allowed groups file %s does not list any groups", filename)
+	}
+	updateGroups(groupsFile.Entries())
+	return nil
+}
+
+// allowedGroupsUpdater returns the function that sets the allowed groups of
+// the provider to the configured groups and the groups from the file.
+// A file without any groups would lift the restriction on groups, eg. when
+// it is truncated while being written, so the previous groups are kept.
+func allowedGroupsUpdater(providerData *providers.ProviderData, configured []string, filename string) func([]string) {
+	return func(groups []string) {
+		if len(groups) == 0 {
+			logger.Errorf("error reloading %s, keeping the previous groups: the file does not list any groups", filename)
+			return
+		}
+		providerData.SetAllowedGroups(combineAllowlists(configured, groups))
+	}
+}
+
 // buildRoutesAllowlist builds an []allowedRoute  list from either the legacy
 // SkipAuthRegex option (paths only support) or newer SkipAuthRoutes option
 // (method=path support)
@@ -456,6 +1047,77 @@ func buildRoutesAllowlist(opts *options.Options) ([]allowedRoute, error) {
 	return routes, nil
 }
 
+// buildStepUpRoutes builds the []stepUpRoute list from the StepUp Routes
+// option (amr1|amr2:method=path support)
+func buildStepUpRoutes(opts options.StepUp) ([]stepUpRoute, error) {
+	routes := make([]stepUpRoute, 0, len(opts.Routes))
+
+	for _, amrRoute := range opts.Routes {
+		parts := strings.SplitN(amrRoute, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("step-up route %q must be in the format amr:method=path_regex or amr:path_regex", amrRoute)
+		}
+		amr := strings.Split(parts[0], "|")
+
+		var (
+			method string
+			path   string
+		)
+		methodPath := strings.SplitN(parts[1], "=", 2)
+		if len(methodPath) == 1 {
+			method = ""
+			path = methodPath[0]
+		} else {
+			method = strings.ToUpper(methodPath[0])
+			path = methodPath[1]
+		}
+
+		compiledRegex, err := regexp.Compile(path)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("Requiring step-up authentication - AMR: %s | Method: %s | Path: %s", strings.Join(amr, ","), method, path)
+		routes = append(routes, stepUpRoute{
+			method:    method,
+			pathRegex: compiledRegex,
+			amr:       amr,
+		})
+	}
+
+	return routes, nil
+}
+
+// buildLandingRedirects builds the []landingRedirect list from the group=path
+// LandingRedirects option
+func buildLandingRedirects(landings []string) []landingRedirect {
+	redirects := make([]landingRedirect, 0, len(landings))
+	for _, landing := range landings {
+		parts := strings.SplitN(landing, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		redirects = append(redirects, landingRedirect{
+			group:    parts[0],
+			redirect: parts[1],
+		})
+	}
+	return redirects
+}
+
+// buildProviderErrorMapping builds a map of provider error codes to values
+// from the error_code=value pairs in the ProviderErrorMessages and
+// ProviderErrorRetryPrompts options
+func buildProviderErrorMapping(pairs []string) map[string]string {
+	mapping := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			mapping[parts[0]] = parts[1]
+		}
+	}
+	return mapping
+}
+
 // ClearSessionCookie creates a cookie to unset the user's authentication cookie
 // stored in the user's session
 func (p *OAuthProxy) ClearSessionCookie(rw http.ResponseWriter, req *http.Request) error {
@@ -469,6 +1131,11 @@ func (p *OAuthProxy) LoadCookiedSession(req *http.Request) (*sessionsapi.Session
 
 // SaveSession creates a new session cookie value and sets this on the response
 func (p *OAuthProxy) SaveSession(rw http.ResponseWriter, req *http.Request, s *sessionsapi.SessionState) error {
+	if p.sessionBinder != nil {
+		if err := p.sessionBinder.Bind(req, s); err != nil {
+			return err
+		}
+	}
 	return p.sessionStore.Save(rw, req, s)
 }
 
@@ -505,13 +1172,27 @@ func (p *OAuthProxy) IsAllowedRequest(req *http.Request) bool {
 // IsAllowedRoute is used to check if the request method & path is allowed without auth
 func (p *OAuthProxy) isAllowedRoute(req *http.Request) bool {
 	for _, route := range p.allowedRoutes {
-		if (route.method == "" || req.Method == route.method) && route.pathRegex.MatchString(req.URL.Path) {
+		if (route.method == "" || req.Method == route.method) && p.matchesPath(route.pathRegex, req.URL.Path) {
 			return true
 		}
 	}
 	return false
 }
 
+// matchesPath checks whether the route's path regex matches the request path.
+// When trailing slashes are normalized, the path also matches if it would
+// with its trailing slash added or removed.
+func (p *OAuthProxy) matchesPath(pathRegex *regexp.Regexp, path string) bool {
+	if pathRegex.MatchString(path) {
+		return true
+	}
+	if !p.normalizeSlashes {
+		return false
+	}
+	toggled, ok := requestutil.ToggleTrailingSlash(path)
+	return ok && pathRegex.MatchString(toggled)
+}
+
 // isTrustedIP is used to check if a request comes from a trusted client IP address.
 func (p *OAuthProxy) isTrustedIP(req *http.Request) bool {
 	if p.trustedIPs == nil {
@@ -543,7 +1224,7 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 	}
 	rw.WriteHeader(code)
 
-	redirectURL, err := p.appDirector.GetRedirect(req)
+	redirectURL, err := p.getAppRedirect(req)
 	if err != nil {
 		logger.Errorf("Error obtaining redirect: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
@@ -588,6 +1269,7 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 	user, ok := p.ManualSignIn(req)
 	if ok {
 		session := &sessionsapi.SessionState{User: user}
+		session.AuthenticatedAtNow()
 		err = p.SaveSession(rw, req, session)
 		if err != nil {
 			logger.Printf("Error saving session: %v", err)
@@ -640,9 +1322,102 @@ func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// DebugHeaders endpoint outputs the headers that are injected into requests
+// to the upstream for the session in JSON format.
+// The values of headers containing tokens or secrets are redacted, unless
+// the endpoint is configured to show them.
+func (p *OAuthProxy) DebugHeaders(rw http.ResponseWriter, req *http.Request) {
+	session, err := p.getAuthenticatedSession(rw, req)
+	if err != nil || session == nil {
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	injected := http.Header{}
+	p.debugHeaders.injector.Inject(injected, session)
+
+	// Headers are flattened when they are injected into requests
+	headers := make(map[string]string, len(injected))
+	for name, values := range injected {
+		headers[name] = strings.Join(values, ",")
+		if _, ok := p.debugHeaders.redacted[name]; ok && !p.debugHeaders.showTokens {
+			headers[name] = redactedHeaderValue
+		}
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(headers); err != nil {
+		logger.Printf("Error encoding debug headers: %v", err)
+	}
+}
+
+// UpstreamToken exchanges the session for a short lived token that can be
+// used to call APIs that are not behind the proxy, without exposing the
+// provider's tokens
+func (p *OAuthProxy) UpstreamToken(rw http.ResponseWriter, req *http.Request) {
+	session, err := p.getAuthenticatedSession(rw, req)
+	if err != nil || session == nil {
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	if !p.upstreamTokens.Allow(session.Email+"|"+session.User, now) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Upstream token rate limit reached")
+		rw.Header().Set("Retry-After", "60")
+		http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	token, expires, err := p.upstreamTokens.Mint(session, now)
+	if errors.Is(err, upstreamtoken.ErrSessionExpired) {
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Error minting upstream token: %v", err)
+		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expires.Sub(now).Seconds()),
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(tokenResponse); err != nil {
+		logger.Printf("Error encoding upstream token: %v", err)
+	}
+}
+
+// UpstreamTokenJWKS serves the public key that upstream tokens are signed
+// with, as a JSON Web Key Set
+func (p *OAuthProxy) UpstreamTokenJWKS(rw http.ResponseWriter, req *http.Request) {
+	jwks, err := p.upstreamTokens.JWKS()
+	if err != nil {
+		logger.Errorf("Error encoding upstream token key set: %v", err)
+		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(jwks); err != nil {
+		logger.Printf("Error writing upstream token key set: %v", err)
+	}
+}
+
 // SignOut sends a response to clear the authentication cookie
 func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
-	redirect, err := p.appDirector.GetRedirect(req)
+	redirect, err := p.signOutRedirect(req)
 	if err != nil {
 		logger.Errorf("Error obtaining redirect: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
@@ -657,40 +1432,251 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 	http.Redirect(rw, req, redirect, http.StatusFound)
 }
 
-// OAuthStart starts the OAuth2 authentication flow
+// OAuthStart starts the OAuth2 authentication flow.
+// Logins that redirect to a step-up route, eg. once the auth endpoint has
+// rejected a session, ask the provider for the stronger methods straight away.
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
+	p.oauthStart(rw, req, false, p.redirectStepUpMethod(req))
+}
+
+// oauthStart starts the OAuth2 authentication flow. When stashRequest is
+// set, a POST request is stashed to be replayed once the login completes.
+// When stepUpMethod is set, the provider is asked to authenticate the user
+// again with the configured step-up acr_values and prompt, and the callback
+// checks the new session against the step-up routes for that request method.
+func (p *OAuthProxy) oauthStart(rw http.ResponseWriter, req *http.Request, stashRequest bool, stepUpMethod string) {
 	prepareNoCache(rw)
 
-	csrf, err := cookies.NewCSRF(p.CookieOptions)
+	csrf, err := cookies.NewCSRF(p.CookieOptions, p.oauthState)
 	if err != nil {
 		logger.Errorf("Error creating CSRF nonce: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	appRedirect, err := p.appDirector.GetRedirect(req)
+	if stashRequest && p.requestStash != nil && req.Method == http.MethodPost {
+		// The login continues without replaying the request if it can't be
+		// stashed. This must happen before the redirect parses the form.
+		if err := p.requestStash.Save(req, csrf.HashOAuthState()); err != nil {
+			logger.Errorf("Error stashing %s request to %s to replay after login: %v", req.Method, req.URL.Path, err)
+		}
+	}
+
+	appRedirect, err := p.getAppRedirect(req)
 	if err != nil {
 		logger.Errorf("Error obtaining application redirect: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	state, err := p.encodeOAuthState(csrf, appRedirect, p.loginRetries(req), stepUpMethod)
+	if err != nil {
+		logger.Errorf("Error encoding OAuth state: %v", err)
+		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	callbackRedirect := p.getOAuthRedirectURI(req)
 	loginURL := p.provider.GetLoginURL(
 		callbackRedirect,
-		encodeState(csrf.HashOAuthState(), appRedirect),
+		state,
 		csrf.HashOIDCNonce(),
 	)
 
-	if _, err := csrf.SetCookie(rw, req); err != nil {
-		logger.Errorf("Error setting CSRF cookie: %v", err)
-		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
-		return
+	// The login flow is being retried after an error from the provider
+	if prompt := req.URL.Query().Get("prompt"); p.isRetryPrompt(prompt) {
+		loginURL = setLoginParams(loginURL, url.Values{"prompt": {prompt}})
+	}
+
+	if stepUpMethod != "" {
+		loginURL = setLoginParams(loginURL, p.stepUpLoginParams())
+	}
+
+	if p.oauthState.Mode != options.OAuthStateSigned {
+		if _, err := csrf.SetCookie(rw, req); err != nil {
+			logger.Errorf("Error setting CSRF cookie: %v", err)
+			p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+			return
+		}
 	}
 
 	http.Redirect(rw, req, loginURL, http.StatusFound)
 }
 
+// providerError handles an error returned by the provider to the OAuth2
+// callback. Configured errors restart the login flow with a different prompt,
+// until the retries counted by the state reach the limit. All others render
+// the error page with a configured message when available.
+func (p *OAuthProxy) providerError(rw http.ResponseWriter, req *http.Request, errorString string) {
+	if description := req.Form.Get("error_description"); description != "" {
+		logger.Errorf("Error while parsing OAuth2 callback: %s: %s", errorString, description)
+	} else {
+		logger.Errorf("Error while parsing OAuth2 callback: %s", errorString)
+	}
+
+	if prompt, ok := p.providerErrorRetryPrompts[errorString]; ok {
+		// The retries are counted by the state, so the login can't be retried
+		// without it
+		state, err := p.decodeOAuthState(req)
+		if err == nil && state.Retries < p.maxLoginRetries() {
+			// The user may already have a session, eg. for a step-up login
+			session, err := p.LoadCookiedSession(req)
+			if err != nil {
+				session = nil
+			}
+			params := url.Values{}
+			params.Set("rd", p.restartRedirect(req, session, state.Redirect))
+			params.Set("prompt", prompt)
+			params.Set(loginRetryParam, strconv.Itoa(state.Retries+1))
+			http.Redirect(rw, req, fmt.Sprintf("%s%s?%s", p.ProxyPrefix, oauthStartPath, params.Encode()), http.StatusFound)
+			return
+		}
+		logger.Errorf("Not retrying the login after the provider error %s", errorString)
+	}
+
+	debugMessage := fmt.Sprintf("Login Failed: The upstream identity provider returned an error: %s", errorString)
+	message, ok := p.providerErrorMessages[errorString]
+	if !ok {
+		message, ok = p.providerErrorMessages["*"]
+	}
+	if !ok {
+		// Override the non debug message to be the same for this case
+		message = debugMessage
+	}
+	p.ErrorPage(rw, req, http.StatusForbidden, debugMessage, message)
+}
+
+// loginRetries returns the number of times the login flow has been restarted
+// after the state or nonce could not be verified at the callback, as carried
+// in the login_retry query parameter. It is bounded by maxLoginRetries.
+func (p *OAuthProxy) loginRetries(req *http.Request) int {
+	retries, err := strconv.Atoi(req.URL.Query().Get(loginRetryParam))
+	if err != nil || retries < 0 {
+		return 0
+	}
+	if limit := p.maxLoginRetries(); retries > limit {
+		return limit
+	}
+	return retries
+}
+
+// maxLoginRetries returns the number of times the login flow may be
+// restarted. A provider error with a retry prompt is retried once even when
+// the loginRetryLimit is not set.
+func (p *OAuthProxy) maxLoginRetries() int {
+	if len(p.providerErrorRetryPrompts) > 0 && p.loginRetryLimit < 1 {
+		return 1
+	}
+	return p.loginRetryLimit
+}
+
+// retryLogin restarts the login flow when the state or nonce could not be
+// verified at the callback, eg. because the CSRF cookie was stripped, as
+// long as the login has been retried fewer than loginRetryLimit times.
+// It reports whether the login flow was restarted.
+func (p *OAuthProxy) retryLogin(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState, reason string) bool {
+	if p.loginRetryLimit <= 0 {
+		return false
+	}
+	// The retries are counted by the state, so the login can't be retried
+	// without it
+	state, err := p.decodeOAuthState(req)
+	if err != nil {
+		return false
+	}
+	if state.Retries >= p.loginRetryLimit {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: %s, not retrying after %d retries", reason, state.Retries)
+		return false
+	}
+
+	appRedirect := p.restartRedirect(req, session, state.Redirect)
+	logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: %s, retrying login (%d of %d)", reason, state.Retries+1, p.loginRetryLimit)
+
+	params := url.Values{}
+	params.Set("rd", appRedirect)
+	params.Set(loginRetryParam, strconv.Itoa(state.Retries+1))
+	http.Redirect(rw, req, fmt.Sprintf("%s%s?%s", p.ProxyPrefix, oauthStartPath, params.Encode()), http.StatusFound)
+	return true
+}
+
+// restartRedirect returns the redirect of the OAuth state to restart the login
+// flow with. Without a requested redirect, the login is restarted without one
+// when landing redirects are configured, so that one is still chosen after
+// login. The redirect is validated for the tenant of the session, when the
+// user's session is known.
+func (p *OAuthProxy) restartRedirect(req *http.Request, session *sessionsapi.SessionState, stateRedirect string) string {
+	if stateRedirect == "" && len(p.landingRedirects) > 0 {
+		return ""
+	}
+	if p.redirectValidatorFor(req, session).IsValidRedirect(stateRedirect) {
+		return stateRedirect
+	}
+	return "/"
+}
+
+// isRetryPrompt determines whether the prompt is one that the login flow
+// may be retried with after an error from the provider.
+func (p *OAuthProxy) isRetryPrompt(prompt string) bool {
+	if prompt == "" {
+		return false
+	}
+	for _, retryPrompt := range p.providerErrorRetryPrompts {
+		if prompt == retryPrompt {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectStepUpMethod returns stepUpAnyMethod when the application redirect
+// of a login is a step-up route. The method of the request that will follow
+// the login is unknown, so routes for any method are considered.
+func (p *OAuthProxy) redirectStepUpMethod(req *http.Request) string {
+	appRedirect, err := p.getAppRedirect(req)
+	if err != nil || appRedirect == "" {
+		return ""
+	}
+	if p.requiresStepUp(stepUpAnyMethod, redirectPath(appRedirect)) {
+		return stepUpAnyMethod
+	}
+	return ""
+}
+
+// stepUpLoginParams returns the login URL parameters that ask the provider
+// to authenticate the user again for a step-up route.
+func (p *OAuthProxy) stepUpLoginParams() url.Values {
+	params := url.Values{}
+	if p.stepUpPrompt != "" {
+		params.Set("prompt", p.stepUpPrompt)
+	}
+	if p.stepUpAcrValues != "" {
+		params.Set("acr_values", p.stepUpAcrValues)
+	}
+	return params
+}
+
+// setLoginParams overrides the parameters configured for the provider in the
+// login URL. Overriding the prompt removes any approval_prompt.
+func setLoginParams(loginURL string, overrides url.Values) string {
+	if len(overrides) == 0 {
+		return loginURL
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		logger.Errorf("Error setting parameters on login URL: %v", err)
+		return loginURL
+	}
+	params := u.Query()
+	if _, ok := overrides["prompt"]; ok {
+		params.Del("approval_prompt")
+	}
+	for key, values := range overrides {
+		params[key] = values
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
 // OAuthCallback is the OAuth2 authentication flow callback that finishes the
 // OAuth2 authentication flow
 func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
@@ -705,10 +1691,7 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 	errorString := req.Form.Get("error")
 	if errorString != "" {
-		logger.Errorf("Error while parsing OAuth2 callback: %s", errorString)
-		message := fmt.Sprintf("Login Failed: The upstream identity provider returned an error: %s", errorString)
-		// Set the debug message and override the non debug message to be the same for this case
-		p.ErrorPage(rw, req, http.StatusForbidden, message, message)
+		p.providerError(rw, req, errorString)
 		return
 	}
 
@@ -725,33 +1708,62 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	csrf, err := cookies.LoadCSRFCookie(req, p.CookieOptions)
-	if err != nil {
-		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unable to obtain CSRF cookie")
-		p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: Unable to find a valid CSRF token. Please try again.")
-		return
+	if p.claimTransformer != nil {
+		p.claimTransformer.Transform(session)
+	}
+	if p.featureFlags != nil {
+		p.featureFlags.Evaluate(session)
 	}
 
-	csrf.ClearCookie(rw, req)
+	// Signed states are verified without the CSRF cookie
+	var csrf cookies.CSRF
+	if p.oauthState.Mode != options.OAuthStateSigned {
+		csrf, err = cookies.LoadCSRFCookie(req, p.CookieOptions)
+		if err != nil {
+			if p.retryLogin(rw, req, session, "unable to obtain CSRF cookie") {
+				return
+			}
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unable to obtain CSRF cookie")
+			p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: Unable to find a valid CSRF token. Please try again.")
+			return
+		}
+
+		csrf.ClearCookie(rw, req)
+	}
 
-	nonce, appRedirect, err := decodeState(req)
+	state, err := p.decodeOAuthState(req)
+	if err != nil && p.signedOAuthState() {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: %v", err)
+		p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: The login request was invalid or has expired. Please try again.")
+		return
+	}
 	if err != nil {
 		logger.Errorf("Error while parsing OAuth2 state: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if !csrf.CheckOAuthState(nonce) {
-		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: CSRF token mismatch, potential attack")
-		p.ErrorPage(rw, req, http.StatusForbidden, "CSRF token mismatch, potential attack", "Login Failed: Unable to find a valid CSRF token. Please try again.")
-		return
+	if csrf != nil {
+		if !csrf.CheckOAuthState(state.Nonce) {
+			if p.retryLogin(rw, req, session, "CSRF token mismatch") {
+				return
+			}
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: CSRF token mismatch, potential attack")
+			p.ErrorPage(rw, req, http.StatusForbidden, "CSRF token mismatch, potential attack", "Login Failed: Unable to find a valid CSRF token. Please try again.")
+			return
+		}
+		csrf.SetSessionNonce(session)
+	} else {
+		state.SetSessionNonce(session)
 	}
-
-	csrf.SetSessionNonce(session)
 	p.provider.ValidateSession(req.Context(), session)
 
-	if !p.redirectValidator.IsValidRedirect(appRedirect) {
+	redirectValidator := p.redirectValidatorFor(req, session)
+	appRedirect := state.Redirect
+	if appRedirect == "" && len(p.landingRedirects) > 0 {
+		appRedirect = p.getLandingRedirect(redirectValidator, session)
+	}
+	if !redirectValidator.IsValidRedirect(appRedirect) {
 		appRedirect = "/"
 	}
 
@@ -761,6 +1773,14 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		logger.Errorf("Error with authorization: %v", err)
 	}
 	if p.Validator(session.Email) && authorized {
+		// A provider may ignore the step-up acr_values, so a login that
+		// doesn't satisfy the step-up routes must not be sent back to them
+		if state.StepUp != "" && !p.isStepUpSatisfied(state.StepUp, redirectPath(appRedirect), session) {
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: authentication methods %v do not satisfy the step-up route %s", session.AMR, appRedirect)
+			p.ErrorPage(rw, req, http.StatusForbidden, "Invalid session: step-up authentication methods missing", "Login Failed: You did not sign in with an authentication method required for this page.")
+			return
+		}
+
 		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via OAuth2: %s", session)
 		err := p.SaveSession(rw, req, session)
 		if err != nil {
@@ -768,6 +1788,11 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 			p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 			return
 		}
+		if p.requestStash != nil && p.requestStash.Has(req.Context(), state.Nonce) {
+			if err := p.requestStash.SetCookie(rw, req, state.Nonce); err != nil {
+				logger.Errorf("Error setting request replay cookie: %v", err)
+			}
+		}
 		http.Redirect(rw, req, appRedirect, http.StatusFound)
 	} else {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unauthorized")
@@ -791,6 +1816,9 @@ func (p *OAuthProxy) redeemCode(req *http.Request) (*sessionsapi.SessionState, e
 	if s.CreatedAt == nil {
 		s.CreatedAtNow()
 	}
+	if s.AuthenticatedAt == nil {
+		s.AuthenticatedAtNow()
+	}
 	if s.ExpiresOn == nil {
 		s.ExpiresIn(p.CookieOptions.Expire)
 	}
@@ -814,8 +1842,15 @@ func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.Sess
 
 // AuthOnly checks whether the user is currently logged in (both authentication
 // and optional authorization).
+// Sessions that don't satisfy a step-up route get a 401, so that the reverse
+// proxy starts a login, which asks for the stronger methods for the redirect.
 func (p *OAuthProxy) AuthOnly(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.getAuthenticatedSession(rw, req)
+	if err == ErrUnknownStepUpRequest {
+		// Logging in again wouldn't make the request known
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
@@ -830,7 +1865,7 @@ func (p *OAuthProxy) AuthOnly(rw http.ResponseWriter, req *http.Request) {
 
 	// we are authenticated
 	p.addHeadersForProxying(rw, session)
-	p.headersChain.Then(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+	p.authOnlyChain.Then(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusAccepted)
 	})).ServeHTTP(rw, req)
 }
@@ -839,12 +1874,25 @@ func (p *OAuthProxy) AuthOnly(rw http.ResponseWriter, req *http.Request) {
 // them to authenticate
 func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.getAuthenticatedSession(rw, req)
+	if err != nil {
+		// Login redirects and error responses must not be cached, otherwise
+		// users would be sent to log in again once they have a session
+		prepareNoCache(rw)
+	}
 	switch err {
 	case nil:
 		// we are authenticated
+		p.replayStashedRequest(rw, req)
 		p.addHeadersForProxying(rw, session)
+		if scope := middlewareapi.GetRequestScope(req); scope != nil {
+			scope.Timings.UpstreamStarted = time.Now()
+		}
 		p.headersChain.Then(p.upstreamProxy).ServeHTTP(rw, req)
 	case ErrNeedsLogin:
+		if p.handleHeadRequest(rw, req, http.StatusUnauthorized) {
+			return
+		}
+
 		// we need to send the user to a login screen
 		if isAjax(req) {
 			// no point redirecting an AJAX request
@@ -853,12 +1901,29 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 		}
 
 		if p.SkipProviderButton {
-			p.OAuthStart(rw, req)
+			p.oauthStart(rw, req, true, "")
 		} else {
 			p.SignInPage(rw, req, http.StatusForbidden)
 		}
 
+	case ErrNeedsStepUp:
+		if p.handleHeadRequest(rw, req, http.StatusUnauthorized) {
+			return
+		}
+
+		if isAjax(req) {
+			p.errorJSON(rw, http.StatusUnauthorized)
+			return
+		}
+
+		// The sign in page doesn't ask for the stronger method, so the user
+		// is always sent straight to the provider
+		p.oauthStart(rw, req, true, req.Method)
+
 	case ErrAccessDenied:
+		if p.handleHeadRequest(rw, req, http.StatusForbidden) {
+			return
+		}
 		p.ErrorPage(rw, req, http.StatusForbidden, "The session failed authorization checks")
 
 	default:
@@ -868,11 +1933,54 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// replayStashedRequest turns the first request after a login into the POST
+// request that started the login, when it was stashed to be replayed.
+// The stashed request is removed whether or not it is replayed.
+func (p *OAuthProxy) replayStashedRequest(rw http.ResponseWriter, req *http.Request) {
+	if p.requestStash == nil {
+		return
+	}
+	state, ok := p.requestStash.LoadCookie(req)
+	if !ok {
+		return
+	}
+	p.requestStash.ClearCookie(rw, req)
+
+	stashed, err := p.requestStash.Take(req.Context(), state)
+	if err != nil {
+		logger.Errorf("Error loading stashed request to replay: %v", err)
+		return
+	}
+	if !stashed.Replay(req) {
+		logger.Printf("Not replaying stashed %s request to %s for %s request to %s", stashed.Method, stashed.URL, req.Method, req.URL.RequestURI())
+	}
+}
+
+// handleHeadRequest responds to HEAD requests which could not be proxied
+// according to the configured HEAD request handling.
+// It returns false when the request should be handled the same as a GET.
+func (p *OAuthProxy) handleHeadRequest(rw http.ResponseWriter, req *http.Request, code int) bool {
+	if req.Method != http.MethodHead {
+		return false
+	}
+
+	switch p.headRequestHandling {
+	case options.HeadRequestStatus:
+		rw.WriteHeader(code)
+	case options.HeadRequestOK:
+		rw.WriteHeader(http.StatusOK)
+	default:
+		return false
+	}
+	return true
+}
+
 // See https://developers.google.com/web/fundamentals/performance/optimizing-content-efficiency/http-caching?hl=en
 var noCacheHeaders = map[string]string{
 	"Expires":         time.Unix(0, 0).Format(time.RFC1123),
 	"Cache-Control":   "no-cache, no-store, must-revalidate, max-age=0",
-	"X-Accel-Expires": "0", // https://www.nginx.com/resources/wiki/start/topics/examples/x-accel/
+	"X-Accel-Expires": "0",        // https://www.nginx.com/resources/wiki/start/topics/examples/x-accel/
+	"Pragma":          "no-cache", // for HTTP/1.0 caches that ignore Cache-Control
 }
 
 // prepareNoCache prepares headers for preventing browser caching.
@@ -921,6 +2029,8 @@ func (p *OAuthProxy) getOAuthRedirectURI(req *http.Request) string {
 // Returns:
 // - `nil, ErrNeedsLogin` if user needs to login.
 // - `nil, ErrAccessDenied` if the authenticated user is not authorized
+// - `nil, ErrNeedsStepUp` if the route requires a stronger authentication method
+// - `nil, ErrUnknownStepUpRequest` if the auth endpoint can't tell which route to check
 // Set-Cookie headers may be set on the response as a side-effect of calling this method.
 func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.Request) (*sessionsapi.SessionState, error) {
 	session := middlewareapi.GetRequestScope(req).Session
@@ -950,9 +2060,97 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 		return nil, ErrAccessDenied
 	}
 
+	method, path, ok := p.stepUpRequest(req)
+	if !ok {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Unable to check step-up routes: the %s header is missing or reverse proxy mode is disabled", requestutil.XForwardedURI)
+		return nil, ErrUnknownStepUpRequest
+	}
+	if !p.isStepUpSatisfied(method, path, session) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session authentication methods %v do not satisfy the step-up route %s", session.AMR, path)
+		return nil, ErrNeedsStepUp
+	}
+
 	return session, nil
 }
 
+// stepUpRequest returns the method and path of the request to check against
+// the step-up routes. On the auth endpoint, this is the request the reverse
+// proxy is authenticating, taken from the X-Forwarded-Uri and
+// X-Forwarded-Method headers. These are only trusted in reverse proxy mode.
+// Without the X-Forwarded-Method header, routes for any method are checked.
+// It returns false when step-up routes are configured but the request being
+// authenticated is unknown.
+func (p *OAuthProxy) stepUpRequest(req *http.Request) (string, string, bool) {
+	if req.URL.Path != p.ProxyPrefix+authOnlyPath {
+		return req.Method, req.URL.Path, true
+	}
+	if len(p.stepUpRoutes) == 0 {
+		return "", "", true
+	}
+
+	uri := req.Header.Get(requestutil.XForwardedURI)
+	if !requestutil.IsProxied(req) || uri == "" {
+		return "", "", false
+	}
+	method := req.Header.Get(requestutil.XForwardedMethod)
+	if method == "" {
+		method = stepUpAnyMethod
+	}
+	return strings.ToUpper(method), redirectPath(uri), true
+}
+
+// isStepUpSatisfied checks that the session was authenticated with one of
+// the methods required by each step-up route matching the method + path
+func (p *OAuthProxy) isStepUpSatisfied(method string, path string, session *sessionsapi.SessionState) bool {
+	for _, route := range p.stepUpRoutes {
+		if p.matchesStepUpRoute(route, method, path) && !hasAnyAMR(session, route.amr) {
+			return false
+		}
+	}
+	return true
+}
+
+// requiresStepUp checks whether any step-up route matches the method + path
+func (p *OAuthProxy) requiresStepUp(method string, path string) bool {
+	for _, route := range p.stepUpRoutes {
+		if p.matchesStepUpRoute(route, method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesStepUpRoute checks whether the step-up route applies to the
+// method + path. The stepUpAnyMethod matches routes for every method.
+func (p *OAuthProxy) matchesStepUpRoute(route stepUpRoute, method string, path string) bool {
+	if route.method != "" && method != stepUpAnyMethod && method != route.method {
+		return false
+	}
+	return p.matchesPath(route.pathRegex, path)
+}
+
+// redirectPath returns the path of a redirect, which may be a full URL
+func redirectPath(redirect string) string {
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// hasAnyAMR checks whether the session was authenticated with any of the
+// authentication methods
+func hasAnyAMR(session *sessionsapi.SessionState, amr []string) bool {
+	for _, method := range amr {
+		for _, sessionMethod := range session.AMR {
+			if method == sessionMethod {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // authOnlyAuthorize handles special authorization logic that is only done
 // on the AuthOnly endpoint for use with Nginx subrequest architectures.
 //
@@ -1006,6 +2204,64 @@ func encodeState(nonce string, redirect string) string {
 	return fmt.Sprintf("%v:%v", nonce, redirect)
 }
 
+// signedOAuthState reports whether the OAuth state param is signed, rather
+// than being verified with the CSRF cookie alone
+func (p *OAuthProxy) signedOAuthState() bool {
+	return p.oauthState.Mode == options.OAuthStateSigned || p.oauthState.Mode == options.OAuthStateSignedAndCookie
+}
+
+// encodeOAuthState builds the OAuth state param for the login flow, signing
+// it when configured to. Unsigned states carry the number of retries of the
+// login, and the method of a step-up login, as suffixes of the nonce.
+func (p *OAuthProxy) encodeOAuthState(csrf cookies.CSRF, redirect string, retries int, stepUpMethod string) (string, error) {
+	if !p.signedOAuthState() {
+		nonce := csrf.HashOAuthState()
+		if retries > 0 || stepUpMethod != "" {
+			nonce = fmt.Sprintf("%s%s%d", nonce, stateRetriesSeparator, retries)
+		}
+		if stepUpMethod != "" {
+			nonce = fmt.Sprintf("%s%s%s", nonce, stateRetriesSeparator, stepUpMethod)
+		}
+		return encodeState(nonce, redirect), nil
+	}
+	state := csrf.NewOAuthState(redirect, p.providerID)
+	state.Retries = retries
+	state.StepUp = stepUpMethod
+	return state.Encode(p.CookieOptions, time.Now())
+}
+
+// decodeOAuthState decodes the OAuth state param reflected to the callback.
+// Signed states are rejected if they have been tampered with, have expired or
+// were issued for a different provider.
+func (p *OAuthProxy) decodeOAuthState(req *http.Request) (*cookies.OAuthState, error) {
+	if !p.signedOAuthState() {
+		nonce, redirect, err := decodeState(req)
+		if err != nil {
+			return nil, err
+		}
+		state := &cookies.OAuthState{Nonce: nonce, Redirect: redirect}
+		// The nonce is URL safe base64, so never contains the separator
+		if parts := strings.SplitN(nonce, stateRetriesSeparator, 3); len(parts) > 1 {
+			if retries, err := strconv.Atoi(parts[1]); err == nil {
+				state.Nonce, state.Retries = parts[0], retries
+				if len(parts) == 3 {
+					state.StepUp = parts[2]
+				}
+			}
+		}
+		return state, nil
+	}
+
+	state, err := cookies.DecodeOAuthState(req.Form.Get("state"), p.CookieOptions, p.oauthState.Expire)
+	if err != nil {
+		return nil, err
+	}
+	if state.Provider != p.providerID {
+		return nil, fmt.Errorf("OAuth state was issued for provider %q", state.Provider)
+	}
+	return state, nil
+}
+
 // decodeState splits the reflected OAuth state response back into
 // the nonce and original application redirect
 func decodeState(req *http.Request) (string, string, error) {
@@ -3,19 +3,28 @@ package main
 import (
 	"context"
 	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt"
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -57,11 +66,789 @@ func TestRobotsTxt(t *testing.T) {
 	assert.Equal(t, "User-agent: *\nDisallow: /\n", rw.Body.String())
 }
 
+func TestSignOutRedirect(t *testing.T) {
+	opts := baseTestOptions()
+	opts.WhitelistDomains = []string{"www.example.com"}
+	opts.PostLogoutRedirectURL = "https://www.example.com/signed-out"
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name             string
+		path             string
+		expectedRedirect string
+	}{
+		{
+			name:             "without a rd parameter",
+			path:             "/oauth2/sign_out",
+			expectedRedirect: "https://www.example.com/signed-out",
+		},
+		{
+			name:             "with a valid rd parameter",
+			path:             "/oauth2/sign_out?rd=%2Fgoodbye",
+			expectedRedirect: "/goodbye",
+		},
+		{
+			name:             "with an invalid rd parameter",
+			path:             "/oauth2/sign_out?rd=https%3A%2F%2Fevil.example.com%2F",
+			expectedRedirect: "https://www.example.com/signed-out",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, tc.expectedRedirect, rw.Header().Get("Location"))
+		})
+	}
+}
+
+func TestWhitelistDomainsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whitelist-domains")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "domains")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("www.example.com\n"), 0600))
+
+	opts := baseTestOptions()
+	opts.WhitelistDomains = []string{"static.example.com"}
+	opts.WhitelistDomainsFile = filename
+	err = validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, proxy.redirectValidator.IsValidRedirect("https://static.example.com/"))
+	assert.True(t, proxy.redirectValidator.IsValidRedirect("https://www.example.com/"))
+	assert.False(t, proxy.redirectValidator.IsValidRedirect("https://evil.example.com/"))
+
+	opts.PostLogoutRedirectURL = "https://www.example.com/signed-out"
+	_, err = NewOAuthProxy(opts, func(string) bool { return true })
+	assert.NoError(t, err)
+
+	opts.PostLogoutRedirectURL = "https://evil.example.com/signed-out"
+	_, err = NewOAuthProxy(opts, func(string) bool { return true })
+	assert.EqualError(t, err, "post_logout_redirect_url \"https://evil.example.com/signed-out\" is not a valid redirect: absolute URLs must be within a whitelist domain or a tenant's redirect domains")
+}
+
+func TestTenantRedirectsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenant-redirects")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tenants")
+	contents := "# tenant allowed domains\na.example.com .tenant-a.com\nb.example.com .tenant-b.com b.example.com:8443\nc.example.com\n"
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0600))
+
+	newProxy := func(claim string) *OAuthProxy {
+		opts := baseTestOptions()
+		opts.WhitelistDomains = []string{"shared.example.com"}
+		opts.TenantRedirectsFile = filename
+		opts.TenantRedirectClaim = claim
+		assert.NoError(t, validation.Validate(opts))
+
+		proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proxy
+	}
+
+	t.Run("with tenants identified by host", func(t *testing.T) {
+		proxy := newProxy("")
+		req := httptest.NewRequest("GET", "https://a.example.com:4180/oauth2/callback", nil)
+		validator := proxy.redirectValidatorFor(req, nil)
+
+		assert.True(t, validator.IsValidRedirect("/app"))
+		assert.True(t, validator.IsValidRedirect("https://shared.example.com/"))
+		assert.True(t, validator.IsValidRedirect("https://app.tenant-a.com/"))
+		assert.False(t, validator.IsValidRedirect("https://app.tenant-b.com/"))
+		assert.False(t, validator.IsValidRedirect("https://b.example.com:8443/"))
+
+		req = httptest.NewRequest("GET", "https://c.example.com/oauth2/callback", nil)
+		validator = proxy.redirectValidatorFor(req, nil)
+		assert.True(t, validator.IsValidRedirect("https://shared.example.com/"))
+		assert.False(t, validator.IsValidRedirect("https://app.tenant-a.com/"))
+	})
+
+	t.Run("with tenants identified by a claim", func(t *testing.T) {
+		proxy := newProxy("groups")
+		req := httptest.NewRequest("GET", "https://a.example.com/oauth2/start", nil)
+
+		// Before the login, redirects to the domains of any tenant are allowed
+		validator := proxy.redirectValidatorFor(req, nil)
+		assert.True(t, validator.IsValidRedirect("https://app.tenant-a.com/"))
+		assert.True(t, validator.IsValidRedirect("https://b.example.com:8443/"))
+		assert.False(t, validator.IsValidRedirect("https://evil.example.com/"))
+
+		validator = proxy.redirectValidatorFor(req, &sessions.SessionState{Groups: []string{"b.example.com"}})
+		assert.True(t, validator.IsValidRedirect("https://shared.example.com/"))
+		assert.True(t, validator.IsValidRedirect("https://b.example.com:8443/"))
+		assert.False(t, validator.IsValidRedirect("https://app.tenant-a.com/"))
+
+		validator = proxy.redirectValidatorFor(req, &sessions.SessionState{})
+		assert.True(t, validator.IsValidRedirect("https://shared.example.com/"))
+		assert.False(t, validator.IsValidRedirect("https://app.tenant-b.com/"))
+	})
+
+	t.Run("validates sign out redirects for the tenant of the session", func(t *testing.T) {
+		proxy := newProxy("groups")
+		proxy.postLogoutRedirect = "https://app.tenant-a.com/signed-out"
+
+		signOut := func(rd string, session *sessions.SessionState) string {
+			req := httptest.NewRequest("GET", "https://a.example.com/oauth2/sign_out?rd="+url.QueryEscape(rd), nil)
+			if session != nil {
+				rw := httptest.NewRecorder()
+				assert.NoError(t, proxy.sessionStore.Save(rw, req, session))
+				req.Header.Set("Cookie", rw.Header().Values("Set-Cookie")[0])
+			}
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, http.StatusFound, rw.Code)
+			return rw.Header().Get("Location")
+		}
+
+		tenantA := &sessions.SessionState{Email: "a@tenant-a.com", Groups: []string{"a.example.com"}}
+		assert.Equal(t, "https://app.tenant-a.com/", signOut("https://app.tenant-a.com/", tenantA))
+		assert.Equal(t, "https://app.tenant-a.com/signed-out", signOut("https://app.tenant-b.com/", tenantA))
+
+		// Without a session, only the domains shared by every tenant are allowed
+		assert.Equal(t, "https://shared.example.com/", signOut("https://shared.example.com/", nil))
+		assert.Equal(t, "/", signOut("https://app.tenant-b.com/", nil))
+	})
+
+	t.Run("accepts a post logout redirect within a tenant's domains", func(t *testing.T) {
+		opts := baseTestOptions()
+		opts.TenantRedirectsFile = filename
+		opts.PostLogoutRedirectURL = "https://app.tenant-a.com/signed-out"
+		assert.NoError(t, validation.Validate(opts))
+
+		_, err := NewOAuthProxy(opts, func(string) bool { return true })
+		assert.NoError(t, err)
+
+		opts.PostLogoutRedirectURL = "https://app.tenant-z.com/signed-out"
+		_, err = NewOAuthProxy(opts, func(string) bool { return true })
+		assert.Error(t, err)
+	})
+
+	t.Run("validates login restarts for the tenant of the session", func(t *testing.T) {
+		proxy := newProxy("groups")
+		req := httptest.NewRequest("GET", "https://a.example.com/oauth2/callback", nil)
+		tenantA := &sessions.SessionState{Groups: []string{"a.example.com"}}
+
+		assert.Equal(t, "https://app.tenant-a.com/", proxy.restartRedirect(req, tenantA, "https://app.tenant-a.com/"))
+		assert.Equal(t, "/", proxy.restartRedirect(req, tenantA, "https://app.tenant-b.com/"))
+	})
+
+	t.Run("rejects cross-tenant redirects when the login is started", func(t *testing.T) {
+		proxy := newProxy("")
+		req := httptest.NewRequest("GET", "https://a.example.com/oauth2/start?rd=https://app.tenant-b.com/", nil)
+		redirect, err := proxy.appDirector.GetRedirect(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "/", redirect)
+
+		req = httptest.NewRequest("GET", "https://a.example.com/oauth2/start?rd=https://app.tenant-a.com/", nil)
+		redirect, err = proxy.appDirector.GetRedirect(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://app.tenant-a.com/", redirect)
+	})
+}
+
+func TestLandingRedirects(t *testing.T) {
+	opts := baseTestOptions()
+	opts.WhitelistDomains = []string{"admin.example.com"}
+	opts.LandingRedirects = []string{
+		"admins=https://admin.example.com/",
+		"role:viewer=/dashboard",
+		"users=/home",
+	}
+	assert.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("only stores requested redirects when the login is started", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oauth2/start", nil)
+		redirect, err := proxy.getAppRedirect(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "", redirect)
+
+		req = httptest.NewRequest("GET", "/oauth2/start?rd=%2Fapp", nil)
+		redirect, err = proxy.getAppRedirect(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "/app", redirect)
+	})
+
+	t.Run("selects the first landing redirect for the groups of the session", func(t *testing.T) {
+		validator := proxy.redirectValidatorFor(httptest.NewRequest("GET", "/oauth2/callback", nil), nil)
+
+		assert.Equal(t, "https://admin.example.com/", proxy.getLandingRedirect(validator, &sessions.SessionState{Groups: []string{"users", "admins"}}))
+		assert.Equal(t, "/dashboard", proxy.getLandingRedirect(validator, &sessions.SessionState{Groups: []string{"role:viewer", "users"}}))
+		assert.Equal(t, "/home", proxy.getLandingRedirect(validator, &sessions.SessionState{Groups: []string{"users"}}))
+		assert.Equal(t, "/", proxy.getLandingRedirect(validator, &sessions.SessionState{Groups: []string{"guests"}}))
+		assert.Equal(t, "/", proxy.getLandingRedirect(validator, &sessions.SessionState{}))
+	})
+
+	t.Run("redirects to the landing redirect after login", func(t *testing.T) {
+		patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(patTest.Close)
+		patTest.proxy.landingRedirects = []landingRedirect{{group: "users", redirect: "/home"}}
+		patTest.proxy.provider.(*TestProvider).Groups = []string{"users"}
+
+		for rd, expectedLocation := range map[string]string{
+			"":     "/home",
+			"/app": "/app",
+		} {
+			csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+			assert.NoError(t, err)
+			state, err := patTest.proxy.encodeOAuthState(csrf, rd, 0, "")
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+			csrfCookie, err := csrf.SetCookie(httptest.NewRecorder(), req)
+			assert.NoError(t, err)
+			req.AddCookie(csrfCookie)
+
+			rw := httptest.NewRecorder()
+			patTest.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, expectedLocation, rw.Header().Get("Location"))
+		}
+	})
+
+	t.Run("restarts the login without a requested redirect", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oauth2/callback", nil)
+		assert.Equal(t, "", proxy.restartRedirect(req, nil, ""))
+		assert.Equal(t, "/app", proxy.restartRedirect(req, nil, "/app"))
+		assert.Equal(t, "/", proxy.restartRedirect(req, nil, "https://evil.example.com/"))
+	})
+}
+
+func TestProviderErrorCallback(t *testing.T) {
+	opts := baseTestOptions()
+	opts.ProviderErrorMessages = []string{
+		"access_denied=You are not allowed to access this application.",
+		"*=Something went wrong signing you in.",
+	}
+	opts.ProviderErrorRetryPrompts = []string{"login_required=login"}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("with a mapped error", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/callback?error=access_denied&state=nonce%3A%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Contains(t, rw.Body.String(), "You are not allowed to access this application.")
+		assert.NotContains(t, rw.Body.String(), "access_denied")
+	})
+
+	t.Run("with an unmapped error", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/callback?error=server_error&state=nonce%3A%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Contains(t, rw.Body.String(), "Something went wrong signing you in.")
+	})
+
+	t.Run("with an error that is retried", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/callback?error=login_required&state=nonce%3A%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/start?login_retry=1&prompt=login&rd=%2Fapp", rw.Header().Get("Location"))
+	})
+
+	t.Run("with an error that has already been retried", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/callback?error=login_required&state=nonce.1%3A%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Contains(t, rw.Body.String(), "Something went wrong signing you in.")
+	})
+
+	t.Run("with an error that is retried without a state", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/callback?error=login_required", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+	})
+
+	t.Run("when retrying the login flow", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/start?prompt=login&rd=%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "login", loginURL.Query().Get("prompt"))
+		assert.Equal(t, "", loginURL.Query().Get("approval_prompt"))
+	})
+
+	t.Run("when retrying the login flow counts the retry in the state", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/start?login_retry=1&prompt=login&rd=%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Regexp(t, `^[^:]+\.1:/app$`, loginURL.Query().Get("state"))
+	})
+
+	t.Run("when starting the login flow with an unknown prompt", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/oauth2/start?prompt=none&rd=%2Fapp", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "", loginURL.Query().Get("prompt"))
+	})
+}
+
+func TestStepUpRoutes(t *testing.T) {
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.Upstreams{
+		{
+			ID:     "static",
+			Path:   "/",
+			Static: true,
+		},
+	}
+	opts.StepUp.Routes = []string{"mfa|otp:^/admin", "hwk:POST=^/keys"}
+	opts.StepUp.AcrValues = "phr"
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		method       string
+		path         string
+		amr          []string
+		ajax         bool
+		expectedCode int
+	}{
+		"without a step-up route": {
+			method:       http.MethodGet,
+			path:         "/",
+			expectedCode: http.StatusOK,
+		},
+		"with a required method": {
+			method:       http.MethodGet,
+			path:         "/admin",
+			amr:          []string{"pwd", "otp"},
+			expectedCode: http.StatusOK,
+		},
+		"without a required method": {
+			method:       http.MethodGet,
+			path:         "/admin/users",
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusFound,
+		},
+		"without a required method for an AJAX request": {
+			method:       http.MethodGet,
+			path:         "/admin",
+			amr:          []string{"pwd"},
+			ajax:         true,
+			expectedCode: http.StatusUnauthorized,
+		},
+		"with a different request method": {
+			method:       http.MethodGet,
+			path:         "/keys",
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusOK,
+		},
+		"without a required method for the request method": {
+			method:       http.MethodPost,
+			path:         "/keys",
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			created := time.Now()
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(""))
+			err := proxy.sessionStore.Save(rw, req, &sessions.SessionState{
+				Email:     "john.doe@example.com",
+				AMR:       tc.amr,
+				CreatedAt: &created,
+			})
+			assert.NoError(t, err)
+
+			req.Header.Set("Cookie", rw.Header().Values("Set-Cookie")[0])
+			if tc.ajax {
+				req.Header.Set("Accept", "application/json")
+			}
+			rw = httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+
+			if tc.expectedCode == http.StatusFound {
+				loginURL, err := url.Parse(rw.Header().Get("Location"))
+				assert.NoError(t, err)
+				assert.Equal(t, "login", loginURL.Query().Get("prompt"))
+				assert.Equal(t, "", loginURL.Query().Get("approval_prompt"))
+				assert.Equal(t, "phr", loginURL.Query().Get("acr_values"))
+			}
+		})
+	}
+}
+
+func TestStepUpAuthOnly(t *testing.T) {
+	opts := baseTestOptions()
+	opts.ReverseProxy = true
+	opts.StepUp.Routes = []string{"mfa:^/admin", "hwk:POST=^/keys"}
+	opts.StepUp.AcrValues = "phr"
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		headers      map[string]string
+		amr          []string
+		expectedCode int
+	}{
+		"without a forwarded URI": {
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusForbidden,
+		},
+		"with a forwarded URI that isn't a step-up route": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/home"},
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusAccepted,
+		},
+		"with a forwarded URI without a required method": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/admin/users?page=2"},
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusUnauthorized,
+		},
+		"with a forwarded URI with a required method": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/admin/users"},
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusAccepted,
+		},
+		"with only an auth request redirect": {
+			headers:      map[string]string{"X-Auth-Request-Redirect": "https://app.example.com/home"},
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusForbidden,
+		},
+		"with a forwarded method that isn't a step-up route": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/keys", "X-Forwarded-Method": "GET"},
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusAccepted,
+		},
+		"with a forwarded method without a required method": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/keys", "X-Forwarded-Method": "POST"},
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusUnauthorized,
+		},
+		"without a forwarded method": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/keys"},
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			created := time.Now()
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/oauth2/auth", nil)
+			err := proxy.sessionStore.Save(rw, req, &sessions.SessionState{
+				Email:     "john.doe@example.com",
+				AMR:       tc.amr,
+				CreatedAt: &created,
+			})
+			assert.NoError(t, err)
+
+			req.Header.Set("Cookie", rw.Header().Values("Set-Cookie")[0])
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			rw = httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+		})
+	}
+
+	t.Run("without reverse proxy mode", func(t *testing.T) {
+		opts := baseTestOptions()
+		opts.StepUp.Routes = []string{"mfa:^/admin"}
+		err := validation.Validate(opts)
+		assert.NoError(t, err)
+		proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/oauth2/auth", nil)
+		created := time.Now()
+		err = proxy.sessionStore.Save(rw, req, &sessions.SessionState{
+			Email:     "john.doe@example.com",
+			AMR:       []string{"pwd"},
+			CreatedAt: &created,
+		})
+		assert.NoError(t, err)
+
+		req.Header.Set("Cookie", rw.Header().Values("Set-Cookie")[0])
+		req.Header.Set("X-Forwarded-Uri", "/home")
+		rw = httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+	})
+
+	t.Run("starting a login that redirects to a step-up route", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fadmin%2Fusers", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "login", loginURL.Query().Get("prompt"))
+		assert.Equal(t, "phr", loginURL.Query().Get("acr_values"))
+		assert.Regexp(t, `^[^:]+\.0\.\*:/admin/users$`, loginURL.Query().Get("state"))
+	})
+
+	t.Run("starting a login that doesn't redirect to a step-up route", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fhome", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+		assert.Equal(t, "", loginURL.Query().Get("acr_values"))
+		assert.Regexp(t, `^[^:.]+:/home$`, loginURL.Query().Get("state"))
+	})
+}
+
+func TestStepUpCallback(t *testing.T) {
+	testCases := []struct {
+		name             string
+		mode             string
+		stepUpMethod     string
+		redirect         string
+		amr              []string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{
+			name:             "with a login that isn't a step-up login",
+			mode:             options.OAuthStateCookie,
+			redirect:         "/admin",
+			amr:              []string{"pwd"},
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/admin",
+		},
+		{
+			name:             "with a step-up login with a required method",
+			mode:             options.OAuthStateCookie,
+			stepUpMethod:     http.MethodGet,
+			redirect:         "/admin",
+			amr:              []string{"pwd", "mfa"},
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/admin",
+		},
+		{
+			name:         "with a step-up login still without a required method",
+			mode:         options.OAuthStateCookie,
+			stepUpMethod: http.MethodGet,
+			redirect:     "/admin",
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "with a signed step-up login still without a required method",
+			mode:         options.OAuthStateSignedAndCookie,
+			stepUpMethod: http.MethodGet,
+			redirect:     "/admin",
+			amr:          []string{"pwd"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:             "with a step-up login for a method without a route",
+			mode:             options.OAuthStateCookie,
+			stepUpMethod:     http.MethodGet,
+			redirect:         "/keys",
+			amr:              []string{"mfa"},
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/keys",
+		},
+		{
+			name:         "with a step-up login for any method",
+			mode:         options.OAuthStateCookie,
+			stepUpMethod: stepUpAnyMethod,
+			redirect:     "/keys",
+			amr:          []string{"mfa"},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(patTest.Close)
+			patTest.proxy.oauthState = options.OAuthState{Mode: tc.mode, Expire: 15 * time.Minute}
+			patTest.proxy.stepUpRoutes, err = buildStepUpRoutes(options.StepUp{Routes: []string{"mfa|otp:^/admin", "hwk:POST=^/keys"}})
+			assert.NoError(t, err)
+			patTest.proxy.provider.(*TestProvider).AMR = tc.amr
+
+			csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+			assert.NoError(t, err)
+			state, err := patTest.proxy.encodeOAuthState(csrf, tc.redirect, 0, tc.stepUpMethod)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+			csrfCookie, err := csrf.SetCookie(httptest.NewRecorder(), req)
+			assert.NoError(t, err)
+			req.AddCookie(csrfCookie)
+
+			rw := httptest.NewRecorder()
+			patTest.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedLocation != "" {
+				assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+			}
+		})
+	}
+
+	t.Run("doesn't start another login when the provider ignores the step-up", func(t *testing.T) {
+		patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(patTest.Close)
+		patTest.proxy.stepUpRoutes, err = buildStepUpRoutes(options.StepUp{Routes: []string{"mfa:^/admin"}})
+		assert.NoError(t, err)
+		patTest.proxy.provider.(*TestProvider).AMR = []string{"pwd"}
+
+		created := time.Now()
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		err = patTest.proxy.sessionStore.Save(rw, req, &sessions.SessionState{
+			Email:     "michael.bland@gsa.gov",
+			AMR:       []string{"pwd"},
+			CreatedAt: &created,
+		})
+		assert.NoError(t, err)
+		req.Header.Set("Cookie", rw.Header().Values("Set-Cookie")[0])
+
+		rw = httptest.NewRecorder()
+		patTest.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+
+		// The session from the step-up login still lacks the method
+		req = httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(loginURL.Query().Get("state")), nil)
+		for _, cookie := range rw.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rw = httptest.NewRecorder()
+		patTest.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Empty(t, rw.Header().Get("Location"))
+	})
+}
+
+func TestMetricsPath(t *testing.T) {
+	testCases := map[string]struct {
+		bearerToken   string
+		authorization string
+		expectedCode  int
+	}{
+		"without a bearer token": {
+			expectedCode: http.StatusOK,
+		},
+		"with the bearer token": {
+			bearerToken:   "scraper-token",
+			authorization: "Bearer scraper-token",
+			expectedCode:  http.StatusOK,
+		},
+		"with a missing bearer token": {
+			bearerToken:  "scraper-token",
+			expectedCode: http.StatusUnauthorized,
+		},
+		"with a different bearer token": {
+			bearerToken:   "scraper-token",
+			authorization: "Bearer user-token",
+			expectedCode:  http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.Metrics.Path = "/metrics"
+			opts.Metrics.BearerToken = tc.bearerToken
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/metrics", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Contains(t, rw.Body.String(), "oauth2_proxy_requests_total")
+			}
+		})
+	}
+}
+
 type TestProvider struct {
 	*providers.ProviderData
 	EmailAddress   string
 	ValidToken     bool
 	GroupValidator func(string) bool
+	Groups         []string
+	AMR            []string
 }
 
 var _ providers.Provider = (*TestProvider)(nil)
@@ -102,6 +889,16 @@ func (tp *TestProvider) ValidateSession(_ context.Context, _ *sessions.SessionSt
 	return tp.ValidToken
 }
 
+func (tp *TestProvider) EnrichSession(_ context.Context, s *sessions.SessionState) error {
+	if len(tp.Groups) > 0 {
+		s.Groups = tp.Groups
+	}
+	if len(tp.AMR) > 0 {
+		s.AMR = tp.AMR
+	}
+	return nil
+}
+
 func Test_redeemCode(t *testing.T) {
 	opts := baseTestOptions()
 	err := validation.Validate(opts)
@@ -398,7 +1195,7 @@ func (patTest *PassAccessTokenTest) Close() {
 func (patTest *PassAccessTokenTest) getCallbackEndpoint() (httpCode int, cookie string) {
 	rw := httptest.NewRecorder()
 
-	csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions)
+	csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
 	if err != nil {
 		panic(err)
 	}
@@ -446,102 +1243,440 @@ func (patTest *PassAccessTokenTest) getEndpointWithCookie(cookie string, endpoin
 		return 0, ""
 	}
 
-	req, err := http.NewRequest("GET", endpoint, strings.NewReader(""))
-	if err != nil {
-		return 0, ""
+	req, err := http.NewRequest("GET", endpoint, strings.NewReader(""))
+	if err != nil {
+		return 0, ""
+	}
+	req.AddCookie(&http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().Add(time.Duration(24)),
+		HttpOnly: true,
+	})
+
+	rw := httptest.NewRecorder()
+	patTest.proxy.ServeHTTP(rw, req)
+	return rw.Code, rw.Body.String()
+}
+
+func TestForwardAccessTokenUpstream(t *testing.T) {
+	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
+		PassAccessToken: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(patTest.Close)
+
+	// A successful validation will redirect and set the auth cookie.
+	code, cookie := patTest.getCallbackEndpoint()
+	if code != 302 {
+		t.Fatalf("expected 302; got %d", code)
+	}
+	assert.NotNil(t, cookie)
+
+	// Now we make a regular request; the access_token from the cookie is
+	// forwarded as the "X-Forwarded-Access-Token" header. The token is
+	// read by the test provider server and written in the response body.
+	code, payload := patTest.getEndpointWithCookie(cookie, "/")
+	if code != 200 {
+		t.Fatalf("expected 200; got %d", code)
+	}
+	assert.Equal(t, "my_auth_token", payload)
+}
+
+func TestStaticProxyUpstream(t *testing.T) {
+	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
+		PassAccessToken: true,
+		ProxyUpstream: options.Upstream{
+			ID:     "static-proxy",
+			Path:   "/static-proxy",
+			Static: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(patTest.Close)
+
+	// A successful validation will redirect and set the auth cookie.
+	code, cookie := patTest.getCallbackEndpoint()
+	if code != 302 {
+		t.Fatalf("expected 302; got %d", code)
+	}
+	assert.NotEqual(t, nil, cookie)
+
+	// Now we make a regular request against the upstream proxy; And validate
+	// the returned status code through the static proxy.
+	code, payload := patTest.getEndpointWithCookie(cookie, "/static-proxy")
+	if code != 200 {
+		t.Fatalf("expected 200; got %d", code)
+	}
+	assert.Equal(t, "Authenticated", payload)
+}
+
+func TestDoNotForwardAccessTokenUpstream(t *testing.T) {
+	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
+		PassAccessToken: false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(patTest.Close)
+
+	// A successful validation will redirect and set the auth cookie.
+	code, cookie := patTest.getCallbackEndpoint()
+	if code != 302 {
+		t.Fatalf("expected 302; got %d", code)
+	}
+	assert.NotEqual(t, nil, cookie)
+
+	// Now we make a regular request, but the access token header should
+	// not be present.
+	code, payload := patTest.getEndpointWithCookie(cookie, "/")
+	if code != 200 {
+		t.Fatalf("expected 200; got %d", code)
+	}
+	assert.Equal(t, "No access token found.", payload)
+}
+
+func TestSignedOAuthState(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         string
+		withCookie   bool
+		otherCookie  bool
+		provider     string
+		createdAt    time.Time
+		tamper       bool
+		expectedCode int
+	}{
+		{
+			name:         "signed state without a CSRF cookie",
+			mode:         options.OAuthStateSigned,
+			provider:     "providerID",
+			createdAt:    time.Now(),
+			expectedCode: http.StatusFound,
+		},
+		{
+			name:         "tampered signed state",
+			mode:         options.OAuthStateSigned,
+			provider:     "providerID",
+			createdAt:    time.Now(),
+			tamper:       true,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "expired signed state",
+			mode:         options.OAuthStateSigned,
+			provider:     "providerID",
+			createdAt:    time.Now().Add(-time.Hour),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "signed state for another provider",
+			mode:         options.OAuthStateSigned,
+			provider:     "otherProviderID",
+			createdAt:    time.Now(),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "signed state with a CSRF cookie",
+			mode:         options.OAuthStateSignedAndCookie,
+			withCookie:   true,
+			provider:     "providerID",
+			createdAt:    time.Now(),
+			expectedCode: http.StatusFound,
+		},
+		{
+			name:         "signed state requiring a CSRF cookie without one",
+			mode:         options.OAuthStateSignedAndCookie,
+			provider:     "providerID",
+			createdAt:    time.Now(),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "signed state with a CSRF cookie from another login",
+			mode:         options.OAuthStateSignedAndCookie,
+			withCookie:   true,
+			otherCookie:  true,
+			provider:     "providerID",
+			createdAt:    time.Now(),
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(patTest.Close)
+			patTest.proxy.oauthState = options.OAuthState{Mode: tc.mode, Expire: 15 * time.Minute}
+
+			csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+			assert.NoError(t, err)
+			state, err := csrf.NewOAuthState("/app", tc.provider).Encode(patTest.proxy.CookieOptions, tc.createdAt)
+			assert.NoError(t, err)
+			if tc.tamper {
+				state = "a" + state
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+			if tc.withCookie {
+				cookieCSRF := csrf
+				if tc.otherCookie {
+					cookieCSRF, err = cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+					assert.NoError(t, err)
+				}
+				csrfCookie, err := cookieCSRF.SetCookie(httptest.NewRecorder(), req)
+				assert.NoError(t, err)
+				req.AddCookie(csrfCookie)
+			}
+
+			rw := httptest.NewRecorder()
+			patTest.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode == http.StatusFound {
+				assert.Equal(t, "/app", rw.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestLoginRetry(t *testing.T) {
+	testCases := []struct {
+		name             string
+		mode             string
+		retries          int
+		otherCookie      bool
+		expectedCode     int
+		expectedLocation string
+	}{
+		{
+			name:             "without a CSRF cookie",
+			mode:             options.OAuthStateCookie,
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/oauth2/start?login_retry=1&rd=%2Fapp",
+		},
+		{
+			name:             "with a CSRF cookie from another login",
+			mode:             options.OAuthStateCookie,
+			retries:          1,
+			otherCookie:      true,
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/oauth2/start?login_retry=2&rd=%2Fapp",
+		},
+		{
+			name:         "once the retry limit is reached",
+			mode:         options.OAuthStateCookie,
+			retries:      2,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:             "with a signed state without a CSRF cookie",
+			mode:             options.OAuthStateSignedAndCookie,
+			retries:          1,
+			expectedCode:     http.StatusFound,
+			expectedLocation: "/oauth2/start?login_retry=2&rd=%2Fapp",
+		},
+		{
+			name:         "with a signed state once the retry limit is reached",
+			mode:         options.OAuthStateSignedAndCookie,
+			retries:      2,
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(patTest.Close)
+			patTest.proxy.oauthState = options.OAuthState{Mode: tc.mode, Expire: 15 * time.Minute}
+			patTest.proxy.loginRetryLimit = 2
+
+			csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+			assert.NoError(t, err)
+			state, err := patTest.proxy.encodeOAuthState(csrf, "/app", tc.retries, "")
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+			if tc.otherCookie {
+				otherCSRF, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+				assert.NoError(t, err)
+				csrfCookie, err := otherCSRF.SetCookie(httptest.NewRecorder(), req)
+				assert.NoError(t, err)
+				req.AddCookie(csrfCookie)
+			}
+
+			rw := httptest.NewRecorder()
+			patTest.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedLocation != "" {
+				assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+			}
+		})
 	}
-	req.AddCookie(&http.Cookie{
-		Name:     cookieName,
-		Value:    value,
-		Path:     "/",
-		Expires:  time.Now().Add(time.Duration(24)),
-		HttpOnly: true,
+
+	t.Run("carries the retries through the login flow", func(t *testing.T) {
+		patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(patTest.Close)
+		patTest.proxy.loginRetryLimit = 2
+
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp&login_retry=1", nil)
+		patTest.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		assert.NoError(t, err)
+
+		// The callback is given the state without the CSRF cookie
+		req = httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(loginURL.Query().Get("state")), nil)
+		rw = httptest.NewRecorder()
+		patTest.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/start?login_retry=2&rd=%2Fapp", rw.Header().Get("Location"))
 	})
 
-	rw := httptest.NewRecorder()
-	patTest.proxy.ServeHTTP(rw, req)
-	return rw.Code, rw.Body.String()
+	t.Run("with a successful login", func(t *testing.T) {
+		patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(patTest.Close)
+		patTest.proxy.loginRetryLimit = 2
+
+		csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.oauthState)
+		assert.NoError(t, err)
+		state, err := patTest.proxy.encodeOAuthState(csrf, "/app", 1, "")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+		csrfCookie, err := csrf.SetCookie(httptest.NewRecorder(), req)
+		assert.NoError(t, err)
+		req.AddCookie(csrfCookie)
+
+		rw := httptest.NewRecorder()
+		patTest.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/app", rw.Header().Get("Location"))
+	})
 }
 
-func TestForwardAccessTokenUpstream(t *testing.T) {
-	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
-		PassAccessToken: true,
-	})
+func TestOAuthStartSignedState(t *testing.T) {
+	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(patTest.Close)
+	patTest.proxy.oauthState = options.OAuthState{Mode: options.OAuthStateSigned, Expire: 15 * time.Minute}
 
-	// A successful validation will redirect and set the auth cookie.
-	code, cookie := patTest.getCallbackEndpoint()
-	if code != 302 {
-		t.Fatalf("expected 302; got %d", code)
-	}
-	assert.NotNil(t, cookie)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=%2Fapp", nil)
+	patTest.proxy.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusFound, rw.Code)
 
-	// Now we make a regular request; the access_token from the cookie is
-	// forwarded as the "X-Forwarded-Access-Token" header. The token is
-	// read by the test provider server and written in the response body.
-	code, payload := patTest.getEndpointWithCookie(cookie, "/")
-	if code != 200 {
-		t.Fatalf("expected 200; got %d", code)
-	}
-	assert.Equal(t, "my_auth_token", payload)
+	// The CSRF cookie isn't needed to verify signed states
+	assert.Empty(t, rw.Header().Values("Set-Cookie"))
+
+	loginURL, err := url.Parse(rw.Header().Get("Location"))
+	assert.NoError(t, err)
+	state, err := cookies.DecodeOAuthState(loginURL.Query().Get("state"), patTest.proxy.CookieOptions, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "/app", state.Redirect)
+	assert.Equal(t, "providerID", state.Provider)
 }
 
-func TestStaticProxyUpstream(t *testing.T) {
-	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
-		PassAccessToken: true,
-		ProxyUpstream: options.Upstream{
-			ID:     "static-proxy",
-			Path:   "/static-proxy",
-			Static: true,
-		},
-	})
+func TestReplayPostRequestAfterLogin(t *testing.T) {
+	redisServer, err := miniredis.Run()
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Cleanup(patTest.Close)
+	t.Cleanup(redisServer.Close)
 
-	// A successful validation will redirect and set the auth cookie.
-	code, cookie := patTest.getCallbackEndpoint()
-	if code != 302 {
-		t.Fatalf("expected 302; got %d", code)
-	}
-	assert.NotEqual(t, nil, cookie)
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			_, _ = w.Write([]byte(`{"access_token": "my_auth_token"}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Content-Type"), body)
+	}))
+	t.Cleanup(providerServer.Close)
 
-	// Now we make a regular request against the upstream proxy; And validate
-	// the returned status code through the static proxy.
-	code, payload := patTest.getEndpointWithCookie(cookie, "/static-proxy")
-	if code != 200 {
-		t.Fatalf("expected 200; got %d", code)
+	opts := baseTestOptions()
+	opts.UpstreamServers = options.Upstreams{
+		{
+			ID:   providerServer.URL,
+			Path: "/",
+			URI:  providerServer.URL,
+		},
 	}
-	assert.Equal(t, "Authenticated", payload)
-}
+	opts.Cookie.Secure = false
+	opts.Session.Type = options.RedisSessionStoreType
+	opts.Session.Redis.ConnectionURL = "redis://" + redisServer.Addr()
+	opts.SkipProviderButton = true
+	opts.RequestReplay.Enabled = true
+	err = validation.Validate(opts)
+	assert.NoError(t, err)
 
-func TestDoNotForwardAccessTokenUpstream(t *testing.T) {
-	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
-		PassAccessToken: false,
-	})
+	providerURL, _ := url.Parse(providerServer.URL)
+	opts.SetProvider(NewTestProvider(providerURL, "michael.bland@gsa.gov"))
+	proxy, err := NewOAuthProxy(opts, func(email string) bool { return true })
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Cleanup(patTest.Close)
 
-	// A successful validation will redirect and set the auth cookie.
-	code, cookie := patTest.getCallbackEndpoint()
-	if code != 302 {
-		t.Fatalf("expected 302; got %d", code)
+	// jar keeps the cookies set by the proxy between requests
+	jar := map[string]*http.Cookie{}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		for _, c := range jar {
+			req.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		for _, c := range rw.Result().Cookies() {
+			if c.Value == "" {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c
+			}
+		}
+		return rw
 	}
-	assert.NotEqual(t, nil, cookie)
 
-	// Now we make a regular request, but the access token header should
-	// not be present.
-	code, payload := patTest.getEndpointWithCookie(cookie, "/")
-	if code != 200 {
-		t.Fatalf("expected 200; got %d", code)
-	}
-	assert.Equal(t, "No access token found.", payload)
+	// The POST starts the login flow
+	req := httptest.NewRequest(http.MethodPost, "/form?step=2", strings.NewReader("name=value"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://example.com")
+	rw := serve(req)
+	assert.Equal(t, http.StatusFound, rw.Code)
+	loginURL, err := url.Parse(rw.Header().Get("Location"))
+	assert.NoError(t, err)
+
+	rw = serve(httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=callback_code&state="+url.QueryEscape(loginURL.Query().Get("state")), nil))
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/form?step=2", rw.Header().Get("Location"))
+	assert.Contains(t, jar, "_oauth2_proxy_replay")
+
+	// The redirect after the login replays the POST
+	rw = serve(httptest.NewRequest(http.MethodGet, "/form?step=2", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "POST application/x-www-form-urlencoded name=value", rw.Body.String())
+	assert.NotContains(t, jar, "_oauth2_proxy_replay")
+
+	// The stashed request is only replayed once
+	rw = serve(httptest.NewRequest(http.MethodGet, "/form?step=2", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "GET  ", rw.Body.String())
 }
 
 type SignInPageTest struct {
@@ -915,6 +2050,225 @@ func TestUserInfoEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, test.rw.Code)
 }
 
+func NewDebugHeadersEndpointTest(modifiers ...OptionsModifier) (*ProcessCookieTest, error) {
+	modifiers = append([]OptionsModifier{func(opts *options.Options) {
+		opts.Debug.HeadersEndpoint = true
+		opts.InjectRequestHeaders = []options.Header{
+			{
+				Name: "X-Forwarded-User",
+				Values: []options.HeaderValue{
+					{ClaimSource: &options.ClaimSource{Claim: "user"}},
+				},
+			},
+			{
+				Name: "X-Forwarded-Groups",
+				Values: []options.HeaderValue{
+					{ClaimSource: &options.ClaimSource{Claim: "groups"}},
+				},
+			},
+			{
+				Name: "X-Forwarded-Access-Token",
+				Values: []options.HeaderValue{
+					{ClaimSource: &options.ClaimSource{Claim: "access_token"}},
+				},
+			},
+			{
+				Name: "Authorization",
+				Values: []options.HeaderValue{
+					{ClaimSource: &options.ClaimSource{
+						Claim:             "user",
+						BasicAuthPassword: &options.SecretSource{Value: []byte("password")},
+					}},
+				},
+			},
+		}
+	}}, modifiers...)
+
+	pcTest, err := NewProcessCookieTestWithOptionsModifiers(modifiers...)
+	if err != nil {
+		return nil, err
+	}
+	pcTest.req, _ = http.NewRequest("GET",
+		pcTest.opts.ProxyPrefix+"/debug/headers", nil)
+	return pcTest, nil
+}
+
+func TestDebugHeadersEndpoint(t *testing.T) {
+	testCases := []struct {
+		name             string
+		showTokens       bool
+		expectedResponse map[string]string
+	}{
+		{
+			name:       "With tokens redacted",
+			showTokens: false,
+			expectedResponse: map[string]string{
+				"X-Forwarded-User":         "john.doe",
+				"X-Forwarded-Groups":       "example,groups",
+				"X-Forwarded-Access-Token": "[REDACTED]",
+				"Authorization":            "[REDACTED]",
+			},
+		},
+		{
+			name:       "With tokens shown",
+			showTokens: true,
+			expectedResponse: map[string]string{
+				"X-Forwarded-User":         "john.doe",
+				"X-Forwarded-Groups":       "example,groups",
+				"X-Forwarded-Access-Token": "my_access_token",
+				"Authorization":            "Basic am9obi5kb2U6cGFzc3dvcmQ=",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, err := NewDebugHeadersEndpointTest(func(opts *options.Options) {
+				opts.Debug.ShowTokens = tc.showTokens
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = test.SaveSession(&sessions.SessionState{
+				User:        "john.doe",
+				Email:       "john.doe@example.com",
+				Groups:      []string{"example", "groups"},
+				AccessToken: "my_access_token",
+			})
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, http.StatusOK, test.rw.Code)
+			assert.Equal(t, applicationJSON, test.rw.Header().Get("Content-Type"))
+
+			var headers map[string]string
+			assert.NoError(t, json.Unmarshal(test.rw.Body.Bytes(), &headers))
+			assert.Equal(t, tc.expectedResponse, headers)
+		})
+	}
+}
+
+func TestDebugHeadersEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
+	test, err := NewDebugHeadersEndpointTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusUnauthorized, test.rw.Code)
+}
+
+func TestDebugHeadersEndpointDisabled(t *testing.T) {
+	test, err := NewDebugHeadersEndpointTest(func(opts *options.Options) {
+		opts.Debug.HeadersEndpoint = false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = test.SaveSession(&sessions.SessionState{User: "john.doe", Email: "john.doe@example.com"})
+	assert.NoError(t, err)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusNotFound, test.rw.Code)
+}
+
+func NewUpstreamTokenEndpointTest(t *testing.T, modifiers ...OptionsModifier) *ProcessCookieTest {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "upstream_token.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	modifiers = append([]OptionsModifier{func(opts *options.Options) {
+		opts.UpstreamToken.KeyFile = keyFile
+		opts.UpstreamToken.Audience = "https://api.example.com"
+	}}, modifiers...)
+	pcTest, err := NewProcessCookieTestWithOptionsModifiers(modifiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcTest.req, _ = http.NewRequest("POST", pcTest.opts.ProxyPrefix+"/upstream_token", nil)
+	return pcTest
+}
+
+func TestUpstreamTokenEndpoint(t *testing.T) {
+	created := time.Now()
+	session := &sessions.SessionState{
+		User:        "john.doe",
+		Email:       "john.doe@example.com",
+		Groups:      []string{"example"},
+		AccessToken: "my_access_token",
+		CreatedAt:   &created,
+	}
+
+	t.Run("mints a token for the session", func(t *testing.T) {
+		test := NewUpstreamTokenEndpointTest(t)
+		assert.NoError(t, test.SaveSession(session))
+
+		test.proxy.ServeHTTP(test.rw, test.req)
+		assert.Equal(t, http.StatusOK, test.rw.Code)
+
+		var tokenResponse struct {
+			AccessToken string `json:"access_token"`
+			TokenType   string `json:"token_type"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}
+		assert.NoError(t, json.NewDecoder(test.rw.Body).Decode(&tokenResponse))
+		assert.Equal(t, "Bearer", tokenResponse.TokenType)
+		assert.InDelta(t, (5 * time.Minute).Seconds(), tokenResponse.ExpiresIn, 1)
+		assert.NotContains(t, tokenResponse.AccessToken, "my_access_token")
+
+		claims := jwt.MapClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(tokenResponse.AccessToken, claims)
+		assert.NoError(t, err)
+		assert.Equal(t, "john.doe", claims["sub"])
+		assert.Equal(t, "https://api.example.com", claims["aud"])
+		assert.Equal(t, "john.doe@example.com", claims["email"])
+		assert.Equal(t, []interface{}{"example"}, claims["groups"])
+	})
+
+	t.Run("rejects requests without a session", func(t *testing.T) {
+		test := NewUpstreamTokenEndpointTest(t)
+
+		test.proxy.ServeHTTP(test.rw, test.req)
+		assert.Equal(t, http.StatusUnauthorized, test.rw.Code)
+	})
+
+	t.Run("rejects requests over the rate limit", func(t *testing.T) {
+		test := NewUpstreamTokenEndpointTest(t, func(opts *options.Options) {
+			opts.UpstreamToken.RateLimit = 1
+		})
+		assert.NoError(t, test.SaveSession(session))
+
+		test.proxy.ServeHTTP(test.rw, test.req)
+		assert.Equal(t, http.StatusOK, test.rw.Code)
+
+		rw := httptest.NewRecorder()
+		test.proxy.ServeHTTP(rw, test.req)
+		assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+		assert.Equal(t, "60", rw.Header().Get("Retry-After"))
+	})
+
+	t.Run("serves the key set", func(t *testing.T) {
+		test := NewUpstreamTokenEndpointTest(t)
+		req, _ := http.NewRequest("GET", test.opts.ProxyPrefix+"/upstream_token/jwks", nil)
+
+		test.proxy.ServeHTTP(test.rw, req)
+		assert.Equal(t, http.StatusOK, test.rw.Code)
+
+		var jwks struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		assert.NoError(t, json.NewDecoder(test.rw.Body).Decode(&jwks))
+		assert.Len(t, jwks.Keys, 1)
+		assert.Equal(t, "RS256", jwks.Keys[0]["alg"])
+	})
+}
+
 func NewAuthOnlyEndpointTest(querystring string, modifiers ...OptionsModifier) (*ProcessCookieTest, error) {
 	pcTest, err := NewProcessCookieTestWithOptionsModifiers(modifiers...)
 	if err != nil {
@@ -933,16 +2287,54 @@ func TestAuthOnlyEndpointAccepted(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	created := time.Now()
-	startSession := &sessions.SessionState{
-		Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
-	err = test.SaveSession(startSession)
-	assert.NoError(t, err)
+	created := time.Now()
+	startSession := &sessions.SessionState{
+		Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
+	err = test.SaveSession(startSession)
+	assert.NoError(t, err)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusAccepted, test.rw.Code)
+	bodyBytes, _ := ioutil.ReadAll(test.rw.Body)
+	assert.Equal(t, "", string(bodyBytes))
+}
+
+func TestAuthOnlyEndpointSessionBinding(t *testing.T) {
+	testCases := map[string]struct {
+		userAgent    string
+		expectedCode int
+	}{
+		"same user agent": {
+			userAgent:    "Firefox",
+			expectedCode: http.StatusAccepted,
+		},
+		"different user agent": {
+			userAgent:    "curl",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest("", func(opts *options.Options) {
+				opts.Session.BindUserAgent = true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created := time.Now()
+			test.req.Header.Set("User-Agent", "Firefox")
+			err = test.SaveSession(&sessions.SessionState{
+				Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created})
+			assert.NoError(t, err)
 
-	test.proxy.ServeHTTP(test.rw, test.req)
-	assert.Equal(t, http.StatusAccepted, test.rw.Code)
-	bodyBytes, _ := ioutil.ReadAll(test.rw.Body)
-	assert.Equal(t, "", string(bodyBytes))
+			test.rw = httptest.NewRecorder()
+			test.req.Header.Set("User-Agent", tc.userAgent)
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+		})
+	}
 }
 
 func TestAuthOnlyEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
@@ -1076,6 +2468,78 @@ func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 	assert.Equal(t, "oauth_user@example.com", pcTest.rw.Header().Get("X-Auth-Request-Email"))
 }
 
+func TestAuthOnlyEndpointAuthResponseHeaders(t *testing.T) {
+	var pcTest ProcessCookieTest
+
+	pcTest.opts = baseTestOptions()
+	pcTest.opts.InjectResponseHeaders = []options.Header{
+		{
+			Name: "X-Auth-Request-User",
+			Values: []options.HeaderValue{
+				{
+					ClaimSource: &options.ClaimSource{
+						Claim: "user",
+					},
+				},
+			},
+		},
+	}
+	pcTest.opts.AuthResponseHeaders = []options.Header{
+		{
+			Name: "X-Email",
+			Values: []options.HeaderValue{
+				{
+					ClaimSource: &options.ClaimSource{
+						Claim: "email",
+					},
+				},
+			},
+		},
+		{
+			Name: "X-Groups",
+			Values: []options.HeaderValue{
+				{
+					ClaimSource: &options.ClaimSource{
+						Claim: "groups",
+					},
+				},
+			},
+		},
+	}
+	err := validation.Validate(pcTest.opts)
+	assert.NoError(t, err)
+
+	pcTest.proxy, err = NewOAuthProxy(pcTest.opts, func(email string) bool {
+		return pcTest.validateUser
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcTest.proxy.provider = &TestProvider{
+		ProviderData: &providers.ProviderData{},
+		ValidToken:   true,
+	}
+
+	pcTest.validateUser = true
+
+	pcTest.rw = httptest.NewRecorder()
+	pcTest.req, _ = http.NewRequest("GET",
+		pcTest.opts.ProxyPrefix+"/auth", nil)
+
+	created := time.Now()
+	startSession := &sessions.SessionState{
+		User: "oauth_user", Groups: []string{"oauth_groups", "other_groups"}, Email: "oauth_user@example.com", AccessToken: "oauth_token", CreatedAt: &created}
+	err = pcTest.SaveSession(startSession)
+	assert.NoError(t, err)
+
+	pcTest.proxy.ServeHTTP(pcTest.rw, pcTest.req)
+	assert.Equal(t, http.StatusAccepted, pcTest.rw.Code)
+	assert.Equal(t, "oauth_user@example.com", pcTest.rw.Header().Get("X-Email"))
+	assert.Equal(t, "oauth_groups,other_groups", pcTest.rw.Header().Get("X-Groups"))
+	// The auth response headers replace the injected response headers
+	assert.Empty(t, pcTest.rw.Header().Values("X-Auth-Request-User"))
+}
+
 func TestAuthOnlyEndpointSetBasicAuthTrueRequestHeaders(t *testing.T) {
 	var pcTest ProcessCookieTest
 
@@ -1850,6 +3314,66 @@ func Test_noCacheHeaders(t *testing.T) {
 	})
 }
 
+func Test_noCacheHeadersOnLoginResponses(t *testing.T) {
+	tests := []struct {
+		name               string
+		method             string
+		headers            map[string]string
+		skipProviderButton bool
+		expectedCode       int
+	}{
+		{
+			name:         "SignInPage",
+			method:       http.MethodGet,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:               "LoginRedirect",
+			method:             http.MethodGet,
+			skipProviderButton: true,
+			expectedCode:       http.StatusFound,
+		},
+		{
+			name:         "AjaxUnauthorized",
+			method:       http.MethodGet,
+			headers:      map[string]string{"Accept": "application/json"},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "HeadRequest",
+			method:       http.MethodHead,
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.SkipProviderButton = tt.skipProviderButton
+			opts.HeadRequestHandling = options.HeadRequestStatus
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+			proxy, err := NewOAuthProxy(opts, func(_ string) bool { return true })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/upstream", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			proxy.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			for k, v := range noCacheHeaders {
+				assert.Equal(t, v, rec.Header().Get(k), k)
+			}
+			assert.Contains(t, rec.Header().Get("Cache-Control"), "no-store")
+		})
+	}
+}
+
 func baseTestOptions() *options.Options {
 	opts := options.NewOptions()
 	opts.Cookie.Secret = rawCookieSecret
@@ -1898,6 +3422,253 @@ func baseTestOptions() *options.Options {
 	return opts
 }
 
+func TestServerTimingHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		serverTiming   options.ServerTiming
+		remoteAddr     string
+		requestHeader  bool
+		expectedHeader bool
+	}{
+		{
+			name:           "Disabled",
+			remoteAddr:     "127.0.0.1:43670",
+			requestHeader:  true,
+			expectedHeader: false,
+		},
+		{
+			name:           "EnabledForAllRequests",
+			serverTiming:   options.ServerTiming{Enabled: true},
+			remoteAddr:     "192.168.0.1:43670",
+			expectedHeader: true,
+		},
+		{
+			name: "RequestedByTrustedClient",
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+				TrustedIPs:    []string{"127.0.0.1"},
+			},
+			remoteAddr:     "127.0.0.1:43670",
+			requestHeader:  true,
+			expectedHeader: true,
+		},
+		{
+			name: "NotRequestedByTrustedClient",
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+				TrustedIPs:    []string{"127.0.0.1"},
+			},
+			remoteAddr:     "127.0.0.1:43670",
+			requestHeader:  false,
+			expectedHeader: false,
+		},
+		{
+			name: "RequestedByUntrustedClient",
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+				TrustedIPs:    []string{"127.0.0.1"},
+			},
+			remoteAddr:     "192.168.0.1:43670",
+			requestHeader:  true,
+			expectedHeader: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.UpstreamServers = options.Upstreams{
+				{
+					ID:     "static",
+					Path:   "/",
+					Static: true,
+				},
+			}
+			opts.SkipAuthRoutes = []string{"^/$"}
+			opts.ServerTiming = tt.serverTiming
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			assert.NoError(t, err)
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.requestHeader {
+				req.Header.Set("X-Debug-Timing", "1")
+			}
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, 200, rw.Code)
+			if tt.expectedHeader {
+				assert.Contains(t, rw.Header().Get("Server-Timing"), "upstream;dur=")
+			} else {
+				assert.Empty(t, rw.Header().Values("Server-Timing"))
+			}
+		})
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		ipFilter     options.IPFilter
+		remoteAddr   string
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "Disabled",
+			remoteAddr:   "192.168.0.1:43670",
+			path:         "/",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "AllowedClient",
+			ipFilter:     options.IPFilter{Allowlist: []string{"127.0.0.0/8"}},
+			remoteAddr:   "127.0.0.1:43670",
+			path:         "/",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "ClientNotAllowed",
+			ipFilter:     options.IPFilter{Allowlist: []string{"127.0.0.0/8"}},
+			remoteAddr:   "192.168.0.1:43670",
+			path:         "/",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "DeniedClient",
+			ipFilter:     options.IPFilter{Denylist: []string{"192.168.0.0/16"}},
+			remoteAddr:   "192.168.0.1:43670",
+			path:         "/",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name: "AllowedAndDeniedClient",
+			ipFilter: options.IPFilter{
+				Allowlist: []string{"127.0.0.0/8"},
+				Denylist:  []string{"127.0.0.1"},
+			},
+			remoteAddr:   "127.0.0.1:43670",
+			path:         "/",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "SignInPageNotAllowed",
+			ipFilter:     options.IPFilter{Allowlist: []string{"127.0.0.0/8"}},
+			remoteAddr:   "192.168.0.1:43670",
+			path:         "/oauth2/sign_in",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "HealthCheckNotAllowed",
+			ipFilter:     options.IPFilter{Allowlist: []string{"127.0.0.0/8"}},
+			remoteAddr:   "192.168.0.1:43670",
+			path:         "/ping",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.UpstreamServers = options.Upstreams{
+				{
+					ID:     "static",
+					Path:   "/",
+					Static: true,
+				},
+			}
+			opts.SkipAuthRoutes = []string{"^/$"}
+			opts.IPFilter = tt.ipFilter
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			assert.NoError(t, err)
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tt.expectedCode, rw.Code)
+		})
+	}
+}
+
+func TestHeadRequestHandling(t *testing.T) {
+	tests := []struct {
+		name                string
+		headRequestHandling string
+		method              string
+		expectedCode        int
+		expectedBody        bool
+	}{
+		{
+			name:                "LoginHead",
+			headRequestHandling: options.HeadRequestLogin,
+			method:              http.MethodHead,
+			expectedCode:        http.StatusForbidden,
+			expectedBody:        true,
+		},
+		{
+			name:                "StatusHead",
+			headRequestHandling: options.HeadRequestStatus,
+			method:              http.MethodHead,
+			expectedCode:        http.StatusUnauthorized,
+			expectedBody:        false,
+		},
+		{
+			name:                "StatusGet",
+			headRequestHandling: options.HeadRequestStatus,
+			method:              http.MethodGet,
+			expectedCode:        http.StatusForbidden,
+			expectedBody:        true,
+		},
+		{
+			name:                "OKHead",
+			headRequestHandling: options.HeadRequestOK,
+			method:              http.MethodHead,
+			expectedCode:        http.StatusOK,
+			expectedBody:        false,
+		},
+		{
+			name:                "OKGet",
+			headRequestHandling: options.HeadRequestOK,
+			method:              http.MethodGet,
+			expectedCode:        http.StatusForbidden,
+			expectedBody:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.HeadRequestHandling = tt.headRequestHandling
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			assert.NoError(t, err)
+
+			req, _ := http.NewRequest(tt.method, "/", nil)
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, tt.expectedCode, rw.Code)
+			assert.Empty(t, rw.Header().Get("Location"))
+			if tt.expectedBody {
+				assert.NotEmpty(t, rw.Body.String())
+			} else {
+				assert.Empty(t, rw.Body.String())
+			}
+		})
+	}
+}
+
 func TestTrustedIPs(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -2317,6 +4088,83 @@ func TestAllowedRequest(t *testing.T) {
 	}
 }
 
+func TestAllowedRequestTrailingSlashPolicy(t *testing.T) {
+	testCases := []struct {
+		name      string
+		url       string
+		strict    bool
+		normalize bool
+	}{
+		{
+			name:      "Exact path",
+			url:       "/app",
+			strict:    true,
+			normalize: true,
+		},
+		{
+			name:      "Exact path with a trailing slash",
+			url:       "/app/",
+			strict:    false,
+			normalize: true,
+		},
+		{
+			name:      "Longer path sharing the prefix",
+			url:       "/application",
+			strict:    false,
+			normalize: false,
+		},
+		{
+			name:      "Nested path",
+			url:       "/app/users",
+			strict:    false,
+			normalize: false,
+		},
+		{
+			name:      "Directory route without the trailing slash",
+			url:       "/docs",
+			strict:    false,
+			normalize: true,
+		},
+		{
+			name:      "Directory route",
+			url:       "/docs/",
+			strict:    true,
+			normalize: true,
+		},
+		{
+			name:      "Root path",
+			url:       "/",
+			strict:    false,
+			normalize: false,
+		},
+	}
+
+	for _, policy := range []string{options.TrailingSlashStrict, options.TrailingSlashNormalize} {
+		opts := baseTestOptions()
+		opts.SkipAuthRoutes = []string{"GET=^/app$", "^/docs/$"}
+		opts.TrailingSlashPolicy = policy
+		err := validation.Validate(opts)
+		assert.NoError(t, err)
+		proxy, err := NewOAuthProxy(opts, func(_ string) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range testCases {
+			t.Run(policy+" "+tc.name, func(t *testing.T) {
+				req, err := http.NewRequest("GET", tc.url, nil)
+				assert.NoError(t, err)
+
+				expected := tc.strict
+				if policy == options.TrailingSlashNormalize {
+					expected = tc.normalize
+				}
+				assert.Equal(t, expected, proxy.isAllowedRoute(req))
+			})
+		}
+	}
+}
+
 func TestProxyAllowedGroups(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 )
@@ -39,8 +40,30 @@ type RequestScope struct {
 	// it was loaded or not.
 	SessionRevalidated bool
 
+	// SessionDegraded indicates whether the session is being served although
+	// it could not be refreshed with the provider, eg. during a provider
+	// outage.
+	SessionDegraded bool
+
 	// Upstream tracks which upstream was used for this request
 	Upstream string
+
+	// Timings tracks how long was spent in each stage of handling the request
+	Timings Timings
+}
+
+// Timings records when the stages of handling a request started and how long
+// they took, so that they can be reported in the Server-Timing header.
+type Timings struct {
+	// Started is when the request was received
+	Started time.Time
+
+	// Refresh is the time spent refreshing the session, if it was refreshed
+	Refresh time.Duration
+
+	// UpstreamStarted is when the request was passed to the upstream, if it
+	// was proxied
+	UpstreamStarted time.Time
 }
 
 // GetRequestScope returns the current request scope from the given request
@@ -29,6 +29,26 @@ type AlphaOptions struct {
 	// or from a static secret value.
 	InjectResponseHeaders []Header `json:"injectResponseHeaders,omitempty"`
 
+	// AuthResponseHeaders is used to configure the headers that are added to
+	// the responses of the auth endpoint (`/oauth2/auth`) for authenticated
+	// and authorized requests, so that a proxy such as NGINX can forward
+	// exactly the identity it needs.
+	// When set, these headers replace the InjectResponseHeaders on the
+	// responses of the auth endpoint.
+	// Headers may source values from either the authenticated user's session
+	// or from a static secret value.
+	AuthResponseHeaders []Header `json:"authResponseHeaders,omitempty"`
+
+	// ClaimTransformations transform the claims of the user's session, eg. to
+	// extract the CN of groups given as DNs or to lowercase emails, before
+	// the session is stored and its claims are injected into headers.
+	ClaimTransformations []ClaimTransformation `json:"claimTransformations,omitempty"`
+
+	// FeatureFlags are enabled for the sessions of users based on their
+	// claims, eg. their groups, and are injected into headers from the
+	// `feature_flags` claim.
+	FeatureFlags []FeatureFlag `json:"featureFlags,omitempty"`
+
 	// Server is used to configure the HTTP(S) server for the proxy application.
 	// You may choose to run both HTTP and HTTPS servers simultaneously.
 	// This can be done by setting the BindAddress and the SecureBindAddress simultaneously.
@@ -51,6 +71,9 @@ func (a *AlphaOptions) MergeInto(opts *Options) {
 	opts.UpstreamServers = a.Upstreams
 	opts.InjectRequestHeaders = a.InjectRequestHeaders
 	opts.InjectResponseHeaders = a.InjectResponseHeaders
+	opts.AuthResponseHeaders = a.AuthResponseHeaders
+	opts.ClaimTransformations = a.ClaimTransformations
+	opts.FeatureFlags = a.FeatureFlags
 	opts.Server = a.Server
 	opts.MetricsServer = a.MetricsServer
 	opts.Providers = a.Providers
@@ -63,6 +86,9 @@ func (a *AlphaOptions) ExtractFrom(opts *Options) {
 	a.Upstreams = opts.UpstreamServers
 	a.InjectRequestHeaders = opts.InjectRequestHeaders
 	a.InjectResponseHeaders = opts.InjectResponseHeaders
+	a.AuthResponseHeaders = opts.AuthResponseHeaders
+	a.ClaimTransformations = opts.ClaimTransformations
+	a.FeatureFlags = opts.FeatureFlags
 	a.Server = opts.Server
 	a.MetricsServer = opts.MetricsServer
 	a.Providers = opts.Providers
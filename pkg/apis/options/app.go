@@ -24,6 +24,12 @@ type Templates struct {
 	// Footer overrides the default sign_in page footer text.
 	Footer string `flag:"footer" cfg:"footer"`
 
+	// UpstreamErrorPath is the path to a template that should be used to render
+	// errors when an upstream server cannot be reached (502) or does not respond
+	// in time (504).
+	// If unset, the error page template will be used.
+	UpstreamErrorPath string `flag:"custom-upstream-error-template" cfg:"custom_upstream_error_template"`
+
 	// DisplayLoginForm determines whether the sign_in page should render a
 	// password form if a static passwords file (htpasswd file) has been
 	// configured.
@@ -43,6 +49,7 @@ func templatesFlagSet() *pflag.FlagSet {
 	flagSet.String("custom-sign-in-logo", "", "path to an custom image for the sign_in page logo. Use \"-\" to disable default logo.")
 	flagSet.String("banner", "", "custom banner string. Use \"-\" to disable default banner.")
 	flagSet.String("footer", "", "custom footer string. Use \"-\" to disable default footer.")
+	flagSet.String("custom-upstream-error-template", "", "path to a custom html template for upstream connection (502) and timeout (504) errors")
 	flagSet.Bool("display-htpasswd-form", true, "display username / password login form if an htpasswd file is provided")
 	flagSet.Bool("show-debug-on-error", false, "show detailed error information on error pages (WARNING: this may contain sensitive information - do not use in production)")
 
@@ -0,0 +1,70 @@
+package options
+
+const (
+	// ClaimTransformLowercase lowercases each value of the claim.
+	ClaimTransformLowercase = "lowercase"
+
+	// ClaimTransformRegex replaces each value of the claim with a group
+	// captured by a regular expression.
+	ClaimTransformRegex = "regex"
+
+	// ClaimTransformSplit splits each value of the claim into multiple values.
+	ClaimTransformSplit = "split"
+
+	// ClaimTransformJoin joins the values of the claim into a single value.
+	ClaimTransformJoin = "join"
+
+	// ClaimTransformMap replaces values of the claim using a map of values.
+	ClaimTransformMap = "map"
+)
+
+// ClaimTransformation transforms the values of a claim of the session when
+// the user logs in, and when the session is refreshed, before the session is
+// stored and the claim is injected into headers.
+type ClaimTransformation struct {
+	// Claim is the name of the claim in the session to transform.
+	// It must be one of `user`, `email`, `groups` or `preferred_username`.
+	Claim string `json:"claim,omitempty"`
+
+	// Transformations are applied to the values of the claim in the order
+	// they are listed, each transforming the result of the one before it.
+	// If any transformation fails, eg. because a value does not match a
+	// regex, the error is logged and the claim keeps its raw value.
+	Transformations []Transformation `json:"transformations,omitempty"`
+}
+
+// Transformation is a single transformation of the values of a claim.
+type Transformation struct {
+	// Type is the type of the transformation.
+	// Valid values are:
+	// - `lowercase`: Lowercase each value
+	// - `regex`: Replace each value with the Group captured by the Pattern,
+	// eg. to extract the CN of a group given as a DN. Values that do not
+	// match the Pattern fail the transformation
+	// - `split`: Split each value on the Separator into multiple values
+	// - `join`: Join the values with the Separator into a single value
+	// - `map`: Replace each value that is a key of the Values with the value
+	// it maps to. Other values are not changed
+	// Claims other than groups must have a single value once all of their
+	// transformations have been applied, eg. a split must be followed by a
+	// join.
+	Type string `json:"type,omitempty"`
+
+	// Pattern is the regular expression that values are matched against.
+	// This is required for the regex type.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Group is the index of the group captured by the Pattern that replaces
+	// the value, with 0 as the whole match.
+	// This option can only be used with the regex type.
+	// Defaults to 1.
+	Group *int `json:"group,omitempty"`
+
+	// Separator is used to split or join the values.
+	// This is required for the split and join types.
+	Separator string `json:"separator,omitempty"`
+
+	// Values maps values of the claim to the values that replace them.
+	// This is required for the map type.
+	Values map[string]string `json:"values,omitempty"`
+}
@@ -0,0 +1,49 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// Compression contains the options for compressing responses from upstreams
+type Compression struct {
+	// Enabled enables gzip compression of upstream responses for clients
+	// that accept it.
+	Enabled bool `flag:"compress-responses" cfg:"compress_responses"`
+
+	// MinSize is the minimum size in bytes of a response body before it will
+	// be compressed.
+	MinSize int `flag:"compress-min-size" cfg:"compress_min_size"`
+
+	// ContentTypes is the list of media types that should be compressed.
+	// Types may use a wildcard subtype, eg. "text/*".
+	ContentTypes []string `flag:"compress-content-type" cfg:"compress_content_types"`
+}
+
+func compressionFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("compression", pflag.ExitOnError)
+
+	flagSet.Bool("compress-responses", false, "gzip compress upstream responses for clients that accept it")
+	flagSet.Int("compress-min-size", defaultCompressionMinSize, "the minimum size in bytes of an upstream response before it is compressed")
+	flagSet.StringSlice("compress-content-type", defaultCompressionContentTypes, "the media types of upstream responses that should be compressed (may be given multiple times)")
+
+	return flagSet
+}
+
+const defaultCompressionMinSize = 1024
+
+// defaultCompressionContentTypes are text based types that benefit from
+// compression. Images, video and archives are already compressed.
+var defaultCompressionContentTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressionDefaults creates a Compression and populates it with any default values
+func compressionDefaults() Compression {
+	return Compression{
+		Enabled:      false,
+		MinSize:      defaultCompressionMinSize,
+		ContentTypes: defaultCompressionContentTypes,
+	}
+}
@@ -8,22 +8,43 @@ import (
 
 // Cookie contains configuration options relating to Cookie configuration
 type Cookie struct {
-	Name     string        `flag:"cookie-name" cfg:"cookie_name"`
-	Secret   string        `flag:"cookie-secret" cfg:"cookie_secret"`
-	Domains  []string      `flag:"cookie-domain" cfg:"cookie_domains"`
-	Path     string        `flag:"cookie-path" cfg:"cookie_path"`
-	Expire   time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
-	Refresh  time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
-	Secure   bool          `flag:"cookie-secure" cfg:"cookie_secure"`
-	HTTPOnly bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
-	SameSite string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
+	Name             string        `flag:"cookie-name" cfg:"cookie_name"`
+	Secret           string        `flag:"cookie-secret" cfg:"cookie_secret"`
+	SecretPassphrase string        `flag:"cookie-secret-passphrase" cfg:"cookie_secret_passphrase"`
+	SecretKDF        string        `flag:"cookie-secret-kdf" cfg:"cookie_secret_kdf"`
+	SecretKDFSalt    string        `flag:"cookie-secret-kdf-salt" cfg:"cookie_secret_kdf_salt"`
+	SecretKDFParams  string        `flag:"cookie-secret-kdf-params" cfg:"cookie_secret_kdf_params"`
+	PreviousSecrets  []string      `flag:"cookie-previous-secret" cfg:"cookie_previous_secrets"`
+	Domains          []string      `flag:"cookie-domain" cfg:"cookie_domains"`
+	Path             string        `flag:"cookie-path" cfg:"cookie_path"`
+	Expire           time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
+	Refresh          time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
+	Secure           bool          `flag:"cookie-secure" cfg:"cookie_secure"`
+	HTTPOnly         bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
+	SameSite         string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
+	ValueEncoding    string        `flag:"cookie-value-encoding" cfg:"cookie_value_encoding"`
 }
 
+const (
+	// CookieValueEncodingBase64 encodes signed cookie values with the
+	// URL-safe base64 alphabet and `=` padding.
+	CookieValueEncodingBase64 = "base64"
+
+	// CookieValueEncodingBase64URL encodes signed cookie values with the
+	// URL-safe base64 alphabet without padding.
+	CookieValueEncodingBase64URL = "base64url"
+)
+
 func cookieFlagSet() *pflag.FlagSet {
 	flagSet := pflag.NewFlagSet("cookie", pflag.ExitOnError)
 
 	flagSet.String("cookie-name", "_oauth2_proxy", "the name of the cookie that the oauth_proxy creates")
 	flagSet.String("cookie-secret", "", "the seed string for secure cookies (optionally base64 encoded)")
+	flagSet.String("cookie-secret-passphrase", "", "a passphrase of any length to derive the cookie secret from, instead of setting --cookie-secret")
+	flagSet.String("cookie-secret-kdf", "scrypt", "the key derivation function used to derive the cookie secret from the passphrase (\"scrypt\" or \"argon2id\")")
+	flagSet.String("cookie-secret-kdf-salt", "oauth2-proxy", "the salt used to derive the cookie secret from the passphrase; must be the same on every instance")
+	flagSet.String("cookie-secret-kdf-params", "", "the parameters of the key derivation function as comma separated key=value pairs (eg. \"n=32768,r=8,p=1\" for scrypt or \"t=3,m=65536,p=4\" for argon2id); must be the same on every instance")
+	flagSet.StringSlice("cookie-previous-secret", []string{}, "previous cookie secrets that cookie session store sessions are still loaded with, in order, while rotating --cookie-secret (may be given multiple times)")
 	flagSet.StringSlice("cookie-domain", []string{}, "Optional cookie domains to force cookies to (ie: `.yourcompany.com`). The longest domain matching the request's host will be used (or the shortest cookie domain if there is no match).")
 	flagSet.String("cookie-path", "/", "an optional cookie path to force cookies to (ie: /poc/)*")
 	flagSet.Duration("cookie-expire", time.Duration(168)*time.Hour, "expire timeframe for cookie")
@@ -31,6 +52,7 @@ func cookieFlagSet() *pflag.FlagSet {
 	flagSet.Bool("cookie-secure", true, "set secure (HTTPS) cookie flag")
 	flagSet.Bool("cookie-httponly", true, "set HttpOnly cookie flag")
 	flagSet.String("cookie-samesite", "", "set SameSite cookie attribute (ie: \"lax\", \"strict\", \"none\", or \"\"). ")
+	flagSet.String("cookie-value-encoding", CookieValueEncodingBase64, "the encoding of signed cookie values (\"base64\" with padding or \"base64url\" without padding). Cookies with either encoding are accepted")
 
 	return flagSet
 }
@@ -38,14 +60,17 @@ func cookieFlagSet() *pflag.FlagSet {
 // cookieDefaults creates a Cookie populating each field with its default value
 func cookieDefaults() Cookie {
 	return Cookie{
-		Name:     "_oauth2_proxy",
-		Secret:   "",
-		Domains:  nil,
-		Path:     "/",
-		Expire:   time.Duration(168) * time.Hour,
-		Refresh:  time.Duration(0),
-		Secure:   true,
-		HTTPOnly: true,
-		SameSite: "",
+		Name:          "_oauth2_proxy",
+		Secret:        "",
+		SecretKDF:     "scrypt",
+		SecretKDFSalt: "oauth2-proxy",
+		Domains:       nil,
+		Path:          "/",
+		Expire:        time.Duration(168) * time.Hour,
+		Refresh:       time.Duration(0),
+		Secure:        true,
+		HTTPOnly:      true,
+		SameSite:      "",
+		ValueEncoding: CookieValueEncodingBase64,
 	}
 }
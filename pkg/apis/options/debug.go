@@ -0,0 +1,23 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// Debug contains the options for the diagnostics endpoints
+type Debug struct {
+	// HeadersEndpoint enables the endpoint that shows authenticated users the
+	// headers that are injected into their requests to the upstream.
+	HeadersEndpoint bool `flag:"debug-headers-endpoint" cfg:"debug_headers_endpoint"`
+
+	// ShowTokens shows the values of headers that contain the session's
+	// tokens or secrets on the headers endpoint, rather than redacting them.
+	ShowTokens bool `flag:"debug-headers-show-tokens" cfg:"debug_headers_show_tokens"`
+}
+
+func debugFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("debug", pflag.ExitOnError)
+
+	flagSet.Bool("debug-headers-endpoint", false, "enable the /debug/headers endpoint under the proxy prefix, which shows authenticated users the headers injected into their requests to the upstream as JSON")
+	flagSet.Bool("debug-headers-show-tokens", false, "INSECURE: show the values of headers containing tokens or secrets on the debug headers endpoint, rather than redacting them")
+
+	return flagSet
+}
@@ -0,0 +1,32 @@
+package options
+
+// FeatureFlag enables a feature flag for the sessions of users whose claims
+// match any of its conditions. The flags of a session are evaluated when the
+// user logs in, and again when the session is refreshed, once the claims have
+// been transformed. They can be injected into headers from the
+// `feature_flags` claim, eg. as a comma separated X-Feature-Flags header.
+type FeatureFlag struct {
+	// Name is the name of the feature flag.
+	// Names must be unique, and must not contain commas.
+	Name string `json:"name,omitempty"`
+
+	// Conditions are the conditions that enable the feature flag.
+	// The flag is enabled when any of the conditions match.
+	Conditions []FeatureFlagCondition `json:"conditions,omitempty"`
+}
+
+// FeatureFlagCondition matches the values of a claim of the session.
+type FeatureFlagCondition struct {
+	// Claim is the name of the claim in the session to match.
+	// It must be one of `user`, `email`, `groups` or `preferred_username`.
+	Claim string `json:"claim,omitempty"`
+
+	// Values matches the claim when it has any of these values, eg. any of
+	// a list of groups.
+	Values []string `json:"values,omitempty"`
+
+	// Pattern matches the claim when any of its values match this regular
+	// expression, eg. `@example\.com$` for the emails of a domain.
+	// Either Values or a Pattern is required.
+	Pattern string `json:"pattern,omitempty"`
+}
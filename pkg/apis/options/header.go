@@ -14,6 +14,8 @@ type Header struct {
 	PreserveRequestValue bool `json:"preserveRequestValue,omitempty"`
 
 	// Values contains the desired values for this header
+	// If no values are given and PreserveRequestValue is false, the header
+	// will be stripped from the request without being replaced.
 	Values []HeaderValue `json:"values,omitempty"`
 }
 
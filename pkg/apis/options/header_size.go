@@ -0,0 +1,54 @@
+package options
+
+import "github.com/spf13/pflag"
+
+const (
+	// HeaderSizeWarn injects oversized headers into requests to the upstream
+	// and logs a warning.
+	HeaderSizeWarn = "warn"
+
+	// HeaderSizeTruncate removes values from the end of oversized headers
+	// until they fit, and logs the truncation.
+	HeaderSizeTruncate = "truncate"
+
+	// HeaderSizeDrop removes oversized headers from requests to the upstream.
+	HeaderSizeDrop = "drop"
+
+	// HeaderSizeFail fails requests with oversized headers rather than
+	// proxying them to the upstream.
+	HeaderSizeFail = "fail"
+)
+
+// HeaderSize contains the options for limiting the size of the headers
+// injected into requests to the upstream
+type HeaderSize struct {
+	// MaxSize is the maximum size in bytes of the values of each injected
+	// request header. A size of 0 disables the limit.
+	MaxSize int `flag:"inject-header-max-size" cfg:"inject_header_max_size"`
+
+	// Policy is how injected headers exceeding the MaxSize are handled, one
+	// of warn, truncate, drop or fail.
+	Policy string `flag:"inject-header-size-policy" cfg:"inject_header_size_policy"`
+}
+
+func headerSizeFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("headersize", pflag.ExitOnError)
+
+	flagSet.Int("inject-header-max-size", defaultInjectHeaderMaxSize, "the maximum size in bytes of each header injected into requests to the upstream (0 to disable)")
+	flagSet.String("inject-header-size-policy", HeaderSizeWarn, "how injected headers exceeding the maximum size are handled (one of: warn, truncate, drop, fail)")
+
+	return flagSet
+}
+
+// defaultInjectHeaderMaxSize matches the default size of the buffer for a
+// single request header line in NGINX
+const defaultInjectHeaderMaxSize = 8192
+
+// headerSizeDefaults creates a HeaderSize and populates it with any default
+// values
+func headerSizeDefaults() HeaderSize {
+	return HeaderSize{
+		MaxSize: defaultInjectHeaderMaxSize,
+		Policy:  HeaderSizeWarn,
+	}
+}
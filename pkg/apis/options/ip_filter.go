@@ -0,0 +1,24 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// IPFilter contains the options for allowing or denying requests based on the
+// IP address of the client, before any authentication takes place
+type IPFilter struct {
+	// Allowlist is the list of IPs or CIDRs of clients that may make requests.
+	// When set, requests from any other client are denied.
+	Allowlist []string `flag:"ip-allowlist" cfg:"ip_allowlist"`
+
+	// Denylist is the list of IPs or CIDRs of clients that may not make
+	// requests. The Denylist takes precedence over the Allowlist.
+	Denylist []string `flag:"ip-denylist" cfg:"ip_denylist"`
+}
+
+func ipFilterFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("ipfilter", pflag.ExitOnError)
+
+	flagSet.StringSlice("ip-allowlist", []string{}, "list of IPs or CIDR ranges of clients that may make requests, requests from other clients are denied (may be given multiple times)")
+	flagSet.StringSlice("ip-denylist", []string{}, "list of IPs or CIDR ranges of clients that are denied, even when they are in --ip-allowlist (may be given multiple times)")
+
+	return flagSet
+}
@@ -81,6 +81,12 @@ func (l *LegacyOptions) ToOptions() (*Options, error) {
 
 	l.Options.InjectRequestHeaders, l.Options.InjectResponseHeaders = l.LegacyHeaders.convert()
 
+	authResponseHeaders, err := l.LegacyHeaders.getAuthResponseHeaders()
+	if err != nil {
+		return nil, fmt.Errorf("error converting auth response headers: %v", err)
+	}
+	l.Options.AuthResponseHeaders = authResponseHeaders
+
 	l.Options.Server, l.Options.MetricsServer = l.LegacyServer.convert()
 
 	l.Options.LegacyPreferEmailToUser = l.LegacyHeaders.PreferEmailToUser
@@ -100,6 +106,7 @@ type LegacyUpstreams struct {
 	ProxyWebSockets               bool          `flag:"proxy-websockets" cfg:"proxy_websockets"`
 	SSLUpstreamInsecureSkipVerify bool          `flag:"ssl-upstream-insecure-skip-verify" cfg:"ssl_upstream_insecure_skip_verify"`
 	Upstreams                     []string      `flag:"upstream" cfg:"upstreams"`
+	Timeout                       time.Duration `flag:"upstream-timeout" cfg:"upstream_timeout"`
 }
 
 func legacyUpstreamsFlagSet() *pflag.FlagSet {
@@ -110,6 +117,7 @@ func legacyUpstreamsFlagSet() *pflag.FlagSet {
 	flagSet.Bool("proxy-websockets", true, "enables WebSocket proxying")
 	flagSet.Bool("ssl-upstream-insecure-skip-verify", false, "skip validation of certificates presented when using HTTPS upstreams")
 	flagSet.StringSlice("upstream", []string{}, "the http url(s) of the upstream endpoint, file:// paths for static files or static://<status_code> for static response. Routing is based on the path")
+	flagSet.Duration("upstream-timeout", 0, "the maximum duration of requests to http upstreams, after which the upstream request is cancelled and a 504 error page is returned (0 to disable)")
 
 	return flagSet
 }
@@ -137,6 +145,10 @@ func (l *LegacyUpstreams) convert() (Upstreams, error) {
 			ProxyWebSockets:       &l.ProxyWebSockets,
 			FlushInterval:         &flushInterval,
 		}
+		if l.Timeout > 0 {
+			timeout := Duration(l.Timeout)
+			upstream.Timeout = &timeout
+		}
 
 		switch u.Scheme {
 		case "file":
@@ -167,6 +179,7 @@ func (l *LegacyUpstreams) convert() (Upstreams, error) {
 			upstream.PassHostHeader = nil
 			upstream.ProxyWebSockets = nil
 			upstream.FlushInterval = nil
+			upstream.Timeout = nil
 		}
 
 		upstreams = append(upstreams, upstream)
@@ -181,10 +194,14 @@ type LegacyHeaders struct {
 	PassUserHeaders   bool `flag:"pass-user-headers" cfg:"pass_user_headers"`
 	PassAuthorization bool `flag:"pass-authorization-header" cfg:"pass_authorization_header"`
 
+	StripAuthorization bool `flag:"strip-authorization-header" cfg:"strip_authorization_header"`
+
 	SetBasicAuth     bool `flag:"set-basic-auth" cfg:"set_basic_auth"`
 	SetXAuthRequest  bool `flag:"set-xauthrequest" cfg:"set_xauthrequest"`
 	SetAuthorization bool `flag:"set-authorization-header" cfg:"set_authorization_header"`
 
+	AuthResponseHeaders []string `flag:"auth-response-header" cfg:"auth_response_headers"`
+
 	PreferEmailToUser    bool   `flag:"prefer-email-to-user" cfg:"prefer_email_to_user"`
 	BasicAuthPassword    string `flag:"basic-auth-password" cfg:"basic_auth_password"`
 	SkipAuthStripHeaders bool   `flag:"skip-auth-strip-headers" cfg:"skip_auth_strip_headers"`
@@ -197,10 +214,12 @@ func legacyHeadersFlagSet() *pflag.FlagSet {
 	flagSet.Bool("pass-access-token", false, "pass OAuth access_token to upstream via X-Forwarded-Access-Token header")
 	flagSet.Bool("pass-user-headers", true, "pass X-Forwarded-User and X-Forwarded-Email information to upstream")
 	flagSet.Bool("pass-authorization-header", false, "pass the Authorization Header to upstream")
+	flagSet.Bool("strip-authorization-header", false, "strip the Authorization header sent by the client before proxying to upstream")
 
 	flagSet.Bool("set-basic-auth", false, "set HTTP Basic Auth information in response (useful in Nginx auth_request mode)")
 	flagSet.Bool("set-xauthrequest", false, "set X-Auth-Request-User and X-Auth-Request-Email response headers (useful in Nginx auth_request mode)")
 	flagSet.Bool("set-authorization-header", false, "set Authorization response headers (useful in Nginx auth_request mode)")
+	flagSet.StringSlice("auth-response-header", []string{}, "the headers returned by the auth endpoint for authenticated requests instead of the response headers set by the other flags (may be given multiple times). Format: Header-Name=claim eg. X-Auth-Request-Email=email")
 
 	flagSet.Bool("prefer-email-to-user", false, "Prefer to use the Email address as the Username when passing information to upstream. Will only use Username if Email is unavailable, eg. htaccess authentication. Used in conjunction with -pass-basic-auth and -pass-user-headers")
 	flagSet.String("basic-auth-password", "", "the password to set when passing the HTTP Basic Auth header")
@@ -240,9 +259,28 @@ func (l *LegacyHeaders) getRequestHeaders() []Header {
 		requestHeaders[i].PreserveRequestValue = !l.SkipAuthStripHeaders
 	}
 
+	if l.StripAuthorization {
+		requestHeaders = stripAuthorizationHeader(requestHeaders)
+	}
+
 	return requestHeaders
 }
 
+// stripAuthorizationHeader ensures that any Authorization header sent by the
+// client is removed from the request before it is proxied upstream.
+// If oauth2-proxy already injects an Authorization header, its value replaces
+// the client's header, otherwise the header is stripped without a replacement.
+func stripAuthorizationHeader(requestHeaders []Header) []Header {
+	for i := range requestHeaders {
+		if requestHeaders[i].Name == "Authorization" {
+			requestHeaders[i].PreserveRequestValue = false
+			return requestHeaders
+		}
+	}
+
+	return append(requestHeaders, Header{Name: "Authorization"})
+}
+
 func (l *LegacyHeaders) getResponseHeaders() []Header {
 	responseHeaders := []Header{}
 
@@ -264,6 +302,30 @@ func (l *LegacyHeaders) getResponseHeaders() []Header {
 	return responseHeaders
 }
 
+// getAuthResponseHeaders converts the `Header-Name=claim` auth response
+// headers into headers sourced from the session claims
+func (l *LegacyHeaders) getAuthResponseHeaders() ([]Header, error) {
+	var headers []Header
+	for _, authResponseHeader := range l.AuthResponseHeaders {
+		parts := strings.SplitN(authResponseHeader, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid auth response header %q: must be of the form Header-Name=claim", authResponseHeader)
+		}
+
+		headers = append(headers, Header{
+			Name: parts[0],
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: parts[1],
+					},
+				},
+			},
+		})
+	}
+	return headers, nil
+}
+
 func getBasicAuthHeader(preferEmailToUser bool, basicAuthPassword string) Header {
 	claim := "user"
 	if preferEmailToUser {
@@ -440,14 +502,16 @@ func getXAuthRequestAccessTokenHeader() Header {
 }
 
 type LegacyServer struct {
-	MetricsAddress       string `flag:"metrics-address" cfg:"metrics_address"`
-	MetricsSecureAddress string `flag:"metrics-secure-address" cfg:"metrics_secure_address"`
-	MetricsTLSCertFile   string `flag:"metrics-tls-cert-file" cfg:"metrics_tls_cert_file"`
-	MetricsTLSKeyFile    string `flag:"metrics-tls-key-file" cfg:"metrics_tls_key_file"`
-	HTTPAddress          string `flag:"http-address" cfg:"http_address"`
-	HTTPSAddress         string `flag:"https-address" cfg:"https_address"`
-	TLSCertFile          string `flag:"tls-cert-file" cfg:"tls_cert_file"`
-	TLSKeyFile           string `flag:"tls-key-file" cfg:"tls_key_file"`
+	MetricsAddress       string   `flag:"metrics-address" cfg:"metrics_address"`
+	MetricsSecureAddress string   `flag:"metrics-secure-address" cfg:"metrics_secure_address"`
+	MetricsTLSCertFile   string   `flag:"metrics-tls-cert-file" cfg:"metrics_tls_cert_file"`
+	MetricsTLSKeyFile    string   `flag:"metrics-tls-key-file" cfg:"metrics_tls_key_file"`
+	HTTPAddress          string   `flag:"http-address" cfg:"http_address"`
+	HTTPSAddress         string   `flag:"https-address" cfg:"https_address"`
+	TLSCertFile          string   `flag:"tls-cert-file" cfg:"tls_cert_file"`
+	TLSKeyFile           string   `flag:"tls-key-file" cfg:"tls_key_file"`
+	TLSMinVersion        string   `flag:"tls-min-version" cfg:"tls_min_version"`
+	TLSCipherSuites      []string `flag:"tls-cipher-suite" cfg:"tls_cipher_suites"`
 }
 
 func legacyServerFlagset() *pflag.FlagSet {
@@ -461,6 +525,8 @@ func legacyServerFlagset() *pflag.FlagSet {
 	flagSet.String("https-address", ":443", "<addr>:<port> to listen on for HTTPS clients")
 	flagSet.String("tls-cert-file", "", "path to certificate file")
 	flagSet.String("tls-key-file", "", "path to private key file")
+	flagSet.String("tls-min-version", "", "minimal TLS version for HTTPS clients (either \"TLS1.2\" or \"TLS1.3\")")
+	flagSet.StringSlice("tls-cipher-suite", []string{}, "restricts TLS 1.2 cipher suites to those listed (e.g. TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384) (may be given multiple times)")
 
 	return flagSet
 }
@@ -471,6 +537,7 @@ type LegacyProvider struct {
 	ClientSecretFile string `flag:"client-secret-file" cfg:"client_secret_file"`
 
 	KeycloakGroups           []string `flag:"keycloak-group" cfg:"keycloak_groups"`
+	KeycloakRoleClients      []string `flag:"keycloak-role-client" cfg:"keycloak_role_clients"`
 	AzureTenant              string   `flag:"azure-tenant" cfg:"azure_tenant"`
 	BitbucketTeam            string   `flag:"bitbucket-team" cfg:"bitbucket_team"`
 	BitbucketRepository      string   `flag:"bitbucket-repository" cfg:"bitbucket_repository"`
@@ -484,6 +551,7 @@ type LegacyProvider struct {
 	GoogleGroups             []string `flag:"google-group" cfg:"google_group"`
 	GoogleAdminEmail         string   `flag:"google-admin-email" cfg:"google_admin_email"`
 	GoogleServiceAccountJSON string   `flag:"google-service-account-json" cfg:"google_service_account_json"`
+	GoogleExtraScopes        []string `flag:"google-extra-scope" cfg:"google_extra_scopes"`
 
 	// These options allow for other providers besides Google, with
 	// potential overrides.
@@ -497,17 +565,23 @@ type LegacyProvider struct {
 	SkipOIDCDiscovery                  bool     `flag:"skip-oidc-discovery" cfg:"skip_oidc_discovery"`
 	OIDCJwksURL                        string   `flag:"oidc-jwks-url" cfg:"oidc_jwks_url"`
 	OIDCEmailClaim                     string   `flag:"oidc-email-claim" cfg:"oidc_email_claim"`
+	OIDCExtraEmailClaims               []string `flag:"oidc-extra-email-claim" cfg:"oidc_extra_email_claims"`
+	OIDCPreferredEmailDomains          []string `flag:"oidc-preferred-email-domain" cfg:"oidc_preferred_email_domains"`
+	OIDCPreferVerifiedEmail            bool     `flag:"oidc-prefer-verified-email" cfg:"oidc_prefer_verified_email"`
 	OIDCGroupsClaim                    string   `flag:"oidc-groups-claim" cfg:"oidc_groups_claim"`
+	OIDCUserTemplate                   string   `flag:"oidc-user-template" cfg:"oidc_user_template"`
 	LoginURL                           string   `flag:"login-url" cfg:"login_url"`
 	RedeemURL                          string   `flag:"redeem-url" cfg:"redeem_url"`
 	ProfileURL                         string   `flag:"profile-url" cfg:"profile_url"`
 	ProtectedResource                  string   `flag:"resource" cfg:"resource"`
 	ValidateURL                        string   `flag:"validate-url" cfg:"validate_url"`
+	IntrospectURL                      string   `flag:"introspect-url" cfg:"introspect_url"`
 	Scope                              string   `flag:"scope" cfg:"scope"`
 	Prompt                             string   `flag:"prompt" cfg:"prompt"`
 	ApprovalPrompt                     string   `flag:"approval-prompt" cfg:"approval_prompt"` // Deprecated by OIDC 1.0
 	UserIDClaim                        string   `flag:"user-id-claim" cfg:"user_id_claim"`
 	AllowedGroups                      []string `flag:"allowed-group" cfg:"allowed_groups"`
+	AllowedGroupsFile                  string   `flag:"allowed-groups-file" cfg:"allowed_groups_file"`
 	AllowedRoles                       []string `flag:"allowed-role" cfg:"allowed_roles"`
 
 	AcrValues  string `flag:"acr-values" cfg:"acr_values"`
@@ -520,6 +594,7 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet := pflag.NewFlagSet("provider", pflag.ExitOnError)
 
 	flagSet.StringSlice("keycloak-group", []string{}, "restrict logins to members of these groups (may be given multiple times)")
+	flagSet.StringSlice("keycloak-role-client", []string{}, "(keycloak-oidc) only add the roles of these clients to the user's groups, rather than the roles of all clients (may be given multiple times)")
 	flagSet.String("azure-tenant", "common", "go to a tenant-specific or common (tenant-independent) endpoint.")
 	flagSet.String("bitbucket-team", "", "restrict logins to members of this team")
 	flagSet.String("bitbucket-repository", "", "restrict logins to user with access to this repository")
@@ -533,6 +608,7 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("google-group", []string{}, "restrict logins to members of this google group (may be given multiple times).")
 	flagSet.String("google-admin-email", "", "the google admin to impersonate for api calls")
 	flagSet.String("google-service-account-json", "", "the path to the service account json credentials")
+	flagSet.StringSlice("google-extra-scope", []string{}, "additional scope to request from google, so that the access token can be used to call other google APIs (may be given multiple times)")
 	flagSet.String("client-id", "", "the OAuth Client ID: ie: \"123456.apps.googleusercontent.com\"")
 	flagSet.String("client-secret", "", "the OAuth Client Secret")
 	flagSet.String("client-secret-file", "", "the file with OAuth Client Secret")
@@ -548,11 +624,16 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet.String("oidc-jwks-url", "", "OpenID Connect JWKS URL (ie: https://www.googleapis.com/oauth2/v3/certs)")
 	flagSet.String("oidc-groups-claim", providers.OIDCGroupsClaim, "which OIDC claim contains the user groups")
 	flagSet.String("oidc-email-claim", providers.OIDCEmailClaim, "which OIDC claim contains the user's email")
+	flagSet.StringSlice("oidc-extra-email-claim", []string{}, "further OIDC claims that contain the user's emails, checked after oidc-email-claim (may be given multiple times)")
+	flagSet.StringSlice("oidc-preferred-email-domain", []string{}, "domains of the emails to prefer when several emails are found in the claims, in order of preference (may be given multiple times)")
+	flagSet.Bool("oidc-prefer-verified-email", false, "prefer verified emails when several emails are found in the claims")
+	flagSet.String("oidc-user-template", "", "Go template over the OIDC claims used to build the user, eg. `{{.given_name}} {{.family_name}}` (defaults to the sub claim)")
 	flagSet.String("login-url", "", "Authentication endpoint")
 	flagSet.String("redeem-url", "", "Token redemption endpoint")
 	flagSet.String("profile-url", "", "Profile access endpoint")
 	flagSet.String("resource", "", "The resource that is protected (Azure AD only)")
 	flagSet.String("validate-url", "", "Access token validation endpoint")
+	flagSet.String("introspect-url", "", "Token introspection endpoint, used to check sessions for revoked refresh tokens")
 	flagSet.String("scope", "", "OAuth scope specification")
 	flagSet.String("prompt", "", "OIDC prompt")
 	flagSet.String("approval-prompt", "force", "OAuth approval_prompt")
@@ -564,6 +645,7 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 
 	flagSet.String("user-id-claim", providers.OIDCEmailClaim, "(DEPRECATED for `oidc-email-claim`) which claim contains the user ID")
 	flagSet.StringSlice("allowed-group", []string{}, "restrict logins to members of this group (may be given multiple times)")
+	flagSet.String("allowed-groups-file", "", "restrict logins to members of the groups listed in this file (one per line), in addition to any allowed-group. The file is reloaded when it changes")
 	flagSet.StringSlice("allowed-role", []string{}, "(keycloak-oidc) restrict logins to members of these roles (may be given multiple times)")
 
 	return flagSet
@@ -582,6 +664,8 @@ func (l LegacyServer) convert() (Server, Server) {
 			Cert: &SecretSource{
 				FromFile: l.TLSCertFile,
 			},
+			MinVersion:   l.TLSMinVersion,
+			CipherSuites: l.TLSCipherSuites,
 		}
 		// Preserve backwards compatibility, only run one server
 		appServer.BindAddress = ""
@@ -623,10 +707,12 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		ProfileURL:        l.ProfileURL,
 		ProtectedResource: l.ProtectedResource,
 		ValidateURL:       l.ValidateURL,
+		IntrospectURL:     l.IntrospectURL,
 		Scope:             l.Scope,
 		Prompt:            l.Prompt,
 		ApprovalPrompt:    l.ApprovalPrompt,
 		AllowedGroups:     l.AllowedGroups,
+		AllowedGroupsFile: l.AllowedGroupsFile,
 		AcrValues:         l.AcrValues,
 	}
 
@@ -640,7 +726,11 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		JwksURL:                        l.OIDCJwksURL,
 		UserIDClaim:                    l.UserIDClaim,
 		EmailClaim:                     l.OIDCEmailClaim,
+		ExtraEmailClaims:               l.OIDCExtraEmailClaims,
+		PreferredEmailDomains:          l.OIDCPreferredEmailDomains,
+		PreferVerifiedEmail:            l.OIDCPreferVerifiedEmail,
 		GroupsClaim:                    l.OIDCGroupsClaim,
+		UserTemplate:                   l.OIDCUserTemplate,
 	}
 
 	// This part is out of the switch section because azure has a default tenant
@@ -660,8 +750,9 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		}
 	case "keycloak-oidc":
 		provider.KeycloakConfig = KeycloakOptions{
-			Groups: l.KeycloakGroups,
-			Roles:  l.AllowedRoles,
+			Groups:      l.KeycloakGroups,
+			Roles:       l.AllowedRoles,
+			RoleClients: l.KeycloakRoleClients,
 		}
 	case "keycloak":
 		provider.KeycloakConfig = KeycloakOptions{
@@ -688,6 +779,7 @@ func (l *LegacyProvider) convert() (Providers, error) {
 			Groups:             l.GoogleGroups,
 			AdminEmail:         l.GoogleAdminEmail,
 			ServiceAccountJSON: l.GoogleServiceAccountJSON,
+			ExtraScopes:        l.GoogleExtraScopes,
 		}
 	}
 
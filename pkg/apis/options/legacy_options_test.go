@@ -267,6 +267,21 @@ var _ = Describe("Legacy Options", func() {
 				errMsg:            "",
 			}),
 		)
+
+		It("sets the timeout on HTTP upstreams only", func() {
+			legacyUpstreams := LegacyUpstreams{
+				Upstreams: []string{validHTTP, validStatic},
+				Timeout:   30 * time.Second,
+			}
+
+			upstreams, err := legacyUpstreams.convert()
+			Expect(err).ToNot(HaveOccurred())
+
+			timeout := Duration(30 * time.Second)
+			Expect(upstreams).To(HaveLen(2))
+			Expect(upstreams[0].Timeout).To(Equal(&timeout))
+			Expect(upstreams[1].Timeout).To(BeNil())
+		})
 	})
 
 	Context("Legacy Headers", func() {
@@ -766,6 +781,110 @@ var _ = Describe("Legacy Options", func() {
 					authorizationHeader,
 				},
 			}),
+			Entry("with stripAuthorization", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:      false,
+					PassAccessToken:    false,
+					PassUserHeaders:    false,
+					PassAuthorization:  false,
+					StripAuthorization: true,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  false,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: true,
+				},
+				expectedRequestHeaders: []Header{
+					{
+						Name:                 "Authorization",
+						PreserveRequestValue: false,
+					},
+				},
+				expectedResponseHeaders: []Header{},
+			}),
+			Entry("with stripAuthorization, authorization headers and SkipAuthStripHeaders disabled", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:      false,
+					PassAccessToken:    false,
+					PassUserHeaders:    false,
+					PassAuthorization:  true,
+					StripAuthorization: true,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  false,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: false,
+				},
+				expectedRequestHeaders: []Header{
+					authorizationHeader,
+				},
+				expectedResponseHeaders: []Header{},
+			}),
+		)
+
+		type authResponseHeadersTableInput struct {
+			authResponseHeaders []string
+			expectedHeaders     []Header
+			errMsg              string
+		}
+
+		DescribeTable("should convert to authResponseHeaders",
+			func(in authResponseHeadersTableInput) {
+				legacyHeaders := &LegacyHeaders{AuthResponseHeaders: in.authResponseHeaders}
+
+				headers, err := legacyHeaders.getAuthResponseHeaders()
+				if in.errMsg != "" {
+					Expect(err).To(MatchError(in.errMsg))
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(headers).To(Equal(in.expectedHeaders))
+			},
+			Entry("with no auth response headers", authResponseHeadersTableInput{
+				authResponseHeaders: []string{},
+				expectedHeaders:     nil,
+			}),
+			Entry("with claims mapped to headers", authResponseHeadersTableInput{
+				authResponseHeaders: []string{"X-Email=email", "X-Groups=groups"},
+				expectedHeaders: []Header{
+					{
+						Name: "X-Email",
+						Values: []HeaderValue{
+							{
+								ClaimSource: &ClaimSource{
+									Claim: "email",
+								},
+							},
+						},
+					},
+					{
+						Name: "X-Groups",
+						Values: []HeaderValue{
+							{
+								ClaimSource: &ClaimSource{
+									Claim: "groups",
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("with a header without a claim", authResponseHeadersTableInput{
+				authResponseHeaders: []string{"X-Email"},
+				expectedHeaders:     nil,
+				errMsg:              "invalid auth response header \"X-Email\": must be of the form Header-Name=claim",
+			}),
+			Entry("with an empty header name", authResponseHeadersTableInput{
+				authResponseHeaders: []string{"=email"},
+				expectedHeaders:     nil,
+				errMsg:              "invalid auth response header \"=email\": must be of the form Header-Name=claim",
+			}),
 		)
 	})
 
@@ -821,6 +940,25 @@ var _ = Describe("Legacy Options", func() {
 					TLS:               tlsConfig,
 				},
 			}),
+			Entry("with TLS version and cipher suite options", legacyServersTableInput{
+				legacyServer: LegacyServer{
+					HTTPAddress:     insecureAddr,
+					HTTPSAddress:    secureAddr,
+					TLSKeyFile:      keyPath,
+					TLSCertFile:     crtPath,
+					TLSMinVersion:   "TLS1.3",
+					TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+				},
+				expectedAppServer: Server{
+					SecureBindAddress: secureAddr,
+					TLS: &TLS{
+						Cert:         tlsConfig.Cert,
+						Key:          tlsConfig.Key,
+						MinVersion:   "TLS1.3",
+						CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+					},
+				},
+			}),
 			Entry("with metrics HTTP and HTTPS addresses", legacyServersTableInput{
 				legacyServer: LegacyServer{
 					HTTPAddress:          insecureAddr,
@@ -898,6 +1036,7 @@ var _ = Describe("Legacy Options", func() {
 				AdminEmail:         "email@email.com",
 				ServiceAccountJSON: "test.json",
 				Groups:             []string{"1", "2"},
+				ExtraScopes:        []string{"https://www.googleapis.com/auth/calendar.readonly"},
 			},
 		}
 
@@ -907,6 +1046,7 @@ var _ = Describe("Legacy Options", func() {
 			GoogleAdminEmail:         "email@email.com",
 			GoogleServiceAccountJSON: "test.json",
 			GoogleGroups:             []string{"1", "2"},
+			GoogleExtraScopes:        []string{"https://www.googleapis.com/auth/calendar.readonly"},
 		}
 		DescribeTable("convertLegacyProviders",
 			func(in *convertProvidersTableInput) {
@@ -46,15 +46,24 @@ var _ = Describe("Load", func() {
 		},
 
 		Options: Options{
-			ProxyPrefix:        "/oauth2",
-			PingPath:           "/ping",
-			RealClientIPHeader: "X-Real-IP",
-			ForceHTTPS:         false,
-			Cookie:             cookieDefaults(),
-			Session:            sessionOptionsDefaults(),
-			Templates:          templatesDefaults(),
-			SkipAuthPreflight:  false,
-			Logging:            loggingDefaults(),
+			ProxyPrefix:         "/oauth2",
+			PingPath:            "/ping",
+			RealClientIPHeader:  "X-Real-IP",
+			ForceHTTPS:          false,
+			Cookie:              cookieDefaults(),
+			Session:             sessionOptionsDefaults(),
+			Templates:           templatesDefaults(),
+			Compression:         compressionDefaults(),
+			UpstreamToken:       upstreamTokenDefaults(),
+			OAuthState:          oauthStateDefaults(),
+			RequestReplay:       requestReplayDefaults(),
+			Shutdown:            shutdownDefaults(),
+			StepUp:              stepUpDefaults(),
+			HeaderSize:          headerSizeDefaults(),
+			SkipAuthPreflight:   false,
+			HeadRequestHandling: HeadRequestLogin,
+			TrailingSlashPolicy: TrailingSlashStrict,
+			Logging:             loggingDefaults(),
 		},
 	}
 
@@ -0,0 +1,26 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// Metrics contains the options for serving metrics on the main server,
+// alongside the upstreams, rather than on the separate metrics server
+type Metrics struct {
+	// Path is the path that metrics are served on by the main server.
+	// Requests to the path skip authentication. When empty, metrics are
+	// only served by the metrics server.
+	Path string `flag:"metrics-path" cfg:"metrics_path"`
+
+	// BearerToken is the token that scrapers must present in the
+	// Authorization header to read the metrics served on Path.
+	// When empty, the metrics on Path are served to any client.
+	BearerToken string `flag:"metrics-bearer-token" cfg:"metrics_bearer_token"`
+}
+
+func metricsFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("metrics", pflag.ExitOnError)
+
+	flagSet.String("metrics-path", "", "the path metrics are served on by the main server, without authentication (e.g. \"/metrics\")")
+	flagSet.String("metrics-bearer-token", "", "the bearer token that must be presented in the Authorization header to read the metrics served on --metrics-path")
+
+	return flagSet
+}
@@ -0,0 +1,67 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// OAuthStateCookie verifies the OAuth2 state parameter against the nonce
+	// stored in the CSRF cookie.
+	OAuthStateCookie = "cookie"
+
+	// OAuthStateSigned signs the OAuth2 state parameter so that it can be
+	// verified at the callback without the CSRF cookie.
+	OAuthStateSigned = "signed"
+
+	// OAuthStateSignedAndCookie signs the OAuth2 state parameter and also
+	// verifies it against the nonce stored in the CSRF cookie.
+	OAuthStateSignedAndCookie = "signed+cookie"
+)
+
+// OAuthState contains the options for verifying the OAuth2 state parameter
+// at the callback
+type OAuthState struct {
+	// Mode is how the state is verified, one of cookie, signed or
+	// signed+cookie.
+	Mode string `flag:"oauth-state-mode" cfg:"oauth_state_mode"`
+
+	// Expire is how long a signed state is valid for, so that the login flow
+	// must be completed within this duration.
+	Expire time.Duration `flag:"oauth-state-expire" cfg:"oauth_state_expire"`
+
+	// StateLength is the length in bytes of the random nonce in the OAuth2
+	// state parameter.
+	StateLength int `flag:"oauth-state-length" cfg:"oauth_state_length"`
+
+	// NonceLength is the length in bytes of the random OIDC nonce parameter.
+	NonceLength int `flag:"oauth-nonce-length" cfg:"oauth_nonce_length"`
+}
+
+func oauthStateFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("oauthstate", pflag.ExitOnError)
+
+	flagSet.String("oauth-state-mode", OAuthStateCookie, "how the OAuth2 state parameter is verified at the callback (one of: cookie, signed, signed+cookie). Signed states are verified without the CSRF cookie")
+	flagSet.Duration("oauth-state-expire", defaultOAuthStateExpire, "how long a signed OAuth2 state is valid for")
+	flagSet.Int("oauth-state-length", defaultOAuthNonceLength, "the length in bytes of the random nonce in the OAuth2 state parameter (at least 16)")
+	flagSet.Int("oauth-nonce-length", defaultOAuthNonceLength, "the length in bytes of the random OIDC nonce parameter (at least 16)")
+
+	return flagSet
+}
+
+const (
+	defaultOAuthStateExpire = 15 * time.Minute
+	defaultOAuthNonceLength = 32
+)
+
+// oauthStateDefaults creates an OAuthState and populates it with any default
+// values
+func oauthStateDefaults() OAuthState {
+	return OAuthState{
+		Mode:        OAuthStateCookie,
+		Expire:      defaultOAuthStateExpire,
+		StateLength: defaultOAuthNonceLength,
+		NonceLength: defaultOAuthNonceLength,
+	}
+}
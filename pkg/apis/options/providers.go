@@ -61,6 +61,9 @@ type Provider struct {
 	ProtectedResource string `json:"resource,omitempty"`
 	// ValidateURL is the access token validation endpoint
 	ValidateURL string `json:"validateURL,omitempty"`
+	// IntrospectURL is the token introspection endpoint (RFC 7662), used to
+	// check whether the refresh tokens of sessions have been revoked
+	IntrospectURL string `json:"introspectURL,omitempty"`
 	// Scope is the OAuth scope specification
 	Scope string `json:"scope,omitempty"`
 	// Prompt is OIDC prompt
@@ -70,6 +73,10 @@ type Provider struct {
 	ApprovalPrompt string `json:"approvalPrompt,omitempty"`
 	// AllowedGroups is a list of restrict logins to members of this group
 	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// AllowedGroupsFile is the path to a file listing additional groups,
+	// one per line, to restrict logins to.
+	// The file is reloaded whenever it changes or on SIGHUP.
+	AllowedGroupsFile string `json:"allowedGroupsFile,omitempty"`
 
 	// AcrValues is a string of acr values
 	AcrValues string `json:"acrValues,omitempty"`
@@ -81,6 +88,12 @@ type KeycloakOptions struct {
 
 	// Role enables to restrict login to users with role (only available when using the keycloak-oidc provider)
 	Roles []string `json:"roles,omitempty"`
+
+	// RoleClients restricts the client roles added to the user's groups to
+	// the roles of these clients (only available when using the keycloak-oidc provider).
+	// Realm roles are always added.
+	// Defaults to the roles of all clients.
+	RoleClients []string `json:"roleClients,omitempty"`
 }
 
 type AzureOptions struct {
@@ -131,6 +144,10 @@ type GoogleOptions struct {
 	AdminEmail string `json:"adminEmail,omitempty"`
 	// ServiceAccountJSON is the path to the service account json credentials
 	ServiceAccountJSON string `json:"serviceAccountJson,omitempty"`
+	// ExtraScopes are requested at login in addition to the provider's scope,
+	// so that the access token stored in the session can be used by the
+	// upstream to call other Google APIs
+	ExtraScopes []string `json:"extraScopes,omitempty"`
 }
 
 type OIDCOptions struct {
@@ -158,12 +175,61 @@ type OIDCOptions struct {
 	// EmailClaim indicates which claim contains the user email,
 	// default set to 'email'
 	EmailClaim string `json:"emailClaim,omitempty"`
+	// ExtraEmailClaims are further claims that contain emails of the user,
+	// checked after the EmailClaim. Claims may contain an email, a list of
+	// emails or objects with an `email` or `value` field, and may be a dot
+	// separated path into nested claims, eg. `contact.email`.
+	ExtraEmailClaims []string `json:"extraEmailClaims,omitempty"`
+	// PreferredEmailDomains are the domains of the emails to choose as the
+	// session email when several emails are found, in order of preference
+	PreferredEmailDomains []string `json:"preferredEmailDomains,omitempty"`
+	// PreferVerifiedEmail chooses the session email only from the verified
+	// emails when several emails are found and any of them are verified,
+	// before applying the PreferredEmailDomains.
+	// Otherwise the first email found is chosen.
+	PreferVerifiedEmail bool `json:"preferVerifiedEmail,omitempty"`
 	// GroupsClaim indicates which claim contains the user groups
 	// default set to 'groups'
 	GroupsClaim string `json:"groupsClaim,omitempty"`
 	// UserIDClaim indicates which claim contains the user ID
 	// default set to 'email'
 	UserIDClaim string `json:"userIDClaim,omitempty"`
+	// UserTemplate is a Go template evaluated over the ID token claims to
+	// build the user, eg. `{{.given_name}} {{.family_name}}`.
+	// Claims that are missing from the ID token are substituted with an empty
+	// string, and claims that are not strings are substituted with their JSON
+	// encoding. ID tokens for which the template results in an empty user
+	// are rejected.
+	// default set to '', which uses the 'sub' claim
+	UserTemplate string `json:"userTemplate,omitempty"`
+	// ClaimAssertions are rules the claims of ID tokens must pass, checked
+	// whenever a session is created or refreshed from an ID token.
+	// Tokens failing any rule are rejected.
+	ClaimAssertions []ClaimAssertion `json:"claimAssertions,omitempty"`
+}
+
+// ClaimAssertion is a rule the claims of an ID token must pass.
+type ClaimAssertion struct {
+	// Claim is the name of the claim to check, and may be a dot separated
+	// path into nested claims, eg. `tenant.id`.
+	// Tokens without the claim fail the rule.
+	Claim string `json:"claim,omitempty"`
+	// Operator is how the claim is compared with the expected value:
+	// - `equals`: the claim is the Value
+	// - `contains`: the claim is a string containing the Value, or a list
+	//   with the Value as an item
+	// - `matches`: the claim, or an item of a list claim, matches the Value
+	//   as a regular expression. The whole claim must match, as if the
+	//   expression started with `^` and ended with `$`
+	// - `in`: the claim is one of the Values
+	// Claims that are not strings are compared using their JSON encoding,
+	// eg. `true` or `42`.
+	Operator string `json:"operator,omitempty"`
+	// Value is the expected value for the `equals`, `contains` and `matches`
+	// operators.
+	Value string `json:"value,omitempty"`
+	// Values are the expected values for the `in` operator.
+	Values []string `json:"values,omitempty"`
 }
 
 type LoginGovOptions struct {
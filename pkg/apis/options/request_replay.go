@@ -0,0 +1,47 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// RequestReplay contains the options for replaying POST requests that
+// required a login once the login has completed
+type RequestReplay struct {
+	// Enabled stashes POST requests that start the login flow in the
+	// persistent session store, and replays them once the login completes.
+	Enabled bool `flag:"replay-post-requests" cfg:"replay_post_requests"`
+
+	// MaxBodySize is the largest request body, in bytes, that is stashed.
+	// Requests with larger bodies are not replayed.
+	MaxBodySize int64 `flag:"replay-post-max-body-size" cfg:"replay_post_max_body_size"`
+
+	// Expire is how long a stashed request is kept for, so that the login
+	// must complete within this duration for the request to be replayed.
+	Expire time.Duration `flag:"replay-post-expire" cfg:"replay_post_expire"`
+}
+
+func requestReplayFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("requestreplay", pflag.ExitOnError)
+
+	flagSet.Bool("replay-post-requests", false, "stash POST requests that start the login flow and replay them once the login completes (requires a persistent session store and --skip-provider-button)")
+	flagSet.Int64("replay-post-max-body-size", defaultRequestReplayMaxBodySize, "the largest POST body in bytes that is stashed to be replayed after the login")
+	flagSet.Duration("replay-post-expire", defaultRequestReplayExpire, "how long a stashed POST request is kept to be replayed after the login")
+
+	return flagSet
+}
+
+const (
+	defaultRequestReplayMaxBodySize = 64 * 1024
+	defaultRequestReplayExpire      = 5 * time.Minute
+)
+
+// requestReplayDefaults creates a RequestReplay and populates it with any
+// default values
+func requestReplayDefaults() RequestReplay {
+	return RequestReplay{
+		MaxBodySize: defaultRequestReplayMaxBodySize,
+		Expire:      defaultRequestReplayExpire,
+	}
+}
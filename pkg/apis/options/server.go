@@ -24,4 +24,17 @@ type TLS struct {
 	// Cert is the TLS certificate data to use.
 	// Typically this will come from a file.
 	Cert *SecretSource
+
+	// MinVersion is the minimum TLS version accepted by the server.
+	// Valid values are `TLS1.2` and `TLS1.3`.
+	// Defaults to TLS1.2.
+	MinVersion string
+
+	// CipherSuites is the list of cipher suites accepted by the server for
+	// TLS 1.2 connections, using the names from the
+	// [crypto/tls documentation](https://pkg.go.dev/crypto/tls#pkg-constants),
+	// eg. `TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384`.
+	// The cipher suites of TLS 1.3 connections are not configurable.
+	// Defaults to the ECDHE cipher suites with AES-GCM or ChaCha20-Poly1305.
+	CipherSuites []string
 }
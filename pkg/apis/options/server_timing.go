@@ -0,0 +1,28 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// ServerTiming contains the options for reporting how long was spent handling
+// each request in a Server-Timing response header
+type ServerTiming struct {
+	// Enabled adds the Server-Timing header to every response.
+	Enabled bool `flag:"server-timing" cfg:"server_timing"`
+
+	// RequestHeader is the name of a request header that trusted clients may
+	// send to request the Server-Timing header on their responses.
+	RequestHeader string `flag:"server-timing-request-header" cfg:"server_timing_request_header"`
+
+	// TrustedIPs is the list of IPs or CIDRs of clients that may request the
+	// Server-Timing header using the RequestHeader.
+	TrustedIPs []string `flag:"server-timing-trusted-ip" cfg:"server_timing_trusted_ips"`
+}
+
+func serverTimingFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("servertiming", pflag.ExitOnError)
+
+	flagSet.Bool("server-timing", false, "add a Server-Timing header with the time spent authenticating, refreshing and proxying to every response (WARNING: this exposes timing information to all clients)")
+	flagSet.String("server-timing-request-header", "", "the request header that clients from --server-timing-trusted-ip may send to request the Server-Timing header")
+	flagSet.StringSlice("server-timing-trusted-ip", []string{}, "list of IPs or CIDR ranges of clients that may request the Server-Timing header (may be given multiple times)")
+
+	return flagSet
+}
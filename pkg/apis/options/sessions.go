@@ -1,10 +1,29 @@
 package options
 
+import "time"
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
-	Type   string             `flag:"session-store-type" cfg:"session_store_type"`
-	Cookie CookieStoreOptions `cfg:",squash"`
-	Redis  RedisStoreOptions  `cfg:",squash"`
+	Type                    string               `flag:"session-store-type" cfg:"session_store_type"`
+	MaxPerUser              int                  `flag:"session-max-per-user" cfg:"session_max_per_user"`
+	SinglePerUser           bool                 `flag:"session-single-per-user" cfg:"session_single_per_user"`
+	EvictionPolicy          string               `flag:"session-eviction-policy" cfg:"session_eviction_policy"`
+	EncryptTokensOnly       bool                 `flag:"session-encrypt-tokens-only" cfg:"session_encrypt_tokens_only"`
+	SignedOnly              bool                 `flag:"session-signed-only" cfg:"session_signed_only"`
+	ExpiryJitter            time.Duration        `flag:"session-expiry-jitter" cfg:"session_expiry_jitter"`
+	UnavailablePolicy       string               `flag:"session-store-unavailable-policy" cfg:"session_store_unavailable_policy"`
+	RefreshDedupTTL         time.Duration        `flag:"session-refresh-dedup-ttl" cfg:"session_refresh_dedup_ttl"`
+	RevocationCheckInterval time.Duration        `flag:"session-revocation-check-interval" cfg:"session_revocation_check_interval"`
+	RevocationCheckMethod   string               `flag:"session-revocation-check-method" cfg:"session_revocation_check_method"`
+	DegradedHeader          string               `flag:"session-degraded-header" cfg:"session_degraded_header"`
+	BindUserAgent           bool                 `flag:"session-bind-user-agent" cfg:"session_bind_user_agent"`
+	BindClientIP            bool                 `flag:"session-bind-client-ip" cfg:"session_bind_client_ip"`
+	BindIPv4Prefix          int                  `flag:"session-bind-ipv4-prefix" cfg:"session_bind_ipv4_prefix"`
+	BindIPv6Prefix          int                  `flag:"session-bind-ipv6-prefix" cfg:"session_bind_ipv6_prefix"`
+	Cookie                  CookieStoreOptions   `cfg:",squash"`
+	Redis                   RedisStoreOptions    `cfg:",squash"`
+	Etcd                    EtcdStoreOptions     `cfg:",squash"`
+	Postgres                PostgresStoreOptions `cfg:",squash"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
@@ -15,6 +34,48 @@ var CookieSessionStoreType = "cookie"
 // used for storing sessions.
 var RedisSessionStoreType = "redis"
 
+// EtcdSessionStoreType is used to indicate the EtcdSessionStore should be
+// used for storing sessions.
+var EtcdSessionStoreType = "etcd"
+
+// PostgresSessionStoreType is used to indicate the PostgresSessionStore should
+// be used for storing sessions.
+var PostgresSessionStoreType = "postgres"
+
+// OldestSessionEvictionPolicy is used to indicate that the oldest session of a
+// user should be removed when they exceed the maximum number of sessions.
+var OldestSessionEvictionPolicy = "oldest"
+
+// RejectSessionEvictionPolicy is used to indicate that new sessions for a user
+// should be rejected when they have reached the maximum number of sessions.
+var RejectSessionEvictionPolicy = "reject"
+
+// FailClosedUnavailablePolicy is used to indicate that requests should be
+// treated as unauthenticated while the persistent session store is unavailable.
+var FailClosedUnavailablePolicy = "fail-closed"
+
+// CookieFallbackUnavailablePolicy is used to indicate that sessions should be
+// loaded from a fallback cookie while the persistent session store is
+// unavailable.
+var CookieFallbackUnavailablePolicy = "cookie-fallback"
+
+// RefreshRevocationMethod is used to indicate that sessions should be checked
+// for revoked refresh tokens by refreshing them with the provider.
+var RefreshRevocationMethod = "refresh"
+
+// IntrospectionRevocationMethod is used to indicate that sessions should be
+// checked for revoked refresh tokens with the provider's token introspection
+// endpoint.
+var IntrospectionRevocationMethod = "introspection"
+
+// DefaultSessionBindIPv4Prefix is the default length of the prefix of IPv4
+// client IPs that sessions are bound to.
+const DefaultSessionBindIPv4Prefix = 24
+
+// DefaultSessionBindIPv6Prefix is the default length of the prefix of IPv6
+// client IPs that sessions are bound to.
+const DefaultSessionBindIPv6Prefix = 64
+
 // CookieStoreOptions contains configuration options for the CookieSessionStore.
 type CookieStoreOptions struct {
 	Minimal bool `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
@@ -34,11 +95,50 @@ type RedisStoreOptions struct {
 	InsecureSkipTLSVerify  bool     `flag:"redis-insecure-skip-tls-verify" cfg:"redis_insecure_skip_tls_verify"`
 }
 
+// EtcdStoreOptions contains configuration options for the EtcdSessionStore.
+type EtcdStoreOptions struct {
+	Endpoints             []string      `flag:"etcd-endpoints" cfg:"etcd_endpoints"`
+	Username              string        `flag:"etcd-username" cfg:"etcd_username"`
+	Password              string        `flag:"etcd-password" cfg:"etcd_password"`
+	KeyPrefix             string        `flag:"etcd-key-prefix" cfg:"etcd_key_prefix"`
+	DialTimeout           time.Duration `flag:"etcd-dial-timeout" cfg:"etcd_dial_timeout"`
+	CAPath                string        `flag:"etcd-ca-path" cfg:"etcd_ca_path"`
+	CertPath              string        `flag:"etcd-cert-path" cfg:"etcd_cert_path"`
+	KeyPath               string        `flag:"etcd-key-path" cfg:"etcd_key_path"`
+	InsecureSkipTLSVerify bool          `flag:"etcd-insecure-skip-tls-verify" cfg:"etcd_insecure_skip_tls_verify"`
+}
+
+// PostgresStoreOptions contains configuration options for the
+// PostgresSessionStore.
+type PostgresStoreOptions struct {
+	ConnectionURL         string        `flag:"postgres-connection-url" cfg:"postgres_connection_url"`
+	Table                 string        `flag:"postgres-table" cfg:"postgres_table"`
+	MaxOpenConns          int           `flag:"postgres-max-open-conns" cfg:"postgres_max_open_conns"`
+	ReapInterval          time.Duration `flag:"postgres-reap-interval" cfg:"postgres_reap_interval"`
+	CAPath                string        `flag:"postgres-ca-path" cfg:"postgres_ca_path"`
+	CertPath              string        `flag:"postgres-cert-path" cfg:"postgres_cert_path"`
+	KeyPath               string        `flag:"postgres-key-path" cfg:"postgres_key_path"`
+	InsecureSkipTLSVerify bool          `flag:"postgres-insecure-skip-tls-verify" cfg:"postgres_insecure_skip_tls_verify"`
+}
+
 func sessionOptionsDefaults() SessionOptions {
 	return SessionOptions{
-		Type: CookieSessionStoreType,
+		Type:                  CookieSessionStoreType,
+		EvictionPolicy:        OldestSessionEvictionPolicy,
+		UnavailablePolicy:     FailClosedUnavailablePolicy,
+		RevocationCheckMethod: RefreshRevocationMethod,
+		BindIPv4Prefix:        DefaultSessionBindIPv4Prefix,
+		BindIPv6Prefix:        DefaultSessionBindIPv6Prefix,
 		Cookie: CookieStoreOptions{
 			Minimal: false,
 		},
+		Etcd: EtcdStoreOptions{
+			DialTimeout: 5 * time.Second,
+		},
+		Postgres: PostgresStoreOptions{
+			Table:        "oauth2_proxy_sessions",
+			MaxOpenConns: 10,
+			ReapInterval: 5 * time.Minute,
+		},
 	}
 }
@@ -0,0 +1,51 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// ShutdownWebSocketDrain waits for hijacked connections, e.g. WebSockets,
+	// to be closed by the client or upstream during shutdown, and closes them
+	// once the shutdown timeout expires.
+	ShutdownWebSocketDrain = "drain"
+
+	// ShutdownWebSocketClose closes hijacked connections, e.g. WebSockets, as
+	// soon as the shutdown starts.
+	ShutdownWebSocketClose = "close"
+)
+
+// Shutdown contains the options for gracefully shutting down the servers
+// on SIGINT or SIGTERM
+type Shutdown struct {
+	// Timeout is how long in-flight requests are given to complete once the
+	// shutdown starts, before their connections are closed.
+	// A timeout of 0 waits for all requests to complete.
+	Timeout time.Duration `flag:"shutdown-timeout" cfg:"shutdown_timeout"`
+
+	// WebSocketPolicy is how hijacked connections, e.g. WebSockets, are
+	// handled during the shutdown, one of drain or close.
+	WebSocketPolicy string `flag:"shutdown-websocket-policy" cfg:"shutdown_websocket_policy"`
+}
+
+func shutdownFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("shutdown", pflag.ExitOnError)
+
+	flagSet.Duration("shutdown-timeout", defaultShutdownTimeout, "how long in-flight requests are given to complete on shutdown before their connections are closed (0 waits indefinitely)")
+	flagSet.String("shutdown-websocket-policy", ShutdownWebSocketDrain, "how WebSocket connections are handled on shutdown (one of: drain, close). Drained connections are closed once the shutdown timeout expires")
+
+	return flagSet
+}
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownDefaults creates a Shutdown and populates it with any default
+// values
+func shutdownDefaults() Shutdown {
+	return Shutdown{
+		Timeout:         defaultShutdownTimeout,
+		WebSocketPolicy: ShutdownWebSocketDrain,
+	}
+}
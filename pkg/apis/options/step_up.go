@@ -0,0 +1,40 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// StepUp contains the options for requiring stronger authentication methods,
+// e.g. MFA, on some routes
+type StepUp struct {
+	// Routes are the routes that require the session to have been
+	// authenticated with one of a set of methods from the amr claim.
+	// Each route is in the format `amr1|amr2:method=path_regex` or
+	// `amr1|amr2:path_regex`.
+	Routes []string `flag:"step-up-route" cfg:"step_up_routes"`
+
+	// AcrValues are the acr_values sent to the provider when the user is
+	// sent to authenticate again for a step-up route.
+	AcrValues string `flag:"step-up-acr-values" cfg:"step_up_acr_values"`
+
+	// Prompt is the prompt sent to the provider when the user is sent to
+	// authenticate again for a step-up route.
+	Prompt string `flag:"step-up-prompt" cfg:"step_up_prompt"`
+}
+
+func stepUpFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("stepup", pflag.ExitOnError)
+
+	flagSet.StringSlice("step-up-route", []string{}, "require the amr claim to contain one of the '|' separated methods for requests matching the route, in the format amr1|amr2:method=path_regex or amr1|amr2:path_regex (may be given multiple times)")
+	flagSet.String("step-up-acr-values", "", "acr_values to request from the provider when authenticating again for a step-up route")
+	flagSet.String("step-up-prompt", defaultStepUpPrompt, "prompt to request from the provider when authenticating again for a step-up route")
+
+	return flagSet
+}
+
+const defaultStepUpPrompt = "login"
+
+// stepUpDefaults creates a StepUp and populates it with any default values
+func stepUpDefaults() StepUp {
+	return StepUp{
+		Prompt: defaultStepUpPrompt,
+	}
+}
@@ -0,0 +1,64 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// UpstreamToken contains the options for minting short lived tokens that
+// clients can exchange their session for, to call APIs that are not behind
+// the proxy
+type UpstreamToken struct {
+	// KeyFile is the path to a PEM encoded RSA private key used to sign the
+	// tokens. The token endpoint is only enabled when this is set.
+	KeyFile string `flag:"upstream-token-key-file" cfg:"upstream_token_key_file"`
+
+	// Issuer is the iss claim of the tokens. It is omitted when empty.
+	Issuer string `flag:"upstream-token-issuer" cfg:"upstream_token_issuer"`
+
+	// Audience is the aud claim of the tokens, identifying the API they
+	// may be used with.
+	Audience string `flag:"upstream-token-audience" cfg:"upstream_token_audience"`
+
+	// Lifetime is how long the tokens are valid for. Tokens never outlive
+	// the session they were minted from.
+	Lifetime time.Duration `flag:"upstream-token-lifetime" cfg:"upstream_token_lifetime"`
+
+	// Claims are the session claims added to the tokens, in the form
+	// `token_claim=session_claim`, or `claim` to use the same name for both.
+	Claims []string `flag:"upstream-token-claim" cfg:"upstream_token_claims"`
+
+	// RateLimit is the number of tokens each user may mint per minute.
+	RateLimit int `flag:"upstream-token-rate-limit" cfg:"upstream_token_rate_limit"`
+}
+
+func upstreamTokenFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("upstreamtoken", pflag.ExitOnError)
+
+	flagSet.String("upstream-token-key-file", "", "path to a PEM encoded RSA private key used to sign short lived tokens minted at /oauth2/upstream_token. The endpoint is disabled when not set")
+	flagSet.String("upstream-token-issuer", "", "the iss claim of minted upstream tokens")
+	flagSet.String("upstream-token-audience", "", "the aud claim of minted upstream tokens")
+	flagSet.Duration("upstream-token-lifetime", defaultUpstreamTokenLifetime, "how long minted upstream tokens are valid for (at most 1h)")
+	flagSet.StringSlice("upstream-token-claim", defaultUpstreamTokenClaims, "session claims to add to minted upstream tokens, as token_claim=session_claim or claim (may be given multiple times)")
+	flagSet.Int("upstream-token-rate-limit", defaultUpstreamTokenRateLimit, "the number of upstream tokens each user may mint per minute")
+
+	return flagSet
+}
+
+const (
+	defaultUpstreamTokenLifetime  = 5 * time.Minute
+	defaultUpstreamTokenRateLimit = 10
+)
+
+var defaultUpstreamTokenClaims = []string{"email", "groups"}
+
+// upstreamTokenDefaults creates an UpstreamToken and populates it with any
+// default values
+func upstreamTokenDefaults() UpstreamToken {
+	return UpstreamToken{
+		Lifetime:  defaultUpstreamTokenLifetime,
+		Claims:    defaultUpstreamTokenClaims,
+		RateLimit: defaultUpstreamTokenRateLimit,
+	}
+}
@@ -5,6 +5,66 @@ import "time"
 const (
 	// DefaultUpstreamFlushInterval is the default value for the Upstream FlushInterval.
 	DefaultUpstreamFlushInterval = 1 * time.Second
+
+	// SetCookiePassthrough passes Set-Cookie headers from the upstream to the
+	// client unchanged.
+	SetCookiePassthrough = "passthrough"
+
+	// SetCookieRewrite rewrites the Domain and Path of Set-Cookie headers from
+	// the upstream so that the cookies are scoped to the proxy.
+	SetCookieRewrite = "rewrite"
+
+	// SetCookieStrip removes all Set-Cookie headers from upstream responses.
+	SetCookieStrip = "strip"
+
+	// WebSocketSessionExpiryContinue lets established WebSocket connections
+	// run to completion after the session has expired.
+	WebSocketSessionExpiryContinue = "continue"
+
+	// WebSocketSessionExpiryClose closes established WebSocket connections
+	// with a close frame when the session expires.
+	WebSocketSessionExpiryClose = "close"
+
+	// DefaultWebSocketCloseCode is the default value for the Upstream
+	// WebSocketCloseCode, the Policy Violation status code.
+	DefaultWebSocketCloseCode = 1008
+
+	// RewriteRequestPath rewrites the path of requests to the upstream.
+	RewriteRequestPath = "requestPath"
+
+	// RewriteRequestHeader rewrites a header of requests to the upstream.
+	RewriteRequestHeader = "requestHeader"
+
+	// RewriteResponseHeader rewrites a header of responses from the upstream.
+	RewriteResponseHeader = "responseHeader"
+
+	// AuthRedirectPassthrough passes redirects from the upstream to an
+	// identity provider to the client unchanged, and logs a warning.
+	AuthRedirectPassthrough = "passthrough"
+
+	// AuthRedirectRewrite rewrites the Location of redirects from the
+	// upstream to an identity provider.
+	AuthRedirectRewrite = "rewrite"
+
+	// AuthRedirectError renders the error page in place of redirects from the
+	// upstream to an identity provider.
+	AuthRedirectError = "error"
+
+	// DefaultHealthCheckInterval is the default value for the HealthCheck
+	// Interval.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultHealthCheckTimeout is the default value for the HealthCheck
+	// Timeout.
+	DefaultHealthCheckTimeout = 2 * time.Second
+
+	// DefaultHealthCheckHealthyThreshold is the default value for the
+	// HealthCheck HealthyThreshold.
+	DefaultHealthCheckHealthyThreshold = 2
+
+	// DefaultHealthCheckUnhealthyThreshold is the default value for the
+	// HealthCheck UnhealthyThreshold.
+	DefaultHealthCheckUnhealthyThreshold = 3
 )
 
 // Upstreams is a collection of definitions for upstream servers.
@@ -18,7 +78,9 @@ type Upstream struct {
 	ID string `json:"id,omitempty"`
 
 	// Path is used to map requests to the upstream server.
-	// The closest match will take precedence and all Paths must be unique.
+	// The closest match will take precedence and all Paths must be unique,
+	// unless the upstreams sharing a Path form a weighted group (see Weight)
+	// or select between them by a claim (see RoutingClaim).
 	// Path can also take a pattern when used with RewriteTarget.
 	// Path segments can be captured and matched using regular experessions.
 	// Eg:
@@ -35,14 +97,17 @@ type Upstream struct {
 	// upstream server.
 	RewriteTarget string `json:"rewriteTarget,omitempty"`
 
-	// The URI of the upstream server. This may be an HTTP(S) server of a File
-	// based URL. It may include a path, in which case all requests will be served
-	// under that path.
+	// The URI of the upstream server. This may be an HTTP(S) server, a File
+	// based URL, or a FastCGI server over TCP or a unix socket (see FastCGI).
+	// HTTP(S) URIs may include a path, in which case all requests will be
+	// served under that path.
 	// Eg:
 	// - http://localhost:8080
 	// - https://service.localhost
 	// - https://service.localhost/path
 	// - file://host/path
+	// - fcgi://localhost:9000
+	// - fcgi+unix:///run/php/php-fpm.sock
 	// If the URI's path is "/base" and the incoming request was for "/dir",
 	// the upstream request will be for "/base/dir".
 	URI string `json:"uri,omitempty"`
@@ -65,9 +130,26 @@ type Upstream struct {
 
 	// FlushInterval is the period between flushing the response buffer when
 	// streaming response from the upstream.
+	// A negative value flushes each write to the client immediately, and 0
+	// disables periodic flushing, so the response is only sent once the
+	// buffer is full or the response is complete.
 	// Defaults to 1 second.
 	FlushInterval *Duration `json:"flushInterval,omitempty"`
 
+	// EventStreamFlushInterval is the period between flushing the response
+	// buffer when streaming Server-Sent Events (`text/event-stream`) responses
+	// from the upstream. FlushInterval does not apply to these responses.
+	// Defaults to 0, flushing each event to the client immediately.
+	EventStreamFlushInterval *Duration `json:"eventStreamFlushInterval,omitempty"`
+
+	// Timeout is the maximum duration of a request to the upstream, including
+	// reading the response body. When it is exceeded the upstream request is
+	// cancelled, and a 504 Gateway Timeout error page is returned if the
+	// response has not started yet.
+	// This applies to HTTP(S) upstreams, but not to WebSocket connections.
+	// Defaults to 0, no timeout.
+	Timeout *Duration `json:"timeout,omitempty"`
+
 	// PassHostHeader determines whether the request host header should be proxied
 	// to the upstream server.
 	// Defaults to true.
@@ -76,4 +158,213 @@ type Upstream struct {
 	// ProxyWebSockets enables proxying of websockets to upstream servers
 	// Defaults to true.
 	ProxyWebSockets *bool `json:"proxyWebSockets,omitempty"`
+
+	// PassAccessToken injects the session's access token into requests to
+	// this upstream, as the X-Forwarded-Access-Token header, eg. so that an
+	// API gateway can call services on behalf of the user. Any
+	// X-Forwarded-Access-Token header sent by the client is removed.
+	// Other upstreams do not receive the access token, unless it is injected
+	// into the requests to every upstream with InjectRequestHeaders.
+	// Defaults to false.
+	PassAccessToken bool `json:"passAccessToken,omitempty"`
+
+	// SetCookieHandling determines how Set-Cookie headers in responses from
+	// the upstream server are handled.
+	// Valid values are:
+	// - `passthrough`: Pass the Set-Cookie headers to the client unchanged
+	// - `rewrite`: Remove the Domain attribute so that cookies are scoped to
+	// the proxy host, and restrict the Path to the upstream Path if the cookie
+	// would otherwise apply outside of it
+	// - `strip`: Remove all Set-Cookie headers from the response
+	// Defaults to passthrough.
+	SetCookieHandling string `json:"setCookieHandling,omitempty"`
+
+	// WebSocketSessionExpiry determines what happens to established WebSocket
+	// connections when the session that authenticated the upgrade request
+	// expires. WebSocket upgrade requests are always authenticated before
+	// the connection is proxied, this only applies to connections that
+	// outlive the expiry of the session (its access token's expiry).
+	// Valid values are:
+	// - `continue`: Let the connection run to completion. Users keep access
+	// to the upstream after their session has expired for as long as the
+	// connection stays open.
+	// - `close`: Send a close frame with the WebSocketCloseCode to the client
+	// and close the connection when the session expires, so that the client
+	// knows to reauthenticate and reconnect.
+	// Defaults to continue.
+	WebSocketSessionExpiry string `json:"webSocketSessionExpiry,omitempty"`
+
+	// WebSocketCloseCode is the status code of the close frame sent when a
+	// WebSocket connection is closed because the session expired.
+	// This option can only be used with a WebSocketSessionExpiry of close.
+	// Defaults to 1008 (Policy Violation).
+	WebSocketCloseCode *int `json:"webSocketCloseCode,omitempty"`
+
+	// Weight allows multiple upstreams to share the same Path, eg. to send a
+	// share of the traffic to a canary release.
+	// Upstreams that share a Path form a weighted group, and must all set a
+	// Weight and the same RewriteTarget. Requests are distributed across the
+	// group in proportion to the weights, using a weighted round robin.
+	// A Weight of 0 stops requests being sent to the upstream.
+	Weight *int `json:"weight,omitempty"`
+
+	// Sticky makes the weighted group consistently proxy each user to the same
+	// upstream, based on the user's session, rather than using a round robin.
+	// Requests without a session still use the round robin.
+	// This must be set on all or none of the upstreams in a weighted group.
+	// Defaults to false.
+	Sticky bool `json:"sticky,omitempty"`
+
+	// RoutingClaim selects between the upstreams that share a Path based on
+	// the value of a claim in the user's session, eg. to proxy each tenant to
+	// their own backend. It must be one of `user`, `email`, `groups` or
+	// `preferred_username`, and must be the same on all of the upstreams
+	// sharing the Path. Upstreams that set a RoutingClaim can't set a Weight.
+	RoutingClaim string `json:"routingClaim,omitempty"`
+
+	// RoutingClaimValues are the values of the RoutingClaim that are proxied
+	// to this upstream. Each value may only be routed to one upstream.
+	// For claims with multiple values, such as groups, the first value that is
+	// routed to an upstream is used.
+	// One upstream sharing the Path may leave this empty to serve requests
+	// whose claim value isn't routed to another upstream. Without it, these
+	// requests receive a 403 Forbidden error.
+	RoutingClaimValues []string `json:"routingClaimValues,omitempty"`
+
+	// FastCGI configures how requests are passed to FastCGI upstreams, eg.
+	// PHP-FPM. This is required for upstreams with a fcgi or fcgi+unix URI.
+	// The identity of the user is passed as CGI params rather than headers:
+	// the REMOTE_USER param is set to the user of the session, and the
+	// request headers, including those set by InjectRequestHeaders, are
+	// passed as HTTP_* params, eg. X-Forwarded-User as HTTP_X_FORWARDED_USER.
+	FastCGI *FastCGIOptions `json:"fastCGI,omitempty"`
+
+	// RewriteRules rewrite requests as they are proxied to the upstream, and
+	// responses as they are returned from the upstream, eg. to rewrite the
+	// Location header of redirects from the upstream's own host to the proxy.
+	// Rules are applied in the order they are listed, and each rule sees the
+	// value as rewritten by the rules before it.
+	// Request rules are applied after the RewriteTarget and before the request
+	// is signed, including to WebSocket requests, and response rules after the
+	// SetCookieHandling.
+	RewriteRules []RewriteRule `json:"rewriteRules,omitempty"`
+
+	// AuthRedirect configures how redirects from the upstream to an identity
+	// provider are handled, eg. for upstreams that authenticate users
+	// themselves, to prevent users that are already authenticated with the
+	// proxy from being sent through a second login.
+	// These redirects are handled before the RewriteRules are applied.
+	AuthRedirect *AuthRedirectOptions `json:"authRedirect,omitempty"`
+
+	// HealthCheck enables active health checks of the upstream, eg. so that
+	// a weighted group stops sending requests to an upstream that is down
+	// until it recovers. Requests are never proxied to an upstream while it
+	// is unhealthy: a weighted group sends them to the healthy upstreams in
+	// the group, and otherwise they receive a 503 Service Unavailable error.
+	// Upstreams are assumed to be healthy when the proxy starts.
+	// This can only be used with HTTP(S) upstreams.
+	HealthCheck *HealthCheckOptions `json:"healthCheck,omitempty"`
+}
+
+// HealthCheckOptions configures the active health checks of an upstream.
+type HealthCheckOptions struct {
+	// Path is the path that is requested from the upstream to check its
+	// health. The upstream passes the check when it responds with a 2xx or
+	// 3xx status.
+	// Defaults to `/`.
+	Path string `json:"path,omitempty"`
+
+	// Interval is the period between health checks.
+	// Defaults to 10 seconds.
+	Interval *Duration `json:"interval,omitempty"`
+
+	// Timeout is the maximum duration of a health check, after which the
+	// check fails.
+	// Defaults to 2 seconds.
+	Timeout *Duration `json:"timeout,omitempty"`
+
+	// HealthyThreshold is the number of consecutive checks an unhealthy
+	// upstream must pass to be marked healthy again.
+	// Defaults to 2.
+	HealthyThreshold int `json:"healthyThreshold,omitempty"`
+
+	// UnhealthyThreshold is the number of consecutive checks a healthy
+	// upstream must fail to be marked unhealthy.
+	// Defaults to 3.
+	UnhealthyThreshold int `json:"unhealthyThreshold,omitempty"`
+}
+
+// AuthRedirectOptions configures how redirects from an upstream to an
+// identity provider are handled.
+type AuthRedirectOptions struct {
+	// Hosts are the hosts of the identity providers. Redirect responses from
+	// the upstream with a Location on one of these hosts are handled.
+	// A leading `.` matches the host and any of its subdomains, eg.
+	// `.login.example.com`.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Handling determines how the redirects are handled.
+	// Valid values are:
+	// - `passthrough`: Pass the redirect to the client unchanged, and log a
+	// warning
+	// - `rewrite`: Rewrite the Location of the redirect to the Location
+	// - `error`: Render the error page with a 502 Bad Gateway status
+	// Defaults to passthrough.
+	Handling string `json:"handling,omitempty"`
+
+	// Location is the URL that redirects are rewritten to when the Handling
+	// is `rewrite`.
+	// Defaults to `/`.
+	Location string `json:"location,omitempty"`
+}
+
+// RewriteRule rewrites the request path, or a request or response header,
+// by replacing the matches of a regular expression.
+type RewriteRule struct {
+	// Target is what the rule rewrites.
+	// Valid values are:
+	// - `requestPath`: The path of the request to the upstream, excluding
+	// the query string
+	// - `requestHeader`: A header of the request to the upstream
+	// - `responseHeader`: A header of the response from the upstream
+	Target string `json:"target,omitempty"`
+
+	// Header is the name of the header to rewrite.
+	// This is required for header targets, and each value of the header is
+	// rewritten. Requests or responses without the header are not changed.
+	Header string `json:"header,omitempty"`
+
+	// Match is the regular expression that is matched against the value.
+	// A value that doesn't match is not changed.
+	Match string `json:"match,omitempty"`
+
+	// Replacement replaces each match within the value.
+	// Groups captured by the Match can be used within the replacement.
+	// Eg: With a Match of `^https://internal\.example\.com(/.*)?$`, a
+	// Replacement of `https://example.com$1` would rewrite the redirect
+	// `https://internal.example.com/login` to `https://example.com/login`.
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// FastCGIOptions configures how requests are passed to a FastCGI upstream.
+type FastCGIOptions struct {
+	// DocumentRoot is the directory on the FastCGI server that scripts are
+	// served from. The SCRIPT_FILENAME of each request is the request path
+	// within the DocumentRoot.
+	// Either DocumentRoot or ScriptFilename is required.
+	DocumentRoot string `json:"documentRoot,omitempty"`
+
+	// ScriptFilename is the script on the FastCGI server that serves every
+	// request, eg. the front controller of a PHP application. Setting this
+	// overrides the script determined from the DocumentRoot.
+	ScriptFilename string `json:"scriptFilename,omitempty"`
+
+	// Index is the script that serves requests for paths ending with `/`.
+	// Defaults to index.php.
+	Index string `json:"index,omitempty"`
+
+	// Params are additional CGI params passed with every request.
+	// They override the params of the request, except for REMOTE_USER and
+	// AUTH_TYPE, which are always set from the session.
+	Params map[string]string `json:"params,omitempty"`
 }
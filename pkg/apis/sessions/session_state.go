@@ -3,6 +3,10 @@ package sessions
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,11 +18,28 @@ import (
 	"github.com/vmihailenco/msgpack/v4"
 )
 
+// signedOnlyPrefix marks a session encoded by EncodeSessionStateSignedOnly,
+// so that it can be told apart from an encrypted session when it is loaded
+var signedOnlyPrefix = []byte("signed:")
+
+// encryptedTokensMACPrefix is signed along with a session encoded by
+// EncodeSessionStateWithEncryptedTokens, so that its signature can't be
+// used as the signature of another kind of data
+var encryptedTokensMACPrefix = []byte("encrypted-tokens:")
+
 // SessionState is used to store information about the currently authenticated user session
 type SessionState struct {
+	// CreatedAt is when the session was created, or last refreshed with the
+	// provider. It is the start of the refresh period of the session.
 	CreatedAt *time.Time `msgpack:"ca,omitempty"`
 	ExpiresOn *time.Time `msgpack:"eo,omitempty"`
 
+	// AuthenticatedAt is when the user last logged in with the provider.
+	// Unlike CreatedAt, it is not updated when the session is refreshed, so
+	// it can be used to limit the absolute lifetime of a session.
+	// The last activity of a session is not recorded.
+	AuthenticatedAt *time.Time `msgpack:"aa,omitempty"`
+
 	AccessToken  string `msgpack:"at,omitempty"`
 	IDToken      string `msgpack:"it,omitempty"`
 	RefreshToken string `msgpack:"rt,omitempty"`
@@ -30,9 +51,50 @@ type SessionState struct {
 	Groups            []string `msgpack:"g,omitempty"`
 	PreferredUsername string   `msgpack:"pu,omitempty"`
 
+	// AMR holds the authentication methods the user logged in with, from
+	// the amr claim of the ID token
+	AMR []string `msgpack:"amr,omitempty"`
+
+	// Binding binds the session to properties of the client that created
+	// it, so that it is rejected when used by another client
+	Binding string `msgpack:"b,omitempty"`
+
+	// RevocationCheckedAt is when the refresh token of the session was last
+	// checked for revocation with the provider
+	RevocationCheckedAt *time.Time `msgpack:"rc,omitempty"`
+
+	// FeatureFlags are the feature flags enabled for the user, evaluated
+	// from the claims of the session when it was created or last refreshed
+	FeatureFlags []string `msgpack:"ff,omitempty"`
+
 	// Internal helpers, not serialized
 	Clock clock.Clock `msgpack:"-"`
 	Lock  Lock        `msgpack:"-"`
+
+	// encryptedTokens holds the tokens of a session decoded with
+	// DecodeSessionStateWithEncryptedTokens until DecryptTokens is called.
+	encryptedTokens []byte
+	tokenCipher     encryption.Cipher
+}
+
+// sessionTokens are the sensitive fields of a SessionState that are
+// encrypted separately from the rest of the session by
+// EncodeSessionStateWithEncryptedTokens.
+type sessionTokens struct {
+	AccessToken  string `msgpack:"at,omitempty"`
+	IDToken      string `msgpack:"it,omitempty"`
+	RefreshToken string `msgpack:"rt,omitempty"`
+}
+
+// encryptedTokensSession is the serialized form of a SessionState when only
+// its tokens are encrypted.
+type encryptedTokensSession struct {
+	// Session is the MessagePack encoded SessionState, without any tokens
+	Session []byte `msgpack:"s"`
+	// Tokens is the encrypted, MessagePack encoded sessionTokens
+	Tokens []byte `msgpack:"t,omitempty"`
+	// MAC is the HMAC of the Session and the Tokens
+	MAC []byte `msgpack:"m,omitempty"`
 }
 
 func (s *SessionState) ObtainLock(ctx context.Context, expiration time.Duration) error {
@@ -69,6 +131,12 @@ func (s *SessionState) CreatedAtNow() {
 	s.CreatedAt = &now
 }
 
+// AuthenticatedAtNow sets a SessionState's AuthenticatedAt to now
+func (s *SessionState) AuthenticatedAtNow() {
+	now := s.Clock.Now()
+	s.AuthenticatedAt = &now
+}
+
 // SetExpiresOn sets an expiration
 func (s *SessionState) SetExpiresOn(exp time.Time) {
 	s.ExpiresOn = &exp
@@ -100,6 +168,23 @@ func (s *SessionState) Age() time.Duration {
 	return 0
 }
 
+// RevocationCheckedNow sets a SessionState's RevocationCheckedAt to now
+func (s *SessionState) RevocationCheckedNow() {
+	now := s.Clock.Now()
+	s.RevocationCheckedAt = &now
+}
+
+// SinceRevocationCheck returns how long it has been since the refresh token
+// of the session was last known not to be revoked: when it was last checked,
+// or when the session was created or refreshed, whichever is later
+func (s *SessionState) SinceRevocationCheck() time.Duration {
+	if s.RevocationCheckedAt != nil && !s.RevocationCheckedAt.IsZero() &&
+		(s.CreatedAt == nil || s.RevocationCheckedAt.After(*s.CreatedAt)) {
+		return s.Clock.Now().Truncate(time.Second).Sub(*s.RevocationCheckedAt)
+	}
+	return s.Age()
+}
+
 // String constructs a summary of the session state
 func (s *SessionState) String() string {
 	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", s.Email, s.User, s.PreferredUsername)
@@ -115,6 +200,9 @@ func (s *SessionState) String() string {
 	if s.ExpiresOn != nil && !s.ExpiresOn.IsZero() {
 		o += fmt.Sprintf(" expires:%s", s.ExpiresOn)
 	}
+	if s.AuthenticatedAt != nil && !s.AuthenticatedAt.IsZero() {
+		o += fmt.Sprintf(" authenticated:%s", s.AuthenticatedAt)
+	}
 	if s.RefreshToken != "" {
 		o += " refresh_token:true"
 	}
@@ -129,6 +217,13 @@ func (s *SessionState) GetClaim(claim string) []string {
 		return []string{}
 	}
 	switch claim {
+	case "access_token", "id_token", "refresh_token":
+		// Tokens that cannot be decrypted cannot be used
+		if err := s.DecryptTokens(); err != nil {
+			return []string{}
+		}
+	}
+	switch claim {
 	case "access_token":
 		return []string{s.AccessToken}
 	case "id_token":
@@ -137,6 +232,11 @@ func (s *SessionState) GetClaim(claim string) []string {
 		return []string{s.CreatedAt.String()}
 	case "expires_on":
 		return []string{s.ExpiresOn.String()}
+	case "authenticated_at":
+		if s.AuthenticatedAt == nil {
+			return []string{}
+		}
+		return []string{s.AuthenticatedAt.String()}
 	case "refresh_token":
 		return []string{s.RefreshToken}
 	case "email":
@@ -149,6 +249,10 @@ func (s *SessionState) GetClaim(claim string) []string {
 		return groups
 	case "preferred_username":
 		return []string{s.PreferredUsername}
+	case "feature_flags":
+		flags := make([]string, len(s.FeatureFlags))
+		copy(flags, s.FeatureFlags)
+		return flags
 	default:
 		return []string{}
 	}
@@ -201,6 +305,212 @@ func DecodeSessionState(data []byte, c encryption.Cipher, compressed bool) (*Ses
 	return &ss, nil
 }
 
+// EncodeSessionStateWithEncryptedTokens returns an optionally lz4 compressed,
+// MessagePack encoded session in which only the access, ID and refresh tokens
+// are encrypted.
+// The remaining session metadata is not encrypted, but the whole session is
+// signed with an HMAC of the key, so that the metadata can't be changed.
+func (s *SessionState) EncodeSessionStateWithEncryptedTokens(c encryption.Cipher, key []byte, compress bool) ([]byte, error) {
+	// Any tokens that were never decrypted must be carried over into
+	// the new encoding
+	if err := s.DecryptTokens(); err != nil {
+		return nil, err
+	}
+
+	metadata := *s
+	metadata.AccessToken = ""
+	metadata.IDToken = ""
+	metadata.RefreshToken = ""
+
+	encoded := encryptedTokensSession{}
+	var err error
+	encoded.Session, err = msgpack.Marshal(&metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling session state to msgpack: %w", err)
+	}
+
+	if s.AccessToken != "" || s.IDToken != "" || s.RefreshToken != "" {
+		packedTokens, err := msgpack.Marshal(&sessionTokens{
+			AccessToken:  s.AccessToken,
+			IDToken:      s.IDToken,
+			RefreshToken: s.RefreshToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling session tokens to msgpack: %w", err)
+		}
+		encoded.Tokens, err = c.Encrypt(packedTokens)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting the session tokens: %w", err)
+		}
+	}
+	encoded.MAC = encryptedTokensMAC(key, &encoded)
+
+	packed, err := msgpack.Marshal(&encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling session state to msgpack: %w", err)
+	}
+
+	if !compress {
+		return packed, nil
+	}
+	return lz4Compress(packed)
+}
+
+// DecodeSessionStateWithEncryptedTokens decodes a session encoded by
+// EncodeSessionStateWithEncryptedTokens, after verifying its signature.
+// The tokens are not decrypted until they are needed, either by calling
+// DecryptTokens or by requesting them via GetClaim.
+func DecodeSessionStateWithEncryptedTokens(data []byte, c encryption.Cipher, key []byte, compressed bool) (*SessionState, error) {
+	packed := data
+	if compressed {
+		var err error
+		packed, err = lz4Decompress(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var encoded encryptedTokensSession
+	err := msgpack.Unmarshal(packed, &encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling data to session state: %w", err)
+	}
+	if !hmac.Equal(encoded.MAC, encryptedTokensMAC(key, &encoded)) {
+		return nil, errors.New("session state signature not valid")
+	}
+
+	var ss SessionState
+	err = msgpack.Unmarshal(encoded.Session, &ss)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling data to session state: %w", err)
+	}
+
+	if len(encoded.Tokens) > 0 {
+		ss.encryptedTokens = encoded.Tokens
+		ss.tokenCipher = c
+	}
+	return &ss, nil
+}
+
+// DecryptTokens decrypts the tokens of a session that was decoded by
+// DecodeSessionStateWithEncryptedTokens and populates them on the session.
+// It should be called before the tokens are read directly from the session,
+// eg. before refreshing or validating the session with the provider.
+// It is a no-op when there are no tokens waiting to be decrypted.
+func (s *SessionState) DecryptTokens() error {
+	if s.encryptedTokens == nil {
+		return nil
+	}
+
+	packedTokens, err := s.tokenCipher.Decrypt(s.encryptedTokens)
+	if err != nil {
+		return fmt.Errorf("error decrypting the session tokens: %w", err)
+	}
+
+	var tokens sessionTokens
+	err = msgpack.Unmarshal(packedTokens, &tokens)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling data to session tokens: %w", err)
+	}
+
+	s.AccessToken = tokens.AccessToken
+	s.IDToken = tokens.IDToken
+	s.RefreshToken = tokens.RefreshToken
+	s.encryptedTokens = nil
+	s.tokenCipher = nil
+	return nil
+}
+
+// EncodeSessionStateSignedOnly returns an optionally lz4 compressed,
+// MessagePack encoded session that is signed with an HMAC of the key, but is
+// not encrypted.
+// The whole session, including the tokens, can be read by anyone with access
+// to the encoded session.
+func (s *SessionState) EncodeSessionStateSignedOnly(key []byte, compress bool) ([]byte, error) {
+	// Any tokens that were never decrypted must be carried over into
+	// the new encoding
+	if err := s.DecryptTokens(); err != nil {
+		return nil, err
+	}
+
+	payload, err := msgpack.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling session state to msgpack: %w", err)
+	}
+
+	if compress {
+		payload, err = lz4Compress(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encoded := make([]byte, 0, len(signedOnlyPrefix)+sha256.Size+len(payload))
+	encoded = append(encoded, signedOnlyPrefix...)
+	encoded = append(encoded, signedOnlyMAC(key, payload)...)
+	return append(encoded, payload...), nil
+}
+
+// IsSessionStateSignedOnly returns whether the data is a session encoded by
+// EncodeSessionStateSignedOnly, rather than an encrypted session
+func IsSessionStateSignedOnly(data []byte) bool {
+	return bytes.HasPrefix(data, signedOnlyPrefix)
+}
+
+// DecodeSessionStateSignedOnly decodes a session encoded by
+// EncodeSessionStateSignedOnly, after verifying its signature
+func DecodeSessionStateSignedOnly(data []byte, key []byte, compressed bool) (*SessionState, error) {
+	if !IsSessionStateSignedOnly(data) || len(data) < len(signedOnlyPrefix)+sha256.Size {
+		return nil, errors.New("session state is not signed only")
+	}
+
+	signed := data[len(signedOnlyPrefix):]
+	mac, payload := signed[:sha256.Size], signed[sha256.Size:]
+	if !hmac.Equal(mac, signedOnlyMAC(key, payload)) {
+		return nil, errors.New("session state signature not valid")
+	}
+
+	packed := payload
+	if compressed {
+		var err error
+		packed, err = lz4Decompress(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ss SessionState
+	err := msgpack.Unmarshal(packed, &ss)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling data to session state: %w", err)
+	}
+	return &ss, nil
+}
+
+// signedOnlyMAC signs the payload of a signed only session. The prefix is
+// included so that the key is not used to sign arbitrary data.
+func signedOnlyMAC(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signedOnlyPrefix)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encryptedTokensMAC signs the session and the tokens of a session encoded
+// with encrypted tokens. The length of the session is included so that bytes
+// can't be moved between the session and the tokens.
+func encryptedTokensMAC(key []byte, encoded *encryptedTokensSession) []byte {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(encoded.Session)))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encryptedTokensMACPrefix)
+	mac.Write(length)
+	mac.Write(encoded.Session)
+	mac.Write(encoded.Tokens)
+	return mac.Sum(nil)
+}
+
 // lz4Compress compresses with LZ4
 //
 // The Compress:Decompress ratio is 1:Many. LZ4 gives fastest decompress speeds
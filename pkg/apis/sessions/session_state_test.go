@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +28,18 @@ func TestCreatedAtNow(t *testing.T) {
 	g.Expect(*ss.CreatedAt).To(Equal(now))
 }
 
+func TestAuthenticatedAtNow(t *testing.T) {
+	g := NewWithT(t)
+	ss := &SessionState{}
+
+	now := time.Unix(1234567890, 0)
+	ss.Clock.Set(now)
+
+	ss.AuthenticatedAtNow()
+	g.Expect(*ss.AuthenticatedAt).To(Equal(now))
+	g.Expect(ss.GetClaim("authenticated_at")).To(Equal([]string{now.String()}))
+}
+
 func TestExpiresIn(t *testing.T) {
 	g := NewWithT(t)
 	ss := &SessionState{}
@@ -95,6 +108,16 @@ func TestString(t *testing.T) {
 			},
 			expected: "Session{email:email@email.email user:some.user PreferredUsername:preferred.user expires:2000-01-01 01:00:00 +0000 UTC}",
 		},
+		{
+			name: "With an AuthenticatedAt",
+			sessionState: &SessionState{
+				Email:             "email@email.email",
+				User:              "some.user",
+				PreferredUsername: "preferred.user",
+				AuthenticatedAt:   &created,
+			},
+			expected: "Session{email:email@email.email user:some.user PreferredUsername:preferred.user authenticated:2000-01-01 00:00:00 +0000 UTC}",
+		},
 		{
 			name: "With an AccessToken",
 			sessionState: &SessionState{
@@ -157,6 +180,39 @@ func TestAge(t *testing.T) {
 	assert.Equal(t, time.Hour, ss.Age().Round(time.Minute))
 }
 
+func TestAuthenticatedAtClaim(t *testing.T) {
+	ss := &SessionState{}
+
+	// Saved before the authentication was recorded
+	assert.Equal(t, []string{}, ss.GetClaim("authenticated_at"))
+
+	authenticated := time.Now().Add(-3 * time.Hour)
+	ss.AuthenticatedAt = &authenticated
+	assert.Equal(t, []string{authenticated.String()}, ss.GetClaim("authenticated_at"))
+}
+
+func TestSinceRevocationCheck(t *testing.T) {
+	ss := &SessionState{}
+
+	// Created at and revocation checked at unset so should be 0
+	assert.Equal(t, time.Duration(0), ss.SinceRevocationCheck())
+
+	// Never checked, so should be the age of the session
+	ss.CreatedAt = timePtr(time.Now().Add(-1 * time.Hour))
+	assert.Equal(t, time.Hour, ss.SinceRevocationCheck().Round(time.Minute))
+
+	// Checked 10 minutes ago
+	ss.RevocationCheckedAt = timePtr(time.Now().Add(-10 * time.Minute))
+	assert.Equal(t, 10*time.Minute, ss.SinceRevocationCheck().Round(time.Minute))
+
+	// Refreshed since it was checked
+	ss.CreatedAt = timePtr(time.Now().Add(-5 * time.Minute))
+	assert.Equal(t, 5*time.Minute, ss.SinceRevocationCheck().Round(time.Minute))
+
+	ss.RevocationCheckedNow()
+	assert.Equal(t, time.Duration(0), ss.SinceRevocationCheck().Round(time.Minute))
+}
+
 // TestEncodeAndDecodeSessionState encodes & decodes various session states
 // and confirms the operation is 1:1
 func TestEncodeAndDecodeSessionState(t *testing.T) {
@@ -175,6 +231,7 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
 			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
 			CreatedAt:         &created,
+			AuthenticatedAt:   &created,
 			ExpiresOn:         &expires,
 			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
 			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
@@ -224,6 +281,19 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
 			Groups:            []string{"group-a", "group-b"},
 		},
+		"With feature flags": {
+			Email:             "username@example.com",
+			User:              "username",
+			PreferredUsername: "preferred.username",
+			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:         &created,
+			ExpiresOn:         &expires,
+			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
+			Groups:            []string{"group-a", "group-b"},
+			FeatureFlags:      []string{"beta", "new-ui"},
+		},
 	}
 
 	for _, secretSize := range []int{16, 24, 32} {
@@ -268,6 +338,198 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 	}
 }
 
+// TestEncodeAndDecodeSessionStateWithEncryptedTokens encodes & decodes
+// sessions with only the tokens encrypted and confirms the tokens are only
+// available once decrypted
+func TestEncodeAndDecodeSessionStateWithEncryptedTokens(t *testing.T) {
+	created := time.Now()
+	expires := time.Now().Add(time.Duration(1) * time.Hour)
+
+	testCases := map[string]SessionState{
+		"Full session": {
+			Email:             "username@example.com",
+			User:              "username",
+			PreferredUsername: "preferred.username",
+			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:         &created,
+			ExpiresOn:         &expires,
+			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
+			Groups:            []string{"group-a", "group-b"},
+		},
+		"No tokens": {
+			Email:     "username@example.com",
+			User:      "username",
+			CreatedAt: &created,
+		},
+	}
+
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secret)
+	assert.NoError(t, err)
+
+	cfb, err := encryption.NewCFBCipher(secret)
+	assert.NoError(t, err)
+	gcm, err := encryption.NewGCMCipher(secret)
+	assert.NoError(t, err)
+
+	ciphers := map[string]encryption.Cipher{
+		"CFB cipher": cfb,
+		"GCM cipher": gcm,
+	}
+
+	for cipherName, c := range ciphers {
+		t.Run(cipherName, func(t *testing.T) {
+			for testName, ss := range testCases {
+				t.Run(testName, func(t *testing.T) {
+					for _, compress := range []bool{false, true} {
+						encoded, err := ss.EncodeSessionStateWithEncryptedTokens(c, secret, compress)
+						assert.NoError(t, err)
+						if !compress {
+							assert.NotContains(t, string(encoded), "Token.")
+							assert.Contains(t, string(encoded), ss.Email)
+						}
+
+						decoded, err := DecodeSessionStateWithEncryptedTokens(encoded, c, secret, compress)
+						assert.NoError(t, err)
+						assert.Equal(t, ss.Email, decoded.Email)
+						assert.Equal(t, "", decoded.AccessToken)
+						assert.Equal(t, "", decoded.RefreshToken)
+
+						// Requesting a token claim decrypts the tokens
+						assert.Equal(t, []string{ss.IDToken}, decoded.GetClaim("id_token"))
+						compareSessionStates(t, decoded, &ss)
+
+						// Decrypting again is a no-op
+						assert.NoError(t, decoded.DecryptTokens())
+						compareSessionStates(t, decoded, &ss)
+					}
+				})
+			}
+		})
+	}
+
+	t.Run("Re-encoding a session that has not been decrypted", func(t *testing.T) {
+		ss := testCases["Full session"]
+		encoded, err := ss.EncodeSessionStateWithEncryptedTokens(gcm, secret, false)
+		assert.NoError(t, err)
+		decoded, err := DecodeSessionStateWithEncryptedTokens(encoded, gcm, secret, false)
+		assert.NoError(t, err)
+
+		reencoded, err := decoded.EncodeSessionStateWithEncryptedTokens(gcm, secret, false)
+		assert.NoError(t, err)
+		redecoded, err := DecodeSessionStateWithEncryptedTokens(reencoded, gcm, secret, false)
+		assert.NoError(t, err)
+		assert.NoError(t, redecoded.DecryptTokens())
+		compareSessionStates(t, redecoded, &ss)
+	})
+
+	t.Run("Decrypting with the wrong cipher", func(t *testing.T) {
+		ss := testCases["Full session"]
+		encoded, err := ss.EncodeSessionStateWithEncryptedTokens(gcm, secret, false)
+		assert.NoError(t, err)
+
+		otherSecret := make([]byte, 32)
+		_, err = io.ReadFull(rand.Reader, otherSecret)
+		assert.NoError(t, err)
+		otherGCM, err := encryption.NewGCMCipher(otherSecret)
+		assert.NoError(t, err)
+
+		decoded, err := DecodeSessionStateWithEncryptedTokens(encoded, otherGCM, secret, false)
+		assert.NoError(t, err)
+		assert.Error(t, decoded.DecryptTokens())
+		assert.Equal(t, []string{}, decoded.GetClaim("access_token"))
+	})
+
+	t.Run("Modified sessions", func(t *testing.T) {
+		ss := testCases["Full session"]
+		encoded, err := ss.EncodeSessionStateWithEncryptedTokens(gcm, secret, false)
+		assert.NoError(t, err)
+
+		modified := []byte(strings.Replace(string(encoded), "username@example.com", "attacker@example.com", 1))
+		_, err = DecodeSessionStateWithEncryptedTokens(modified, gcm, secret, false)
+		assert.EqualError(t, err, "session state signature not valid")
+	})
+
+	t.Run("Signed with another secret", func(t *testing.T) {
+		ss := testCases["Full session"]
+		encoded, err := ss.EncodeSessionStateWithEncryptedTokens(gcm, secret, false)
+		assert.NoError(t, err)
+
+		_, err = DecodeSessionStateWithEncryptedTokens(encoded, gcm, []byte("another secret"), false)
+		assert.EqualError(t, err, "session state signature not valid")
+	})
+}
+
+// TestEncodeAndDecodeSessionStateSignedOnly encodes & decodes signed only
+// sessions and confirms that they can be told apart from encrypted sessions
+// and cannot be modified
+func TestEncodeAndDecodeSessionStateSignedOnly(t *testing.T) {
+	created := time.Now()
+	ss := SessionState{
+		Email:        "username@example.com",
+		User:         "username",
+		AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+		RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+		CreatedAt:    &created,
+		Groups:       []string{"group-a", "group-b"},
+	}
+
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secret)
+	assert.NoError(t, err)
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed: %t", compress), func(t *testing.T) {
+			encoded, err := ss.EncodeSessionStateSignedOnly(secret, compress)
+			assert.NoError(t, err)
+			assert.True(t, IsSessionStateSignedOnly(encoded))
+			if !compress {
+				assert.Contains(t, string(encoded), ss.AccessToken)
+			}
+
+			decoded, err := DecodeSessionStateSignedOnly(encoded, secret, compress)
+			assert.NoError(t, err)
+			compareSessionStates(t, decoded, &ss)
+		})
+	}
+
+	t.Run("Encrypted sessions are not signed only", func(t *testing.T) {
+		gcm, err := encryption.NewGCMCipher(secret)
+		assert.NoError(t, err)
+
+		encoded, err := ss.EncodeSessionState(gcm, false)
+		assert.NoError(t, err)
+		assert.False(t, IsSessionStateSignedOnly(encoded))
+
+		_, err = DecodeSessionStateSignedOnly(encoded, secret, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("Modified sessions", func(t *testing.T) {
+		encoded, err := ss.EncodeSessionStateSignedOnly(secret, false)
+		assert.NoError(t, err)
+
+		modified := []byte(strings.Replace(string(encoded), "username@example.com", "attacker@example.com", 1))
+		_, err = DecodeSessionStateSignedOnly(modified, secret, false)
+		assert.EqualError(t, err, "session state signature not valid")
+	})
+
+	t.Run("Signed with another secret", func(t *testing.T) {
+		encoded, err := ss.EncodeSessionStateSignedOnly(secret, false)
+		assert.NoError(t, err)
+
+		_, err = DecodeSessionStateSignedOnly(encoded, []byte("another secret"), false)
+		assert.EqualError(t, err, "session state signature not valid")
+	})
+
+	t.Run("Truncated sessions", func(t *testing.T) {
+		_, err := DecodeSessionStateSignedOnly([]byte("signed:abc"), secret, false)
+		assert.Error(t, err)
+	})
+}
+
 func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionState) {
 	if expected.CreatedAt != nil {
 		assert.NotNil(t, actual.CreatedAt)
@@ -281,13 +543,21 @@ func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionS
 	} else {
 		assert.Nil(t, actual.ExpiresOn)
 	}
+	if expected.AuthenticatedAt != nil {
+		assert.NotNil(t, actual.AuthenticatedAt)
+		assert.Equal(t, true, expected.AuthenticatedAt.Equal(*actual.AuthenticatedAt))
+	} else {
+		assert.Nil(t, actual.AuthenticatedAt)
+	}
 
 	// Compare sessions without *time.Time fields
 	exp := *expected
 	exp.CreatedAt = nil
 	exp.ExpiresOn = nil
+	exp.AuthenticatedAt = nil
 	act := *actual
 	act.CreatedAt = nil
 	act.ExpiresOn = nil
+	act.AuthenticatedAt = nil
 	assert.Equal(t, exp, act)
 }
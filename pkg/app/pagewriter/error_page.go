@@ -1,8 +1,11 @@
 package pagewriter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
@@ -23,6 +26,10 @@ type errorPageWriter struct {
 	// template is the error page HTML template.
 	template *template.Template
 
+	// upstreamTemplate is an optional HTML template used in place of the
+	// error page template when there are issues with upstream servers.
+	upstreamTemplate *template.Template
+
 	// proxyPrefix is the prefix under which OAuth2 Proxy pages are served.
 	proxyPrefix string
 
@@ -56,6 +63,11 @@ type ErrorPageOpts struct {
 // It uses the passed redirectURL to give users the option to go back to where
 // they originally came from or try signing in again.
 func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageOpts) {
+	e.writeErrorPage(rw, e.template, opts)
+}
+
+// writeErrorPage renders the error page data with the given template.
+func (e *errorPageWriter) writeErrorPage(rw http.ResponseWriter, tmpl *template.Template, opts ErrorPageOpts) {
 	rw.WriteHeader(opts.Status)
 
 	// We allow unescaped template.HTML since it is user configured options
@@ -80,7 +92,7 @@ func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageO
 		Version:     e.version,
 	}
 
-	if err := e.template.Execute(rw, data); err != nil {
+	if err := tmpl.Execute(rw, data); err != nil {
 		logger.Printf("Error rendering error template: %v", err)
 		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -88,19 +100,45 @@ func (e *errorPageWriter) WriteErrorPage(rw http.ResponseWriter, opts ErrorPageO
 
 // ProxyErrorHandler is used by the upstream ReverseProxy to render error pages
 // when there are issues with upstream servers.
-// It is expected to always render a bad gateway error.
+// It renders a gateway timeout error if the upstream did not respond in time,
+// otherwise a bad gateway error.
+// If an upstream error template was configured, it is used in place of the
+// error page template.
 func (e *errorPageWriter) ProxyErrorHandler(rw http.ResponseWriter, req *http.Request, proxyErr error) {
 	logger.Errorf("Error proxying to upstream server: %v", proxyErr)
 	scope := middlewareapi.GetRequestScope(req)
-	e.WriteErrorPage(rw, ErrorPageOpts{
-		Status:      http.StatusBadGateway,
+
+	status := http.StatusBadGateway
+	message := "There was a problem connecting to the upstream server."
+	if isTimeout(proxyErr) {
+		status = http.StatusGatewayTimeout
+		message = "The upstream server did not respond in time."
+	}
+
+	tmpl := e.template
+	if e.upstreamTemplate != nil {
+		tmpl = e.upstreamTemplate
+	}
+
+	e.writeErrorPage(rw, tmpl, ErrorPageOpts{
+		Status:      status,
 		RedirectURL: "", // The user is already logged in and has hit an upstream error. Makes no sense to redirect in this case.
 		RequestID:   scope.RequestID,
 		AppError:    proxyErr.Error(),
-		Messages:    []interface{}{"There was a problem connecting to the upstream server."},
+		Messages:    []interface{}{message},
 	})
 }
 
+// isTimeout determines whether the error was caused by the upstream server
+// not responding in time.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // getMessage creates the message for the template parameters.
 // If the errorPagewriter.Debug is enabled, the application error takes precedence.
 // Otherwise, any messages will be used.
@@ -1,9 +1,12 @@
 package pagewriter
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"html/template"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
@@ -101,6 +104,55 @@ var _ = Describe("Error Page Writer", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(string(body)).To(Equal("Bad Gateway There was a problem connecting to the upstream server. /prefix/ 502  11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
 		})
+
+		It("Writes a gateway timeout error to the response writer when the upstream times out", func() {
+			req := httptest.NewRequest("", "/gateway-timeout", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				RequestID: testRequestID,
+			})
+			recorder := httptest.NewRecorder()
+			errorPage.ProxyErrorHandler(recorder, req, fmt.Errorf("dial tcp: %w", context.DeadlineExceeded))
+
+			Expect(recorder.Code).To(Equal(http.StatusGatewayTimeout))
+			body, err := ioutil.ReadAll(recorder.Result().Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Gateway Timeout The upstream server did not respond in time. /prefix/ 504  11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
+		})
+
+		Context("With an upstream error template", func() {
+			BeforeEach(func() {
+				tmpl, err := template.New("").Parse("Upstream {{.StatusCode}} {{.RequestID}}")
+				Expect(err).ToNot(HaveOccurred())
+
+				errorPage.upstreamTemplate = tmpl
+			})
+
+			It("Writes the upstream error template to the response writer", func() {
+				req := httptest.NewRequest("", "/bad-gateway", nil)
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+					RequestID: testRequestID,
+				})
+				recorder := httptest.NewRecorder()
+				errorPage.ProxyErrorHandler(recorder, req, errors.New("some upstream error"))
+
+				Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+				body, err := ioutil.ReadAll(recorder.Result().Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Upstream 502 11111111-2222-4333-8444-555555555555"))
+			})
+
+			It("Does not use the upstream error template for other errors", func() {
+				recorder := httptest.NewRecorder()
+				errorPage.WriteErrorPage(recorder, ErrorPageOpts{
+					Status:    403,
+					RequestID: testRequestID,
+				})
+
+				body, err := ioutil.ReadAll(recorder.Result().Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Forbidden You do not have permission to access this resource. /prefix/ 403  11111111-2222-4333-8444-555555555555 Custom Footer Text v0.0.0-test"))
+			})
+		})
 	})
 
 	Context("With Debug enabled", func() {
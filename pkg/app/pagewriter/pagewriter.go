@@ -29,6 +29,11 @@ type Opts struct {
 	// TemplatesPath is the path from which to load custom templates for the sign-in and error pages.
 	TemplatesPath string
 
+	// UpstreamErrorTemplatePath is the path to a template used to render
+	// errors when the upstream server cannot be reached or times out.
+	// If not set, the error page template will be used.
+	UpstreamErrorTemplatePath string
+
 	// ProxyPrefix is the prefix under which OAuth2 Proxy pages are served.
 	ProxyPrefix string
 
@@ -65,17 +70,23 @@ func NewWriter(opts Opts) (Writer, error) {
 		return nil, fmt.Errorf("error loading templates: %v", err)
 	}
 
+	upstreamErrorTemplate, err := loadUpstreamErrorTemplate(opts.UpstreamErrorTemplatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading upstream error template: %v", err)
+	}
+
 	logoData, err := loadCustomLogo(opts.CustomLogo)
 	if err != nil {
 		return nil, fmt.Errorf("error loading logo: %v", err)
 	}
 
 	errorPage := &errorPageWriter{
-		template:    templates.Lookup("error.html"),
-		proxyPrefix: opts.ProxyPrefix,
-		footer:      opts.Footer,
-		version:     opts.Version,
-		debug:       opts.Debug,
+		template:         templates.Lookup("error.html"),
+		upstreamTemplate: upstreamErrorTemplate,
+		proxyPrefix:      opts.ProxyPrefix,
+		footer:           opts.Footer,
+		version:          opts.Version,
+		debug:            opts.Debug,
 	}
 
 	signInPage := &signInPageWriter{
@@ -28,10 +28,7 @@ var defaultSignInTemplate string
 // directory, or uses the defaults if they do not exist or the custom directory
 // is not provided.
 func loadTemplates(customDir string) (*template.Template, error) {
-	t := template.New("").Funcs(template.FuncMap{
-		"ToUpper": strings.ToUpper,
-		"ToLower": strings.ToLower,
-	})
+	t := template.New("").Funcs(templateFuncs())
 	var err error
 	t, err = addTemplate(t, customDir, signInTemplateName, defaultSignInTemplate)
 	if err != nil {
@@ -45,6 +42,30 @@ func loadTemplates(customDir string) (*template.Template, error) {
 	return t, nil
 }
 
+// loadUpstreamErrorTemplate loads the template used to render upstream errors
+// from the given path.
+// If no path is provided, no template is returned and the error page template
+// should be used instead.
+func loadUpstreamErrorTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	t, err := template.New(filepath.Base(path)).Funcs(templateFuncs()).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// templateFuncs are the functions made available to all templates.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"ToUpper": strings.ToUpper,
+		"ToLower": strings.ToLower,
+	}
+}
+
 // addTemplate will add the template from the custom directory if provided,
 // else it will add the default template.
 func addTemplate(t *template.Template, customDir, fileName, defaultTemplate string) (*template.Template, error) {
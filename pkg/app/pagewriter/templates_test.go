@@ -187,6 +187,32 @@ var _ = Describe("Templates", func() {
 		})
 	})
 
+	Context("loadUpstreamErrorTemplate", func() {
+		It("With no path, returns no template", func() {
+			t, err := loadUpstreamErrorTemplate("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t).To(BeNil())
+		})
+
+		It("With a valid template, loads the template", func() {
+			t, err := loadUpstreamErrorTemplate(filepath.Join(customDir, errorTemplateName))
+			Expect(err).ToNot(HaveOccurred())
+
+			buf := bytes.NewBuffer([]byte{})
+			Expect(t.Execute(buf, struct{ TestString string }{TestString: "Testing"})).To(Succeed())
+			Expect(buf.String()).To(Equal("Testing testing TESTING"))
+		})
+
+		It("With an invalid template, returns an error", func() {
+			upstreamFile := filepath.Join(customDir, "upstream_error.html")
+			Expect(ioutil.WriteFile(upstreamFile, []byte("{{"), 0600)).To(Succeed())
+
+			t, err := loadUpstreamErrorTemplate(upstreamFile)
+			Expect(err).To(MatchError(HavePrefix("failed to parse template")))
+			Expect(t).To(BeNil())
+		})
+	})
+
 	Context("isFile", func() {
 		It("with a valid file", func() {
 			Expect(isFile(filepath.Join(customDir, signInTemplateName))).To(BeTrue())
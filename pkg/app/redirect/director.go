@@ -12,12 +12,21 @@ import (
 // a users request to after the user has authenticated with the identity provider.
 type AppDirector interface {
 	GetRedirect(req *http.Request) (string, error)
+
+	// GetRequestedRedirect determines the redirect requested by the client,
+	// without falling back to the default redirect. An empty string is
+	// returned when the request doesn't specify a redirect.
+	GetRequestedRedirect(req *http.Request) (string, error)
 }
 
 // AppDirectorOpts are the requirements for constructing a new AppDirector.
 type AppDirectorOpts struct {
 	ProxyPrefix string
 	Validator   Validator
+
+	// DefaultRedirect is used when no redirect can be determined from the
+	// request. If empty or invalid, clients are redirected to `/`.
+	DefaultRedirect string
 }
 
 // NewAppDirector constructs a new AppDirector for getting the application
@@ -29,15 +38,17 @@ func NewAppDirector(opts AppDirectorOpts) AppDirector {
 	}
 
 	return &appDirector{
-		proxyPrefix: prefix,
-		validator:   opts.Validator,
+		proxyPrefix:     prefix,
+		validator:       opts.Validator,
+		defaultRedirect: opts.DefaultRedirect,
 	}
 }
 
 // appDirector implements the AppDirector interface.
 type appDirector struct {
-	proxyPrefix string
-	validator   Validator
+	proxyPrefix     string
+	validator       Validator
+	defaultRedirect string
 }
 
 // GetRedirect determines the full URL or URI path to redirect clients to once
@@ -49,8 +60,45 @@ type appDirector struct {
 // - `X-Forwarded-(Proto|Host)` if `Uri` has the ProxyPath (i.e. /oauth2/*)
 // - `X-Forwarded-Uri` direct URI path (when ReverseProxy mode is enabled)
 // - `req.URL.RequestURI` if not under the ProxyPath (i.e. /oauth2/*)
+// - The configured default redirect
 // - `/`
+// Redirects are validated with the Validator for the request, when the
+// Validator is a RequestValidator.
 func (a *appDirector) GetRedirect(req *http.Request) (string, error) {
+	if rv, ok := a.validator.(RequestValidator); ok {
+		scoped := *a
+		scoped.validator = rv.ForRequest(req)
+		return scoped.getRedirect(req)
+	}
+	return a.getRedirect(req)
+}
+
+// GetRequestedRedirect determines the full URL or URI path requested by the
+// client to redirect to once authenticated with the OAuthProxy. This follows
+// the strategies of GetRedirect, but returns an empty string instead of the
+// configured default redirect or `/`.
+func (a *appDirector) GetRequestedRedirect(req *http.Request) (string, error) {
+	if rv, ok := a.validator.(RequestValidator); ok {
+		scoped := *a
+		scoped.validator = rv.ForRequest(req)
+		return scoped.getRequestedRedirect(req)
+	}
+	return a.getRequestedRedirect(req)
+}
+
+func (a *appDirector) getRedirect(req *http.Request) (string, error) {
+	redirect, err := a.getRequestedRedirect(req)
+	if err != nil || redirect != "" {
+		return redirect, err
+	}
+
+	if a.defaultRedirect != "" && a.validator.IsValidRedirect(a.defaultRedirect) {
+		return a.defaultRedirect, nil
+	}
+	return "/", nil
+}
+
+func (a *appDirector) getRequestedRedirect(req *http.Request) (string, error) {
 	err := req.ParseForm()
 	if err != nil {
 		return "", err
@@ -70,8 +118,7 @@ func (a *appDirector) GetRedirect(req *http.Request) (string, error) {
 			return redirect, nil
 		}
 	}
-
-	return "/", nil
+	return "", nil
 }
 
 // validateRedirect checks that the redirect is valid.
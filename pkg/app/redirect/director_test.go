@@ -17,14 +17,16 @@ var _ = Describe("Director Suite", func() {
 		headers          map[string]string
 		reverseProxy     bool
 		validator        Validator
+		defaultRedirect  string
 		expectedRedirect string
 	}
 
 	DescribeTable("GetRedirect",
 		func(in getRedirectTableInput) {
 			appDirector := NewAppDirector(AppDirectorOpts{
-				ProxyPrefix: testProxyPrefix,
-				Validator:   in.validator,
+				ProxyPrefix:     testProxyPrefix,
+				Validator:       in.validator,
+				DefaultRedirect: in.defaultRedirect,
 			})
 
 			req, _ := http.NewRequest("GET", in.requestURL, nil)
@@ -173,5 +175,110 @@ var _ = Describe("Director Suite", func() {
 			validator:        testValidator(false, "https://a-service.example.com/foo/bar"),
 			expectedRedirect: "https://a-service.example.com/foo/bar",
 		}),
+		Entry("Request under the proxy prefix with a default redirect, redirects to the default redirect", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/sign_out",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(true),
+			defaultRedirect:  "https://www.example.com/",
+			expectedRedirect: "https://www.example.com/",
+		}),
+		Entry("Request with RD parameter and a default redirect, redirects to the RD parameter", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/sign_out?rd=%2Ffoo%2Fbar",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(true),
+			defaultRedirect:  "https://www.example.com/",
+			expectedRedirect: "/foo/bar",
+		}),
+		Entry("Request with RD parameter with a query and fragment, preserves the query and fragment", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start?rd=%2Fsearch%3Fq%3Da%2F%2Fb%26next%3D%252Fdashboard%23results",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        NewValidator(nil),
+			expectedRedirect: "/search?q=a//b&next=%2Fdashboard#results",
+		}),
+		Entry("Request with RD parameter with a redirect to another host in the query, redirects to root", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start?rd=%2Flogin%3Fnext%3D%252F%252Fevil.example.com",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        NewValidator(nil),
+			expectedRedirect: "/",
+		}),
+		Entry("Request with invalid RD parameter and a default redirect, redirects to the default redirect", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/sign_out?rd=https%3A%2F%2Fevil.example.com%2F",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(false, "https://www.example.com/"),
+			defaultRedirect:  "https://www.example.com/",
+			expectedRedirect: "https://www.example.com/",
+		}),
+		Entry("Request with an invalid default redirect, redirects to root", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/sign_out",
+			headers:          nil,
+			reverseProxy:     false,
+			validator:        testValidator(false),
+			defaultRedirect:  "https://evil.example.com/",
+			expectedRedirect: "/",
+		}),
+	)
+
+	DescribeTable("GetRequestedRedirect",
+		func(in getRedirectTableInput) {
+			appDirector := NewAppDirector(AppDirectorOpts{
+				ProxyPrefix:     testProxyPrefix,
+				Validator:       in.validator,
+				DefaultRedirect: in.defaultRedirect,
+			})
+
+			req, _ := http.NewRequest("GET", in.requestURL, nil)
+			for header, value := range in.headers {
+				if value != "" {
+					req.Header.Add(header, value)
+				}
+			}
+			req = middleware.AddRequestScope(req, &middleware.RequestScope{
+				ReverseProxy: in.reverseProxy,
+			})
+
+			redirect, err := appDirector.GetRequestedRedirect(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(redirect).To(Equal(in.expectedRedirect))
+		},
+		Entry("Request outside of the proxy prefix, redirects to original request", getRedirectTableInput{
+			requestURL:       "/foo/bar",
+			validator:        testValidator(true),
+			expectedRedirect: "/foo/bar",
+		}),
+		Entry("Request with RD parameter, redirects to the RD parameter", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start?rd=%2Ffoo%2Fbar",
+			validator:        testValidator(true),
+			defaultRedirect:  "https://www.example.com/",
+			expectedRedirect: "/foo/bar",
+		}),
+		Entry("Request with X-Auth-Request-Redirect, redirects to the header", getRedirectTableInput{
+			requestURL: testProxyPrefix + "/start",
+			headers: map[string]string{
+				"X-Auth-Request-Redirect": "https://a-service.example.com/foo/bar",
+			},
+			validator:        testValidator(true),
+			expectedRedirect: "https://a-service.example.com/foo/bar",
+		}),
+		Entry("Request under the proxy prefix, has no redirect", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start",
+			validator:        testValidator(true),
+			expectedRedirect: "",
+		}),
+		Entry("Request under the proxy prefix with a default redirect, has no redirect", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start",
+			validator:        testValidator(true),
+			defaultRedirect:  "https://www.example.com/",
+			expectedRedirect: "",
+		}),
+		Entry("Request with invalid RD parameter, has no redirect", getRedirectTableInput{
+			requestURL:       testProxyPrefix + "/start?rd=https%3A%2F%2Fevil.example.com%2F",
+			validator:        testValidator(false),
+			expectedRedirect: "",
+		}),
 	)
 })
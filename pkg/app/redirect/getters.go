@@ -56,7 +56,8 @@ func (a *appDirector) getXForwardedHeadersRedirect(req *http.Request) string {
 // getURIRedirect handles these getAppRedirect strategies:
 // - `X-Forwarded-Uri` direct URI path (when ReverseProxy mode is enabled)
 // - `req.URL.RequestURI` if not under the ProxyPath (i.e. /oauth2/*)
-// - `/`
+// Requests under the ProxyPath return no redirect so that the default
+// redirect is used.
 func (a *appDirector) getURIRedirect(req *http.Request) string {
 	redirect := a.validateRedirect(
 		requestutil.GetRequestURI(req),
@@ -67,7 +68,7 @@ func (a *appDirector) getURIRedirect(req *http.Request) string {
 	}
 
 	if a.hasProxyPrefix(redirect) {
-		return "/"
+		return ""
 	}
 	return redirect
 }
@@ -0,0 +1,87 @@
+package redirect
+
+import (
+	"net/http"
+)
+
+// RequestValidator is a Validator whose allowed domains depend on the request
+// the redirect was given in.
+// The AppDirector validates redirects with the Validator for each request.
+type RequestValidator interface {
+	Validator
+	ForRequest(req *http.Request) Validator
+}
+
+// TenantValidatorOpts are the requirements for constructing a new
+// TenantValidator.
+type TenantValidatorOpts struct {
+	// AllowedDomains are allowed for every tenant
+	AllowedDomains func() []string
+
+	// TenantDomains maps each tenant to the domains that are allowed only for
+	// that tenant
+	TenantDomains func() map[string][]string
+
+	// TenantFromRequest determines the tenant of a request, eg. from its host.
+	// If nil, the tenant can't be determined from the request and must be
+	// given with ForTenant.
+	TenantFromRequest func(req *http.Request) string
+}
+
+// TenantValidator validates redirects against the domains allowed for the
+// tenant the redirect was given by, so that a redirect to the domains of one
+// tenant can't be given by another.
+type TenantValidator struct {
+	allowedDomains    func() []string
+	tenantDomains     func() map[string][]string
+	tenantFromRequest func(req *http.Request) string
+}
+
+// NewTenantValidator constructs a new TenantValidator.
+func NewTenantValidator(opts TenantValidatorOpts) *TenantValidator {
+	return &TenantValidator{
+		allowedDomains:    opts.AllowedDomains,
+		tenantDomains:     opts.TenantDomains,
+		tenantFromRequest: opts.TenantFromRequest,
+	}
+}
+
+// IsValidRedirect checks the redirect against the domains of every tenant.
+// This is used where the tenant is not yet known, eg. when tenants are
+// identified by a claim before the user has logged in. The redirect must be
+// validated again with ForTenant once the tenant is known.
+func (t *TenantValidator) IsValidRedirect(redirect string) bool {
+	v := &validator{
+		allowedDomains: func() []string {
+			domains := append([]string{}, t.allowedDomains()...)
+			for _, tenantDomains := range t.tenantDomains() {
+				domains = append(domains, tenantDomains...)
+			}
+			return domains
+		},
+	}
+	return v.IsValidRedirect(redirect)
+}
+
+// ForRequest returns the Validator for the tenant of the request.
+// If the tenant can't be determined from the request, the TenantValidator
+// itself is returned.
+func (t *TenantValidator) ForRequest(req *http.Request) Validator {
+	if t.tenantFromRequest == nil {
+		return t
+	}
+	return t.ForTenant(t.tenantFromRequest(req))
+}
+
+// ForTenant returns the Validator for the tenant, which allows the domains
+// of the tenant along with the domains allowed for every tenant.
+// Rejected redirects are logged with the tenant.
+func (t *TenantValidator) ForTenant(tenant string) Validator {
+	return &validator{
+		allowedDomains: func() []string {
+			domains := append([]string{}, t.allowedDomains()...)
+			return append(domains, t.tenantDomains()[tenant]...)
+		},
+		tenant: tenant,
+	}
+}
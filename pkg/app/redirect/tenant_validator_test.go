@@ -0,0 +1,94 @@
+package redirect
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tenant Validator Suite", func() {
+	var tenantDomains map[string][]string
+	var validator *TenantValidator
+
+	BeforeEach(func() {
+		tenantDomains = map[string][]string{
+			"a.example.com": {".tenant-a.com"},
+			"b.example.com": {".tenant-b.com", "b.example.com:8443"},
+		}
+		validator = NewTenantValidator(TenantValidatorOpts{
+			AllowedDomains: func() []string { return []string{"shared.example.com"} },
+			TenantDomains:  func() map[string][]string { return tenantDomains },
+			TenantFromRequest: func(req *http.Request) string {
+				return req.Host
+			},
+		})
+	})
+
+	It("allows the domains of any tenant when the tenant is not known", func() {
+		Expect(validator.IsValidRedirect("/foo")).To(BeTrue())
+		Expect(validator.IsValidRedirect("https://shared.example.com/")).To(BeTrue())
+		Expect(validator.IsValidRedirect("https://app.tenant-a.com/")).To(BeTrue())
+		Expect(validator.IsValidRedirect("https://b.example.com:8443/")).To(BeTrue())
+		Expect(validator.IsValidRedirect("https://evil.example.com/")).To(BeFalse())
+	})
+
+	It("allows only the domains of the tenant", func() {
+		tenant := validator.ForTenant("a.example.com")
+		Expect(tenant.IsValidRedirect("/foo")).To(BeTrue())
+		Expect(tenant.IsValidRedirect("https://shared.example.com/")).To(BeTrue())
+		Expect(tenant.IsValidRedirect("https://app.tenant-a.com/")).To(BeTrue())
+		Expect(tenant.IsValidRedirect("https://app.tenant-b.com/")).To(BeFalse())
+		Expect(tenant.IsValidRedirect("https://b.example.com:8443/")).To(BeFalse())
+	})
+
+	It("allows only the shared domains for unknown tenants", func() {
+		tenant := validator.ForTenant("c.example.com")
+		Expect(tenant.IsValidRedirect("https://shared.example.com/")).To(BeTrue())
+		Expect(tenant.IsValidRedirect("https://app.tenant-a.com/")).To(BeFalse())
+	})
+
+	It("validates against the current domains of the tenant", func() {
+		tenant := validator.ForTenant("a.example.com")
+		Expect(tenant.IsValidRedirect("https://app.tenant-c.com/")).To(BeFalse())
+
+		tenantDomains = map[string][]string{"a.example.com": {".tenant-c.com"}}
+		Expect(tenant.IsValidRedirect("https://app.tenant-c.com/")).To(BeTrue())
+		Expect(tenant.IsValidRedirect("https://app.tenant-a.com/")).To(BeFalse())
+	})
+
+	Context("ForRequest", func() {
+		It("returns the validator of the tenant of the request", func() {
+			req, _ := http.NewRequest("GET", "https://b.example.com/", nil)
+			tenant := validator.ForRequest(req)
+			Expect(tenant.IsValidRedirect("https://app.tenant-b.com/")).To(BeTrue())
+			Expect(tenant.IsValidRedirect("https://app.tenant-a.com/")).To(BeFalse())
+		})
+
+		It("returns the validator itself when the tenant can't be determined from the request", func() {
+			validator = NewTenantValidator(TenantValidatorOpts{
+				AllowedDomains: func() []string { return []string{} },
+				TenantDomains:  func() map[string][]string { return tenantDomains },
+			})
+			req, _ := http.NewRequest("GET", "https://b.example.com/", nil)
+			Expect(validator.ForRequest(req)).To(Equal(validator))
+		})
+
+		It("is used by the AppDirector", func() {
+			appDirector := NewAppDirector(AppDirectorOpts{
+				ProxyPrefix: testProxyPrefix,
+				Validator:   validator,
+			})
+
+			req, _ := http.NewRequest("GET", "https://a.example.com/oauth2/start?rd=https://app.tenant-b.com/", nil)
+			redirect, err := appDirector.GetRedirect(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(redirect).To(Equal("/"))
+
+			req, _ = http.NewRequest("GET", "https://b.example.com/oauth2/start?rd=https://app.tenant-b.com/", nil)
+			redirect, err = appDirector.GetRedirect(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(redirect).To(Equal("https://app.tenant-b.com/"))
+		})
+	})
+})
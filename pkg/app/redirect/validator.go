@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
@@ -12,8 +13,19 @@ var (
 	// Used to check final redirects are not susceptible to open redirects.
 	// Matches //, /\ and both of these with whitespace in between (eg / / or / \).
 	invalidRedirectRegex = regexp.MustCompile(`[/\\](?:[\s\v]*|\.{1,2})[/\\]`)
+
+	// Matches values embedded in the query or fragment of a redirect that
+	// browsers may follow to another host: URLs of the http(s) schemes, with
+	// or without slashes, and of other schemes that are followed by slashes
+	// or could run scripts.
+	embeddedSchemeRegex = regexp.MustCompile(`(?i)^(?:https?:|[a-z][a-z0-9+.-]*:/|(?:javascript|data|vbscript):)`)
 )
 
+// maxRedirectUnescapes is the number of times the parts of a relative
+// redirect are unescaped to check them. Redirects that are escaped more times
+// than this are rejected.
+const maxRedirectUnescapes = 3
+
 // Validator is an interface to allow validation of application
 // redirect URLs.
 // As these values are determined from the request, they must go
@@ -24,6 +36,13 @@ type Validator interface {
 
 // NewValidator constructs a new redirect validator.
 func NewValidator(allowedDomains []string) Validator {
+	return NewDynamicValidator(func() []string { return allowedDomains })
+}
+
+// NewDynamicValidator constructs a new redirect validator whose allowed
+// domains may change while it is in use, eg. when loaded from a file.
+// The allowedDomains func is called each time a redirect is validated.
+func NewDynamicValidator(allowedDomains func() []string) Validator {
 	return &validator{
 		allowedDomains: allowedDomains,
 	}
@@ -32,54 +51,195 @@ func NewValidator(allowedDomains []string) Validator {
 // validator implements the Validator interface to allow validation
 // of redirect URLs.
 type validator struct {
-	allowedDomains []string
+	allowedDomains func() []string
+
+	// tenant is included in the logs of rejected redirects, when the allowed
+	// domains are those of a tenant
+	tenant string
 }
 
 // IsValidRedirect checks whether the redirect URL is safe and allowed.
 func (v *validator) IsValidRedirect(redirect string) bool {
-	switch {
-	case redirect == "":
+	if redirect == "" {
 		// The user didn't specify a redirect.
 		// In this case, we expect the proxt to fallback to `/`
 		return false
-	case strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//") && !invalidRedirectRegex.MatchString(redirect):
-		return true
+	}
+	if reason := v.invalidRedirect(redirect); reason != "" {
+		v.reject(redirect, reason)
+		return false
+	}
+	return true
+}
+
+// invalidRedirect returns why the redirect is invalid, or an empty string
+// when it is valid
+func (v *validator) invalidRedirect(redirect string) string {
+	switch {
+	case strings.HasPrefix(redirect, "/"):
+		return v.invalidRelativeRedirect(redirect)
 	case strings.HasPrefix(redirect, "http://") || strings.HasPrefix(redirect, "https://"):
-		redirectURL, err := url.Parse(redirect)
-		if err != nil {
-			logger.Printf("Rejecting invalid redirect %q: scheme unsupported or missing", redirect)
-			return false
+		return v.invalidAbsoluteRedirect(redirect)
+	default:
+		return "not an absolute or relative URL"
+	}
+}
+
+// invalidAbsoluteRedirect checks that the domain and port of the redirect are
+// allowed
+func (v *validator) invalidAbsoluteRedirect(redirect string) string {
+	redirectURL, err := url.Parse(redirect)
+	if err != nil {
+		return "scheme unsupported or missing"
+	}
+	redirectHostname := redirectURL.Hostname()
+
+	for _, allowedDomain := range v.allowedDomains() {
+		allowedHost, allowedPort := splitHostPort(allowedDomain)
+		if allowedHost == "" {
+			continue
 		}
-		redirectHostname := redirectURL.Hostname()
 
-		for _, allowedDomain := range v.allowedDomains {
-			allowedHost, allowedPort := splitHostPort(allowedDomain)
-			if allowedHost == "" {
-				continue
+		if redirectHostname == strings.TrimPrefix(allowedHost, ".") ||
+			(strings.HasPrefix(allowedHost, ".") &&
+				strings.HasSuffix(redirectHostname, allowedHost)) {
+			// the domain names match, now validate the ports
+			// if the whitelisted domain's port is '*', allow all ports
+			// if the whitelisted domain contains a specific port, only allow that port
+			// if the whitelisted domain doesn't contain a port at all, only allow empty redirect ports ie http and https
+			redirectPort := redirectURL.Port()
+			if allowedPort == "*" ||
+				allowedPort == redirectPort ||
+				(allowedPort == "" && redirectPort == "") {
+				return ""
 			}
+		}
+	}
+	return "domain / port not in whitelist"
+}
 
-			if redirectHostname == strings.TrimPrefix(allowedHost, ".") ||
-				(strings.HasPrefix(allowedHost, ".") &&
-					strings.HasSuffix(redirectHostname, allowedHost)) {
-				// the domain names match, now validate the ports
-				// if the whitelisted domain's port is '*', allow all ports
-				// if the whitelisted domain contains a specific port, only allow that port
-				// if the whitelisted domain doesn't contain a port at all, only allow empty redirect ports ie http and https
-				redirectPort := redirectURL.Port()
-				if allowedPort == "*" ||
-					allowedPort == redirectPort ||
-					(allowedPort == "" && redirectPort == "") {
-					return true
-				}
-			}
+// invalidRelativeRedirect checks the path, query and fragment of a relative
+// redirect separately, so that the query and fragment of deep links are kept.
+// The path must stay on this host, and the query and fragment must not carry
+// a redirect to another host, eg. for an application that redirects to its
+// `next` parameter.
+func (v *validator) invalidRelativeRedirect(redirect string) string {
+	path, rest := redirect, ""
+	if i := strings.IndexAny(redirect, "?#"); i >= 0 {
+		path, rest = redirect[:i], redirect[i:]
+	}
+	query, fragment := rest, ""
+	if i := strings.Index(rest, "#"); i >= 0 {
+		query, fragment = rest[:i], rest[i+1:]
+	}
+
+	if reason := checkUnescaped(path, unescapePath, checkRedirectPath); reason != "" {
+		return "path " + reason
+	}
+	for _, value := range strings.FieldsFunc(strings.TrimPrefix(query, "?"), isQuerySeparator) {
+		if reason := checkUnescaped(value, url.QueryUnescape, v.checkEmbeddedRedirect); reason != "" {
+			return "query " + reason
 		}
+	}
+	if reason := checkUnescaped(fragment, url.PathUnescape, v.checkEmbeddedRedirect); reason != "" {
+		return "fragment " + reason
+	}
+	return ""
+}
 
-		logger.Printf("Rejecting invalid redirect %q: domain / port not in whitelist", redirect)
-		return false
-	default:
-		logger.Printf("Rejecting invalid redirect %q: not an absolute or relative URL", redirect)
-		return false
+// checkUnescaped checks the value, and the value each time it is unescaped,
+// so that values that are escaped more than once, eg. `%252F` for `/`, are
+// checked as they would be once fully decoded.
+func checkUnescaped(value string, unescape func(string) (string, error), check func(string) string) string {
+	for i := 0; ; i++ {
+		if reason := check(value); reason != "" {
+			return reason
+		}
+		unescaped, err := unescape(value)
+		if err != nil {
+			return "has invalid escaping"
+		}
+		if unescaped == value {
+			return ""
+		}
+		if i == maxRedirectUnescapes {
+			return "is escaped too many times"
+		}
+		value = unescaped
+	}
+}
+
+// unescapePath unescapes the path of a relative redirect. A `%` that does not
+// start an escape is kept as a literal `%`, eg. in `/reports/100%`, rather
+// than being invalid escaping, while the escapes around it are still
+// unescaped so that they are checked.
+func unescapePath(path string) (string, error) {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '%' && (i+2 >= len(path) || !isHex(path[i+1]) || !isHex(path[i+2])) {
+			escaped.WriteString("%25")
+			continue
+		}
+		escaped.WriteByte(path[i])
+	}
+	return url.PathUnescape(escaped.String())
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// checkRedirectPath checks that the path of a relative redirect can't be
+// interpreted as a redirect to another host
+func checkRedirectPath(path string) string {
+	if strings.HasPrefix(path, "//") || invalidRedirectRegex.MatchString(path) {
+		return "redirects to another host"
+	}
+	if strings.IndexFunc(path, unicode.IsControl) >= 0 {
+		return "has control characters"
+	}
+	return ""
+}
+
+// checkEmbeddedRedirect checks that a value in the query or fragment of a
+// relative redirect is not itself a redirect to another host
+func (v *validator) checkEmbeddedRedirect(value string) string {
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return "has control characters"
+	}
+
+	// Browsers ignore leading spaces, and treat `\` as `/`
+	value = strings.TrimLeft(value, " ")
+	normalized := strings.ReplaceAll(value, "\\", "/")
+	switch {
+	case strings.HasPrefix(normalized, "/"):
+		if strings.HasPrefix(normalized, "//") || invalidRedirectRegex.MatchString(value) {
+			return "redirects to another host"
+		}
+	case embeddedSchemeRegex.MatchString(normalized):
+		scheme := strings.ToLower(normalized[:strings.Index(normalized, ":")])
+		if scheme != "http" && scheme != "https" {
+			return "has an unsupported scheme"
+		}
+		if v.invalidAbsoluteRedirect(value) != "" || !strings.HasPrefix(value, scheme+"://") {
+			return "redirects to a domain / port not in whitelist"
+		}
+	}
+	return ""
+}
+
+// isQuerySeparator splits a query into its keys and values
+func isQuerySeparator(r rune) bool {
+	return r == '&' || r == ';' || r == '='
+}
+
+// reject logs the reason a redirect was rejected
+func (v *validator) reject(redirect string, reason string) {
+	if v.tenant != "" {
+		logger.Printf("Rejecting invalid redirect %q for tenant %q: %s", redirect, v.tenant, reason)
+		return
 	}
+	logger.Printf("Rejecting invalid redirect %q: %s", redirect, reason)
 }
 
 // splitHostPort separates host and port. If the port is not valid, it returns
@@ -97,6 +97,37 @@ var _ = Describe("Validator suite", func() {
 			Entry("Relative Path", "/./\\evil.com", false),
 			Entry("Relative Subpath", "/./../../\\evil.com", false),
 			Entry("Partial Subdomain", "evilbar.foo", false),
+			Entry("Query and Fragment", "/search?q=oauth2&page=2#results", true),
+			Entry("Query with Double Slash", "/search?q=a//b", true),
+			Entry("Query with Colon", "/search?filter=status:open", true),
+			Entry("Query with Relative Redirect", "/login?next=/dashboard", true),
+			Entry("Query with Allowed Redirect", "/login?next=https://foo.bar/redirect", true),
+			Entry("Query with Escaped Allowed Redirect", "/login?next=https%3A%2F%2Ffoo.bar%2Fredirect%3Fa%3Db", true),
+			Entry("Fragment Route", "/app#/settings/profile", true),
+			Entry("Escaped Path", "/path%20with%20spaces", true),
+			Entry("Query with Protocol Relative Redirect", "/login?next=//evil.com", false),
+			Entry("Query with Escaped Protocol Relative Redirect", "/login?next=%2F%2Fevil.com", false),
+			Entry("Query with Double Escaped Protocol Relative Redirect", "/login?next=%252F%252Fevil.com", false),
+			Entry("Query with Backslash Redirect", "/login?next=/\\evil.com", false),
+			Entry("Query with Disallowed Redirect", "/login?next=https://evil.com", false),
+			Entry("Query with Disallowed Redirect without Slashes", "/login?next=https:evil.com", false),
+			Entry("Query with Uppercase Scheme", "/login?next=HTTPS://evil.com", false),
+			Entry("Query with Double Escaped Scheme", "/login?next=https%253A%252F%252Fevil.com", false),
+			Entry("Query with Javascript", "/login?next=javascript:alert(1)", false),
+			Entry("Query with Escaped Tab", "/login?next=%09//evil.com", false),
+			Entry("Query Key Redirect", "/login?//evil.com", false),
+			Entry("Query with Invalid Escaping", "/search?q=100%", false),
+			Entry("Path with a Literal Percent", "/reports/100%", true),
+			Entry("Path with a Literal Percent before a Slash", "/reports/100%/summary", true),
+			Entry("Path with a Literal Percent and Escaping", "/reports/100%25%20done%", true),
+			Entry("Escaped Double Slash Path with a Literal Percent", "/%2F%2Fevil.com/100%", false),
+			Entry("Double Escaped Double Slash Path with a Literal Percent", "/%252F%252Fevil.com/%zz", false),
+			Entry("Escaped Double Slash Path", "/%2F%2Fevil.com", false),
+			Entry("Double Escaped Double Slash Path", "/%252F%252Fevil.com", false),
+			Entry("Escaped Backslash Path", "/%5Cevil.com", false),
+			Entry("Escaped Carriage Return Path", "/redirect%0d%0aSet-Cookie:a=b", false),
+			Entry("Path Escaped Too Many Times", "/%2525252541", false),
+			Entry("Fragment with Protocol Relative Redirect", "/app#//evil.com", false),
 		)
 	})
 
@@ -140,4 +171,18 @@ var _ = Describe("Validator suite", func() {
 			}),
 		)
 	})
+
+	Context("with dynamic allowed domains", func() {
+		It("validates against the current allowed domains", func() {
+			allowedDomains := []string{"foo.bar"}
+			validator := NewDynamicValidator(func() []string { return allowedDomains })
+
+			Expect(validator.IsValidRedirect("https://foo.bar/")).To(BeTrue())
+			Expect(validator.IsValidRedirect("https://baz.bar/")).To(BeFalse())
+
+			allowedDomains = []string{"baz.bar"}
+			Expect(validator.IsValidRedirect("https://foo.bar/")).To(BeFalse())
+			Expect(validator.IsValidRedirect("https://baz.bar/")).To(BeTrue())
+		})
+	})
 })
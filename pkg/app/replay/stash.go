@@ -0,0 +1,221 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+)
+
+// ErrBodyTooLarge is returned when a request can't be stashed because its
+// body is larger than the maximum body size
+var ErrBodyTooLarge = errors.New("request body is too large to be replayed")
+
+// ErrCrossOrigin is returned when a request can't be stashed because it was
+// not made by a page of the same origin, eg. a form on another site that
+// submits itself automatically, which must not be replayed with the session
+// of the user once they log in
+var ErrCrossOrigin = errors.New("request is not from the same origin")
+
+// Request is a request stashed to be replayed after the login
+type Request struct {
+	Method      string `json:"m"`
+	URL         string `json:"u"`
+	ContentType string `json:"ct,omitempty"`
+	Body        []byte `json:"b,omitempty"`
+}
+
+// Replay turns the request into the stashed request.
+// Requests are only replayed in place of a GET to the same URL, which is
+// the request made by the redirect at the end of the login.
+func (r *Request) Replay(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.URL.RequestURI() != r.URL {
+		return false
+	}
+
+	req.Method = r.Method
+	req.Body = ioutil.NopCloser(bytes.NewReader(r.Body))
+	req.ContentLength = int64(len(r.Body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	} else {
+		req.Header.Del("Content-Type")
+	}
+	return true
+}
+
+// Stash stores requests that start the login flow in a persistent Store,
+// keyed by the login state, so that they can be replayed once the login
+// completes. Stashed requests are encrypted with the cookie secret, expire
+// and can only be taken from the Stash once.
+type Stash struct {
+	store       persistence.Store
+	cookieOpts  *options.Cookie
+	maxBodySize int64
+	expire      time.Duration
+}
+
+// NewStash creates a Stash that stores requests in the Store
+func NewStash(store persistence.Store, opts options.RequestReplay, cookieOpts *options.Cookie) *Stash {
+	return &Stash{
+		store:       store,
+		cookieOpts:  cookieOpts,
+		maxBodySize: opts.MaxBodySize,
+		expire:      opts.Expire,
+	}
+}
+
+// Save stashes the request under the login state.
+// Only requests from the same origin are stashed.
+// The body is read from the request and replaced, so that it can still be
+// read by later handlers.
+func (s *Stash) Save(req *http.Request, state string) error {
+	if !isSameOrigin(req) {
+		return ErrCrossOrigin
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, s.maxBodySize+1))
+	req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+	if err != nil {
+		return fmt.Errorf("error reading request body: %v", err)
+	}
+	if int64(len(body)) > s.maxBodySize {
+		return ErrBodyTooLarge
+	}
+
+	packed, err := json.Marshal(&Request{
+		Method:      req.Method,
+		URL:         req.URL.RequestURI(),
+		ContentType: req.Header.Get("Content-Type"),
+		Body:        body,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling request: %v", err)
+	}
+
+	cipher, err := s.cipher()
+	if err != nil {
+		return err
+	}
+	encrypted, err := cipher.Encrypt(packed)
+	if err != nil {
+		return fmt.Errorf("error encrypting request: %v", err)
+	}
+
+	return s.store.Save(req.Context(), s.key(state), encrypted, s.expire)
+}
+
+// Has reports whether a request is stashed under the login state
+func (s *Stash) Has(ctx context.Context, state string) bool {
+	_, err := s.store.Load(ctx, s.key(state))
+	return err == nil
+}
+
+// Take loads the request stashed under the login state and removes it from
+// the Stash in a single operation, so that it is only replayed once even by
+// concurrent requests
+func (s *Stash) Take(ctx context.Context, state string) (*Request, error) {
+	encrypted, err := s.store.LoadAndClear(ctx, s.key(state))
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	packed, err := cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting stashed request: %v", err)
+	}
+
+	r := &Request{}
+	if err := json.Unmarshal(packed, r); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stashed request: %v", err)
+	}
+	return r, nil
+}
+
+// SetCookie sets a cookie marking that the first request after the login
+// should replay the request stashed under the login state
+func (s *Stash) SetCookie(rw http.ResponseWriter, req *http.Request, state string) error {
+	now := time.Now()
+	value, err := encryption.SignedValue(s.cookieOpts.Secret, s.cookieName(), []byte(state), now, cookies.ValueEncoding(s.cookieOpts))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(rw, cookies.MakeCookieFromOptions(req, s.cookieName(), value, s.cookieOpts, s.expire, now))
+	return nil
+}
+
+// LoadCookie returns the login state from the replay cookie, if the request
+// has a valid replay cookie
+func (s *Stash) LoadCookie(req *http.Request) (string, bool) {
+	cookie, err := req.Cookie(s.cookieName())
+	if err != nil {
+		return "", false
+	}
+	state, _, ok := encryption.Validate(cookie, s.cookieOpts.Secret, s.expire)
+	if !ok {
+		return "", false
+	}
+	return string(state), true
+}
+
+// ClearCookie removes the replay cookie
+func (s *Stash) ClearCookie(rw http.ResponseWriter, req *http.Request) {
+	http.SetCookie(rw, cookies.MakeCookieFromOptions(req, s.cookieName(), "", s.cookieOpts, time.Hour*-1, time.Now()))
+}
+
+// isSameOrigin reports whether the request was made by a page of the same
+// origin as the request. Current browsers send Sec-Fetch-Site with every
+// request, older browsers send at least one of Origin or Referer with POST
+// requests. Requests without any of them are rejected.
+func isSameOrigin(req *http.Request) bool {
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "":
+	case "same-origin":
+		return true
+	default:
+		return false
+	}
+
+	source := req.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return false
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return u.Host != "" && u.Host == requestutil.GetRequestHost(req)
+}
+
+func (s *Stash) cookieName() string {
+	return fmt.Sprintf("%v_replay", s.cookieOpts.Name)
+}
+
+func (s *Stash) key(state string) string {
+	return fmt.Sprintf("%s-replay-%s", s.cookieOpts.Name, state)
+}
+
+func (s *Stash) cipher() (encryption.Cipher, error) {
+	return encryption.NewCFBCipher(encryption.SecretBytes(s.cookieOpts.Secret))
+}
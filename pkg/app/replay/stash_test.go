@@ -0,0 +1,212 @@
+package replay
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stash", func() {
+	const (
+		state = "login-state"
+		form  = "name=value&other=1"
+	)
+
+	var (
+		store      *tests.MockStore
+		stash      *Stash
+		cookieOpts *options.Cookie
+	)
+
+	BeforeEach(func() {
+		store = tests.NewMockStore()
+		cookieOpts = &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdefghijklmnopqrstuv",
+			Path:   "/",
+		}
+		stash = NewStash(store, options.RequestReplay{
+			Enabled:     true,
+			MaxBodySize: 64,
+			Expire:      time.Minute,
+		}, cookieOpts)
+	})
+
+	newPost := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/form?step=2", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Sec-Fetch-Site", "same-origin")
+		return req
+	}
+
+	Context("Save and Take", func() {
+		It("stashes the request to be taken once", func() {
+			Expect(stash.Save(newPost(form), state)).To(Succeed())
+			Expect(stash.Has(context.Background(), state)).To(BeTrue())
+
+			stashed, err := stash.Take(context.Background(), state)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stashed).To(Equal(&Request{
+				Method:      http.MethodPost,
+				URL:         "/form?step=2",
+				ContentType: "application/x-www-form-urlencoded",
+				Body:        []byte(form),
+			}))
+
+			Expect(stash.Has(context.Background(), state)).To(BeFalse())
+			_, err = stash.Take(context.Background(), state)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("leaves the body to be read by later handlers", func() {
+			req := newPost(form)
+			Expect(stash.Save(req, state)).To(Succeed())
+
+			Expect(req.ParseForm()).To(Succeed())
+			Expect(req.PostForm.Get("name")).To(Equal("value"))
+		})
+
+		It("does not stash bodies larger than the maximum size", func() {
+			body := strings.Repeat("a", 65)
+			req := newPost(body)
+			Expect(stash.Save(req, state)).To(Equal(ErrBodyTooLarge))
+			Expect(stash.Has(context.Background(), state)).To(BeFalse())
+
+			read, err := ioutil.ReadAll(req.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(read)).To(Equal(body))
+		})
+
+		It("encrypts the stashed request", func() {
+			Expect(stash.Save(newPost(form), state)).To(Succeed())
+
+			data, err := store.Load(context.Background(), "_oauth2_proxy-replay-"+state)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).ToNot(ContainSubstring("name=value"))
+		})
+
+		It("expires stashed requests", func() {
+			Expect(stash.Save(newPost(form), state)).To(Succeed())
+			store.FastForward(2 * time.Minute)
+
+			_, err := stash.Take(context.Background(), state)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	DescribeTable("only stashes requests from the same origin",
+		func(headers map[string]string, expected error) {
+			req := httptest.NewRequest(http.MethodPost, "https://proxy.example.com/form", strings.NewReader(form))
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+
+			err := stash.Save(req, state)
+			if expected != nil {
+				Expect(err).To(Equal(expected))
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(stash.Has(context.Background(), state)).To(Equal(expected == nil))
+		},
+		Entry("with Sec-Fetch-Site same-origin", map[string]string{
+			"Sec-Fetch-Site": "same-origin",
+		}, nil),
+		Entry("with Sec-Fetch-Site cross-site", map[string]string{
+			"Sec-Fetch-Site": "cross-site",
+			"Origin":         "https://proxy.example.com",
+		}, ErrCrossOrigin),
+		Entry("with Sec-Fetch-Site same-site", map[string]string{
+			"Sec-Fetch-Site": "same-site",
+		}, ErrCrossOrigin),
+		Entry("with a matching Origin", map[string]string{
+			"Origin": "https://proxy.example.com",
+		}, nil),
+		Entry("with another Origin", map[string]string{
+			"Origin": "https://evil.example.com",
+		}, ErrCrossOrigin),
+		Entry("with a matching Referer", map[string]string{
+			"Origin":  "null",
+			"Referer": "https://proxy.example.com/form",
+		}, nil),
+		Entry("with another Referer", map[string]string{
+			"Referer": "https://evil.example.com/proxy.example.com",
+		}, ErrCrossOrigin),
+		Entry("without any origin headers", map[string]string{}, ErrCrossOrigin),
+	)
+
+	Context("Replay", func() {
+		stashed := &Request{
+			Method:      http.MethodPost,
+			URL:         "/form?step=2",
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        []byte(form),
+		}
+
+		It("replays the request in place of a GET to the same URL", func() {
+			req := httptest.NewRequest(http.MethodGet, "/form?step=2", nil)
+			Expect(stashed.Replay(req)).To(BeTrue())
+
+			Expect(req.Method).To(Equal(http.MethodPost))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
+			Expect(req.ContentLength).To(Equal(int64(len(form))))
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(form))
+		})
+
+		It("does not replay the request for another URL", func() {
+			req := httptest.NewRequest(http.MethodGet, "/other", nil)
+			Expect(stashed.Replay(req)).To(BeFalse())
+			Expect(req.Method).To(Equal(http.MethodGet))
+		})
+
+		It("does not replay the request in place of another method", func() {
+			req := httptest.NewRequest(http.MethodPut, "/form?step=2", nil)
+			Expect(stashed.Replay(req)).To(BeFalse())
+			Expect(req.Method).To(Equal(http.MethodPut))
+		})
+	})
+
+	Context("Cookies", func() {
+		It("loads the state from the replay cookie", func() {
+			rw := httptest.NewRecorder()
+			Expect(stash.SetCookie(rw, httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil), state)).To(Succeed())
+
+			req := httptest.NewRequest(http.MethodGet, "/form?step=2", nil)
+			for _, c := range rw.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			loaded, ok := stash.LoadCookie(req)
+			Expect(ok).To(BeTrue())
+			Expect(loaded).To(Equal(state))
+		})
+
+		It("rejects a replay cookie that is not signed", func() {
+			req := httptest.NewRequest(http.MethodGet, "/form?step=2", nil)
+			req.AddCookie(&http.Cookie{Name: "_oauth2_proxy_replay", Value: state})
+			_, ok := stash.LoadCookie(req)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("clears the replay cookie", func() {
+			rw := httptest.NewRecorder()
+			stash.ClearCookie(rw, httptest.NewRequest(http.MethodGet, "/form", nil))
+
+			cookies := rw.Result().Cookies()
+			Expect(cookies).To(HaveLen(1))
+			Expect(cookies[0].Name).To(Equal("_oauth2_proxy_replay"))
+			Expect(cookies[0].Value).To(BeEmpty())
+			Expect(cookies[0].Expires.Before(time.Now())).To(BeTrue())
+		})
+	})
+})
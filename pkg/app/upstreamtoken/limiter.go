@@ -0,0 +1,45 @@
+package upstreamtoken
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits how many tokens each user may mint within a fixed window.
+// The counts are reset at the start of each window, so only the users that
+// have minted tokens within the current window are held in memory.
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+// NewLimiter creates a Limiter that allows each user limit requests per window
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+	}
+}
+
+// Allow records a request from the user, and reports whether it is within
+// the limit
+func (l *Limiter) Allow(user string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.counts = make(map[string]int)
+	}
+
+	if l.counts[user] >= l.limit {
+		return false
+	}
+	l.counts[user]++
+	return true
+}
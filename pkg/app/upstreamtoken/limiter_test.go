@@ -0,0 +1,39 @@
+package upstreamtoken
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiter", func() {
+	var limiter *Limiter
+	now := time.Now()
+
+	BeforeEach(func() {
+		limiter = NewLimiter(2, time.Minute)
+	})
+
+	It("allows requests up to the limit within the window", func() {
+		Expect(limiter.Allow("user", now)).To(BeTrue())
+		Expect(limiter.Allow("user", now.Add(time.Second))).To(BeTrue())
+		Expect(limiter.Allow("user", now.Add(2*time.Second))).To(BeFalse())
+	})
+
+	It("limits each user separately", func() {
+		Expect(limiter.Allow("user", now)).To(BeTrue())
+		Expect(limiter.Allow("user", now)).To(BeTrue())
+		Expect(limiter.Allow("user", now)).To(BeFalse())
+
+		Expect(limiter.Allow("other", now)).To(BeTrue())
+	})
+
+	It("resets the counts in the next window", func() {
+		Expect(limiter.Allow("user", now)).To(BeTrue())
+		Expect(limiter.Allow("user", now)).To(BeTrue())
+		Expect(limiter.Allow("user", now)).To(BeFalse())
+
+		Expect(limiter.Allow("user", now.Add(time.Minute))).To(BeTrue())
+	})
+})
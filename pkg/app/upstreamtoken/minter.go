@@ -0,0 +1,239 @@
+package upstreamtoken
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+// MaxLifetime is the longest lifetime a minted token may have
+const MaxLifetime = time.Hour
+
+// ErrSessionExpired is returned when a token can't be minted because the
+// session has expired
+var ErrSessionExpired = errors.New("session has expired")
+
+// reservedClaims are set on every token and can't be mapped from the session
+var reservedClaims = map[string]struct{}{
+	"iss": {}, "sub": {}, "aud": {}, "exp": {}, "iat": {}, "nbf": {}, "jti": {},
+}
+
+// secretClaims are session claims that must never be added to a token, as
+// the tokens are given to the browser
+var secretClaims = map[string]struct{}{
+	"access_token": {}, "id_token": {}, "refresh_token": {},
+}
+
+// sessionClaims are the session claims that can be added to a token
+var sessionClaims = map[string]struct{}{
+	"user": {}, "email": {}, "groups": {}, "preferred_username": {},
+}
+
+// Claim maps a claim from the session to a claim of the minted tokens
+type Claim struct {
+	Name         string
+	SessionClaim string
+}
+
+// ParseClaim parses a claim in the form `token_claim=session_claim`, or
+// `claim` to use the same name for both
+func ParseClaim(claim string) (Claim, error) {
+	parts := strings.SplitN(claim, "=", 2)
+	c := Claim{Name: parts[0], SessionClaim: parts[0]}
+	if len(parts) == 2 {
+		c.SessionClaim = parts[1]
+	}
+
+	if c.Name == "" || c.SessionClaim == "" {
+		return Claim{}, fmt.Errorf("invalid claim %q: must be of the form token_claim=session_claim or claim", claim)
+	}
+	if _, ok := reservedClaims[c.Name]; ok {
+		return Claim{}, fmt.Errorf("invalid claim %q: %q is set on every token and can't be overridden", claim, c.Name)
+	}
+	if _, ok := secretClaims[c.SessionClaim]; ok {
+		return Claim{}, fmt.Errorf("invalid claim %q: the session's %q must not be exposed in upstream tokens", claim, c.SessionClaim)
+	}
+	if _, ok := sessionClaims[c.SessionClaim]; !ok {
+		return Claim{}, fmt.Errorf("invalid claim %q: unknown session claim %q", claim, c.SessionClaim)
+	}
+	return c, nil
+}
+
+// Minter mints short lived tokens from sessions, signed with an RSA key
+type Minter struct {
+	key             *rsa.PrivateKey
+	keyID           string
+	issuer          string
+	audience        string
+	lifetime        time.Duration
+	sessionLifetime time.Duration
+	claims          []Claim
+	limiter         *Limiter
+}
+
+// NewMinter creates a Minter from the options.
+// The sessionLifetime is the cookie expiry, which tokens may not outlive.
+func NewMinter(opts options.UpstreamToken, sessionLifetime time.Duration) (*Minter, error) {
+	key, err := LoadKey(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make([]Claim, 0, len(opts.Claims))
+	for _, claim := range opts.Claims {
+		c, err := ParseClaim(claim)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+
+	keyID, err := keyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Minter{
+		key:             key,
+		keyID:           keyID,
+		issuer:          opts.Issuer,
+		audience:        opts.Audience,
+		lifetime:        opts.Lifetime,
+		sessionLifetime: sessionLifetime,
+		claims:          claims,
+		limiter:         NewLimiter(opts.RateLimit, time.Minute),
+	}, nil
+}
+
+// LoadKey loads a PEM encoded RSA private key from the file
+func LoadKey(path string) (*rsa.PrivateKey, error) {
+	keyData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read upstream token key file %s: %v", path, err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse upstream token key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// Allow reports whether the user may mint another token, or has reached the
+// rate limit
+func (m *Minter) Allow(user string, now time.Time) bool {
+	return m.limiter.Allow(user, now)
+}
+
+// Mint creates a signed token for the session, and returns it along with its
+// expiry.
+// The token expires after the configured lifetime, or when the session
+// expires, whichever is sooner.
+func (m *Minter) Mint(session *sessions.SessionState, now time.Time) (string, time.Time, error) {
+	expires := now.Add(m.lifetime)
+	if session.ExpiresOn != nil && session.ExpiresOn.Before(expires) {
+		expires = *session.ExpiresOn
+	}
+	if session.CreatedAt != nil && m.sessionLifetime > 0 {
+		if sessionExpires := session.CreatedAt.Add(m.sessionLifetime); sessionExpires.Before(expires) {
+			expires = sessionExpires
+		}
+	}
+	if !expires.After(now) {
+		return "", time.Time{}, ErrSessionExpired
+	}
+
+	jti, err := encryption.Nonce()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to generate token id: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"sub": subject(session),
+		"aud": m.audience,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": expires.Unix(),
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+	}
+	if m.issuer != "" {
+		claims["iss"] = m.issuer
+	}
+	for _, c := range m.claims {
+		values := session.GetClaim(c.SessionClaim)
+		switch {
+		case c.SessionClaim == "groups":
+			// Groups are always a list, even with a single group
+			claims[c.Name] = values
+		case len(values) == 1 && values[0] != "":
+			claims[c.Name] = values[0]
+		case len(values) > 1:
+			claims[c.Name] = values
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.keyID
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to sign token: %v", err)
+	}
+	return signed, expires, nil
+}
+
+// subject identifies the user of the session
+func subject(session *sessions.SessionState) string {
+	if session.User != "" {
+		return session.User
+	}
+	return session.Email
+}
+
+// jsonWebKey is the public part of an RSA key, as a JSON Web Key
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JWKS returns the JSON Web Key Set containing the public key that tokens
+// can be verified with
+func (m *Minter) JWKS() ([]byte, error) {
+	return json.Marshal(struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{
+		Keys: []jsonWebKey{{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			KeyID:     m.keyID,
+			Modulus:   base64.RawURLEncoding.EncodeToString(m.key.PublicKey.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.PublicKey.E)).Bytes()),
+		}},
+	})
+}
+
+// keyID identifies the key by a hash of the public key
+func keyID(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal upstream token public key: %v", err)
+	}
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
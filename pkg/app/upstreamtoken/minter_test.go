@@ -0,0 +1,168 @@
+package upstreamtoken
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Minter", func() {
+	var key *rsa.PrivateKey
+	var keyFile string
+	var minter *Minter
+	now := time.Unix(1600000000, 0)
+
+	BeforeEach(func() {
+		key, keyFile = writeTestKey()
+
+		var err error
+		minter, err = NewMinter(options.UpstreamToken{
+			KeyFile:   keyFile,
+			Issuer:    "https://proxy.example.com",
+			Audience:  "https://api.example.com",
+			Lifetime:  5 * time.Minute,
+			Claims:    []string{"email", "groups", "username=preferred_username"},
+			RateLimit: 10,
+		}, time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		removeTestKey(keyFile)
+	})
+
+	// parse verifies the token with the public key and returns its claims
+	parse := func(token string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		parser := &jwt.Parser{SkipClaimsValidation: true}
+		_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			Expect(t.Method).To(Equal(jwt.SigningMethodRS256))
+			Expect(t.Header["kid"]).To(Equal(minter.keyID))
+			return &key.PublicKey, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return claims
+	}
+
+	It("mints tokens signed by the key with the session claims", func() {
+		session := &sessions.SessionState{
+			User:              "123456789",
+			Email:             "user@example.com",
+			Groups:            []string{"admins"},
+			PreferredUsername: "user",
+			AccessToken:       "AccessToken",
+			CreatedAt:         &now,
+		}
+
+		token, expires, err := minter.Mint(session, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expires).To(Equal(now.Add(5 * time.Minute)))
+		Expect(token).ToNot(ContainSubstring("AccessToken"))
+
+		claims := parse(token)
+		Expect(claims).To(HaveKeyWithValue("iss", "https://proxy.example.com"))
+		Expect(claims).To(HaveKeyWithValue("aud", "https://api.example.com"))
+		Expect(claims).To(HaveKeyWithValue("sub", "123456789"))
+		Expect(claims).To(HaveKeyWithValue("iat", BeEquivalentTo(now.Unix())))
+		Expect(claims).To(HaveKeyWithValue("exp", BeEquivalentTo(expires.Unix())))
+		Expect(claims).To(HaveKey("jti"))
+		Expect(claims).To(HaveKeyWithValue("email", "user@example.com"))
+		Expect(claims).To(HaveKeyWithValue("groups", ConsistOf("admins")))
+		Expect(claims).To(HaveKeyWithValue("username", "user"))
+	})
+
+	It("omits empty claims and the issuer when not configured", func() {
+		minter.issuer = ""
+		session := &sessions.SessionState{Email: "user@example.com", CreatedAt: &now}
+
+		token, _, err := minter.Mint(session, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		claims := parse(token)
+		Expect(claims).ToNot(HaveKey("iss"))
+		Expect(claims).ToNot(HaveKey("username"))
+		Expect(claims).To(HaveKeyWithValue("sub", "user@example.com"))
+		Expect(claims).To(HaveKeyWithValue("groups", BeEmpty()))
+	})
+
+	It("does not mint tokens that outlive the session's expiry", func() {
+		expiresOn := now.Add(time.Minute)
+		session := &sessions.SessionState{Email: "user@example.com", CreatedAt: &now, ExpiresOn: &expiresOn}
+
+		_, expires, err := minter.Mint(session, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expires).To(Equal(expiresOn))
+	})
+
+	It("does not mint tokens that outlive the session cookie", func() {
+		created := now.Add(-58 * time.Minute)
+		session := &sessions.SessionState{Email: "user@example.com", CreatedAt: &created}
+
+		_, expires, err := minter.Mint(session, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expires).To(Equal(created.Add(time.Hour)))
+	})
+
+	It("does not mint tokens for expired sessions", func() {
+		expiresOn := now.Add(-time.Minute)
+		session := &sessions.SessionState{Email: "user@example.com", CreatedAt: &now, ExpiresOn: &expiresOn}
+
+		_, _, err := minter.Mint(session, now)
+		Expect(err).To(Equal(ErrSessionExpired))
+	})
+
+	It("publishes the public key as a JSON Web Key Set", func() {
+		jwks, err := minter.JWKS()
+		Expect(err).ToNot(HaveOccurred())
+
+		var keySet struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		Expect(json.Unmarshal(jwks, &keySet)).To(Succeed())
+		Expect(keySet.Keys).To(HaveLen(1))
+
+		jwk := keySet.Keys[0]
+		Expect(jwk).To(HaveKeyWithValue("kty", "RSA"))
+		Expect(jwk).To(HaveKeyWithValue("alg", "RS256"))
+		Expect(jwk).To(HaveKeyWithValue("kid", minter.keyID))
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk["n"])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(new(big.Int).SetBytes(n)).To(Equal(key.PublicKey.N))
+		Expect(jwk).To(HaveKeyWithValue("e", "AQAB"))
+	})
+
+	It("fails to load a missing key file", func() {
+		_, err := NewMinter(options.UpstreamToken{KeyFile: "/does/not/exist.pem"}, time.Hour)
+		Expect(err).To(MatchError(ContainSubstring("could not read upstream token key file /does/not/exist.pem")))
+	})
+})
+
+var _ = Describe("ParseClaim", func() {
+	DescribeTable("parses claims",
+		func(claim string, expected Claim, expectedErr string) {
+			c, err := ParseClaim(claim)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(Equal(expected))
+		},
+		Entry("with a single name", "email", Claim{Name: "email", SessionClaim: "email"}, ""),
+		Entry("with a mapping", "username=preferred_username", Claim{Name: "username", SessionClaim: "preferred_username"}, ""),
+		Entry("with an empty claim", "=email", Claim{}, "invalid claim \"=email\": must be of the form token_claim=session_claim or claim"),
+		Entry("with a reserved claim", "sub=email", Claim{}, "invalid claim \"sub=email\": \"sub\" is set on every token and can't be overridden"),
+		Entry("with a provider token", "token=access_token", Claim{}, "invalid claim \"token=access_token\": the session's \"access_token\" must not be exposed in upstream tokens"),
+		Entry("with an unknown session claim", "name", Claim{}, "invalid claim \"name\": unknown session claim \"name\""),
+	)
+})
@@ -0,0 +1,45 @@
+package upstreamtoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestUpstreamTokenSuite(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	logger.SetErrOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Upstream Token Suite")
+}
+
+// writeTestKey writes a new PEM encoded RSA private key to a temporary file
+// and returns the key and the path to the file
+func writeTestKey() (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+
+	file, err := ioutil.TempFile("", "upstream-token-key-*.pem")
+	Expect(err).ToNot(HaveOccurred())
+	defer file.Close()
+
+	Expect(pem.Encode(file, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})).To(Succeed())
+	return key, file.Name()
+}
+
+// removeTestKey removes a key file written by writeTestKey
+func removeTestKey(path string) {
+	Expect(os.Remove(path)).To(Succeed())
+}
@@ -1,6 +1,7 @@
 package cookies
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,6 +13,15 @@ import (
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
 
+// ValueEncoding returns the encoding of signed cookie values for the
+// *options.Cookie
+func ValueEncoding(opts *options.Cookie) *base64.Encoding {
+	if opts.ValueEncoding == options.CookieValueEncodingBase64URL {
+		return base64.RawURLEncoding
+	}
+	return base64.URLEncoding
+}
+
 // MakeCookieFromOptions constructs a cookie based on the given *options.CookieOptions,
 // value and creation time
 func MakeCookieFromOptions(req *http.Request, name string, value string, opts *options.Cookie, expiration time.Duration, now time.Time) *http.Cookie {
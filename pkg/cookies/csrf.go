@@ -22,6 +22,7 @@ type CSRF interface {
 	CheckOIDCNonce(string) bool
 
 	SetSessionNonce(s *sessions.SessionState)
+	NewOAuthState(redirect string, provider string) *OAuthState
 
 	SetCookie(http.ResponseWriter, *http.Request) (*http.Cookie, error)
 	ClearCookie(http.ResponseWriter, *http.Request)
@@ -42,13 +43,14 @@ type csrf struct {
 	time       clock.Clock
 }
 
-// NewCSRF creates a CSRF with random nonces
-func NewCSRF(opts *options.Cookie) (CSRF, error) {
-	state, err := encryption.Nonce()
+// NewCSRF creates a CSRF with random nonces of the lengths configured in the
+// OAuthState options. Lengths that aren't set use the default nonce length.
+func NewCSRF(opts *options.Cookie, stateOpts options.OAuthState) (CSRF, error) {
+	state, err := newNonce(stateOpts.StateLength)
 	if err != nil {
 		return nil, err
 	}
-	nonce, err := encryption.Nonce()
+	nonce, err := newNonce(stateOpts.NonceLength)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +63,15 @@ func NewCSRF(opts *options.Cookie) (CSRF, error) {
 	}, nil
 }
 
+// newNonce generates a nonce of the given length, or of the default length
+// if it isn't set
+func newNonce(length int) ([]byte, error) {
+	if length <= 0 {
+		length = encryption.DefaultNonceLength
+	}
+	return encryption.NonceWithLength(length)
+}
+
 // LoadCSRFCookie loads a CSRF object from a request's CSRF cookie
 func LoadCSRFCookie(req *http.Request, opts *options.Cookie) (CSRF, error) {
 	cookie, err := req.Cookie(csrfCookieName(opts))
@@ -142,7 +153,7 @@ func (c *csrf) encodeCookie() (string, error) {
 		return "", err
 	}
 
-	return encryption.SignedValue(c.cookieOpts.Secret, c.cookieName(), encrypted, c.time.Now())
+	return encryption.SignedValue(c.cookieOpts.Secret, c.cookieName(), encrypted, c.time.Now(), ValueEncoding(c.cookieOpts))
 }
 
 // decodeCSRFCookie validates the signature then decrypts and decodes a CSRF
@@ -33,7 +33,7 @@ var _ = Describe("CSRF Cookie Tests", func() {
 		}
 
 		var err error
-		publicCSRF, err = NewCSRF(cookieOpts)
+		publicCSRF, err = NewCSRF(cookieOpts, options.OAuthState{})
 		Expect(err).ToNot(HaveOccurred())
 
 		privateCSRF = publicCSRF.(*csrf)
@@ -47,12 +47,25 @@ var _ = Describe("CSRF Cookie Tests", func() {
 		})
 
 		It("makes unique nonces between multiple CSRFs", func() {
-			other, err := NewCSRF(cookieOpts)
+			other, err := NewCSRF(cookieOpts, options.OAuthState{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(privateCSRF.OAuthState).ToNot(Equal(other.(*csrf).OAuthState))
 			Expect(privateCSRF.OIDCNonce).ToNot(Equal(other.(*csrf).OIDCNonce))
 		})
+
+		It("makes nonces of the default length", func() {
+			Expect(privateCSRF.OAuthState).To(HaveLen(encryption.DefaultNonceLength))
+			Expect(privateCSRF.OIDCNonce).To(HaveLen(encryption.DefaultNonceLength))
+		})
+
+		It("makes nonces of the configured lengths", func() {
+			other, err := NewCSRF(cookieOpts, options.OAuthState{StateLength: 16, NonceLength: 64})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(other.(*csrf).OAuthState).To(HaveLen(16))
+			Expect(other.(*csrf).OIDCNonce).To(HaveLen(64))
+		})
 	})
 
 	Context("CheckOAuthState and CheckOIDCNonce", func() {
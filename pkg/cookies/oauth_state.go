@@ -0,0 +1,106 @@
+package cookies
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// ErrInvalidOAuthState is returned when a signed OAuth2 state has been
+// tampered with or has expired
+var ErrInvalidOAuthState = errors.New("OAuth state failed validation")
+
+// OAuthState is a signed OAuth2 state parameter. It holds everything needed
+// to complete the login flow at the callback, so that the state can be
+// verified without the CSRF cookie.
+type OAuthState struct {
+	// Nonce holds the hash of the CSRF's OAuth state nonce, so that the state
+	// can also be checked against the CSRF cookie.
+	Nonce string `msgpack:"s,omitempty"`
+
+	// OIDCNonce holds the OIDC nonce that is set as the nonce on the session
+	// when there is no CSRF cookie to take it from.
+	OIDCNonce []byte `msgpack:"n,omitempty"`
+
+	// Redirect is the application redirect to return to after the login.
+	Redirect string `msgpack:"r,omitempty"`
+
+	// Provider identifies the provider that the login flow was started with.
+	Provider string `msgpack:"p,omitempty"`
+
+	// Retries counts the times the login flow has been restarted because the
+	// state or nonce could not be verified at the callback.
+	Retries int `msgpack:"rt,omitempty"`
+
+	// StepUp holds the method of the request that the login was started for
+	// when it requires step-up authentication, or "*" when the method is
+	// unknown. It is empty for other logins.
+	StepUp string `msgpack:"su,omitempty"`
+}
+
+// NewOAuthState creates an OAuthState for the CSRF's nonces
+func (c *csrf) NewOAuthState(redirect string, provider string) *OAuthState {
+	return &OAuthState{
+		Nonce:     c.HashOAuthState(),
+		OIDCNonce: c.OIDCNonce,
+		Redirect:  redirect,
+		Provider:  provider,
+	}
+}
+
+// SetSessionNonce sets the OIDCNonce on a SessionState
+func (s *OAuthState) SetSessionNonce(ss *sessions.SessionState) {
+	ss.Nonce = s.OIDCNonce
+}
+
+// Encode MessagePack encodes and encrypts the OAuthState, so that the OIDC
+// nonce is not exposed, and then signs it with the time it was created
+func (s *OAuthState) Encode(opts *options.Cookie, now time.Time) (string, error) {
+	packed, err := msgpack.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling OAuth state to msgpack: %v", err)
+	}
+
+	encrypted, err := encrypt(packed, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return encryption.SignedValue(opts.Secret, oauthStateKey(opts), encrypted, now, ValueEncoding(opts))
+}
+
+// DecodeOAuthState validates the signature and age of a signed OAuth2 state
+// then decrypts and decodes it into an OAuthState
+func DecodeOAuthState(state string, opts *options.Cookie, expire time.Duration) (*OAuthState, error) {
+	// The state is signed in the same way as a cookie, keyed by a name that
+	// no cookie uses so that signed cookie values can't be used as a state
+	val, _, ok := encryption.Validate(&http.Cookie{Name: oauthStateKey(opts), Value: state}, opts.Secret, expire)
+	if !ok {
+		return nil, ErrInvalidOAuthState
+	}
+
+	decrypted, err := decrypt(val, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &OAuthState{}
+	err = msgpack.Unmarshal(decrypted, s)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling data to OAuth state: %v", err)
+	}
+
+	return s, nil
+}
+
+// oauthStateKey returns the key signed states are signed with, derived from
+// the base session cookie name
+func oauthStateKey(opts *options.Cookie) string {
+	return fmt.Sprintf("%v_oauth_state", opts.Name)
+}
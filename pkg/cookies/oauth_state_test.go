@@ -0,0 +1,105 @@
+package cookies
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OAuth State Tests", func() {
+	var (
+		cookieOpts *options.Cookie
+		state      *OAuthState
+	)
+
+	BeforeEach(func() {
+		cookieOpts = &options.Cookie{
+			Name:   cookieName,
+			Secret: cookieSecret,
+			Expire: time.Hour,
+		}
+
+		c := &csrf{
+			OAuthState: []byte(csrfState),
+			OIDCNonce:  []byte(csrfNonce),
+			cookieOpts: cookieOpts,
+		}
+		state = c.NewOAuthState("/app", "providerID")
+	})
+
+	Context("NewOAuthState", func() {
+		It("holds the CSRF's nonces, the redirect and the provider", func() {
+			Expect(state).To(Equal(&OAuthState{
+				Nonce:     encryption.HashNonce([]byte(csrfState)),
+				OIDCNonce: []byte(csrfNonce),
+				Redirect:  "/app",
+				Provider:  "providerID",
+			}))
+		})
+	})
+
+	Context("SetSessionNonce", func() {
+		It("sets the OIDC nonce on the session", func() {
+			session := &sessions.SessionState{}
+			state.SetSessionNonce(session)
+			Expect(session.Nonce).To(Equal([]byte(csrfNonce)))
+		})
+	})
+
+	Context("Encode and DecodeOAuthState", func() {
+		It("decodes an encoded state", func() {
+			encoded, err := state.Encode(cookieOpts, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded, err := DecodeOAuthState(encoded, cookieOpts, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal(state))
+		})
+
+		It("does not expose the OIDC nonce", func() {
+			encoded, err := state.Encode(cookieOpts, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(encoded).ToNot(ContainSubstring(csrfNonce))
+		})
+
+		It("rejects an expired state", func() {
+			encoded, err := state.Encode(cookieOpts, time.Now().Add(-2*time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = DecodeOAuthState(encoded, cookieOpts, time.Minute)
+			Expect(err).To(Equal(ErrInvalidOAuthState))
+		})
+
+		It("rejects a tampered state", func() {
+			encoded, err := state.Encode(cookieOpts, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = DecodeOAuthState("a"+encoded, cookieOpts, time.Minute)
+			Expect(err).To(Equal(ErrInvalidOAuthState))
+		})
+
+		It("rejects a state signed with another secret", func() {
+			encoded, err := state.Encode(&options.Cookie{
+				Name:   cookieName,
+				Secret: "0123456789abcdefghijklmnopqrstuv",
+			}, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = DecodeOAuthState(encoded, cookieOpts, time.Minute)
+			Expect(err).To(Equal(ErrInvalidOAuthState))
+		})
+
+		It("rejects a signed cookie value", func() {
+			c := &csrf{OAuthState: []byte(csrfState), cookieOpts: cookieOpts}
+			encoded, err := c.encodeCookie()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = DecodeOAuthState(encoded, cookieOpts, time.Minute)
+			Expect(err).To(Equal(ErrInvalidOAuthState))
+		})
+	})
+})
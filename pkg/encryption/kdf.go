@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// ScryptKDF derives secrets with scrypt.
+	// Its parameters are the CPU/memory cost `n`, block size `r` and
+	// parallelism `p`.
+	ScryptKDF = "scrypt"
+
+	// Argon2idKDF derives secrets with Argon2id.
+	// Its parameters are the number of passes `t`, memory in KiB `m` and
+	// number of threads `p`.
+	Argon2idKDF = "argon2id"
+
+	// derivedSecretLength creates secrets for AES-256
+	derivedSecretLength = 32
+)
+
+// defaultKDFParams follow the recommendations for interactive logins, they
+// are only needed once at startup
+var defaultKDFParams = map[string]map[string]int{
+	ScryptKDF:   {"n": 32768, "r": 8, "p": 1},
+	Argon2idKDF: {"t": 3, "m": 64 * 1024, "p": 4},
+}
+
+// DeriveSecret derives a 32 byte secret from a passphrase of any length using
+// the named key derivation function.
+// The salt and parameters must be the same on every instance so that they all
+// derive the same secret. Params is a comma separated list of key=value pairs,
+// eg. "n=32768,r=8,p=1", any parameters not given use their defaults.
+func DeriveSecret(passphrase, kdf, salt, params string) ([]byte, error) {
+	kdfParams, err := parseKDFParams(kdf, params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kdf {
+	case ScryptKDF:
+		return scrypt.Key([]byte(passphrase), []byte(salt), kdfParams["n"], kdfParams["r"], kdfParams["p"], derivedSecretLength)
+	case Argon2idKDF:
+		if kdfParams["p"] > 255 {
+			return nil, fmt.Errorf("argon2id parameter p must be at most 255, got %d", kdfParams["p"])
+		}
+		return argon2.IDKey([]byte(passphrase), []byte(salt), uint32(kdfParams["t"]), uint32(kdfParams["m"]), uint8(kdfParams["p"]), derivedSecretLength), nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation function %q", kdf)
+	}
+}
+
+// parseKDFParams parses the key=value parameters of a key derivation function
+// on top of its defaults
+func parseKDFParams(kdf, params string) (map[string]int, error) {
+	defaults, ok := defaultKDFParams[kdf]
+	if !ok {
+		return nil, fmt.Errorf("unknown key derivation function %q", kdf)
+	}
+
+	kdfParams := make(map[string]int, len(defaults))
+	for key, value := range defaults {
+		kdfParams[key] = value
+	}
+	if params == "" {
+		return kdfParams, nil
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s parameter %q: must be of the form key=value", kdf, param)
+		}
+		if _, ok := defaults[parts[0]]; !ok {
+			return nil, fmt.Errorf("unknown %s parameter %q", kdf, parts[0])
+		}
+		value, err := strconv.Atoi(parts[1])
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("invalid %s parameter %q: must be a positive integer", kdf, param)
+		}
+		kdfParams[parts[0]] = value
+	}
+	return kdfParams, nil
+}
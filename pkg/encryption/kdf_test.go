@@ -0,0 +1,90 @@
+package encryption
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveSecretScrypt(t *testing.T) {
+	// Test vector from RFC 7914, truncated to the derived secret length
+	secret, err := DeriveSecret("password", ScryptKDF, "NaCl", "n=1024,r=8,p=16")
+	assert.NoError(t, err)
+	assert.Equal(t, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162", hex.EncodeToString(secret))
+}
+
+func TestDeriveSecretDeterministic(t *testing.T) {
+	for _, kdf := range []string{ScryptKDF, Argon2idKDF} {
+		t.Run(kdf, func(t *testing.T) {
+			params := "n=1024"
+			if kdf == Argon2idKDF {
+				params = "t=1,m=1024,p=1"
+			}
+
+			secret, err := DeriveSecret("a passphrase of any length", kdf, "salt", params)
+			assert.NoError(t, err)
+			assert.Len(t, secret, 32)
+
+			again, err := DeriveSecret("a passphrase of any length", kdf, "salt", params)
+			assert.NoError(t, err)
+			assert.Equal(t, secret, again)
+
+			otherSalt, err := DeriveSecret("a passphrase of any length", kdf, "other salt", params)
+			assert.NoError(t, err)
+			assert.NotEqual(t, secret, otherSalt)
+		})
+	}
+}
+
+func TestDeriveSecretErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		kdf    string
+		params string
+		err    string
+	}{
+		{
+			name: "with an unknown key derivation function",
+			kdf:  "md5",
+			err:  "unknown key derivation function \"md5\"",
+		},
+		{
+			name:   "with an unknown parameter",
+			kdf:    ScryptKDF,
+			params: "n=1024,t=1",
+			err:    "unknown scrypt parameter \"t\"",
+		},
+		{
+			name:   "with a malformed parameter",
+			kdf:    Argon2idKDF,
+			params: "t",
+			err:    "invalid argon2id parameter \"t\": must be of the form key=value",
+		},
+		{
+			name:   "with a parameter that is not a positive integer",
+			kdf:    ScryptKDF,
+			params: "n=0",
+			err:    "invalid scrypt parameter \"n=0\": must be a positive integer",
+		},
+		{
+			name:   "with an invalid scrypt cost",
+			kdf:    ScryptKDF,
+			params: "n=1000",
+			err:    "scrypt: N must be > 1 and a power of 2",
+		},
+		{
+			name:   "with too many argon2id threads",
+			kdf:    Argon2idKDF,
+			params: "p=256",
+			err:    "argon2id parameter p must be at most 255, got 256",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DeriveSecret("passphrase", tc.kdf, "salt", tc.params)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -8,9 +8,28 @@ import (
 	"golang.org/x/crypto/blake2b"
 )
 
+const (
+	// DefaultNonceLength is the length in bytes of the nonces generated by Nonce
+	DefaultNonceLength = 32
+
+	// MinNonceLength is the shortest nonce, in bytes, that may be configured,
+	// giving 128 bits of entropy
+	MinNonceLength = 16
+
+	// MaxNonceLength is the longest nonce, in bytes, that may be configured,
+	// so that nonces fit within cookies and URLs
+	MaxNonceLength = 128
+)
+
 // Nonce generates a random 32-byte slice to be used as a nonce
 func Nonce() ([]byte, error) {
-	b := make([]byte, 32)
+	return NonceWithLength(DefaultNonceLength)
+}
+
+// NonceWithLength generates a random slice of the given length in bytes to
+// be used as a nonce
+func NonceWithLength(length int) ([]byte, error) {
+	b := make([]byte, length)
 	_, err := rand.Read(b)
 	if err != nil {
 		return nil, err
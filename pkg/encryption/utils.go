@@ -52,7 +52,7 @@ func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value
 		t = time.Unix(int64(ts), 0)
 		if t.After(time.Now().Add(expiration*-1)) && t.Before(time.Now().Add(time.Minute*5)) {
 			// it's a valid cookie. now get the contents
-			rawValue, err := base64.URLEncoding.DecodeString(parts[0])
+			rawValue, err := decodeSignedValue(parts[0])
 			if err == nil {
 				value = rawValue
 				ok = true
@@ -63,29 +63,44 @@ func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value
 	return
 }
 
-// SignedValue returns a cookie that is signed and can later be checked with Validate
-func SignedValue(seed string, key string, value []byte, now time.Time) (string, error) {
-	encodedValue := base64.URLEncoding.EncodeToString(value)
+// SignedValue returns a cookie that is signed and can later be checked with Validate.
+// The value and signature are encoded with the encoding, which must use the
+// URL-safe alphabet. Validate accepts values encoded with or without padding.
+func SignedValue(seed string, key string, value []byte, now time.Time, encoding *base64.Encoding) (string, error) {
+	encodedValue := encoding.EncodeToString(value)
 	timeStr := fmt.Sprintf("%d", now.Unix())
-	sig, err := cookieSignature(sha256.New, seed, key, encodedValue, timeStr)
+	mac, err := cookieMAC(sha256.New, seed, key, encodedValue, timeStr)
 	if err != nil {
 		return "", err
 	}
-	cookieVal := fmt.Sprintf("%s|%s|%s", encodedValue, timeStr, sig)
+	cookieVal := fmt.Sprintf("%s|%s|%s", encodedValue, timeStr, encoding.EncodeToString(mac))
 	return cookieVal, nil
 }
 
+// decodeSignedValue decodes the parts of signed values, with or without
+// padding
+func decodeSignedValue(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
 func cookieSignature(signer func() hash.Hash, args ...string) (string, error) {
+	mac, err := cookieMAC(signer, args...)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(mac), nil
+}
+
+func cookieMAC(signer func() hash.Hash, args ...string) ([]byte, error) {
 	h := hmac.New(signer, []byte(args[0]))
 	for _, arg := range args[1:] {
 		_, err := h.Write([]byte(arg))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 	var b []byte
-	b = h.Sum(b)
-	return base64.URLEncoding.EncodeToString(b), nil
+	return h.Sum(b), nil
 }
 
 func checkSignature(signature string, args ...string) bool {
@@ -97,9 +112,9 @@ func checkSignature(signature string, args ...string) bool {
 }
 
 func checkHmac(input, expected string) bool {
-	inputMAC, err1 := base64.URLEncoding.DecodeString(input)
+	inputMAC, err1 := decodeSignedValue(input)
 	if err1 == nil {
-		expectedMAC, err2 := base64.URLEncoding.DecodeString(expected)
+		expectedMAC, err2 := decodeSignedValue(expected)
 		if err2 == nil {
 			return hmac.Equal(inputMAC, expectedMAC)
 		}
@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -100,3 +102,31 @@ func TestSignAndValidate(t *testing.T) {
 	assert.False(t, checkSignature(sha256sig, seed, key, "tampered", epoch))
 	assert.False(t, checkSignature(sha1sig, seed, key, "tampered", epoch))
 }
+
+func TestSignedValueEncodings(t *testing.T) {
+	seed := "0123456789abcdef"
+	key := "cookie-name"
+	// Encodes to a value with URL-safe characters and padding
+	value := []byte("\xfb\xff\xfe session")
+	now := time.Now()
+
+	padded, err := SignedValue(seed, key, value, now, base64.URLEncoding)
+	assert.NoError(t, err)
+	assert.Contains(t, padded, "=")
+
+	raw, err := SignedValue(seed, key, value, now, base64.RawURLEncoding)
+	assert.NoError(t, err)
+	assert.NotContains(t, raw, "=")
+	assert.NotContains(t, raw, "+")
+	assert.NotContains(t, raw, "/")
+
+	// Either encoding is accepted, e.g. while migrating between them
+	for _, signed := range []string{padded, raw} {
+		validated, _, ok := Validate(&http.Cookie{Name: key, Value: signed}, seed, time.Hour)
+		assert.True(t, ok)
+		assert.Equal(t, value, validated)
+	}
+
+	_, _, ok := Validate(&http.Cookie{Name: "other-name", Value: raw}, seed, time.Hour)
+	assert.False(t, ok)
+}
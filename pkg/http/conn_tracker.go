@@ -0,0 +1,138 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hijackedPollInterval is how often the connTracker checks whether all of
+// the hijacked connections have been closed while draining.
+const hijackedPollInterval = 100 * time.Millisecond
+
+// connTracker tracks the connections hijacked from the http.Server, e.g. by
+// WebSocket proxying, so that they can be drained or closed when the server
+// is shut down. The http.Server stops tracking connections once they are
+// hijacked.
+type connTracker struct {
+	mutex    sync.Mutex
+	hijacked map[*trackedConn]struct{}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		hijacked: make(map[*trackedConn]struct{}),
+	}
+}
+
+// handler wraps the handler so that any connections it hijacks are tracked.
+func (t *connTracker) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&trackedResponseWriter{ResponseWriter: rw, tracker: t}, req)
+	})
+}
+
+func (t *connTracker) add(c *trackedConn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.hijacked[c] = struct{}{}
+}
+
+func (t *connTracker) remove(c *trackedConn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.hijacked, c)
+}
+
+// hijackedCount returns the number of hijacked connections that are open.
+func (t *connTracker) hijackedCount() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.hijacked)
+}
+
+// waitForHijacked waits for all of the hijacked connections to be closed,
+// or for the context to be done.
+func (t *connTracker) waitForHijacked(ctx context.Context) error {
+	ticker := time.NewTicker(hijackedPollInterval)
+	defer ticker.Stop()
+	for t.hijackedCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// closeHijacked closes all of the open hijacked connections.
+func (t *connTracker) closeHijacked() {
+	t.mutex.Lock()
+	conns := make([]*trackedConn, 0, len(t.hijacked))
+	for c := range t.hijacked {
+		conns = append(conns, c)
+	}
+	t.mutex.Unlock()
+
+	for _, c := range conns {
+		// The connection may already be closed by its handler
+		_ = c.Close()
+	}
+}
+
+// trackedResponseWriter adds the connection to the tracker when it is
+// hijacked.
+type trackedResponseWriter struct {
+	http.ResponseWriter
+	tracker *connTracker
+}
+
+// Hijack implements the `http.Hijacker` interface that actual ResponseWriters
+// implement to support websockets
+func (w *trackedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not available on writer")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	tc := &trackedConn{Conn: conn, tracker: w.tracker}
+	w.tracker.add(tc)
+	return tc, rw, nil
+}
+
+// Flush sends any buffered data to the client. Implements the `http.Flusher`
+// interface
+func (w *trackedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, so that an
+// http.ResponseController can reach its other optional interfaces
+func (w *trackedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// trackedConn removes itself from the tracker when it is closed.
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	once    sync.Once
+}
+
+// Close implements the net.Conn interface.
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.remove(c)
+	})
+	return c.Conn.Close()
+}
@@ -35,12 +35,22 @@ type Opts struct {
 
 	// TLS is the TLS configuration for the server.
 	TLS *options.TLS
+
+	// ShutdownTimeout is how long in-flight requests are given to complete
+	// when the server is shut down. A timeout of 0 waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// ShutdownWebSocketPolicy is how hijacked connections are handled when
+	// the server is shut down. See options.Shutdown.
+	ShutdownWebSocketPolicy string
 }
 
 // NewServer creates a new Server from the options given.
 func NewServer(opts Opts) (Server, error) {
 	s := &server{
-		handler: opts.Handler,
+		handler:                 opts.Handler,
+		shutdownTimeout:         opts.ShutdownTimeout,
+		shutdownWebSocketPolicy: opts.ShutdownWebSocketPolicy,
 	}
 	if err := s.setupListener(opts); err != nil {
 		return nil, fmt.Errorf("error setting up listener: %v", err)
@@ -56,6 +66,9 @@ func NewServer(opts Opts) (Server, error) {
 type server struct {
 	handler http.Handler
 
+	shutdownTimeout         time.Duration
+	shutdownWebSocketPolicy string
+
 	listener    net.Listener
 	tlsListener net.Listener
 }
@@ -90,13 +103,9 @@ func (s *server) setupTLSListener(opts Opts) error {
 		return nil
 	}
 
-	config := &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		MaxVersion: tls.VersionTLS13,
-		NextProtos: []string{"http/1.1"},
-	}
-	if opts.TLS == nil {
-		return errors.New("no TLS config provided")
+	config, err := newTLSConfig(opts.TLS)
+	if err != nil {
+		return err
 	}
 	cert, err := getCertificate(opts.TLS)
 	if err != nil {
@@ -115,6 +124,108 @@ func (s *server) setupTLSListener(opts Opts) error {
 	return nil
 }
 
+// ValidateTLS parses the minimum version and cipher suites of the TLS
+// configuration when the HTTPS server is enabled, without loading the
+// certificate or listening on the SecureBindAddress.
+func ValidateTLS(opts Opts) error {
+	if opts.SecureBindAddress == "" || opts.SecureBindAddress == "-" {
+		return nil
+	}
+	_, err := newTLSConfig(opts.TLS)
+	return err
+}
+
+// newTLSConfig creates the tls.Config for the HTTPS server from the TLS
+// options. The certificate is not loaded.
+func newTLSConfig(opts *options.TLS) (*tls.Config, error) {
+	if opts == nil {
+		return nil, errors.New("no TLS config provided")
+	}
+	minVersion, err := parseTLSVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		MaxVersion:   tls.VersionTLS13,
+		CipherSuites: cipherSuites,
+		NextProtos:   []string{"http/1.1"},
+	}, nil
+}
+
+// defaultCipherSuites are the cipher suites accepted for TLS 1.2 connections
+// when none are configured. These all provide forward secrecy and
+// authenticated encryption.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// parseTLSVersion converts the configured minimum TLS version into the
+// version used by the tls.Config
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "TLS1.2":
+		return tls.VersionTLS12, nil
+	case "TLS1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS min version %q: must be one of [%q, %q]", version, "TLS1.2", "TLS1.3")
+	}
+}
+
+// parseCipherSuites converts the configured cipher suite names into the IDs
+// used by the tls.Config.
+// Only the cipher suites supported by Go may be used.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites, nil
+	}
+
+	supported := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		supported[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		if !supportsTLS12(suite) {
+			return nil, fmt.Errorf("TLS cipher suite %q can't be configured: only TLS 1.2 cipher suites may be set", name)
+		}
+		if suite.Insecure {
+			logger.Printf("WARNING: the TLS cipher suite %q is insecure", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// supportsTLS12 returns whether the cipher suite can be used for TLS 1.2
+// connections. TLS 1.3 cipher suites are always enabled by Go.
+func supportsTLS12(suite *tls.CipherSuite) bool {
+	for _, version := range suite.SupportedVersions {
+		if version == tls.VersionTLS12 {
+			return true
+		}
+	}
+	return false
+}
+
 // Start starts the HTTP and HTTPS server if applicable.
 // It will block until the context is cancelled.
 // If any errors occur, only the first error will be returned.
@@ -143,19 +254,18 @@ func (s *server) Start(ctx context.Context) error {
 }
 
 // startServer creates and starts a new server with the given listener.
-// When the given context is cancelled the server will be shutdown.
+// When the given context is cancelled the server will be shutdown, waiting
+// up to the shutdown timeout for in-flight requests and hijacked connections
+// before closing them.
 // If any errors occur, only the first error will be returned.
 func (s *server) startServer(ctx context.Context, listener net.Listener) error {
-	srv := &http.Server{Handler: s.handler}
+	tracker := newConnTracker()
+	srv := &http.Server{Handler: tracker.handler(s.handler)}
 	g, groupCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		<-groupCtx.Done()
-
-		if err := srv.Shutdown(context.Background()); err != nil {
-			return fmt.Errorf("error shutting down server: %v", err)
-		}
-		return nil
+		return s.shutdown(srv, tracker)
 	})
 
 	g.Go(func() error {
@@ -168,6 +278,43 @@ func (s *server) startServer(ctx context.Context, listener net.Listener) error {
 	return g.Wait()
 }
 
+// shutdown stops the server from accepting new connections and waits for
+// the in-flight requests and hijacked connections to complete.
+// Once the shutdown timeout expires, any remaining connections are closed.
+func (s *server) shutdown(srv *http.Server, tracker *connTracker) error {
+	ctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	if s.shutdownWebSocketPolicy == options.ShutdownWebSocketClose {
+		tracker.closeHijacked()
+	}
+
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		if s.shutdownWebSocketPolicy == options.ShutdownWebSocketClose {
+			// Close any connections hijacked by the requests that were in-flight
+			tracker.closeHijacked()
+		}
+		err = tracker.waitForHijacked(ctx)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Printf("Shutdown timeout of %s exceeded, closing remaining connections", s.shutdownTimeout)
+		tracker.closeHijacked()
+		if err := srv.Close(); err != nil {
+			return fmt.Errorf("error closing server: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+	return nil
+}
+
 // getNetworkScheme gets the scheme for the HTTP server.
 func getNetworkScheme(addr string) string {
 	var scheme string
@@ -1,11 +1,16 @@
 package http
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	. "github.com/onsi/ginkgo"
@@ -211,6 +216,63 @@ var _ = Describe("Server", func() {
 				expectHTTPListener: false,
 				expectTLSListener:  false,
 			}),
+			Entry("with a valid TLS min version and cipher suites", &newServerTableInput{
+				opts: Opts{
+					Handler:           handler,
+					SecureBindAddress: "127.0.0.1:0",
+					TLS: &options.TLS{
+						Key:          &keyDataSource,
+						Cert:         &certDataSource,
+						MinVersion:   "TLS1.3",
+						CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+					},
+				},
+				expectedErr:        nil,
+				expectHTTPListener: false,
+				expectTLSListener:  true,
+			}),
+			Entry("with an invalid TLS min version", &newServerTableInput{
+				opts: Opts{
+					Handler:           handler,
+					SecureBindAddress: "127.0.0.1:0",
+					TLS: &options.TLS{
+						Key:        &keyDataSource,
+						Cert:       &certDataSource,
+						MinVersion: "TLS1.1",
+					},
+				},
+				expectedErr:        errors.New("error setting up TLS listener: invalid TLS min version \"TLS1.1\": must be one of [\"TLS1.2\", \"TLS1.3\"]"),
+				expectHTTPListener: false,
+				expectTLSListener:  false,
+			}),
+			Entry("with an unknown TLS cipher suite", &newServerTableInput{
+				opts: Opts{
+					Handler:           handler,
+					SecureBindAddress: "127.0.0.1:0",
+					TLS: &options.TLS{
+						Key:          &keyDataSource,
+						Cert:         &certDataSource,
+						CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "TLS_RSA_WITH_UNKNOWN"},
+					},
+				},
+				expectedErr:        errors.New("error setting up TLS listener: unknown TLS cipher suite \"TLS_RSA_WITH_UNKNOWN\""),
+				expectHTTPListener: false,
+				expectTLSListener:  false,
+			}),
+			Entry("with a TLS 1.3 cipher suite", &newServerTableInput{
+				opts: Opts{
+					Handler:           handler,
+					SecureBindAddress: "127.0.0.1:0",
+					TLS: &options.TLS{
+						Key:          &keyDataSource,
+						Cert:         &certDataSource,
+						CipherSuites: []string{"TLS_AES_128_GCM_SHA256"},
+					},
+				},
+				expectedErr:        errors.New("error setting up TLS listener: TLS cipher suite \"TLS_AES_128_GCM_SHA256\" can't be configured: only TLS 1.2 cipher suites may be set"),
+				expectHTTPListener: false,
+				expectTLSListener:  false,
+			}),
 			Entry("when the bind address is prefixed with the http scheme", &newServerTableInput{
 				opts: Opts{
 					Handler:     handler,
@@ -368,6 +430,75 @@ var _ = Describe("Server", func() {
 			})
 		})
 
+		Context("with TLS version and cipher suite options", func() {
+			// handshake connects to a server with the TLS options and returns the
+			// negotiated connection state
+			handshake := func(serverTLS *options.TLS, clientConfig *tls.Config) (tls.ConnectionState, error) {
+				srv, err := NewServer(Opts{
+					Handler:           handler,
+					SecureBindAddress: "127.0.0.1:0",
+					TLS:               serverTLS,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+
+				clientConfig.InsecureSkipVerify = true
+				conn, err := tls.Dial("tcp", srv.(*server).tlsListener.Addr().String(), clientConfig)
+				if err != nil {
+					return tls.ConnectionState{}, err
+				}
+				defer conn.Close()
+				return conn.ConnectionState(), nil
+			}
+
+			It("rejects TLS 1.1 by default", func() {
+				_, err := handshake(&options.TLS{Key: &keyDataSource, Cert: &certDataSource}, &tls.Config{
+					MinVersion: tls.VersionTLS10,
+					MaxVersion: tls.VersionTLS11,
+				})
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("rejects weak cipher suites by default", func() {
+				_, err := handshake(&options.TLS{Key: &keyDataSource, Cert: &certDataSource}, &tls.Config{
+					MaxVersion:   tls.VersionTLS12,
+					CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+				})
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("rejects TLS 1.2 with a min version of TLS1.3", func() {
+				tlsOpts := &options.TLS{Key: &keyDataSource, Cert: &certDataSource, MinVersion: "TLS1.3"}
+				_, err := handshake(tlsOpts, &tls.Config{MaxVersion: tls.VersionTLS12})
+				Expect(err).To(HaveOccurred())
+
+				state, err := handshake(tlsOpts, &tls.Config{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(state.Version).To(Equal(uint16(tls.VersionTLS13)))
+			})
+
+			It("only accepts the configured cipher suites", func() {
+				tlsOpts := &options.TLS{
+					Key:          &keyDataSource,
+					Cert:         &certDataSource,
+					CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+				}
+				_, err := handshake(tlsOpts, &tls.Config{
+					MaxVersion:   tls.VersionTLS12,
+					CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				})
+				Expect(err).To(HaveOccurred())
+
+				state, err := handshake(tlsOpts, &tls.Config{MaxVersion: tls.VersionTLS12})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(state.CipherSuite).To(Equal(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384))
+			})
+		})
+
 		Context("with both an http and an https server", func() {
 			var listenAddr, secureListenAddr string
 
@@ -444,6 +575,158 @@ var _ = Describe("Server", func() {
 				}).Should(HaveOccurred())
 			})
 		})
+
+		Context("with a shutdown timeout", func() {
+			var listenAddr string
+			var started, release chan struct{}
+			var done chan struct{}
+
+			newShutdownServer := func(handler http.Handler, timeout time.Duration, policy string) {
+				var err error
+				srv, err = NewServer(Opts{
+					Handler:                 handler,
+					BindAddress:             "127.0.0.1:0",
+					ShutdownTimeout:         timeout,
+					ShutdownWebSocketPolicy: policy,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				s, ok := srv.(*server)
+				Expect(ok).To(BeTrue())
+				listenAddr = s.listener.Addr().String()
+
+				done = make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+					Expect(srv.Start(ctx)).To(Succeed())
+				}()
+			}
+
+			BeforeEach(func() {
+				started = make(chan struct{})
+				release = make(chan struct{})
+			})
+
+			AfterEach(func() {
+				close(release)
+			})
+
+			blockingHandler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				close(started)
+				select {
+				case <-release:
+				case <-req.Context().Done():
+				}
+				rw.Write([]byte(hello))
+			})
+
+			hijackingHandler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				conn, _, err := rw.(http.Hijacker).Hijack()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte(hello + "\n"))
+				// Hold the connection open until the client closes it
+				io.Copy(ioutil.Discard, conn)
+			})
+
+			dialHijacked := func() net.Conn {
+				conn, err := net.Dial("tcp", listenAddr)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+				Expect(err).ToNot(HaveOccurred())
+
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				Expect(err).ToNot(HaveOccurred())
+				Expect(line).To(Equal(hello + "\n"))
+				return conn
+			}
+
+			It("Completes in-flight requests before stopping", func() {
+				newShutdownServer(blockingHandler, time.Minute, options.ShutdownWebSocketDrain)
+
+				type result struct {
+					body string
+					err  error
+				}
+				results := make(chan result, 1)
+				go func() {
+					resp, err := client.Get(fmt.Sprintf("http://%s/", listenAddr))
+					if err != nil {
+						results <- result{err: err}
+						return
+					}
+					defer resp.Body.Close()
+					body, err := ioutil.ReadAll(resp.Body)
+					results <- result{body: string(body), err: err}
+				}()
+				Eventually(started).Should(BeClosed())
+
+				cancel()
+				Consistently(done, 200*time.Millisecond).ShouldNot(BeClosed())
+
+				release <- struct{}{}
+				var res result
+				Eventually(results).Should(Receive(&res))
+				Expect(res.err).ToNot(HaveOccurred())
+				Expect(res.body).To(Equal(hello))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("Closes in-flight requests once the timeout expires", func() {
+				newShutdownServer(blockingHandler, 200*time.Millisecond, options.ShutdownWebSocketDrain)
+
+				errs := make(chan error, 1)
+				go func() {
+					resp, err := client.Get(fmt.Sprintf("http://%s/", listenAddr))
+					if err == nil {
+						_, err = ioutil.ReadAll(resp.Body)
+						resp.Body.Close()
+					}
+					errs <- err
+				}()
+				Eventually(started).Should(BeClosed())
+
+				cancel()
+				Eventually(done).Should(BeClosed())
+				Eventually(errs).Should(Receive(HaveOccurred()))
+			})
+
+			It("Drains hijacked connections with the drain policy", func() {
+				newShutdownServer(hijackingHandler, time.Minute, options.ShutdownWebSocketDrain)
+				conn := dialHijacked()
+
+				cancel()
+				Consistently(done, 200*time.Millisecond).ShouldNot(BeClosed())
+
+				Expect(conn.Close()).To(Succeed())
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("Closes hijacked connections once the timeout expires", func() {
+				newShutdownServer(hijackingHandler, 200*time.Millisecond, options.ShutdownWebSocketDrain)
+				conn := dialHijacked()
+				defer conn.Close()
+
+				cancel()
+				Eventually(done).Should(BeClosed())
+				_, err := conn.Read(make([]byte, 1))
+				Expect(err).To(Equal(io.EOF))
+			})
+
+			It("Closes hijacked connections immediately with the close policy", func() {
+				newShutdownServer(hijackingHandler, time.Minute, options.ShutdownWebSocketClose)
+				conn := dialHijacked()
+				defer conn.Close()
+
+				cancel()
+				Eventually(done).Should(BeClosed())
+				_, err := conn.Read(make([]byte, 1))
+				Expect(err).To(Equal(io.EOF))
+			})
+		})
 	})
 
 	Context("getNetworkScheme", func() {
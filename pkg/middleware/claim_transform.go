@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// ClaimTransformer transforms the claims of sessions into the formats that
+// upstreams expect, eg. extracting the CN of groups given as DNs.
+type ClaimTransformer struct {
+	claims []claimTransformer
+}
+
+// claimTransformer applies the transformations of a single claim, in order
+type claimTransformer struct {
+	claim           string
+	transformations []transformation
+}
+
+// transformation transforms the values of a claim
+type transformation func(values []string) ([]string, error)
+
+// NewClaimTransformer compiles the claim transformations.
+// If there are no transformations, nil is returned.
+func NewClaimTransformer(claimTransformations []options.ClaimTransformation) (*ClaimTransformer, error) {
+	if len(claimTransformations) == 0 {
+		return nil, nil
+	}
+
+	claims := make([]claimTransformer, 0, len(claimTransformations))
+	for _, ct := range claimTransformations {
+		transformations := make([]transformation, 0, len(ct.Transformations))
+		for i, t := range ct.Transformations {
+			transformation, err := newTransformation(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transformation %d of claim %q: %v", i, ct.Claim, err)
+			}
+			transformations = append(transformations, transformation)
+		}
+		claims = append(claims, claimTransformer{
+			claim:           ct.Claim,
+			transformations: transformations,
+		})
+	}
+	return &ClaimTransformer{claims: claims}, nil
+}
+
+func newTransformation(t options.Transformation) (transformation, error) {
+	switch t.Type {
+	case options.ClaimTransformLowercase:
+		return mapEach(func(value string) (string, error) {
+			return strings.ToLower(value), nil
+		}), nil
+	case options.ClaimTransformRegex:
+		return newRegexTransformation(t)
+	case options.ClaimTransformSplit:
+		return func(values []string) ([]string, error) {
+			split := []string{}
+			for _, value := range values {
+				split = append(split, strings.Split(value, t.Separator)...)
+			}
+			return split, nil
+		}, nil
+	case options.ClaimTransformJoin:
+		return func(values []string) ([]string, error) {
+			return []string{strings.Join(values, t.Separator)}, nil
+		}, nil
+	case options.ClaimTransformMap:
+		return mapEach(func(value string) (string, error) {
+			if mapped, ok := t.Values[value]; ok {
+				return mapped, nil
+			}
+			return value, nil
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", t.Type)
+	}
+}
+
+func newRegexTransformation(t options.Transformation) (transformation, error) {
+	pattern, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	group := 1
+	if t.Group != nil {
+		group = *t.Group
+	}
+	if group < 0 || group > pattern.NumSubexp() {
+		return nil, fmt.Errorf("pattern %q has no group %d", t.Pattern, group)
+	}
+
+	return mapEach(func(value string) (string, error) {
+		matches := pattern.FindStringSubmatch(value)
+		if matches == nil {
+			return "", fmt.Errorf("value %q does not match pattern %q", value, t.Pattern)
+		}
+		return matches[group], nil
+	}), nil
+}
+
+// mapEach creates a transformation that transforms each value on its own
+func mapEach(transform func(string) (string, error)) transformation {
+	return func(values []string) ([]string, error) {
+		transformed := make([]string, 0, len(values))
+		for _, value := range values {
+			v, err := transform(value)
+			if err != nil {
+				return nil, err
+			}
+			transformed = append(transformed, v)
+		}
+		return transformed, nil
+	}
+}
+
+// Transform transforms the claims of the session.
+// If any transformation of a claim fails, the error is logged and the claim
+// keeps its raw value.
+func (t *ClaimTransformer) Transform(session *sessionsapi.SessionState) {
+	for _, ct := range t.claims {
+		if err := ct.transform(session); err != nil {
+			logger.Errorf("Error transforming claim %q, using the raw value: %v", ct.claim, err)
+		}
+	}
+}
+
+// RefreshSession wraps the function that refreshes sessions with the
+// provider so that the claims of refreshed sessions are transformed.
+// Only claims that were changed by the refresh are transformed, so that the
+// transformations are not applied again to claims that were already
+// transformed.
+func (t *ClaimTransformer) RefreshSession(refresh func(context.Context, *sessionsapi.SessionState) (bool, error)) func(context.Context, *sessionsapi.SessionState) (bool, error) {
+	return func(ctx context.Context, session *sessionsapi.SessionState) (bool, error) {
+		previous := make([][]string, 0, len(t.claims))
+		for _, ct := range t.claims {
+			previous = append(previous, session.GetClaim(ct.claim))
+		}
+
+		refreshed, err := refresh(ctx, session)
+		if err != nil || !refreshed {
+			return refreshed, err
+		}
+
+		for i, ct := range t.claims {
+			if equalValues(previous[i], session.GetClaim(ct.claim)) {
+				continue
+			}
+			if err := ct.transform(session); err != nil {
+				logger.Errorf("Error transforming claim %q, using the raw value: %v", ct.claim, err)
+			}
+		}
+		return refreshed, nil
+	}
+}
+
+// transform applies the transformations to the claim, leaving the claim
+// unchanged if any of them fails
+func (ct claimTransformer) transform(session *sessionsapi.SessionState) error {
+	values := session.GetClaim(ct.claim)
+	// Claims that the session doesn't have are left empty
+	if len(values) == 0 || (ct.claim != "groups" && values[0] == "") {
+		return nil
+	}
+
+	for _, transformation := range ct.transformations {
+		var err error
+		values, err = transformation(values)
+		if err != nil {
+			return err
+		}
+	}
+	if ct.claim != "groups" && len(values) != 1 {
+		return fmt.Errorf("transformed into %d values, the claim must have a single value", len(values))
+	}
+	return setClaim(session, ct.claim, values)
+}
+
+func setClaim(session *sessionsapi.SessionState, claim string, values []string) error {
+	switch claim {
+	case "user":
+		session.User = values[0]
+	case "email":
+		session.Email = values[0]
+	case "groups":
+		session.Groups = values
+	case "preferred_username":
+		session.PreferredUsername = values[0]
+	default:
+		return errors.New("claim cannot be transformed")
+	}
+	return nil
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
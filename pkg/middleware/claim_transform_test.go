@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claim Transformer Suite", func() {
+	groupCN := options.Transformation{Type: options.ClaimTransformRegex, Pattern: `^CN=([^,]+)`}
+	lowercase := options.Transformation{Type: options.ClaimTransformLowercase}
+
+	newSession := func() *sessionsapi.SessionState {
+		return &sessionsapi.SessionState{
+			User:   "Jane",
+			Email:  "Jane.Doe@Example.com",
+			Groups: []string{"CN=Admins,OU=Groups,DC=example,DC=com", "CN=Users,OU=Groups,DC=example,DC=com"},
+		}
+	}
+
+	It("is disabled when there are no transformations", func() {
+		transformer, err := NewClaimTransformer(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(transformer).To(BeNil())
+	})
+
+	It("returns an error for an invalid transformation", func() {
+		group := 2
+		_, err := NewClaimTransformer([]options.ClaimTransformation{
+			{Claim: "groups", Transformations: []options.Transformation{
+				lowercase,
+				{Type: options.ClaimTransformRegex, Pattern: `^CN=([^,]+)`, Group: &group},
+			}},
+		})
+		Expect(err).To(MatchError("invalid transformation 1 of claim \"groups\": pattern \"^CN=([^,]+)\" has no group 2"))
+	})
+
+	type transformTableInput struct {
+		transformations []options.ClaimTransformation
+		session         *sessionsapi.SessionState
+		expectedSession *sessionsapi.SessionState
+	}
+
+	DescribeTable("Transform",
+		func(in transformTableInput) {
+			transformer, err := NewClaimTransformer(in.transformations)
+			Expect(err).ToNot(HaveOccurred())
+
+			session := newSession()
+			if in.session != nil {
+				session = in.session
+			}
+			transformer.Transform(session)
+			Expect(session).To(Equal(in.expectedSession))
+		},
+		Entry("lowercases the email", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "email", Transformations: []options.Transformation{lowercase}},
+			},
+			expectedSession: &sessionsapi.SessionState{
+				User:   "Jane",
+				Email:  "jane.doe@example.com",
+				Groups: []string{"CN=Admins,OU=Groups,DC=example,DC=com", "CN=Users,OU=Groups,DC=example,DC=com"},
+			},
+		}),
+		Entry("extracts the CN of the groups and maps them", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "groups", Transformations: []options.Transformation{
+					groupCN,
+					{Type: options.ClaimTransformMap, Values: map[string]string{"Admins": "admin"}},
+				}},
+			},
+			expectedSession: &sessionsapi.SessionState{
+				User:   "Jane",
+				Email:  "Jane.Doe@Example.com",
+				Groups: []string{"admin", "Users"},
+			},
+		}),
+		Entry("splits and joins values", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "groups", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformSplit, Separator: ";"},
+				}},
+				{Claim: "user", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformSplit, Separator: "\\"},
+					{Type: options.ClaimTransformJoin, Separator: "/"},
+				}},
+			},
+			session: &sessionsapi.SessionState{
+				User:   "DOMAIN\\jane",
+				Groups: []string{"admin;users", "ops"},
+			},
+			expectedSession: &sessionsapi.SessionState{
+				User:   "DOMAIN/jane",
+				Groups: []string{"admin", "users", "ops"},
+			},
+		}),
+		Entry("keeps the raw value of a claim when a value does not match", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "groups", Transformations: []options.Transformation{lowercase, groupCN}},
+				{Claim: "email", Transformations: []options.Transformation{lowercase}},
+			},
+			session: &sessionsapi.SessionState{
+				Email:  "Jane.Doe@Example.com",
+				Groups: []string{"CN=Admins,OU=Groups,DC=example,DC=com", "Users"},
+			},
+			expectedSession: &sessionsapi.SessionState{
+				Email:  "jane.doe@example.com",
+				Groups: []string{"CN=Admins,OU=Groups,DC=example,DC=com", "Users"},
+			},
+		}),
+		Entry("keeps the raw value of a single valued claim that is split", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "email", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformSplit, Separator: "@"},
+				}},
+			},
+			expectedSession: newSession(),
+		}),
+		Entry("leaves claims the session doesn't have empty", transformTableInput{
+			transformations: []options.ClaimTransformation{
+				{Claim: "preferred_username", Transformations: []options.Transformation{groupCN}},
+				{Claim: "groups", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformJoin, Separator: ","},
+				}},
+			},
+			session:         &sessionsapi.SessionState{Email: "jane@example.com"},
+			expectedSession: &sessionsapi.SessionState{Email: "jane@example.com"},
+		}),
+	)
+
+	Context("RefreshSession", func() {
+		var transformer *ClaimTransformer
+
+		BeforeEach(func() {
+			var err error
+			transformer, err = NewClaimTransformer([]options.ClaimTransformation{
+				{Claim: "email", Transformations: []options.Transformation{lowercase}},
+				{Claim: "groups", Transformations: []options.Transformation{
+					groupCN,
+					{Type: options.ClaimTransformMap, Values: map[string]string{"Admins": "CN=Admins"}},
+				}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("transforms the claims that were reset by the refresh", func() {
+			session := newSession()
+			transformer.Transform(session)
+			Expect(session.Groups).To(Equal([]string{"CN=Admins", "Users"}))
+
+			refresh := transformer.RefreshSession(func(_ context.Context, s *sessionsapi.SessionState) (bool, error) {
+				s.Email = "JANE@example.com"
+				s.Groups = []string{"CN=Admins,OU=Groups,DC=example,DC=com"}
+				return true, nil
+			})
+			refreshed, err := refresh(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refreshed).To(BeTrue())
+			Expect(session.Email).To(Equal("jane@example.com"))
+			Expect(session.Groups).To(Equal([]string{"CN=Admins"}))
+		})
+
+		It("does not transform the claims again when the refresh keeps them", func() {
+			session := newSession()
+			transformer.Transform(session)
+
+			refresh := transformer.RefreshSession(func(_ context.Context, s *sessionsapi.SessionState) (bool, error) {
+				s.AccessToken = "refreshed"
+				return true, nil
+			})
+			_, err := refresh(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Groups).To(Equal([]string{"CN=Admins", "Users"}))
+		})
+
+		It("does not transform sessions that were not refreshed", func() {
+			session := newSession()
+			refreshErr := errors.New("refresh failed")
+
+			refresh := transformer.RefreshSession(func(context.Context, *sessionsapi.SessionState) (bool, error) {
+				return false, refreshErr
+			})
+			refreshed, err := refresh(context.Background(), session)
+			Expect(err).To(Equal(refreshErr))
+			Expect(refreshed).To(BeFalse())
+			Expect(session).To(Equal(newSession()))
+		})
+	})
+})
@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const gzipEncoding = "gzip"
+
+// eventStreamContentType is never compressed, even when it matches a
+// configured wildcard type, as server-sent events must reach the client as
+// soon as each one is written.
+const eventStreamContentType = "text/event-stream"
+
+// NewCompression creates a new compression middleware that will gzip
+// responses for clients that accept gzip encoding.
+func NewCompression(opts options.Compression) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return compressResponses(opts, next)
+	}
+}
+
+// compressResponses is an HTTP middleware that compresses responses whose
+// type matches one of the configured content types, once the body reaches
+// the minimum size.
+// Responses that already have a Content-Encoding are never compressed.
+func compressResponses(opts options.Compression, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Upgraded connections (eg. WebSockets) must be hijacked from the
+		// original ResponseWriter, and HEAD responses have no body to compress
+		if req.Header.Get("Upgrade") != "" || req.Method == http.MethodHead {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		w := &compressionWriter{
+			ResponseWriter: rw,
+			opts:           opts,
+			acceptsGzip:    acceptsEncoding(req.Header.Values("Accept-Encoding"), gzipEncoding),
+		}
+		defer w.close()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// compressionWriter buffers the start of a response until it knows whether
+// the response is large enough to be worth compressing.
+type compressionWriter struct {
+	http.ResponseWriter
+	opts        options.Compression
+	acceptsGzip bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+// WriteHeader determines whether the response could be compressed.
+// Responses that cannot be compressed are written immediately, others are
+// held until the minimum size is reached.
+func (w *compressionWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	if !w.compressible() || !w.acceptsGzip {
+		w.writeUncompressed()
+		return
+	}
+
+	if length, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		if length < w.opts.MinSize {
+			w.writeUncompressed()
+		} else if err := w.writeCompressed(); err != nil {
+			logger.Errorf("Error compressing response: %v", err)
+		}
+	}
+}
+
+// Write writes the data to the gzip writer once compression has been decided
+// on, otherwise the data is buffered until the minimum size is reached.
+func (w *compressionWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.opts.MinSize {
+		if err := w.writeCompressed(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush compresses any buffered data as the response is being streamed.
+// Implements the `http.Flusher` interface.
+func (w *compressionWriter) Flush() {
+	if w.wroteHeader && !w.decided {
+		if err := w.writeCompressed(); err != nil {
+			logger.Errorf("Error compressing response: %v", err)
+			return
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			logger.Errorf("Error compressing response: %v", err)
+			return
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close writes any buffered data that did not reach the minimum size and
+// completes the compressed response.
+func (w *compressionWriter) close() {
+	if w.wroteHeader && !w.decided {
+		w.writeUncompressed()
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			logger.Errorf("Error compressing response: %v", err)
+		}
+	}
+}
+
+// compressible determines whether the response may be compressed, based on
+// its status, content type and any existing encoding.
+// Compressible responses vary based on the Accept-Encoding of the request.
+func (w *compressionWriter) compressible() bool {
+	if w.status < http.StatusOK ||
+		w.status == http.StatusNoContent ||
+		w.status == http.StatusPartialContent ||
+		w.status == http.StatusNotModified {
+		return false
+	}
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		// Never compress a response twice
+		return false
+	}
+	if !matchesContentType(w.Header().Get("Content-Type"), w.opts.ContentTypes) {
+		return false
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	return true
+}
+
+func (w *compressionWriter) writeUncompressed() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		if _, err := w.ResponseWriter.Write(w.buf); err != nil {
+			logger.Errorf("Error writing response: %v", err)
+		}
+		w.buf = nil
+	}
+}
+
+func (w *compressionWriter) writeCompressed() error {
+	w.decided = true
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", gzipEncoding)
+	if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed body is no longer byte for byte identical
+		w.Header().Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if len(w.buf) > 0 {
+		if _, err := w.gz.Write(w.buf); err != nil {
+			return err
+		}
+		w.buf = nil
+	}
+	return nil
+}
+
+// acceptsEncoding determines whether the Accept-Encoding header values allow
+// the given encoding, either explicitly or through a wildcard.
+func acceptsEncoding(acceptEncoding []string, encoding string) bool {
+	wildcard := false
+	for _, value := range acceptEncoding {
+		for _, part := range strings.Split(value, ",") {
+			name, quality := parseEncoding(part)
+			switch name {
+			case encoding:
+				// An explicit quality overrides any wildcard
+				return quality > 0
+			case "*":
+				wildcard = quality > 0
+			}
+		}
+	}
+	return wildcard
+}
+
+// parseEncoding splits an Accept-Encoding entry (eg. "gzip;q=0.5") into the
+// encoding and its quality value.
+func parseEncoding(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	name := strings.ToLower(strings.TrimSpace(params[0]))
+	quality := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil {
+				return name, 0
+			}
+			quality = q
+		}
+	}
+	return name, quality
+}
+
+// matchesContentType determines whether the Content-Type matches one of the
+// media types, which may use a wildcard subtype (eg. "text/*").
+// Event streams never match.
+func matchesContentType(contentType string, mediaTypes []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == eventStreamContentType {
+		return false
+	}
+	for _, allowed := range mediaTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression Suite", func() {
+	opts := options.Compression{
+		Enabled:      true,
+		MinSize:      100,
+		ContentTypes: []string{"text/*", "application/json"},
+	}
+	largeBody := strings.Repeat("compress me ", 20)
+	smallBody := "too small"
+
+	type compressionTableInput struct {
+		method          string
+		acceptEncoding  string
+		responseHeaders map[string]string
+		status          int
+		body            string
+		setLength       bool
+		expectedGzip    bool
+		expectedVary    bool
+	}
+
+	DescribeTable("when serving a response",
+		func(in compressionTableInput) {
+			req := httptest.NewRequest(in.method, "/", nil)
+			if in.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", in.acceptEncoding)
+			}
+			rw := httptest.NewRecorder()
+
+			handler := NewCompression(opts)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				for key, value := range in.responseHeaders {
+					rw.Header().Set(key, value)
+				}
+				if in.setLength {
+					rw.Header().Set("Content-Length", strconv.Itoa(len(in.body)))
+				}
+				rw.WriteHeader(in.status)
+				// Write in parts to check buffering up to the minimum size
+				half := len(in.body) / 2
+				rw.Write([]byte(in.body[:half]))
+				rw.Write([]byte(in.body[half:]))
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.status))
+			if in.expectedVary {
+				Expect(rw.Header().Values("Vary")).To(ContainElement("Accept-Encoding"))
+			} else {
+				Expect(rw.Header().Values("Vary")).To(BeEmpty())
+			}
+
+			if !in.expectedGzip {
+				Expect(rw.Header().Get("Content-Encoding")).To(Equal(in.responseHeaders["Content-Encoding"]))
+				Expect(rw.Body.String()).To(Equal(in.body))
+				return
+			}
+
+			Expect(rw.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(rw.Header().Get("Content-Length")).To(BeEmpty())
+			reader, err := gzip.NewReader(rw.Body)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := ioutil.ReadAll(reader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(in.body))
+		},
+		Entry("with a large text response", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip, deflate",
+			responseHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    true,
+			expectedVary:    true,
+		}),
+		Entry("with a large response with a Content-Length", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "application/json"},
+			status:          200,
+			body:            largeBody,
+			setLength:       true,
+			expectedGzip:    true,
+			expectedVary:    true,
+		}),
+		Entry("with a small response", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			body:            smallBody,
+			expectedGzip:    false,
+			expectedVary:    true,
+		}),
+		Entry("with a small response with a Content-Length", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			body:            smallBody,
+			setLength:       true,
+			expectedGzip:    false,
+			expectedVary:    true,
+		}),
+		Entry("with a content type that is not allowed", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "image/png"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    false,
+			expectedVary:    false,
+		}),
+		Entry("with an event stream matching a wildcard type", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "text/event-stream"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    false,
+			expectedVary:    false,
+		}),
+		Entry("with a response that is already compressed", compressionTableInput{
+			method:         "GET",
+			acceptEncoding: "gzip",
+			responseHeaders: map[string]string{
+				"Content-Type":     "text/plain",
+				"Content-Encoding": "br",
+			},
+			status:       200,
+			body:         largeBody,
+			expectedGzip: false,
+			expectedVary: false,
+		}),
+		Entry("when the client does not accept gzip", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "br",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    false,
+			expectedVary:    true,
+		}),
+		Entry("when the client refuses gzip", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "*, gzip;q=0",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    false,
+			expectedVary:    true,
+		}),
+		Entry("when the client accepts any encoding", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "*",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			body:            largeBody,
+			expectedGzip:    true,
+			expectedVary:    true,
+		}),
+		Entry("with a partial content response", compressionTableInput{
+			method:          "GET",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          206,
+			body:            largeBody,
+			expectedGzip:    false,
+			expectedVary:    false,
+		}),
+		Entry("with a HEAD request", compressionTableInput{
+			method:          "HEAD",
+			acceptEncoding:  "gzip",
+			responseHeaders: map[string]string{"Content-Type": "text/plain"},
+			status:          200,
+			expectedGzip:    false,
+			expectedVary:    false,
+		}),
+	)
+
+	It("weakens strong ETags when compressing", func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rw := httptest.NewRecorder()
+
+		NewCompression(opts)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("ETag", `"abc"`)
+			rw.Write([]byte(largeBody))
+		})).ServeHTTP(rw, req)
+
+		Expect(rw.Header().Get("ETag")).To(Equal(`W/"abc"`))
+	})
+
+	It("compresses buffered data when the response is flushed", func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rw := httptest.NewRecorder()
+
+		var flushed []byte
+		NewCompression(opts)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(smallBody))
+			w.(http.Flusher).Flush()
+			flushed = append(flushed, rw.Body.Bytes()...)
+		})).ServeHTTP(rw, req)
+
+		Expect(rw.Flushed).To(BeTrue())
+		Expect(rw.Header().Get("Content-Encoding")).To(Equal("gzip"))
+
+		// The flushed data must be decodable before the response completes
+		reader, err := gzip.NewReader(bytes.NewReader(flushed))
+		Expect(err).ToNot(HaveOccurred())
+		body := make([]byte, len(smallBody))
+		_, err = reader.Read(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(smallBody))
+	})
+})
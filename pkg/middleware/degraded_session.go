@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+)
+
+// NewDegradedSessionHeaderInjector creates a middleware that sets the header
+// to "true" on the request to the upstream and on the response when the
+// session is degraded, so that the upstream can tell users that it has
+// limited connectivity with the provider.
+// The header is stripped from all requests, so that it cannot be set by the
+// client.
+func NewDegradedSessionHeaderInjector(header string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			req.Header.Del(header)
+
+			scope := middlewareapi.GetRequestScope(req)
+			// If scope is nil, this will panic.
+			// A scope should always be injected before this handler is called.
+			if scope.Session != nil && scope.SessionDegraded {
+				req.Header.Set(header, "true")
+				rw.Header().Set(header, "true")
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
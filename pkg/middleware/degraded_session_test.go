@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Degraded Session Header Injector Suite", func() {
+	type degradedSessionTableInput struct {
+		session                 *sessionsapi.SessionState
+		degraded                bool
+		requestHeaders          http.Header
+		expectedRequestHeaders  http.Header
+		expectedResponseHeaders http.Header
+	}
+
+	DescribeTable("when serving a request",
+		func(in degradedSessionTableInput) {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header = in.requestHeaders
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				Session:         in.session,
+				SessionDegraded: in.degraded,
+			})
+			rw := httptest.NewRecorder()
+
+			var gotHeaders http.Header
+			handler := NewDegradedSessionHeaderInjector("X-Auth-Degraded")(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotHeaders = r.Header.Clone()
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(gotHeaders).To(Equal(in.expectedRequestHeaders))
+			Expect(rw.Header()).To(Equal(in.expectedResponseHeaders))
+		},
+		Entry("with a session that is not degraded", degradedSessionTableInput{
+			session:                 &sessionsapi.SessionState{User: "john"},
+			requestHeaders:          http.Header{},
+			expectedRequestHeaders:  http.Header{},
+			expectedResponseHeaders: http.Header{},
+		}),
+		Entry("with a degraded session", degradedSessionTableInput{
+			session:        &sessionsapi.SessionState{User: "john"},
+			degraded:       true,
+			requestHeaders: http.Header{},
+			expectedRequestHeaders: http.Header{
+				"X-Auth-Degraded": []string{"true"},
+			},
+			expectedResponseHeaders: http.Header{
+				"X-Auth-Degraded": []string{"true"},
+			},
+		}),
+		Entry("strips the header set by the client", degradedSessionTableInput{
+			session: &sessionsapi.SessionState{User: "john"},
+			requestHeaders: http.Header{
+				"X-Auth-Degraded": []string{"true"},
+				"Foo":             []string{"bar"},
+			},
+			expectedRequestHeaders: http.Header{
+				"Foo": []string{"bar"},
+			},
+			expectedResponseHeaders: http.Header{},
+		}),
+		Entry("without a session", degradedSessionTableInput{
+			degraded: true,
+			requestHeaders: http.Header{
+				"X-Auth-Degraded": []string{"false"},
+			},
+			expectedRequestHeaders:  http.Header{},
+			expectedResponseHeaders: http.Header{},
+		}),
+	)
+})
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// FeatureFlagEvaluator enables feature flags for sessions based on their
+// claims, eg. so that upstreams can read the flags of the user from a header
+// rather than calling a feature flag service on every request.
+type FeatureFlagEvaluator struct {
+	flags []featureFlag
+}
+
+// featureFlag is enabled when any of its conditions match
+type featureFlag struct {
+	name       string
+	conditions []featureFlagCondition
+}
+
+// featureFlagCondition matches the values of a claim
+type featureFlagCondition struct {
+	claim   string
+	values  map[string]struct{}
+	pattern *regexp.Regexp
+}
+
+// NewFeatureFlagEvaluator compiles the feature flags.
+// If there are no feature flags, nil is returned.
+func NewFeatureFlagEvaluator(featureFlags []options.FeatureFlag) (*FeatureFlagEvaluator, error) {
+	if len(featureFlags) == 0 {
+		return nil, nil
+	}
+
+	flags := make([]featureFlag, 0, len(featureFlags))
+	for _, ff := range featureFlags {
+		conditions := make([]featureFlagCondition, 0, len(ff.Conditions))
+		for i, c := range ff.Conditions {
+			condition, err := newFeatureFlagCondition(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition %d of feature flag %q: %v", i, ff.Name, err)
+			}
+			conditions = append(conditions, condition)
+		}
+		flags = append(flags, featureFlag{
+			name:       ff.Name,
+			conditions: conditions,
+		})
+	}
+	return &FeatureFlagEvaluator{flags: flags}, nil
+}
+
+func newFeatureFlagCondition(c options.FeatureFlagCondition) (featureFlagCondition, error) {
+	condition := featureFlagCondition{
+		claim:  c.Claim,
+		values: make(map[string]struct{}, len(c.Values)),
+	}
+	for _, value := range c.Values {
+		condition.values[value] = struct{}{}
+	}
+	if c.Pattern != "" {
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return featureFlagCondition{}, err
+		}
+		condition.pattern = pattern
+	}
+	return condition, nil
+}
+
+// Evaluate sets the feature flags of the session to the flags enabled by its
+// claims, in the order the flags are configured.
+func (e *FeatureFlagEvaluator) Evaluate(session *sessionsapi.SessionState) {
+	enabled := []string{}
+	for _, flag := range e.flags {
+		if flag.matches(session) {
+			enabled = append(enabled, flag.name)
+		}
+	}
+	session.FeatureFlags = enabled
+}
+
+// RefreshSession wraps the function that refreshes sessions with the
+// provider so that the feature flags of refreshed sessions are evaluated
+// again, in case their claims have changed.
+func (e *FeatureFlagEvaluator) RefreshSession(refresh func(context.Context, *sessionsapi.SessionState) (bool, error)) func(context.Context, *sessionsapi.SessionState) (bool, error) {
+	return func(ctx context.Context, session *sessionsapi.SessionState) (bool, error) {
+		refreshed, err := refresh(ctx, session)
+		if err != nil || !refreshed {
+			return refreshed, err
+		}
+		e.Evaluate(session)
+		return refreshed, nil
+	}
+}
+
+func (f featureFlag) matches(session *sessionsapi.SessionState) bool {
+	for _, condition := range f.conditions {
+		if condition.matches(session.GetClaim(condition.claim)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c featureFlagCondition) matches(values []string) bool {
+	for _, value := range values {
+		// Claims that the session doesn't have never match
+		if value == "" {
+			continue
+		}
+		if _, ok := c.values[value]; ok {
+			return true
+		}
+		if c.pattern != nil && c.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
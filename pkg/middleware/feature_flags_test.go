@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feature Flag Evaluator Suite", func() {
+	featureFlags := []options.FeatureFlag{
+		{Name: "beta", Conditions: []options.FeatureFlagCondition{
+			{Claim: "groups", Values: []string{"beta-testers", "admins"}},
+		}},
+		{Name: "new-ui", Conditions: []options.FeatureFlagCondition{
+			{Claim: "email", Pattern: `@example\.com$`},
+			{Claim: "user", Values: []string{"john"}},
+		}},
+		{Name: "reports", Conditions: []options.FeatureFlagCondition{
+			{Claim: "preferred_username", Pattern: `.*`},
+		}},
+	}
+
+	It("is disabled when there are no feature flags", func() {
+		evaluator, err := NewFeatureFlagEvaluator(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(evaluator).To(BeNil())
+	})
+
+	It("returns an error for an invalid pattern", func() {
+		_, err := NewFeatureFlagEvaluator([]options.FeatureFlag{
+			{Name: "beta", Conditions: []options.FeatureFlagCondition{
+				{Claim: "groups", Values: []string{"admins"}},
+				{Claim: "email", Pattern: "(example"},
+			}},
+		})
+		Expect(err).To(MatchError("invalid condition 1 of feature flag \"beta\": error parsing regexp: missing closing ): `(example`"))
+	})
+
+	type evaluateTableInput struct {
+		session       *sessionsapi.SessionState
+		expectedFlags []string
+	}
+
+	DescribeTable("Evaluate",
+		func(in evaluateTableInput) {
+			evaluator, err := NewFeatureFlagEvaluator(featureFlags)
+			Expect(err).ToNot(HaveOccurred())
+
+			evaluator.Evaluate(in.session)
+			Expect(in.session.FeatureFlags).To(Equal(in.expectedFlags))
+		},
+		Entry("enables flags in the order they are configured", evaluateTableInput{
+			session: &sessionsapi.SessionState{
+				Email:             "jane@example.com",
+				Groups:            []string{"users", "admins"},
+				PreferredUsername: "jane",
+			},
+			expectedFlags: []string{"beta", "new-ui", "reports"},
+		}),
+		Entry("enables a flag when any condition matches", evaluateTableInput{
+			session: &sessionsapi.SessionState{
+				User:  "john",
+				Email: "john@example.org",
+			},
+			expectedFlags: []string{"new-ui"},
+		}),
+		Entry("does not match claims the session doesn't have", evaluateTableInput{
+			session:       &sessionsapi.SessionState{Email: "jane@example.org"},
+			expectedFlags: []string{},
+		}),
+		Entry("replaces the flags of the session", evaluateTableInput{
+			session: &sessionsapi.SessionState{
+				Groups:       []string{"beta-testers"},
+				FeatureFlags: []string{"new-ui", "reports"},
+			},
+			expectedFlags: []string{"beta"},
+		}),
+	)
+
+	Context("RefreshSession", func() {
+		var evaluator *FeatureFlagEvaluator
+
+		BeforeEach(func() {
+			var err error
+			evaluator, err = NewFeatureFlagEvaluator(featureFlags)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("evaluates the flags of refreshed sessions again", func() {
+			session := &sessionsapi.SessionState{Groups: []string{"admins"}}
+			evaluator.Evaluate(session)
+			Expect(session.FeatureFlags).To(Equal([]string{"beta"}))
+
+			refresh := evaluator.RefreshSession(func(_ context.Context, s *sessionsapi.SessionState) (bool, error) {
+				s.Email = "jane@example.com"
+				s.Groups = []string{"users"}
+				return true, nil
+			})
+			refreshed, err := refresh(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refreshed).To(BeTrue())
+			Expect(session.FeatureFlags).To(Equal([]string{"new-ui"}))
+		})
+
+		It("does not evaluate sessions that were not refreshed", func() {
+			session := &sessionsapi.SessionState{
+				Groups:       []string{"users"},
+				FeatureFlags: []string{"beta"},
+			}
+			refreshErr := errors.New("refresh failed")
+
+			refresh := evaluator.RefreshSession(func(context.Context, *sessionsapi.SessionState) (bool, error) {
+				return false, refreshErr
+			})
+			refreshed, err := refresh(context.Background(), session)
+			Expect(err).To(Equal(refreshErr))
+			Expect(refreshed).To(BeFalse())
+			Expect(session.FeatureFlags).To(Equal([]string{"beta"}))
+		})
+	})
+})
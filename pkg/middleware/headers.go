@@ -3,16 +3,26 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/justinas/alice"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/header"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
-func NewRequestHeaderInjector(headers []options.Header) (alice.Constructor, error) {
-	headerInjector, err := newRequestHeaderInjector(headers)
+// NewRequestHeaderInjector creates a middleware that injects the headers into
+// requests to the upstream, other than for paths matching any of skipPaths.
+// Headers that do not preserve the request value are stripped from all
+// requests, so that they cannot be set by the client on skipped paths.
+// Injected headers exceeding the maximum size of the headerSize are handled
+// with its policy, rendering an error page with the writer when the request
+// fails.
+func NewRequestHeaderInjector(headers []options.Header, skipPaths []string, headerSize options.HeaderSize, writer pagewriter.Writer) (alice.Constructor, error) {
+	headerInjector, err := newRequestHeaderInjector(headers, skipPaths, headerSize, writer)
 	if err != nil {
 		return nil, fmt.Errorf("error building request header injector: %v", err)
 	}
@@ -59,29 +69,131 @@ func stripHeaders(headers []string, next http.Handler) http.Handler {
 	})
 }
 
-func newRequestHeaderInjector(headers []options.Header) (alice.Constructor, error) {
+func newRequestHeaderInjector(headers []options.Header, skipPaths []string, headerSize options.HeaderSize, writer pagewriter.Writer) (alice.Constructor, error) {
 	injector, err := header.NewInjector(headers)
 	if err != nil {
 		return nil, fmt.Errorf("error building request injector: %v", err)
 	}
 
+	skipRegexes := make([]*regexp.Regexp, 0, len(skipPaths))
+	for _, path := range skipPaths {
+		compiledRegex, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling skip path regex /%s/: %v", path, err)
+		}
+		skipRegexes = append(skipRegexes, compiledRegex)
+	}
+
+	limiter := newHeaderSizeLimiter(headers, headerSize)
+
 	return func(next http.Handler) http.Handler {
-		return injectRequestHeaders(injector, next)
+		return injectRequestHeaders(injector, skipRegexes, limiter, writer, next)
 	}, nil
 }
 
-func injectRequestHeaders(injector header.Injector, next http.Handler) http.Handler {
+func injectRequestHeaders(injector header.Injector, skipPaths []*regexp.Regexp, limiter *headerSizeLimiter, writer pagewriter.Writer, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		scope := middlewareapi.GetRequestScope(req)
-
-		// If scope is nil, this will panic.
-		// A scope should always be injected before this handler is called.
-		injector.Inject(req.Header, scope.Session)
+		if !matchesAnyPath(skipPaths, req.URL.Path) {
+			// If scope is nil, this will panic.
+			// A scope should always be injected before this handler is called.
+			injector.Inject(req.Header, scope.Session)
+		}
 		flattenHeaders(req.Header)
+		if err := limiter.limit(req); err != nil {
+			logger.Errorf("Error injecting request headers: %v", err)
+			writer.WriteErrorPage(rw, pagewriter.ErrorPageOpts{
+				Status:    http.StatusInternalServerError,
+				RequestID: scope.RequestID,
+				AppError:  err.Error(),
+			})
+			return
+		}
 		next.ServeHTTP(rw, req)
 	})
 }
 
+// headerSizeLimiter handles injected request headers that exceed the
+// maximum size with the configured policy
+type headerSizeLimiter struct {
+	names   []string
+	maxSize int
+	policy  string
+}
+
+// newHeaderSizeLimiter creates a headerSizeLimiter for the headers, or nil
+// when their size isn't limited
+func newHeaderSizeLimiter(headers []options.Header, headerSize options.HeaderSize) *headerSizeLimiter {
+	if headerSize.MaxSize <= 0 || len(headers) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		names = append(names, header.Name)
+	}
+	return &headerSizeLimiter{
+		names:   names,
+		maxSize: headerSize.MaxSize,
+		policy:  headerSize.Policy,
+	}
+}
+
+// limit applies the policy to each of the flattened injected headers of the
+// request exceeding the maximum size. It returns an error naming the header
+// when the request must fail rather than being proxied to the upstream.
+func (l *headerSizeLimiter) limit(req *http.Request) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, name := range l.names {
+		value := req.Header.Get(name)
+		if len(value) <= l.maxSize {
+			continue
+		}
+
+		switch l.policy {
+		case options.HeaderSizeTruncate:
+			truncated := truncateHeaderValue(value, l.maxSize)
+			if truncated == "" {
+				req.Header.Del(name)
+			} else {
+				req.Header.Set(name, truncated)
+			}
+			logger.Printf("Truncated injected header %s from %d to %d bytes, exceeding the maximum size of %d bytes", name, len(value), len(truncated), l.maxSize)
+		case options.HeaderSizeDrop:
+			req.Header.Del(name)
+			logger.Printf("Dropped injected header %s of %d bytes, exceeding the maximum size of %d bytes", name, len(value), l.maxSize)
+		case options.HeaderSizeFail:
+			return fmt.Errorf("injected header %s of %d bytes exceeds the maximum size of %d bytes", name, len(value), l.maxSize)
+		default:
+			logger.Printf("WARNING: injected header %s of %d bytes exceeds the maximum size of %d bytes", name, len(value), l.maxSize)
+		}
+	}
+	return nil
+}
+
+// truncateHeaderValue removes the values from the end of the comma separated
+// header value until it fits within the maxSize. Values are never cut, so an
+// empty string is returned when the first value doesn't fit.
+func truncateHeaderValue(value string, maxSize int) string {
+	truncated := value[:maxSize+1]
+	if i := strings.LastIndex(truncated, ","); i >= 0 {
+		return truncated[:i]
+	}
+	return ""
+}
+
+func matchesAnyPath(regexes []*regexp.Regexp, path string) bool {
+	for _, regex := range regexes {
+		if regex.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
 func NewResponseHeaderInjector(headers []options.Header) (alice.Constructor, error) {
 	headerInjector, err := newResponseHeaderInjector(headers)
 	if err != nil {
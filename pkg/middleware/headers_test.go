@@ -8,14 +8,25 @@ import (
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Headers Suite", func() {
+	writer := &pagewriter.WriterFuncs{
+		ErrorPageFunc: func(rw http.ResponseWriter, opts pagewriter.ErrorPageOpts) {
+			rw.WriteHeader(opts.Status)
+			_, _ = rw.Write([]byte(opts.RequestID + ": " + opts.AppError))
+		},
+	}
+
 	type headersTableInput struct {
 		headers         []options.Header
+		skipPaths       []string
+		headerSize      options.HeaderSize
+		path            string
 		initialHeaders  http.Header
 		session         *sessionsapi.SessionState
 		expectedHeaders http.Header
@@ -28,8 +39,13 @@ var _ = Describe("Headers Suite", func() {
 				Session: in.session,
 			}
 
+			path := in.path
+			if path == "" {
+				path = "/"
+			}
+
 			// Set up the request with a request scope
-			req := httptest.NewRequest("", "/", nil)
+			req := httptest.NewRequest("", path, nil)
 			req = middlewareapi.AddRequestScope(req, scope)
 			req.Header = in.initialHeaders.Clone()
 
@@ -38,7 +54,7 @@ var _ = Describe("Headers Suite", func() {
 			// Create the handler with a next handler that will capture the headers
 			// from the request
 			var gotHeaders http.Header
-			injector, err := NewRequestHeaderInjector(in.headers)
+			injector, err := NewRequestHeaderInjector(in.headers, in.skipPaths, in.headerSize, writer)
 			if in.expectedErr != "" {
 				Expect(err).To(MatchError(in.expectedErr))
 				return
@@ -88,6 +104,28 @@ var _ = Describe("Headers Suite", func() {
 			},
 			expectedErr: "",
 		}),
+		Entry("with a feature flags claim valued header", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "X-Feature-Flags",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "feature_flags",
+							},
+						},
+					},
+				},
+			},
+			initialHeaders: http.Header{},
+			session: &sessionsapi.SessionState{
+				FeatureFlags: []string{"beta", "new-ui"},
+			},
+			expectedHeaders: http.Header{
+				"X-Feature-Flags": []string{"beta,new-ui"},
+			},
+			expectedErr: "",
+		}),
 		Entry("with a claim valued header (without preservation)", headersTableInput{
 			headers: []options.Header{
 				{
@@ -180,6 +218,22 @@ var _ = Describe("Headers Suite", func() {
 			},
 			expectedErr: "",
 		}),
+		Entry("with a header that has no values", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "Authorization",
+				},
+			},
+			initialHeaders: http.Header{
+				"Foo":           []string{"bar", "baz"},
+				"Authorization": []string{"Bearer client-token"},
+			},
+			session: &sessionsapi.SessionState{},
+			expectedHeaders: http.Header{
+				"Foo": []string{"bar,baz"},
+			},
+			expectedErr: "",
+		}),
 		Entry("with an invalid basicAuthPassword claim valued header", headersTableInput{
 			headers: []options.Header{
 				{
@@ -206,6 +260,178 @@ var _ = Describe("Headers Suite", func() {
 			expectedHeaders: nil,
 			expectedErr:     "error building request header injector: error building request injector: error building injector for header \"X-Auth-Request-Authorization\": error loading basicAuthPassword: secret source is invalid: exactly one entry required, specify either value, fromEnv or fromFile",
 		}),
+		Entry("with a skipped path", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "Claim",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "id_token",
+							},
+						},
+					},
+				},
+			},
+			skipPaths: []string{"^/logs/"},
+			path:      "/logs/request",
+			initialHeaders: http.Header{
+				"Claim": []string{"bar", "baz"},
+				"Foo":   []string{"bar", "baz"},
+			},
+			session: &sessionsapi.SessionState{
+				IDToken: "IDToken-1234",
+			},
+			expectedHeaders: http.Header{
+				"Foo": []string{"bar,baz"},
+			},
+			expectedErr: "",
+		}),
+		Entry("with a path that is not skipped", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "Claim",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "id_token",
+							},
+						},
+					},
+				},
+			},
+			skipPaths: []string{"^/logs/"},
+			path:      "/api/logs/request",
+			initialHeaders: http.Header{
+				"Claim": []string{"bar", "baz"},
+			},
+			session: &sessionsapi.SessionState{
+				IDToken: "IDToken-1234",
+			},
+			expectedHeaders: http.Header{
+				"Claim": []string{"IDToken-1234"},
+			},
+			expectedErr: "",
+		}),
+		Entry("with an invalid skip path", headersTableInput{
+			headers: []options.Header{
+				{
+					Name: "Claim",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "id_token",
+							},
+						},
+					},
+				},
+			},
+			skipPaths:       []string{"^/logs/(.*"},
+			initialHeaders:  http.Header{},
+			session:         &sessionsapi.SessionState{},
+			expectedHeaders: nil,
+			expectedErr:     "error building request header injector: error compiling skip path regex /^/logs/(.*/: error parsing regexp: missing closing ): `^/logs/(.*`",
+		}),
+	)
+
+	type headerSizeTableInput struct {
+		policy          string
+		groups          []string
+		expectedStatus  int
+		expectedHeaders http.Header
+		expectedBody    string
+	}
+
+	DescribeTable("the request header injector with a maximum header size",
+		func(in headerSizeTableInput) {
+			headers := []options.Header{
+				{
+					Name: "X-Forwarded-Groups",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "groups",
+							},
+						},
+					},
+				},
+			}
+			headerSize := options.HeaderSize{
+				MaxSize: 16,
+				Policy:  in.policy,
+			}
+
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				Session:   &sessionsapi.SessionState{Groups: in.groups},
+				RequestID: "request-id",
+			})
+			rw := httptest.NewRecorder()
+
+			var gotHeaders http.Header
+			injector, err := NewRequestHeaderInjector(headers, nil, headerSize, writer)
+			Expect(err).ToNot(HaveOccurred())
+
+			handler := injector(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotHeaders = r.Header.Clone()
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedStatus))
+			Expect(gotHeaders).To(Equal(in.expectedHeaders))
+			Expect(rw.Body.String()).To(Equal(in.expectedBody))
+		},
+		Entry("with a header within the maximum size", headerSizeTableInput{
+			policy:         options.HeaderSizeFail,
+			groups:         []string{"admins", "users"},
+			expectedStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"X-Forwarded-Groups": []string{"admins,users"},
+			},
+		}),
+		Entry("with a header of exactly the maximum size", headerSizeTableInput{
+			policy:         options.HeaderSizeFail,
+			groups:         []string{"admins", "users", "abc"},
+			expectedStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"X-Forwarded-Groups": []string{"admins,users,abc"},
+			},
+		}),
+		Entry("with the warn policy", headerSizeTableInput{
+			policy:         options.HeaderSizeWarn,
+			groups:         []string{"admins", "users", "viewers"},
+			expectedStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"X-Forwarded-Groups": []string{"admins,users,viewers"},
+			},
+		}),
+		Entry("with the truncate policy", headerSizeTableInput{
+			policy:         options.HeaderSizeTruncate,
+			groups:         []string{"admins", "users", "viewers"},
+			expectedStatus: http.StatusOK,
+			expectedHeaders: http.Header{
+				"X-Forwarded-Groups": []string{"admins,users"},
+			},
+		}),
+		Entry("with the truncate policy and a first value exceeding the maximum size", headerSizeTableInput{
+			policy:          options.HeaderSizeTruncate,
+			groups:          []string{"administrators-of-everything", "users"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: http.Header{},
+		}),
+		Entry("with the drop policy", headerSizeTableInput{
+			policy:          options.HeaderSizeDrop,
+			groups:          []string{"admins", "users", "viewers"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: http.Header{},
+		}),
+		Entry("with the fail policy", headerSizeTableInput{
+			policy:          options.HeaderSizeFail,
+			groups:          []string{"admins", "users", "viewers"},
+			expectedStatus:  http.StatusInternalServerError,
+			expectedHeaders: nil,
+			expectedBody:    "request-id: injected header X-Forwarded-Groups of 20 bytes exceeds the maximum size of 16 bytes",
+		}),
 	)
 
 	DescribeTable("the response header injector",
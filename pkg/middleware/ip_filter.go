@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// NewIPFilter creates a new middleware that denies requests from clients
+// whose IP is not allowed with a 403 Forbidden.
+// When the allowlist is not nil, only clients within it are allowed.
+// Clients within the denylist are always denied, even when they are also
+// within the allowlist.
+func NewIPFilter(allowlist, denylist *ip.NetSet, realClientIPParser ipapi.RealClientIPParser) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return ipFilter(allowlist, denylist, realClientIPParser, next)
+	}
+}
+
+func ipFilter(allowlist, denylist *ip.NetSet, realClientIPParser ipapi.RealClientIPParser, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !isAllowedIP(allowlist, denylist, realClientIPParser, req) {
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func isAllowedIP(allowlist, denylist *ip.NetSet, realClientIPParser ipapi.RealClientIPParser, req *http.Request) bool {
+	remoteAddr, err := ip.GetClientIP(realClientIPParser, req)
+	if err != nil {
+		logger.Errorf("Error obtaining real IP for IP filter: %v", err)
+		return false
+	}
+	if remoteAddr == nil {
+		return false
+	}
+
+	if denylist != nil && denylist.Has(remoteAddr) {
+		return false
+	}
+	return allowlist == nil || allowlist.Has(remoteAddr)
+}
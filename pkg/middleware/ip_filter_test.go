@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPFilter suite", func() {
+	newNetSet := func(ipStrs ...string) *ip.NetSet {
+		netSet := ip.NewNetSet()
+		for _, ipStr := range ipStrs {
+			netSet.AddIPNet(*ip.ParseIPNet(ipStr))
+		}
+		return netSet
+	}
+
+	type ipFilterTableInput struct {
+		allowlist          *ip.NetSet
+		denylist           *ip.NetSet
+		realClientIPParser ipapi.RealClientIPParser
+		remoteAddr         string
+		headers            map[string]string
+		expectedStatus     int
+	}
+
+	DescribeTable("when serving a request",
+		func(in *ipFilterTableInput) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = in.remoteAddr
+			for k, v := range in.headers {
+				req.Header.Add(k, v)
+			}
+			rw := httptest.NewRecorder()
+
+			handler := NewIPFilter(in.allowlist, in.denylist, in.realClientIPParser)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedStatus))
+		},
+		Entry("with a client within the allowlist", &ipFilterTableInput{
+			allowlist:      newNetSet("10.0.0.0/8"),
+			remoteAddr:     "10.1.2.3:43670",
+			expectedStatus: http.StatusOK,
+		}),
+		Entry("with a client outside the allowlist", &ipFilterTableInput{
+			allowlist:      newNetSet("10.0.0.0/8"),
+			remoteAddr:     "192.168.1.1:43670",
+			expectedStatus: http.StatusForbidden,
+		}),
+		Entry("with a client within the denylist", &ipFilterTableInput{
+			denylist:       newNetSet("192.168.0.0/16"),
+			remoteAddr:     "192.168.1.1:43670",
+			expectedStatus: http.StatusForbidden,
+		}),
+		Entry("with a client outside the denylist", &ipFilterTableInput{
+			denylist:       newNetSet("192.168.0.0/16"),
+			remoteAddr:     "10.1.2.3:43670",
+			expectedStatus: http.StatusOK,
+		}),
+		Entry("with a client within both the allowlist and the denylist", &ipFilterTableInput{
+			allowlist:      newNetSet("10.0.0.0/8"),
+			denylist:       newNetSet("10.1.0.0/16"),
+			remoteAddr:     "10.1.2.3:43670",
+			expectedStatus: http.StatusForbidden,
+		}),
+		Entry("with a client within the allowlist and outside the denylist", &ipFilterTableInput{
+			allowlist:      newNetSet("10.0.0.0/8"),
+			denylist:       newNetSet("10.1.0.0/16"),
+			remoteAddr:     "10.2.3.4:43670",
+			expectedStatus: http.StatusOK,
+		}),
+		Entry("with an IPv6 client within the allowlist", &ipFilterTableInput{
+			allowlist:      newNetSet("::1"),
+			remoteAddr:     "[::1]:43670",
+			expectedStatus: http.StatusOK,
+		}),
+		Entry("with a real client IP within the allowlist", &ipFilterTableInput{
+			allowlist:          newNetSet("10.0.0.0/8"),
+			realClientIPParser: mustRealClientIPParser("X-Real-IP"),
+			remoteAddr:         "192.168.1.1:43670",
+			headers:            map[string]string{"X-Real-IP": "10.1.2.3"},
+			expectedStatus:     http.StatusOK,
+		}),
+		Entry("with a real client IP outside the allowlist", &ipFilterTableInput{
+			allowlist:          newNetSet("10.0.0.0/8"),
+			realClientIPParser: mustRealClientIPParser("X-Real-IP"),
+			remoteAddr:         "10.1.2.3:43670",
+			headers:            map[string]string{"X-Real-IP": "192.168.1.1"},
+			expectedStatus:     http.StatusForbidden,
+		}),
+		Entry("with a real client IP missing", &ipFilterTableInput{
+			allowlist:          newNetSet("10.0.0.0/8"),
+			realClientIPParser: mustRealClientIPParser("X-Real-IP"),
+			remoteAddr:         "10.1.2.3:43670",
+			expectedStatus:     http.StatusForbidden,
+		}),
+		Entry("with an unparseable remote address", &ipFilterTableInput{
+			denylist:       newNetSet("192.168.0.0/16"),
+			remoteAddr:     "invalid",
+			expectedStatus: http.StatusForbidden,
+		}),
+	)
+})
+
+func mustRealClientIPParser(header string) ipapi.RealClientIPParser {
+	p, err := ip.GetRealClientIPParser(header)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
 
 	"github.com/justinas/alice"
@@ -26,6 +27,32 @@ func NewMetricsHandler(registerer prometheus.Registerer, gatherer prometheus.Gat
 	)
 }
 
+// NewMetricsTokenAuth returns a middleware that only allows requests that
+// present the token as a bearer token in the Authorization header, so that
+// metrics served alongside the upstreams can only be read by scrapers.
+func NewMetricsTokenAuth(token string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if !hasBearerToken(req, token) {
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// hasBearerToken checks whether the request presents the token as a bearer
+// token in the Authorization header
+func hasBearerToken(req *http.Request, token string) bool {
+	tokenType, presented, err := splitAuthHeader(req.Header.Get("Authorization"))
+	if err != nil || tokenType != "Bearer" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
 // NewRequestMetricsWithDefaultRegistry returns a middleware that will record
 // metrics for HTTP requests to the default prometheus.Registry
 func NewRequestMetricsWithDefaultRegistry() alice.Constructor {
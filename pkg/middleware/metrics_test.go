@@ -64,4 +64,44 @@ var _ = Describe("Instrumentation suite", func() {
 			expectedResultsFile: "testdata/metrics/notfoundrequest.txt",
 		}),
 	)
+
+	type tokenAuthTableInput struct {
+		authorization  string
+		expectedStatus int
+	}
+
+	DescribeTable("NewMetricsTokenAuth",
+		func(in *tokenAuthTableInput) {
+			req := httptest.NewRequest("", "http://example.com/metrics", nil)
+			if in.authorization != "" {
+				req.Header.Set("Authorization", in.authorization)
+			}
+			rw := httptest.NewRecorder()
+
+			handler := NewMetricsTokenAuth("scraper-token")(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedStatus))
+			if in.expectedStatus == http.StatusUnauthorized {
+				Expect(rw.Header().Get("WWW-Authenticate")).To(Equal(`Bearer realm="metrics"`))
+			}
+		},
+		Entry("with the bearer token", &tokenAuthTableInput{
+			authorization:  "Bearer scraper-token",
+			expectedStatus: http.StatusOK,
+		}),
+		Entry("without an Authorization header", &tokenAuthTableInput{
+			expectedStatus: http.StatusUnauthorized,
+		}),
+		Entry("with a different bearer token", &tokenAuthTableInput{
+			authorization:  "Bearer other-token",
+			expectedStatus: http.StatusUnauthorized,
+		}),
+		Entry("with the token as basic auth", &tokenAuthTableInput{
+			authorization:  "Basic scraper-token",
+			expectedStatus: http.StatusUnauthorized,
+		}),
+	)
 })
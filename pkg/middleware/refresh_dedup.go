@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// sharedRefreshTimeout limits how long a refresh shared between requests may
+// take, as it is not cancelled with the request that started it
+const sharedRefreshTimeout = 30 * time.Second
+
+// refreshDeduplicator collapses concurrent refreshes of sessions that share a
+// refresh token into a single call to the provider.
+// Providers that rotate refresh tokens invalidate the refresh token when it is
+// used, so a second refresh with the same token would fail. Instead, sessions
+// refreshed with the same token while a refresh is in progress, or within the
+// TTL after it succeeds, are given the tokens of that refresh. Failed
+// refreshes are not shared, so the next refresh calls the provider again.
+type refreshDeduplicator struct {
+	ttl       time.Duration
+	refresher func(context.Context, *sessionsapi.SessionState) (bool, error)
+
+	mutex     sync.Mutex
+	refreshes map[string]*sharedRefresh
+}
+
+// sharedRefresh is the result of a refresh, shared with every session that
+// was refreshed with the same refresh token
+type sharedRefresh struct {
+	// done is closed when the refresh completes
+	done chan struct{}
+
+	refreshed bool
+	err       error
+	session   *sessionsapi.SessionState
+}
+
+// newRefreshDeduplicator creates a refreshDeduplicator that shares the results
+// of the refresher for the ttl after each successful refresh
+func newRefreshDeduplicator(ttl time.Duration, refresher func(context.Context, *sessionsapi.SessionState) (bool, error)) *refreshDeduplicator {
+	return &refreshDeduplicator{
+		ttl:       ttl,
+		refresher: refresher,
+		refreshes: make(map[string]*sharedRefresh),
+	}
+}
+
+// RefreshSession refreshes the session with the refresher, unless a refresh
+// with the same refresh token is in progress or has recently succeeded.
+// In that case the result of that refresh is applied to the session instead.
+// When the refresh in progress fails, the session is refreshed again.
+func (d *refreshDeduplicator) RefreshSession(ctx context.Context, session *sessionsapi.SessionState) (bool, error) {
+	if session.RefreshToken == "" {
+		return d.refresher(ctx, session)
+	}
+	key := refreshTokenKey(session.RefreshToken)
+
+	for {
+		d.mutex.Lock()
+		shared, found := d.refreshes[key]
+		if !found {
+			shared = &sharedRefresh{done: make(chan struct{})}
+			d.refreshes[key] = shared
+		}
+		d.mutex.Unlock()
+
+		if !found {
+			return d.refresh(ctx, key, shared, session)
+		}
+
+		select {
+		case <-shared.done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		if shared.err != nil {
+			continue
+		}
+		if shared.refreshed {
+			copyRefreshedSession(session, shared.session)
+		}
+		return shared.refreshed, nil
+	}
+}
+
+// refresh refreshes the session with the refresher and shares a successful
+// result until the TTL has passed. The refresh is shared with other
+// requests, so it is not cancelled with the request that started it.
+func (d *refreshDeduplicator) refresh(ctx context.Context, key string, shared *sharedRefresh, session *sessionsapi.SessionState) (bool, error) {
+	refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedRefreshTimeout)
+	defer cancel()
+
+	shared.refreshed, shared.err = d.refresher(refreshCtx, session)
+	if shared.err != nil {
+		// Waiting refreshes retry once the failed refresh is removed
+		d.remove(key, shared)
+		close(shared.done)
+		return shared.refreshed, shared.err
+	}
+
+	shared.session = &sessionsapi.SessionState{}
+	copyRefreshedSession(shared.session, session)
+	close(shared.done)
+	time.AfterFunc(d.ttl, func() { d.remove(key, shared) })
+	return shared.refreshed, nil
+}
+
+// remove stops sharing the result of the refresh
+func (d *refreshDeduplicator) remove(key string, shared *sharedRefresh) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.refreshes[key] == shared {
+		delete(d.refreshes, key)
+	}
+}
+
+// refreshTokenKey identifies a refresh token by its hash, so that refresh
+// tokens aren't used as keys
+func refreshTokenKey(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// copyRefreshedSession copies the fields a provider may update when it
+// refreshes a session
+func copyRefreshedSession(dst, src *sessionsapi.SessionState) {
+	dst.AccessToken = src.AccessToken
+	dst.IDToken = src.IDToken
+	dst.RefreshToken = src.RefreshToken
+	if src.ExpiresOn != nil {
+		expiresOn := *src.ExpiresOn
+		dst.ExpiresOn = &expiresOn
+	} else {
+		dst.ExpiresOn = nil
+	}
+	dst.Email = src.Email
+	dst.User = src.User
+	dst.Groups = append([]string(nil), src.Groups...)
+	dst.PreferredUsername = src.PreferredUsername
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Refresh Deduplicator Suite", func() {
+	var calls int32
+	var release chan struct{}
+	var refreshErr error
+
+	refresher := func(ctx context.Context, s *sessionsapi.SessionState) (bool, error) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		if refreshErr != nil {
+			return false, refreshErr
+		}
+		s.AccessToken = "RefreshedAccessToken"
+		s.RefreshToken = "RotatedRefreshToken"
+		s.Groups = []string{"refreshed"}
+		if n > 1 {
+			s.AccessToken = "RefreshedAgainAccessToken"
+		}
+		return true, nil
+	}
+
+	BeforeEach(func() {
+		calls = 0
+		release = make(chan struct{})
+		refreshErr = nil
+	})
+
+	refreshConcurrently := func(d *refreshDeduplicator, sessions ...*sessionsapi.SessionState) ([]bool, []error) {
+		refreshed := make([]bool, len(sessions))
+		errs := make([]error, len(sessions))
+		wg := sync.WaitGroup{}
+		for i := range sessions {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				refreshed[i], errs[i] = d.RefreshSession(context.Background(), sessions[i])
+			}(i)
+		}
+		// Let the refreshes start before the first one completes
+		Eventually(func() int {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			return len(d.refreshes)
+		}).Should(BeNumerically(">", 0))
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return refreshed, errs
+	}
+
+	It("collapses concurrent refreshes with the same refresh token", func() {
+		d := newRefreshDeduplicator(time.Minute, refresher)
+		first := &sessionsapi.SessionState{AccessToken: "AccessToken", RefreshToken: "RefreshToken"}
+		second := &sessionsapi.SessionState{AccessToken: "OtherAccessToken", RefreshToken: "RefreshToken"}
+
+		refreshed, errs := refreshConcurrently(d, first, second)
+		Expect(refreshed).To(Equal([]bool{true, true}))
+		Expect(errs).To(Equal([]error{nil, nil}))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+
+		for _, s := range []*sessionsapi.SessionState{first, second} {
+			Expect(s.AccessToken).To(Equal("RefreshedAccessToken"))
+			Expect(s.RefreshToken).To(Equal("RotatedRefreshToken"))
+			Expect(s.Groups).To(Equal([]string{"refreshed"}))
+		}
+	})
+
+	It("refreshes sessions with different refresh tokens separately", func() {
+		d := newRefreshDeduplicator(time.Minute, refresher)
+		first := &sessionsapi.SessionState{RefreshToken: "RefreshToken"}
+		second := &sessionsapi.SessionState{RefreshToken: "OtherRefreshToken"}
+
+		_, errs := refreshConcurrently(d, first, second)
+		Expect(errs).To(Equal([]error{nil, nil}))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	It("doesn't share failed refreshes", func() {
+		refreshErr = errors.New("refresh failed")
+		d := newRefreshDeduplicator(time.Minute, refresher)
+		first := &sessionsapi.SessionState{AccessToken: "AccessToken", RefreshToken: "RefreshToken"}
+		second := &sessionsapi.SessionState{AccessToken: "AccessToken", RefreshToken: "RefreshToken"}
+
+		// The concurrent refresh is retried once the first one fails
+		refreshed, errs := refreshConcurrently(d, first, second)
+		Expect(refreshed).To(Equal([]bool{false, false}))
+		Expect(errs).To(Equal([]error{refreshErr, refreshErr}))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+		Expect(second.AccessToken).To(Equal("AccessToken"))
+		Expect(d.refreshes).To(BeEmpty())
+
+		refreshErr = nil
+		third := &sessionsapi.SessionState{RefreshToken: "RefreshToken"}
+		Expect(d.RefreshSession(context.Background(), third)).To(BeTrue())
+		Expect(third.AccessToken).To(Equal("RefreshedAgainAccessToken"))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+	})
+
+	It("doesn't cancel the refresh with the request that started it", func() {
+		close(release)
+		var refreshCtxErr error
+		d := newRefreshDeduplicator(time.Minute, func(ctx context.Context, s *sessionsapi.SessionState) (bool, error) {
+			refreshCtxErr = ctx.Err()
+			return refresher(ctx, s)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(d.RefreshSession(ctx, &sessionsapi.SessionState{RefreshToken: "RefreshToken"})).To(BeTrue())
+		Expect(refreshCtxErr).ToNot(HaveOccurred())
+	})
+
+	It("shares the result of a completed refresh until the TTL has passed", func() {
+		close(release)
+		d := newRefreshDeduplicator(50*time.Millisecond, refresher)
+
+		first := &sessionsapi.SessionState{RefreshToken: "RefreshToken"}
+		Expect(d.RefreshSession(context.Background(), first)).To(BeTrue())
+
+		second := &sessionsapi.SessionState{RefreshToken: "RefreshToken"}
+		Expect(d.RefreshSession(context.Background(), second)).To(BeTrue())
+		Expect(second.AccessToken).To(Equal("RefreshedAccessToken"))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+
+		Eventually(func() int {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			return len(d.refreshes)
+		}).Should(Equal(0))
+
+		third := &sessionsapi.SessionState{RefreshToken: "RefreshToken"}
+		Expect(d.RefreshSession(context.Background(), third)).To(BeTrue())
+		Expect(third.AccessToken).To(Equal("RefreshedAgainAccessToken"))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	It("always refreshes sessions without a refresh token", func() {
+		close(release)
+		d := newRefreshDeduplicator(time.Minute, refresher)
+
+		Expect(d.RefreshSession(context.Background(), &sessionsapi.SessionState{})).To(BeTrue())
+		Expect(d.RefreshSession(context.Background(), &sessionsapi.SessionState{})).To(BeTrue())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+		Expect(d.refreshes).To(BeEmpty())
+	})
+
+	It("stops waiting when the request is cancelled", func() {
+		d := newRefreshDeduplicator(time.Minute, refresher)
+		go func() {
+			defer GinkgoRecover()
+			_, _ = d.RefreshSession(context.Background(), &sessionsapi.SessionState{RefreshToken: "RefreshToken"})
+		}()
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(BeEquivalentTo(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		refreshed, err := d.RefreshSession(ctx, &sessionsapi.SessionState{RefreshToken: "RefreshToken"})
+		Expect(refreshed).To(BeFalse())
+		Expect(err).To(Equal(context.Canceled))
+		close(release)
+	})
+})
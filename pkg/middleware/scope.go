@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/justinas/alice"
@@ -14,6 +15,9 @@ func NewScope(reverseProxy bool, idHeader string) alice.Constructor {
 			scope := &middlewareapi.RequestScope{
 				ReverseProxy: reverseProxy,
 				RequestID:    genRequestID(req, idHeader),
+				Timings: middlewareapi.Timings{
+					Started: time.Now(),
+				},
 			}
 			req = middlewareapi.AddRequestScope(req, scope)
 			next.ServeHTTP(rw, req)
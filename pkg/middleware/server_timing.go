@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+)
+
+const serverTimingHeader = "Server-Timing"
+
+// NewServerTiming creates a new middleware that adds a Server-Timing header to
+// the responses of requests the enabled function allows.
+// The header reports the time spent authenticating the request, refreshing the
+// session and waiting for the upstream to respond.
+func NewServerTiming(enabled func(*http.Request) bool) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			scope := middlewareapi.GetRequestScope(req)
+			if scope == nil || !enabled(req) {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			next.ServeHTTP(&serverTimingResponse{ResponseWriter: rw, timings: &scope.Timings}, req)
+		})
+	}
+}
+
+// serverTimingResponse is a custom http.ResponseWriter that adds the
+// Server-Timing header when the response headers are written.
+type serverTimingResponse struct {
+	http.ResponseWriter
+
+	timings     *middlewareapi.Timings
+	wroteHeader bool
+}
+
+// Write writes the response using the ResponseWriter
+func (r *serverTimingResponse) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// WriteHeader adds the Server-Timing header and writes the status code for
+// the Response
+func (r *serverTimingResponse) WriteHeader(s int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.Header().Add(serverTimingHeader, formatServerTiming(r.timings, time.Now()))
+	}
+	r.ResponseWriter.WriteHeader(s)
+}
+
+// Hijack implements the `http.Hijacker` interface that actual ResponseWriters
+// implement to support websockets
+func (r *serverTimingResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker is not available on writer")
+}
+
+// Flush sends any buffered data to the client. Implements the `http.Flusher`
+// interface
+func (r *serverTimingResponse) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		if !r.wroteHeader {
+			r.WriteHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
+}
+
+// formatServerTiming formats the timings as of the given time as a
+// Server-Timing header value.
+// Authentication covers everything before the request was passed to the
+// upstream, or the whole request when it was not proxied. The upstream time is
+// the time until the upstream started its response.
+func formatServerTiming(timings *middlewareapi.Timings, now time.Time) string {
+	authEnd := now
+	if !timings.UpstreamStarted.IsZero() {
+		authEnd = timings.UpstreamStarted
+	}
+
+	metrics := []string{formatServerTimingMetric("auth", authEnd.Sub(timings.Started))}
+	if timings.Refresh > 0 {
+		metrics = append(metrics, formatServerTimingMetric("refresh", timings.Refresh))
+	}
+	if !timings.UpstreamStarted.IsZero() {
+		metrics = append(metrics, formatServerTimingMetric("upstream", now.Sub(timings.UpstreamStarted)))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// formatServerTimingMetric formats a duration in milliseconds, as expected by
+// the Server-Timing header
+func formatServerTimingMetric(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", name, float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server Timing Suite", func() {
+	started := time.Unix(1600000000, 0)
+
+	DescribeTable("formatServerTiming",
+		func(timings middlewareapi.Timings, expected string) {
+			now := started.Add(50 * time.Millisecond)
+			Expect(formatServerTiming(&timings, now)).To(Equal(expected))
+		},
+		Entry("with a request that was not proxied", middlewareapi.Timings{
+			Started: started,
+		}, "auth;dur=50.000"),
+		Entry("with a proxied request", middlewareapi.Timings{
+			Started:         started,
+			UpstreamStarted: started.Add(1500 * time.Microsecond),
+		}, "auth;dur=1.500, upstream;dur=48.500"),
+		Entry("with a refreshed session", middlewareapi.Timings{
+			Started:         started,
+			Refresh:         time.Millisecond,
+			UpstreamStarted: started.Add(2 * time.Millisecond),
+		}, "auth;dur=2.000, refresh;dur=1.000, upstream;dur=48.000"),
+	)
+
+	type serverTimingTableInput struct {
+		enabled        bool
+		writeHeader    bool
+		expectedHeader bool
+	}
+
+	DescribeTable("when serving a request",
+		func(in serverTimingTableInput) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+				Timings: middlewareapi.Timings{Started: time.Now()},
+			})
+			rw := httptest.NewRecorder()
+
+			handler := NewServerTiming(func(*http.Request) bool {
+				return in.enabled
+			})(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if in.writeHeader {
+					rw.WriteHeader(http.StatusAccepted)
+				}
+				rw.Write([]byte("response"))
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Body.String()).To(Equal("response"))
+			if in.expectedHeader {
+				Expect(rw.Header().Get("Server-Timing")).To(HavePrefix("auth;dur="))
+			} else {
+				Expect(rw.Header().Values("Server-Timing")).To(BeEmpty())
+			}
+		},
+		Entry("when disabled", serverTimingTableInput{
+			enabled:        false,
+			writeHeader:    true,
+			expectedHeader: false,
+		}),
+		Entry("when enabled", serverTimingTableInput{
+			enabled:        true,
+			writeHeader:    true,
+			expectedHeader: true,
+		}),
+		Entry("when enabled and the handler only writes the body", serverTimingTableInput{
+			enabled:        true,
+			writeHeader:    false,
+			expectedHeader: true,
+		}),
+	)
+
+	It("keeps Server-Timing headers set by the upstream", func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{
+			Timings: middlewareapi.Timings{Started: time.Now()},
+		})
+		rw := httptest.NewRecorder()
+
+		NewServerTiming(func(*http.Request) bool { return true })(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Server-Timing", "db;dur=12")
+			rw.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rw, req)
+
+		values := rw.Header().Values("Server-Timing")
+		Expect(values).To(HaveLen(2))
+		Expect(values[0]).To(Equal("db;dur=12"))
+	})
+})
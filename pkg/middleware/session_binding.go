@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// ErrSessionBindingMismatch is returned when a session is used by a client
+// other than the one it is bound to
+var ErrSessionBindingMismatch = errors.New("session is bound to a different client")
+
+// SessionBinder binds sessions to properties of the client that created
+// them, so that a stolen session can't be used by another client.
+// The binding is an HMAC of the chosen properties, keyed by the cookie
+// secret, so that the properties can't be read from the session.
+type SessionBinder struct {
+	secret             []byte
+	userAgent          bool
+	clientIP           bool
+	ipv4Mask           net.IPMask
+	ipv6Mask           net.IPMask
+	realClientIPParser ipapi.RealClientIPParser
+}
+
+// NewSessionBinder creates a SessionBinder from the session options.
+// If sessions aren't bound to any client properties, nil is returned.
+func NewSessionBinder(opts options.SessionOptions, secret string, realClientIPParser ipapi.RealClientIPParser) *SessionBinder {
+	if !opts.BindUserAgent && !opts.BindClientIP {
+		return nil
+	}
+
+	return &SessionBinder{
+		secret:             []byte(secret),
+		userAgent:          opts.BindUserAgent,
+		clientIP:           opts.BindClientIP,
+		ipv4Mask:           net.CIDRMask(opts.BindIPv4Prefix, 32),
+		ipv6Mask:           net.CIDRMask(opts.BindIPv6Prefix, 128),
+		realClientIPParser: realClientIPParser,
+	}
+}
+
+// Bind binds the session to the client making the request
+func (b *SessionBinder) Bind(req *http.Request, session *sessionsapi.SessionState) error {
+	binding, err := b.binding(req)
+	if err != nil {
+		return err
+	}
+	session.Binding = binding
+	return nil
+}
+
+// Verify checks that the session is bound to the client making the request.
+// Sessions that aren't bound to any client, e.g. because they were created
+// before binding was enabled, don't match any client.
+func (b *SessionBinder) Verify(req *http.Request, session *sessionsapi.SessionState) error {
+	binding, err := b.binding(req)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(binding), []byte(session.Binding)) {
+		return ErrSessionBindingMismatch
+	}
+	return nil
+}
+
+// binding computes the binding for the client making the request from each
+// of the enabled properties
+func (b *SessionBinder) binding(req *http.Request) (string, error) {
+	mac := hmac.New(sha256.New, b.secret)
+	if b.userAgent {
+		fmt.Fprintf(mac, "ua=%q;", req.UserAgent())
+	}
+	if b.clientIP {
+		network, err := b.clientNetwork(req)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(mac, "ip=%q;", network)
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// clientNetwork masks the client IP to the configured prefix length for its
+// address family
+func (b *SessionBinder) clientNetwork(req *http.Request) (string, error) {
+	clientIP, err := ip.GetClientIP(b.realClientIPParser, req)
+	if err != nil {
+		return "", fmt.Errorf("error obtaining client IP to bind session: %v", err)
+	}
+	if clientIP == nil {
+		return "", errors.New("could not obtain client IP to bind session")
+	}
+
+	if ipv4 := clientIP.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(b.ipv4Mask), Mask: b.ipv4Mask}).String(), nil
+	}
+	return (&net.IPNet{IP: clientIP.Mask(b.ipv6Mask), Mask: b.ipv6Mask}).String(), nil
+}
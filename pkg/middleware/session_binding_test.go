@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Binding Suite", func() {
+	const secret = "0123456789abcdef"
+
+	bindingOptions := func(userAgent, clientIP bool) options.SessionOptions {
+		return options.SessionOptions{
+			BindUserAgent:  userAgent,
+			BindClientIP:   clientIP,
+			BindIPv4Prefix: options.DefaultSessionBindIPv4Prefix,
+			BindIPv6Prefix: options.DefaultSessionBindIPv6Prefix,
+		}
+	}
+
+	newRequest := func(userAgent, remoteAddr string) *http.Request {
+		req := httptest.NewRequest("", "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = remoteAddr
+		return req
+	}
+
+	It("is disabled when no client properties are bound", func() {
+		Expect(NewSessionBinder(bindingOptions(false, false), secret, nil)).To(BeNil())
+	})
+
+	type sessionBindingTableInput struct {
+		opts          options.SessionOptions
+		bindRequest   *http.Request
+		verifyRequest *http.Request
+		expectedErr   error
+	}
+
+	DescribeTable("verifying a bound session",
+		func(in sessionBindingTableInput) {
+			binder := NewSessionBinder(in.opts, secret, nil)
+			Expect(binder).ToNot(BeNil())
+
+			session := &sessionsapi.SessionState{Email: "user@example.com"}
+			Expect(binder.Bind(in.bindRequest, session)).To(Succeed())
+			Expect(session.Binding).ToNot(BeEmpty())
+			Expect(session.Binding).ToNot(ContainSubstring("Firefox"))
+
+			err := binder.Verify(in.verifyRequest, session)
+			if in.expectedErr != nil {
+				Expect(err).To(MatchError(in.expectedErr))
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+			}
+		},
+		Entry("with the same User-Agent", sessionBindingTableInput{
+			opts:          bindingOptions(true, false),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("Firefox", "198.51.100.1:1234"),
+		}),
+		Entry("with a different User-Agent", sessionBindingTableInput{
+			opts:          bindingOptions(true, false),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("curl", "192.0.2.1:1234"),
+			expectedErr:   ErrSessionBindingMismatch,
+		}),
+		Entry("with an IPv4 client within the same network", sessionBindingTableInput{
+			opts:          bindingOptions(false, true),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("curl", "192.0.2.200:4321"),
+		}),
+		Entry("with an IPv4 client from a different network", sessionBindingTableInput{
+			opts:          bindingOptions(false, true),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("Firefox", "192.0.3.1:1234"),
+			expectedErr:   ErrSessionBindingMismatch,
+		}),
+		Entry("with an IPv6 client within the same network", sessionBindingTableInput{
+			opts:          bindingOptions(false, true),
+			bindRequest:   newRequest("Firefox", "[2001:db8::1]:1234"),
+			verifyRequest: newRequest("Firefox", "[2001:db8::ffff:1]:1234"),
+		}),
+		Entry("with an IPv6 client from a different network", sessionBindingTableInput{
+			opts:          bindingOptions(false, true),
+			bindRequest:   newRequest("Firefox", "[2001:db8::1]:1234"),
+			verifyRequest: newRequest("Firefox", "[2001:db8:0:1::1]:1234"),
+			expectedErr:   ErrSessionBindingMismatch,
+		}),
+		Entry("with both properties and a different network", sessionBindingTableInput{
+			opts:          bindingOptions(true, true),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("Firefox", "198.51.100.1:1234"),
+			expectedErr:   ErrSessionBindingMismatch,
+		}),
+		Entry("with both properties matching", sessionBindingTableInput{
+			opts:          bindingOptions(true, true),
+			bindRequest:   newRequest("Firefox", "192.0.2.1:1234"),
+			verifyRequest: newRequest("Firefox", "192.0.2.2:1234"),
+		}),
+	)
+
+	It("does not match sessions that were never bound", func() {
+		binder := NewSessionBinder(bindingOptions(true, false), secret, nil)
+		err := binder.Verify(newRequest("Firefox", "192.0.2.1:1234"), &sessionsapi.SessionState{})
+		Expect(err).To(MatchError(ErrSessionBindingMismatch))
+	})
+
+	It("does not match sessions bound with a different secret", func() {
+		session := &sessionsapi.SessionState{}
+		req := newRequest("Firefox", "192.0.2.1:1234")
+		Expect(NewSessionBinder(bindingOptions(true, false), "fedcba9876543210", nil).Bind(req, session)).To(Succeed())
+
+		binder := NewSessionBinder(bindingOptions(true, false), secret, nil)
+		Expect(binder.Verify(req, session)).To(MatchError(ErrSessionBindingMismatch))
+	})
+
+	Context("with the stored session loader", func() {
+		var (
+			binder  *SessionBinder
+			session *sessionsapi.SessionState
+			cleared bool
+		)
+
+		BeforeEach(func() {
+			binder = NewSessionBinder(bindingOptions(true, false), secret, nil)
+
+			expires := time.Now().Add(time.Hour)
+			session = &sessionsapi.SessionState{Email: "user@example.com", ExpiresOn: &expires}
+			Expect(binder.Bind(newRequest("Firefox", "192.0.2.1:1234"), session)).To(Succeed())
+			cleared = false
+		})
+
+		loadSession := func(req *http.Request) *sessionsapi.SessionState {
+			store := &fakeSessionStore{
+				LoadFunc: func(*http.Request) (*sessionsapi.SessionState, error) {
+					return session, nil
+				},
+				ClearFunc: func(http.ResponseWriter, *http.Request) error {
+					cleared = true
+					return nil
+				},
+			}
+
+			var gotSession *sessionsapi.SessionState
+			handler := NewStoredSessionLoader(&StoredSessionLoaderOptions{
+				SessionStore:  store,
+				SessionBinder: binder,
+			})(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotSession = middlewareapi.GetRequestScope(r).Session
+			}))
+
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			return gotSession
+		}
+
+		It("loads the session for the client it is bound to", func() {
+			Expect(loadSession(newRequest("Firefox", "192.0.2.1:1234"))).To(Equal(session))
+			Expect(cleared).To(BeFalse())
+		})
+
+		It("clears the session for another client", func() {
+			Expect(loadSession(newRequest("curl", "192.0.2.1:1234"))).To(BeNil())
+			Expect(cleared).To(BeTrue())
+		})
+	})
+})
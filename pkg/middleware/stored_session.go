@@ -31,6 +31,36 @@ type StoredSessionLoaderOptions struct {
 	// If the sesssion is older than `RefreshPeriod` but the provider doesn't
 	// refresh it, we must re-validate using this validation.
 	ValidateSession func(context.Context, *sessionsapi.SessionState) bool
+
+	// How long the result of a refresh is shared with sessions that are
+	// refreshed with the same refresh token.
+	// Concurrent refreshes with the same refresh token are only deduplicated
+	// when this is positive.
+	RefreshDedupTTL time.Duration
+
+	// Binds sessions to the client that created them.
+	// Sessions that are used by another client are cleared.
+	// Sessions are not bound to a client when this is nil.
+	SessionBinder *SessionBinder
+
+	// Transforms the claims of refreshed sessions.
+	// Claims are not transformed when this is nil.
+	ClaimTransformer *ClaimTransformer
+
+	// Evaluates the feature flags of refreshed sessions, once their claims
+	// have been transformed.
+	// Feature flags are not evaluated when this is nil.
+	FeatureFlags *FeatureFlagEvaluator
+
+	// How often sessions are checked for revoked refresh tokens.
+	// Sessions whose refresh tokens have been revoked are cleared.
+	// Sessions are not checked when this is 0.
+	RevocationCheckInterval time.Duration
+
+	// Provider based revocation checking.
+	// Sessions are checked by refreshing them when this is nil, and are
+	// revoked if the provider rejects their refresh token.
+	CheckRevocation func(context.Context, *sessionsapi.SessionState) (bool, error)
 }
 
 // NewStoredSessionLoader creates a new storedSessionLoader which loads
@@ -38,11 +68,27 @@ type StoredSessionLoaderOptions struct {
 // If no session is found, the request will be passed to the nex handler.
 // If a session was loader by a previous handler, it will not be replaced.
 func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor {
+	refreshSession := opts.RefreshSession
+	if opts.ClaimTransformer != nil && refreshSession != nil {
+		refreshSession = opts.ClaimTransformer.RefreshSession(refreshSession)
+	}
+	if opts.FeatureFlags != nil && refreshSession != nil {
+		refreshSession = opts.FeatureFlags.RefreshSession(refreshSession)
+	}
+
 	ss := &storedSessionLoader{
 		store:            opts.SessionStore,
 		refreshPeriod:    opts.RefreshPeriod,
-		sessionRefresher: opts.RefreshSession,
+		sessionRefresher: refreshSession,
 		sessionValidator: opts.ValidateSession,
+		sessionBinder:    opts.SessionBinder,
+
+		revocationCheckInterval: opts.RevocationCheckInterval,
+		revocationChecker:       opts.CheckRevocation,
+	}
+	if opts.RefreshDedupTTL > 0 {
+		// The deduplicated results are shared once their claims are transformed
+		ss.sessionRefresher = newRefreshDeduplicator(opts.RefreshDedupTTL, refreshSession).RefreshSession
 	}
 	return ss.loadSession
 }
@@ -54,6 +100,10 @@ type storedSessionLoader struct {
 	refreshPeriod    time.Duration
 	sessionRefresher func(context.Context, *sessionsapi.SessionState) (bool, error)
 	sessionValidator func(context.Context, *sessionsapi.SessionState) bool
+	sessionBinder    *SessionBinder
+
+	revocationCheckInterval time.Duration
+	revocationChecker       func(context.Context, *sessionsapi.SessionState) (bool, error)
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -99,11 +149,22 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 		return nil, nil
 	}
 
+	if s.sessionBinder != nil {
+		if err := s.sessionBinder.Verify(req, session); err != nil {
+			return nil, fmt.Errorf("error verifying session binding (%s): %v", session, err)
+		}
+	}
+
 	err = s.refreshSessionIfNeeded(rw, req, session)
 	if err != nil {
 		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
 	}
 
+	err = s.checkRevocationIfNeeded(rw, req, session)
+	if err != nil {
+		return nil, fmt.Errorf("error checking revocation of session (%s): %v", session, err)
+	}
+
 	return session, nil
 }
 
@@ -116,16 +177,47 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 		return nil
 	}
 
+	// Tokens are only needed to refresh and validate the session,
+	// so delay decrypting them until now
+	if err := session.DecryptTokens(); err != nil {
+		return err
+	}
+
 	logger.Printf("Refreshing session - User: %s; SessionAge: %s", session.User, session.Age())
+	refreshToken := session.RefreshToken
+	start := time.Now()
 	err := s.refreshSession(rw, req, session)
+	if scope := middlewareapi.GetRequestScope(req); scope != nil {
+		scope.Timings.Refresh = time.Since(start)
+	}
+	if s.revocationCheckInterval > 0 && errors.Is(err, providers.ErrRefreshTokenRevoked) {
+		if !s.refreshedConcurrently(req, session, refreshToken) {
+			// The refresh found the session to be revoked
+			return err
+		}
+		// The session was refreshed by a concurrent request instead
+		err = nil
+	}
 	if err != nil {
+		if errors.Is(err, providers.ErrClaimAssertionFailed) {
+			// The refreshed ID token shows the user is no longer allowed
+			return err
+		}
 		// If a preemptive refresh fails, we still keep the session
 		// if validateSession succeeds.
 		logger.Errorf("Unable to refresh session: %v", err)
 	}
 
 	// Validate all sessions after any Redeem/Refresh operation (fail or success)
-	return s.validateSession(req.Context(), session)
+	validateErr := s.validateSession(req.Context(), session)
+	if errors.Is(err, providers.ErrProviderUnavailable) && validateErr == nil {
+		// The session is served without being refreshed while the provider
+		// is unavailable, so mark it as degraded for the upstream
+		if scope := middlewareapi.GetRequestScope(req); scope != nil {
+			scope.SessionDegraded = true
+		}
+	}
+	return validateErr
 }
 
 // refreshSession attempts to refresh the session with the provider
@@ -133,7 +225,7 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
 	refreshed, err := s.sessionRefresher(req.Context(), session)
 	if err != nil && !errors.Is(err, providers.ErrNotImplemented) {
-		return fmt.Errorf("error refreshing tokens: %v", err)
+		return fmt.Errorf("error refreshing tokens: %w", err)
 	}
 
 	// HACK:
@@ -164,6 +256,83 @@ func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.R
 	return nil
 }
 
+// checkRevocationIfNeeded checks whether the refresh token of the session has
+// been revoked, if it has not been checked, refreshed or created within the
+// revocation check interval.
+// An error implies the session has been revoked. If the check itself fails, the
+// session is kept and is checked again once the interval has passed, so that
+// the provider is not called on every request while it is unavailable.
+func (s *storedSessionLoader) checkRevocationIfNeeded(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
+	if s.revocationCheckInterval <= time.Duration(0) || session.SinceRevocationCheck() < s.revocationCheckInterval {
+		// Checks are disabled or the session was recently checked, do nothing
+		return nil
+	}
+
+	if err := session.DecryptTokens(); err != nil {
+		return err
+	}
+	if session.RefreshToken == "" {
+		// Sessions without a refresh token have nothing to revoke
+		return nil
+	}
+
+	logger.Printf("Checking session for revocation - User: %s; SinceLastCheck: %s", session.User, session.SinceRevocationCheck())
+	if s.revocationChecker == nil {
+		refreshToken := session.RefreshToken
+		err := s.refreshSession(rw, req, session)
+		switch {
+		case errors.Is(err, providers.ErrRefreshTokenRevoked):
+			if !s.refreshedConcurrently(req, session, refreshToken) {
+				return err
+			}
+			// The concurrent refresh saved the session with a new CreatedAt
+			return nil
+		case errors.Is(err, providers.ErrClaimAssertionFailed):
+			return err
+		case err != nil:
+			logger.Errorf("Unable to check session for revocation: %v", err)
+		case session.SinceRevocationCheck() < s.revocationCheckInterval:
+			// The session was refreshed and saved with a new CreatedAt
+			return nil
+		}
+	} else {
+		revoked, err := s.revocationChecker(req.Context(), session)
+		if err != nil {
+			logger.Errorf("Unable to check session for revocation: %v", err)
+		} else if revoked {
+			return providers.ErrRefreshTokenRevoked
+		}
+	}
+
+	session.RevocationCheckedNow()
+	if err := s.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+	}
+	return nil
+}
+
+// refreshedConcurrently reports whether the session was refreshed and saved by
+// a concurrent request after it was loaded for this request. Providers that
+// rotate refresh tokens reject the refresh token this request used once the
+// concurrent refresh has used it, although the session has not been revoked.
+// If it was, the session is replaced with the saved session.
+func (s *storedSessionLoader) refreshedConcurrently(req *http.Request, session *sessionsapi.SessionState, refreshToken string) bool {
+	saved, err := s.store.Load(req)
+	if err != nil || saved == nil {
+		return false
+	}
+	if err := saved.DecryptTokens(); err != nil {
+		return false
+	}
+	if saved.RefreshToken == "" || saved.RefreshToken == refreshToken {
+		return false
+	}
+
+	logger.Printf("Refresh token of session was rotated by a concurrent refresh - User: %s", session.User)
+	*session = *saved
+	return true
+}
+
 // validateSession checks whether the session has expired and performs
 // provider validation on the session.
 // An error implies the session is not longer valid.
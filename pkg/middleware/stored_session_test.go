@@ -275,6 +275,7 @@ var _ = Describe("Stored Session Suite", func() {
 			expectedErr     error
 			expectRefreshed bool
 			expectValidated bool
+			expectDegraded  bool
 		}
 
 		createdPast := time.Now().Add(-5 * time.Minute)
@@ -297,6 +298,10 @@ var _ = Describe("Stored Session Suite", func() {
 							return false, nil
 						case notImplemented:
 							return false, providers.ErrNotImplemented
+						case "FailedAssertion":
+							return false, fmt.Errorf("unable to redeem refresh token: %w", providers.ErrClaimAssertionFailed)
+						case "Unavailable":
+							return false, fmt.Errorf("unable to redeem refresh token: %w", providers.ErrProviderUnavailable)
 						default:
 							return false, errors.New("error refreshing session")
 						}
@@ -307,7 +312,8 @@ var _ = Describe("Stored Session Suite", func() {
 					},
 				}
 
-				req := httptest.NewRequest("", "/", nil)
+				scope := &middlewareapi.RequestScope{}
+				req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
 				err := s.refreshSessionIfNeeded(nil, req, in.session)
 				if in.expectedErr != nil {
 					Expect(err).To(MatchError(in.expectedErr))
@@ -316,6 +322,7 @@ var _ = Describe("Stored Session Suite", func() {
 				}
 				Expect(refreshed).To(Equal(in.expectRefreshed))
 				Expect(validated).To(Equal(in.expectValidated))
+				Expect(scope.SessionDegraded).To(Equal(in.expectDegraded))
 			},
 			Entry("when the refresh period is 0, and the session does not need refreshing", refreshSessionIfNeededTableInput{
 				refreshPeriod: time.Duration(0),
@@ -388,6 +395,38 @@ var _ = Describe("Stored Session Suite", func() {
 				expectRefreshed: true,
 				expectValidated: true,
 			}),
+			Entry("when the provider is unavailable but validation succeeds", refreshSessionIfNeededTableInput{
+				refreshPeriod: 1 * time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "Unavailable",
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:     nil,
+				expectRefreshed: true,
+				expectValidated: true,
+				expectDegraded:  true,
+			}),
+			Entry("when the refreshed ID token fails a claim assertion", refreshSessionIfNeededTableInput{
+				refreshPeriod: 1 * time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "FailedAssertion",
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:     providers.ErrClaimAssertionFailed,
+				expectRefreshed: true,
+				expectValidated: false,
+			}),
+			Entry("when the provider refresh fails and validation fails", refreshSessionIfNeededTableInput{
+				refreshPeriod: 1 * time.Minute,
+				session: &sessionsapi.SessionState{
+					AccessToken:  "Invalid",
+					RefreshToken: "RefreshError",
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:     errors.New("session is invalid"),
+				expectRefreshed: true,
+				expectValidated: true,
+			}),
 			Entry("when the session is not refreshed by the provider and validation fails", refreshSessionIfNeededTableInput{
 				refreshPeriod: 1 * time.Minute,
 				session: &sessionsapi.SessionState{
@@ -406,7 +445,7 @@ var _ = Describe("Stored Session Suite", func() {
 	Context("refreshSession", func() {
 		type refreshSessionWithProviderTableInput struct {
 			session     *sessionsapi.SessionState
-			expectedErr error
+			expectedErr interface{}
 			expectSaved bool
 		}
 
@@ -477,7 +516,7 @@ var _ = Describe("Stored Session Suite", func() {
 					CreatedAt:    &now,
 					ExpiresOn:    &now,
 				},
-				expectedErr: errors.New("error refreshing tokens: error refreshing session"),
+				expectedErr: "error refreshing tokens: error refreshing session",
 				expectSaved: false,
 			}),
 			Entry("when the saving the session returns an error", refreshSessionWithProviderTableInput{
@@ -489,6 +528,271 @@ var _ = Describe("Stored Session Suite", func() {
 				expectSaved: true,
 			}),
 		)
+
+		It("resets the creation time but not the authentication time", func() {
+			authenticated := now.Add(-2 * time.Hour)
+			created := now.Add(-1 * time.Hour)
+			session := &sessionsapi.SessionState{
+				RefreshToken:    refresh,
+				CreatedAt:       &created,
+				AuthenticatedAt: &authenticated,
+			}
+
+			s := &storedSessionLoader{
+				store: &fakeSessionStore{},
+				sessionRefresher: func(context.Context, *sessionsapi.SessionState) (bool, error) {
+					return true, nil
+				},
+			}
+
+			req := httptest.NewRequest("", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			Expect(s.refreshSession(nil, req, session)).To(Succeed())
+			Expect(session.CreatedAt.After(created)).To(BeTrue())
+			Expect(*session.AuthenticatedAt).To(Equal(authenticated))
+		})
+	})
+
+	Context("checkRevocationIfNeeded", func() {
+		const (
+			revoked = "Revoked"
+			active  = "Active"
+		)
+
+		type checkRevocationIfNeededTableInput struct {
+			checkInterval      time.Duration
+			introspect         bool
+			session            *sessionsapi.SessionState
+			expectedErr        interface{}
+			expectRefreshed    bool
+			expectIntrospected bool
+			expectSaved        bool
+			expectChecked      bool
+		}
+
+		createdPast := time.Now().Add(-5 * time.Minute)
+		createdRecently := time.Now().Add(-30 * time.Second)
+		checkedRecently := time.Now().Add(-30 * time.Second)
+
+		DescribeTable("with a session",
+			func(in checkRevocationIfNeededTableInput) {
+				refreshed := false
+				introspected := false
+				saved := false
+
+				s := &storedSessionLoader{
+					revocationCheckInterval: in.checkInterval,
+					store: &fakeSessionStore{
+						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+							saved = true
+							return nil
+						},
+					},
+					sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+						refreshed = true
+						switch ss.RefreshToken {
+						case active:
+							return true, nil
+						case revoked:
+							return false, fmt.Errorf("unable to redeem refresh token: %w", providers.ErrRefreshTokenRevoked)
+						case "FailedAssertion":
+							return false, fmt.Errorf("unable to redeem refresh token: %w", providers.ErrClaimAssertionFailed)
+						default:
+							return false, errors.New("error refreshing session")
+						}
+					},
+				}
+				if in.introspect {
+					s.revocationChecker = func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+						introspected = true
+						switch ss.RefreshToken {
+						case active:
+							return false, nil
+						case revoked:
+							return true, nil
+						default:
+							return false, errors.New("error introspecting refresh token")
+						}
+					}
+				}
+
+				req := httptest.NewRequest("", "/", nil)
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				err := s.checkRevocationIfNeeded(nil, req, in.session)
+				if in.expectedErr != nil {
+					Expect(err).To(MatchError(in.expectedErr))
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(refreshed).To(Equal(in.expectRefreshed))
+				Expect(introspected).To(Equal(in.expectIntrospected))
+				Expect(saved).To(Equal(in.expectSaved))
+				Expect(in.session.RevocationCheckedAt != nil).To(Equal(in.expectChecked))
+			},
+			Entry("when checks are disabled", checkRevocationIfNeededTableInput{
+				checkInterval: time.Duration(0),
+				session: &sessionsapi.SessionState{
+					RefreshToken: revoked,
+					CreatedAt:    &createdPast,
+				},
+			}),
+			Entry("when the session was created within the interval", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: revoked,
+					CreatedAt:    &createdRecently,
+				},
+			}),
+			Entry("when the session was checked within the interval", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken:        revoked,
+					CreatedAt:           &createdPast,
+					RevocationCheckedAt: &checkedRecently,
+				},
+				expectChecked: true,
+			}),
+			Entry("when the session has no refresh token", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					CreatedAt: &createdPast,
+				},
+			}),
+			Entry("when the session is refreshed", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: active,
+					CreatedAt:    &createdPast,
+				},
+				expectRefreshed: true,
+				expectSaved:     true,
+			}),
+			Entry("when the refresh token is rejected by the provider", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: revoked,
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:     "error refreshing tokens: unable to redeem refresh token: refresh token has been revoked",
+				expectRefreshed: true,
+			}),
+			Entry("when the refreshed ID token fails a claim assertion", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "FailedAssertion",
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:     providers.ErrClaimAssertionFailed,
+				expectRefreshed: true,
+			}),
+			Entry("when the refresh fails", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "RefreshError",
+					CreatedAt:    &createdPast,
+				},
+				expectRefreshed: true,
+				expectSaved:     true,
+				expectChecked:   true,
+			}),
+			Entry("when the refresh token is active", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				introspect:    true,
+				session: &sessionsapi.SessionState{
+					RefreshToken: active,
+					CreatedAt:    &createdPast,
+				},
+				expectIntrospected: true,
+				expectSaved:        true,
+				expectChecked:      true,
+			}),
+			Entry("when the refresh token is revoked", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				introspect:    true,
+				session: &sessionsapi.SessionState{
+					RefreshToken: revoked,
+					CreatedAt:    &createdPast,
+				},
+				expectedErr:        providers.ErrRefreshTokenRevoked,
+				expectIntrospected: true,
+			}),
+			Entry("when the introspection fails", checkRevocationIfNeededTableInput{
+				checkInterval: time.Minute,
+				introspect:    true,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "IntrospectionError",
+					CreatedAt:    &createdPast,
+				},
+				expectIntrospected: true,
+				expectSaved:        true,
+				expectChecked:      true,
+			}),
+		)
+	})
+
+	Context("with a refresh token rotated by a concurrent refresh", func() {
+		const (
+			usedToken    = "UsedRefreshToken"
+			rotatedToken = "RotatedRefreshToken"
+		)
+
+		var (
+			s       *storedSessionLoader
+			saved   *sessionsapi.SessionState
+			session *sessionsapi.SessionState
+			req     *http.Request
+		)
+
+		BeforeEach(func() {
+			createdPast := time.Now().Add(-5 * time.Minute)
+			createdRecently := time.Now().Add(-5 * time.Second)
+			session = &sessionsapi.SessionState{
+				AccessToken:  "OldAccessToken",
+				RefreshToken: usedToken,
+				CreatedAt:    &createdPast,
+			}
+			saved = &sessionsapi.SessionState{
+				AccessToken:  "NewAccessToken",
+				RefreshToken: rotatedToken,
+				CreatedAt:    &createdRecently,
+			}
+
+			s = &storedSessionLoader{
+				refreshPeriod:           time.Minute,
+				revocationCheckInterval: time.Minute,
+				store: &fakeSessionStore{
+					LoadFunc: func(_ *http.Request) (*sessionsapi.SessionState, error) {
+						return saved, nil
+					},
+				},
+				sessionRefresher: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					if ss.RefreshToken == rotatedToken {
+						return true, nil
+					}
+					return false, fmt.Errorf("unable to redeem refresh token: %w", providers.ErrRefreshTokenRevoked)
+				},
+				sessionValidator: func(_ context.Context, _ *sessionsapi.SessionState) bool {
+					return true
+				},
+			}
+			req = middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), &middlewareapi.RequestScope{})
+		})
+
+		It("keeps the session saved by the concurrent refresh when refreshing", func() {
+			Expect(s.refreshSessionIfNeeded(nil, req, session)).To(Succeed())
+			Expect(session.AccessToken).To(Equal("NewAccessToken"))
+			Expect(session.RefreshToken).To(Equal(rotatedToken))
+		})
+
+		It("keeps the session saved by the concurrent refresh when checking for revocation", func() {
+			Expect(s.checkRevocationIfNeeded(nil, req, session)).To(Succeed())
+			Expect(session.RefreshToken).To(Equal(rotatedToken))
+		})
+
+		It("treats the session as revoked when the saved refresh token is the one used", func() {
+			saved.RefreshToken = usedToken
+			Expect(s.refreshSessionIfNeeded(nil, req, session)).To(MatchError(providers.ErrRefreshTokenRevoked))
+		})
 	})
 
 	Context("validateSession", func() {
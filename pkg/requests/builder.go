@@ -102,7 +102,7 @@ func (r *builder) do() Result {
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		r.result = &result{err: fmt.Errorf("error performing request: %v", err)}
+		r.result = &result{err: fmt.Errorf("error performing request: %w", err)}
 		return r.result
 	}
 
@@ -2,14 +2,16 @@ package util
 
 import (
 	"net/http"
+	"strings"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 )
 
 const (
-	XForwardedProto = "X-Forwarded-Proto"
-	XForwardedHost  = "X-Forwarded-Host"
-	XForwardedURI   = "X-Forwarded-Uri"
+	XForwardedProto  = "X-Forwarded-Proto"
+	XForwardedHost   = "X-Forwarded-Host"
+	XForwardedURI    = "X-Forwarded-Uri"
+	XForwardedMethod = "X-Forwarded-Method"
 )
 
 // GetRequestProto returns the request scheme or X-Forwarded-Proto if present
@@ -53,6 +55,20 @@ func IsProxied(req *http.Request) bool {
 	return scope.ReverseProxy
 }
 
+// ToggleTrailingSlash returns the path with its trailing slash removed, or
+// with a trailing slash added when it doesn't have one.
+// The root path has no alternative, so false is returned for it.
+func ToggleTrailingSlash(path string) (string, bool) {
+	switch {
+	case path == "" || path == "/":
+		return "", false
+	case strings.HasSuffix(path, "/"):
+		return strings.TrimSuffix(path, "/"), true
+	default:
+		return path + "/", true
+	}
+}
+
 func IsForwardedRequest(req *http.Request) bool {
 	return IsProxied(req) &&
 		req.Host != GetRequestHost(req)
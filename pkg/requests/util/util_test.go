@@ -8,6 +8,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -128,4 +129,18 @@ var _ = Describe("Util Suite", func() {
 			})
 		})
 	})
+
+	DescribeTable("ToggleTrailingSlash",
+		func(path, expectedPath string, expectedOK bool) {
+			toggled, ok := util.ToggleTrailingSlash(path)
+			Expect(toggled).To(Equal(expectedPath))
+			Expect(ok).To(Equal(expectedOK))
+		},
+		Entry("adds a trailing slash", "/app", "/app/", true),
+		Entry("removes a trailing slash", "/app/", "/app", true),
+		Entry("removes only one trailing slash", "/app//", "/app/", true),
+		Entry("with a nested path", "/app/users", "/app/users/", true),
+		Entry("with the root path", "/", "", false),
+		Entry("with an empty path", "", "", false),
+	)
 })
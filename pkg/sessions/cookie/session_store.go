@@ -27,9 +27,21 @@ var _ sessions.SessionStore = &SessionStore{}
 // SessionStore is an implementation of the sessions.SessionStore
 // interface that stores sessions in client side cookies
 type SessionStore struct {
-	Cookie       *options.Cookie
-	CookieCipher encryption.Cipher
-	Minimal      bool
+	Cookie            *options.Cookie
+	CookieCipher      encryption.Cipher
+	Minimal           bool
+	EncryptTokensOnly bool
+	SignedOnly        bool
+
+	// previousSecrets are tried in order to load sessions saved before the
+	// cookie secret was rotated
+	previousSecrets []cookieSecret
+}
+
+// cookieSecret is a cookie secret with the cipher created from it
+type cookieSecret struct {
+	secret string
+	cipher encryption.Cipher
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -53,18 +65,43 @@ func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
 		// always http.ErrNoCookie
 		return nil, fmt.Errorf("cookie %q not present", s.Cookie.Name)
 	}
-	val, _, ok := encryption.Validate(c, s.Cookie.Secret, s.Cookie.Expire)
+	val, secret, ok := s.validateCookie(c)
 	if !ok {
 		return nil, errors.New("cookie signature not valid")
 	}
 
-	session, err := sessions.DecodeSessionState(val, s.CookieCipher, true)
+	// Signed only sessions are loaded regardless of the SignedOnly option, so
+	// that sessions do not need to be cleared when it is changed.
+	// Sessions loaded with a previous secret are saved with the current
+	// secret the next time they are saved.
+	var session *sessions.SessionState
+	switch {
+	case sessions.IsSessionStateSignedOnly(val):
+		session, err = sessions.DecodeSessionStateSignedOnly(val, encryption.SecretBytes(secret.secret), true)
+	case s.EncryptTokensOnly:
+		session, err = sessions.DecodeSessionStateWithEncryptedTokens(val, secret.cipher, encryption.SecretBytes(secret.secret), true)
+	default:
+		session, err = sessions.DecodeSessionState(val, secret.cipher, true)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return session, nil
 }
 
+// validateCookie checks the signature of the session cookie with the cookie
+// secret, then each of the previous secrets, returning the secret that the
+// cookie was signed with
+func (s *SessionStore) validateCookie(c *http.Cookie) ([]byte, cookieSecret, bool) {
+	secrets := append([]cookieSecret{{secret: s.Cookie.Secret, cipher: s.CookieCipher}}, s.previousSecrets...)
+	for _, secret := range secrets {
+		if val, _, ok := encryption.Validate(c, secret.secret, s.Cookie.Expire); ok {
+			return val, secret, true
+		}
+	}
+	return nil, cookieSecret{}, false
+}
+
 // Clear clears any saved session information by writing a cookie to
 // clear the session
 func (s *SessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
@@ -90,9 +127,18 @@ func (s *SessionStore) cookieForSession(ss *sessions.SessionState) ([]byte, erro
 		minimal.IDToken = ""
 		minimal.RefreshToken = ""
 
+		if s.SignedOnly {
+			return minimal.EncodeSessionStateSignedOnly(encryption.SecretBytes(s.Cookie.Secret), true)
+		}
 		return minimal.EncodeSessionState(s.CookieCipher, true)
 	}
 
+	if s.SignedOnly {
+		return ss.EncodeSessionStateSignedOnly(encryption.SecretBytes(s.Cookie.Secret), true)
+	}
+	if s.EncryptTokensOnly {
+		return ss.EncodeSessionStateWithEncryptedTokens(s.CookieCipher, encryption.SecretBytes(s.Cookie.Secret), true)
+	}
 	return ss.EncodeSessionState(s.CookieCipher, true)
 }
 
@@ -105,16 +151,54 @@ func (s *SessionStore) setSessionCookie(rw http.ResponseWriter, req *http.Reques
 	for _, c := range cookies {
 		http.SetCookie(rw, c)
 	}
+
+	// Expire any cookies left over from a previous session that was split
+	// into a different number of cookies, so they aren't joined with the new
+	// session when it is loaded
+	for _, name := range staleCookieNames(req, s.Cookie.Name, cookies) {
+		http.SetCookie(rw, s.makeCookie(req, name, "", time.Hour*-1, time.Now()))
+	}
 	return nil
 }
 
+// staleCookieNames returns the names of the session cookies in the request
+// that are not replaced by the cookies being set
+func staleCookieNames(req *http.Request, cookieName string, cookies []*http.Cookie) []string {
+	replaced := make(map[string]struct{}, len(cookies))
+	for _, c := range cookies {
+		replaced[c.Name] = struct{}{}
+	}
+
+	names := []string{}
+	if _, err := req.Cookie(cookieName); err == nil {
+		names = append(names, cookieName)
+	}
+	// Split cookies are loaded until the first missing index, so any chunks
+	// after a gap can't be loaded and are ignored here too
+	for count := 0; ; count++ {
+		name := splitCookieName(cookieName, count)
+		if _, err := req.Cookie(name); err != nil {
+			break
+		}
+		names = append(names, name)
+	}
+
+	stale := []string{}
+	for _, name := range names {
+		if _, ok := replaced[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
 // makeSessionCookie creates an http.Cookie containing the authenticated user's
 // authentication details
 func (s *SessionStore) makeSessionCookie(req *http.Request, value []byte, now time.Time) ([]*http.Cookie, error) {
 	strValue := string(value)
 	if strValue != "" {
 		var err error
-		strValue, err = encryption.SignedValue(s.Cookie.Secret, s.Cookie.Name, value, now)
+		strValue, err = encryption.SignedValue(s.Cookie.Secret, s.Cookie.Name, value, now, pkgcookies.ValueEncoding(s.Cookie))
 		if err != nil {
 			return nil, err
 		}
@@ -145,10 +229,22 @@ func NewCookieSessionStore(opts *options.SessionOptions, cookieOpts *options.Coo
 		return nil, fmt.Errorf("error initialising cipher: %v", err)
 	}
 
+	previousSecrets := make([]cookieSecret, 0, len(cookieOpts.PreviousSecrets))
+	for i, secret := range cookieOpts.PreviousSecrets {
+		previousCipher, err := encryption.NewCFBCipher(encryption.SecretBytes(secret))
+		if err != nil {
+			return nil, fmt.Errorf("error initialising cipher for previous secret %d: %v", i, err)
+		}
+		previousSecrets = append(previousSecrets, cookieSecret{secret: secret, cipher: previousCipher})
+	}
+
 	return &SessionStore{
-		CookieCipher: cipher,
-		Cookie:       cookieOpts,
-		Minimal:      opts.Cookie.Minimal,
+		CookieCipher:      cipher,
+		Cookie:            cookieOpts,
+		Minimal:           opts.Cookie.Minimal,
+		EncryptTokensOnly: opts.EncryptTokensOnly,
+		SignedOnly:        opts.SignedOnly,
+		previousSecrets:   previousSecrets,
 	}, nil
 }
 
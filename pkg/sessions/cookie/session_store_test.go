@@ -4,6 +4,7 @@ import (
 	"fmt"
 	mathrand "math/rand"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
 )
@@ -34,6 +36,255 @@ var _ = Describe("Cookie SessionStore Tests", func() {
 		}, nil)
 })
 
+var _ = Describe("Cookie SessionStore signed only sessions", func() {
+	cookieOpts := &options.Cookie{
+		Name:   "_oauth2_proxy",
+		Path:   "/",
+		Expire: time.Hour,
+		Secret: "0123456789abcdef0123456789abcdef",
+	}
+	session := &sessionsapi.SessionState{
+		Email:       "user@example.com",
+		AccessToken: "AccessToken",
+		CreatedAt:   timePtr(time.Now()),
+	}
+
+	// migrate saves the session with one store and loads it with another
+	migrate := func(from, to *options.SessionOptions) *sessionsapi.SessionState {
+		saver, err := NewCookieSessionStore(from, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+		loader, err := NewCookieSessionStore(to, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		Expect(saver.Save(rw, req, session)).To(Succeed())
+
+		req = httptest.NewRequest("GET", "/", nil)
+		for _, c := range rw.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		loaded, err := loader.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+		return loaded
+	}
+
+	It("loads encrypted sessions when signed only sessions are enabled", func() {
+		loaded := migrate(&options.SessionOptions{}, &options.SessionOptions{SignedOnly: true})
+		Expect(loaded.Email).To(Equal(session.Email))
+		Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+	})
+
+	It("loads signed only sessions when signed only sessions are disabled", func() {
+		loaded := migrate(&options.SessionOptions{SignedOnly: true}, &options.SessionOptions{})
+		Expect(loaded.Email).To(Equal(session.Email))
+		Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+	})
+
+	It("loads signed only sessions when only tokens are encrypted", func() {
+		loaded := migrate(&options.SessionOptions{SignedOnly: true}, &options.SessionOptions{EncryptTokensOnly: true})
+		Expect(loaded.Email).To(Equal(session.Email))
+		Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+	})
+
+	It("does not encrypt signed only sessions", func() {
+		store, err := NewCookieSessionStore(&options.SessionOptions{SignedOnly: true}, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+
+		value, err := store.(*SessionStore).cookieForSession(session)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sessionsapi.IsSessionStateSignedOnly(value)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Cookie SessionStore secret rotation", func() {
+	const (
+		oldSecret = "0123456789abcdef0123456789abcdef"
+		newSecret = "fedcba9876543210fedcba9876543210"
+	)
+
+	cookieOptsWithSecrets := func(secret string, previousSecrets ...string) *options.Cookie {
+		return &options.Cookie{
+			Name:            "_oauth2_proxy",
+			Path:            "/",
+			Expire:          time.Hour,
+			Secret:          secret,
+			PreviousSecrets: previousSecrets,
+		}
+	}
+
+	// save saves a session with the store and returns a request carrying the
+	// session cookies
+	save := func(store sessionsapi.SessionStore, session *sessionsapi.SessionState) *http.Request {
+		rw := httptest.NewRecorder()
+		Expect(store.Save(rw, httptest.NewRequest("GET", "/", nil), session)).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, c := range rw.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		return req
+	}
+
+	DescribeTable("loading sessions saved with a previous secret",
+		func(opts *options.SessionOptions) {
+			oldStore, err := NewCookieSessionStore(opts, cookieOptsWithSecrets(oldSecret))
+			Expect(err).ToNot(HaveOccurred())
+			rotatedStore, err := NewCookieSessionStore(opts, cookieOptsWithSecrets(newSecret, oldSecret))
+			Expect(err).ToNot(HaveOccurred())
+			newStore, err := NewCookieSessionStore(opts, cookieOptsWithSecrets(newSecret))
+			Expect(err).ToNot(HaveOccurred())
+
+			req := save(oldStore, &sessionsapi.SessionState{Email: "user@example.com", CreatedAt: timePtr(time.Now())})
+			_, err = newStore.Load(req)
+			Expect(err).To(MatchError("cookie signature not valid"))
+
+			loaded, err := rotatedStore.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Email).To(Equal("user@example.com"))
+
+			// Saving the session again uses the current secret
+			loaded, err = newStore.Load(save(rotatedStore, loaded))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Email).To(Equal("user@example.com"))
+		},
+		Entry("with encrypted sessions", &options.SessionOptions{}),
+		Entry("with only the tokens encrypted", &options.SessionOptions{EncryptTokensOnly: true}),
+		Entry("with signed only sessions", &options.SessionOptions{SignedOnly: true}),
+	)
+
+	It("does not load sessions saved with an unknown secret", func() {
+		otherStore, err := NewCookieSessionStore(&options.SessionOptions{}, cookieOptsWithSecrets("abcdefabcdefabcdefabcdefabcdefab"))
+		Expect(err).ToNot(HaveOccurred())
+		rotatedStore, err := NewCookieSessionStore(&options.SessionOptions{}, cookieOptsWithSecrets(newSecret, oldSecret))
+		Expect(err).ToNot(HaveOccurred())
+
+		req := save(otherStore, &sessionsapi.SessionState{Email: "user@example.com", CreatedAt: timePtr(time.Now())})
+		_, err = rotatedStore.Load(req)
+		Expect(err).To(MatchError("cookie signature not valid"))
+	})
+
+	It("returns an error for an invalid previous secret", func() {
+		_, err := NewCookieSessionStore(&options.SessionOptions{}, cookieOptsWithSecrets(newSecret, "short"))
+		Expect(err).To(MatchError("error initialising cipher for previous secret 0: crypto/aes: invalid key size 5"))
+	})
+})
+
+var _ = Describe("Cookie SessionStore chunked cookies", func() {
+	cookieOpts := &options.Cookie{
+		Name:   "_oauth2_proxy",
+		Path:   "/",
+		Expire: time.Hour,
+		Secret: "0123456789abcdef0123456789abcdef",
+	}
+
+	var store sessionsapi.SessionStore
+	var jar map[string]*http.Cookie
+
+	BeforeEach(func() {
+		var err error
+		store, err = NewCookieSessionStore(&options.SessionOptions{}, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+		jar = map[string]*http.Cookie{}
+	})
+
+	// request creates a request with the cookies the browser holds
+	request := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, c := range jar {
+			req.AddCookie(c)
+		}
+		return req
+	}
+
+	// save saves a session with an access token of the given length, and
+	// updates the browser's cookies from the response
+	save := func(tokenLength int) *sessionsapi.SessionState {
+		token := make([]byte, tokenLength)
+		for i := range token {
+			// Random tokens can't be compressed, so the length determines
+			// the number of cookies needed
+			token[i] = byte('a' + mathrand.Intn(26))
+		}
+		session := &sessionsapi.SessionState{
+			Email:       "user@example.com",
+			AccessToken: string(token),
+			CreatedAt:   timePtr(time.Now()),
+		}
+
+		rw := httptest.NewRecorder()
+		Expect(store.Save(rw, request(), session)).To(Succeed())
+		for _, c := range rw.Result().Cookies() {
+			if c.Expires.Before(time.Now()) {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = c
+			}
+		}
+		return session
+	}
+
+	// cookieNames returns the names of the cookies the browser holds
+	cookieNames := func() []string {
+		names := []string{}
+		for name := range jar {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	expectLoaded := func(session *sessionsapi.SessionState) {
+		loaded, err := store.Load(request())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+	}
+
+	It("expires unused chunks when the session no longer needs splitting", func() {
+		expectLoaded(save(10000))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy_0", "_oauth2_proxy_1", "_oauth2_proxy_2", "_oauth2_proxy_3"))
+
+		expectLoaded(save(100))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy"))
+	})
+
+	It("expires unused chunks when the session shrinks", func() {
+		expectLoaded(save(10000))
+		Expect(cookieNames()).To(HaveLen(4))
+
+		expectLoaded(save(5000))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy_0", "_oauth2_proxy_1"))
+	})
+
+	It("expires the unsplit cookie when the session needs splitting", func() {
+		expectLoaded(save(100))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy"))
+
+		expectLoaded(save(5000))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy_0", "_oauth2_proxy_1"))
+	})
+
+	It("does not leave stale chunks as the session grows and shrinks", func() {
+		for _, length := range []int{10000, 100, 5000, 10000, 2000, 100, 10000} {
+			expectLoaded(save(length))
+		}
+		expectLoaded(save(5000))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy_0", "_oauth2_proxy_1"))
+	})
+
+	It("does not expire other cookies", func() {
+		jar["_oauth2_proxy_csrf"] = &http.Cookie{Name: "_oauth2_proxy_csrf", Value: "csrf"}
+		jar["_oauth2_proxy_other"] = &http.Cookie{Name: "_oauth2_proxy_other", Value: "other"}
+
+		save(10000)
+		expectLoaded(save(100))
+		Expect(cookieNames()).To(ConsistOf("_oauth2_proxy", "_oauth2_proxy_csrf", "_oauth2_proxy_other"))
+	})
+})
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
 func Test_copyCookie(t *testing.T) {
 	expire, _ := time.Parse(time.RFC3339, "2020-03-17T00:00:00Z")
 	c := &http.Cookie{
@@ -0,0 +1,191 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrKeyNotFound is returned by the Client when a key does not exist or has
+// expired.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Client is wrapper interface for the etcd client.
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Lock(key string) sessions.Lock
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Del(ctx context.Context, key string) error
+	GetDel(ctx context.Context, key string) ([]byte, error)
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error)
+	Close() error
+}
+
+var _ Client = (*client)(nil)
+
+// client stores each key with its own lease, so that the key expires when
+// the lease does.
+type client struct {
+	kv     clientv3.KV
+	lease  clientv3.Lease
+	closer io.Closer
+	prefix string
+}
+
+func newClient(c *clientv3.Client, prefix string) Client {
+	return &client{
+		kv:     c,
+		lease:  c,
+		closer: c,
+		prefix: prefix,
+	}
+}
+
+func (c *client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.kv.Get(ctx, c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// Set attaches the key to a new lease with a TTL of the expiration.
+// A lease's TTL can only be renewed to the TTL it was granted with, so when
+// the key is saved again any previous lease is revoked after the key has
+// moved to the new lease, rather than leaving it to expire on its own.
+func (c *client) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	lease, err := c.lease.Grant(ctx, leaseTTL(expiration))
+	if err != nil {
+		return fmt.Errorf("unable to grant lease: %v", err)
+	}
+
+	resp, err := c.kv.Put(ctx, c.prefix+key, string(value), clientv3.WithLease(lease.ID), clientv3.WithPrevKV())
+	if err != nil {
+		c.revoke(ctx, lease.ID)
+		return err
+	}
+
+	if resp.PrevKv != nil && resp.PrevKv.Lease != 0 && clientv3.LeaseID(resp.PrevKv.Lease) != lease.ID {
+		c.revoke(ctx, clientv3.LeaseID(resp.PrevKv.Lease))
+	}
+	return nil
+}
+
+// Del deletes the key and revokes its lease.
+func (c *client) Del(ctx context.Context, key string) error {
+	resp, err := c.kv.Delete(ctx, c.prefix+key, clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range resp.PrevKvs {
+		if kv.Lease != 0 {
+			c.revoke(ctx, clientv3.LeaseID(kv.Lease))
+		}
+	}
+	return nil
+}
+
+// GetDel deletes the key and revokes its lease, returning the value it had.
+// Only one of any concurrent deletes of the key gets the value.
+func (c *client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.kv.Delete(ctx, c.prefix+key, clientv3.WithPrevKV())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.PrevKvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	kv := resp.PrevKvs[0]
+	if kv.Lease != 0 {
+		c.revoke(ctx, clientv3.LeaseID(kv.Lease))
+	}
+	return kv.Value, nil
+}
+
+// CompareAndSwap attaches the key to a new lease with the value if its current
+// value is old, or if it does not exist when old is nil.
+// The key is only put if it has not been modified since its value was
+// compared, in which case the lease of the previous value is revoked.
+func (c *client) CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error) {
+	resp, err := c.kv.Get(ctx, c.prefix+key)
+	if err != nil {
+		return false, err
+	}
+
+	var current *mvccpb.KeyValue
+	if len(resp.Kvs) > 0 {
+		current = resp.Kvs[0]
+	}
+	if (current != nil) != (old != nil) || (current != nil && !bytes.Equal(current.Value, old)) {
+		return false, nil
+	}
+
+	unmodified := clientv3.Compare(clientv3.CreateRevision(c.prefix+key), "=", 0)
+	if current != nil {
+		unmodified = clientv3.Compare(clientv3.ModRevision(c.prefix+key), "=", current.ModRevision)
+	}
+
+	lease, err := c.lease.Grant(ctx, leaseTTL(expiration))
+	if err != nil {
+		return false, fmt.Errorf("unable to grant lease: %v", err)
+	}
+
+	txn, err := c.kv.Txn(ctx).
+		If(unmodified).
+		Then(clientv3.OpPut(c.prefix+key, string(value), clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !txn.Succeeded {
+		c.revoke(ctx, lease.ID)
+		return false, err
+	}
+
+	if current != nil && current.Lease != 0 {
+		c.revoke(ctx, clientv3.LeaseID(current.Lease))
+	}
+	return true, nil
+}
+
+func (c *client) Lock(key string) sessions.Lock {
+	return NewLock(c.kv, c.lease, c.prefix+key)
+}
+
+// Close closes the connection to the etcd cluster
+func (c *client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// revoke makes a best effort to revoke a lease that is no longer needed.
+// Leases that cannot be revoked still expire after their TTL.
+func (c *client) revoke(ctx context.Context, id clientv3.LeaseID) {
+	if _, err := c.lease.Revoke(ctx, id); err != nil {
+		logger.Errorf("unable to revoke etcd lease %x: %v", int64(id), err)
+	}
+}
+
+// leaseTTL converts an expiration to a lease TTL.
+// Leases have a granularity of seconds, so the expiration is rounded up to
+// avoid keys expiring early.
+func leaseTTL(expiration time.Duration) int64 {
+	ttl := int64(math.Ceil(expiration.Seconds()))
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
@@ -0,0 +1,188 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// SessionStore is an implementation of the persistence.Store
+// interface that stores sessions in etcd
+type SessionStore struct {
+	Client Client
+}
+
+// NewEtcdSessionStore initialises a new instance of the SessionStore and wraps
+// it in a persistence.Manager
+func NewEtcdSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	client, err := NewEtcdClient(opts.Etcd)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing etcd client: %v", err)
+	}
+
+	es := &SessionStore{
+		Client: client,
+	}
+	return persistence.NewManager(es, opts, cookieOpts)
+}
+
+// Save takes a sessions.SessionState and stores the information from it
+// to etcd, with a lease that expires the session after exp
+func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	err := store.Client.Set(ctx, key, value, exp)
+	if err != nil {
+		return fmt.Errorf("error saving etcd session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// Load reads sessions.SessionState information from etcd
+func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.Get(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("error loading etcd session: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading etcd session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
+
+// Clear clears any saved session information for a given persistence cookie
+// from etcd, and then clears the session
+func (store *SessionStore) Clear(ctx context.Context, key string) error {
+	err := store.Client.Del(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error clearing the session from etcd: %v", err)
+	}
+	return nil
+}
+
+// LoadAndClear reads and clears the value from etcd atomically
+func (store *SessionStore) LoadAndClear(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.GetDel(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("error loading etcd session: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading etcd session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
+
+// CompareAndSwap saves the value to etcd if the current value is old, or if
+// there is no current value when old is nil
+func (store *SessionStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error) {
+	swapped, err := store.Client.CompareAndSwap(ctx, key, old, value, exp)
+	if err != nil {
+		return false, fmt.Errorf("error saving etcd session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return swapped, nil
+}
+
+// Lock creates a lock object for sessions.SessionState
+func (store *SessionStore) Lock(key string) sessions.Lock {
+	return store.Client.Lock(key)
+}
+
+// Close closes the connection to etcd
+func (store *SessionStore) Close() error {
+	return store.Client.Close()
+}
+
+// NewEtcdClient makes a Client that connects to the etcd cluster
+func NewEtcdClient(opts options.EtcdStoreOptions) (Client, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, fmt.Errorf("at least one etcd endpoint is required")
+	}
+
+	tlsConfig, err := buildTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		DialTimeout: opts.DialTimeout,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newClient(c, opts.KeyPrefix), nil
+}
+
+// buildTLSConfig creates the TLS configuration for the etcd client.
+// TLS is used when any endpoint uses https or any TLS options are set,
+// otherwise nil is returned and the client connects without TLS.
+func buildTLSConfig(opts options.EtcdStoreOptions) (*tls.Config, error) {
+	if !usesTLS(opts) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.InsecureSkipTLSVerify {
+		// InsecureSkipVerify is a configurable option we allow
+		/* #nosec G402 */
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if opts.CAPath != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			logger.Errorf("failed to load system cert pool for etcd connection, falling back to empty cert pool")
+		}
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		certs, err := ioutil.ReadFile(opts.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %q, %v", opts.CAPath, err)
+		}
+
+		// Append our cert to the system pool
+		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
+			logger.Errorf("no certs appended, using system certs only")
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if opts.CertPath != "" || opts.KeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertPath, opts.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load etcd client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func usesTLS(opts options.EtcdStoreOptions) bool {
+	if opts.CAPath != "" || opts.CertPath != "" || opts.KeyPath != "" || opts.InsecureSkipTLSVerify {
+		return true
+	}
+	for _, endpoint := range opts.Endpoints {
+		if u, err := url.Parse(endpoint); err == nil && u.Scheme == "https" {
+			return true
+		}
+	}
+	return false
+}
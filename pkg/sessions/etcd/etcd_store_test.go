@@ -0,0 +1,249 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	logger.SetErrOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Etcd SessionStore")
+}
+
+func newFakeClient(f *fakeEtcd, prefix string) Client {
+	return &client{
+		kv:     f,
+		lease:  f,
+		prefix: prefix,
+	}
+}
+
+var _ = Describe("Etcd SessionStore Tests", func() {
+	var fake *fakeEtcd
+
+	BeforeEach(func() {
+		fake = newFakeEtcd()
+	})
+
+	tests.RunSessionStoreTests(
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			opts.Type = options.EtcdSessionStoreType
+			es := &SessionStore{Client: newFakeClient(fake, "")}
+			return persistence.NewManager(es, opts, cookieOpts)
+		},
+		func(d time.Duration) error {
+			return fake.FastForward(d)
+		},
+	)
+
+	Context("with a key prefix", func() {
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.EtcdSessionStoreType
+				es := &SessionStore{Client: newFakeClient(fake, "/oauth2-proxy/")}
+				return persistence.NewManager(es, opts, cookieOpts)
+			},
+			func(d time.Duration) error {
+				return fake.FastForward(d)
+			},
+		)
+	})
+})
+
+var _ = Describe("Etcd Client", func() {
+	var fake *fakeEtcd
+	var c Client
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		fake = newFakeEtcd()
+		c = newFakeClient(fake, "/oauth2-proxy/")
+	})
+
+	It("stores keys under the prefix with a lease for the expiration", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), 1500*time.Millisecond)).To(Succeed())
+
+		Expect(fake.keys).To(HaveKey("/oauth2-proxy/key"))
+		Expect(fake.liveLeases()).To(HaveLen(1))
+		Expect(fake.leases[fake.lastLease].ttl).To(BeEquivalentTo(2))
+
+		value, err := c.Get(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("value")))
+	})
+
+	It("moves the key to a new lease and revokes the previous lease when saved again", func() {
+		Expect(c.Set(ctx, "key", []byte("first"), time.Minute)).To(Succeed())
+		firstLease := fake.lastLease
+
+		Expect(fake.FastForward(30 * time.Second)).To(Succeed())
+		Expect(c.Set(ctx, "key", []byte("second"), time.Hour)).To(Succeed())
+
+		Expect(fake.revoked).To(ConsistOf(firstLease))
+		Expect(fake.liveLeases()).To(ConsistOf(fake.lastLease))
+		Expect(fake.leases[fake.lastLease].ttl).To(BeEquivalentTo(3600))
+
+		// The key outlives the expiration of the first save
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+		value, err := c.Get(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+	})
+
+	It("expires keys with their lease", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		_, err := c.Get(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+	})
+
+	It("revokes the lease of deleted keys", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(c.Del(ctx, "key")).To(Succeed())
+
+		Expect(fake.keys).To(BeEmpty())
+		Expect(fake.liveLeases()).To(BeEmpty())
+
+		_, err := c.Get(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+	})
+
+	It("returns the value of keys deleted with GetDel and revokes their lease", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+
+		value, err := c.GetDel(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("value")))
+		Expect(fake.keys).To(BeEmpty())
+		Expect(fake.liveLeases()).To(BeEmpty())
+
+		_, err = c.GetDel(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+	})
+
+	It("only swaps a value that has not changed and revokes its lease", func() {
+		swapped, err := c.CompareAndSwap(ctx, "key", nil, []byte("first"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", nil, []byte("other"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", []byte("other"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", []byte("first"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		value, err := c.Get(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+		Expect(fake.liveLeases()).To(HaveLen(1))
+	})
+
+	Context("Lock", func() {
+		It("can only be obtained by one lock at a time", func() {
+			first := c.Lock("key")
+			second := c.Lock("key")
+
+			Expect(first.Obtain(ctx, time.Minute)).To(Succeed())
+			Expect(second.Obtain(ctx, time.Minute)).To(Equal(sessionsapi.ErrLockNotObtained))
+			Expect(second.Release(ctx)).To(Equal(sessionsapi.ErrNotLocked))
+
+			locked, err := second.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+
+			Expect(first.Release(ctx)).To(Succeed())
+			Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+		})
+
+		It("does not release or refresh a lock obtained by another lock after expiring", func() {
+			first := c.Lock("key")
+			second := c.Lock("key")
+
+			Expect(first.Obtain(ctx, time.Minute)).To(Succeed())
+			Expect(fake.FastForward(time.Minute)).To(Succeed())
+			Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+
+			Expect(first.Refresh(ctx, time.Minute)).To(Equal(sessionsapi.ErrNotLocked))
+			Expect(first.Release(ctx)).To(Equal(sessionsapi.ErrNotLocked))
+
+			locked, err := second.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+		})
+
+		It("moves the lock to a new lease when refreshed", func() {
+			l := c.Lock("key")
+			Expect(l.Obtain(ctx, time.Minute)).To(Succeed())
+			firstLease := fake.lastLease
+
+			Expect(l.Refresh(ctx, time.Hour)).To(Succeed())
+			Expect(fake.revoked).To(ConsistOf(firstLease))
+			Expect(fake.liveLeases()).To(ConsistOf(fake.lastLease))
+		})
+	})
+})
+
+var _ = Describe("Etcd SessionStore Load", func() {
+	var fake *fakeEtcd
+	var store *SessionStore
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		fake = newFakeEtcd()
+		store = &SessionStore{Client: newFakeClient(fake, "")}
+	})
+
+	It("does not report missing sessions as the store being unavailable", func() {
+		_, err := store.Load(ctx, "missing")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeFalse())
+	})
+
+	It("reports errors from etcd as the store being unavailable", func() {
+		fake.err = errors.New("context deadline exceeded")
+
+		_, err := store.Load(ctx, "key")
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeTrue())
+
+		err = store.Save(ctx, "key", []byte("value"), time.Minute)
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeTrue())
+	})
+
+	It("does not leave a lease behind when the put fails", func() {
+		f := &failingPutEtcd{fakeEtcd: fake}
+		store.Client = &client{kv: f, lease: f}
+
+		Expect(store.Save(ctx, "key", []byte("value"), time.Minute)).ToNot(Succeed())
+		Expect(fake.liveLeases()).To(BeEmpty())
+	})
+})
+
+// failingPutEtcd fails every put, after leases have been granted
+type failingPutEtcd struct {
+	*fakeEtcd
+}
+
+func (f *failingPutEtcd) Put(_ context.Context, _, _ string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	return nil, errors.New("etcdserver: request timed out")
+}
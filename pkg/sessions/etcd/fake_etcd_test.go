@@ -0,0 +1,251 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeEtcd is an in memory implementation of the parts of the etcd KV and
+// Lease APIs used by the client, with a clock that can be fast forwarded to
+// expire leases.
+// Options on the requests cannot be read, so puts are attached to the most
+// recently granted lease, as the client grants a lease before every put.
+type fakeEtcd struct {
+	// The embedded interfaces are nil, calling any method that is not
+	// implemented below will panic
+	clientv3.KV
+	clientv3.Lease
+
+	// err is returned by every request when set, to simulate an outage
+	err error
+
+	mutex     sync.Mutex
+	now       time.Time
+	revision  int64
+	lastLease clientv3.LeaseID
+	leases    map[clientv3.LeaseID]fakeLease
+	keys      map[string]*mvccpb.KeyValue
+	revoked   []clientv3.LeaseID
+}
+
+type fakeLease struct {
+	ttl     int64
+	expires time.Time
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		now:    time.Now(),
+		leases: make(map[clientv3.LeaseID]fakeLease),
+		keys:   make(map[string]*mvccpb.KeyValue),
+	}
+}
+
+// FastForward moves the clock of the fake forward, expiring any leases
+// that have reached their TTL along with their keys
+func (f *fakeEtcd) FastForward(d time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+	for id, lease := range f.leases {
+		if !f.now.Before(lease.expires) {
+			f.deleteLease(id)
+		}
+	}
+	return nil
+}
+
+// liveLeases returns the IDs of the leases that have not expired or been
+// revoked
+func (f *fakeEtcd) liveLeases() []clientv3.LeaseID {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ids := []clientv3.LeaseID{}
+	for id := range f.leases {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (f *fakeEtcd) Grant(_ context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastLease++
+	f.leases[f.lastLease] = fakeLease{
+		ttl:     ttl,
+		expires: f.now.Add(time.Duration(ttl) * time.Second),
+	}
+	return &clientv3.LeaseGrantResponse{ID: f.lastLease, TTL: ttl}, nil
+}
+
+func (f *fakeEtcd) Revoke(_ context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.revoked = append(f.revoked, id)
+	f.deleteLease(id)
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func (f *fakeEtcd) Get(_ context.Context, key string, _ ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	resp := &clientv3.GetResponse{}
+	if kv, ok := f.keys[key]; ok {
+		resp.Kvs = []*mvccpb.KeyValue{kv}
+		resp.Count = 1
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Put(_ context.Context, key, val string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return &clientv3.PutResponse{PrevKv: f.put(key, val)}, nil
+}
+
+func (f *fakeEtcd) Delete(_ context.Context, key string, _ ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	resp := &clientv3.DeleteResponse{}
+	if kv, ok := f.keys[key]; ok {
+		delete(f.keys, key)
+		resp.PrevKvs = []*mvccpb.KeyValue{kv}
+		resp.Deleted = 1
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Txn(_ context.Context) clientv3.Txn {
+	return &fakeTxn{etcd: f}
+}
+
+func (f *fakeEtcd) put(key, val string) *mvccpb.KeyValue {
+	f.revision++
+	prev := f.keys[key]
+	kv := &mvccpb.KeyValue{
+		Key:            []byte(key),
+		Value:          []byte(val),
+		Lease:          int64(f.lastLease),
+		CreateRevision: f.revision,
+		ModRevision:    f.revision,
+	}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+	}
+	f.keys[key] = kv
+	return prev
+}
+
+func (f *fakeEtcd) deleteLease(id clientv3.LeaseID) {
+	delete(f.leases, id)
+	for key, kv := range f.keys {
+		if clientv3.LeaseID(kv.Lease) == id {
+			delete(f.keys, key)
+		}
+	}
+}
+
+// fakeTxn supports comparing the create revision, mod revision or value of
+// keys for equality, and put and delete operations.
+type fakeTxn struct {
+	etcd *fakeEtcd
+	cmps []clientv3.Cmp
+	then []clientv3.Op
+	els  []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.then = append(t.then, ops...)
+	return t
+}
+
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.els = append(t.els, ops...)
+	return t
+}
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	f := t.etcd
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	succeeded := true
+	for _, cmp := range t.cmps {
+		if !t.compare(cmp) {
+			succeeded = false
+		}
+	}
+
+	ops := t.then
+	if !succeeded {
+		ops = t.els
+	}
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			f.put(string(op.KeyBytes()), string(op.ValueBytes()))
+		case op.IsDelete():
+			delete(f.keys, string(op.KeyBytes()))
+		default:
+			panic("unsupported transaction operation")
+		}
+	}
+	return &clientv3.TxnResponse{Succeeded: succeeded}, nil
+}
+
+func (t *fakeTxn) compare(cmp clientv3.Cmp) bool {
+	if cmp.Result != pb.Compare_EQUAL {
+		panic("unsupported transaction comparison")
+	}
+
+	kv := t.etcd.keys[string(cmp.Key)]
+	switch target := cmp.TargetUnion.(type) {
+	case *pb.Compare_CreateRevision:
+		if kv == nil {
+			return target.CreateRevision == 0
+		}
+		return kv.CreateRevision == target.CreateRevision
+	case *pb.Compare_ModRevision:
+		return kv != nil && kv.ModRevision == target.ModRevision
+	case *pb.Compare_Value:
+		return kv != nil && bytes.Equal(kv.Value, target.Value)
+	default:
+		panic("unsupported transaction comparison")
+	}
+}
@@ -0,0 +1,135 @@
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const LockSuffix = "lock"
+
+// Lock is a distributed lock held by creating a key in etcd.
+// The key is attached to a lease so that the lock expires if it is not
+// released, and holds a random token to identify the holder of the lock.
+type Lock struct {
+	kv      clientv3.KV
+	lease   clientv3.Lease
+	key     string
+	token   string
+	leaseID clientv3.LeaseID
+}
+
+// NewLock instantiate a new lock instance. This will not yet apply a lock on etcd side.
+// For that you have to call Obtain(ctx context.Context, expiration time.Duration)
+func NewLock(kv clientv3.KV, lease clientv3.Lease, key string) sessions.Lock {
+	return &Lock{
+		kv:    kv,
+		lease: lease,
+		key:   key,
+	}
+}
+
+// Obtain obtains a distributed lock on etcd for the configured key.
+func (l *Lock) Obtain(ctx context.Context, expiration time.Duration) error {
+	n, err := encryption.Nonce()
+	if err != nil {
+		return fmt.Errorf("unable to generate lock token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(n)
+
+	lease, err := l.lease.Grant(ctx, leaseTTL(expiration))
+	if err != nil {
+		return fmt.Errorf("unable to grant lease: %v", err)
+	}
+
+	// Only create the lock key if it does not already exist
+	resp, err := l.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(l.lockKey()), "=", 0)).
+		Then(clientv3.OpPut(l.lockKey(), token, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		_, _ = l.lease.Revoke(ctx, lease.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return sessions.ErrLockNotObtained
+	}
+
+	l.token = token
+	l.leaseID = lease.ID
+	return nil
+}
+
+// Refresh refreshes an already existing lock.
+// The lock is moved to a new lease with the new expiration, as a lease can
+// only be kept alive with the TTL it was granted with.
+func (l *Lock) Refresh(ctx context.Context, expiration time.Duration) error {
+	if l.leaseID == 0 {
+		return sessions.ErrNotLocked
+	}
+
+	lease, err := l.lease.Grant(ctx, leaseTTL(expiration))
+	if err != nil {
+		return fmt.Errorf("unable to grant lease: %v", err)
+	}
+
+	resp, err := l.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(l.lockKey()), "=", l.token)).
+		Then(clientv3.OpPut(l.lockKey(), l.token, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		_, _ = l.lease.Revoke(ctx, lease.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return sessions.ErrNotLocked
+	}
+
+	_, _ = l.lease.Revoke(ctx, l.leaseID)
+	l.leaseID = lease.ID
+	return nil
+}
+
+// Peek returns true, if the lock is still applied.
+func (l *Lock) Peek(ctx context.Context) (bool, error) {
+	resp, err := l.kv.Get(ctx, l.lockKey(), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// Release releases the lock on etcd side.
+func (l *Lock) Release(ctx context.Context) error {
+	if l.leaseID == 0 {
+		return sessions.ErrNotLocked
+	}
+
+	resp, err := l.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(l.lockKey()), "=", l.token)).
+		Then(clientv3.OpDelete(l.lockKey())).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	_, _ = l.lease.Revoke(ctx, l.leaseID)
+	l.leaseID = 0
+	if !resp.Succeeded {
+		return sessions.ErrNotLocked
+	}
+	return nil
+}
+
+func (l *Lock) lockKey() string {
+	return fmt.Sprintf("%s.%s", l.key, LockSuffix)
+}
@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -14,5 +15,17 @@ type Store interface {
 	Save(context.Context, string, []byte, time.Duration) error
 	Load(context.Context, string) ([]byte, error)
 	Clear(context.Context, string) error
+	// LoadAndClear loads and clears the value in a single atomic operation,
+	// so that a value can only be loaded once
+	LoadAndClear(context.Context, string) ([]byte, error)
+	// CompareAndSwap saves the value only if the current value is old, or if
+	// there is no current value when old is nil, in a single atomic
+	// operation. It reports whether the value was saved.
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error)
 	Lock(key string) sessions.Lock
 }
+
+// ErrStoreUnavailable should be wrapped by Store implementations when the
+// backing data store cannot be reached, as opposed to a session not being
+// found. This allows the Manager to apply the configured unavailable policy.
+var ErrStoreUnavailable = errors.New("session store unavailable")
@@ -0,0 +1,207 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// ErrSessionLimitReached is returned when a new session is saved for a user
+// who already has the maximum number of sessions and the eviction policy
+// rejects new sessions.
+var ErrSessionLimitReached = errors.New("maximum number of sessions reached for user")
+
+// indexEntry records a single session belonging to a user within the
+// user's session index.
+type indexEntry struct {
+	TicketID  string    `json:"id"`
+	CreatedAt time.Time `json:"ca"`
+}
+
+// sessionLimiter indexes sessions by user within the persistent Store and
+// enforces a maximum number of concurrent sessions for each user.
+type sessionLimiter struct {
+	store         Store
+	keyPrefix     string
+	maxSessions   int
+	singleSession bool
+	policy        string
+	expiration    time.Duration
+}
+
+// newSessionLimiter creates a sessionLimiter from the session options.
+// If no limit has been configured, nil is returned.
+func newSessionLimiter(store Store, sessionOpts *options.SessionOptions, cookieOpts *options.Cookie) *sessionLimiter {
+	if sessionOpts == nil || (sessionOpts.MaxPerUser <= 0 && !sessionOpts.SinglePerUser) {
+		return nil
+	}
+
+	return &sessionLimiter{
+		store:         store,
+		keyPrefix:     fmt.Sprintf("%s-user-", cookieOpts.Name),
+		maxSessions:   sessionOpts.MaxPerUser,
+		singleSession: sessionOpts.SinglePerUser,
+		policy:        sessionOpts.EvictionPolicy,
+		expiration:    cookieOpts.Expire,
+	}
+}
+
+// maxIndexUpdates is the number of times the user's session index is loaded
+// and updated before giving up, when it keeps being changed concurrently
+const maxIndexUpdates = 5
+
+// track ensures the ticket is recorded in the user's session index.
+// If the ticket is new and single sessions are enforced, all other sessions
+// of the user are cleared. Otherwise, if the user already has the maximum
+// number of active sessions, sessions are evicted oldest first, or the new
+// session is rejected, depending on the eviction policy.
+// The index is swapped atomically with the one it was loaded as, so that
+// concurrent logins of the user can't overwrite each other's entries, and
+// sessions are only cleared once their removal from the index is saved.
+func (l *sessionLimiter) track(ctx context.Context, s *sessions.SessionState, ticketID string) error {
+	identity := sessionIdentity(s)
+	if identity == "" {
+		// We can't index sessions without knowing who they belong to
+		return nil
+	}
+	key := l.indexKey(identity)
+
+	for attempt := 0; attempt < maxIndexUpdates; attempt++ {
+		data, entries, err := l.loadIndex(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		var removed []indexEntry
+		if !containsTicket(entries, ticketID) {
+			entries = l.pruneIndex(ctx, entries)
+
+			if l.singleSession {
+				entries, removed = []indexEntry{}, entries
+			} else if overflow := len(entries) - l.maxSessions + 1; overflow > 0 {
+				if l.policy == options.RejectSessionEvictionPolicy {
+					return ErrSessionLimitReached
+				}
+				entries, removed = oldestEntries(entries, overflow)
+			}
+
+			entries = append(entries, indexEntry{
+				TicketID:  ticketID,
+				CreatedAt: *s.CreatedAt,
+			})
+		}
+
+		// Always save the index so that its expiry is extended with the session
+		saved, err := l.saveIndex(ctx, key, data, entries)
+		if err != nil {
+			return err
+		}
+		if saved {
+			l.clearRemoved(ctx, removed)
+			return nil
+		}
+	}
+	return errors.New("error saving user session index: the index was changed concurrently too many times")
+}
+
+// oldestEntries splits the given number of the oldest entries from the
+// remaining index entries.
+func oldestEntries(entries []indexEntry, count int) ([]indexEntry, []indexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries[count:], entries[:count]
+}
+
+// clearRemoved clears the sessions removed from the index from the Store,
+// either because they were evicted or because the user has logged in with a
+// new single session. The new session is never removed, so it is not cleared.
+func (l *sessionLimiter) clearRemoved(ctx context.Context, removed []indexEntry) {
+	for _, entry := range removed {
+		if l.singleSession {
+			logger.Printf("Clearing session %s: the user has logged in with a new session", entry.TicketID)
+		} else {
+			logger.Printf("Evicting session %s: maximum number of sessions (%d) reached for user", entry.TicketID, l.maxSessions)
+		}
+		if err := l.store.Clear(ctx, entry.TicketID); err != nil {
+			logger.Errorf("Error clearing session %s: %v", entry.TicketID, err)
+		}
+	}
+}
+
+// pruneIndex removes any entries from the index whose sessions no longer
+// exist in the Store, eg. because they have expired or the user signed out.
+func (l *sessionLimiter) pruneIndex(ctx context.Context, entries []indexEntry) []indexEntry {
+	active := []indexEntry{}
+	for _, entry := range entries {
+		if _, err := l.store.Load(ctx, entry.TicketID); err == nil {
+			active = append(active, entry)
+		}
+	}
+	return active
+}
+
+// loadIndex loads the index of sessions for a user, along with the data it
+// was decoded from. A missing index is treated as an empty index, with nil
+// data.
+func (l *sessionLimiter) loadIndex(ctx context.Context, key string) ([]byte, []indexEntry, error) {
+	data, err := l.store.Load(ctx, key)
+	if err != nil {
+		// Stores do not distinguish missing keys from other errors. If the
+		// index does exist, it won't be swapped as if it was missing.
+		return nil, []indexEntry{}, nil
+	}
+
+	entries := []indexEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, nil, fmt.Errorf("error decoding user session index: %v", err)
+	}
+	return data, entries, nil
+}
+
+// saveIndex persists the index of sessions for a user if the stored index is
+// still the one loaded as the old data. It reports whether it was saved.
+func (l *sessionLimiter) saveIndex(ctx context.Context, key string, old []byte, entries []indexEntry) (bool, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return false, fmt.Errorf("error encoding user session index: %v", err)
+	}
+	saved, err := l.store.CompareAndSwap(ctx, key, old, data, l.expiration)
+	if err != nil {
+		return false, fmt.Errorf("error saving user session index: %v", err)
+	}
+	return saved, nil
+}
+
+// indexKey builds the Store key for a user's session index.
+// The identity is hashed so that it is not stored in plain text.
+func (l *sessionLimiter) indexKey(identity string) string {
+	hash := sha256.Sum256([]byte(identity))
+	return l.keyPrefix + hex.EncodeToString(hash[:])
+}
+
+// sessionIdentity determines which user a session belongs to.
+func sessionIdentity(s *sessions.SessionState) string {
+	if s.Email != "" {
+		return s.Email
+	}
+	return s.User
+}
+
+func containsTicket(entries []indexEntry, ticketID string) bool {
+	for _, entry := range entries {
+		if entry.TicketID == ticketID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Limiter Tests", func() {
+	var ms *tests.MockStore
+	var ctx context.Context
+	cookieOpts := &options.Cookie{
+		Name:   "_oauth2_proxy",
+		Expire: time.Hour,
+	}
+
+	// saveSession mimics the Manager by tracking and then storing a session
+	saveSession := func(l *sessionLimiter, ticketID string, email string, createdAt time.Time) error {
+		s := &sessions.SessionState{Email: email, CreatedAt: &createdAt}
+		if err := l.track(ctx, s, ticketID); err != nil {
+			return err
+		}
+		return ms.Save(ctx, ticketID, []byte("session"), time.Hour)
+	}
+
+	sessionExists := func(ticketID string) bool {
+		_, err := ms.Load(ctx, ticketID)
+		return err == nil
+	}
+
+	BeforeEach(func() {
+		ms = tests.NewMockStore()
+		ctx = context.Background()
+	})
+
+	It("is not created when no limit is configured", func() {
+		Expect(newSessionLimiter(ms, &options.SessionOptions{}, cookieOpts)).To(BeNil())
+	})
+
+	Context("with the oldest eviction policy", func() {
+		var limiter *sessionLimiter
+
+		BeforeEach(func() {
+			limiter = newSessionLimiter(ms, &options.SessionOptions{
+				MaxPerUser:     2,
+				EvictionPolicy: options.OldestSessionEvictionPolicy,
+			}, cookieOpts)
+			Expect(limiter).ToNot(BeNil())
+		})
+
+		It("evicts the oldest session when the limit is exceeded", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now.Add(-1*time.Minute))).To(Succeed())
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now.Add(-2*time.Minute))).To(Succeed())
+			Expect(saveSession(limiter, "ticket-3", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeFalse())
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+			Expect(sessionExists("ticket-3")).To(BeTrue())
+		})
+
+		It("does not count sessions that are saved again", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeTrue())
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+		})
+
+		It("does not count sessions that no longer exist", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+			Expect(ms.Clear(ctx, "ticket-1")).To(Succeed())
+			Expect(saveSession(limiter, "ticket-3", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+			Expect(sessionExists("ticket-3")).To(BeTrue())
+		})
+
+		It("limits each user independently", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-3", "bar@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeTrue())
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+			Expect(sessionExists("ticket-3")).To(BeTrue())
+		})
+	})
+
+	Context("with the reject eviction policy", func() {
+		var limiter *sessionLimiter
+
+		BeforeEach(func() {
+			limiter = newSessionLimiter(ms, &options.SessionOptions{
+				MaxPerUser:     1,
+				EvictionPolicy: options.RejectSessionEvictionPolicy,
+			}, cookieOpts)
+			Expect(limiter).ToNot(BeNil())
+		})
+
+		It("rejects new sessions when the limit is reached", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(MatchError(ErrSessionLimitReached))
+
+			Expect(sessionExists("ticket-1")).To(BeTrue())
+			Expect(sessionExists("ticket-2")).To(BeFalse())
+		})
+
+		It("allows existing sessions to be saved again", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+		})
+	})
+
+	Context("with single sessions per user", func() {
+		var limiter *sessionLimiter
+
+		BeforeEach(func() {
+			limiter = newSessionLimiter(ms, &options.SessionOptions{
+				SinglePerUser:  true,
+				EvictionPolicy: options.RejectSessionEvictionPolicy,
+			}, cookieOpts)
+			Expect(limiter).ToNot(BeNil())
+		})
+
+		It("clears the other sessions of the user when a new session is saved", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "bar@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-3", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeFalse())
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+			Expect(sessionExists("ticket-3")).To(BeTrue())
+		})
+
+		It("does not clear sessions that are saved again", func() {
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeTrue())
+		})
+
+		It("takes precedence over the session limit", func() {
+			limiter = newSessionLimiter(ms, &options.SessionOptions{
+				MaxPerUser:     3,
+				SinglePerUser:  true,
+				EvictionPolicy: options.OldestSessionEvictionPolicy,
+			}, cookieOpts)
+
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeFalse())
+			Expect(sessionExists("ticket-2")).To(BeTrue())
+		})
+	})
+
+	Context("with concurrent logins", func() {
+		var rs *racingStore
+
+		// indexedTickets returns the tickets in the user's session index
+		indexedTickets := func(l *sessionLimiter, email string) []string {
+			_, entries, err := l.loadIndex(ctx, l.indexKey(email))
+			Expect(err).ToNot(HaveOccurred())
+			tickets := []string{}
+			for _, entry := range entries {
+				tickets = append(tickets, entry.TicketID)
+			}
+			return tickets
+		}
+
+		BeforeEach(func() {
+			rs = &racingStore{MockStore: ms}
+		})
+
+		It("does not lose the entries of sessions saved concurrently", func() {
+			limiter := newSessionLimiter(rs, &options.SessionOptions{
+				MaxPerUser:     3,
+				EvictionPolicy: options.OldestSessionEvictionPolicy,
+			}, cookieOpts)
+
+			now := time.Now()
+			rs.race = func() {
+				Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+			}
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+
+			Expect(indexedTickets(limiter, "foo@example.com")).To(ConsistOf("ticket-1", "ticket-2"))
+		})
+
+		It("keeps only the session that is indexed last with single sessions per user", func() {
+			limiter := newSessionLimiter(rs, &options.SessionOptions{
+				SinglePerUser:  true,
+				EvictionPolicy: options.RejectSessionEvictionPolicy,
+			}, cookieOpts)
+
+			now := time.Now()
+			Expect(saveSession(limiter, "ticket-1", "foo@example.com", now)).To(Succeed())
+			rs.race = func() {
+				Expect(saveSession(limiter, "ticket-2", "foo@example.com", now)).To(Succeed())
+			}
+			Expect(saveSession(limiter, "ticket-3", "foo@example.com", now)).To(Succeed())
+
+			Expect(sessionExists("ticket-1")).To(BeFalse())
+			Expect(sessionExists("ticket-2")).To(BeFalse())
+			Expect(sessionExists("ticket-3")).To(BeTrue())
+			Expect(indexedTickets(limiter, "foo@example.com")).To(ConsistOf("ticket-3"))
+		})
+
+		It("gives up when the index keeps changing", func() {
+			limiter := newSessionLimiter(rs, &options.SessionOptions{
+				MaxPerUser:     100,
+				EvictionPolicy: options.OldestSessionEvictionPolicy,
+			}, cookieOpts)
+
+			now := time.Now()
+			count := 0
+			var race func()
+			race = func() {
+				count++
+				Expect(saveSession(limiter, fmt.Sprintf("other-%d", count), "foo@example.com", now)).To(Succeed())
+				rs.race = race
+			}
+			rs.race = race
+
+			err := limiter.track(ctx, &sessions.SessionState{Email: "foo@example.com", CreatedAt: &now}, "ticket-1")
+			Expect(err).To(MatchError("error saving user session index: the index was changed concurrently too many times"))
+			Expect(indexedTickets(limiter, "foo@example.com")).ToNot(ContainElement("ticket-1"))
+		})
+	})
+})
+
+// racingStore runs race just before the next swap of a value, once, as if
+// another instance had changed the value concurrently
+type racingStore struct {
+	*tests.MockStore
+	race func()
+}
+
+func (s *racingStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error) {
+	if race := s.race; race != nil {
+		s.race = nil
+		race()
+	}
+	return s.MockStore.CompareAndSwap(ctx, key, old, value, exp)
+}
@@ -1,28 +1,73 @@
 package persistence
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
 )
 
+// fallbackCookieSuffix is appended to the cookie name to name the cookie
+// that holds a copy of the session for the cookie-fallback unavailable policy
+const fallbackCookieSuffix = "_fallback"
+
 // Manager wraps a Store and handles the implementation details of the
 // sessions.SessionStore with its use of session tickets
 type Manager struct {
 	Store   Store
 	Options *options.Cookie
+
+	limiter           *sessionLimiter
+	encryptTokensOnly bool
+	signedOnly        bool
+	expiryJitter      time.Duration
+	fallback          sessions.SessionStore
 }
 
 // NewManager creates a Manager that can wrap a Store and manage the
 // sessions.SessionStore implementation details
-func NewManager(store Store, cookieOpts *options.Cookie) *Manager {
+func NewManager(store Store, sessionOpts *options.SessionOptions, cookieOpts *options.Cookie) (*Manager, error) {
+	fallback, err := newFallbackStore(sessionOpts, cookieOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating fallback cookie session store: %v", err)
+	}
+
 	return &Manager{
-		Store:   store,
-		Options: cookieOpts,
+		Store:             store,
+		Options:           cookieOpts,
+		limiter:           newSessionLimiter(store, sessionOpts, cookieOpts),
+		encryptTokensOnly: sessionOpts != nil && sessionOpts.EncryptTokensOnly,
+		signedOnly:        sessionOpts != nil && sessionOpts.SignedOnly,
+		expiryJitter:      getExpiryJitter(sessionOpts),
+		fallback:          fallback,
+	}, nil
+}
+
+// newFallbackStore creates a cookie session store to keep a copy of each
+// session in, when the cookie-fallback unavailable policy is configured.
+// The copy is stored in a separate cookie from the session ticket.
+func newFallbackStore(sessionOpts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	if sessionOpts == nil || sessionOpts.UnavailablePolicy != options.CookieFallbackUnavailablePolicy {
+		return nil, nil
+	}
+
+	fallbackOpts := *cookieOpts
+	fallbackOpts.Name = cookieOpts.Name + fallbackCookieSuffix
+	return cookie.NewCookieSessionStore(sessionOpts, &fallbackOpts)
+}
+
+func getExpiryJitter(sessionOpts *options.SessionOptions) time.Duration {
+	if sessionOpts == nil {
+		return 0
 	}
+	return sessionOpts.ExpiryJitter
 }
 
 // Save saves a session in a persistent Store. Save will generate (or reuse an
@@ -40,15 +85,47 @@ func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.Se
 			return fmt.Errorf("error creating a session ticket: %v", err)
 		}
 	}
+	tckt.encryptTokensOnly = m.encryptTokensOnly
+	tckt.signedOnly = m.signedOnly
+
+	if m.limiter != nil {
+		if err := m.limiter.track(req.Context(), s, tckt.id); err != nil {
+			return err
+		}
+	}
 
-	err = tckt.saveSession(s, func(key string, val []byte, exp time.Duration) error {
+	// The same expiration must be used for both the Store and the cookie so
+	// that neither outlives the other
+	expires := m.sessionExpiration()
+	err = tckt.saveSession(s, expires, func(key string, val []byte, exp time.Duration) error {
 		return m.Store.Save(req.Context(), key, val, exp)
 	})
 	if err != nil {
+		// New sessions are never saved only to the fallback cookie, so that
+		// there is always a copy in the Store that can be revoked
 		return err
 	}
 
-	return tckt.setCookie(rw, req, s)
+	if err := tckt.setCookie(rw, req, s, expires); err != nil {
+		return err
+	}
+
+	if m.fallback != nil {
+		return m.fallback.Save(rw, req, s)
+	}
+	return nil
+}
+
+// sessionExpiration determines how long a session should be stored for.
+// When a jitter is configured, a random duration up to the jitter is taken off
+// the cookie expiry so that sessions created at the same time do not all
+// expire at once. The jitter is never added, so sessions cannot outlive the
+// cookie expiry.
+func (m *Manager) sessionExpiration() time.Duration {
+	if m.expiryJitter <= 0 {
+		return m.Options.Expire
+	}
+	return m.Options.Expire - time.Duration(rand.Int63n(int64(m.expiryJitter)+1))
 }
 
 // Load reads sessions.SessionState information from a session store. It will
@@ -58,13 +135,36 @@ func (m *Manager) Load(req *http.Request) (*sessions.SessionState, error) {
 	if err != nil {
 		return nil, err
 	}
+	tckt.encryptTokensOnly = m.encryptTokensOnly
+	tckt.signedOnly = m.signedOnly
 
-	return tckt.loadSession(
+	var storeErr error
+	session, err := tckt.loadSession(
 		func(key string) ([]byte, error) {
-			return m.Store.Load(req.Context(), key)
+			value, err := m.Store.Load(req.Context(), key)
+			storeErr = err
+			return value, err
 		},
 		m.Store.Lock,
 	)
+	if err != nil && m.fallback != nil && errors.Is(storeErr, ErrStoreUnavailable) {
+		return m.loadFallback(req, err)
+	}
+	return session, err
+}
+
+// loadFallback loads the copy of the session from the fallback cookie.
+// This is only used when the request has a valid session ticket but the Store
+// is unavailable. Sessions that are missing from the Store, eg. because they
+// have been cleared, are never loaded from the fallback cookie.
+func (m *Manager) loadFallback(req *http.Request, storeErr error) (*sessions.SessionState, error) {
+	session, err := m.fallback.Load(req)
+	if err != nil {
+		logger.Errorf("Session store unavailable and the fallback cookie could not be loaded: %v", err)
+		return nil, storeErr
+	}
+	logger.Errorf("WARNING: Session store unavailable, loading the session from the fallback cookie: %v", storeErr)
+	return session, nil
 }
 
 // Clear clears any saved session information for a given ticket cookie.
@@ -78,6 +178,9 @@ func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
 			options: m.Options,
 		}
 		tckt.clearCookie(rw, req)
+		if clearErr := m.clearFallback(rw, req); clearErr != nil {
+			return clearErr
+		}
 		// Don't raise an error if we didn't have a Cookie
 		if err == http.ErrNoCookie {
 			return nil
@@ -86,7 +189,28 @@ func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
 	}
 
 	tckt.clearCookie(rw, req)
+	if err := m.clearFallback(rw, req); err != nil {
+		return err
+	}
 	return tckt.clearSession(func(key string) error {
 		return m.Store.Clear(req.Context(), key)
 	})
 }
+
+// clearFallback clears the fallback cookie, if the cookie-fallback unavailable
+// policy is configured
+func (m *Manager) clearFallback(rw http.ResponseWriter, req *http.Request) error {
+	if m.fallback == nil {
+		return nil
+	}
+	return m.fallback.Clear(rw, req)
+}
+
+// Close closes the connection to the Store, if the Store holds one
+func (m *Manager) Close() error {
+	closer, ok := m.Store.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
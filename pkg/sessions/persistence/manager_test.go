@@ -1,12 +1,17 @@
 package persistence
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Persistence Manager Tests", func() {
@@ -15,11 +20,249 @@ var _ = Describe("Persistence Manager Tests", func() {
 		ms = tests.NewMockStore()
 	})
 	tests.RunSessionStoreTests(
-		func(_ *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
-			return NewManager(ms, cookieOpts), nil
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			return NewManager(ms, opts, cookieOpts)
 		},
 		func(d time.Duration) error {
 			ms.FastForward(d)
 			return nil
 		})
 })
+
+var _ = Describe("Persistence Manager Expiry Jitter", func() {
+	const (
+		expire = 168 * time.Hour
+		jitter = time.Hour
+	)
+
+	var ms *tests.MockStore
+	var manager *Manager
+
+	BeforeEach(func() {
+		ms = tests.NewMockStore()
+		var err error
+		manager, err = NewManager(ms, &options.SessionOptions{
+			ExpiryJitter: jitter,
+		}, &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdefghijklmnopqrstuv",
+			Expire: expire,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("keeps session expiry within the jitter of the cookie expiry", func() {
+		expirations := map[time.Duration]struct{}{}
+		for i := 0; i < 1000; i++ {
+			expires := manager.sessionExpiration()
+			Expect(expires).To(BeNumerically("<=", expire))
+			Expect(expires).To(BeNumerically(">=", expire-jitter))
+			expirations[expires] = struct{}{}
+		}
+		// The expiry should vary between sessions
+		Expect(len(expirations)).To(BeNumerically(">", 1))
+	})
+
+	It("uses the cookie expiry when no jitter is configured", func() {
+		manager.expiryJitter = 0
+		Expect(manager.sessionExpiration()).To(Equal(expire))
+	})
+
+	It("applies the same expiry to the cookie and the Store", func() {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		rw := httptest.NewRecorder()
+
+		createdAt := time.Now().Truncate(time.Second)
+		ss := &sessionsapi.SessionState{Email: "foo@example.com", CreatedAt: &createdAt}
+		Expect(manager.Save(rw, req, ss)).To(Succeed())
+
+		cookies := rw.Result().Cookies()
+		Expect(cookies).To(HaveLen(1))
+		cookieExpires := cookies[0].Expires.Sub(createdAt)
+		Expect(cookieExpires).To(BeNumerically("<=", expire))
+		Expect(cookieExpires).To(BeNumerically(">=", expire-jitter))
+
+		loadReq := httptest.NewRequest("GET", "http://example.com/", nil)
+		loadReq.AddCookie(cookies[0])
+
+		// The session should remain in the Store until the cookie expires.
+		// Cookie expiry has a resolution of a second.
+		ms.FastForward(cookieExpires - time.Second)
+		_, err := manager.Load(loadReq)
+		Expect(err).ToNot(HaveOccurred())
+
+		ms.FastForward(2 * time.Second)
+		_, err = manager.Load(loadReq)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Persistence Manager Unavailable Policy", func() {
+	var store *unavailableStore
+
+	BeforeEach(func() {
+		store = &unavailableStore{MockStore: tests.NewMockStore()}
+	})
+
+	// saveSession saves a session with the given policy and returns a request
+	// carrying the cookies that were set
+	saveSession := func(policy string) (*Manager, *http.Request) {
+		manager, err := NewManager(store, &options.SessionOptions{
+			UnavailablePolicy: policy,
+		}, &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdefghijklmnopqrstuv",
+			Expire: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		ss := &sessionsapi.SessionState{Email: "foo@example.com"}
+		Expect(manager.Save(rw, httptest.NewRequest("GET", "http://example.com/", nil), ss)).To(Succeed())
+
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		for _, c := range rw.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		return manager, req
+	}
+
+	Context("with the fail-closed policy", func() {
+		It("does not set a fallback cookie", func() {
+			_, req := saveSession(options.FailClosedUnavailablePolicy)
+			Expect(req.Cookies()).To(HaveLen(1))
+		})
+
+		It("fails to load sessions while the Store is unavailable", func() {
+			manager, req := saveSession(options.FailClosedUnavailablePolicy)
+			store.unavailable = true
+
+			_, err := manager.Load(req)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("with the cookie-fallback policy", func() {
+		It("sets a fallback cookie", func() {
+			_, req := saveSession(options.CookieFallbackUnavailablePolicy)
+			_, err := req.Cookie("_oauth2_proxy" + fallbackCookieSuffix)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("loads sessions from the Store while it is available", func() {
+			manager, req := saveSession(options.CookieFallbackUnavailablePolicy)
+
+			ss, err := manager.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ss.Email).To(Equal("foo@example.com"))
+			Expect(ss.Lock).ToNot(BeNil())
+		})
+
+		It("loads sessions from the fallback cookie while the Store is unavailable", func() {
+			manager, req := saveSession(options.CookieFallbackUnavailablePolicy)
+			store.unavailable = true
+
+			ss, err := manager.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ss.Email).To(Equal("foo@example.com"))
+		})
+
+		It("does not load sessions missing from the Store from the fallback cookie", func() {
+			manager, req := saveSession(options.CookieFallbackUnavailablePolicy)
+			tckt, err := decodeTicketFromRequest(req, manager.Options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Clear(context.Background(), tckt.id)).To(Succeed())
+
+			_, err = manager.Load(req)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails to load sessions without a fallback cookie while the Store is unavailable", func() {
+			manager, req := saveSession(options.CookieFallbackUnavailablePolicy)
+			ticketCookie, err := req.Cookie("_oauth2_proxy")
+			Expect(err).ToNot(HaveOccurred())
+			store.unavailable = true
+
+			ticketOnly := httptest.NewRequest("GET", "http://example.com/", nil)
+			ticketOnly.AddCookie(ticketCookie)
+			_, err = manager.Load(ticketOnly)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("does not save new sessions while the Store is unavailable", func() {
+			manager, _ := saveSession(options.CookieFallbackUnavailablePolicy)
+			store.unavailable = true
+
+			rw := httptest.NewRecorder()
+			ss := &sessionsapi.SessionState{Email: "bar@example.com"}
+			Expect(manager.Save(rw, httptest.NewRequest("GET", "http://example.com/", nil), ss)).ToNot(Succeed())
+			Expect(rw.Result().Cookies()).To(BeEmpty())
+		})
+
+		It("clears the fallback cookie", func() {
+			manager, req := saveSession(options.CookieFallbackUnavailablePolicy)
+
+			rw := httptest.NewRecorder()
+			Expect(manager.Clear(rw, req)).To(Succeed())
+
+			cleared := map[string]bool{}
+			for _, c := range rw.Result().Cookies() {
+				cleared[c.Name] = c.MaxAge < 0 || c.Expires.Before(time.Now())
+			}
+			Expect(cleared).To(Equal(map[string]bool{
+				"_oauth2_proxy":                        true,
+				"_oauth2_proxy" + fallbackCookieSuffix: true,
+			}))
+		})
+	})
+})
+
+// unavailableStore wraps a MockStore so that outages of the Store can be
+// simulated
+type unavailableStore struct {
+	*tests.MockStore
+	unavailable bool
+}
+
+func (s *unavailableStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	if s.unavailable {
+		return fmt.Errorf("%w: connection refused", ErrStoreUnavailable)
+	}
+	return s.MockStore.Save(ctx, key, value, exp)
+}
+
+func (s *unavailableStore) Load(ctx context.Context, key string) ([]byte, error) {
+	if s.unavailable {
+		return nil, fmt.Errorf("%w: connection refused", ErrStoreUnavailable)
+	}
+	return s.MockStore.Load(ctx, key)
+}
+
+var _ = Describe("Persistence Manager Close", func() {
+	It("closes a Store that holds a connection", func() {
+		store := &closingStore{MockStore: tests.NewMockStore()}
+		manager, err := NewManager(store, &options.SessionOptions{}, &options.Cookie{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(manager.Close()).To(Succeed())
+		Expect(store.closed).To(BeTrue())
+	})
+
+	It("does nothing for a Store without a connection", func() {
+		manager, err := NewManager(tests.NewMockStore(), &options.SessionOptions{}, &options.Cookie{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(manager.Close()).To(Succeed())
+	})
+})
+
+// closingStore wraps a MockStore to record whether it has been closed
+type closingStore struct {
+	*tests.MockStore
+	closed bool
+}
+
+func (s *closingStore) Close() error {
+	s.closed = true
+	return nil
+}
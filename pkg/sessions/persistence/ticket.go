@@ -41,6 +41,14 @@ type ticket struct {
 	id      string
 	secret  []byte
 	options *options.Cookie
+
+	// encryptTokensOnly determines whether only the tokens within the session
+	// are encrypted with the ticket's secret.
+	encryptTokensOnly bool
+
+	// signedOnly determines whether the session is signed with the ticket's
+	// secret, without being encrypted.
+	signedOnly bool
 }
 
 // newTicket creates a new ticket. The ID & secret will be randomly created
@@ -110,17 +118,25 @@ func decodeTicketFromRequest(req *http.Request, cookieOpts *options.Cookie) (*ti
 }
 
 // saveSession encodes the SessionState with the ticket's secret and persists
-// it to disk via the passed saveFunc.
-func (t *ticket) saveSession(s *sessions.SessionState, saver saveFunc) error {
+// it to disk via the passed saveFunc, to expire after the given duration.
+func (t *ticket) saveSession(s *sessions.SessionState, expires time.Duration, saver saveFunc) error {
 	c, err := t.makeCipher()
 	if err != nil {
 		return err
 	}
-	ciphertext, err := s.EncodeSessionState(c, false)
+	var ciphertext []byte
+	switch {
+	case t.signedOnly:
+		ciphertext, err = s.EncodeSessionStateSignedOnly(t.secret, false)
+	case t.encryptTokensOnly:
+		ciphertext, err = s.EncodeSessionStateWithEncryptedTokens(c, t.secret, false)
+	default:
+		ciphertext, err = s.EncodeSessionState(c, false)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to encode the session state with the ticket: %v", err)
 	}
-	return saver(t.id, ciphertext, t.options.Expire)
+	return saver(t.id, ciphertext, expires)
 }
 
 // loadSession loads a session from the disk store via the passed loadFunc
@@ -137,7 +153,17 @@ func (t *ticket) loadSession(loader loadFunc, initLock initLockFunc) (*sessions.
 		return nil, err
 	}
 
-	sessionState, err := sessions.DecodeSessionState(ciphertext, c, false)
+	// Signed only sessions are loaded regardless of signedOnly, so that
+	// sessions do not need to be cleared when it is changed
+	var sessionState *sessions.SessionState
+	switch {
+	case sessions.IsSessionStateSignedOnly(ciphertext):
+		sessionState, err = sessions.DecodeSessionStateSignedOnly(ciphertext, t.secret, false)
+	case t.encryptTokensOnly:
+		sessionState, err = sessions.DecodeSessionStateWithEncryptedTokens(ciphertext, c, t.secret, false)
+	default:
+		sessionState, err = sessions.DecodeSessionState(ciphertext, c, false)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -152,12 +178,13 @@ func (t *ticket) clearSession(clearer clearFunc) error {
 	return clearer(t.id)
 }
 
-// setCookie sets the encoded ticket as a cookie
-func (t *ticket) setCookie(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
+// setCookie sets the encoded ticket as a cookie, to expire after the given
+// duration
+func (t *ticket) setCookie(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState, expires time.Duration) error {
 	ticketCookie, err := t.makeCookie(
 		req,
 		t.encodeTicket(),
-		t.options.Expire,
+		expires,
 		*s.CreatedAt,
 	)
 	if err != nil {
@@ -185,7 +212,7 @@ func (t *ticket) clearCookie(rw http.ResponseWriter, req *http.Request) {
 func (t *ticket) makeCookie(req *http.Request, value string, expires time.Duration, now time.Time) (*http.Cookie, error) {
 	if value != "" {
 		var err error
-		value, err = encryption.SignedValue(t.options.Secret, t.options.Name, []byte(value), now)
+		value, err = encryption.SignedValue(t.options.Secret, t.options.Name, []byte(value), now, cookies.ValueEncoding(t.options))
 		if err != nil {
 			return nil, err
 		}
@@ -71,7 +71,7 @@ var _ = Describe("Session Ticket Tests", func() {
 
 			ss := &sessions.SessionState{User: "foobar"}
 			store := map[string][]byte{}
-			err = t.saveSession(ss, func(k string, v []byte, e time.Duration) error {
+			err = t.saveSession(ss, time.Hour, func(k string, v []byte, e time.Duration) error {
 				store[k] = v
 				return nil
 			})
@@ -88,6 +88,7 @@ var _ = Describe("Session Ticket Tests", func() {
 
 			err = t.saveSession(
 				&sessions.SessionState{User: "foobar"},
+				time.Hour,
 				func(k string, v []byte, e time.Duration) error {
 					return errors.New("save error")
 				})
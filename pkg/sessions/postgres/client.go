@@ -0,0 +1,180 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// ErrKeyNotFound is returned by the Client when a key does not exist or has
+// expired.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Client is wrapper interface for the PostgreSQL database.
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Lock(key string) sessions.Lock
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Del(ctx context.Context, key string) error
+	GetDel(ctx context.Context, key string) ([]byte, error)
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+	Close() error
+}
+
+var _ Client = (*client)(nil)
+
+// client stores each key as a row of the sessions table, with the time that
+// it expires at.
+// Expiry is computed with the clock of the database, rather than of each
+// oauth2-proxy instance, and expired rows are never returned even before
+// they are deleted.
+type client struct {
+	db      *sql.DB
+	queries queries
+}
+
+// queries are the statements used by the client, for the configured table.
+type queries struct {
+	createTable   string
+	createIndex   string
+	get           string
+	set           string
+	del           string
+	getDel        string
+	add           string
+	swap          string
+	deleteExpired string
+	obtainLock    string
+	refreshLock   string
+	releaseLock   string
+	peekLock      string
+}
+
+func newQueries(table string) queries {
+	t := pq.QuoteIdentifier(table)
+	// A key can be added when its row does not exist or has expired
+	add := fmt.Sprintf("INSERT INTO %s (key, value, expires_at) VALUES ($1, $2, now() + $3::bigint * interval '1 millisecond') "+
+		"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at WHERE %s.expires_at <= now()", t, t)
+	return queries{
+		createTable: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value BYTEA NOT NULL, expires_at TIMESTAMPTZ NOT NULL)", t),
+		createIndex: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)", pq.QuoteIdentifier(table+"_expires_at_idx"), t),
+		get:         fmt.Sprintf("SELECT value FROM %s WHERE key = $1 AND expires_at > now()", t),
+		set: fmt.Sprintf("INSERT INTO %s (key, value, expires_at) VALUES ($1, $2, now() + $3::bigint * interval '1 millisecond') "+
+			"ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at", t),
+		del:    fmt.Sprintf("DELETE FROM %s WHERE key = $1", t),
+		getDel: fmt.Sprintf("DELETE FROM %s WHERE key = $1 AND expires_at > now() RETURNING value", t),
+		add:    add,
+		swap: fmt.Sprintf("UPDATE %s SET value = $3, expires_at = now() + $4::bigint * interval '1 millisecond' "+
+			"WHERE key = $1 AND value = $2 AND expires_at > now()", t),
+		deleteExpired: fmt.Sprintf("DELETE FROM %s WHERE expires_at <= now()", t),
+		// A lock is obtained by adding its row
+		obtainLock:  add,
+		refreshLock: fmt.Sprintf("UPDATE %s SET expires_at = now() + $3::bigint * interval '1 millisecond' WHERE key = $1 AND value = $2 AND expires_at > now()", t),
+		releaseLock: fmt.Sprintf("DELETE FROM %s WHERE key = $1 AND value = $2 AND expires_at > now()", t),
+		peekLock:    fmt.Sprintf("SELECT count(*) FROM %s WHERE key = $1 AND expires_at > now()", t),
+	}
+}
+
+func newClient(db *sql.DB, table string) *client {
+	return &client{
+		db:      db,
+		queries: newQueries(table),
+	}
+}
+
+// createTable creates the sessions table, and the index used to delete
+// expired sessions, if they do not already exist.
+func (c *client) createTable(ctx context.Context) error {
+	if _, err := c.db.ExecContext(ctx, c.queries.createTable); err != nil {
+		return fmt.Errorf("unable to create sessions table: %v", err)
+	}
+	if _, err := c.db.ExecContext(ctx, c.queries.createIndex); err != nil {
+		return fmt.Errorf("unable to create sessions table index: %v", err)
+	}
+	return nil
+}
+
+func (c *client) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := c.db.QueryRowContext(ctx, c.queries.get, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set inserts the key, or replaces its value and expiry if it already exists
+func (c *client) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	_, err := c.db.ExecContext(ctx, c.queries.set, key, value, expiration.Milliseconds())
+	return err
+}
+
+func (c *client) Del(ctx context.Context, key string) error {
+	_, err := c.db.ExecContext(ctx, c.queries.del, key)
+	return err
+}
+
+// GetDel deletes the key, returning the value it had.
+// Only one of any concurrent deletes of the key gets the value. Expired rows
+// are left to be deleted by DeleteExpired.
+func (c *client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := c.db.QueryRowContext(ctx, c.queries.getDel, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// CompareAndSwap replaces the value and expiry of the key if its current value
+// is old, or inserts the key if it does not exist when old is nil.
+// Each is a single conditional statement, so only one of any concurrent
+// swaps from the same value succeeds.
+func (c *client) CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error) {
+	var result sql.Result
+	var err error
+	if old == nil {
+		result, err = c.db.ExecContext(ctx, c.queries.add, key, value, expiration.Milliseconds())
+	} else {
+		result, err = c.db.ExecContext(ctx, c.queries.swap, key, old, value, expiration.Milliseconds())
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// DeleteExpired deletes the rows of any expired keys, returning how many
+// were deleted.
+func (c *client) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := c.db.ExecContext(ctx, c.queries.deleteExpired)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c *client) Lock(key string) sessions.Lock {
+	return NewLock(c.db, c.queries, key)
+}
+
+// Close closes the pool of connections to the database
+func (c *client) Close() error {
+	return c.db.Close()
+}
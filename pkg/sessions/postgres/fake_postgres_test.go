@@ -0,0 +1,252 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// fakePostgres is an in memory implementation of the sessions table, which
+// executes the statements used by the client, with a clock that can be fast
+// forwarded to expire rows.
+// Statements are recognised by comparing them to the client's queries for
+// the table, any other statement returns an error.
+type fakePostgres struct {
+	queries queries
+
+	// err is returned by every statement when set, to simulate an outage
+	err error
+
+	mutex sync.Mutex
+	now   time.Time
+	rows  map[string]fakeRow
+}
+
+type fakeRow struct {
+	value   []byte
+	expires time.Time
+}
+
+func newFakePostgres(table string) *fakePostgres {
+	return &fakePostgres{
+		queries: newQueries(table),
+		now:     time.Now(),
+		rows:    make(map[string]fakeRow),
+	}
+}
+
+// FastForward moves the clock of the fake forward. Rows that have expired
+// are kept until they are deleted, as they are by the database.
+func (f *fakePostgres) FastForward(d time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+	return nil
+}
+
+// keys returns the keys of every row, including the expired rows
+func (f *fakePostgres) keys() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	keys := []string{}
+	for key := range f.rows {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// live returns the row for the key if it exists and has not expired
+func (f *fakePostgres) live(key string) (fakeRow, bool) {
+	row, ok := f.rows[key]
+	if !ok || !f.now.Before(row.expires) {
+		return fakeRow{}, false
+	}
+	return row, true
+}
+
+func (f *fakePostgres) set(key string, value []byte, milliseconds int64) {
+	f.rows[key] = fakeRow{
+		value:   append([]byte{}, value...),
+		expires: f.now.Add(time.Duration(milliseconds) * time.Millisecond),
+	}
+}
+
+func (f *fakePostgres) exec(query string, args []driver.Value) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	switch query {
+	case f.queries.createTable, f.queries.createIndex:
+		return 0, nil
+	case f.queries.set:
+		f.set(args[0].(string), args[1].([]byte), args[2].(int64))
+		return 1, nil
+	case f.queries.del:
+		if _, ok := f.rows[args[0].(string)]; !ok {
+			return 0, nil
+		}
+		delete(f.rows, args[0].(string))
+		return 1, nil
+	case f.queries.deleteExpired:
+		var deleted int64
+		for key, row := range f.rows {
+			if !f.now.Before(row.expires) {
+				delete(f.rows, key)
+				deleted++
+			}
+		}
+		return deleted, nil
+	case f.queries.add:
+		// Locks are obtained with the same statement
+		if _, ok := f.live(args[0].(string)); ok {
+			return 0, nil
+		}
+		f.set(args[0].(string), args[1].([]byte), args[2].(int64))
+		return 1, nil
+	case f.queries.swap:
+		row, ok := f.live(args[0].(string))
+		if !ok || !bytes.Equal(row.value, args[1].([]byte)) {
+			return 0, nil
+		}
+		f.set(args[0].(string), args[2].([]byte), args[3].(int64))
+		return 1, nil
+	case f.queries.refreshLock:
+		row, ok := f.live(args[0].(string))
+		if !ok || !bytes.Equal(row.value, args[1].([]byte)) {
+			return 0, nil
+		}
+		f.set(args[0].(string), row.value, args[2].(int64))
+		return 1, nil
+	case f.queries.releaseLock:
+		row, ok := f.live(args[0].(string))
+		if !ok || !bytes.Equal(row.value, args[1].([]byte)) {
+			return 0, nil
+		}
+		delete(f.rows, args[0].(string))
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unexpected statement: %s", query)
+}
+
+func (f *fakePostgres) query(query string, args []driver.Value) (*fakeRows, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	switch query {
+	case f.queries.get:
+		row, ok := f.live(args[0].(string))
+		if !ok {
+			return &fakeRows{columns: []string{"value"}}, nil
+		}
+		return &fakeRows{columns: []string{"value"}, values: [][]driver.Value{{row.value}}}, nil
+	case f.queries.getDel:
+		row, ok := f.live(args[0].(string))
+		if !ok {
+			return &fakeRows{columns: []string{"value"}}, nil
+		}
+		delete(f.rows, args[0].(string))
+		return &fakeRows{columns: []string{"value"}, values: [][]driver.Value{{row.value}}}, nil
+	case f.queries.peekLock:
+		var count int64
+		if _, ok := f.live(args[0].(string)); ok {
+			count = 1
+		}
+		return &fakeRows{columns: []string{"count"}, values: [][]driver.Value{{count}}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+// Connect implements driver.Connector, so that the fake can be opened with
+// sql.OpenDB
+func (f *fakePostgres) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{fake: f}, nil
+}
+
+// Driver implements driver.Connector
+func (f *fakePostgres) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("the fake can only be opened with sql.OpenDB")
+}
+
+// fakeConn executes statements directly, without preparing them
+type fakeConn struct {
+	fake *fakePostgres
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	n, err := c.fake.exec(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.fake.query(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		vals = append(vals, arg.Value)
+	}
+	return vals
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
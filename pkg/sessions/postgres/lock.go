@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+const LockSuffix = "lock"
+
+// Lock is a distributed lock held by inserting a row into the sessions
+// table.
+// The row expires like any other key so that the lock expires if it is not
+// released, and holds a random token to identify the holder of the lock.
+type Lock struct {
+	db      *sql.DB
+	queries queries
+	key     string
+	token   string
+}
+
+// NewLock instantiate a new lock instance. This will not yet apply a lock in the database.
+// For that you have to call Obtain(ctx context.Context, expiration time.Duration)
+func NewLock(db *sql.DB, queries queries, key string) sessions.Lock {
+	return &Lock{
+		db:      db,
+		queries: queries,
+		key:     key,
+	}
+}
+
+// Obtain obtains a distributed lock in the database for the configured key.
+func (l *Lock) Obtain(ctx context.Context, expiration time.Duration) error {
+	n, err := encryption.Nonce()
+	if err != nil {
+		return fmt.Errorf("unable to generate lock token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(n)
+
+	obtained, err := l.exec(ctx, l.queries.obtainLock, l.lockKey(), []byte(token), expiration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if !obtained {
+		return sessions.ErrLockNotObtained
+	}
+
+	l.token = token
+	return nil
+}
+
+// Refresh refreshes an already existing lock.
+func (l *Lock) Refresh(ctx context.Context, expiration time.Duration) error {
+	if l.token == "" {
+		return sessions.ErrNotLocked
+	}
+
+	refreshed, err := l.exec(ctx, l.queries.refreshLock, l.lockKey(), []byte(l.token), expiration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if !refreshed {
+		return sessions.ErrNotLocked
+	}
+	return nil
+}
+
+// Peek returns true, if the lock is still applied.
+func (l *Lock) Peek(ctx context.Context) (bool, error) {
+	var count int
+	if err := l.db.QueryRowContext(ctx, l.queries.peekLock, l.lockKey()).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Release releases the lock in the database.
+func (l *Lock) Release(ctx context.Context) error {
+	if l.token == "" {
+		return sessions.ErrNotLocked
+	}
+
+	released, err := l.exec(ctx, l.queries.releaseLock, l.lockKey(), []byte(l.token))
+	if err != nil {
+		return err
+	}
+
+	l.token = ""
+	if !released {
+		return sessions.ErrNotLocked
+	}
+	return nil
+}
+
+// exec runs a statement that applies to the lock's row, returning whether
+// the row was affected.
+func (l *Lock) exec(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	result, err := l.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (l *Lock) lockKey() string {
+	return fmt.Sprintf("%s.%s", l.key, LockSuffix)
+}
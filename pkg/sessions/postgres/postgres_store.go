@@ -0,0 +1,218 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+)
+
+const (
+	// setupTimeout bounds creating the sessions table when the client is
+	// constructed
+	setupTimeout = 30 * time.Second
+
+	// reapTimeout bounds each deletion of the expired sessions
+	reapTimeout = time.Minute
+)
+
+// SessionStore is an implementation of the persistence.Store
+// interface that stores sessions in PostgreSQL
+type SessionStore struct {
+	Client Client
+
+	stopReaper chan struct{}
+	reaperDone chan struct{}
+}
+
+// NewPostgresSessionStore initialises a new instance of the SessionStore and
+// wraps it in a persistence.Manager
+func NewPostgresSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	client, err := NewPostgresClient(opts.Postgres)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing postgres client: %v", err)
+	}
+
+	ps := &SessionStore{
+		Client: client,
+	}
+	if opts.Postgres.ReapInterval > 0 {
+		ps.startReaper(opts.Postgres.ReapInterval)
+	}
+	return persistence.NewManager(ps, opts, cookieOpts)
+}
+
+// Save takes a sessions.SessionState and stores the information from it
+// to PostgreSQL, with an expiry of exp from now
+func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	err := store.Client.Set(ctx, key, value, exp)
+	if err != nil {
+		return fmt.Errorf("error saving postgres session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// Load reads sessions.SessionState information from PostgreSQL
+func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.Get(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("error loading postgres session: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading postgres session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
+
+// Clear clears any saved session information for a given persistence cookie
+// from PostgreSQL, and then clears the session
+func (store *SessionStore) Clear(ctx context.Context, key string) error {
+	err := store.Client.Del(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error clearing the session from postgres: %v", err)
+	}
+	return nil
+}
+
+// LoadAndClear reads and clears the value from PostgreSQL atomically
+func (store *SessionStore) LoadAndClear(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.GetDel(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("error loading postgres session: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading postgres session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
+
+// CompareAndSwap saves the value to PostgreSQL if the current value is old, or
+// if there is no current value when old is nil
+func (store *SessionStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error) {
+	swapped, err := store.Client.CompareAndSwap(ctx, key, old, value, exp)
+	if err != nil {
+		return false, fmt.Errorf("error saving postgres session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return swapped, nil
+}
+
+// Lock creates a lock object for sessions.SessionState
+func (store *SessionStore) Lock(key string) sessions.Lock {
+	return store.Client.Lock(key)
+}
+
+// Close stops the reaper and closes the connections to PostgreSQL
+func (store *SessionStore) Close() error {
+	if store.stopReaper != nil {
+		close(store.stopReaper)
+		<-store.reaperDone
+		store.stopReaper = nil
+	}
+	return store.Client.Close()
+}
+
+// startReaper deletes the expired sessions every interval until the store
+// is closed.
+// Expired sessions are never loaded, so this only stops the table growing.
+func (store *SessionStore) startReaper(interval time.Duration) {
+	store.stopReaper = make(chan struct{})
+	store.reaperDone = make(chan struct{})
+
+	go func() {
+		defer close(store.reaperDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-store.stopReaper:
+				return
+			case <-ticker.C:
+				store.reap()
+			}
+		}
+	}()
+}
+
+func (store *SessionStore) reap() {
+	ctx, cancel := context.WithTimeout(context.Background(), reapTimeout)
+	defer cancel()
+
+	deleted, err := store.Client.DeleteExpired(ctx)
+	if err != nil {
+		logger.Errorf("unable to delete expired postgres sessions: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logger.Printf("deleted %d expired postgres sessions", deleted)
+	}
+}
+
+// NewPostgresClient makes a Client with a pool of connections to the
+// PostgreSQL database, and creates the sessions table if it does not exist
+func NewPostgresClient(opts options.PostgresStoreOptions) (Client, error) {
+	connectionURL, err := buildConnectionURL(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	// Keep the connections open between requests rather than reconnecting
+	db.SetMaxIdleConns(opts.MaxOpenConns)
+
+	c := newClient(db, opts.Table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	if err := c.createTable(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// buildConnectionURL adds the TLS options to the connection URL.
+// Unless the URL sets an sslmode, the connection uses TLS and verifies the
+// certificate of the server. Skipping the verification uses TLS without
+// verifying the certificate, unless a CA is given.
+func buildConnectionURL(opts options.PostgresStoreOptions) (string, error) {
+	u, err := url.Parse(opts.ConnectionURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse postgres connection url: %v", err)
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return "", fmt.Errorf("postgres connection url must use the postgres or postgresql scheme, got %q", u.Scheme)
+	}
+
+	query := u.Query()
+	if opts.CAPath != "" {
+		query.Set("sslrootcert", opts.CAPath)
+	}
+	if opts.CertPath != "" {
+		query.Set("sslcert", opts.CertPath)
+	}
+	if opts.KeyPath != "" {
+		query.Set("sslkey", opts.KeyPath)
+	}
+
+	switch {
+	case opts.InsecureSkipTLSVerify:
+		query.Set("sslmode", "require")
+	case query.Get("sslmode") == "":
+		query.Set("sslmode", "verify-full")
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
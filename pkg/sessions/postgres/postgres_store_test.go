@@ -0,0 +1,297 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const testTable = "oauth2_proxy_sessions"
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	logger.SetErrOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Postgres SessionStore")
+}
+
+func newFakeClient(f *fakePostgres) *client {
+	return newClient(sql.OpenDB(f), testTable)
+}
+
+var _ = Describe("Postgres SessionStore Tests", func() {
+	var fake *fakePostgres
+
+	BeforeEach(func() {
+		fake = newFakePostgres(testTable)
+	})
+
+	tests.RunSessionStoreTests(
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			opts.Type = options.PostgresSessionStoreType
+			ps := &SessionStore{Client: newFakeClient(fake)}
+			return persistence.NewManager(ps, opts, cookieOpts)
+		},
+		func(d time.Duration) error {
+			return fake.FastForward(d)
+		},
+	)
+})
+
+var _ = Describe("Postgres Client", func() {
+	var fake *fakePostgres
+	var c *client
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		fake = newFakePostgres(testTable)
+		c = newFakeClient(fake)
+	})
+
+	AfterEach(func() {
+		Expect(c.Close()).To(Succeed())
+	})
+
+	It("creates the sessions table", func() {
+		Expect(c.createTable(ctx)).To(Succeed())
+	})
+
+	It("replaces the value and expiry when saved again", func() {
+		Expect(c.Set(ctx, "key", []byte("first"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(30 * time.Second)).To(Succeed())
+		Expect(c.Set(ctx, "key", []byte("second"), time.Hour)).To(Succeed())
+
+		// The key outlives the expiration of the first save
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+		value, err := c.Get(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+	})
+
+	It("does not return expired keys before they are deleted", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		_, err := c.Get(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+		Expect(fake.keys()).To(ConsistOf("key"))
+	})
+
+	It("returns the value of keys deleted with GetDel", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+
+		value, err := c.GetDel(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("value")))
+		Expect(fake.keys()).To(BeEmpty())
+
+		_, err = c.GetDel(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+	})
+
+	It("does not return expired keys deleted with GetDel", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		_, err := c.GetDel(ctx, "key")
+		Expect(err).To(Equal(ErrKeyNotFound))
+	})
+
+	It("only swaps a value that has not changed", func() {
+		swapped, err := c.CompareAndSwap(ctx, "key", nil, []byte("first"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", nil, []byte("other"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", []byte("other"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", []byte("first"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		value, err := c.Get(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+	})
+
+	It("adds the value of an expired key as if it was missing", func() {
+		Expect(c.Set(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		swapped, err := c.CompareAndSwap(ctx, "key", []byte("value"), []byte("other"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = c.CompareAndSwap(ctx, "key", nil, []byte("other"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+	})
+
+	It("deletes only the expired keys", func() {
+		Expect(c.Set(ctx, "expired", []byte("value"), time.Minute)).To(Succeed())
+		Expect(c.Set(ctx, "live", []byte("value"), time.Hour)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		deleted, err := c.DeleteExpired(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeEquivalentTo(1))
+		Expect(fake.keys()).To(ConsistOf("live"))
+	})
+
+	Context("Lock", func() {
+		It("can only be obtained by one lock at a time", func() {
+			first := c.Lock("key")
+			second := c.Lock("key")
+
+			Expect(first.Obtain(ctx, time.Minute)).To(Succeed())
+			Expect(second.Obtain(ctx, time.Minute)).To(Equal(sessionsapi.ErrLockNotObtained))
+			Expect(second.Release(ctx)).To(Equal(sessionsapi.ErrNotLocked))
+
+			locked, err := second.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+
+			Expect(first.Release(ctx)).To(Succeed())
+			Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+		})
+
+		It("can be obtained once the previous lock has expired", func() {
+			first := c.Lock("key")
+			second := c.Lock("key")
+
+			Expect(first.Obtain(ctx, time.Minute)).To(Succeed())
+			Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+			locked, err := second.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeFalse())
+			Expect(second.Obtain(ctx, time.Minute)).To(Succeed())
+
+			// The expired lock can no longer be refreshed or released
+			Expect(first.Refresh(ctx, time.Minute)).To(Equal(sessionsapi.ErrNotLocked))
+			Expect(first.Release(ctx)).To(Equal(sessionsapi.ErrNotLocked))
+
+			locked, err = second.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+		})
+
+		It("extends the expiry when refreshed", func() {
+			l := c.Lock("key")
+			Expect(l.Obtain(ctx, time.Minute)).To(Succeed())
+			Expect(l.Refresh(ctx, time.Hour)).To(Succeed())
+			Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+			locked, err := l.Peek(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Postgres SessionStore", func() {
+	var fake *fakePostgres
+	var store *SessionStore
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		fake = newFakePostgres(testTable)
+		store = &SessionStore{Client: newFakeClient(fake)}
+	})
+
+	AfterEach(func() {
+		Expect(store.Close()).To(Succeed())
+	})
+
+	It("does not report missing sessions as the store being unavailable", func() {
+		_, err := store.Load(ctx, "missing")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeFalse())
+	})
+
+	It("reports errors from the database as the store being unavailable", func() {
+		fake.err = errors.New("dial tcp: connection refused")
+
+		_, err := store.Load(ctx, "key")
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeTrue())
+
+		err = store.Save(ctx, "key", []byte("value"), time.Minute)
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeTrue())
+	})
+
+	It("deletes the expired sessions in the background until it is closed", func() {
+		Expect(store.Save(ctx, "key", []byte("value"), time.Minute)).To(Succeed())
+		Expect(fake.FastForward(time.Minute)).To(Succeed())
+
+		store.startReaper(10 * time.Millisecond)
+		Eventually(fake.keys).Should(BeEmpty())
+
+		Expect(store.Close()).To(Succeed())
+		Expect(store.Save(ctx, "key", []byte("value"), time.Minute)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("buildConnectionURL", func() {
+	type buildConnectionURLTableInput struct {
+		opts        options.PostgresStoreOptions
+		expectedURL string
+		expectedErr string
+	}
+
+	DescribeTable("with the TLS options",
+		func(in buildConnectionURLTableInput) {
+			connectionURL, err := buildConnectionURL(in.opts)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connectionURL).To(Equal(in.expectedURL))
+		},
+		Entry("verifies the server by default", buildConnectionURLTableInput{
+			opts:        options.PostgresStoreOptions{ConnectionURL: "postgres://user:pass@db:5432/sessions"},
+			expectedURL: "postgres://user:pass@db:5432/sessions?sslmode=verify-full",
+		}),
+		Entry("keeps the sslmode of the URL", buildConnectionURLTableInput{
+			opts:        options.PostgresStoreOptions{ConnectionURL: "postgresql://db/sessions?sslmode=disable"},
+			expectedURL: "postgresql://db/sessions?sslmode=disable",
+		}),
+		Entry("adds the CA and client certificate", buildConnectionURLTableInput{
+			opts: options.PostgresStoreOptions{
+				ConnectionURL: "postgres://db/sessions",
+				CAPath:        "/etc/ssl/ca.pem",
+				CertPath:      "/etc/ssl/client.crt",
+				KeyPath:       "/etc/ssl/client.key",
+			},
+			expectedURL: "postgres://db/sessions?sslcert=%2Fetc%2Fssl%2Fclient.crt&sslkey=%2Fetc%2Fssl%2Fclient.key&sslmode=verify-full&sslrootcert=%2Fetc%2Fssl%2Fca.pem",
+		}),
+		Entry("skips verifying the server", buildConnectionURLTableInput{
+			opts: options.PostgresStoreOptions{
+				ConnectionURL:         "postgres://db/sessions?sslmode=verify-full",
+				InsecureSkipTLSVerify: true,
+			},
+			expectedURL: "postgres://db/sessions?sslmode=require",
+		}),
+		Entry("rejects other schemes", buildConnectionURLTableInput{
+			opts:        options.PostgresStoreOptions{ConnectionURL: "mysql://db/sessions"},
+			expectedErr: "postgres connection url must use the postgres or postgresql scheme, got \"mysql\"",
+		}),
+	)
+})
@@ -14,8 +14,36 @@ type Client interface {
 	Lock(key string) sessions.Lock
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Del(ctx context.Context, key string) error
+	GetDel(ctx context.Context, key string) ([]byte, error)
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error)
+	Close() error
 }
 
+// getDelScript gets and deletes a key atomically. GETDEL is only available
+// from Redis 6.2, so a script is used instead.
+var getDelScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("DEL", KEYS[1])
+end
+return value
+`)
+
+// compareAndSwapScript sets a key if its value is ARGV[2], or if it does not
+// exist when ARGV[1] is "0", with an expiration of ARGV[4] milliseconds.
+var compareAndSwapScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if ARGV[1] == "1" then
+	if value ~= ARGV[2] then
+		return 0
+	end
+elseif value then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+return 1
+`)
+
 var _ Client = (*client)(nil)
 
 type client struct {
@@ -40,6 +68,14 @@ func (c *client) Del(ctx context.Context, key string) error {
 	return c.Client.Del(ctx, key).Err()
 }
 
+func (c *client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	return getDel(ctx, c.Client, key)
+}
+
+func (c *client) CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error) {
+	return compareAndSwap(ctx, c.Client, key, old, value, expiration)
+}
+
 func (c *client) Lock(key string) sessions.Lock {
 	return NewLock(c.Client, key)
 }
@@ -68,6 +104,38 @@ func (c *clusterClient) Del(ctx context.Context, key string) error {
 	return c.ClusterClient.Del(ctx, key).Err()
 }
 
+func (c *clusterClient) GetDel(ctx context.Context, key string) ([]byte, error) {
+	return getDel(ctx, c.ClusterClient, key)
+}
+
+func (c *clusterClient) CompareAndSwap(ctx context.Context, key string, old, value []byte, expiration time.Duration) (bool, error) {
+	return compareAndSwap(ctx, c.ClusterClient, key, old, value, expiration)
+}
+
 func (c *clusterClient) Lock(key string) sessions.Lock {
 	return NewLock(c.ClusterClient, key)
 }
+
+// getDel runs the getDelScript, returning redis.Nil when the key does not
+// exist, as GET does
+func getDel(ctx context.Context, c redis.Cmdable, key string) ([]byte, error) {
+	value, err := getDelScript.Run(ctx, c, []string{key}).Text()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// compareAndSwap runs the compareAndSwapScript, reporting whether the key
+// was set
+func compareAndSwap(ctx context.Context, c redis.Cmdable, key string, old, value []byte, expiration time.Duration) (bool, error) {
+	exists := "0"
+	if old != nil {
+		exists = "1"
+	}
+	swapped, err := compareAndSwapScript.Run(ctx, c, []string{key}, exists, old, value, expiration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
@@ -31,7 +31,7 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 	rs := &SessionStore{
 		Client: client,
 	}
-	return persistence.NewManager(rs, cookieOpts), nil
+	return persistence.NewManager(rs, opts, cookieOpts)
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -39,7 +39,7 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
 	err := store.Client.Set(ctx, key, value, exp)
 	if err != nil {
-		return fmt.Errorf("error saving redis session: %v", err)
+		return fmt.Errorf("error saving redis session: %w: %v", persistence.ErrStoreUnavailable, err)
 	}
 	return nil
 }
@@ -48,9 +48,12 @@ func (store *SessionStore) Save(ctx context.Context, key string, value []byte, e
 // cookie within the HTTP request object
 func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
 	value, err := store.Client.Get(ctx, key)
-	if err != nil {
+	if err == redis.Nil {
 		return nil, fmt.Errorf("error loading redis session: %v", err)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading redis session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
 	return value, nil
 }
 
@@ -64,11 +67,38 @@ func (store *SessionStore) Clear(ctx context.Context, key string) error {
 	return nil
 }
 
+// LoadAndClear reads and clears the value from redis atomically
+func (store *SessionStore) LoadAndClear(ctx context.Context, key string) ([]byte, error) {
+	value, err := store.Client.GetDel(ctx, key)
+	if err == redis.Nil {
+		return nil, fmt.Errorf("error loading redis session: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading redis session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
+
+// CompareAndSwap saves the value to redis if the current value is old, or if
+// there is no current value when old is nil
+func (store *SessionStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error) {
+	swapped, err := store.Client.CompareAndSwap(ctx, key, old, value, exp)
+	if err != nil {
+		return false, fmt.Errorf("error saving redis session: %w: %v", persistence.ErrStoreUnavailable, err)
+	}
+	return swapped, nil
+}
+
 // Lock creates a lock object for sessions.SessionState
 func (store *SessionStore) Lock(key string) sessions.Lock {
 	return store.Client.Lock(key)
 }
 
+// Close closes the connection to redis
+func (store *SessionStore) Close() error {
+	return store.Client.Close()
+}
+
 // NewRedisClient makes a redis.Client (either standalone, sentinel aware, or
 // redis cluster)
 func NewRedisClient(opts options.RedisStoreOptions) (Client, error) {
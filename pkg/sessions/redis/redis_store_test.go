@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"testing"
@@ -225,3 +226,72 @@ var _ = Describe("Redis SessionStore Tests", func() {
 		})
 	})
 })
+
+var _ = Describe("Redis SessionStore Load", func() {
+	var mr *miniredis.Miniredis
+	var store *SessionStore
+
+	BeforeEach(func() {
+		var err error
+		mr, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := NewRedisClient(options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()})
+		Expect(err).ToNot(HaveOccurred())
+		store = &SessionStore{Client: client}
+	})
+
+	AfterEach(func() {
+		Expect(store.Client.(*client).Close()).To(Succeed())
+		mr.Close()
+	})
+
+	It("does not report missing sessions as the store being unavailable", func() {
+		_, err := store.Load(context.Background(), "missing")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeFalse())
+	})
+
+	It("reports connection errors as the store being unavailable", func() {
+		mr.Close()
+		_, err := store.Load(context.Background(), "missing")
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeTrue())
+	})
+
+	It("loads a value and clears it", func() {
+		Expect(store.Save(context.Background(), "key", []byte("value"), time.Minute)).To(Succeed())
+
+		value, err := store.LoadAndClear(context.Background(), "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("value")))
+		Expect(mr.Exists("key")).To(BeFalse())
+
+		_, err = store.LoadAndClear(context.Background(), "key")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, persistence.ErrStoreUnavailable)).To(BeFalse())
+	})
+
+	It("only swaps a value that has not changed", func() {
+		ctx := context.Background()
+		swapped, err := store.CompareAndSwap(ctx, "key", nil, []byte("first"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		swapped, err = store.CompareAndSwap(ctx, "key", nil, []byte("other"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = store.CompareAndSwap(ctx, "key", []byte("other"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeFalse())
+
+		swapped, err = store.CompareAndSwap(ctx, "key", []byte("first"), []byte("second"), time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(swapped).To(BeTrue())
+
+		value, err := store.Load(ctx, "key")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+		Expect(mr.TTL("key")).To(Equal(time.Minute))
+	})
+})
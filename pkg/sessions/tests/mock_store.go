@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"time"
@@ -56,6 +57,27 @@ func (s *MockStore) Clear(_ context.Context, key string) error {
 	return nil
 }
 
+// LoadAndClear gets data from the memory cache via a key and deletes it
+func (s *MockStore) LoadAndClear(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.cache, key)
+	return data, nil
+}
+
+// CompareAndSwap sets a key to the data in the memory cache if its current
+// data is old, or if it does not exist when old is nil
+func (s *MockStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, exp time.Duration) (bool, error) {
+	current, err := s.Load(ctx, key)
+	exists := err == nil
+	if exists != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	return true, s.Save(ctx, key, value, exp)
+}
+
 func (s *MockStore) Lock(key string) sessions.Lock {
 	if s.lockCache[key] != nil {
 		return s.lockCache[key]
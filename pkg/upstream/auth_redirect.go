@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const locationHeader = "Location"
+
+// newAuthRedirectModifier creates a function that can be used as the
+// ModifyResponse hook of a ReverseProxy to apply the configured handling to
+// redirects from the upstream server to an identity provider.
+// If no identity provider hosts are configured, nil is returned.
+func newAuthRedirectModifier(upstream options.Upstream) func(*http.Response) error {
+	if upstream.AuthRedirect == nil || len(upstream.AuthRedirect.Hosts) == 0 {
+		return nil
+	}
+
+	hosts := upstream.AuthRedirect.Hosts
+	location := upstream.AuthRedirect.Location
+	if location == "" {
+		location = "/"
+	}
+
+	return func(resp *http.Response) error {
+		if !isAuthRedirect(resp, hosts) {
+			return nil
+		}
+
+		switch upstream.AuthRedirect.Handling {
+		case options.AuthRedirectRewrite:
+			logger.Printf("Rewriting redirect from upstream %q to identity provider %s to %s", upstream.ID, resp.Header.Get(locationHeader), location)
+			resp.Header.Set(locationHeader, location)
+		case options.AuthRedirectError:
+			return fmt.Errorf("upstream %q redirected to identity provider %s", upstream.ID, resp.Header.Get(locationHeader))
+		default:
+			logger.Printf("WARNING: upstream %q redirected to identity provider %s", upstream.ID, resp.Header.Get(locationHeader))
+		}
+		return nil
+	}
+}
+
+// isAuthRedirect determines whether the response is a redirect with a
+// Location on one of the identity provider hosts.
+func isAuthRedirect(resp *http.Response, hosts []string) bool {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+
+	u, err := url.Parse(resp.Header.Get(locationHeader))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return matchesAuthRedirectHost(u.Hostname(), hosts)
+}
+
+// matchesAuthRedirectHost determines whether the host is one of the hosts, or
+// a subdomain of a host with a leading `.`.
+func matchesAuthRedirectHost(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if strings.HasPrefix(h, ".") {
+			if host == strings.TrimPrefix(h, ".") || strings.HasSuffix(host, h) {
+				return true
+			}
+			continue
+		}
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auth redirect handling", func() {
+	type authRedirectTableInput struct {
+		authRedirect     *options.AuthRedirectOptions
+		status           int
+		location         string
+		expectNil        bool
+		expectErr        bool
+		expectedLocation string
+	}
+
+	authHosts := []string{"idp.example.com", ".login.example.com"}
+
+	DescribeTable("newAuthRedirectModifier",
+		func(in authRedirectTableInput) {
+			modifier := newAuthRedirectModifier(options.Upstream{ID: "app", Path: "/", AuthRedirect: in.authRedirect})
+			if in.expectNil {
+				Expect(modifier).To(BeNil())
+				return
+			}
+			Expect(modifier).ToNot(BeNil())
+
+			resp := &http.Response{StatusCode: in.status, Header: http.Header{}}
+			resp.Header.Set("Location", in.location)
+			if in.expectErr {
+				Expect(modifier(resp)).To(MatchError("upstream \"app\" redirected to identity provider " + in.location))
+				return
+			}
+			Expect(modifier(resp)).To(Succeed())
+			Expect(resp.Header.Get("Location")).To(Equal(in.expectedLocation))
+		},
+		Entry("with no auth redirect configured", authRedirectTableInput{
+			expectNil: true,
+		}),
+		Entry("with no hosts", authRedirectTableInput{
+			authRedirect: &options.AuthRedirectOptions{Handling: options.AuthRedirectError},
+			expectNil:    true,
+		}),
+		Entry("with passthrough handling", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts},
+			status:           http.StatusFound,
+			location:         "https://idp.example.com/authorize?client_id=app",
+			expectedLocation: "https://idp.example.com/authorize?client_id=app",
+		}),
+		Entry("with rewrite handling", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectRewrite, Location: "/app/"},
+			status:           http.StatusFound,
+			location:         "https://idp.example.com/authorize?client_id=app",
+			expectedLocation: "/app/",
+		}),
+		Entry("with rewrite handling and no location", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectRewrite},
+			status:           http.StatusSeeOther,
+			location:         "https://eu.login.example.com/authorize",
+			expectedLocation: "/",
+		}),
+		Entry("with error handling", authRedirectTableInput{
+			authRedirect: &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectError},
+			status:       http.StatusTemporaryRedirect,
+			location:     "https://login.example.com/authorize",
+			expectErr:    true,
+		}),
+		Entry("with error handling and a redirect to another host", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectError},
+			status:           http.StatusFound,
+			location:         "https://app.example.com/dashboard",
+			expectedLocation: "https://app.example.com/dashboard",
+		}),
+		Entry("with error handling and a relative redirect", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectError},
+			status:           http.StatusFound,
+			location:         "/login",
+			expectedLocation: "/login",
+		}),
+		Entry("with error handling and a response that isn't a redirect", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectError},
+			status:           http.StatusCreated,
+			location:         "https://idp.example.com/clients/app",
+			expectedLocation: "https://idp.example.com/clients/app",
+		}),
+		Entry("with error handling and a host with a matching suffix", authRedirectTableInput{
+			authRedirect:     &options.AuthRedirectOptions{Hosts: authHosts, Handling: options.AuthRedirectError},
+			status:           http.StatusFound,
+			location:         "https://evilidp.example.com/authorize",
+			expectedLocation: "https://evilidp.example.com/authorize",
+		}),
+	)
+
+	It("renders the error page for redirects to an identity provider with error handling", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			http.Redirect(rw, req, "https://idp.example.com/authorize", http.StatusFound)
+		}))
+		defer server.Close()
+		target, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		var proxyErr error
+		upstream := options.Upstream{
+			ID:           "app",
+			Path:         "/",
+			URI:          server.URL,
+			AuthRedirect: &options.AuthRedirectOptions{Hosts: []string{"idp.example.com"}, Handling: options.AuthRedirectError},
+		}
+		handler := newReverseProxy(target, upstream, func(rw http.ResponseWriter, _ *http.Request, err error) {
+			proxyErr = err
+			rw.WriteHeader(http.StatusBadGateway)
+		})
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+		Expect(rw.Code).To(Equal(http.StatusBadGateway))
+		Expect(rw.Header().Get("Location")).To(BeEmpty())
+		Expect(proxyErr).To(MatchError("upstream \"app\" redirected to identity provider https://idp.example.com/authorize"))
+	})
+})
@@ -0,0 +1,76 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// claimUpstreamGroup selects between the upstreams that share a path based on
+// the value of a claim in the session.
+type claimUpstreamGroup struct {
+	claim    string
+	routes   map[string]http.Handler
+	fallback http.Handler
+	writer   pagewriter.Writer
+}
+
+// newClaimUpstreamGroup creates a claimUpstreamGroup from the upstreams and
+// their handlers.
+// An upstream without any claim values serves the requests whose claim value
+// isn't routed to another upstream.
+func newClaimUpstreamGroup(upstreams options.Upstreams, handlers []http.Handler, writer pagewriter.Writer) http.Handler {
+	group := &claimUpstreamGroup{
+		claim:  upstreams[0].RoutingClaim,
+		routes: make(map[string]http.Handler),
+		writer: writer,
+	}
+	for i, upstream := range upstreams {
+		if len(upstream.RoutingClaimValues) == 0 {
+			logger.Printf("routing path %q => upstream %q: by default", upstream.Path, upstream.ID)
+			group.fallback = handlers[i]
+			continue
+		}
+		for _, value := range upstream.RoutingClaimValues {
+			logger.Printf("routing path %q => upstream %q: %s %q", upstream.Path, upstream.ID, group.claim, value)
+			group.routes[value] = handlers[i]
+		}
+	}
+	return group
+}
+
+// ServeHTTP proxies the request to the upstream for the session's claim value
+func (g *claimUpstreamGroup) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	scope := middleware.GetRequestScope(req)
+	if handler := g.route(scope); handler != nil {
+		handler.ServeHTTP(rw, req)
+		return
+	}
+
+	logger.Errorf("no upstream for the %s claim of the session, and no default upstream", g.claim)
+	opts := pagewriter.ErrorPageOpts{
+		Status:   http.StatusForbidden,
+		AppError: fmt.Sprintf("No upstream is configured for the %s claim of the session", g.claim),
+	}
+	if scope != nil {
+		opts.RequestID = scope.RequestID
+	}
+	g.writer.WriteErrorPage(rw, opts)
+}
+
+// route selects the upstream for the first claim value that is routed to
+// one, or the default upstream
+func (g *claimUpstreamGroup) route(scope *middleware.RequestScope) http.Handler {
+	if scope != nil && scope.Session != nil {
+		for _, value := range scope.Session.GetClaim(g.claim) {
+			if handler, ok := g.routes[value]; ok {
+				return handler
+			}
+		}
+	}
+	return g.fallback
+}
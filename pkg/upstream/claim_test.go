@@ -0,0 +1,151 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claim Upstream Suite", func() {
+	writer := &pagewriter.WriterFuncs{
+		ErrorPageFunc: func(rw http.ResponseWriter, opts pagewriter.ErrorPageOpts) {
+			rw.WriteHeader(opts.Status)
+			_, _ = rw.Write([]byte(opts.AppError))
+		},
+	}
+
+	// newGroup creates a claim routed group of static upstreams with the given
+	// IDs and claim values which record the upstream in the request scope
+	newGroup := func(claim string, routes map[string][]string) http.Handler {
+		upstreams := options.Upstreams{}
+		handlers := []http.Handler{}
+		for id, values := range routes {
+			upstreams = append(upstreams, options.Upstream{
+				ID:                 id,
+				Path:               "/",
+				Static:             true,
+				RoutingClaim:       claim,
+				RoutingClaimValues: values,
+			})
+			handlers = append(handlers, newStaticResponseHandler(id, nil))
+		}
+		return newClaimUpstreamGroup(upstreams, handlers, writer)
+	}
+
+	type claimGroupTableInput struct {
+		claim            string
+		routes           map[string][]string
+		session          *sessionsapi.SessionState
+		expectedUpstream string
+		expectedCode     int
+	}
+
+	DescribeTable("selects the upstream for the claim value",
+		func(in claimGroupTableInput) {
+			group := newGroup(in.claim, in.routes)
+
+			rw := httptest.NewRecorder()
+			scope := &middlewareapi.RequestScope{Session: in.session}
+			req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
+			group.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			Expect(scope.Upstream).To(Equal(in.expectedUpstream))
+		},
+		Entry("with a routed value", claimGroupTableInput{
+			claim:            "email",
+			routes:           map[string][]string{"acme": {"user@acme.com"}, "globex": {"user@globex.com"}},
+			session:          &sessionsapi.SessionState{Email: "user@globex.com"},
+			expectedUpstream: "globex",
+			expectedCode:     http.StatusOK,
+		}),
+		Entry("with one of several values routed to the upstream", claimGroupTableInput{
+			claim:            "user",
+			routes:           map[string][]string{"acme": {"alice", "bob"}, "globex": {"carol"}},
+			session:          &sessionsapi.SessionState{User: "bob"},
+			expectedUpstream: "acme",
+			expectedCode:     http.StatusOK,
+		}),
+		Entry("with a multi valued claim", claimGroupTableInput{
+			claim:            "groups",
+			routes:           map[string][]string{"acme": {"acme"}, "globex": {"globex"}},
+			session:          &sessionsapi.SessionState{Groups: []string{"everyone", "globex", "acme"}},
+			expectedUpstream: "globex",
+			expectedCode:     http.StatusOK,
+		}),
+		Entry("with an unrouted value and a default", claimGroupTableInput{
+			claim:            "groups",
+			routes:           map[string][]string{"acme": {"acme"}, "default": nil},
+			session:          &sessionsapi.SessionState{Groups: []string{"initech"}},
+			expectedUpstream: "default",
+			expectedCode:     http.StatusOK,
+		}),
+		Entry("with no session and a default", claimGroupTableInput{
+			claim:            "groups",
+			routes:           map[string][]string{"acme": {"acme"}, "default": nil},
+			session:          nil,
+			expectedUpstream: "default",
+			expectedCode:     http.StatusOK,
+		}),
+		Entry("with an unrouted value and no default", claimGroupTableInput{
+			claim:            "groups",
+			routes:           map[string][]string{"acme": {"acme"}},
+			session:          &sessionsapi.SessionState{Groups: []string{"initech"}},
+			expectedUpstream: "",
+			expectedCode:     http.StatusForbidden,
+		}),
+		Entry("with no session and no default", claimGroupTableInput{
+			claim:            "groups",
+			routes:           map[string][]string{"acme": {"acme"}},
+			session:          nil,
+			expectedUpstream: "",
+			expectedCode:     http.StatusForbidden,
+		}),
+	)
+
+	It("explains why the request was forbidden", func() {
+		group := newGroup("groups", map[string][]string{"acme": {"acme"}})
+
+		rw := httptest.NewRecorder()
+		req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), &middlewareapi.RequestScope{})
+		group.ServeHTTP(rw, req)
+		Expect(rw.Code).To(Equal(http.StatusForbidden))
+		Expect(rw.Body.String()).To(Equal("No upstream is configured for the groups claim of the session"))
+	})
+
+	It("is built by NewProxy for upstreams with a routing claim", func() {
+		proxy, err := NewProxy(options.Upstreams{
+			{
+				ID:                 "acme",
+				Path:               "/app/",
+				Static:             true,
+				RoutingClaim:       "groups",
+				RoutingClaimValues: []string{"acme"},
+			},
+			{
+				ID:           "default",
+				Path:         "/app/",
+				Static:       true,
+				RoutingClaim: "groups",
+			},
+		}, nil, writer, options.TrailingSlashStrict)
+		Expect(err).ToNot(HaveOccurred())
+
+		serve := func(groups ...string) string {
+			req := httptest.NewRequest("", "/app/page", nil)
+			scope := &middlewareapi.RequestScope{Session: &sessionsapi.SessionState{Groups: groups}}
+			req = middlewareapi.AddRequestScope(req, scope)
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+			return scope.Upstream
+		}
+		Expect(serve("acme")).To(Equal("acme"))
+		Expect(serve("initech")).To(Equal("default"))
+	})
+})
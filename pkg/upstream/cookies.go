@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+const setCookieHeader = "Set-Cookie"
+
+// newSetCookieModifier creates a function that can be used as the
+// ModifyResponse hook of a ReverseProxy to apply the configured handling to
+// any Set-Cookie headers returned by the upstream server.
+// If the Set-Cookie headers should be passed through unchanged, nil is
+// returned.
+func newSetCookieModifier(upstream options.Upstream) func(*http.Response) error {
+	switch upstream.SetCookieHandling {
+	case options.SetCookieStrip:
+		return func(resp *http.Response) error {
+			resp.Header.Del(setCookieHeader)
+			return nil
+		}
+	case options.SetCookieRewrite:
+		path := cookiePathForUpstream(upstream)
+		return func(resp *http.Response) error {
+			rewriteSetCookies(resp.Header, path)
+			return nil
+		}
+	default:
+		return nil
+	}
+}
+
+// cookiePathForUpstream determines the path that cookies should be restricted
+// to when they are rewritten.
+// Rewrite upstreams use a regex for their path, so only the root path can be
+// used for these.
+func cookiePathForUpstream(upstream options.Upstream) string {
+	if upstream.RewriteTarget != "" || !strings.HasPrefix(upstream.Path, "/") {
+		return "/"
+	}
+	return upstream.Path
+}
+
+// rewriteSetCookies rewrites each of the Set-Cookie headers so that the
+// cookies are scoped to the proxy.
+// Any header that cannot be parsed as a cookie is passed through unchanged.
+func rewriteSetCookies(header http.Header, path string) {
+	values := header.Values(setCookieHeader)
+	if len(values) == 0 {
+		return
+	}
+
+	header.Del(setCookieHeader)
+	for _, value := range values {
+		header.Add(setCookieHeader, rewriteSetCookie(value, path))
+	}
+}
+
+// rewriteSetCookie removes the Domain from the cookie so that it is scoped to
+// the host the client requested, and ensures the cookie is not sent for paths
+// outside of the upstream path.
+func rewriteSetCookie(value string, path string) string {
+	cookies := (&http.Response{Header: http.Header{setCookieHeader: {value}}}).Cookies()
+	if len(cookies) != 1 {
+		return value
+	}
+
+	c := cookies[0]
+	c.Domain = ""
+	if !strings.HasPrefix(c.Path, path) {
+		c.Path = path
+	}
+
+	if rewritten := c.String(); rewritten != "" {
+		return rewritten
+	}
+	return value
+}
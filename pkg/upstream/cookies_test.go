@@ -0,0 +1,77 @@
+package upstream
+
+import (
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Set-Cookie handling", func() {
+	type setCookieTableInput struct {
+		upstream        options.Upstream
+		setCookies      []string
+		expectNil       bool
+		expectedCookies []string
+	}
+
+	DescribeTable("newSetCookieModifier",
+		func(in setCookieTableInput) {
+			modifier := newSetCookieModifier(in.upstream)
+			if in.expectNil {
+				Expect(modifier).To(BeNil())
+				return
+			}
+			Expect(modifier).ToNot(BeNil())
+
+			resp := &http.Response{Header: http.Header{}}
+			for _, c := range in.setCookies {
+				resp.Header.Add("Set-Cookie", c)
+			}
+			Expect(modifier(resp)).To(Succeed())
+			Expect(resp.Header.Values("Set-Cookie")).To(Equal(in.expectedCookies))
+		},
+		Entry("with no handling configured", setCookieTableInput{
+			upstream:  options.Upstream{Path: "/"},
+			expectNil: true,
+		}),
+		Entry("with passthrough handling", setCookieTableInput{
+			upstream:  options.Upstream{Path: "/", SetCookieHandling: options.SetCookiePassthrough},
+			expectNil: true,
+		}),
+		Entry("with strip handling", setCookieTableInput{
+			upstream:        options.Upstream{Path: "/", SetCookieHandling: options.SetCookieStrip},
+			setCookies:      []string{"foo=bar; Path=/", "baz=qux; Domain=upstream.internal"},
+			expectedCookies: nil,
+		}),
+		Entry("with rewrite handling on the root path", setCookieTableInput{
+			upstream:   options.Upstream{Path: "/", SetCookieHandling: options.SetCookieRewrite},
+			setCookies: []string{"foo=bar; Path=/; Domain=upstream.internal; HttpOnly", "baz=qux; Path=/sub"},
+			expectedCookies: []string{
+				"foo=bar; Path=/; HttpOnly",
+				"baz=qux; Path=/sub",
+			},
+		}),
+		Entry("with rewrite handling on a sub path", setCookieTableInput{
+			upstream:   options.Upstream{Path: "/app/", SetCookieHandling: options.SetCookieRewrite},
+			setCookies: []string{"foo=bar; Path=/; Domain=upstream.internal", "baz=qux; Path=/app/sub", "empty=path"},
+			expectedCookies: []string{
+				"foo=bar; Path=/app/",
+				"baz=qux; Path=/app/sub",
+				"empty=path; Path=/app/",
+			},
+		}),
+		Entry("with rewrite handling on a rewrite path", setCookieTableInput{
+			upstream:        options.Upstream{Path: "^/app/(.*)$", RewriteTarget: "/$1", SetCookieHandling: options.SetCookieRewrite},
+			setCookies:      []string{"foo=bar; Path=/sub; Domain=upstream.internal"},
+			expectedCookies: []string{"foo=bar; Path=/sub"},
+		}),
+		Entry("with rewrite handling and an invalid cookie", setCookieTableInput{
+			upstream:        options.Upstream{Path: "/", SetCookieHandling: options.SetCookieRewrite},
+			setCookies:      []string{"invalid"},
+			expectedCookies: []string{"invalid"},
+		}),
+	)
+})
@@ -0,0 +1,156 @@
+package upstream
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const eventStreamContentType = "text/event-stream"
+
+// isEventStream determines whether the headers describe a Server-Sent Events
+// response.
+func isEventStream(header http.Header) bool {
+	contentType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && contentType == eventStreamContentType
+}
+
+// setEventStreamHeaders is used as part of the ModifyResponse hook of a
+// ReverseProxy to ensure that Server-Sent Events responses are not buffered
+// by any intermediaries (eg. nginx) between the proxy and the client.
+// The ReverseProxy itself flushes Server-Sent Events responses immediately.
+func setEventStreamHeaders(resp *http.Response) error {
+	if !isEventStream(resp.Header) {
+		return nil
+	}
+
+	resp.Header.Set("X-Accel-Buffering", "no")
+	if resp.Header.Get("Cache-Control") == "" {
+		resp.Header.Set("Cache-Control", "no-cache")
+	}
+	return nil
+}
+
+// newEventStreamFlusher wraps the handler so that Server-Sent Events responses
+// are flushed to the client at most once per interval, rather than after every
+// write. Events written within the interval are sent together.
+// All other responses are written as normal.
+func newEventStreamFlusher(interval time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		w := &eventStreamWriter{
+			ResponseWriter: rw,
+			flusher:        flusher,
+			interval:       interval,
+		}
+		defer w.stop()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// eventStreamWriter delays flushes of Server-Sent Events responses so that
+// they happen at most once per interval.
+type eventStreamWriter struct {
+	http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+
+	// mu guards writes to the ResponseWriter as flushes happen on a timer
+	mu          sync.Mutex
+	eventStream bool
+	timer       *time.Timer
+	stopped     bool
+}
+
+// WriteHeader determines whether the response is an event stream before
+// writing the response headers.
+func (w *eventStreamWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.eventStream = isEventStream(w.Header())
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the data to the underlying ResponseWriter
+func (w *eventStreamWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes immediately unless the response is an event stream, in which
+// case a flush is scheduled for the end of the current interval.
+// Implements the `http.Flusher` interface.
+func (w *eventStreamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.eventStream {
+		w.flusher.Flush()
+		return
+	}
+	if w.timer == nil && !w.stopped {
+		w.timer = time.AfterFunc(w.interval, w.delayedFlush)
+	}
+}
+
+// Hijack hands the connection over to the caller, eg. for websockets, after
+// cancelling any pending flush, as the ResponseWriter can't be flushed once
+// it has been hijacked.
+// Implements the `http.Hijacker` interface.
+func (w *eventStreamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not available on writer")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	return conn, rw, nil
+}
+
+// delayedFlush flushes any events written since the last flush.
+func (w *eventStreamWriter) delayedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timer = nil
+	if !w.stopped {
+		w.flusher.Flush()
+	}
+}
+
+// stop cancels any pending flush once the response is complete and flushes
+// any remaining events.
+func (w *eventStreamWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+		w.flusher.Flush()
+	}
+}
@@ -0,0 +1,226 @@
+package upstream
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event Stream Suite", func() {
+	type eventStreamHeadersTableInput struct {
+		header         http.Header
+		expectedHeader http.Header
+	}
+
+	DescribeTable("setEventStreamHeaders",
+		func(in eventStreamHeadersTableInput) {
+			resp := &http.Response{Header: in.header}
+			Expect(setEventStreamHeaders(resp)).To(Succeed())
+			Expect(resp.Header).To(Equal(in.expectedHeader))
+		},
+		Entry("with a regular response", eventStreamHeadersTableInput{
+			header:         http.Header{contentType: []string{applicationJSON}},
+			expectedHeader: http.Header{contentType: []string{applicationJSON}},
+		}),
+		Entry("with an event stream response", eventStreamHeadersTableInput{
+			header: http.Header{contentType: []string{"text/event-stream; charset=utf-8"}},
+			expectedHeader: http.Header{
+				contentType:         []string{"text/event-stream; charset=utf-8"},
+				"Cache-Control":     []string{"no-cache"},
+				"X-Accel-Buffering": []string{"no"},
+			},
+		}),
+		Entry("with an event stream response that sets Cache-Control", eventStreamHeadersTableInput{
+			header: http.Header{
+				contentType:     []string{"text/event-stream"},
+				"Cache-Control": []string{"no-store"},
+			},
+			expectedHeader: http.Header{
+				contentType:         []string{"text/event-stream"},
+				"Cache-Control":     []string{"no-store"},
+				"X-Accel-Buffering": []string{"no"},
+			},
+		}),
+	)
+
+	Context("when proxying an event stream", func() {
+		var eventServer, proxyServer *httptest.Server
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+
+			// The upstream sends a single event, then holds the connection open
+			eventServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set(contentType, eventStreamContentType)
+				rw.WriteHeader(http.StatusOK)
+				fmt.Fprint(rw, "data: first\n\n")
+				rw.(http.Flusher).Flush()
+				<-release
+			}))
+
+			u, err := url.Parse(eventServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			// A long flush interval would delay the event if it were applied
+			flush := options.Duration(time.Hour)
+			handler := newHTTPUpstreamProxy(options.Upstream{
+				ID:            "events",
+				FlushInterval: &flush,
+			}, u, nil, nil)
+
+			proxyServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				handler.ServeHTTP(rw, req)
+			}))
+		})
+
+		AfterEach(func() {
+			close(release)
+			proxyServer.Close()
+			eventServer.Close()
+		})
+
+		It("sends each event to the client without buffering", func() {
+			resp, err := http.Get(proxyServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.Header.Get("X-Accel-Buffering")).To(Equal("no"))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("no-cache"))
+
+			lines := make(chan string, 1)
+			go func() {
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				lines <- line
+			}()
+			Eventually(lines, 5*time.Second).Should(Receive(Equal("data: first\n")))
+		})
+	})
+
+	Context("newEventStreamFlusher", func() {
+		const interval = 100 * time.Millisecond
+		var done chan struct{}
+
+		BeforeEach(func() {
+			done = make(chan struct{})
+		})
+
+		AfterEach(func() {
+			close(done)
+		})
+
+		// writeEvent writes a single event with the given headers and flushes
+		// it, then holds the response open until the test completes
+		writeEvent := func(header http.Header) *flushRecorder {
+			rw := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			flushed := make(chan struct{})
+			release := done
+
+			handler := newEventStreamFlusher(interval, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				for key, values := range header {
+					rw.Header()[key] = values
+				}
+				rw.WriteHeader(http.StatusOK)
+				fmt.Fprint(rw, "data: event\n\n")
+				rw.(http.Flusher).Flush()
+				close(flushed)
+				<-release
+			}))
+
+			go handler.ServeHTTP(rw, httptest.NewRequest("", "/", nil))
+			<-flushed
+			return rw
+		}
+
+		It("flushes regular responses immediately", func() {
+			rw := writeEvent(http.Header{contentType: []string{textPlainUTF8}})
+			Expect(rw.flushCount()).To(Equal(1))
+		})
+
+		It("delays flushing event stream responses until the interval", func() {
+			start := time.Now()
+			rw := writeEvent(http.Header{contentType: []string{eventStreamContentType}})
+			Expect(rw.flushCount()).To(Equal(0))
+
+			Eventually(rw.flushCount, time.Second, 10*time.Millisecond).Should(Equal(1))
+			Expect(time.Since(start)).To(BeNumerically(">=", interval))
+		})
+
+		It("hijacks the connection of the underlying writer", func() {
+			server, client := net.Pipe()
+			defer client.Close()
+			rw := &hijackRecorder{
+				flushRecorder: &flushRecorder{ResponseRecorder: httptest.NewRecorder()},
+				conn:          server,
+			}
+
+			var conn net.Conn
+			var err error
+			handler := newEventStreamFlusher(interval, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set(contentType, eventStreamContentType)
+				rw.WriteHeader(http.StatusOK)
+				rw.(http.Flusher).Flush()
+				conn, _, err = rw.(http.Hijacker).Hijack()
+			}))
+			handler.ServeHTTP(rw, httptest.NewRequest("", "/", nil))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn).To(Equal(server))
+			// The pending flush is cancelled as the writer has been hijacked
+			Consistently(rw.flushCount, 2*interval, 10*time.Millisecond).Should(Equal(0))
+		})
+
+		It("can't hijack a writer that does not support it", func() {
+			var err error
+			handler := newEventStreamFlusher(interval, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_, _, err = rw.(http.Hijacker).Hijack()
+			}))
+			handler.ServeHTTP(&flushRecorder{ResponseRecorder: httptest.NewRecorder()}, httptest.NewRequest("", "/", nil))
+
+			Expect(err).To(MatchError("http.Hijacker is not available on writer"))
+		})
+	})
+})
+
+// hijackRecorder is a flushRecorder that can be hijacked, returning conn
+type hijackRecorder struct {
+	*flushRecorder
+	conn net.Conn
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// flushRecorder counts the flushes of a ResponseRecorder so that they can be
+// observed while the response is still being written.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+
+	mu      sync.Mutex
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushes++
+}
+
+func (f *flushRecorder) flushCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushes
+}
@@ -0,0 +1,287 @@
+package upstream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mbland/hmacauth"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	fcgiScheme     = "fcgi"
+	fcgiUnixScheme = "fcgi+unix"
+
+	// defaultFastCGIIndex is the default script for request paths that end
+	// with a slash
+	defaultFastCGIIndex = "index.php"
+)
+
+// newFastCGIUpstreamProxy creates a new fastCGIUpstreamProxy that can serve
+// requests to a single FastCGI server, eg. PHP-FPM.
+func newFastCGIUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, errorHandler ProxyErrorHandler) http.Handler {
+	network, address := "tcp", u.Host
+	if u.Scheme == fcgiUnixScheme {
+		network, address = "unix", u.Path
+	}
+
+	var auth hmacauth.HmacAuth
+	if sigData != nil {
+		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), SignatureHeader, SignatureHeaders)
+	}
+
+	fcgiOpts := options.FastCGIOptions{}
+	if upstream.FastCGI != nil {
+		fcgiOpts = *upstream.FastCGI
+	}
+	index := fcgiOpts.Index
+	if index == "" {
+		index = defaultFastCGIIndex
+	}
+
+	var handler http.Handler = &fastCGIUpstreamProxy{
+		upstream:        upstream.ID,
+		network:         network,
+		address:         address,
+		documentRoot:    fcgiOpts.DocumentRoot,
+		scriptFilename:  fcgiOpts.ScriptFilename,
+		index:           index,
+		params:          fcgiOpts.Params,
+		auth:            auth,
+		passAccessToken: upstream.PassAccessToken,
+		errorHandler:    errorHandler,
+	}
+	if upstream.Timeout != nil && upstream.Timeout.Duration() > 0 {
+		handler = newRequestTimeoutHandler(upstream.Timeout.Duration(), handler)
+	}
+	return handler
+}
+
+// fastCGIUpstreamProxy represents a single FastCGI upstream.
+// The identity of the user is passed to the FastCGI server as params: the
+// request headers, including those injected by InjectRequestHeaders, as
+// HTTP_* params, and the user as the REMOTE_USER.
+type fastCGIUpstreamProxy struct {
+	upstream        string
+	network         string
+	address         string
+	documentRoot    string
+	scriptFilename  string
+	index           string
+	params          map[string]string
+	auth            hmacauth.HmacAuth
+	passAccessToken bool
+	errorHandler    ProxyErrorHandler
+}
+
+// ServeHTTP passes the request to the FastCGI server and writes its response
+func (f *fastCGIUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	scope := middleware.GetRequestScope(req)
+	// If scope is nil, this will panic.
+	// A scope should always be injected before this handler is called.
+	scope.Upstream = f.upstream
+
+	// The access token must be injected before the request is signed
+	if f.passAccessToken {
+		setAccessTokenHeader(req, scope)
+	}
+	if f.auth != nil {
+		req.Header.Set("GAP-Auth", rw.Header().Get("GAP-Auth"))
+		f.auth.SignRequest(req)
+	}
+
+	if err := f.serveFastCGI(rw, req, scope); err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			// The connection was closed as the request was cancelled
+			err = fmt.Errorf("%w: %v", ctxErr, err)
+		}
+		f.handleError(rw, req, err)
+	}
+}
+
+// serveFastCGI makes the request to the FastCGI server.
+// Errors are returned before the response is written, so that the error page
+// can be rendered.
+func (f *fastCGIUpstreamProxy) serveFastCGI(rw http.ResponseWriter, req *http.Request, scope *middleware.RequestScope) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(req.Context(), f.network, f.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to FastCGI upstream: %w", err)
+	}
+	fcgi := newFCGIConn(conn)
+	defer fcgi.Close()
+
+	// Unblock any reads or writes when the request is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-req.Context().Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if err := fcgi.writeRequest(f.buildParams(req, scope), req.Body); err != nil {
+		return fmt.Errorf("error writing request to FastCGI upstream: %w", err)
+	}
+
+	reader := bufio.NewReader(fcgi)
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return fmt.Errorf("error reading response from FastCGI upstream: %w", err)
+	}
+
+	code, err := fastCGIStatus(header)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		rw.Header()[name] = values
+	}
+	rw.WriteHeader(code)
+
+	if _, err := io.Copy(rw, reader); err != nil {
+		// The response has started, so the error page can't be rendered
+		logger.Errorf("error copying response from FastCGI upstream %q: %v", f.upstream, err)
+	}
+	return nil
+}
+
+// buildParams builds the CGI params of the request.
+// The configured Params are set after the params of the request, but can't
+// override the params that identify the user.
+func (f *fastCGIUpstreamProxy) buildParams(req *http.Request, scope *middleware.RequestScope) map[string]string {
+	scriptName := req.URL.Path
+	if strings.HasSuffix(scriptName, "/") {
+		scriptName += f.index
+	}
+	scriptFilename := f.scriptFilename
+	if scriptFilename == "" {
+		scriptFilename = path.Join(f.documentRoot, path.Clean("/"+scriptName))
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "oauth2-proxy",
+		"SERVER_PROTOCOL":   req.Proto,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"DOCUMENT_ROOT":     f.documentRoot,
+		"DOCUMENT_URI":      scriptName,
+		"HTTP_HOST":         req.Host,
+	}
+
+	host, port := req.Host, ""
+	if h, p, err := net.SplitHostPort(req.Host); err == nil {
+		host, port = h, p
+	}
+	params["SERVER_NAME"] = host
+	if req.TLS != nil {
+		params["HTTPS"] = "on"
+		if port == "" {
+			port = "443"
+		}
+	}
+	if port == "" {
+		port = "80"
+	}
+	params["SERVER_PORT"] = port
+
+	if addr, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"] = addr
+		params["REMOTE_PORT"] = port
+	}
+	if req.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		params["CONTENT_TYPE"] = contentType
+	}
+
+	for name, values := range req.Header {
+		if strings.Contains(name, "_") {
+			// Headers with underscores would map to the same param as the
+			// header with hyphens, eg. X_Forwarded_User would be passed as
+			// HTTP_X_FORWARDED_USER and could replace X-Forwarded-User
+			continue
+		}
+		param := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		switch param {
+		case "HTTP_CONTENT_TYPE", "HTTP_CONTENT_LENGTH":
+			continue
+		case "HTTP_PROXY":
+			// Mitigate the httpoxy vulnerability, as CGI applications take the
+			// HTTP_PROXY param to be the HTTP_PROXY environment variable
+			continue
+		}
+		params[param] = strings.Join(values, ", ")
+	}
+
+	for name, value := range f.params {
+		params[name] = value
+	}
+
+	delete(params, "REMOTE_USER")
+	delete(params, "AUTH_TYPE")
+	if user := sessionUser(scope); user != "" {
+		params["REMOTE_USER"] = user
+		params["AUTH_TYPE"] = "oauth2-proxy"
+	}
+	return params
+}
+
+// handleError renders the error page for errors before the response started
+func (f *fastCGIUpstreamProxy) handleError(rw http.ResponseWriter, req *http.Request, err error) {
+	if f.errorHandler != nil {
+		f.errorHandler(rw, req, err)
+		return
+	}
+	logger.Errorf("error proxying to FastCGI upstream %q: %v", f.upstream, err)
+	rw.WriteHeader(http.StatusBadGateway)
+}
+
+// sessionUser returns the user of the request's session, or their email if
+// the session has no user
+func sessionUser(scope *middleware.RequestScope) string {
+	if scope.Session == nil {
+		return ""
+	}
+	if scope.Session.User != "" {
+		return scope.Session.User
+	}
+	return scope.Session.Email
+}
+
+// fastCGIStatus determines the status code of a CGI response from its
+// Status header, which is removed from the response headers.
+// Responses with a Location but no Status are redirects.
+func fastCGIStatus(header textproto.MIMEHeader) (int, error) {
+	status := header.Get("Status")
+	header.Del("Status")
+	if status == "" {
+		if header.Get("Location") != "" {
+			return http.StatusFound, nil
+		}
+		return http.StatusOK, nil
+	}
+
+	code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	if err != nil || code < 100 || code > 999 {
+		return 0, fmt.Errorf("invalid Status %q in response from FastCGI upstream", status)
+	}
+	return code, nil
+}
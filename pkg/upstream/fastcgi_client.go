@@ -0,0 +1,202 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// FastCGI record types and values, as defined by the FastCGI specification
+// https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	fcgiVersion = 1
+
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+
+	// fcgiRequestID is the ID of the request on each connection. Connections
+	// are not reused, so each only ever carries a single request.
+	fcgiRequestID = 1
+
+	fcgiHeaderLen     = 8
+	fcgiMaxContentLen = 65535
+)
+
+// fcgiConn writes a single request to a FastCGI server and reads back its
+// response.
+type fcgiConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// stdout holds the remaining content of the current stdout record
+	stdout []byte
+	done   bool
+}
+
+func newFCGIConn(conn net.Conn) *fcgiConn {
+	return &fcgiConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+// writeRequest begins a request with the params, and sends the body as the
+// request's stdin.
+func (c *fcgiConn) writeRequest(params map[string]string, body io.Reader) error {
+	w := bufio.NewWriter(c.conn)
+
+	// The role is followed by the flags, which don't keep the connection open,
+	// and reserved bytes
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, fcgiBeginRequest, begin); err != nil {
+		return err
+	}
+	if err := writeStream(w, fcgiParams, bytes.NewReader(encodeParams(params))); err != nil {
+		return err
+	}
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	if err := writeStream(w, fcgiStdin, body); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read reads the stdout of the response. Anything written to stderr by the
+// FastCGI server is logged.
+// It returns io.EOF once the server ends the request.
+func (c *fcgiConn) Read(p []byte) (int, error) {
+	for len(c.stdout) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.stdout)
+	c.stdout = c.stdout[n:]
+	return n, nil
+}
+
+// readRecord reads the next record of the response
+func (c *fcgiConn) readRecord() error {
+	header := make([]byte, fcgiHeaderLen)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if header[0] != fcgiVersion {
+		return fmt.Errorf("unsupported FastCGI version %d", header[0])
+	}
+	recordType := header[1]
+	contentLen := int(binary.BigEndian.Uint16(header[4:6]))
+	paddingLen := int(header[6])
+
+	content := make([]byte, contentLen+paddingLen)
+	if _, err := io.ReadFull(c.reader, content); err != nil {
+		return err
+	}
+	content = content[:contentLen]
+
+	switch recordType {
+	case fcgiStdout:
+		c.stdout = content
+	case fcgiStderr:
+		if len(content) > 0 {
+			logger.Errorf("FastCGI upstream stderr: %s", bytes.TrimSpace(content))
+		}
+	case fcgiEndRequest:
+		c.done = true
+	}
+	return nil
+}
+
+// Close closes the connection to the FastCGI server
+func (c *fcgiConn) Close() error {
+	return c.conn.Close()
+}
+
+// writeStream writes the content of the stream as records of the type,
+// followed by the empty record that ends the stream.
+func writeStream(w io.Writer, recordType byte, r io.Reader) error {
+	buf := make([]byte, fcgiMaxContentLen)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := writeRecord(w, recordType, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return writeRecord(w, recordType, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeRecord writes a single record, which must be no longer than
+// fcgiMaxContentLen
+func writeRecord(w io.Writer, recordType byte, content []byte) error {
+	header := make([]byte, fcgiHeaderLen)
+	header[0] = fcgiVersion
+	header[1] = recordType
+	binary.BigEndian.PutUint16(header[2:4], fcgiRequestID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// encodeParams encodes the params as FastCGI name-value pairs, in the order
+// of their names
+func encodeParams(params map[string]string) []byte {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		writeParamLen(&buf, len(name))
+		writeParamLen(&buf, len(params[name]))
+		buf.WriteString(name)
+		buf.WriteString(params[name])
+	}
+	return buf.Bytes()
+}
+
+// writeParamLen writes the length of a name or value with a single byte, or
+// with four bytes and the high bit set when it is too long for one
+func writeParamLen(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n)|1<<31)
+	buf.Write(b)
+}
@@ -0,0 +1,264 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fastCGIResponse is the response of the test FastCGI server, describing the
+// request it received.
+// Params only holds the params that net/http/fcgi doesn't use to build the
+// request itself.
+type fastCGIResponse struct {
+	Method        string            `json:"method"`
+	RequestURI    string            `json:"requestURI"`
+	Host          string            `json:"host"`
+	ContentLength int64             `json:"contentLength"`
+	Header        http.Header       `json:"header"`
+	Params        map[string]string `json:"params"`
+	Body          string            `json:"body"`
+}
+
+var _ = Describe("FastCGI Upstream Suite", func() {
+	var listener net.Listener
+	var upstreamURI *url.URL
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		upstreamURI, err = url.Parse("fcgi://" + listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			_ = fcgi.Serve(listener, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/redirect.php":
+					rw.Header().Set("Location", "/elsewhere")
+					rw.WriteHeader(http.StatusFound)
+					return
+				case "/slow.php":
+					time.Sleep(time.Second)
+				}
+
+				body, _ := ioutil.ReadAll(req.Body)
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusAccepted)
+				_ = json.NewEncoder(rw).Encode(fastCGIResponse{
+					Method:        req.Method,
+					RequestURI:    req.URL.RequestURI(),
+					Host:          req.Host,
+					ContentLength: req.ContentLength,
+					Header:        req.Header,
+					Params:        fcgi.ProcessEnv(req),
+					Body:          string(body),
+				})
+			}))
+		}()
+	})
+
+	AfterEach(func() {
+		Expect(listener.Close()).To(Succeed())
+	})
+
+	serve := func(upstream options.Upstream, req *http.Request, session *sessionsapi.SessionState) *httptest.ResponseRecorder {
+		var proxyErr error
+		handler := newFastCGIUpstreamProxy(upstream, upstreamURI, nil, func(rw http.ResponseWriter, _ *http.Request, err error) {
+			proxyErr = err
+			rw.WriteHeader(http.StatusBadGateway)
+		})
+
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		Expect(middlewareapi.GetRequestScope(req).Upstream).To(Equal(upstream.ID))
+		if rw.Code == http.StatusBadGateway {
+			Expect(proxyErr).To(HaveOccurred())
+		}
+		return rw
+	}
+
+	decode := func(rw *httptest.ResponseRecorder) fastCGIResponse {
+		Expect(rw.Code).To(Equal(http.StatusAccepted))
+		Expect(rw.Header().Get("Content-Type")).To(Equal("application/json"))
+		resp := fastCGIResponse{}
+		Expect(json.Unmarshal(rw.Body.Bytes(), &resp)).To(Succeed())
+		return resp
+	}
+
+	It("passes the request and the identity of the user as params", func() {
+		upstream := options.Upstream{
+			ID:      "php",
+			FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/app/index.php?foo=bar", strings.NewReader("body"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Forwarded-User", "jane")
+		req.Header.Set("Proxy", "http://evil.example.com")
+
+		resp := decode(serve(upstream, req, &sessionsapi.SessionState{User: "jane", Email: "jane@example.com"}))
+		Expect(resp.Body).To(Equal("body"))
+		Expect(resp.Method).To(Equal(http.MethodPost))
+		Expect(resp.RequestURI).To(Equal("/app/index.php?foo=bar"))
+		Expect(resp.Host).To(Equal("example.com"))
+		Expect(resp.ContentLength).To(Equal(int64(4)))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/plain"))
+		Expect(resp.Header.Get("X-Forwarded-User")).To(Equal("jane"))
+		Expect(resp.Header).ToNot(HaveKey("Proxy"))
+		Expect(resp.Params).To(HaveKeyWithValue("SCRIPT_FILENAME", "/var/www/app/index.php"))
+		Expect(resp.Params).To(HaveKeyWithValue("DOCUMENT_ROOT", "/var/www"))
+		Expect(resp.Params).To(HaveKeyWithValue("SERVER_NAME", "example.com"))
+		Expect(resp.Params).To(HaveKeyWithValue("SERVER_PORT", "80"))
+		Expect(resp.Params).To(HaveKeyWithValue("REMOTE_USER", "jane"))
+		Expect(resp.Params).To(HaveKeyWithValue("AUTH_TYPE", "oauth2-proxy"))
+	})
+
+	It("does not pass headers with underscores that could replace other headers", func() {
+		upstream := options.Upstream{
+			ID:      "php",
+			FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header["X_Forwarded_User"] = []string{"admin"}
+
+		resp := decode(serve(upstream, req, &sessionsapi.SessionState{User: "jane"}))
+		Expect(resp.Header).ToNot(HaveKey("X-Forwarded-User"))
+	})
+
+	DescribeTable("sets the script",
+		func(fcgiOpts options.FastCGIOptions, requestPath string, expectedScriptFilename string) {
+			upstream := options.Upstream{ID: "php", FastCGI: &fcgiOpts}
+			req := httptest.NewRequest(http.MethodGet, requestPath, nil)
+
+			resp := decode(serve(upstream, req, nil))
+			Expect(resp.Params).To(HaveKeyWithValue("SCRIPT_FILENAME", expectedScriptFilename))
+		},
+		Entry("with the default index", options.FastCGIOptions{DocumentRoot: "/var/www"}, "/app/", "/var/www/app/index.php"),
+		Entry("with an index", options.FastCGIOptions{DocumentRoot: "/var/www", Index: "main.php"}, "/", "/var/www/main.php"),
+		Entry("with a script filename", options.FastCGIOptions{DocumentRoot: "/var/www", ScriptFilename: "/srv/app/public/index.php"}, "/users/1", "/srv/app/public/index.php"),
+		Entry("for paths escaping the document root", options.FastCGIOptions{DocumentRoot: "/var/www"}, "/../../etc/passwd", "/var/www/etc/passwd"),
+	)
+
+	It("passes the configured params, but not over the user", func() {
+		upstream := options.Upstream{
+			ID: "php",
+			FastCGI: &options.FastCGIOptions{
+				DocumentRoot: "/var/www",
+				Params: map[string]string{
+					"APP_ENV":     "production",
+					"REMOTE_USER": "admin",
+				},
+			},
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+		resp := decode(serve(upstream, req, &sessionsapi.SessionState{Email: "jane@example.com"}))
+		Expect(resp.Params).To(HaveKeyWithValue("APP_ENV", "production"))
+		Expect(resp.Params).To(HaveKeyWithValue("REMOTE_USER", "jane@example.com"))
+
+		resp = decode(serve(upstream, httptest.NewRequest(http.MethodGet, "http://example.com/", nil), nil))
+		Expect(resp.Params).ToNot(HaveKey("REMOTE_USER"))
+	})
+
+	It("passes the access token to upstreams that set passAccessToken", func() {
+		upstream := options.Upstream{
+			ID:              "php",
+			PassAccessToken: true,
+			FastCGI:         &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set(AccessTokenHeader, "forged")
+
+		resp := decode(serve(upstream, req, &sessionsapi.SessionState{User: "jane", AccessToken: "access-token"}))
+		Expect(resp.Header.Get(AccessTokenHeader)).To(Equal("access-token"))
+
+		req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		resp = decode(serve(upstream, req, newSessionWithEncryptedTokens(&sessionsapi.SessionState{User: "jane", AccessToken: "access-token"})))
+		Expect(resp.Header.Get(AccessTokenHeader)).To(Equal("access-token"))
+	})
+
+	It("returns the status and headers of the response", func() {
+		upstream := options.Upstream{
+			ID:      "php",
+			FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+		rw := serve(upstream, httptest.NewRequest(http.MethodGet, "http://example.com/redirect.php", nil), nil)
+		Expect(rw.Code).To(Equal(http.StatusFound))
+		Expect(rw.Header().Get("Location")).To(Equal("/elsewhere"))
+		Expect(rw.Header()).ToNot(HaveKey("Status"))
+	})
+
+	It("renders the error page when the upstream can't be reached", func() {
+		Expect(listener.Close()).To(Succeed())
+		upstream := options.Upstream{
+			ID:      "php",
+			FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+		rw := serve(upstream, httptest.NewRequest(http.MethodGet, "http://example.com/", nil), nil)
+		Expect(rw.Code).To(Equal(http.StatusBadGateway))
+
+		// Closing the listener again in the AfterEach must not fail
+		listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	})
+
+	It("cancels requests once the timeout has passed", func() {
+		timeout := options.Duration(50 * time.Millisecond)
+		upstream := options.Upstream{
+			ID:      "php",
+			Timeout: &timeout,
+			FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+		}
+
+		var proxyErr error
+		handler := newFastCGIUpstreamProxy(upstream, upstreamURI, nil, func(rw http.ResponseWriter, _ *http.Request, err error) {
+			proxyErr = err
+			rw.WriteHeader(http.StatusGatewayTimeout)
+		})
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/slow.php", nil)
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusGatewayTimeout))
+		Expect(errors.Is(proxyErr, context.DeadlineExceeded)).To(BeTrue())
+	})
+
+	DescribeTable("fastCGIStatus",
+		func(header map[string]string, expectedCode int, expectedErr string) {
+			mimeHeader := textproto.MIMEHeader{}
+			for name, value := range header {
+				mimeHeader.Set(name, value)
+			}
+			code, err := fastCGIStatus(mimeHeader)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(code).To(Equal(expectedCode))
+			Expect(mimeHeader).ToNot(HaveKey("Status"))
+		},
+		Entry("without a status", map[string]string{}, http.StatusOK, ""),
+		Entry("with a status", map[string]string{"Status": "404 Not Found"}, http.StatusNotFound, ""),
+		Entry("with a location", map[string]string{"Location": "/foo"}, http.StatusFound, ""),
+		Entry("with a location and a status", map[string]string{"Location": "/foo", "Status": "301"}, http.StatusMovedPermanently, ""),
+		Entry("with an invalid status", map[string]string{"Status": "OK"}, 0, "invalid Status \"OK\" in response from FastCGI upstream"),
+	)
+})
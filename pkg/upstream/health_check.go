@@ -0,0 +1,235 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// healthChecker actively checks the health of an upstream on an interval.
+// The upstream is marked unhealthy once it has failed the unhealthyThreshold
+// consecutive checks, and healthy again once it has passed the
+// healthyThreshold consecutive checks.
+type healthChecker struct {
+	id       string
+	url      string
+	client   *http.Client
+	interval time.Duration
+
+	healthyThreshold   int
+	unhealthyThreshold int
+
+	mutex     sync.RWMutex
+	isHealthy bool
+	successes int
+	failures  int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newHealthChecker creates a healthChecker for the upstream, which is served
+// from the URL u. The checks are not started until start is called.
+func newHealthChecker(upstream options.Upstream, u *url.URL) (*healthChecker, error) {
+	opts := upstream.HealthCheck
+
+	path := opts.Path
+	if path == "" {
+		path = "/"
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := options.DefaultHealthCheckInterval
+	if opts.Interval != nil {
+		interval = opts.Interval.Duration()
+	}
+	timeout := options.DefaultHealthCheckTimeout
+	if opts.Timeout != nil {
+		timeout = opts.Timeout.Duration()
+	}
+	healthyThreshold := options.DefaultHealthCheckHealthyThreshold
+	if opts.HealthyThreshold > 0 {
+		healthyThreshold = opts.HealthyThreshold
+	}
+	unhealthyThreshold := options.DefaultHealthCheckUnhealthyThreshold
+	if opts.UnhealthyThreshold > 0 {
+		unhealthyThreshold = opts.UnhealthyThreshold
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		// Redirects are a response from the upstream, so they pass the check
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// InsecureSkipVerify is a configurable option we allow
+	/* #nosec G402 */
+	if upstream.InsecureSkipTLSVerify {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	return &healthChecker{
+		id:                 upstream.ID,
+		url:                u.ResolveReference(ref).String(),
+		client:             client,
+		interval:           interval,
+		healthyThreshold:   healthyThreshold,
+		unhealthyThreshold: unhealthyThreshold,
+		isHealthy:          true,
+	}, nil
+}
+
+// start checks the health of the upstream straight away, and then every
+// interval until the checker is closed.
+func (c *healthChecker) start() {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-c.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.check(ctx)
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the health checks, cancelling any check in progress.
+func (c *healthChecker) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	return nil
+}
+
+// check requests the health check path from the upstream and records
+// whether it passed
+func (c *healthChecker) check(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		logger.Errorf("unable to create health check request for upstream %q: %v", c.id, err)
+		c.record(false)
+		return
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The checker was closed during the check
+			return
+		}
+		logger.Errorf("health check of upstream %q failed: %v", c.id, err)
+		c.record(false)
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	passed := resp.StatusCode >= 200 && resp.StatusCode < 400
+	if !passed {
+		logger.Errorf("health check of upstream %q failed: unexpected status %d", c.id, resp.StatusCode)
+	}
+	c.record(passed)
+}
+
+// record counts the consecutive passed or failed checks, and marks the
+// upstream healthy or unhealthy once a threshold is reached
+func (c *healthChecker) record(passed bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if passed {
+		c.successes++
+		c.failures = 0
+		if !c.isHealthy && c.successes >= c.healthyThreshold {
+			c.isHealthy = true
+			logger.Printf("upstream %q is healthy after %d passed health checks", c.id, c.successes)
+		}
+		return
+	}
+
+	c.failures++
+	c.successes = 0
+	if c.isHealthy && c.failures >= c.unhealthyThreshold {
+		c.isHealthy = false
+		logger.Errorf("upstream %q is unhealthy after %d failed health checks: requests will not be proxied to it until it recovers", c.id, c.failures)
+	}
+}
+
+// healthy reports whether the upstream is currently healthy
+func (c *healthChecker) healthy() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.isHealthy
+}
+
+// healthCheckedUpstream is the handler of an upstream with health checks
+type healthCheckedUpstream struct {
+	checker *healthChecker
+	handler http.Handler
+	writer  pagewriter.Writer
+}
+
+// newHealthCheckedUpstream wraps the handler of the upstream so that
+// requests are not proxied to the upstream while it is unhealthy
+func newHealthCheckedUpstream(checker *healthChecker, handler http.Handler, writer pagewriter.Writer) *healthCheckedUpstream {
+	return &healthCheckedUpstream{
+		checker: checker,
+		handler: handler,
+		writer:  writer,
+	}
+}
+
+// ServeHTTP proxies the request to the upstream, or renders a service
+// unavailable error page when it is unhealthy
+func (u *healthCheckedUpstream) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !u.checker.healthy() {
+		logger.Errorf("not proxying to upstream %q: the upstream is unhealthy", u.checker.id)
+		opts := pagewriter.ErrorPageOpts{
+			Status:   http.StatusServiceUnavailable,
+			AppError: fmt.Sprintf("Upstream %q is unhealthy", u.checker.id),
+		}
+		if scope := middleware.GetRequestScope(req); scope != nil {
+			opts.RequestID = scope.RequestID
+		}
+		u.writer.WriteErrorPage(rw, opts)
+		return
+	}
+	u.handler.ServeHTTP(rw, req)
+}
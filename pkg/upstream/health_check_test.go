@@ -0,0 +1,221 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health Check Suite", func() {
+	var server *httptest.Server
+	var status int32
+	var checkedPath atomic.Value
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&status, http.StatusOK)
+		checkedPath.Store("")
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			checkedPath.Store(req.URL.Path)
+			rw.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newChecker := func(healthCheck options.HealthCheckOptions) *healthChecker {
+		u, err := url.Parse(server.URL + "/app/")
+		Expect(err).ToNot(HaveOccurred())
+		checker, err := newHealthChecker(options.Upstream{
+			ID:          "backend",
+			URI:         u.String(),
+			HealthCheck: &healthCheck,
+		}, u)
+		Expect(err).ToNot(HaveOccurred())
+		return checker
+	}
+
+	It("uses the defaults", func() {
+		checker := newChecker(options.HealthCheckOptions{})
+		Expect(checker.url).To(Equal(server.URL + "/"))
+		Expect(checker.interval).To(Equal(options.DefaultHealthCheckInterval))
+		Expect(checker.client.Timeout).To(Equal(options.DefaultHealthCheckTimeout))
+		Expect(checker.healthyThreshold).To(Equal(options.DefaultHealthCheckHealthyThreshold))
+		Expect(checker.unhealthyThreshold).To(Equal(options.DefaultHealthCheckUnhealthyThreshold))
+		Expect(checker.healthy()).To(BeTrue())
+	})
+
+	It("checks the health check path of the upstream host", func() {
+		checker := newChecker(options.HealthCheckOptions{Path: "/healthz"})
+		checker.check(context.Background())
+		Expect(checkedPath.Load()).To(Equal("/healthz"))
+	})
+
+	It("marks the upstream unhealthy and healthy once the thresholds are reached", func() {
+		checker := newChecker(options.HealthCheckOptions{
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		})
+
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		checker.check(context.Background())
+		checker.check(context.Background())
+		Expect(checker.healthy()).To(BeTrue())
+		checker.check(context.Background())
+		Expect(checker.healthy()).To(BeFalse())
+
+		atomic.StoreInt32(&status, http.StatusFound)
+		checker.check(context.Background())
+		Expect(checker.healthy()).To(BeFalse())
+		checker.check(context.Background())
+		Expect(checker.healthy()).To(BeTrue())
+	})
+
+	It("only counts consecutive failed checks", func() {
+		checker := newChecker(options.HealthCheckOptions{UnhealthyThreshold: 2})
+
+		checker.record(false)
+		checker.record(true)
+		checker.record(false)
+		Expect(checker.healthy()).To(BeTrue())
+		checker.record(false)
+		Expect(checker.healthy()).To(BeFalse())
+	})
+
+	It("fails checks that time out", func() {
+		timeout := options.Duration(10 * time.Millisecond)
+		checker := newChecker(options.HealthCheckOptions{
+			Timeout:            &timeout,
+			UnhealthyThreshold: 1,
+		})
+		slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+		defer slow.Close()
+		checker.url = slow.URL
+
+		checker.check(context.Background())
+		Expect(checker.healthy()).To(BeFalse())
+	})
+
+	It("does not proxy requests to an unhealthy upstream", func() {
+		checker := newChecker(options.HealthCheckOptions{UnhealthyThreshold: 1})
+		writer := &pagewriter.WriterFuncs{
+			ErrorPageFunc: func(rw http.ResponseWriter, opts pagewriter.ErrorPageOpts) {
+				rw.WriteHeader(opts.Status)
+				_, _ = rw.Write([]byte(opts.RequestID + ": " + opts.AppError))
+			},
+		}
+		handler := newHealthCheckedUpstream(checker, newStaticResponseHandler("backend", nil), writer)
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), &middlewareapi.RequestScope{}))
+		Expect(rw.Code).To(Equal(http.StatusOK))
+
+		checker.record(false)
+		rw = httptest.NewRecorder()
+		handler.ServeHTTP(rw, middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), &middlewareapi.RequestScope{RequestID: "request-id"}))
+		Expect(rw.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rw.Body.String()).To(Equal("request-id: Upstream \"backend\" is unhealthy"))
+	})
+
+	Context("in a weighted group", func() {
+		var stable, canary *healthChecker
+		var group http.Handler
+		var writer pagewriter.Writer
+
+		BeforeEach(func() {
+			stable = newChecker(options.HealthCheckOptions{UnhealthyThreshold: 1, HealthyThreshold: 1})
+			canary = newChecker(options.HealthCheckOptions{UnhealthyThreshold: 1, HealthyThreshold: 1})
+
+			writer = &pagewriter.WriterFuncs{
+				ErrorPageFunc: func(rw http.ResponseWriter, opts pagewriter.ErrorPageOpts) {
+					rw.WriteHeader(opts.Status)
+					_, _ = rw.Write([]byte(opts.RequestID + ": " + opts.AppError))
+				},
+			}
+
+			weight := 1
+			group = newWeightedUpstreamGroup(options.Upstreams{
+				{ID: "stable", Path: "/", Weight: &weight},
+				{ID: "canary", Path: "/", Weight: &weight},
+			}, []http.Handler{
+				newHealthCheckedUpstream(stable, newStaticResponseHandler("stable", nil), &pagewriter.WriterFuncs{}),
+				newHealthCheckedUpstream(canary, newStaticResponseHandler("canary", nil), &pagewriter.WriterFuncs{}),
+			}, writer)
+		})
+
+		serve := func() (int, string, string) {
+			req := httptest.NewRequest("", "/", nil)
+			scope := &middlewareapi.RequestScope{RequestID: "request-id"}
+			req = middlewareapi.AddRequestScope(req, scope)
+			rw := httptest.NewRecorder()
+			group.ServeHTTP(rw, req)
+			return rw.Code, scope.Upstream, rw.Body.String()
+		}
+
+		It("fails over to the healthy upstreams and back once they recover", func() {
+			canary.record(false)
+			for i := 0; i < 4; i++ {
+				_, upstream, _ := serve()
+				Expect(upstream).To(Equal("stable"))
+			}
+
+			canary.record(true)
+			served := []string{}
+			for i := 0; i < 2; i++ {
+				_, upstream, _ := serve()
+				served = append(served, upstream)
+			}
+			Expect(served).To(ConsistOf("stable", "canary"))
+		})
+
+		It("responds with service unavailable when every upstream is unhealthy", func() {
+			stable.record(false)
+			canary.record(false)
+
+			code, upstream, body := serve()
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(upstream).To(BeEmpty())
+			Expect(body).To(Equal("request-id: Every upstream for path \"/\" is unhealthy"))
+		})
+	})
+
+	It("is started by NewProxy and stopped when the proxy is closed", func() {
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		interval := options.Duration(10 * time.Millisecond)
+		proxy, err := NewProxy(options.Upstreams{
+			{
+				ID:   "backend",
+				Path: "/",
+				URI:  server.URL,
+				HealthCheck: &options.HealthCheckOptions{
+					Interval:           &interval,
+					UnhealthyThreshold: 1,
+				},
+			},
+		}, nil, &pagewriter.WriterFuncs{}, options.TrailingSlashStrict)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() int {
+			rw := httptest.NewRecorder()
+			req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/page", nil), &middlewareapi.RequestScope{})
+			proxy.ServeHTTP(rw, req)
+			return rw.Code
+		}).Should(Equal(http.StatusServiceUnavailable))
+
+		Expect(proxy.(*multiUpstreamProxy).Close()).To(Succeed())
+		checkedPath.Store("")
+		Consistently(checkedPath.Load, 50*time.Millisecond).Should(Equal(""))
+	})
+})
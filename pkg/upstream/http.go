@@ -10,6 +10,7 @@ import (
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/yhat/wsutil"
 )
 
@@ -18,6 +19,10 @@ const (
 	// Part of hmacauth
 	SignatureHeader = "GAP-Signature"
 
+	// AccessTokenHeader is the name of the request header containing the
+	// access token, for upstreams that set PassAccessToken
+	AccessTokenHeader = "X-Forwarded-Access-Token"
+
 	httpScheme  = "http"
 	httpsScheme = "https"
 )
@@ -51,6 +56,14 @@ func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *option
 	var wsProxy http.Handler
 	if upstream.ProxyWebSockets == nil || *upstream.ProxyWebSockets {
 		wsProxy = newWebSocketReverseProxy(u, upstream.InsecureSkipTLSVerify)
+
+		if upstream.WebSocketSessionExpiry == options.WebSocketSessionExpiryClose {
+			code := options.DefaultWebSocketCloseCode
+			if upstream.WebSocketCloseCode != nil {
+				code = *upstream.WebSocketCloseCode
+			}
+			wsProxy = newSessionExpiryWebSocketHandler(wsProxy, code)
+		}
 	}
 
 	var auth hmacauth.HmacAuth
@@ -58,20 +71,27 @@ func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *option
 		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), SignatureHeader, SignatureHeaders)
 	}
 
+	// The rules are checked when the upstream handler is created
+	rules, _ := newRewriteRules(upstream.RewriteRules)
+
 	return &httpUpstreamProxy{
-		upstream:  upstream.ID,
-		handler:   proxy,
-		wsHandler: wsProxy,
-		auth:      auth,
+		upstream:        upstream.ID,
+		handler:         proxy,
+		wsHandler:       wsProxy,
+		auth:            auth,
+		passAccessToken: upstream.PassAccessToken,
+		rewriteRequest:  newRequestRewriter(rules),
 	}
 }
 
 // httpUpstreamProxy represents a single HTTP(S) upstream proxy
 type httpUpstreamProxy struct {
-	upstream  string
-	handler   http.Handler
-	wsHandler http.Handler
-	auth      hmacauth.HmacAuth
+	upstream        string
+	handler         http.Handler
+	wsHandler       http.Handler
+	auth            hmacauth.HmacAuth
+	passAccessToken bool
+	rewriteRequest  func(*http.Request) *http.Request
 }
 
 // ServeHTTP proxies requests to the upstream provider while signing the
@@ -82,9 +102,22 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	// A scope should always be injected before this handler is called.
 	scope.Upstream = h.upstream
 
+	// The access token must be injected before the request is signed
+	if h.passAccessToken {
+		setAccessTokenHeader(req, scope)
+	}
+
 	// TODO (@NickMeves) - Deprecate GAP-Signature & remove GAP-Auth
 	if h.auth != nil {
 		req.Header.Set("GAP-Auth", rw.Header().Get("GAP-Auth"))
+	}
+	// The rewrite rules are applied before the request is signed, so that the
+	// signature matches the request sent to the upstream, and before WebSocket
+	// requests are proxied
+	if h.rewriteRequest != nil {
+		req = h.rewriteRequest(req)
+	}
+	if h.auth != nil {
 		h.auth.SignRequest(req)
 	}
 	if h.wsHandler != nil && strings.EqualFold(req.Header.Get("Connection"), "upgrade") && req.Header.Get("Upgrade") == "websocket" {
@@ -94,6 +127,25 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	}
 }
 
+// setAccessTokenHeader replaces any access token header sent by the client
+// with the access token of the session, if there is one.
+// The header is omitted when the tokens of the session cannot be decrypted.
+func setAccessTokenHeader(req *http.Request, scope *middleware.RequestScope) {
+	req.Header.Del(AccessTokenHeader)
+	if scope.Session == nil {
+		return
+	}
+	// Sessions with only their tokens encrypted are not decrypted until the
+	// tokens are needed, which may not have happened yet for this request
+	if err := scope.Session.DecryptTokens(); err != nil {
+		logger.Errorf("Unable to decrypt the access token for upstream %q: %v", scope.Upstream, err)
+		return
+	}
+	if scope.Session.AccessToken != "" {
+		req.Header.Set(AccessTokenHeader, scope.Session.AccessToken)
+	}
+}
+
 // newReverseProxy creates a new reverse proxy for proxying requests to upstream
 // servers based on the upstream configuration provided.
 // The proxy should render an error page if there are failures connecting to the
@@ -123,12 +175,54 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 		setProxyUpstreamHostHeader(proxy, target)
 	}
 
+	// The rules are checked when the upstream handler is created
+	rules, _ := newRewriteRules(upstream.RewriteRules)
+	proxy.ModifyResponse = newResponseModifier(upstream, rules)
+
 	// Set the error handler so that upstream connection failures render the
 	// error page instead of sending a empty response
 	if errorHandler != nil {
 		proxy.ErrorHandler = errorHandler
 	}
-	return proxy
+
+	var handler http.Handler = proxy
+	if upstream.EventStreamFlushInterval != nil && upstream.EventStreamFlushInterval.Duration() > 0 {
+		handler = newEventStreamFlusher(upstream.EventStreamFlushInterval.Duration(), handler)
+	}
+	if upstream.Timeout != nil && upstream.Timeout.Duration() > 0 {
+		handler = newRequestTimeoutHandler(upstream.Timeout.Duration(), handler)
+	}
+	return handler
+}
+
+// newResponseModifier creates the ModifyResponse hook for the ReverseProxy.
+// It prevents buffering of Server-Sent Events, applies the configured
+// handling to redirects to an identity provider and upstream Set-Cookie
+// headers, and then the response rewrite rules.
+func newResponseModifier(upstream options.Upstream, rules []rewriteRule) func(*http.Response) error {
+	authRedirectModifier := newAuthRedirectModifier(upstream)
+	setCookieModifier := newSetCookieModifier(upstream)
+	rewriteModifier := newResponseRewriteModifier(rules)
+
+	return func(resp *http.Response) error {
+		if err := setEventStreamHeaders(resp); err != nil {
+			return err
+		}
+		if authRedirectModifier != nil {
+			if err := authRedirectModifier(resp); err != nil {
+				return err
+			}
+		}
+		if setCookieModifier != nil {
+			if err := setCookieModifier(resp); err != nil {
+				return err
+			}
+		}
+		if rewriteModifier != nil {
+			return rewriteModifier(resp)
+		}
+		return nil
+	}
 }
 
 // setProxyUpstreamHostHeader sets the proxy.Director so that upstream requests
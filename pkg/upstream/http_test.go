@@ -1,6 +1,7 @@
 package upstream
 
 import (
+	"bufio"
 	"bytes"
 	"crypto"
 	"crypto/tls"
@@ -15,6 +16,8 @@ import (
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -37,6 +40,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		body                   []byte
 		passUpstreamHostHeader bool
 		signatureData          *options.SignatureData
+		rewriteRules           []options.RewriteRule
 		existingHeaders        map[string]string
 		expectedResponse       testHTTPResponse
 		expectedUpstream       string
@@ -68,6 +72,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 				ProxyWebSockets:       &falsum,
 				InsecureSkipTLSVerify: false,
 				FlushInterval:         &flush,
+				RewriteRules:          in.rewriteRules,
 			}
 
 			Expect(in.serverAddr).ToNot(BeNil())
@@ -254,6 +259,40 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			},
 			expectedUpstream: "withSignature",
 		}),
+		Entry("with a signature and a path rewrite rule", &httpUpstreamTableInput{
+			id:         "withSignature",
+			serverAddr: &serverAddr,
+			target:     "http://example.localhost/rewritten/withSignature",
+			method:     "GET",
+			body:       []byte{},
+			signatureData: &options.SignatureData{
+				Hash: crypto.SHA256,
+				Key:  "key",
+			},
+			rewriteRules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/rewritten/", Replacement: "/"},
+			},
+			errorHandler: nil,
+			expectedResponse: testHTTPResponse{
+				code: 200,
+				header: map[string][]string{
+					contentType: {applicationJSON},
+				},
+				request: testHTTPRequest{
+					Method: "GET",
+					URL:    "http://example.localhost/withSignature",
+					Header: map[string][]string{
+						gapAuth: {""},
+						// The signature of the rewritten request
+						gapSignature: {"sha256 osMWI8Rr0Zr5HgNq6wakrgJITVJQMmFN1fXCesrqrmM="},
+					},
+					Body:       []byte{},
+					Host:       "example.localhost",
+					RequestURI: "http://example.localhost/withSignature",
+				},
+			},
+			expectedUpstream: "withSignature",
+		}),
 		Entry("with existing headers", &httpUpstreamTableInput{
 			id:           "existingHeaders",
 			serverAddr:   &serverAddr,
@@ -348,6 +387,66 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		Expect(req.Host).To(Equal(strings.TrimPrefix(serverAddr, "http://")))
 	})
 
+	type passAccessTokenTableInput struct {
+		passAccessToken bool
+		session         *sessionsapi.SessionState
+		existingHeader  string
+		expectedHeader  []string
+	}
+
+	DescribeTable("ServeHTTP with passAccessToken",
+		func(in passAccessTokenTableInput) {
+			req := httptest.NewRequest("", "http://example.localhost/foo", nil)
+			if in.existingHeader != "" {
+				req.Header.Set(AccessTokenHeader, in.existingHeader)
+			}
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: in.session})
+
+			upstream := options.Upstream{
+				ID:              "gateway",
+				ProxyWebSockets: &falsum,
+				PassAccessToken: in.passAccessToken,
+			}
+			u, err := url.Parse(serverAddr)
+			Expect(err).ToNot(HaveOccurred())
+
+			httpUpstream, ok := newHTTPUpstreamProxy(upstream, u, nil, nil).(*httpUpstreamProxy)
+			Expect(ok).To(BeTrue())
+
+			var upstreamHeader http.Header
+			httpUpstream.handler = http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				upstreamHeader = req.Header
+			})
+
+			httpUpstream.ServeHTTP(httptest.NewRecorder(), req)
+			Expect(upstreamHeader.Values(AccessTokenHeader)).To(Equal(in.expectedHeader))
+		},
+		Entry("is disabled by default", passAccessTokenTableInput{
+			session: &sessionsapi.SessionState{AccessToken: "access-token"},
+		}),
+		Entry("injects the access token of the session", passAccessTokenTableInput{
+			passAccessToken: true,
+			session:         &sessionsapi.SessionState{AccessToken: "access-token"},
+			existingHeader:  "client-token",
+			expectedHeader:  []string{"access-token"},
+		}),
+		Entry("removes the header from the client without a session", passAccessTokenTableInput{
+			passAccessToken: true,
+			existingHeader:  "client-token",
+		}),
+		Entry("removes the header from the client without an access token", passAccessTokenTableInput{
+			passAccessToken: true,
+			session:         &sessionsapi.SessionState{Email: "user@example.com"},
+			existingHeader:  "client-token",
+		}),
+		Entry("decrypts the access token of a session with encrypted tokens", passAccessTokenTableInput{
+			passAccessToken: true,
+			session:         newSessionWithEncryptedTokens(&sessionsapi.SessionState{AccessToken: "access-token"}),
+			existingHeader:  "client-token",
+			expectedHeader:  []string{"access-token"},
+		}),
+	)
+
 	type newUpstreamTableInput struct {
 		proxyWebSockets bool
 		flushInterval   options.Duration
@@ -426,6 +525,76 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		}),
 	)
 
+	Context("when streaming a response", func() {
+		var streamServer, proxyServer *httptest.Server
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			proxyServer = nil
+
+			// The upstream sends the first chunk of a download, then holds the
+			// connection open. The Content-Length is set so that the chunk is
+			// only flushed according to the flush interval.
+			streamServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set(contentType, "application/octet-stream")
+				rw.Header().Set("Content-Length", "12")
+				rw.WriteHeader(http.StatusOK)
+				fmt.Fprint(rw, "first\n")
+				rw.(http.Flusher).Flush()
+				<-release
+				fmt.Fprint(rw, "second")
+			}))
+		})
+
+		AfterEach(func() {
+			close(release)
+			if proxyServer != nil {
+				proxyServer.Close()
+			}
+			streamServer.Close()
+		})
+
+		// readFirstLine proxies a request to the stream server with the given
+		// flush interval and reads the first line of the response
+		readFirstLine := func(flushInterval options.Duration) <-chan string {
+			u, err := url.Parse(streamServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			handler := newHTTPUpstreamProxy(options.Upstream{
+				ID:            "stream",
+				FlushInterval: &flushInterval,
+			}, u, nil, nil)
+
+			proxyServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+				handler.ServeHTTP(rw, req)
+			}))
+
+			lines := make(chan string, 1)
+			go func() {
+				resp, err := http.Get(proxyServer.URL)
+				if err != nil {
+					return
+				}
+				defer resp.Body.Close()
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				lines <- line
+			}()
+			return lines
+		}
+
+		It("flushes each write immediately with a negative flush interval", func() {
+			lines := readFirstLine(options.Duration(-1))
+			Eventually(lines, 5*time.Second).Should(Receive(Equal("first\n")))
+		})
+
+		It("buffers writes until the flush interval", func() {
+			lines := readFirstLine(options.Duration(time.Hour))
+			Consistently(lines, 500*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
 	Context("with a websocket proxy", func() {
 		var proxyServer *httptest.Server
 
@@ -476,5 +645,48 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(response.StatusCode).To(Equal(200))
 		})
+
+		It("applies the request rewrite rules to websockets", func() {
+			u, err := url.Parse(serverAddr)
+			Expect(err).ToNot(HaveOccurred())
+
+			handler := newHTTPUpstreamProxy(options.Upstream{
+				ID:              "websocketProxy",
+				ProxyWebSockets: &truth,
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteRequestHeader, Header: "Origin", Match: "^http://example.localhost$", Replacement: "http://upstream.localhost"},
+				},
+			}, u, nil, nil)
+			rewriteServer := httptest.NewServer(middleware.NewScope(false, "X-Request-Id")(handler))
+			defer rewriteServer.Close()
+
+			wsAddr := fmt.Sprintf("ws://%s/", rewriteServer.Listener.Addr().String())
+			ws, err := websocket.Dial(wsAddr, "", "http://example.localhost")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(websocket.Message.Send(ws, []byte("Hello, world!"))).To(Succeed())
+			var response testWebSocketResponse
+			Expect(websocket.JSON.Receive(ws, &response)).To(Succeed())
+			Expect(response.Origin).To(Equal("http://upstream.localhost"))
+		})
 	})
 })
+
+// newSessionWithEncryptedTokens encodes and decodes the session with only its
+// tokens encrypted, so that the tokens are not decrypted until they are needed
+func newSessionWithEncryptedTokens(session *sessionsapi.SessionState) *sessionsapi.SessionState {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	c, err := encryption.NewCFBCipher(secret)
+	if err != nil {
+		panic(err)
+	}
+	encoded, err := session.EncodeSessionStateWithEncryptedTokens(c, secret, false)
+	if err != nil {
+		panic(err)
+	}
+	decoded, err := sessionsapi.DecodeSessionStateWithEncryptedTokens(encoded, c, secret, false)
+	if err != nil {
+		panic(err)
+	}
+	return decoded
+}
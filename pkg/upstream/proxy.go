@@ -14,6 +14,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
 
 // ProxyErrorHandler is a function that will be used to render error pages when
@@ -22,68 +23,170 @@ type ProxyErrorHandler func(http.ResponseWriter, *http.Request, error)
 
 // NewProxy creates a new multiUpstreamProxy that can serve requests directed to
 // multiple upstreams.
-func NewProxy(upstreams options.Upstreams, sigData *options.SignatureData, writer pagewriter.Writer) (http.Handler, error) {
+// With the normalize trailing slash policy, requests that don't match any
+// upstream are served by the upstream matching the path with its trailing
+// slash added or removed, rather than being redirected.
+func NewProxy(upstreams options.Upstreams, sigData *options.SignatureData, writer pagewriter.Writer, trailingSlashPolicy string) (http.Handler, error) {
 	m := &multiUpstreamProxy{
-		serveMux: mux.NewRouter(),
+		serveMux:         mux.NewRouter(),
+		normalizeSlashes: trailingSlashPolicy == options.TrailingSlashNormalize,
 	}
 
-	for _, upstream := range sortByPathLongest(upstreams) {
-		if upstream.Static {
-			if err := m.registerStaticResponseHandler(upstream, writer); err != nil {
-				return nil, fmt.Errorf("could not register static upstream %q: %v", upstream.ID, err)
+	for _, group := range groupByPath(sortByPathLongest(upstreams)) {
+		handlers := make([]http.Handler, 0, len(group))
+		for _, upstream := range group {
+			handler, err := newUpstreamHandler(upstream, sigData, writer)
+			if err != nil {
+				return nil, err
 			}
-			continue
+			handler, err = m.newHealthCheckedUpstream(upstream, handler, writer)
+			if err != nil {
+				return nil, fmt.Errorf("could not configure health checks for upstream %q: %v", upstream.ID, err)
+			}
+			handlers = append(handlers, handler)
 		}
 
-		u, err := url.Parse(upstream.URI)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing URI for upstream %q: %w", upstream.ID, err)
+		handler := handlers[0]
+		switch {
+		case group[0].RoutingClaim != "":
+			handler = newClaimUpstreamGroup(group, handlers, writer)
+		case len(group) > 1:
+			handler = newWeightedUpstreamGroup(group, handlers, writer)
 		}
-		switch u.Scheme {
-		case fileScheme:
-			if err := m.registerFileServer(upstream, u, writer); err != nil {
-				return nil, fmt.Errorf("could not register file upstream %q: %v", upstream.ID, err)
-			}
-		case httpScheme, httpsScheme:
-			if err := m.registerHTTPUpstreamProxy(upstream, u, sigData, writer); err != nil {
-				return nil, fmt.Errorf("could not register HTTP upstream %q: %v", upstream.ID, err)
-			}
-		default:
-			return nil, fmt.Errorf("unknown scheme for upstream %q: %q", upstream.ID, u.Scheme)
+		if err := m.registerHandler(group[0], handler, writer); err != nil {
+			return nil, fmt.Errorf("could not register upstream %q: %v", group[0].ID, err)
 		}
 	}
 
-	registerTrailingSlashHandler(m.serveMux)
+	if !m.normalizeSlashes {
+		registerTrailingSlashHandler(m.serveMux)
+	}
+
+	// The health checks are only started once the proxy has been built
+	for _, checker := range m.healthCheckers {
+		checker.start()
+	}
 	return m, nil
 }
 
 // multiUpstreamProxy will serve requests directed to multiple upstream servers
 // registered in the serverMux.
 type multiUpstreamProxy struct {
-	serveMux *mux.Router
+	serveMux         *mux.Router
+	normalizeSlashes bool
+	healthCheckers   []*healthChecker
+}
+
+// Close stops the health checks of the upstreams.
+func (m *multiUpstreamProxy) Close() error {
+	for _, checker := range m.healthCheckers {
+		_ = checker.Close()
+	}
+	return nil
+}
+
+// newHealthCheckedUpstream wraps the handler of an HTTP(S) upstream with
+// health checks so that requests are not proxied to it while it is unhealthy.
+// Other upstreams are not health checked, so their handler is unchanged.
+func (m *multiUpstreamProxy) newHealthCheckedUpstream(upstream options.Upstream, handler http.Handler, writer pagewriter.Writer) (http.Handler, error) {
+	if upstream.HealthCheck == nil || upstream.Static {
+		return handler, nil
+	}
+	u, err := url.Parse(upstream.URI)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != httpScheme && u.Scheme != httpsScheme {
+		return handler, nil
+	}
+
+	checker, err := newHealthChecker(upstream, u)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("checking health of upstream %q every %s", upstream.ID, checker.interval)
+	m.healthCheckers = append(m.healthCheckers, checker)
+	return newHealthCheckedUpstream(checker, handler, writer), nil
 }
 
 // ServerHTTP handles HTTP requests.
 func (m *multiUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if m.normalizeSlashes {
+		if handler := m.matchToggledSlash(req); handler != nil {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+	}
 	m.serveMux.ServeHTTP(rw, req)
 }
 
-// registerStaticResponseHandler registers a static response handler with at the given path.
-func (m *multiUpstreamProxy) registerStaticResponseHandler(upstream options.Upstream, writer pagewriter.Writer) error {
-	logger.Printf("mapping path %q => static response %d", upstream.Path, derefStaticCode(upstream.StaticCode))
-	return m.registerHandler(upstream, newStaticResponseHandler(upstream.ID, upstream.StaticCode), writer)
+// matchToggledSlash finds the handler for a request that doesn't match any
+// upstream, but would match with its trailing slash added or removed.
+// The request is served with its original path, so the upstream receives
+// the path the client requested.
+func (m *multiUpstreamProxy) matchToggledSlash(req *http.Request) http.Handler {
+	if m.serveMux.Match(req, &mux.RouteMatch{}) {
+		return nil
+	}
+	toggled, ok := requestutil.ToggleTrailingSlash(req.URL.Path)
+	if !ok {
+		return nil
+	}
+
+	toggledReq := req.Clone(req.Context())
+	toggledReq.URL.Path = toggled
+	toggledReq.URL.RawPath = ""
+	match := &mux.RouteMatch{}
+	if !m.serveMux.Match(toggledReq, match) {
+		return nil
+	}
+	return match.Handler
 }
 
-// registerFileServer registers a new fileServer based on the configuration given.
-func (m *multiUpstreamProxy) registerFileServer(upstream options.Upstream, u *url.URL, writer pagewriter.Writer) error {
-	logger.Printf("mapping path %q => file system %q", upstream.Path, u.Path)
-	return m.registerHandler(upstream, newFileServer(upstream.ID, upstream.Path, u.Path), writer)
+// newUpstreamHandler creates the handler for the upstream based on its
+// configuration and URI scheme.
+func newUpstreamHandler(upstream options.Upstream, sigData *options.SignatureData, writer pagewriter.Writer) (http.Handler, error) {
+	if upstream.Static {
+		logger.Printf("mapping path %q => static response %d", upstream.Path, derefStaticCode(upstream.StaticCode))
+		return newStaticResponseHandler(upstream.ID, upstream.StaticCode), nil
+	}
+
+	u, err := url.Parse(upstream.URI)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing URI for upstream %q: %w", upstream.ID, err)
+	}
+	switch u.Scheme {
+	case fileScheme:
+		logger.Printf("mapping path %q => file system %q", upstream.Path, u.Path)
+		return newFileServer(upstream.ID, upstream.Path, u.Path), nil
+	case httpScheme, httpsScheme:
+		if _, err := newRewriteRules(upstream.RewriteRules); err != nil {
+			return nil, fmt.Errorf("error parsing rewrite rules for upstream %q: %w", upstream.ID, err)
+		}
+		logger.Printf("mapping path %q => upstream %q", upstream.Path, upstream.URI)
+		return newHTTPUpstreamProxy(upstream, u, sigData, writer.ProxyErrorHandler), nil
+	case fcgiScheme, fcgiUnixScheme:
+		logger.Printf("mapping path %q => FastCGI upstream %q", upstream.Path, upstream.URI)
+		return newFastCGIUpstreamProxy(upstream, u, sigData, writer.ProxyErrorHandler), nil
+	default:
+		return nil, fmt.Errorf("unknown scheme for upstream %q: %q", upstream.ID, u.Scheme)
+	}
 }
 
-// registerHTTPUpstreamProxy registers a new httpUpstreamProxy based on the configuration given.
-func (m *multiUpstreamProxy) registerHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, writer pagewriter.Writer) error {
-	logger.Printf("mapping path %q => upstream %q", upstream.Path, upstream.URI)
-	return m.registerHandler(upstream, newHTTPUpstreamProxy(upstream, u, sigData, writer.ProxyErrorHandler), writer)
+// groupByPath groups upstreams that share the same Path, keeping the order of
+// the first upstream with each Path.
+func groupByPath(in options.Upstreams) []options.Upstreams {
+	groups := []options.Upstreams{}
+	index := make(map[string]int)
+	for _, upstream := range in {
+		if i, ok := index[upstream.Path]; ok {
+			groups[i] = append(groups[i], upstream)
+			continue
+		}
+		index[upstream.Path] = len(groups)
+		groups = append(groups, options.Upstreams{upstream})
+	}
+	return groups
 }
 
 // registerHandler ensures the given handler is regiestered with the serveMux.
@@ -92,7 +92,7 @@ var _ = Describe("Proxy Suite", func() {
 			}
 
 			var err error
-			upstreamServer, err = NewProxy(upstreams, sigData, writer)
+			upstreamServer, err = NewProxy(upstreams, sigData, writer, options.TrailingSlashStrict)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -313,6 +313,69 @@ var _ = Describe("Proxy Suite", func() {
 		)
 	})
 
+	Context("with the normalize trailing slash policy", func() {
+		var proxy http.Handler
+
+		BeforeEach(func() {
+			ok := http.StatusOK
+			accepted := http.StatusAccepted
+
+			var err error
+			proxy, err = NewProxy(options.Upstreams{
+				{
+					ID:         "app-backend",
+					Path:       "/app/",
+					Static:     true,
+					StaticCode: &ok,
+				},
+				{
+					ID:         "exact-backend",
+					Path:       "/exact",
+					Static:     true,
+					StaticCode: &accepted,
+				},
+			}, nil, &pagewriter.WriterFuncs{}, options.TrailingSlashNormalize)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		DescribeTable("Proxy ServeHTTP",
+			func(target string, expectedCode int, expectedUpstream string) {
+				req := middlewareapi.AddRequestScope(
+					httptest.NewRequest("", target, nil),
+					&middlewareapi.RequestScope{},
+				)
+				rw := httptest.NewRecorder()
+				proxy.ServeHTTP(rw, req)
+
+				Expect(rw.Code).To(Equal(expectedCode))
+				Expect(middlewareapi.GetRequestScope(req).Upstream).To(Equal(expectedUpstream))
+				Expect(rw.Header().Get("Location")).To(BeEmpty())
+			},
+			Entry("with a prefix path", "http://example.localhost/app/", 200, "app-backend"),
+			Entry("with a path under the prefix", "http://example.localhost/app/users", 200, "app-backend"),
+			Entry("with a prefix path missing the trailing slash", "http://example.localhost/app", 200, "app-backend"),
+			Entry("with a path sharing the prefix without a slash", "http://example.localhost/application", 404, ""),
+			Entry("with an exact path", "http://example.localhost/exact", 202, "exact-backend"),
+			Entry("with an exact path and a trailing slash", "http://example.localhost/exact/", 202, "exact-backend"),
+			Entry("with a path under the exact path", "http://example.localhost/exact/users", 404, ""),
+			Entry("with a path sharing the exact path", "http://example.localhost/exactly", 404, ""),
+		)
+	})
+
+	It("NewProxy returns an error for invalid rewrite rules", func() {
+		_, err := NewProxy(options.Upstreams{
+			{
+				ID:   "http-backend",
+				Path: "/http/",
+				URI:  serverAddr,
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Location", Match: "(foo"},
+				},
+			},
+		}, nil, &pagewriter.WriterFuncs{}, options.TrailingSlashStrict)
+		Expect(err).To(MatchError("error parsing rewrite rules for upstream \"http-backend\": invalid match \"(foo\" for rewrite rule 0: error parsing regexp: missing closing ): `(foo`"))
+	})
+
 	Context("sortByPathLongest", func() {
 		type sortByPathLongestTableInput struct {
 			input          options.Upstreams
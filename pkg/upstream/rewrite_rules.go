@@ -0,0 +1,139 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// rewriteRule is a compiled options.RewriteRule.
+type rewriteRule struct {
+	target      string
+	header      string
+	match       *regexp.Regexp
+	replacement string
+}
+
+// newRewriteRules compiles the rewrite rules, keeping them in order.
+// If any of the rules is invalid, no rules are returned.
+func newRewriteRules(rules []options.RewriteRule) ([]rewriteRule, error) {
+	compiled := make([]rewriteRule, 0, len(rules))
+	for i, rule := range rules {
+		match, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match %q for rewrite rule %d: %v", rule.Match, i, err)
+		}
+		compiled = append(compiled, rewriteRule{
+			target:      rule.Target,
+			header:      http.CanonicalHeaderKey(rule.Header),
+			match:       match,
+			replacement: rule.Replacement,
+		})
+	}
+	return compiled, nil
+}
+
+// rewrite replaces each match within the value.
+func (r rewriteRule) rewrite(value string) string {
+	return r.match.ReplaceAllString(value, r.replacement)
+}
+
+// rewriteHeader rewrites each value of the rule's header.
+func (r rewriteRule) rewriteHeader(header http.Header) {
+	values := header.Values(r.header)
+	if len(values) == 0 {
+		return
+	}
+
+	header.Del(r.header)
+	for _, value := range values {
+		header.Add(r.header, r.rewrite(value))
+	}
+}
+
+// newRequestRewriter creates a function that applies the request rules to a
+// clone of the request, in order, so that the rewritten request isn't seen
+// by the handlers before the upstream.
+// If there are no request rules, nil is returned.
+func newRequestRewriter(rules []rewriteRule) func(*http.Request) *http.Request {
+	requestRules := []rewriteRule{}
+	for _, rule := range rules {
+		if rule.target == options.RewriteRequestPath || rule.target == options.RewriteRequestHeader {
+			requestRules = append(requestRules, rule)
+		}
+	}
+	if len(requestRules) == 0 {
+		return nil
+	}
+
+	return func(req *http.Request) *http.Request {
+		req = req.Clone(req.Context())
+		for _, rule := range requestRules {
+			switch rule.target {
+			case options.RewriteRequestPath:
+				rewriteRequestPath(req, rule)
+			case options.RewriteRequestHeader:
+				rule.rewriteHeader(req.Header)
+			}
+		}
+		return req
+	}
+}
+
+// rewriteRequestPath rewrites the path of the request URI, which the proxy
+// director sends to the upstream, leaving the scheme and host of absolute
+// request URIs and the query string unchanged.
+// The path of the URL is updated to match, as it is signed and used by the
+// WebSocket proxy.
+func rewriteRequestPath(req *http.Request, rule rewriteRule) {
+	prefix, path, query := "", req.RequestURI, ""
+	if !strings.HasPrefix(path, "/") {
+		if i := strings.Index(path, "://"); i >= 0 {
+			end := len(path)
+			if j := strings.IndexAny(path[i+3:], "/?"); j >= 0 {
+				end = i + 3 + j
+			}
+			prefix, path = path[:end], path[end:]
+		}
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	req.RequestURI = prefix + rule.rewrite(path) + query
+
+	u, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		logger.Errorf("Error parsing rewritten request URI %q: %v", req.RequestURI, err)
+		return
+	}
+	req.URL.Path = u.Path
+	req.URL.RawPath = u.RawPath
+}
+
+// newResponseRewriteModifier creates a function that can be used within the
+// ModifyResponse hook of a ReverseProxy to apply the response rules, in
+// order.
+// If there are no response rules, nil is returned.
+func newResponseRewriteModifier(rules []rewriteRule) func(*http.Response) error {
+	responseRules := []rewriteRule{}
+	for _, rule := range rules {
+		if rule.target == options.RewriteResponseHeader {
+			responseRules = append(responseRules, rule)
+		}
+	}
+	if len(responseRules) == 0 {
+		return nil
+	}
+
+	return func(resp *http.Response) error {
+		for _, rule := range responseRules {
+			rule.rewriteHeader(resp.Header)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,204 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rewrite rules", func() {
+	It("newRewriteRules returns an error for an invalid match", func() {
+		rules, err := newRewriteRules([]options.RewriteRule{
+			{Target: options.RewriteRequestPath, Match: "^/foo"},
+			{Target: options.RewriteRequestPath, Match: "(foo"},
+		})
+		Expect(err).To(MatchError("invalid match \"(foo\" for rewrite rule 1: error parsing regexp: missing closing ): `(foo`"))
+		Expect(rules).To(BeNil())
+	})
+
+	type requestRewriteTableInput struct {
+		rules          []options.RewriteRule
+		requestURI     string
+		header         http.Header
+		expectedOpaque string
+		expectedPath   string
+		expectedHeader http.Header
+	}
+
+	DescribeTable("newRequestRewriter",
+		func(in requestRewriteTableInput) {
+			rules, err := newRewriteRules(in.rules)
+			Expect(err).ToNot(HaveOccurred())
+
+			target, err := url.Parse("http://upstream:8080")
+			Expect(err).ToNot(HaveOccurred())
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			setProxyDirector(proxy)
+
+			original := httptest.NewRequest("", in.requestURI, nil)
+			for key, values := range in.header {
+				original.Header[key] = values
+			}
+
+			req := original
+			if rewrite := newRequestRewriter(rules); rewrite != nil {
+				req = rewrite(original)
+				Expect(original.RequestURI).To(Equal(in.requestURI))
+			}
+			if in.expectedPath != "" {
+				Expect(req.URL.EscapedPath()).To(Equal(in.expectedPath))
+			}
+
+			proxy.Director(req)
+			Expect(req.URL.Opaque).To(Equal(in.expectedOpaque))
+			for key, values := range in.expectedHeader {
+				Expect(req.Header.Values(key)).To(Equal(values))
+			}
+		},
+		Entry("with no rules", requestRewriteTableInput{
+			requestURI:     "/foo/bar?baz=1",
+			expectedOpaque: "/foo/bar?baz=1",
+		}),
+		Entry("with a path rule", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/foo/(.*)$", Replacement: "/api/$1"},
+			},
+			requestURI:     "/foo/bar?baz=/foo/",
+			expectedOpaque: "/api/bar?baz=/foo/",
+			expectedPath:   "/api/bar",
+		}),
+		Entry("with a path rule and encoded slashes", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/foo/(.*)$", Replacement: "/api/$1"},
+			},
+			requestURI:     "/foo/a%2Fb",
+			expectedOpaque: "/api/a%2Fb",
+			expectedPath:   "/api/a%2Fb",
+		}),
+		Entry("with a path rule and an absolute request URI", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/foo/(.*)$", Replacement: "/api/$1"},
+			},
+			requestURI:     "http://example.localhost/foo/bar?baz=1",
+			expectedOpaque: "http://example.localhost/api/bar?baz=1",
+			expectedPath:   "/api/bar",
+		}),
+		Entry("with a path rule that doesn't match", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/other/(.*)$", Replacement: "/api/$1"},
+			},
+			requestURI:     "/foo/bar",
+			expectedOpaque: "/foo/bar",
+		}),
+		Entry("with path rules applied in order", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestPath, Match: "^/foo/", Replacement: "/bar/"},
+				{Target: options.RewriteRequestPath, Match: "^/bar/", Replacement: "/baz/"},
+			},
+			requestURI:     "/foo/index.html",
+			expectedOpaque: "/baz/index.html",
+			expectedPath:   "/baz/index.html",
+		}),
+		Entry("with a request header rule", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteRequestHeader, Header: "referer", Match: "^https://example.com", Replacement: "http://upstream:8080"},
+			},
+			requestURI: "/foo",
+			header: http.Header{
+				"Referer":  {"https://example.com/page"},
+				"X-Origin": {"https://example.com"},
+			},
+			expectedOpaque: "/foo",
+			expectedHeader: http.Header{
+				"Referer":  {"http://upstream:8080/page"},
+				"X-Origin": {"https://example.com"},
+			},
+		}),
+		Entry("with a response header rule", requestRewriteTableInput{
+			rules: []options.RewriteRule{
+				{Target: options.RewriteResponseHeader, Header: "Referer", Match: "^https://example.com", Replacement: "http://upstream:8080"},
+			},
+			requestURI:     "/foo",
+			header:         http.Header{"Referer": {"https://example.com/page"}},
+			expectedOpaque: "/foo",
+			expectedHeader: http.Header{"Referer": {"https://example.com/page"}},
+		}),
+	)
+
+	type responseRewriteTableInput struct {
+		upstream       options.Upstream
+		header         http.Header
+		expectedHeader http.Header
+	}
+
+	DescribeTable("newResponseModifier with rewrite rules",
+		func(in responseRewriteTableInput) {
+			rules, err := newRewriteRules(in.upstream.RewriteRules)
+			Expect(err).ToNot(HaveOccurred())
+
+			resp := &http.Response{Header: in.header}
+			Expect(newResponseModifier(in.upstream, rules)(resp)).To(Succeed())
+			Expect(resp.Header).To(Equal(in.expectedHeader))
+		},
+		Entry("with a Location rule", responseRewriteTableInput{
+			upstream: options.Upstream{
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Location", Match: `^http://upstream:8080(/.*)?$`, Replacement: "https://example.com$1"},
+				},
+			},
+			header:         http.Header{"Location": {"http://upstream:8080/login?next=/"}},
+			expectedHeader: http.Header{"Location": {"https://example.com/login?next=/"}},
+		}),
+		Entry("with a Location rule that doesn't match", responseRewriteTableInput{
+			upstream: options.Upstream{
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Location", Match: `^http://upstream:8080(/.*)?$`, Replacement: "https://example.com$1"},
+				},
+			},
+			header:         http.Header{"Location": {"https://idp.example.com/authorize"}},
+			expectedHeader: http.Header{"Location": {"https://idp.example.com/authorize"}},
+		}),
+		Entry("with a rule for a missing header", responseRewriteTableInput{
+			upstream: options.Upstream{
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Location", Match: "^http://upstream:8080", Replacement: "https://example.com"},
+				},
+			},
+			header:         http.Header{"Content-Type": {"text/plain"}},
+			expectedHeader: http.Header{"Content-Type": {"text/plain"}},
+		}),
+		Entry("with rules applied in order", responseRewriteTableInput{
+			upstream: options.Upstream{
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Link", Match: "^<http://", Replacement: "<https://"},
+					{Target: options.RewriteResponseHeader, Header: "Link", Match: "^<https://upstream:8080", Replacement: "<https://example.com"},
+					{Target: options.RewriteRequestHeader, Header: "Link", Match: "example.com", Replacement: "other.com"},
+				},
+			},
+			header: http.Header{"Link": {
+				"<http://upstream:8080/style.css>; rel=preload",
+				"<https://cdn.example.com/app.js>; rel=preload",
+			}},
+			expectedHeader: http.Header{"Link": {
+				"<https://example.com/style.css>; rel=preload",
+				"<https://cdn.example.com/app.js>; rel=preload",
+			}},
+		}),
+		Entry("with Set-Cookie handling before the rules", responseRewriteTableInput{
+			upstream: options.Upstream{
+				SetCookieHandling: options.SetCookieStrip,
+				RewriteRules: []options.RewriteRule{
+					{Target: options.RewriteResponseHeader, Header: "Set-Cookie", Match: "^", Replacement: "added=1"},
+				},
+			},
+			header:         http.Header{"Set-Cookie": {"foo=bar"}},
+			expectedHeader: http.Header{},
+		}),
+	)
+})
@@ -0,0 +1,22 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// newRequestTimeoutHandler wraps the handler so that the request to the
+// upstream is cancelled once the timeout has passed.
+// The ReverseProxy reports the cancellation to its error handler, which
+// renders a gateway timeout error if the response has not started yet.
+// Cancelling the context releases the upstream connection, and any
+// goroutines waiting on it, when the request ends.
+func newRequestTimeoutHandler(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
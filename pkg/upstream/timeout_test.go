@@ -0,0 +1,117 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request Timeout Suite", func() {
+	var slowServer *httptest.Server
+	var cancelled chan struct{}
+
+	BeforeEach(func() {
+		cancelled = make(chan struct{}, 1)
+
+		// The slow server waits for the response delay given in the request,
+		// and records whether the request was cancelled first
+		slowServer = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			delay, err := time.ParseDuration(req.URL.Query().Get("delay"))
+			Expect(err).ToNot(HaveOccurred())
+
+			select {
+			case <-time.After(delay):
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("done"))
+			case <-req.Context().Done():
+				cancelled <- struct{}{}
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		slowServer.Close()
+	})
+
+	type timeoutTableInput struct {
+		timeout          *options.Duration
+		delay            string
+		expectedCode     int
+		expectCancelled  bool
+		expectedProxyErr error
+	}
+
+	DescribeTable("proxies requests with the timeout",
+		func(in timeoutTableInput) {
+			var proxyErr error
+			errorHandler := func(rw http.ResponseWriter, _ *http.Request, err error) {
+				proxyErr = err
+				rw.WriteHeader(http.StatusGatewayTimeout)
+			}
+
+			u, err := url.Parse(slowServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			handler := newHTTPUpstreamProxy(options.Upstream{
+				ID:      "slow",
+				Path:    "/",
+				URI:     slowServer.URL,
+				Timeout: in.timeout,
+			}, u, nil, errorHandler)
+
+			req := httptest.NewRequest("", "/?delay="+in.delay, nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{})
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			if in.expectedProxyErr != nil {
+				Expect(proxyErr).To(MatchError(in.expectedProxyErr))
+			} else {
+				Expect(proxyErr).ToNot(HaveOccurred())
+			}
+			if in.expectCancelled {
+				Eventually(cancelled).Should(Receive())
+			} else {
+				Consistently(cancelled, 50*time.Millisecond).ShouldNot(Receive())
+			}
+		},
+		Entry("with no timeout", timeoutTableInput{
+			timeout:         nil,
+			delay:           "100ms",
+			expectedCode:    http.StatusOK,
+			expectCancelled: false,
+		}),
+		Entry("with a timeout of 0", timeoutTableInput{
+			timeout:         durationPtr(0),
+			delay:           "100ms",
+			expectedCode:    http.StatusOK,
+			expectCancelled: false,
+		}),
+		Entry("with a response within the timeout", timeoutTableInput{
+			timeout:         durationPtr(time.Second),
+			delay:           "10ms",
+			expectedCode:    http.StatusOK,
+			expectCancelled: false,
+		}),
+		Entry("with a response after the timeout", timeoutTableInput{
+			timeout:          durationPtr(50 * time.Millisecond),
+			delay:            "10s",
+			expectedCode:     http.StatusGatewayTimeout,
+			expectCancelled:  true,
+			expectedProxyErr: context.DeadlineExceeded,
+		}),
+	)
+})
+
+func durationPtr(d time.Duration) *options.Duration {
+	duration := options.Duration(d)
+	return &duration
+}
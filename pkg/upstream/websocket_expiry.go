@@ -0,0 +1,298 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	// closeOpcode is the first byte of an unfragmented close frame
+	closeOpcode = 0x88
+
+	// sessionExpiredReason is the reason sent in the close frame
+	sessionExpiredReason = "session expired"
+
+	// maxHandshakeSize limits how much of the upstream's handshake response
+	// is buffered while looking for the end of the headers
+	maxHandshakeSize = 64 * 1024
+)
+
+// errSessionExpired is returned by writes to a connection that was closed
+// because the session expired
+var errSessionExpired = errors.New("websocket closed: session expired")
+
+// newSessionExpiryWebSocketHandler wraps the WebSocket handler so that the
+// connections are closed with a close frame when the session of the upgrade
+// request expires.
+func newSessionExpiryWebSocketHandler(handler http.Handler, code int) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middleware.GetRequestScope(req)
+		if scope == nil || scope.Session == nil || scope.Session.ExpiresOn == nil {
+			// There is no expiry to enforce
+			handler.ServeHTTP(rw, req)
+			return
+		}
+
+		handler.ServeHTTP(&sessionExpiryResponseWriter{
+			ResponseWriter: rw,
+			expires:        *scope.Session.ExpiresOn,
+			code:           code,
+		}, req)
+	})
+}
+
+// sessionExpiryResponseWriter wraps the connection when it is hijacked by the
+// WebSocket proxy
+type sessionExpiryResponseWriter struct {
+	http.ResponseWriter
+	expires time.Time
+	code    int
+}
+
+// Hijack implements the http.Hijacker interface
+func (w *sessionExpiryResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newSessionExpiryConn(conn, w.expires, w.code), rw, nil
+}
+
+// sessionExpiryConn is the client side of a proxied WebSocket connection.
+// When the session expires, it waits for the frame being written to the
+// client to complete, then sends a close frame and closes the connection.
+type sessionExpiryConn struct {
+	net.Conn
+
+	closeFrame []byte
+	timer      *time.Timer
+
+	mutex   sync.Mutex
+	frames  frameTracker
+	expired bool
+	closed  bool
+}
+
+func newSessionExpiryConn(conn net.Conn, expires time.Time, code int) *sessionExpiryConn {
+	c := &sessionExpiryConn{
+		Conn:       conn,
+		closeFrame: newCloseFrame(code, sessionExpiredReason),
+	}
+	c.timer = time.AfterFunc(time.Until(expires), c.expire)
+	return c
+}
+
+// Write writes data from the upstream to the client.
+// Once the session has expired, data is only written up to the end of the
+// current frame.
+func (c *sessionExpiryConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, errSessionExpired
+	}
+
+	n := len(b)
+	if c.expired {
+		// Find the end of the current frame without updating the tracker, as
+		// the write may not complete
+		frames := c.frames
+		n = frames.advance(b, true)
+	}
+
+	written, err := c.Conn.Write(b[:n])
+	c.frames.advance(b[:written], false)
+	if err != nil {
+		return written, err
+	}
+
+	if c.expired && c.frames.atBoundary() {
+		c.closeLocked()
+		return written, errSessionExpired
+	}
+	return written, nil
+}
+
+// Close stops the expiry timer and closes the connection
+func (c *sessionExpiryConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// expire closes the connection if no frame is being written, otherwise the
+// connection is closed once the frame has been written
+func (c *sessionExpiryConn) expire() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expired = true
+	if c.frames.atBoundary() {
+		c.closeLocked()
+	}
+}
+
+func (c *sessionExpiryConn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	if _, err := c.Conn.Write(c.closeFrame); err != nil {
+		logger.Errorf("Error sending websocket close frame: %v", err)
+	}
+	if err := c.Conn.Close(); err != nil {
+		logger.Errorf("Error closing websocket connection: %v", err)
+	}
+}
+
+// newCloseFrame creates an unmasked close frame, as sent by a server
+func newCloseFrame(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	// Control frame payloads are at most 125 bytes, so the length always
+	// fits in the first length byte
+	return append([]byte{closeOpcode, byte(len(payload))}, payload...)
+}
+
+// frameTracker follows the data sent from the upstream to the client to find
+// the boundaries between WebSocket frames.
+// The data starts with the upstream's handshake response. If the upstream
+// does not switch protocols, the data is not WebSocket frames and there are
+// no boundaries.
+type frameTracker struct {
+	handshakeComplete bool
+	notWebSocket      bool
+
+	// buffer holds a partial handshake response or frame header
+	buffer []byte
+
+	// payloadRemaining is the length of the current frame's payload that has
+	// not been written yet
+	payloadRemaining uint64
+}
+
+// atBoundary returns whether the data written so far ends between frames
+func (t *frameTracker) atBoundary() bool {
+	return t.handshakeComplete && !t.notWebSocket && len(t.buffer) == 0 && t.payloadRemaining == 0
+}
+
+// advance tracks the data in b and returns the number of bytes consumed.
+// If stopAtBoundary is set, it stops at the first boundary between frames.
+func (t *frameTracker) advance(b []byte, stopAtBoundary bool) int {
+	consumed := 0
+	for consumed < len(b) {
+		if t.notWebSocket {
+			return len(b)
+		}
+		if stopAtBoundary && t.atBoundary() {
+			return consumed
+		}
+
+		switch {
+		case !t.handshakeComplete:
+			consumed += t.advanceHandshake(b[consumed:])
+		case t.payloadRemaining > 0:
+			n := uint64(len(b) - consumed)
+			if n > t.payloadRemaining {
+				n = t.payloadRemaining
+			}
+			t.payloadRemaining -= n
+			consumed += int(n)
+		default:
+			consumed += t.advanceHeader(b[consumed:])
+		}
+	}
+	return consumed
+}
+
+// advanceHandshake buffers the handshake response until the end of its
+// headers, and returns the number of bytes of b that were part of it
+func (t *frameTracker) advanceHandshake(b []byte) int {
+	start := len(t.buffer)
+	t.buffer = append(t.buffer, b...)
+
+	end := bytes.Index(t.buffer, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(t.buffer) > maxHandshakeSize {
+			t.notWebSocket = true
+			t.buffer = nil
+		}
+		return len(b)
+	}
+
+	statusLine := t.buffer[:bytes.IndexByte(t.buffer, '\n')]
+	fields := bytes.Fields(statusLine)
+	if len(fields) < 2 || string(fields[1]) != "101" {
+		t.notWebSocket = true
+	}
+
+	t.handshakeComplete = true
+	t.buffer = nil
+	return end + 4 - start
+}
+
+// advanceHeader buffers a frame header until it is complete, and returns the
+// number of bytes of b that were part of it
+func (t *frameTracker) advanceHeader(b []byte) int {
+	consumed := 0
+	for consumed < len(b) {
+		t.buffer = append(t.buffer, b[consumed])
+		consumed++
+
+		if length, ok := t.headerLength(); ok && len(t.buffer) == length {
+			t.payloadRemaining = t.payloadLength()
+			t.buffer = nil
+			return consumed
+		}
+	}
+	return consumed
+}
+
+// headerLength returns the length of the buffered frame header, once enough
+// of it has been buffered to know
+func (t *frameTracker) headerLength() (int, bool) {
+	if len(t.buffer) < 2 {
+		return 0, false
+	}
+
+	length := 2
+	switch t.buffer[1] & 0x7f {
+	case 126:
+		length += 2
+	case 127:
+		length += 8
+	}
+	if t.buffer[1]&0x80 != 0 {
+		// Masking key
+		length += 4
+	}
+	return length, true
+}
+
+// payloadLength returns the payload length from a complete frame header
+func (t *frameTracker) payloadLength() uint64 {
+	switch length := t.buffer[1] & 0x7f; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(t.buffer[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(t.buffer[2:10])
+	default:
+		return uint64(length)
+	}
+}
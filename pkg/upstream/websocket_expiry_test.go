@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebSocket Session Expiry Suite", func() {
+	const handshake = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+
+	// textFrame builds an unmasked text frame with a payload of the given length
+	textFrame := func(length int) []byte {
+		var header []byte
+		switch {
+		case length < 126:
+			header = []byte{0x81, byte(length)}
+		case length <= 0xffff:
+			header = []byte{0x81, 126, byte(length >> 8), byte(length)}
+		default:
+			header = []byte{0x81, 127, 0, 0, 0, 0, byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)}
+		}
+		return append(header, bytes.Repeat([]byte("a"), length)...)
+	}
+
+	Context("newCloseFrame", func() {
+		It("builds an unmasked close frame with the code and reason", func() {
+			Expect(newCloseFrame(1008, "bye")).To(Equal([]byte{0x88, 0x05, 0x03, 0xf0, 'b', 'y', 'e'}))
+		})
+	})
+
+	Context("frameTracker", func() {
+		type frameTrackerTableInput struct {
+			writes     [][]byte
+			atBoundary bool
+		}
+
+		DescribeTable("tracks the boundaries between frames",
+			func(in frameTrackerTableInput) {
+				tracker := &frameTracker{}
+				for _, b := range in.writes {
+					Expect(tracker.advance(b, false)).To(Equal(len(b)))
+				}
+				Expect(tracker.atBoundary()).To(Equal(in.atBoundary))
+			},
+			Entry("before the handshake", frameTrackerTableInput{
+				writes:     [][]byte{},
+				atBoundary: false,
+			}),
+			Entry("with a partial handshake", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake[:20])},
+				atBoundary: false,
+			}),
+			Entry("with a complete handshake", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake)},
+				atBoundary: true,
+			}),
+			Entry("with a handshake split across writes", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake[:len(handshake)-2]), []byte(handshake[len(handshake)-2:])},
+				atBoundary: true,
+			}),
+			Entry("with a response that does not switch protocols", frameTrackerTableInput{
+				writes:     [][]byte{[]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")},
+				atBoundary: false,
+			}),
+			Entry("with a complete frame", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake), textFrame(10)},
+				atBoundary: true,
+			}),
+			Entry("with a partial frame header", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake), textFrame(200)[:3]},
+				atBoundary: false,
+			}),
+			Entry("with a partial frame payload", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake), textFrame(200)[:100]},
+				atBoundary: false,
+			}),
+			Entry("with a 64 bit payload length", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake), textFrame(70000)},
+				atBoundary: true,
+			}),
+			Entry("with a masked frame", frameTrackerTableInput{
+				writes:     [][]byte{[]byte(handshake), {0x81, 0x82, 1, 2, 3, 4, 'h', 'i'}},
+				atBoundary: true,
+			}),
+			Entry("with the handshake and frames in one write", frameTrackerTableInput{
+				writes:     [][]byte{append(append([]byte(handshake), textFrame(10)...), textFrame(300)...)},
+				atBoundary: true,
+			}),
+		)
+
+		It("stops at the end of the current frame", func() {
+			tracker := &frameTracker{}
+			tracker.advance([]byte(handshake), false)
+
+			first := textFrame(200)
+			b := append(append([]byte{}, first[50:]...), textFrame(10)...)
+			tracker.advance(first[:50], false)
+
+			Expect(tracker.advance(b, true)).To(Equal(len(first) - 50))
+			Expect(tracker.atBoundary()).To(BeTrue())
+		})
+	})
+
+	Context("sessionExpiryConn", func() {
+		var server, client net.Conn
+		closeFrame := newCloseFrame(4001, sessionExpiredReason)
+
+		BeforeEach(func() {
+			server, client = net.Pipe()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			client.Close()
+		})
+
+		// readAll reads from the client until the connection is closed
+		readAll := func() <-chan []byte {
+			data := make(chan []byte, 1)
+			go func() {
+				defer GinkgoRecover()
+				b, _ := io.ReadAll(client)
+				data <- b
+			}()
+			return data
+		}
+
+		It("closes an idle connection when the session expires", func() {
+			data := readAll()
+			conn := newSessionExpiryConn(server, time.Now().Add(50*time.Millisecond), 4001)
+
+			_, err := conn.Write([]byte(handshake))
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(data).Should(Receive(Equal(append([]byte(handshake), closeFrame...))))
+
+			_, err = conn.Write(textFrame(10))
+			Expect(err).To(Equal(errSessionExpired))
+		})
+
+		It("finishes writing the current frame before closing", func() {
+			data := readAll()
+			conn := newSessionExpiryConn(server, time.Now().Add(time.Hour), 4001)
+
+			frame := textFrame(200)
+			_, err := conn.Write(append([]byte(handshake), frame[:100]...))
+			Expect(err).ToNot(HaveOccurred())
+
+			conn.expire()
+
+			// The rest of the frame is written, but the next frame is not
+			n, err := conn.Write(append(append([]byte{}, frame[100:]...), textFrame(10)...))
+			Expect(err).To(Equal(errSessionExpired))
+			Expect(n).To(Equal(len(frame) - 100))
+
+			expected := append(append([]byte(handshake), frame...), closeFrame...)
+			Eventually(data).Should(Receive(Equal(expected)))
+		})
+
+		It("does not close the connection before the session expires", func() {
+			conn := newSessionExpiryConn(server, time.Now().Add(time.Hour), 4001)
+			go func() {
+				defer GinkgoRecover()
+				_, err := conn.Write(append([]byte(handshake), textFrame(10)...))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.Close()).To(Succeed())
+			}()
+
+			b, err := io.ReadAll(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal(append([]byte(handshake), textFrame(10)...)))
+		})
+	})
+})
@@ -0,0 +1,191 @@
+package upstream
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// weightedUpstream is a member of a weightedUpstreamGroup
+type weightedUpstream struct {
+	id      string
+	weight  int
+	handler http.Handler
+
+	// health checks the upstream, when it has health checks
+	health *healthChecker
+
+	// current is the state of the upstream in the smooth weighted round robin
+	current int
+}
+
+// weightedUpstreamGroup distributes requests across the upstreams that share
+// a path in proportion to their weights.
+// Upstreams that are unhealthy are left out until they recover.
+type weightedUpstreamGroup struct {
+	path      string
+	upstreams []*weightedUpstream
+	total     int
+	sticky    bool
+	writer    pagewriter.Writer
+
+	mutex sync.Mutex
+}
+
+// newWeightedUpstreamGroup creates a weightedUpstreamGroup from the upstreams
+// and their handlers.
+// The upstreams are ordered by ID so that every instance assigns sticky
+// sessions to the same upstreams.
+func newWeightedUpstreamGroup(upstreams options.Upstreams, handlers []http.Handler, writer pagewriter.Writer) http.Handler {
+	group := &weightedUpstreamGroup{
+		path:   upstreams[0].Path,
+		sticky: upstreams[0].Sticky,
+		writer: writer,
+	}
+	for i, upstream := range upstreams {
+		weight := 0
+		if upstream.Weight != nil {
+			weight = *upstream.Weight
+		}
+		if weight <= 0 {
+			// The upstream is drained
+			continue
+		}
+		wu := &weightedUpstream{
+			id:      upstream.ID,
+			weight:  weight,
+			handler: handlers[i],
+		}
+		if checked, ok := handlers[i].(*healthCheckedUpstream); ok {
+			wu.health = checked.checker
+		}
+		group.upstreams = append(group.upstreams, wu)
+		group.total += weight
+	}
+	sort.Slice(group.upstreams, func(i, j int) bool {
+		return group.upstreams[i].id < group.upstreams[j].id
+	})
+
+	for _, upstream := range group.upstreams {
+		logger.Printf("weighting path %q => upstream %q: %d/%d", upstreams[0].Path, upstream.id, upstream.weight, group.total)
+	}
+	return group
+}
+
+// ServeHTTP proxies the request to the next upstream in the group, or
+// renders an error page when no upstream can be proxied to
+func (g *weightedUpstreamGroup) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if len(g.upstreams) == 0 {
+		logger.Errorf("not proxying to path %q: every upstream is drained", g.path)
+		g.writeErrorPage(rw, req, http.StatusBadGateway, fmt.Sprintf("Every upstream for path %q is drained", g.path))
+		return
+	}
+	upstream := g.next(req)
+	if upstream == nil {
+		logger.Errorf("not proxying to path %q: every upstream is unhealthy", g.path)
+		g.writeErrorPage(rw, req, http.StatusServiceUnavailable, fmt.Sprintf("Every upstream for path %q is unhealthy", g.path))
+		return
+	}
+	upstream.handler.ServeHTTP(rw, req)
+}
+
+// writeErrorPage renders the error page with the ID of the request
+func (g *weightedUpstreamGroup) writeErrorPage(rw http.ResponseWriter, req *http.Request, status int, appError string) {
+	opts := pagewriter.ErrorPageOpts{
+		Status:   status,
+		AppError: appError,
+	}
+	if scope := middleware.GetRequestScope(req); scope != nil {
+		opts.RequestID = scope.RequestID
+	}
+	g.writer.WriteErrorPage(rw, opts)
+}
+
+// next selects the upstream for the request, or nil when every upstream is
+// unhealthy.
+// Sticky groups select upstreams based on the session, when there is one.
+func (g *weightedUpstreamGroup) next(req *http.Request) *weightedUpstream {
+	if g.sticky {
+		if key := stickyKey(req); key != "" {
+			return g.stickyUpstream(key)
+		}
+	}
+	return g.roundRobin()
+}
+
+// roundRobin uses a smooth weighted round robin, which interleaves the
+// requests to each upstream rather than sending them in bursts.
+func (g *weightedUpstreamGroup) roundRobin() *weightedUpstream {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var selected *weightedUpstream
+	total := 0
+	for _, upstream := range g.upstreams {
+		if !upstream.healthy() {
+			continue
+		}
+		upstream.current += upstream.weight
+		total += upstream.weight
+		if selected == nil || upstream.current > selected.current {
+			selected = upstream
+		}
+	}
+	if selected != nil {
+		selected.current -= total
+	}
+	return selected
+}
+
+// stickyUpstream hashes the key into the range of the total weight of the
+// healthy upstreams, so that the same key is always proxied to the same
+// upstream while the weights and health of the upstreams are unchanged.
+func (g *weightedUpstreamGroup) stickyUpstream(key string) *weightedUpstream {
+	healthy := make([]*weightedUpstream, 0, len(g.upstreams))
+	total := 0
+	for _, upstream := range g.upstreams {
+		if upstream.healthy() {
+			healthy = append(healthy, upstream)
+			total += upstream.weight
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	bucket := int(h.Sum32() % uint32(total))
+
+	for _, upstream := range healthy {
+		if bucket < upstream.weight {
+			return upstream
+		}
+		bucket -= upstream.weight
+	}
+	return healthy[len(healthy)-1]
+}
+
+// healthy reports whether requests may be proxied to the upstream
+func (u *weightedUpstream) healthy() bool {
+	return u.health == nil || u.health.healthy()
+}
+
+// stickyKey identifies the user of the request from their session
+func stickyKey(req *http.Request) string {
+	scope := middleware.GetRequestScope(req)
+	if scope == nil || scope.Session == nil {
+		return ""
+	}
+	if scope.Session.Email != "" {
+		return scope.Session.Email
+	}
+	return scope.Session.User
+}
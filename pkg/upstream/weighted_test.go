@@ -0,0 +1,133 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/pagewriter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Weighted Upstream Suite", func() {
+	// newGroup creates a weighted group of static upstreams with the given
+	// IDs and weights which record the upstream in the request scope
+	newGroup := func(sticky bool, weights map[string]int) http.Handler {
+		upstreams := options.Upstreams{}
+		handlers := []http.Handler{}
+		for id, weight := range weights {
+			weight := weight
+			upstreams = append(upstreams, options.Upstream{
+				ID:     id,
+				Path:   "/",
+				Static: true,
+				Weight: &weight,
+				Sticky: sticky,
+			})
+			handlers = append(handlers, newStaticResponseHandler(id, nil))
+		}
+		return newWeightedUpstreamGroup(upstreams, handlers, &pagewriter.WriterFuncs{})
+	}
+
+	// serve makes a request to the handler and returns the upstream that
+	// handled it
+	serve := func(handler http.Handler, session *sessionsapi.SessionState) string {
+		req := httptest.NewRequest("", "/", nil)
+		scope := &middlewareapi.RequestScope{Session: session}
+		req = middlewareapi.AddRequestScope(req, scope)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return scope.Upstream
+	}
+
+	It("distributes requests in proportion to the weights", func() {
+		group := newGroup(false, map[string]int{"stable": 3, "canary": 1})
+
+		// Requests to each upstream are interleaved rather than sent in bursts,
+		// so every cycle of the total weight has the exact proportions
+		for cycle := 0; cycle < 3; cycle++ {
+			served := []string{}
+			for i := 0; i < 4; i++ {
+				served = append(served, serve(group, nil))
+			}
+			Expect(served).To(ConsistOf("stable", "stable", "stable", "canary"))
+		}
+	})
+
+	It("does not send requests to upstreams with no weight", func() {
+		group := newGroup(false, map[string]int{"stable": 1, "canary": 0})
+
+		for i := 0; i < 4; i++ {
+			Expect(serve(group, nil)).To(Equal("stable"))
+		}
+	})
+
+	It("responds with a bad gateway when every upstream has no weight", func() {
+		group := newGroup(false, map[string]int{"stable": 0})
+
+		rw := httptest.NewRecorder()
+		req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), &middlewareapi.RequestScope{})
+		group.ServeHTTP(rw, req)
+		Expect(rw.Code).To(Equal(http.StatusBadGateway))
+	})
+
+	Context("with sticky upstreams", func() {
+		It("consistently proxies each user to the same upstream", func() {
+			group := newGroup(true, map[string]int{"stable": 1, "canary": 1})
+			other := newGroup(true, map[string]int{"canary": 1, "stable": 1})
+
+			served := map[string]int{}
+			for i := 0; i < 100; i++ {
+				session := &sessionsapi.SessionState{Email: fmt.Sprintf("user%d@example.com", i)}
+				upstream := serve(group, session)
+				for j := 0; j < 3; j++ {
+					Expect(serve(group, session)).To(Equal(upstream))
+				}
+				// The upstream should not depend on the order of the configuration
+				Expect(serve(other, session)).To(Equal(upstream))
+				served[upstream]++
+			}
+			Expect(served["stable"]).To(BeNumerically(">", 25))
+			Expect(served["canary"]).To(BeNumerically(">", 25))
+		})
+
+		It("uses the round robin for requests without a session", func() {
+			group := newGroup(true, map[string]int{"stable": 1, "canary": 1})
+
+			Expect([]string{serve(group, nil), serve(group, nil)}).To(ConsistOf("stable", "canary"))
+		})
+	})
+
+	It("is built by NewProxy for upstreams sharing a path", func() {
+		stableWeight := 1
+		canaryWeight := 1
+		proxy, err := NewProxy(options.Upstreams{
+			{
+				ID:     "stable",
+				Path:   "/app/",
+				Static: true,
+				Weight: &stableWeight,
+			},
+			{
+				ID:     "canary",
+				Path:   "/app/",
+				Static: true,
+				Weight: &canaryWeight,
+			},
+		}, nil, &pagewriter.WriterFuncs{}, options.TrailingSlashStrict)
+		Expect(err).ToNot(HaveOccurred())
+
+		served := []string{}
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("", "/app/page", nil)
+			scope := &middlewareapi.RequestScope{}
+			req = middlewareapi.AddRequestScope(req, scope)
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+			served = append(served, scope.Upstream)
+		}
+		Expect(served).To(ConsistOf("stable", "canary"))
+	})
+})
@@ -16,6 +16,8 @@ func validateAllowlists(o *options.Options) []string {
 	msgs = append(msgs, validateRoutes(o)...)
 	msgs = append(msgs, validateRegexes(o)...)
 	msgs = append(msgs, validateTrustedIPs(o)...)
+	msgs = append(msgs, validateHeadRequestHandling(o)...)
+	msgs = append(msgs, validateTrailingSlashPolicy(o)...)
 
 	if len(o.TrustedIPs) > 0 && o.ReverseProxy {
 		_, err := fmt.Fprintln(os.Stderr, "WARNING: mixing --trusted-ip with --reverse-proxy is a potential security vulnerability. An attacker can inject a trusted IP into an X-Real-IP or X-Forwarded-For header if they aren't properly protected outside of oauth2-proxy")
@@ -27,6 +29,28 @@ func validateAllowlists(o *options.Options) []string {
 	return msgs
 }
 
+// validateHeadRequestHandling validates options.HeadRequestHandling
+func validateHeadRequestHandling(o *options.Options) []string {
+	switch o.HeadRequestHandling {
+	case "", options.HeadRequestLogin, options.HeadRequestStatus, options.HeadRequestOK:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("invalid head-request-handling %q: must be one of %q, %q or %q",
+			o.HeadRequestHandling, options.HeadRequestLogin, options.HeadRequestStatus, options.HeadRequestOK)}
+	}
+}
+
+// validateTrailingSlashPolicy validates options.TrailingSlashPolicy
+func validateTrailingSlashPolicy(o *options.Options) []string {
+	switch o.TrailingSlashPolicy {
+	case "", options.TrailingSlashStrict, options.TrailingSlashNormalize:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("invalid trailing-slash-policy %q: must be one of %q or %q",
+			o.TrailingSlashPolicy, options.TrailingSlashStrict, options.TrailingSlashNormalize)}
+	}
+}
+
 // validateRoutes validates method=path routes passed with options.SkipAuthRoutes
 func validateRoutes(o *options.Options) []string {
 	msgs := []string{}
@@ -46,10 +70,14 @@ func validateRoutes(o *options.Options) []string {
 	return msgs
 }
 
-// validateRegex validates regex paths passed with options.SkipAuthRegex
+// validateRegex validates regex paths passed with options.SkipAuthRegex and
+// options.SkipHeaderInjectRegex
 func validateRegexes(o *options.Options) []string {
 	msgs := []string{}
-	for _, regex := range o.SkipAuthRegex {
+	regexes := make([]string, 0, len(o.SkipAuthRegex)+len(o.SkipHeaderInjectRegex))
+	regexes = append(regexes, o.SkipAuthRegex...)
+	regexes = append(regexes, o.SkipHeaderInjectRegex...)
+	for _, regex := range regexes {
 		_, err := regexp.Compile(regex)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", regex, err))
@@ -88,6 +88,15 @@ var _ = Describe("Allowlist", func() {
 		}),
 	)
 
+	It("validateRegexes validates the header injection skip regexes", func() {
+		opts := &options.Options{
+			SkipHeaderInjectRegex: []string{"^/logs/", "/(foo"},
+		}
+		Expect(validateRegexes(opts)).To(ConsistOf(
+			"error compiling regex //(foo/: error parsing regexp: missing closing ): `/(foo`",
+		))
+	})
+
 	DescribeTable("validateTrustedIPs",
 		func(t *validateTrustedIPsTableInput) {
 			opts := &options.Options{
@@ -122,4 +131,35 @@ var _ = Describe("Allowlist", func() {
 			},
 		}),
 	)
+
+	DescribeTable("validateHeadRequestHandling",
+		func(headRequestHandling string, errStrings []string) {
+			opts := &options.Options{
+				HeadRequestHandling: headRequestHandling,
+			}
+			Expect(validateHeadRequestHandling(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("Unset", "", []string{}),
+		Entry("Login", options.HeadRequestLogin, []string{}),
+		Entry("Status", options.HeadRequestStatus, []string{}),
+		Entry("OK", options.HeadRequestOK, []string{}),
+		Entry("Invalid", "redirect", []string{
+			"invalid head-request-handling \"redirect\": must be one of \"login\", \"status\" or \"ok\"",
+		}),
+	)
+
+	DescribeTable("validateTrailingSlashPolicy",
+		func(trailingSlashPolicy string, errStrings []string) {
+			opts := &options.Options{
+				TrailingSlashPolicy: trailingSlashPolicy,
+			}
+			Expect(validateTrailingSlashPolicy(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("Unset", "", []string{}),
+		Entry("Strict", options.TrailingSlashStrict, []string{}),
+		Entry("Normalize", options.TrailingSlashNormalize, []string{}),
+		Entry("Invalid", "redirect", []string{
+			"invalid trailing-slash-policy \"redirect\": must be one of \"strict\" or \"normalize\"",
+		}),
+	)
 })
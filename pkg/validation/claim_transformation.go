@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// transformableClaims are the claims of the session that can be transformed
+var transformableClaims = []string{"user", "email", "groups", "preferred_username"}
+
+func validateClaimTransformations(claimTransformations []options.ClaimTransformation) []string {
+	msgs := []string{}
+	claims := make(map[string]struct{})
+
+	for _, ct := range claimTransformations {
+		if !isTransformableClaim(ct.Claim) {
+			msgs = append(msgs, fmt.Sprintf("claim %q cannot be transformed: must be one of %q", ct.Claim, transformableClaims))
+		}
+		if _, ok := claims[ct.Claim]; ok {
+			msgs = append(msgs, fmt.Sprintf("multiple transformations found for claim %q: claims must be unique", ct.Claim))
+		}
+		claims[ct.Claim] = struct{}{}
+
+		for i, t := range ct.Transformations {
+			msgs = append(msgs,
+				prefixValues(fmt.Sprintf("claim %q: transformation %d: ", ct.Claim, i),
+					validateTransformation(t)...,
+				)...,
+			)
+		}
+	}
+	return msgs
+}
+
+func isTransformableClaim(claim string) bool {
+	for _, c := range transformableClaims {
+		if claim == c {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTransformation(t options.Transformation) []string {
+	msgs := []string{}
+
+	switch t.Type {
+	case options.ClaimTransformLowercase:
+	case options.ClaimTransformRegex:
+		msgs = append(msgs, validateRegexTransformation(t)...)
+	case options.ClaimTransformSplit, options.ClaimTransformJoin:
+		if t.Separator == "" {
+			msgs = append(msgs, fmt.Sprintf("a separator is required for type %q", t.Type))
+		}
+	case options.ClaimTransformMap:
+		if len(t.Values) == 0 {
+			msgs = append(msgs, fmt.Sprintf("values are required for type %q", t.Type))
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid type %q: must be one of %q", t.Type, []string{
+			options.ClaimTransformLowercase,
+			options.ClaimTransformRegex,
+			options.ClaimTransformSplit,
+			options.ClaimTransformJoin,
+			options.ClaimTransformMap,
+		}))
+	}
+
+	if t.Type != options.ClaimTransformRegex && t.Group != nil {
+		msgs = append(msgs, fmt.Sprintf("group is set, but type is %q, this will have no effect.", t.Type))
+	}
+	return msgs
+}
+
+func validateRegexTransformation(t options.Transformation) []string {
+	if t.Pattern == "" {
+		return []string{"a pattern is required for type \"regex\""}
+	}
+
+	pattern, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return []string{fmt.Sprintf("error compiling regex /%s/: %v", t.Pattern, err)}
+	}
+
+	group := 1
+	if t.Group != nil {
+		group = *t.Group
+	}
+	if group < 0 || group > pattern.NumSubexp() {
+		return []string{fmt.Sprintf("pattern /%s/ has no group %d", t.Pattern, group)}
+	}
+	return []string{}
+}
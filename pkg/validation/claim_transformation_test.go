@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claim Transformations", func() {
+	type validateClaimTransformationsTableInput struct {
+		claimTransformations []options.ClaimTransformation
+		errStrings           []string
+	}
+
+	group0 := 0
+	group2 := 2
+
+	DescribeTable("validateClaimTransformations",
+		func(in validateClaimTransformationsTableInput) {
+			Expect(validateClaimTransformations(in.claimTransformations)).To(ConsistOf(in.errStrings))
+		},
+		Entry("with no transformations", validateClaimTransformationsTableInput{
+			errStrings: []string{},
+		}),
+		Entry("with valid transformations", validateClaimTransformationsTableInput{
+			claimTransformations: []options.ClaimTransformation{
+				{Claim: "email", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformLowercase},
+				}},
+				{Claim: "groups", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformSplit, Separator: ","},
+					{Type: options.ClaimTransformRegex, Pattern: `^CN=([^,]+)`},
+					{Type: options.ClaimTransformRegex, Pattern: `[a-z]+`, Group: &group0},
+					{Type: options.ClaimTransformMap, Values: map[string]string{"admins": "admin"}},
+				}},
+				{Claim: "user", Transformations: []options.Transformation{
+					{Type: options.ClaimTransformSplit, Separator: "\\"},
+					{Type: options.ClaimTransformJoin, Separator: "/"},
+				}},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid and duplicate claims", validateClaimTransformationsTableInput{
+			claimTransformations: []options.ClaimTransformation{
+				{Claim: "access_token"},
+				{Claim: "email"},
+				{Claim: "email"},
+			},
+			errStrings: []string{
+				"claim \"access_token\" cannot be transformed: must be one of [\"user\" \"email\" \"groups\" \"preferred_username\"]",
+				"multiple transformations found for claim \"email\": claims must be unique",
+			},
+		}),
+		Entry("with invalid transformations", validateClaimTransformationsTableInput{
+			claimTransformations: []options.ClaimTransformation{
+				{Claim: "groups", Transformations: []options.Transformation{
+					{Type: "uppercase"},
+					{Type: options.ClaimTransformRegex},
+					{Type: options.ClaimTransformRegex, Pattern: "(CN"},
+					{Type: options.ClaimTransformRegex, Pattern: `^CN=([^,]+)`, Group: &group2},
+					{Type: options.ClaimTransformSplit},
+					{Type: options.ClaimTransformJoin},
+					{Type: options.ClaimTransformMap},
+					{Type: options.ClaimTransformLowercase, Group: &group0},
+				}},
+			},
+			errStrings: []string{
+				"claim \"groups\": transformation 0: invalid type \"uppercase\": must be one of [\"lowercase\" \"regex\" \"split\" \"join\" \"map\"]",
+				"claim \"groups\": transformation 1: a pattern is required for type \"regex\"",
+				"claim \"groups\": transformation 2: error compiling regex /(CN/: error parsing regexp: missing closing ): `(CN`",
+				"claim \"groups\": transformation 3: pattern /^CN=([^,]+)/ has no group 2",
+				"claim \"groups\": transformation 4: a separator is required for type \"split\"",
+				"claim \"groups\": transformation 5: a separator is required for type \"join\"",
+				"claim \"groups\": transformation 6: values are required for type \"map\"",
+				"claim \"groups\": transformation 7: group is set, but type is \"lowercase\", this will have no effect.",
+			},
+		}),
+	)
+})
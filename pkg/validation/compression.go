@@ -0,0 +1,30 @@
+package validation
+
+import (
+	"fmt"
+	"mime"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateCompression validates the options for compressing upstream responses
+func validateCompression(compression options.Compression) []string {
+	msgs := []string{}
+	if !compression.Enabled {
+		return msgs
+	}
+
+	if compression.MinSize < 0 {
+		msgs = append(msgs, fmt.Sprintf("compress_min_size must not be negative, got %d", compression.MinSize))
+	}
+	if len(compression.ContentTypes) == 0 {
+		msgs = append(msgs, "compress_content_types must not be empty when compress_responses is enabled")
+	}
+	for _, contentType := range compression.ContentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid compress_content_types entry %q: %v", contentType, err))
+		}
+	}
+
+	return msgs
+}
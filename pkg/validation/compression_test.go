@@ -0,0 +1,56 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	type validateCompressionTableInput struct {
+		compression options.Compression
+		errStrings  []string
+	}
+
+	DescribeTable("validateCompression",
+		func(o *validateCompressionTableInput) {
+			Expect(validateCompression(o.compression)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateCompressionTableInput{
+			compression: options.Compression{
+				MinSize: -1,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with valid options", &validateCompressionTableInput{
+			compression: options.Compression{
+				Enabled:      true,
+				MinSize:      1024,
+				ContentTypes: []string{"text/*", "application/json"},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a negative minimum size", &validateCompressionTableInput{
+			compression: options.Compression{
+				Enabled:      true,
+				MinSize:      -1,
+				ContentTypes: []string{"text/*"},
+			},
+			errStrings: []string{"compress_min_size must not be negative, got -1"},
+		}),
+		Entry("without any content types", &validateCompressionTableInput{
+			compression: options.Compression{
+				Enabled: true,
+			},
+			errStrings: []string{"compress_content_types must not be empty when compress_responses is enabled"},
+		}),
+		Entry("with an invalid content type", &validateCompressionTableInput{
+			compression: options.Compression{
+				Enabled:      true,
+				ContentTypes: []string{"text/"},
+			},
+			errStrings: []string{"invalid compress_content_types entry \"text/\": mime: expected token after slash"},
+		}),
+	)
+})
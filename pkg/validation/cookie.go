@@ -1,6 +1,7 @@
 package validation
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"sort"
@@ -10,7 +11,14 @@ import (
 )
 
 func validateCookie(o options.Cookie) []string {
-	msgs := validateCookieSecret(o.Secret)
+	msgs := []string{}
+	// Secrets derived from a passphrase are always a valid length
+	if o.SecretPassphrase == "" {
+		msgs = append(msgs, validateCookieSecret(o.Secret)...)
+	}
+	for i, secret := range o.PreviousSecrets {
+		msgs = append(msgs, prefixValues(fmt.Sprintf("cookie_previous_secrets[%d]: ", i), validatePreviousCookieSecret(secret)...)...)
+	}
 
 	if o.Refresh >= o.Expire {
 		msgs = append(msgs, fmt.Sprintf(
@@ -25,6 +33,12 @@ func validateCookie(o options.Cookie) []string {
 		msgs = append(msgs, fmt.Sprintf("cookie_samesite (%q) must be one of ['', 'lax', 'strict', 'none']", o.SameSite))
 	}
 
+	switch o.ValueEncoding {
+	case "", options.CookieValueEncodingBase64, options.CookieValueEncodingBase64URL:
+	default:
+		msgs = append(msgs, fmt.Sprintf("cookie_value_encoding (%q) must be one of ['base64', 'base64url']", o.ValueEncoding))
+	}
+
 	// Sort cookie domains by length, so that we try longer (and more specific) domains first
 	sort.Slice(o.Domains, func(i, j int) bool {
 		return len(o.Domains[i]) > len(o.Domains[j])
@@ -66,3 +80,38 @@ func validateCookieSecret(secret string) []string {
 		len(secretBytes)),
 	}
 }
+
+// validatePreviousCookieSecret checks that a previous cookie secret could
+// have been used as the cookie secret
+func validatePreviousCookieSecret(secret string) []string {
+	if secret == "" {
+		return []string{"must not be empty"}
+	}
+	return validateCookieSecret(secret)
+}
+
+// deriveCookieSecret derives the cookie secret from the passphrase, if one is
+// configured. The derived secret is base64 encoded so that it is decoded into
+// an AES-256 key.
+// Deriving the secret again gives the same result, so this is safe to call
+// more than once.
+func deriveCookieSecret(o *options.Cookie) []string {
+	if o.SecretPassphrase == "" {
+		return []string{}
+	}
+	if o.SecretKDFSalt == "" {
+		return []string{"cookie_secret_kdf_salt must be set to derive the cookie secret from the passphrase"}
+	}
+
+	secret, err := encryption.DeriveSecret(o.SecretPassphrase, o.SecretKDF, o.SecretKDFSalt, o.SecretKDFParams)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to derive the cookie secret from the passphrase: %v", err)}
+	}
+
+	derived := base64.RawURLEncoding.EncodeToString(secret)
+	if o.Secret != "" && o.Secret != derived {
+		return []string{"cookie_secret and cookie_secret_passphrase are mutually exclusive"}
+	}
+	o.Secret = derived
+	return []string{}
+}
@@ -36,6 +36,7 @@ func TestValidateCookie(t *testing.T) {
 	invalidBase64SecretMsg := "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 10 bytes"
 	refreshLongerThanExpireMsg := "cookie_refresh (\"1h0m0s\") must be less than cookie_expire (\"15m0s\")"
 	invalidSameSiteMsg := "cookie_samesite (\"invalid\") must be one of ['', 'lax', 'strict', 'none']"
+	invalidValueEncodingMsg := "cookie_value_encoding (\"base32\") must be one of ['base64', 'base64url']"
 
 	testCases := []struct {
 		name       string
@@ -236,6 +237,40 @@ func TestValidateCookie(t *testing.T) {
 				invalidSameSiteMsg,
 			},
 		},
+		{
+			name: "with value encoding \"base64url\"",
+			cookie: options.Cookie{
+				Name:          validName,
+				Secret:        validSecret,
+				Domains:       emptyDomains,
+				Path:          "",
+				Expire:        time.Hour,
+				Refresh:       15 * time.Minute,
+				Secure:        true,
+				HTTPOnly:      false,
+				SameSite:      "",
+				ValueEncoding: "base64url",
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with value encoding \"base32\"",
+			cookie: options.Cookie{
+				Name:          validName,
+				Secret:        validSecret,
+				Domains:       emptyDomains,
+				Path:          "",
+				Expire:        time.Hour,
+				Refresh:       15 * time.Minute,
+				Secure:        true,
+				HTTPOnly:      false,
+				SameSite:      "",
+				ValueEncoding: "base32",
+			},
+			errStrings: []string{
+				invalidValueEncodingMsg,
+			},
+		},
 		{
 			name: "with a combination of configuration errors",
 			cookie: options.Cookie{
@@ -256,6 +291,41 @@ func TestValidateCookie(t *testing.T) {
 				invalidSameSiteMsg,
 			},
 		},
+		{
+			name: "with valid previous secrets",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				PreviousSecrets: []string{validBase64Secret, "0123456789abcdef"},
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          true,
+				HTTPOnly:        false,
+				SameSite:        "",
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with invalid previous secrets",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				PreviousSecrets: []string{validSecret, "", invalidSecret},
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          true,
+				HTTPOnly:        false,
+				SameSite:        "",
+			},
+			errStrings: []string{
+				"cookie_previous_secrets[1]: must not be empty",
+				"cookie_previous_secrets[2]: " + invalidSecretMsg,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -271,3 +341,63 @@ func TestValidateCookie(t *testing.T) {
 		})
 	}
 }
+
+func TestDeriveCookieSecret(t *testing.T) {
+	passphraseCookie := func() options.Cookie {
+		return options.Cookie{
+			Name:             "_oauth2_proxy",
+			SecretPassphrase: "a passphrase that is not a valid AES key length",
+			SecretKDF:        "scrypt",
+			SecretKDFSalt:    "oauth2-proxy",
+			SecretKDFParams:  "n=1024",
+			Expire:           time.Hour,
+		}
+	}
+
+	t.Run("derives a valid secret", func(t *testing.T) {
+		g := NewWithT(t)
+		cookie := passphraseCookie()
+
+		g.Expect(deriveCookieSecret(&cookie)).To(BeEmpty())
+		g.Expect(validateCookie(cookie)).To(BeEmpty())
+		g.Expect(validateCookieSecret(cookie.Secret)).To(BeEmpty())
+
+		// Deriving again must give the same secret
+		secret := cookie.Secret
+		g.Expect(deriveCookieSecret(&cookie)).To(BeEmpty())
+		g.Expect(cookie.Secret).To(Equal(secret))
+	})
+
+	t.Run("does nothing without a passphrase", func(t *testing.T) {
+		g := NewWithT(t)
+		cookie := options.Cookie{Secret: "secretthirtytwobytes+abcdefghijk"}
+
+		g.Expect(deriveCookieSecret(&cookie)).To(BeEmpty())
+		g.Expect(cookie.Secret).To(Equal("secretthirtytwobytes+abcdefghijk"))
+	})
+
+	t.Run("with a cookie secret", func(t *testing.T) {
+		g := NewWithT(t)
+		cookie := passphraseCookie()
+		cookie.Secret = "secretthirtytwobytes+abcdefghijk"
+
+		g.Expect(deriveCookieSecret(&cookie)).To(ConsistOf("cookie_secret and cookie_secret_passphrase are mutually exclusive"))
+	})
+
+	t.Run("without a salt", func(t *testing.T) {
+		g := NewWithT(t)
+		cookie := passphraseCookie()
+		cookie.SecretKDFSalt = ""
+
+		g.Expect(deriveCookieSecret(&cookie)).To(ConsistOf("cookie_secret_kdf_salt must be set to derive the cookie secret from the passphrase"))
+	})
+
+	t.Run("with invalid parameters", func(t *testing.T) {
+		g := NewWithT(t)
+		cookie := passphraseCookie()
+		cookie.SecretKDF = "argon2id"
+
+		g.Expect(deriveCookieSecret(&cookie)).To(ConsistOf("unable to derive the cookie secret from the passphrase: unknown argon2id parameter \"n\""))
+		g.Expect(cookie.Secret).To(BeEmpty())
+	})
+}
@@ -0,0 +1,56 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+func validateFeatureFlags(featureFlags []options.FeatureFlag) []string {
+	msgs := []string{}
+	names := make(map[string]struct{})
+
+	for _, ff := range featureFlags {
+		if ff.Name == "" {
+			msgs = append(msgs, "feature flag has empty name: please provide a name for the feature flag")
+		}
+		if strings.Contains(ff.Name, ",") {
+			msgs = append(msgs, fmt.Sprintf("feature flag %q is invalid: names must not contain commas", ff.Name))
+		}
+		if _, ok := names[ff.Name]; ok {
+			msgs = append(msgs, fmt.Sprintf("multiple feature flags found with name %q: names must be unique", ff.Name))
+		}
+		names[ff.Name] = struct{}{}
+
+		if len(ff.Conditions) == 0 {
+			msgs = append(msgs, fmt.Sprintf("feature flag %q has no conditions: at least one condition is required", ff.Name))
+		}
+		for i, c := range ff.Conditions {
+			msgs = append(msgs,
+				prefixValues(fmt.Sprintf("feature flag %q: condition %d: ", ff.Name, i),
+					validateFeatureFlagCondition(c)...,
+				)...,
+			)
+		}
+	}
+	return msgs
+}
+
+func validateFeatureFlagCondition(c options.FeatureFlagCondition) []string {
+	msgs := []string{}
+
+	if !isTransformableClaim(c.Claim) {
+		msgs = append(msgs, fmt.Sprintf("invalid claim %q: must be one of %q", c.Claim, transformableClaims))
+	}
+	if len(c.Values) == 0 && c.Pattern == "" {
+		msgs = append(msgs, "values or a pattern is required")
+	}
+	if c.Pattern != "" {
+		if _, err := regexp.Compile(c.Pattern); err != nil {
+			msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", c.Pattern, err))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feature Flags", func() {
+	type validateFeatureFlagsTableInput struct {
+		featureFlags []options.FeatureFlag
+		errStrings   []string
+	}
+
+	DescribeTable("validateFeatureFlags",
+		func(in validateFeatureFlagsTableInput) {
+			Expect(validateFeatureFlags(in.featureFlags)).To(ConsistOf(in.errStrings))
+		},
+		Entry("with no feature flags", validateFeatureFlagsTableInput{
+			errStrings: []string{},
+		}),
+		Entry("with valid feature flags", validateFeatureFlagsTableInput{
+			featureFlags: []options.FeatureFlag{
+				{Name: "beta", Conditions: []options.FeatureFlagCondition{
+					{Claim: "groups", Values: []string{"beta-testers"}},
+				}},
+				{Name: "new-ui", Conditions: []options.FeatureFlagCondition{
+					{Claim: "email", Pattern: `@example\.com$`},
+					{Claim: "user", Values: []string{"jane"}},
+				}},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid and duplicate names", validateFeatureFlagsTableInput{
+			featureFlags: []options.FeatureFlag{
+				{Name: "", Conditions: []options.FeatureFlagCondition{
+					{Claim: "groups", Values: []string{"admins"}},
+				}},
+				{Name: "beta,new-ui", Conditions: []options.FeatureFlagCondition{
+					{Claim: "groups", Values: []string{"admins"}},
+				}},
+				{Name: "beta"},
+				{Name: "beta", Conditions: []options.FeatureFlagCondition{
+					{Claim: "groups", Values: []string{"admins"}},
+				}},
+			},
+			errStrings: []string{
+				"feature flag has empty name: please provide a name for the feature flag",
+				"feature flag \"beta,new-ui\" is invalid: names must not contain commas",
+				"feature flag \"beta\" has no conditions: at least one condition is required",
+				"multiple feature flags found with name \"beta\": names must be unique",
+			},
+		}),
+		Entry("with invalid conditions", validateFeatureFlagsTableInput{
+			featureFlags: []options.FeatureFlag{
+				{Name: "beta", Conditions: []options.FeatureFlagCondition{
+					{Claim: "access_token", Values: []string{"token"}},
+					{Claim: "groups"},
+					{Claim: "email", Pattern: "(example"},
+				}},
+			},
+			errStrings: []string{
+				"feature flag \"beta\": condition 0: invalid claim \"access_token\": must be one of [\"user\" \"email\" \"groups\" \"preferred_username\"]",
+				"feature flag \"beta\": condition 1: values or a pattern is required",
+				"feature flag \"beta\": condition 2: error compiling regex /(example/: error parsing regexp: missing closing ): `(example`",
+			},
+		}),
+	)
+})
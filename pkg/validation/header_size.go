@@ -0,0 +1,24 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateHeaderSize validates the options for limiting the size of the
+// headers injected into requests to the upstream
+func validateHeaderSize(headerSize options.HeaderSize) []string {
+	msgs := []string{}
+	if headerSize.MaxSize < 0 {
+		msgs = append(msgs, fmt.Sprintf("inject_header_max_size (%d) must not be negative", headerSize.MaxSize))
+	}
+
+	switch headerSize.Policy {
+	case options.HeaderSizeWarn, options.HeaderSizeTruncate, options.HeaderSizeDrop, options.HeaderSizeFail:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid inject_header_size_policy %q: must be one of %q, %q, %q or %q",
+			headerSize.Policy, options.HeaderSizeWarn, options.HeaderSizeTruncate, options.HeaderSizeDrop, options.HeaderSizeFail))
+	}
+	return msgs
+}
@@ -0,0 +1,52 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HeaderSize", func() {
+	type validateHeaderSizeTableInput struct {
+		headerSize options.HeaderSize
+		errStrings []string
+	}
+
+	DescribeTable("validateHeaderSize",
+		func(o *validateHeaderSizeTableInput) {
+			Expect(validateHeaderSize(o.headerSize)).To(ConsistOf(o.errStrings))
+		},
+		Entry("with the warn policy", &validateHeaderSizeTableInput{
+			headerSize: options.HeaderSize{
+				MaxSize: 8192,
+				Policy:  options.HeaderSizeWarn,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with the fail policy and no maximum size", &validateHeaderSizeTableInput{
+			headerSize: options.HeaderSize{
+				Policy: options.HeaderSizeFail,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a negative maximum size", &validateHeaderSizeTableInput{
+			headerSize: options.HeaderSize{
+				MaxSize: -1,
+				Policy:  options.HeaderSizeTruncate,
+			},
+			errStrings: []string{
+				"inject_header_max_size (-1) must not be negative",
+			},
+		}),
+		Entry("with an unknown policy", &validateHeaderSizeTableInput{
+			headerSize: options.HeaderSize{
+				MaxSize: 8192,
+				Policy:  "ignore",
+			},
+			errStrings: []string{
+				"invalid inject_header_size_policy \"ignore\": must be one of \"warn\", \"truncate\", \"drop\" or \"fail\"",
+			},
+		}),
+	)
+})
@@ -0,0 +1,27 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// validateIPFilter validates that the IP allowlist and denylist only contain
+// IPs and CIDRs
+func validateIPFilter(ipFilter options.IPFilter) []string {
+	msgs := []string{}
+
+	for i, ipStr := range ipFilter.Allowlist {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("ip_allowlist[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+	for i, ipStr := range ipFilter.Denylist {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("ip_denylist[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,44 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPFilter", func() {
+	type validateIPFilterTableInput struct {
+		ipFilter   options.IPFilter
+		errStrings []string
+	}
+
+	DescribeTable("validateIPFilter",
+		func(o *validateIPFilterTableInput) {
+			Expect(validateIPFilter(o.ipFilter)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateIPFilterTableInput{
+			ipFilter:   options.IPFilter{},
+			errStrings: []string{},
+		}),
+		Entry("with a valid allowlist and denylist", &validateIPFilterTableInput{
+			ipFilter: options.IPFilter{
+				Allowlist: []string{"10.0.0.0/8", "::1"},
+				Denylist:  []string{"10.1.0.0/16", "10.2.3.4"},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid allowlist entry", &validateIPFilterTableInput{
+			ipFilter: options.IPFilter{
+				Allowlist: []string{"10.0.0.0/8", "not-an-ip"},
+			},
+			errStrings: []string{"ip_allowlist[1] (not-an-ip) could not be recognized"},
+		}),
+		Entry("with an invalid denylist entry", &validateIPFilterTableInput{
+			ipFilter: options.IPFilter{
+				Denylist: []string{"10.0.0.1/8"},
+			},
+			errStrings: []string{"ip_denylist[0] (10.0.0.1/8) could not be recognized"},
+		}),
+	)
+})
@@ -0,0 +1,36 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/redirect"
+)
+
+// validateLandingRedirects validates the group=path landing redirects passed
+// with options.LandingRedirects
+func validateLandingRedirects(o *options.Options) []string {
+	msgs := []string{}
+	for i, landing := range o.LandingRedirects {
+		msgs = append(msgs, prefixValues(fmt.Sprintf("landing_redirects[%d]: ", i), validateLandingRedirect(o, landing)...)...)
+	}
+	return msgs
+}
+
+func validateLandingRedirect(o *options.Options, landing string) []string {
+	parts := strings.SplitN(landing, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return []string{fmt.Sprintf("landing redirect %q must be in the format group=path", landing)}
+	}
+
+	msgs := []string{}
+	if parts[0] == "" {
+		msgs = append(msgs, fmt.Sprintf("landing redirect %q must not have an empty group", landing))
+	}
+	// Redirects to tenant domains can only be validated for each request
+	if o.TenantRedirectsFile == "" && !redirect.NewValidator(o.WhitelistDomains).IsValidRedirect(parts[1]) {
+		msgs = append(msgs, fmt.Sprintf("redirect %q is not a valid redirect: absolute URLs must be within a whitelist_domain", parts[1]))
+	}
+	return msgs
+}
@@ -0,0 +1,64 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LandingRedirects", func() {
+	type validateLandingRedirectsTableInput struct {
+		landings            []string
+		whitelistDomains    []string
+		tenantRedirectsFile string
+		errStrings          []string
+	}
+
+	DescribeTable("validateLandingRedirects",
+		func(in *validateLandingRedirectsTableInput) {
+			o := &options.Options{
+				LandingRedirects:    in.landings,
+				WhitelistDomains:    in.whitelistDomains,
+				TenantRedirectsFile: in.tenantRedirectsFile,
+			}
+			Expect(validateLandingRedirects(o)).To(ConsistOf(in.errStrings))
+		},
+		Entry("with no landing redirects", &validateLandingRedirectsTableInput{
+			errStrings: []string{},
+		}),
+		Entry("with valid landing redirects", &validateLandingRedirectsTableInput{
+			landings: []string{
+				"admins=/admin",
+				"role:viewer=/dashboard?view=summary",
+				"support=https://support.example.com/queue",
+			},
+			whitelistDomains: []string{"support.example.com"},
+			errStrings:       []string{},
+		}),
+		Entry("with a landing redirect missing the path", &validateLandingRedirectsTableInput{
+			landings: []string{"/admin", "admins="},
+			errStrings: []string{
+				"landing_redirects[0]: landing redirect \"/admin\" must be in the format group=path",
+				"landing_redirects[1]: landing redirect \"admins=\" must be in the format group=path",
+			},
+		}),
+		Entry("with an empty group", &validateLandingRedirectsTableInput{
+			landings: []string{"admins=/admin", "=/dashboard"},
+			errStrings: []string{
+				"landing_redirects[1]: landing redirect \"=/dashboard\" must not have an empty group",
+			},
+		}),
+		Entry("with a redirect outside of the whitelist domains", &validateLandingRedirectsTableInput{
+			landings: []string{"admins=https://evil.example.com/admin"},
+			errStrings: []string{
+				"landing_redirects[0]: redirect \"https://evil.example.com/admin\" is not a valid redirect: absolute URLs must be within a whitelist_domain",
+			},
+		}),
+		Entry("with a redirect to a tenant domain", &validateLandingRedirectsTableInput{
+			landings:            []string{"admins=https://tenant.example.com/admin"},
+			tenantRedirectsFile: "tenants.yaml",
+			errStrings:          []string{},
+		}),
+	)
+})
@@ -0,0 +1,21 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateMetrics validates the options for serving metrics on the main
+// server
+func validateMetrics(metrics options.Metrics) []string {
+	msgs := []string{}
+	if metrics.Path != "" && !strings.HasPrefix(metrics.Path, "/") {
+		msgs = append(msgs, fmt.Sprintf("metrics_path (%s) must start with a \"/\"", metrics.Path))
+	}
+	if metrics.Path == "" && metrics.BearerToken != "" {
+		msgs = append(msgs, "metrics_bearer_token requires metrics_path to be set")
+	}
+	return msgs
+}
@@ -0,0 +1,48 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	type validateMetricsTableInput struct {
+		metrics    options.Metrics
+		errStrings []string
+	}
+
+	DescribeTable("validateMetrics",
+		func(o *validateMetricsTableInput) {
+			Expect(validateMetrics(o.metrics)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateMetricsTableInput{
+			metrics:    options.Metrics{},
+			errStrings: []string{},
+		}),
+		Entry("with a path and bearer token", &validateMetricsTableInput{
+			metrics: options.Metrics{
+				Path:        "/metrics",
+				BearerToken: "scraper-token",
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a relative path", &validateMetricsTableInput{
+			metrics: options.Metrics{
+				Path: "metrics",
+			},
+			errStrings: []string{
+				"metrics_path (metrics) must start with a \"/\"",
+			},
+		}),
+		Entry("with a bearer token and no path", &validateMetricsTableInput{
+			metrics: options.Metrics{
+				BearerToken: "scraper-token",
+			},
+			errStrings: []string{
+				"metrics_bearer_token requires metrics_path to be set",
+			},
+		}),
+	)
+})
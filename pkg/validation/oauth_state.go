@@ -0,0 +1,38 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+// validateOAuthState validates the options for verifying the OAuth2 state
+// parameter and generating the nonces used in the login flow
+func validateOAuthState(oauthState options.OAuthState) []string {
+	msgs := validateNonceLength("oauth_state_length", oauthState.StateLength)
+	msgs = append(msgs, validateNonceLength("oauth_nonce_length", oauthState.NonceLength)...)
+
+	switch oauthState.Mode {
+	case options.OAuthStateCookie:
+		return msgs
+	case options.OAuthStateSigned, options.OAuthStateSignedAndCookie:
+		if oauthState.Expire <= 0 {
+			msgs = append(msgs, fmt.Sprintf("oauth_state_expire (%s) must be positive when oauth_state_mode is %q", oauthState.Expire, oauthState.Mode))
+		}
+		return msgs
+	default:
+		return append(msgs, fmt.Sprintf("invalid oauth_state_mode %q: must be one of %q, %q or %q",
+			oauthState.Mode, options.OAuthStateCookie, options.OAuthStateSigned, options.OAuthStateSignedAndCookie))
+	}
+}
+
+// validateNonceLength checks that a configured nonce length is within the
+// lengths that give enough entropy and still fit within cookies and URLs
+func validateNonceLength(name string, length int) []string {
+	if length < encryption.MinNonceLength || length > encryption.MaxNonceLength {
+		return []string{fmt.Sprintf("%s (%d) must be between %d and %d bytes",
+			name, length, encryption.MinNonceLength, encryption.MaxNonceLength)}
+	}
+	return []string{}
+}
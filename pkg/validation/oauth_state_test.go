@@ -0,0 +1,98 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OAuthState", func() {
+	type validateOAuthStateTableInput struct {
+		oauthState options.OAuthState
+		errStrings []string
+	}
+
+	DescribeTable("validateOAuthState",
+		func(o *validateOAuthStateTableInput) {
+			Expect(validateOAuthState(o.oauthState)).To(ConsistOf(o.errStrings))
+		},
+		Entry("with cookie verification", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateCookie,
+				StateLength: 32,
+				NonceLength: 32,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with signed states", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateSigned,
+				Expire:      15 * time.Minute,
+				StateLength: 32,
+				NonceLength: 32,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with signed states and cookie verification", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateSignedAndCookie,
+				Expire:      time.Minute,
+				StateLength: 32,
+				NonceLength: 32,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with signed states that don't expire", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateSigned,
+				StateLength: 32,
+				NonceLength: 32,
+			},
+			errStrings: []string{
+				"oauth_state_expire (0s) must be positive when oauth_state_mode is \"signed\"",
+			},
+		}),
+		Entry("with an unknown mode", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        "hmac",
+				StateLength: 32,
+				NonceLength: 32,
+			},
+			errStrings: []string{
+				"invalid oauth_state_mode \"hmac\": must be one of \"cookie\", \"signed\" or \"signed+cookie\"",
+			},
+		}),
+		Entry("with the minimum nonce lengths", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateCookie,
+				StateLength: 16,
+				NonceLength: 16,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with nonce lengths below the minimum", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateCookie,
+				StateLength: 8,
+				NonceLength: 0,
+			},
+			errStrings: []string{
+				"oauth_state_length (8) must be between 16 and 128 bytes",
+				"oauth_nonce_length (0) must be between 16 and 128 bytes",
+			},
+		}),
+		Entry("with a state length above the maximum", &validateOAuthStateTableInput{
+			oauthState: options.OAuthState{
+				Mode:        options.OAuthStateCookie,
+				StateLength: 256,
+				NonceLength: 32,
+			},
+			errStrings: []string{
+				"oauth_state_length (256) must be between 16 and 128 bytes",
+			},
+		}),
+	)
+})
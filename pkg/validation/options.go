@@ -24,12 +24,38 @@ import (
 // Validate checks that required options are set and validates those that they
 // are of the correct format
 func Validate(o *options.Options) error {
-	msgs := validateCookie(o.Cookie)
+	msgs := deriveCookieSecret(&o.Cookie)
+	msgs = append(msgs, validateCookie(o.Cookie)...)
 	msgs = append(msgs, validateSessionCookieMinimal(o)...)
+	msgs = append(msgs, validateSessionEncryptTokensOnly(o)...)
+	msgs = append(msgs, validateSessionSignedOnly(o)...)
+	msgs = append(msgs, validateSessionLimit(o)...)
+	msgs = append(msgs, validateSessionExpiryJitter(o)...)
+	msgs = append(msgs, validateSessionRefreshDedupTTL(o)...)
+	msgs = append(msgs, validateSessionRevocationCheck(o)...)
+	msgs = append(msgs, validateSessionBinding(o)...)
+	msgs = append(msgs, validateSessionUnavailablePolicy(o)...)
+	msgs = append(msgs, validateSessionDegradedHeader(o)...)
 	msgs = append(msgs, validateRedisSessionStore(o)...)
+	msgs = append(msgs, validateEtcdSessionStore(o)...)
+	msgs = append(msgs, validatePostgresSessionStore(o)...)
 	msgs = append(msgs, prefixValues("injectRequestHeaders: ", validateHeaders(o.InjectRequestHeaders)...)...)
 	msgs = append(msgs, prefixValues("injectResponseHeaders: ", validateHeaders(o.InjectResponseHeaders)...)...)
+	msgs = append(msgs, prefixValues("authResponseHeaders: ", validateHeaders(o.AuthResponseHeaders)...)...)
+	msgs = append(msgs, prefixValues("claimTransformations: ", validateClaimTransformations(o.ClaimTransformations)...)...)
+	msgs = append(msgs, prefixValues("featureFlags: ", validateFeatureFlags(o.FeatureFlags)...)...)
 	msgs = append(msgs, validateProviders(o)...)
+	msgs = append(msgs, validateCompression(o.Compression)...)
+	msgs = append(msgs, validateServerTiming(o.ServerTiming)...)
+	msgs = append(msgs, validateIPFilter(o.IPFilter)...)
+	msgs = append(msgs, validateUpstreamToken(o.UpstreamToken, o.Cookie.Expire)...)
+	msgs = append(msgs, validateOAuthState(o.OAuthState)...)
+	msgs = append(msgs, validateRequestReplay(o)...)
+	msgs = append(msgs, validateShutdown(o.Shutdown)...)
+	msgs = append(msgs, validateStepUp(o.StepUp)...)
+	msgs = append(msgs, validateLandingRedirects(o)...)
+	msgs = append(msgs, validateHeaderSize(o.HeaderSize)...)
+	msgs = append(msgs, validateMetrics(o.Metrics)...)
 	msgs = configureLogger(o.Logging, msgs)
 	msgs = parseSignatureKey(o, msgs)
 
@@ -169,6 +195,10 @@ func Validate(o *options.Options) error {
 		logger.Print("WARNING: no explicit redirect URL: redirects will default to insecure HTTP")
 	}
 
+	if o.TenantRedirectClaim != "" && o.TenantRedirectsFile == "" {
+		msgs = append(msgs, "tenant_redirect_claim requires a tenant_redirects_file")
+	}
+
 	msgs = append(msgs, validateUpstreams(o.UpstreamServers)...)
 	msgs = parseProviderInfo(o, msgs)
 
@@ -209,14 +239,35 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 	p.RedeemURL, msgs = parseURL(o.Providers[0].RedeemURL, "redeem", msgs)
 	p.ProfileURL, msgs = parseURL(o.Providers[0].ProfileURL, "profile", msgs)
 	p.ValidateURL, msgs = parseURL(o.Providers[0].ValidateURL, "validate", msgs)
+	p.IntrospectURL, msgs = parseURL(o.Providers[0].IntrospectURL, "introspect", msgs)
 	p.ProtectedResource, msgs = parseURL(o.Providers[0].ProtectedResource, "resource", msgs)
 
 	// Make the OIDC options available to all providers that support it
 	p.AllowUnverifiedEmail = o.Providers[0].OIDCConfig.InsecureAllowUnverifiedEmail
 	p.EmailClaim = o.Providers[0].OIDCConfig.EmailClaim
+	p.ExtraEmailClaims = o.Providers[0].OIDCConfig.ExtraEmailClaims
+	p.PreferredEmailDomains = o.Providers[0].OIDCConfig.PreferredEmailDomains
+	p.PreferVerifiedEmail = o.Providers[0].OIDCConfig.PreferVerifiedEmail
 	p.GroupsClaim = o.Providers[0].OIDCConfig.GroupsClaim
 	p.Verifier = o.GetOIDCVerifier()
 
+	if o.Providers[0].OIDCConfig.UserTemplate != "" {
+		userTemplate, err := providers.ParseUserTemplate(o.Providers[0].OIDCConfig.UserTemplate)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid oidc-user-template: %v", err))
+		}
+		p.UserTemplate = userTemplate
+	}
+
+	for i, assertion := range o.Providers[0].OIDCConfig.ClaimAssertions {
+		claimAssertion, err := providers.NewClaimAssertion(assertion.Claim, assertion.Operator, assertion.Value, assertion.Values)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid oidc claim assertion[%d]: %v", i, err))
+			continue
+		}
+		p.ClaimAssertions = append(p.ClaimAssertions, claimAssertion)
+	}
+
 	// TODO (@NickMeves) - Remove This
 	// Backwards Compatibility for Deprecated UserIDClaim option
 	if o.Providers[0].OIDCConfig.EmailClaim == providers.OIDCEmailClaim &&
@@ -252,7 +303,9 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 			msgs = append(msgs, "keycloak-oidc provider requires an oidc issuer URL")
 		}
 		p.AddAllowedRoles(o.Providers[0].KeycloakConfig.Roles)
+		p.SetRoleClients(o.Providers[0].KeycloakConfig.RoleClients)
 	case *providers.GoogleProvider:
+		p.AddExtraScopes(o.Providers[0].GoogleConfig.ExtraScopes)
 		if o.Providers[0].GoogleConfig.ServiceAccountJSON != "" {
 			file, err := os.Open(o.Providers[0].GoogleConfig.ServiceAccountJSON)
 			if err != nil {
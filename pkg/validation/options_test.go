@@ -155,6 +155,31 @@ func TestRedirectURL(t *testing.T) {
 	assert.Equal(t, expected, o.GetRedirectURL())
 }
 
+func TestPostLogoutRedirectURL(t *testing.T) {
+	o := testOptions()
+	o.PostLogoutRedirectURL = "/signed-out"
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.WhitelistDomains = []string{"www.example.com"}
+	o.PostLogoutRedirectURL = "https://www.example.com/"
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestTenantRedirectClaim(t *testing.T) {
+	o := testOptions()
+	o.TenantRedirectsFile = "/etc/oauth2-proxy/tenants"
+	o.TenantRedirectClaim = "groups"
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.TenantRedirectClaim = "groups"
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{
+		"tenant_redirect_claim requires a tenant_redirects_file",
+	}), err.Error())
+}
+
 func TestDefaultProviderApiSettings(t *testing.T) {
 	o := testOptions()
 	assert.Equal(t, nil, Validate(o))
@@ -245,6 +270,39 @@ func TestSkipOIDCDiscovery(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestOIDCUserTemplate(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.UserTemplate = "{{.given_name}} {{.family_name}}"
+	assert.Equal(t, nil, Validate(o))
+	assert.NotNil(t, o.GetProvider().Data().UserTemplate)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.UserTemplate = "{{.given_name"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid oidc-user-template: template: user:1: unclosed action", err.Error())
+}
+
+func TestOIDCClaimAssertions(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.ClaimAssertions = []options.ClaimAssertion{
+		{Claim: "tid", Operator: "equals", Value: "tenant"},
+		{Claim: "iss", Operator: "matches", Value: "https://login\\.example\\.com/.*"},
+	}
+	assert.Equal(t, nil, Validate(o))
+	assert.Len(t, o.GetProvider().Data().ClaimAssertions, 2)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.ClaimAssertions = []options.ClaimAssertion{
+		{Claim: "tid", Operator: "is", Value: "tenant"},
+		{Claim: "roles", Operator: "in"},
+	}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid oidc claim assertion[0]: unknown operator \"is\", must be one of \"equals\", \"contains\", \"matches\" or \"in\"\n"+
+		"  invalid oidc claim assertion[1]: values are required by the \"in\" operator", err.Error())
+}
+
 func TestGCPHealthcheck(t *testing.T) {
 	o := testOptions()
 	o.GCPHealthChecks = true
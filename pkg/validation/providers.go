@@ -3,6 +3,7 @@ package validation
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 )
@@ -26,9 +27,56 @@ func validateProviders(o *options.Options) []string {
 		msgs = append(msgs, validateProvider(provider, providerIDs)...)
 	}
 
+	msgs = append(msgs, validateProviderErrors(o)...)
+
+	return msgs
+}
+
+// validateProviderErrors validates the error_code=value pairs passed with
+// options.ProviderErrorMessages and options.ProviderErrorRetryPrompts, and
+// the limit of login retries at the callback
+func validateProviderErrors(o *options.Options) []string {
+	msgs := []string{}
+
+	if o.LoginRetryLimit < 0 {
+		msgs = append(msgs, fmt.Sprintf("login_retry_limit (%d) must not be negative", o.LoginRetryLimit))
+	}
+
+	for _, mapping := range o.ProviderErrorMessages {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			msgs = append(msgs, fmt.Sprintf("invalid provider error message %q: must be of the form error_code=message", mapping))
+		}
+	}
+
+	for _, mapping := range o.ProviderErrorRetryPrompts {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			msgs = append(msgs, fmt.Sprintf("invalid provider error retry prompt %q: must be of the form error_code=prompt", mapping))
+			continue
+		}
+		if parts[0] == "*" {
+			msgs = append(msgs, fmt.Sprintf("invalid provider error retry prompt %q: retries must be configured for specific error codes", mapping))
+		}
+		for _, prompt := range strings.Fields(parts[1]) {
+			// Retrying with prompt=none could cause a redirect loop as the
+			// provider would return the same error again
+			if !validRetryPrompts[prompt] {
+				msgs = append(msgs, fmt.Sprintf("invalid provider error retry prompt %q: prompt must be one of login, consent or select_account", mapping))
+				break
+			}
+		}
+	}
+
 	return msgs
 }
 
+var validRetryPrompts = map[string]bool{
+	"login":          true,
+	"consent":        true,
+	"select_account": true,
+}
+
 func validateProvider(provider options.Provider, providerIDs map[string]struct{}) []string {
 	msgs := []string{}
 
@@ -60,7 +108,41 @@ func validateProvider(provider options.Provider, providerIDs map[string]struct{}
 	}
 
 	msgs = append(msgs, validateGoogleConfig(provider)...)
+	msgs = append(msgs, validateAllowedGroupsFile(provider)...)
+	msgs = append(msgs, validateEmailSelection(provider)...)
+
+	return msgs
+}
+
+// validateAllowedGroupsFile ensures the allowed groups file is only used by
+// providers that check sessions against the allowed groups directly.
+// Other providers transform the allowed groups when they are configured.
+func validateAllowedGroupsFile(provider options.Provider) []string {
+	msgs := []string{}
+	if provider.AllowedGroupsFile == "" {
+		return msgs
+	}
+	switch provider.Type {
+	case "google", "gitlab", "keycloak", "keycloak-oidc":
+		msgs = append(msgs, fmt.Sprintf("allowed groups file is not supported by the %s provider", provider.Type))
+	}
+	return msgs
+}
 
+// validateEmailSelection ensures the claims and domains used to choose the
+// session email are not empty
+func validateEmailSelection(provider options.Provider) []string {
+	msgs := []string{}
+	for i, claim := range provider.OIDCConfig.ExtraEmailClaims {
+		if strings.TrimSpace(claim) == "" {
+			msgs = append(msgs, fmt.Sprintf("oidc-extra-email-claim[%d] must not be empty", i))
+		}
+	}
+	for i, domain := range provider.OIDCConfig.PreferredEmailDomains {
+		if strings.TrimPrefix(strings.TrimSpace(domain), "@") == "" {
+			msgs = append(msgs, fmt.Sprintf("oidc-preferred-email-domain[%d] must not be empty", i))
+		}
+	}
 	return msgs
 }
 
@@ -80,5 +80,94 @@ var _ = Describe("Providers", func() {
 			},
 			errStrings: []string{skipButtonAndMultipleProvidersMsg},
 		}),
+		Entry("with an allowed groups file", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:                "ProviderID",
+						Type:              "oidc",
+						ClientID:          "ClientID",
+						ClientSecret:      "ClientSecret",
+						AllowedGroupsFile: "/etc/oauth2-proxy/groups",
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an allowed groups file for an unsupported provider", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:                "ProviderID",
+						Type:              "gitlab",
+						ClientID:          "ClientID",
+						ClientSecret:      "ClientSecret",
+						AllowedGroupsFile: "/etc/oauth2-proxy/groups",
+					},
+				},
+			},
+			errStrings: []string{"allowed groups file is not supported by the gitlab provider"},
+		}),
+		Entry("with email selection", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						OIDCConfig: options.OIDCOptions{
+							ExtraEmailClaims:      []string{"emails", "contact.email"},
+							PreferredEmailDomains: []string{"example.com", "@example.org"},
+							PreferVerifiedEmail:   true,
+						},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with empty email selection claims and domains", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers: options.Providers{
+					{
+						ID:           "ProviderID",
+						ClientID:     "ClientID",
+						ClientSecret: "ClientSecret",
+						OIDCConfig: options.OIDCOptions{
+							ExtraEmailClaims:      []string{"emails", " "},
+							PreferredEmailDomains: []string{"@"},
+						},
+					},
+				},
+			},
+			errStrings: []string{
+				"oidc-extra-email-claim[1] must not be empty",
+				"oidc-preferred-email-domain[0] must not be empty",
+			},
+		}),
+		Entry("with valid provider error mappings", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers:                 options.Providers{validProvider},
+				ProviderErrorMessages:     []string{"access_denied=You don't have access", "*=Something went wrong"},
+				ProviderErrorRetryPrompts: []string{"login_required=login", "consent_required=login consent"},
+				LoginRetryLimit:           2,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid provider error mappings", &validateProvidersTableInput{
+			options: &options.Options{
+				Providers:                 options.Providers{validProvider},
+				ProviderErrorMessages:     []string{"access_denied", "=message"},
+				ProviderErrorRetryPrompts: []string{"login_required=", "login_required=none", "*=login"},
+				LoginRetryLimit:           -1,
+			},
+			errStrings: []string{
+				"login_retry_limit (-1) must not be negative",
+				"invalid provider error message \"access_denied\": must be of the form error_code=message",
+				"invalid provider error message \"=message\": must be of the form error_code=message",
+				"invalid provider error retry prompt \"login_required=\": must be of the form error_code=prompt",
+				"invalid provider error retry prompt \"login_required=none\": prompt must be one of login, consent or select_account",
+				"invalid provider error retry prompt \"*=login\": retries must be configured for specific error codes",
+			},
+		}),
 	)
 })
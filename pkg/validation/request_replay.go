@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateRequestReplay validates the options for replaying POST requests
+// after the login. Requests are stashed in the persistent session store, and
+// can only be stashed when the POST request itself starts the login flow.
+func validateRequestReplay(o *options.Options) []string {
+	if !o.RequestReplay.Enabled {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.Type == options.CookieSessionStoreType {
+		msgs = append(msgs, "replay_post_requests requires a persistent session store and is not supported by the cookie session store")
+	}
+	if !o.SkipProviderButton {
+		msgs = append(msgs, "replay_post_requests requires skip_provider_button, so that POST requests start the login flow")
+	}
+	if o.RequestReplay.MaxBodySize <= 0 {
+		msgs = append(msgs, fmt.Sprintf("replay_post_max_body_size (%d) must be positive", o.RequestReplay.MaxBodySize))
+	}
+	if o.RequestReplay.Expire <= 0 {
+		msgs = append(msgs, fmt.Sprintf("replay_post_expire (%s) must be positive", o.RequestReplay.Expire))
+	}
+	return msgs
+}
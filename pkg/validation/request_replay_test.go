@@ -0,0 +1,91 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestReplay", func() {
+	type validateRequestReplayTableInput struct {
+		opts       *options.Options
+		errStrings []string
+	}
+
+	DescribeTable("validateRequestReplay",
+		func(o *validateRequestReplayTableInput) {
+			Expect(validateRequestReplay(o.opts)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateRequestReplayTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a persistent session store", &validateRequestReplayTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+				},
+				SkipProviderButton: true,
+				RequestReplay: options.RequestReplay{
+					Enabled:     true,
+					MaxBodySize: 1024,
+					Expire:      time.Minute,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with the cookie session store", &validateRequestReplayTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+				SkipProviderButton: true,
+				RequestReplay: options.RequestReplay{
+					Enabled:     true,
+					MaxBodySize: 1024,
+					Expire:      time.Minute,
+				},
+			},
+			errStrings: []string{
+				"replay_post_requests requires a persistent session store and is not supported by the cookie session store",
+			},
+		}),
+		Entry("without skip provider button", &validateRequestReplayTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.EtcdSessionStoreType,
+				},
+				RequestReplay: options.RequestReplay{
+					Enabled:     true,
+					MaxBodySize: 1024,
+					Expire:      time.Minute,
+				},
+			},
+			errStrings: []string{
+				"replay_post_requests requires skip_provider_button, so that POST requests start the login flow",
+			},
+		}),
+		Entry("without a body size or expiry", &validateRequestReplayTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+				},
+				SkipProviderButton: true,
+				RequestReplay: options.RequestReplay{
+					Enabled: true,
+				},
+			},
+			errStrings: []string{
+				"replay_post_max_body_size (0) must be positive",
+				"replay_post_expire (0s) must be positive",
+			},
+		}),
+	)
+})
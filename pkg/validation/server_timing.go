@@ -0,0 +1,29 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// validateServerTiming validates the options for the Server-Timing header.
+// Clients may only request the header when they are trusted, so that timing
+// information is not exposed to every client that knows the request header.
+func validateServerTiming(serverTiming options.ServerTiming) []string {
+	msgs := []string{}
+
+	if serverTiming.RequestHeader != "" && len(serverTiming.TrustedIPs) == 0 {
+		msgs = append(msgs, "server_timing_request_header requires server_timing_trusted_ips to be set")
+	}
+	if serverTiming.RequestHeader == "" && len(serverTiming.TrustedIPs) > 0 {
+		msgs = append(msgs, "server_timing_trusted_ips requires server_timing_request_header to be set")
+	}
+	for i, ipStr := range serverTiming.TrustedIPs {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("server_timing_trusted_ips[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,57 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerTiming", func() {
+	type validateServerTimingTableInput struct {
+		serverTiming options.ServerTiming
+		errStrings   []string
+	}
+
+	DescribeTable("validateServerTiming",
+		func(o *validateServerTimingTableInput) {
+			Expect(validateServerTiming(o.serverTiming)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{},
+			errStrings:   []string{},
+		}),
+		Entry("when enabled for all requests", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{
+				Enabled: true,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a request header and trusted IPs", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+				TrustedIPs:    []string{"10.0.0.0/8", "127.0.0.1"},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a request header and no trusted IPs", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+			},
+			errStrings: []string{"server_timing_request_header requires server_timing_trusted_ips to be set"},
+		}),
+		Entry("with trusted IPs and no request header", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{
+				TrustedIPs: []string{"127.0.0.1"},
+			},
+			errStrings: []string{"server_timing_trusted_ips requires server_timing_request_header to be set"},
+		}),
+		Entry("with an invalid trusted IP", &validateServerTimingTableInput{
+			serverTiming: options.ServerTiming{
+				RequestHeader: "X-Debug-Timing",
+				TrustedIPs:    []string{"127.0.0.1", "not-an-ip"},
+			},
+			errStrings: []string{"server_timing_trusted_ips[1] (not-an-ip) could not be recognized"},
+		}),
+	)
+})
@@ -4,20 +4,42 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/etcd"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/postgres"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/redis"
 )
 
+// maxSessionRefreshDedupTTL is the longest the result of a refresh may be
+// shared with other sessions
+const maxSessionRefreshDedupTTL = time.Minute
+
+// minSessionRevocationCheckInterval is the shortest interval sessions may be
+// checked for revoked refresh tokens at, so that the provider is not called
+// on every request
+const minSessionRevocationCheckInterval = time.Minute
+
+// headerNameRegex matches the characters allowed in header names by RFC 7230
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// postgresTableRegex matches unquoted PostgreSQL identifiers, which the
+// postgres_table must be so that the table name is used as given
+var postgresTableRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
 func validateSessionCookieMinimal(o *options.Options) []string {
 	if !o.Session.Cookie.Minimal {
 		return []string{}
 	}
 
 	msgs := []string{}
-	for _, header := range append(o.InjectRequestHeaders, o.InjectResponseHeaders...) {
+	headers := append(append([]options.Header{}, o.InjectRequestHeaders...), o.InjectResponseHeaders...)
+	for _, header := range append(headers, o.AuthResponseHeaders...) {
 		for _, value := range header.Values {
 			if value.ClaimSource != nil {
 				if value.ClaimSource.Claim == "access_token" {
@@ -39,6 +61,175 @@ func validateSessionCookieMinimal(o *options.Options) []string {
 	return msgs
 }
 
+// validateSessionEncryptTokensOnly ensures tokens are stored in the session
+// when only the tokens are to be encrypted.
+func validateSessionEncryptTokensOnly(o *options.Options) []string {
+	if o.Session.EncryptTokensOnly && o.Session.Cookie.Minimal {
+		return []string{"session_encrypt_tokens_only requires oauth tokens in sessions. session_cookie_minimal cannot be set"}
+	}
+	return []string{}
+}
+
+// validateSessionSignedOnly ensures sessions are not both signed only and
+// partially encrypted, and warns that sessions will not be encrypted.
+func validateSessionSignedOnly(o *options.Options) []string {
+	if !o.Session.SignedOnly {
+		return []string{}
+	}
+	if o.Session.EncryptTokensOnly {
+		return []string{"session_signed_only cannot be combined with session_encrypt_tokens_only"}
+	}
+
+	logger.Print("WARNING: session_signed_only is set: sessions are signed but NOT encrypted. " +
+		"The whole session, including any OAuth tokens, can be read by anyone with access to the session cookie or session store")
+	return []string{}
+}
+
+// validateSessionExpiryJitter ensures the expiry jitter is only used with
+// persistent session stores and leaves sessions with a positive lifetime.
+func validateSessionExpiryJitter(o *options.Options) []string {
+	jitter := o.Session.ExpiryJitter
+	msgs := []string{}
+	if jitter < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_expiry_jitter (%s) must not be negative", jitter))
+	}
+	if jitter > 0 && o.Session.Type == options.CookieSessionStoreType {
+		msgs = append(msgs, "session_expiry_jitter requires a persistent session store and is not supported by the cookie session store")
+	}
+	if jitter > 0 && jitter >= o.Cookie.Expire {
+		msgs = append(msgs, fmt.Sprintf("session_expiry_jitter (%s) must be less than cookie_expire (%s)", jitter, o.Cookie.Expire))
+	}
+	return msgs
+}
+
+// validateSessionRefreshDedupTTL ensures the results of refreshes are only
+// shared for a short time, as they hold the refreshed tokens in memory.
+func validateSessionRefreshDedupTTL(o *options.Options) []string {
+	ttl := o.Session.RefreshDedupTTL
+	msgs := []string{}
+	if ttl < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_refresh_dedup_ttl (%s) must not be negative", ttl))
+	}
+	if ttl > maxSessionRefreshDedupTTL {
+		msgs = append(msgs, fmt.Sprintf("session_refresh_dedup_ttl (%s) must not be more than %s", ttl, maxSessionRefreshDedupTTL))
+	}
+	return msgs
+}
+
+// validateSessionRevocationCheck ensures sessions are not checked for revoked
+// refresh tokens more often than the minimum interval, and that the refresh
+// tokens are kept in sessions and can be introspected when that is the method.
+func validateSessionRevocationCheck(o *options.Options) []string {
+	interval := o.Session.RevocationCheckInterval
+	msgs := []string{}
+	if interval < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_revocation_check_interval (%s) must not be negative", interval))
+	}
+	if interval > 0 && interval < minSessionRevocationCheckInterval {
+		msgs = append(msgs, fmt.Sprintf("session_revocation_check_interval (%s) must be at least %s", interval, minSessionRevocationCheckInterval))
+	}
+
+	switch o.Session.RevocationCheckMethod {
+	case "", options.RefreshRevocationMethod:
+	case options.IntrospectionRevocationMethod:
+		if interval > 0 && o.Providers[0].IntrospectURL == "" {
+			msgs = append(msgs, "session_revocation_check_method \"introspection\" requires an introspect_url")
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("session_revocation_check_method (%q) must be one of ['refresh', 'introspection']", o.Session.RevocationCheckMethod))
+	}
+
+	if interval > 0 && o.Session.Cookie.Minimal {
+		msgs = append(msgs, "session_revocation_check_interval > 0 requires oauth tokens in sessions. session_cookie_minimal cannot be set")
+	}
+	return msgs
+}
+
+// validateSessionBinding ensures sessions are bound to a valid prefix of the
+// client IP, so that the binding tolerates clients moving within a network.
+func validateSessionBinding(o *options.Options) []string {
+	if !o.Session.BindClientIP {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.BindIPv4Prefix < 1 || o.Session.BindIPv4Prefix > 32 {
+		msgs = append(msgs, fmt.Sprintf("session_bind_ipv4_prefix (%d) must be between 1 and 32", o.Session.BindIPv4Prefix))
+	}
+	if o.Session.BindIPv6Prefix < 1 || o.Session.BindIPv6Prefix > 128 {
+		msgs = append(msgs, fmt.Sprintf("session_bind_ipv6_prefix (%d) must be between 1 and 128", o.Session.BindIPv6Prefix))
+	}
+	return msgs
+}
+
+// validateSessionLimit ensures the per user session limit is only used with
+// persistent session stores, which are able to index sessions by user.
+// Single sessions per user are not enforced by the cookie session store, which
+// is only warned about.
+func validateSessionLimit(o *options.Options) []string {
+	msgs := []string{}
+	if o.Session.MaxPerUser < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_max_per_user (%d) must not be negative", o.Session.MaxPerUser))
+	}
+	if o.Session.MaxPerUser > 0 && o.Session.Type == options.CookieSessionStoreType {
+		msgs = append(msgs, "session_max_per_user requires a persistent session store and is not supported by the cookie session store")
+	}
+	if o.Session.SinglePerUser && o.Session.Type == options.CookieSessionStoreType {
+		// Cookie sessions can't be cleared server side, so this can't be enforced
+		logger.Print("WARNING: session_single_per_user is set, but the cookie session store cannot index sessions by user: " +
+			"the other sessions of users will not be cleared when they log in")
+	}
+
+	switch o.Session.EvictionPolicy {
+	case "", options.OldestSessionEvictionPolicy, options.RejectSessionEvictionPolicy:
+	default:
+		msgs = append(msgs, fmt.Sprintf("session_eviction_policy (%q) must be one of ['oldest', 'reject']", o.Session.EvictionPolicy))
+	}
+	return msgs
+}
+
+// validateSessionDegradedHeader ensures the degraded session header is a valid
+// header name that is not also injected into requests. Sessions are only
+// degraded when they fail to refresh, which is warned about when sessions are
+// never refreshed.
+func validateSessionDegradedHeader(o *options.Options) []string {
+	header := o.Session.DegradedHeader
+	msgs := []string{}
+	if header == "" {
+		return msgs
+	}
+
+	if !headerNameRegex.MatchString(header) {
+		msgs = append(msgs, fmt.Sprintf("session_degraded_header (%q) must be a valid header name", header))
+	}
+	for _, injected := range o.InjectRequestHeaders {
+		if http.CanonicalHeaderKey(injected.Name) == http.CanonicalHeaderKey(header) {
+			msgs = append(msgs, fmt.Sprintf("session_degraded_header (%q) must not also be an injected request header", header))
+		}
+	}
+	if o.Cookie.Refresh == time.Duration(0) {
+		logger.Print("WARNING: session_degraded_header is set, but cookie_refresh is not: " +
+			"sessions are never refreshed, so they will never be marked as degraded")
+	}
+	return msgs
+}
+
+// validateSessionUnavailablePolicy ensures the unavailable policy is known and
+// that the cookie fallback is only used with persistent session stores.
+func validateSessionUnavailablePolicy(o *options.Options) []string {
+	msgs := []string{}
+	switch o.Session.UnavailablePolicy {
+	case "", options.FailClosedUnavailablePolicy:
+	case options.CookieFallbackUnavailablePolicy:
+		if o.Session.Type == options.CookieSessionStoreType {
+			msgs = append(msgs, "session_store_unavailable_policy \"cookie-fallback\" requires a persistent session store and is not supported by the cookie session store")
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("session_store_unavailable_policy (%q) must be one of ['fail-closed', 'cookie-fallback']", o.Session.UnavailablePolicy))
+	}
+	return msgs
+}
+
 // validateRedisSessionStore builds a Redis Client from the options and
 // attempts to connect, Set, Get and Del a random health check key
 func validateRedisSessionStore(o *options.Options) []string {
@@ -86,3 +277,137 @@ func sendRedisConnectionTest(client redis.Client, key string, val string) []stri
 	}
 	return msgs
 }
+
+// validateEtcdSessionStore checks the etcd options, then builds an etcd Client
+// from them and attempts to Set, Get and Del a random health check key
+func validateEtcdSessionStore(o *options.Options) []string {
+	if o.Session.Type != options.EtcdSessionStoreType {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if len(o.Session.Etcd.Endpoints) == 0 {
+		msgs = append(msgs, "etcd_endpoints must be set when using the etcd session store")
+	}
+	if (o.Session.Etcd.CertPath == "") != (o.Session.Etcd.KeyPath == "") {
+		msgs = append(msgs, "etcd_cert_path and etcd_key_path must be set together")
+	}
+	if (o.Session.Etcd.Username == "") != (o.Session.Etcd.Password == "") {
+		msgs = append(msgs, "etcd_username and etcd_password must be set together")
+	}
+	if len(msgs) > 0 {
+		return msgs
+	}
+
+	client, err := etcd.NewEtcdClient(o.Session.Etcd)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to initialize an etcd client: %v", err)}
+	}
+
+	n, err := encryption.Nonce()
+	if err != nil {
+		return []string{fmt.Sprintf("unable to generate an etcd initialization test key: %v", err)}
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(n)
+
+	key := fmt.Sprintf("%s-healthcheck-%s", o.Cookie.Name, nonce)
+	return sendEtcdConnectionTest(client, key, nonce)
+}
+
+func sendEtcdConnectionTest(client etcd.Client, key string, val string) []string {
+	msgs := []string{}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := client.Set(ctx, key, []byte(val), time.Duration(60)*time.Second)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to set an etcd initialization key: %v", err))
+	} else {
+		gval, err := client.Get(ctx, key)
+		if err != nil {
+			msgs = append(msgs,
+				fmt.Sprintf("unable to retrieve etcd initialization key: %v", err))
+		}
+		if string(gval) != val {
+			msgs = append(msgs,
+				"the retrieved etcd initialization key did not match the value we set")
+		}
+	}
+
+	err = client.Del(ctx, key)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to delete the etcd initialization key: %v", err))
+	}
+	return msgs
+}
+
+// validatePostgresSessionStore checks the postgres options, then builds a
+// postgres Client from them and attempts to Set, Get and Del a random health
+// check key
+func validatePostgresSessionStore(o *options.Options) []string {
+	if o.Session.Type != options.PostgresSessionStoreType {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.Postgres.ConnectionURL == "" {
+		msgs = append(msgs, "postgres_connection_url must be set when using the postgres session store")
+	}
+	if !postgresTableRegex.MatchString(o.Session.Postgres.Table) {
+		msgs = append(msgs, fmt.Sprintf("postgres_table (%q) must start with a letter or underscore, contain only letters, digits and underscores, and be at most 63 characters", o.Session.Postgres.Table))
+	}
+	if o.Session.Postgres.MaxOpenConns < 1 {
+		msgs = append(msgs, fmt.Sprintf("postgres_max_open_conns (%d) must be at least 1", o.Session.Postgres.MaxOpenConns))
+	}
+	if o.Session.Postgres.ReapInterval < 0 {
+		msgs = append(msgs, fmt.Sprintf("postgres_reap_interval (%s) must not be negative", o.Session.Postgres.ReapInterval))
+	}
+	if (o.Session.Postgres.CertPath == "") != (o.Session.Postgres.KeyPath == "") {
+		msgs = append(msgs, "postgres_cert_path and postgres_key_path must be set together")
+	}
+	if len(msgs) > 0 {
+		return msgs
+	}
+
+	client, err := postgres.NewPostgresClient(o.Session.Postgres)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to initialize a postgres client: %v", err)}
+	}
+	defer client.Close()
+
+	n, err := encryption.Nonce()
+	if err != nil {
+		return []string{fmt.Sprintf("unable to generate a postgres initialization test key: %v", err)}
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(n)
+
+	key := fmt.Sprintf("%s-healthcheck-%s", o.Cookie.Name, nonce)
+	return sendPostgresConnectionTest(client, key, nonce)
+}
+
+func sendPostgresConnectionTest(client postgres.Client, key string, val string) []string {
+	msgs := []string{}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := client.Set(ctx, key, []byte(val), time.Duration(60)*time.Second)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to set a postgres initialization key: %v", err))
+	} else {
+		gval, err := client.Get(ctx, key)
+		if err != nil {
+			msgs = append(msgs,
+				fmt.Sprintf("unable to retrieve postgres initialization key: %v", err))
+		}
+		if string(gval) != val {
+			msgs = append(msgs,
+				"the retrieved postgres initialization key did not match the value we set")
+		}
+	}
+
+	err = client.Del(ctx, key)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to delete the postgres initialization key: %v", err))
+	}
+	return msgs
+}
@@ -113,6 +113,28 @@ var _ = Describe("Sessions", func() {
 			},
 			errStrings: []string{idTokenConflictMsg},
 		}),
+		Entry("Auth Response Header access_token conflict", &cookieMinimalTableInput{
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Minimal: true,
+					},
+				},
+				AuthResponseHeaders: []options.Header{
+					{
+						Name: "X-Access-Token",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "access_token",
+								},
+							},
+						},
+					},
+				},
+			},
+			errStrings: []string{accessTokenConflictMsg},
+		}),
 		Entry("Request Header access_token conflict", &cookieMinimalTableInput{
 			opts: &options.Options{
 				Session: options.SessionOptions{
@@ -405,4 +427,327 @@ var _ = Describe("Sessions", func() {
 			errStrings: []string{clusterAndSentinelMsg},
 		}),
 	)
+
+	DescribeTable("validateEtcdSessionStore",
+		func(session options.SessionOptions, errStrings []string) {
+			opts := &options.Options{
+				Session: session,
+			}
+			Expect(validateEtcdSessionStore(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("cookie sessions are skipped", options.SessionOptions{
+			Type: options.CookieSessionStoreType,
+		}, []string{}),
+		Entry("missing endpoints", options.SessionOptions{
+			Type: options.EtcdSessionStoreType,
+		}, []string{"etcd_endpoints must be set when using the etcd session store"}),
+		Entry("client certificate without a key", options.SessionOptions{
+			Type: options.EtcdSessionStoreType,
+			Etcd: options.EtcdStoreOptions{
+				Endpoints: []string{"https://127.0.0.1:2379"},
+				CertPath:  "/etc/etcd/client.crt",
+			},
+		}, []string{"etcd_cert_path and etcd_key_path must be set together"}),
+		Entry("username without a password", options.SessionOptions{
+			Type: options.EtcdSessionStoreType,
+			Etcd: options.EtcdStoreOptions{
+				Endpoints: []string{"https://127.0.0.1:2379"},
+				Username:  "oauth2-proxy",
+			},
+		}, []string{"etcd_username and etcd_password must be set together"}),
+	)
+
+	DescribeTable("validatePostgresSessionStore",
+		func(session options.SessionOptions, errStrings []string) {
+			opts := &options.Options{
+				Session: session,
+			}
+			Expect(validatePostgresSessionStore(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("cookie sessions are skipped", options.SessionOptions{
+			Type: options.CookieSessionStoreType,
+		}, []string{}),
+		Entry("missing connection url", options.SessionOptions{
+			Type: options.PostgresSessionStoreType,
+			Postgres: options.PostgresStoreOptions{
+				Table:        "oauth2_proxy_sessions",
+				MaxOpenConns: 10,
+			},
+		}, []string{"postgres_connection_url must be set when using the postgres session store"}),
+		Entry("invalid pool and reaper options", options.SessionOptions{
+			Type: options.PostgresSessionStoreType,
+			Postgres: options.PostgresStoreOptions{
+				ConnectionURL: "postgres://127.0.0.1:5432/sessions",
+				Table:         "oauth2_proxy_sessions",
+				ReapInterval:  -time.Minute,
+			},
+		}, []string{
+			"postgres_max_open_conns (0) must be at least 1",
+			"postgres_reap_interval (-1m0s) must not be negative",
+		}),
+		Entry("quoted table name", options.SessionOptions{
+			Type: options.PostgresSessionStoreType,
+			Postgres: options.PostgresStoreOptions{
+				ConnectionURL: "postgres://127.0.0.1:5432/sessions",
+				Table:         "public.sessions",
+				MaxOpenConns:  10,
+			},
+		}, []string{"postgres_table (\"public.sessions\") must start with a letter or underscore, contain only letters, digits and underscores, and be at most 63 characters"}),
+		Entry("client certificate without a key", options.SessionOptions{
+			Type: options.PostgresSessionStoreType,
+			Postgres: options.PostgresStoreOptions{
+				ConnectionURL: "postgres://127.0.0.1:5432/sessions",
+				Table:         "oauth2_proxy_sessions",
+				MaxOpenConns:  10,
+				CertPath:      "/etc/postgres/client.crt",
+			},
+		}, []string{"postgres_cert_path and postgres_key_path must be set together"}),
+	)
+
+	const (
+		negativeLimitMsg    = "session_max_per_user (-1) must not be negative"
+		cookieStoreLimitMsg = "session_max_per_user requires a persistent session store and is not supported by the cookie session store"
+		invalidEvictionMsg  = "session_eviction_policy (\"newest\") must be one of ['oldest', 'reject']"
+	)
+
+	type sessionLimitTableInput struct {
+		session    options.SessionOptions
+		errStrings []string
+	}
+
+	DescribeTable("validateSessionLimit",
+		func(o *sessionLimitTableInput) {
+			Expect(validateSessionLimit(&options.Options{Session: o.session})).To(ConsistOf(o.errStrings))
+		},
+		Entry("with no limit", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:           options.CookieSessionStoreType,
+				EvictionPolicy: options.OldestSessionEvictionPolicy,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a limit and redis sessions", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:           options.RedisSessionStoreType,
+				MaxPerUser:     3,
+				EvictionPolicy: options.RejectSessionEvictionPolicy,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a limit and cookie sessions", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:       options.CookieSessionStoreType,
+				MaxPerUser: 3,
+			},
+			errStrings: []string{cookieStoreLimitMsg},
+		}),
+		Entry("with single sessions and redis sessions", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:          options.RedisSessionStoreType,
+				SinglePerUser: true,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with single sessions and cookie sessions", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:          options.CookieSessionStoreType,
+				SinglePerUser: true,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a negative limit", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:       options.RedisSessionStoreType,
+				MaxPerUser: -1,
+			},
+			errStrings: []string{negativeLimitMsg},
+		}),
+		Entry("with an invalid eviction policy", &sessionLimitTableInput{
+			session: options.SessionOptions{
+				Type:           options.RedisSessionStoreType,
+				MaxPerUser:     3,
+				EvictionPolicy: "newest",
+			},
+			errStrings: []string{invalidEvictionMsg},
+		}),
+	)
+
+	DescribeTable("validateSessionEncryptTokensOnly",
+		func(session options.SessionOptions, errStrings []string) {
+			Expect(validateSessionEncryptTokensOnly(&options.Options{Session: session})).To(ConsistOf(errStrings))
+		},
+		Entry("with tokens only encryption", options.SessionOptions{
+			EncryptTokensOnly: true,
+		}, []string{}),
+		Entry("with tokens only encryption and a minimal cookie session", options.SessionOptions{
+			EncryptTokensOnly: true,
+			Cookie: options.CookieStoreOptions{
+				Minimal: true,
+			},
+		}, []string{"session_encrypt_tokens_only requires oauth tokens in sessions. session_cookie_minimal cannot be set"}),
+	)
+
+	DescribeTable("validateSessionSignedOnly",
+		func(session options.SessionOptions, errStrings []string) {
+			Expect(validateSessionSignedOnly(&options.Options{Session: session})).To(ConsistOf(errStrings))
+		},
+		Entry("with encrypted sessions", options.SessionOptions{}, []string{}),
+		Entry("with signed only sessions", options.SessionOptions{
+			SignedOnly: true,
+		}, []string{}),
+		Entry("with signed only sessions and a minimal cookie session", options.SessionOptions{
+			SignedOnly: true,
+			Cookie: options.CookieStoreOptions{
+				Minimal: true,
+			},
+		}, []string{}),
+		Entry("with signed only sessions and tokens only encryption", options.SessionOptions{
+			SignedOnly:        true,
+			EncryptTokensOnly: true,
+		}, []string{"session_signed_only cannot be combined with session_encrypt_tokens_only"}),
+	)
+
+	DescribeTable("validateSessionExpiryJitter",
+		func(o *options.Options, errStrings []string) {
+			Expect(validateSessionExpiryJitter(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with no jitter", &options.Options{
+			Cookie:  options.Cookie{Expire: time.Hour},
+			Session: options.SessionOptions{Type: options.CookieSessionStoreType},
+		}, []string{}),
+		Entry("with a jitter and redis sessions", &options.Options{
+			Cookie:  options.Cookie{Expire: time.Hour},
+			Session: options.SessionOptions{Type: options.RedisSessionStoreType, ExpiryJitter: time.Minute},
+		}, []string{}),
+		Entry("with a jitter and cookie sessions", &options.Options{
+			Cookie:  options.Cookie{Expire: time.Hour},
+			Session: options.SessionOptions{Type: options.CookieSessionStoreType, ExpiryJitter: time.Minute},
+		}, []string{"session_expiry_jitter requires a persistent session store and is not supported by the cookie session store"}),
+		Entry("with a negative jitter", &options.Options{
+			Cookie:  options.Cookie{Expire: time.Hour},
+			Session: options.SessionOptions{Type: options.RedisSessionStoreType, ExpiryJitter: -time.Minute},
+		}, []string{"session_expiry_jitter (-1m0s) must not be negative"}),
+		Entry("with a jitter as long as the cookie expiry", &options.Options{
+			Cookie:  options.Cookie{Expire: time.Hour},
+			Session: options.SessionOptions{Type: options.RedisSessionStoreType, ExpiryJitter: time.Hour},
+		}, []string{"session_expiry_jitter (1h0m0s) must be less than cookie_expire (1h0m0s)"}),
+	)
+
+	DescribeTable("validateSessionRefreshDedupTTL",
+		func(session options.SessionOptions, errStrings []string) {
+			Expect(validateSessionRefreshDedupTTL(&options.Options{Session: session})).To(ConsistOf(errStrings))
+		},
+		Entry("with deduplication disabled", options.SessionOptions{}, []string{}),
+		Entry("with a short TTL", options.SessionOptions{
+			RefreshDedupTTL: 10 * time.Second,
+		}, []string{}),
+		Entry("with a negative TTL", options.SessionOptions{
+			RefreshDedupTTL: -time.Second,
+		}, []string{"session_refresh_dedup_ttl (-1s) must not be negative"}),
+		Entry("with a long TTL", options.SessionOptions{
+			RefreshDedupTTL: time.Hour,
+		}, []string{"session_refresh_dedup_ttl (1h0m0s) must not be more than 1m0s"}),
+	)
+
+	DescribeTable("validateSessionRevocationCheck",
+		func(session options.SessionOptions, introspectURL string, errStrings []string) {
+			o := &options.Options{
+				Session:   session,
+				Providers: options.Providers{{IntrospectURL: introspectURL}},
+			}
+			Expect(validateSessionRevocationCheck(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with checks disabled", options.SessionOptions{
+			RevocationCheckMethod: options.IntrospectionRevocationMethod,
+		}, "", []string{}),
+		Entry("with checks by refreshing", options.SessionOptions{
+			RevocationCheckInterval: 5 * time.Minute,
+			RevocationCheckMethod:   options.RefreshRevocationMethod,
+		}, "", []string{}),
+		Entry("with checks by introspection", options.SessionOptions{
+			RevocationCheckInterval: 5 * time.Minute,
+			RevocationCheckMethod:   options.IntrospectionRevocationMethod,
+		}, "https://idp.example.com/introspect", []string{}),
+		Entry("with checks by introspection without an introspect url", options.SessionOptions{
+			RevocationCheckInterval: 5 * time.Minute,
+			RevocationCheckMethod:   options.IntrospectionRevocationMethod,
+		}, "", []string{"session_revocation_check_method \"introspection\" requires an introspect_url"}),
+		Entry("with a short interval", options.SessionOptions{
+			RevocationCheckInterval: time.Second,
+		}, "", []string{"session_revocation_check_interval (1s) must be at least 1m0s"}),
+		Entry("with a negative interval", options.SessionOptions{
+			RevocationCheckInterval: -time.Minute,
+		}, "", []string{"session_revocation_check_interval (-1m0s) must not be negative"}),
+		Entry("with an invalid method", options.SessionOptions{
+			RevocationCheckMethod: "userinfo",
+		}, "", []string{"session_revocation_check_method (\"userinfo\") must be one of ['refresh', 'introspection']"}),
+		Entry("with checks and a minimal cookie session", options.SessionOptions{
+			RevocationCheckInterval: 5 * time.Minute,
+			Cookie:                  options.CookieStoreOptions{Minimal: true},
+		}, "", []string{"session_revocation_check_interval > 0 requires oauth tokens in sessions. session_cookie_minimal cannot be set"}),
+	)
+
+	DescribeTable("validateSessionDegradedHeader",
+		func(o *options.Options, errStrings []string) {
+			Expect(validateSessionDegradedHeader(o)).To(ConsistOf(errStrings))
+		},
+		Entry("without a header", &options.Options{}, []string{}),
+		Entry("with a header", &options.Options{
+			Cookie:  options.Cookie{Refresh: time.Hour},
+			Session: options.SessionOptions{DegradedHeader: "X-Auth-Degraded"},
+		}, []string{}),
+		Entry("with an invalid header name", &options.Options{
+			Cookie:  options.Cookie{Refresh: time.Hour},
+			Session: options.SessionOptions{DegradedHeader: "X-Auth Degraded"},
+		}, []string{"session_degraded_header (\"X-Auth Degraded\") must be a valid header name"}),
+		Entry("with a header that is also injected", &options.Options{
+			Cookie:               options.Cookie{Refresh: time.Hour},
+			Session:              options.SessionOptions{DegradedHeader: "x-auth-degraded"},
+			InjectRequestHeaders: []options.Header{{Name: "X-Auth-Degraded"}},
+		}, []string{"session_degraded_header (\"x-auth-degraded\") must not also be an injected request header"}),
+	)
+
+	DescribeTable("validateSessionBinding",
+		func(session options.SessionOptions, errStrings []string) {
+			Expect(validateSessionBinding(&options.Options{Session: session})).To(ConsistOf(errStrings))
+		},
+		Entry("with client IP binding disabled", options.SessionOptions{
+			BindUserAgent: true,
+		}, []string{}),
+		Entry("with the default prefixes", options.SessionOptions{
+			BindClientIP:   true,
+			BindIPv4Prefix: options.DefaultSessionBindIPv4Prefix,
+			BindIPv6Prefix: options.DefaultSessionBindIPv6Prefix,
+		}, []string{}),
+		Entry("with invalid prefixes", options.SessionOptions{
+			BindClientIP:   true,
+			BindIPv4Prefix: 33,
+			BindIPv6Prefix: 0,
+		}, []string{
+			"session_bind_ipv4_prefix (33) must be between 1 and 32",
+			"session_bind_ipv6_prefix (0) must be between 1 and 128",
+		}),
+	)
+
+	DescribeTable("validateSessionUnavailablePolicy",
+		func(session options.SessionOptions, errStrings []string) {
+			Expect(validateSessionUnavailablePolicy(&options.Options{Session: session})).To(ConsistOf(errStrings))
+		},
+		Entry("with the default policy", options.SessionOptions{
+			Type:              options.CookieSessionStoreType,
+			UnavailablePolicy: options.FailClosedUnavailablePolicy,
+		}, []string{}),
+		Entry("with the cookie fallback and redis sessions", options.SessionOptions{
+			Type:              options.RedisSessionStoreType,
+			UnavailablePolicy: options.CookieFallbackUnavailablePolicy,
+		}, []string{}),
+		Entry("with the cookie fallback and cookie sessions", options.SessionOptions{
+			Type:              options.CookieSessionStoreType,
+			UnavailablePolicy: options.CookieFallbackUnavailablePolicy,
+		}, []string{"session_store_unavailable_policy \"cookie-fallback\" requires a persistent session store and is not supported by the cookie session store"}),
+		Entry("with an invalid policy", options.SessionOptions{
+			Type:              options.RedisSessionStoreType,
+			UnavailablePolicy: "fail-open",
+		}, []string{"session_store_unavailable_policy (\"fail-open\") must be one of ['fail-closed', 'cookie-fallback']"}),
+	)
 })
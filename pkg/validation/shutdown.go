@@ -0,0 +1,24 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateShutdown validates the options for gracefully shutting down the
+// servers
+func validateShutdown(shutdown options.Shutdown) []string {
+	msgs := []string{}
+	if shutdown.Timeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("shutdown_timeout (%s) must not be negative", shutdown.Timeout))
+	}
+
+	switch shutdown.WebSocketPolicy {
+	case options.ShutdownWebSocketDrain, options.ShutdownWebSocketClose:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid shutdown_websocket_policy %q: must be one of %q or %q",
+			shutdown.WebSocketPolicy, options.ShutdownWebSocketDrain, options.ShutdownWebSocketClose))
+	}
+	return msgs
+}
@@ -0,0 +1,54 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shutdown", func() {
+	type validateShutdownTableInput struct {
+		shutdown   options.Shutdown
+		errStrings []string
+	}
+
+	DescribeTable("validateShutdown",
+		func(o *validateShutdownTableInput) {
+			Expect(validateShutdown(o.shutdown)).To(ConsistOf(o.errStrings))
+		},
+		Entry("with the drain policy", &validateShutdownTableInput{
+			shutdown: options.Shutdown{
+				Timeout:         30 * time.Second,
+				WebSocketPolicy: options.ShutdownWebSocketDrain,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with the close policy and no timeout", &validateShutdownTableInput{
+			shutdown: options.Shutdown{
+				WebSocketPolicy: options.ShutdownWebSocketClose,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a negative timeout", &validateShutdownTableInput{
+			shutdown: options.Shutdown{
+				Timeout:         -time.Second,
+				WebSocketPolicy: options.ShutdownWebSocketDrain,
+			},
+			errStrings: []string{
+				"shutdown_timeout (-1s) must not be negative",
+			},
+		}),
+		Entry("with an unknown policy", &validateShutdownTableInput{
+			shutdown: options.Shutdown{
+				Timeout:         30 * time.Second,
+				WebSocketPolicy: "ignore",
+			},
+			errStrings: []string{
+				"invalid shutdown_websocket_policy \"ignore\": must be one of \"drain\" or \"close\"",
+			},
+		}),
+	)
+})
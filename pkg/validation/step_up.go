@@ -0,0 +1,43 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateStepUp validates the amr:method=path routes passed with
+// options.StepUp.Routes
+func validateStepUp(stepUp options.StepUp) []string {
+	msgs := []string{}
+	for i, route := range stepUp.Routes {
+		msgs = append(msgs, prefixValues(fmt.Sprintf("step_up_routes[%d]: ", i), validateStepUpRoute(route)...)...)
+	}
+	return msgs
+}
+
+func validateStepUpRoute(route string) []string {
+	parts := strings.SplitN(route, ":", 2)
+	if len(parts) != 2 {
+		return []string{fmt.Sprintf("route %q must be in the format amr:method=path_regex or amr:path_regex", route)}
+	}
+
+	msgs := []string{}
+	for _, amr := range strings.Split(parts[0], "|") {
+		if amr == "" {
+			msgs = append(msgs, fmt.Sprintf("route %q must not contain an empty authentication method", route))
+			break
+		}
+	}
+
+	regex := parts[1]
+	if methodPath := strings.SplitN(parts[1], "=", 2); len(methodPath) == 2 {
+		regex = methodPath[1]
+	}
+	if _, err := regexp.Compile(regex); err != nil {
+		msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", regex, err))
+	}
+	return msgs
+}
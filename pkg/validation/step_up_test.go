@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StepUp", func() {
+	type validateStepUpTableInput struct {
+		routes     []string
+		errStrings []string
+	}
+
+	DescribeTable("validateStepUp",
+		func(o *validateStepUpTableInput) {
+			Expect(validateStepUp(options.StepUp{Routes: o.routes})).To(ConsistOf(o.errStrings))
+		},
+		Entry("with no routes", &validateStepUpTableInput{
+			errStrings: []string{},
+		}),
+		Entry("with valid routes", &validateStepUpTableInput{
+			routes: []string{
+				"mfa:^/admin",
+				"mfa|otp:POST=^/payments/",
+				"hwk:^/keys/[0-9]+:rotate$",
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a route missing the authentication methods", &validateStepUpTableInput{
+			routes: []string{"^/admin"},
+			errStrings: []string{
+				"step_up_routes[0]: route \"^/admin\" must be in the format amr:method=path_regex or amr:path_regex",
+			},
+		}),
+		Entry("with an empty authentication method", &validateStepUpTableInput{
+			routes: []string{"mfa|:^/admin", ":GET=^/keys"},
+			errStrings: []string{
+				"step_up_routes[0]: route \"mfa|:^/admin\" must not contain an empty authentication method",
+				"step_up_routes[1]: route \":GET=^/keys\" must not contain an empty authentication method",
+			},
+		}),
+		Entry("with an invalid regex", &validateStepUpTableInput{
+			routes: []string{"mfa:^/admin", "mfa:POST=^/(payments"},
+			errStrings: []string{
+				"step_up_routes[1]: error compiling regex /^/(payments/: error parsing regexp: missing closing ): `^/(payments`",
+			},
+		}),
+	)
+})
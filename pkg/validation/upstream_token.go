@@ -0,0 +1,42 @@
+package validation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/upstreamtoken"
+)
+
+// validateUpstreamToken validates the options for minting upstream tokens.
+// The lifetime of the tokens must be well below the lifetime of the sessions
+// they are minted from, so it's capped at upstreamtoken.MaxLifetime.
+func validateUpstreamToken(upstreamToken options.UpstreamToken, cookieExpire time.Duration) []string {
+	msgs := []string{}
+	if upstreamToken.KeyFile == "" {
+		return msgs
+	}
+
+	if _, err := upstreamtoken.LoadKey(upstreamToken.KeyFile); err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	if upstreamToken.Audience == "" {
+		msgs = append(msgs, "upstream_token_audience is required when upstream_token_key_file is set")
+	}
+	if upstreamToken.Lifetime <= 0 || upstreamToken.Lifetime > upstreamtoken.MaxLifetime {
+		msgs = append(msgs, fmt.Sprintf("upstream_token_lifetime (%s) must be greater than 0 and at most %s", upstreamToken.Lifetime, upstreamtoken.MaxLifetime))
+	}
+	if cookieExpire > 0 && upstreamToken.Lifetime >= cookieExpire {
+		msgs = append(msgs, fmt.Sprintf("upstream_token_lifetime (%s) must be less than cookie_expire (%s)", upstreamToken.Lifetime, cookieExpire))
+	}
+	if upstreamToken.RateLimit <= 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream_token_rate_limit (%d) must be greater than 0", upstreamToken.RateLimit))
+	}
+	for _, claim := range upstreamToken.Claims {
+		if _, err := upstreamtoken.ParseClaim(claim); err != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream_token_claims: %v", err))
+		}
+	}
+
+	return msgs
+}
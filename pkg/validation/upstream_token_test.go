@@ -0,0 +1,127 @@
+package validation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpstreamToken", func() {
+	type validateUpstreamTokenTableInput struct {
+		upstreamToken options.UpstreamToken
+		cookieExpire  time.Duration
+		errStrings    []string
+	}
+
+	// validKeyFile is replaced with the path to a valid key in each entry
+	const validKeyFile = "valid-key-file"
+	var keyFile string
+
+	BeforeEach(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+
+		file, err := ioutil.TempFile("", "upstream-token-key-*.pem")
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+		Expect(pem.Encode(file, &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})).To(Succeed())
+		keyFile = file.Name()
+	})
+
+	AfterEach(func() {
+		Expect(os.Remove(keyFile)).To(Succeed())
+	})
+
+	validUpstreamToken := func() options.UpstreamToken {
+		return options.UpstreamToken{
+			KeyFile:   validKeyFile,
+			Audience:  "https://api.example.com",
+			Lifetime:  5 * time.Minute,
+			Claims:    []string{"email", "groups"},
+			RateLimit: 10,
+		}
+	}
+
+	DescribeTable("validateUpstreamToken",
+		func(o *validateUpstreamTokenTableInput) {
+			if o.upstreamToken.KeyFile == validKeyFile {
+				o.upstreamToken.KeyFile = keyFile
+			}
+			Expect(validateUpstreamToken(o.upstreamToken, o.cookieExpire)).To(ConsistOf(o.errStrings))
+		},
+		Entry("when disabled", &validateUpstreamTokenTableInput{
+			upstreamToken: options.UpstreamToken{
+				Lifetime: -1,
+			},
+			cookieExpire: time.Hour,
+			errStrings:   []string{},
+		}),
+		Entry("with valid options", &validateUpstreamTokenTableInput{
+			upstreamToken: validUpstreamToken(),
+			cookieExpire:  time.Hour,
+			errStrings:    []string{},
+		}),
+		Entry("with a missing key file", &validateUpstreamTokenTableInput{
+			upstreamToken: func() options.UpstreamToken {
+				u := validUpstreamToken()
+				u.KeyFile = "/does/not/exist.pem"
+				return u
+			}(),
+			cookieExpire: time.Hour,
+			errStrings:   []string{"could not read upstream token key file /does/not/exist.pem: open /does/not/exist.pem: no such file or directory"},
+		}),
+		Entry("without an audience", &validateUpstreamTokenTableInput{
+			upstreamToken: func() options.UpstreamToken {
+				u := validUpstreamToken()
+				u.Audience = ""
+				return u
+			}(),
+			cookieExpire: time.Hour,
+			errStrings:   []string{"upstream_token_audience is required when upstream_token_key_file is set"},
+		}),
+		Entry("with a lifetime over the maximum", &validateUpstreamTokenTableInput{
+			upstreamToken: func() options.UpstreamToken {
+				u := validUpstreamToken()
+				u.Lifetime = 2 * time.Hour
+				return u
+			}(),
+			cookieExpire: 168 * time.Hour,
+			errStrings:   []string{"upstream_token_lifetime (2h0m0s) must be greater than 0 and at most 1h0m0s"},
+		}),
+		Entry("with a lifetime as long as the session", &validateUpstreamTokenTableInput{
+			upstreamToken: validUpstreamToken(),
+			cookieExpire:  5 * time.Minute,
+			errStrings:    []string{"upstream_token_lifetime (5m0s) must be less than cookie_expire (5m0s)"},
+		}),
+		Entry("without a rate limit", &validateUpstreamTokenTableInput{
+			upstreamToken: func() options.UpstreamToken {
+				u := validUpstreamToken()
+				u.RateLimit = 0
+				return u
+			}(),
+			cookieExpire: time.Hour,
+			errStrings:   []string{"upstream_token_rate_limit (0) must be greater than 0"},
+		}),
+		Entry("with an invalid claim", &validateUpstreamTokenTableInput{
+			upstreamToken: func() options.UpstreamToken {
+				u := validUpstreamToken()
+				u.Claims = []string{"token=access_token"}
+				return u
+			}(),
+			cookieExpire: time.Hour,
+			errStrings:   []string{"upstream_token_claims: invalid claim \"token=access_token\": the session's \"access_token\" must not be exposed in upstream tokens"},
+		}),
+	)
+})
@@ -3,6 +3,8 @@ package validation
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 )
@@ -10,18 +12,28 @@ import (
 func validateUpstreams(upstreams options.Upstreams) []string {
 	msgs := []string{}
 	ids := make(map[string]struct{})
-	paths := make(map[string]struct{})
+	paths := []string{}
+	groups := make(map[string]options.Upstreams)
 
 	for _, upstream := range upstreams {
-		msgs = append(msgs, validateUpstream(upstream, ids, paths)...)
+		msgs = append(msgs, validateUpstream(upstream, ids)...)
+
+		if _, ok := groups[upstream.Path]; !ok {
+			paths = append(paths, upstream.Path)
+		}
+		groups[upstream.Path] = append(groups[upstream.Path], upstream)
+	}
+
+	for _, path := range paths {
+		msgs = append(msgs, validateUpstreamGroup(path, groups[path])...)
 	}
 
 	return msgs
 }
 
 // validateUpstream validates that the upstream has valid options and that
-// the ids and paths are unique across all options
-func validateUpstream(upstream options.Upstream, ids, paths map[string]struct{}) []string {
+// the ids are unique across all options
+func validateUpstream(upstream options.Upstream, ids map[string]struct{}) []string {
 	msgs := []string{}
 
 	if upstream.ID == "" {
@@ -37,14 +49,258 @@ func validateUpstream(upstream options.Upstream, ids, paths map[string]struct{})
 	}
 	ids[upstream.ID] = struct{}{}
 
-	// Ensure upstream Paths are unique
-	if _, ok := paths[upstream.Path]; ok {
-		msgs = append(msgs, fmt.Sprintf("multiple upstreams found with path %q: upstream paths must be unique", upstream.Path))
+	if upstream.Timeout != nil && upstream.Timeout.Duration() < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has negative timeout (%s): timeouts must not be negative", upstream.ID, upstream.Timeout.Duration()))
+	}
+	if upstream.Weight != nil && *upstream.Weight < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has negative weight (%d): weights must not be negative", upstream.ID, *upstream.Weight))
 	}
-	paths[upstream.Path] = struct{}{}
 
 	msgs = append(msgs, validateUpstreamURI(upstream)...)
 	msgs = append(msgs, validateStaticUpstream(upstream)...)
+	msgs = append(msgs, validateFastCGIUpstream(upstream)...)
+	msgs = append(msgs, validateUpstreamSetCookieHandling(upstream)...)
+	msgs = append(msgs, validateUpstreamWebSocketSessionExpiry(upstream)...)
+	msgs = append(msgs, validateUpstreamRoutingClaim(upstream)...)
+	msgs = append(msgs, validateUpstreamRewriteRules(upstream)...)
+	msgs = append(msgs, validateUpstreamAuthRedirect(upstream)...)
+	msgs = append(msgs, validateUpstreamHealthCheck(upstream)...)
+	return msgs
+}
+
+// validateUpstreamGroup ensures that upstream Paths are unique, unless all of
+// the upstreams that share the Path form a valid weighted group
+func validateUpstreamGroup(path string, group options.Upstreams) []string {
+	for _, upstream := range group {
+		if upstream.RoutingClaim != "" {
+			return validateClaimUpstreamGroup(path, group)
+		}
+	}
+
+	if len(group) < 2 {
+		return []string{}
+	}
+
+	total := 0
+	for _, upstream := range group {
+		if upstream.Weight == nil {
+			return []string{fmt.Sprintf("multiple upstreams found with path %q: upstream paths must be unique, unless every upstream with the path sets a weight", path)}
+		}
+		total += *upstream.Weight
+	}
+
+	msgs := []string{}
+	if total <= 0 {
+		msgs = append(msgs, fmt.Sprintf("upstreams with path %q have a total weight of %d: the total weight must be greater than 0", path, total))
+	}
+	for _, upstream := range group[1:] {
+		if upstream.RewriteTarget != group[0].RewriteTarget {
+			msgs = append(msgs, fmt.Sprintf("upstreams with path %q have different rewriteTargets: upstreams sharing a path must have the same rewriteTarget", path))
+			break
+		}
+	}
+	for _, upstream := range group[1:] {
+		if upstream.Sticky != group[0].Sticky {
+			msgs = append(msgs, fmt.Sprintf("upstreams with path %q have different sticky settings: sticky must be set on all or none of the upstreams sharing a path", path))
+			break
+		}
+	}
+	return msgs
+}
+
+// validateClaimUpstreamGroup ensures that the upstreams that share the Path
+// route on the same claim, and that each claim value is routed to one upstream
+func validateClaimUpstreamGroup(path string, group options.Upstreams) []string {
+	msgs := []string{}
+	for _, upstream := range group[1:] {
+		if upstream.RoutingClaim != group[0].RoutingClaim {
+			msgs = append(msgs, fmt.Sprintf("upstreams with path %q have different routingClaims: routingClaim must be set to the same claim on all of the upstreams sharing a path", path))
+			break
+		}
+	}
+	for _, upstream := range group[1:] {
+		if upstream.RewriteTarget != group[0].RewriteTarget {
+			msgs = append(msgs, fmt.Sprintf("upstreams with path %q have different rewriteTargets: upstreams sharing a path must have the same rewriteTarget", path))
+			break
+		}
+	}
+
+	routes := make(map[string]string)
+	defaults := 0
+	for _, upstream := range group {
+		if len(upstream.RoutingClaimValues) == 0 {
+			defaults++
+		}
+		for _, value := range upstream.RoutingClaimValues {
+			if id, ok := routes[value]; ok {
+				msgs = append(msgs, fmt.Sprintf("upstreams %q and %q with path %q both route the claim value %q: each value may only be routed to one upstream", id, upstream.ID, path, value))
+				continue
+			}
+			routes[value] = upstream.ID
+		}
+	}
+	if defaults > 1 {
+		msgs = append(msgs, fmt.Sprintf("multiple upstreams with path %q have no routingClaimValues: only one upstream sharing a path may be the default", path))
+	}
+	return msgs
+}
+
+// validateUpstreamSetCookieHandling checks that the SetCookieHandling is one
+// of the known values
+func validateUpstreamSetCookieHandling(upstream options.Upstream) []string {
+	switch upstream.SetCookieHandling {
+	case "", options.SetCookiePassthrough, options.SetCookieRewrite, options.SetCookieStrip:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("upstream %q has invalid setCookieHandling %q: must be one of [%q, %q, %q]",
+			upstream.ID, upstream.SetCookieHandling, options.SetCookiePassthrough, options.SetCookieRewrite, options.SetCookieStrip)}
+	}
+}
+
+// validateUpstreamAuthRedirect checks that the AuthRedirect has hosts to
+// detect redirects to, that the Handling is one of the known values, and that
+// the Location is only set when redirects are rewritten
+func validateUpstreamAuthRedirect(upstream options.Upstream) []string {
+	authRedirect := upstream.AuthRedirect
+	if authRedirect == nil {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if len(authRedirect.Hosts) == 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has empty authRedirect hosts: hosts are required to detect redirects to an identity provider", upstream.ID))
+	}
+	for _, host := range authRedirect.Hosts {
+		if strings.TrimPrefix(host, ".") == "" {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has invalid authRedirect host %q: hosts must not be empty", upstream.ID, host))
+		}
+	}
+
+	switch authRedirect.Handling {
+	case "", options.AuthRedirectPassthrough, options.AuthRedirectRewrite, options.AuthRedirectError:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid authRedirect handling %q: must be one of [%q, %q, %q]",
+			upstream.ID, authRedirect.Handling, options.AuthRedirectPassthrough, options.AuthRedirectRewrite, options.AuthRedirectError))
+	}
+	if authRedirect.Location != "" && authRedirect.Handling != options.AuthRedirectRewrite {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has authRedirect location, but does not rewrite redirects, this will have no effect.", upstream.ID))
+	}
+	return msgs
+}
+
+// validateUpstreamHealthCheck checks that the health check path is a path, and
+// that the interval, timeout and thresholds are valid
+func validateUpstreamHealthCheck(upstream options.Upstream) []string {
+	healthCheck := upstream.HealthCheck
+	if healthCheck == nil {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if healthCheck.Path != "" && !strings.HasPrefix(healthCheck.Path, "/") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid healthCheck path %q: paths must start with /", upstream.ID, healthCheck.Path))
+	}
+	if healthCheck.Interval != nil && healthCheck.Interval.Duration() <= 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid healthCheck interval (%s): the interval must be greater than 0", upstream.ID, healthCheck.Interval.Duration()))
+	}
+	if healthCheck.Timeout != nil && healthCheck.Timeout.Duration() <= 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid healthCheck timeout (%s): the timeout must be greater than 0", upstream.ID, healthCheck.Timeout.Duration()))
+	}
+	if healthCheck.HealthyThreshold < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has negative healthCheck healthyThreshold (%d): thresholds must not be negative", upstream.ID, healthCheck.HealthyThreshold))
+	}
+	if healthCheck.UnhealthyThreshold < 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has negative healthCheck unhealthyThreshold (%d): thresholds must not be negative", upstream.ID, healthCheck.UnhealthyThreshold))
+	}
+	if strings.HasPrefix(upstream.URI, "file://") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has healthCheck, but is a file system upstream, this will have no effect.", upstream.ID))
+	}
+	return msgs
+}
+
+// validateUpstreamWebSocketSessionExpiry checks that the WebSocketSessionExpiry
+// is one of the known values, and that the WebSocketCloseCode is only set when
+// connections are closed and is a status code that may be sent in a close frame
+func validateUpstreamWebSocketSessionExpiry(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	switch upstream.WebSocketSessionExpiry {
+	case "", options.WebSocketSessionExpiryContinue, options.WebSocketSessionExpiryClose:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid webSocketSessionExpiry %q: must be one of [%q, %q]",
+			upstream.ID, upstream.WebSocketSessionExpiry, options.WebSocketSessionExpiryContinue, options.WebSocketSessionExpiryClose))
+	}
+
+	if upstream.WebSocketCloseCode == nil {
+		return msgs
+	}
+	if upstream.WebSocketSessionExpiry != options.WebSocketSessionExpiryClose {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has webSocketCloseCode, but webSocketSessionExpiry is not %q, this will have no effect.", upstream.ID, options.WebSocketSessionExpiryClose))
+	}
+
+	// Codes 1004-1006 and 1015 must not be sent, and 1016-2999 are reserved
+	code := *upstream.WebSocketCloseCode
+	if !(code >= 1000 && code <= 1003) && !(code >= 1007 && code <= 1014) && !(code >= 3000 && code <= 4999) {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid webSocketCloseCode (%d): must be 1000-1003, 1007-1014 or 3000-4999", upstream.ID, code))
+	}
+	return msgs
+}
+
+// validateUpstreamRoutingClaim checks that the RoutingClaim is a session
+// claim, and that the options that depend on it are set consistently
+func validateUpstreamRoutingClaim(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	switch upstream.RoutingClaim {
+	case "":
+		if len(upstream.RoutingClaimValues) > 0 {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has routingClaimValues, but no routingClaim, this will have no effect.", upstream.ID))
+		}
+		return msgs
+	case "user", "email", "groups", "preferred_username":
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid routingClaim %q: must be one of [%q, %q, %q, %q]",
+			upstream.ID, upstream.RoutingClaim, "user", "email", "groups", "preferred_username"))
+	}
+
+	if upstream.Weight != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has both a weight and a routingClaim: upstreams sharing a path are selected by either weight or claim", upstream.ID))
+	}
+	for _, value := range upstream.RoutingClaimValues {
+		if value == "" {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has an empty routingClaimValue: claim values must not be empty", upstream.ID))
+			break
+		}
+	}
+	return msgs
+}
+
+// validateUpstreamRewriteRules checks that each of the rewrite rules has a
+// known target, a header when the target is a header, and a valid match
+func validateUpstreamRewriteRules(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	for i, rule := range upstream.RewriteRules {
+		switch rule.Target {
+		case options.RewriteRequestPath:
+			if rule.Header != "" {
+				msgs = append(msgs, fmt.Sprintf("upstream %q has rewrite rule %d with a header, but its target is %q, this will have no effect.", upstream.ID, i, options.RewriteRequestPath))
+			}
+		case options.RewriteRequestHeader, options.RewriteResponseHeader:
+			if rule.Header == "" {
+				msgs = append(msgs, fmt.Sprintf("upstream %q has rewrite rule %d with empty header: a header is required for target %q", upstream.ID, i, rule.Target))
+			}
+		default:
+			msgs = append(msgs, fmt.Sprintf("upstream %q has rewrite rule %d with invalid target %q: must be one of [%q, %q, %q]",
+				upstream.ID, i, rule.Target, options.RewriteRequestPath, options.RewriteRequestHeader, options.RewriteResponseHeader))
+		}
+
+		if rule.Match == "" {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has rewrite rule %d with empty match: a match is required for all rewrite rules", upstream.ID, i))
+		} else if _, err := regexp.Compile(rule.Match); err != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has rewrite rule %d with invalid match %q: %v", upstream.ID, i, rule.Match, err))
+		}
+	}
 	return msgs
 }
 
@@ -72,12 +328,82 @@ func validateStaticUpstream(upstream options.Upstream) []string {
 	if upstream.FlushInterval != nil && upstream.FlushInterval.Duration() != options.DefaultUpstreamFlushInterval {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has flushInterval, but is a static upstream, this will have no effect.", upstream.ID))
 	}
+	if upstream.EventStreamFlushInterval != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has eventStreamFlushInterval, but is a static upstream, this will have no effect.", upstream.ID))
+	}
 	if upstream.PassHostHeader != nil {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has passHostHeader, but is a static upstream, this will have no effect.", upstream.ID))
 	}
 	if upstream.ProxyWebSockets != nil {
 		msgs = append(msgs, fmt.Sprintf("upstream %q has proxyWebSockets, but is a static upstream, this will have no effect.", upstream.ID))
 	}
+	if upstream.PassAccessToken {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has passAccessToken, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.SetCookieHandling != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has setCookieHandling, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.WebSocketSessionExpiry != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has webSocketSessionExpiry, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.Timeout != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has timeout, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if len(upstream.RewriteRules) > 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has rewriteRules, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.AuthRedirect != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has authRedirect, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.HealthCheck != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has healthCheck, but is a static upstream, this will have no effect.", upstream.ID))
+	}
+
+	return msgs
+}
+
+// validateFastCGIUpstream checks that FastCGI is set for, and only for,
+// upstreams with a FastCGI URI, that it determines the script to run, and that
+// any options that do not make sense for a FastCGI upstream are not set.
+func validateFastCGIUpstream(upstream options.Upstream) []string {
+	msgs := []string{}
+
+	isFastCGI := false
+	if u, err := url.Parse(upstream.URI); err == nil && !upstream.Static {
+		isFastCGI = u.Scheme == "fcgi" || u.Scheme == "fcgi+unix"
+	}
+
+	if !isFastCGI {
+		if upstream.FastCGI != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has fastCGI, but is not a FastCGI upstream, this will have no effect.", upstream.ID))
+		}
+		return msgs
+	}
+
+	if upstream.FastCGI == nil || (upstream.FastCGI.DocumentRoot == "" && upstream.FastCGI.ScriptFilename == "") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has empty fastCGI documentRoot and scriptFilename: one is required for FastCGI upstreams", upstream.ID))
+	}
+	if upstream.InsecureSkipTLSVerify {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has insecureSkipTLSVerify, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.ProxyWebSockets != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has proxyWebSockets, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.SetCookieHandling != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has setCookieHandling, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.WebSocketSessionExpiry != "" {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has webSocketSessionExpiry, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if len(upstream.RewriteRules) > 0 {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has rewriteRules, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.AuthRedirect != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has authRedirect, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
+	if upstream.HealthCheck != nil {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has healthCheck, but is a FastCGI upstream, this will have no effect.", upstream.ID))
+	}
 
 	return msgs
 }
@@ -102,7 +428,7 @@ func validateUpstreamURI(upstream options.Upstream) []string {
 	}
 
 	switch u.Scheme {
-	case "http", "https", "file":
+	case "http", "https", "file", "fcgi", "fcgi+unix":
 		// Valid, do nothing
 	default:
 		msgs = append(msgs, fmt.Sprintf("upstream %q has invalid scheme: %q", upstream.ID, u.Scheme))
@@ -16,8 +16,18 @@ var _ = Describe("Upstreams", func() {
 	}
 
 	flushInterval := options.Duration(5 * time.Second)
+	timeoutNegative := options.Duration(-time.Second)
+	healthCheckInterval := options.Duration(5 * time.Second)
+	healthCheckTimeout := options.Duration(time.Second)
+	durationZero := options.Duration(0)
 	staticCode200 := 200
 	truth := true
+	weight0 := 0
+	weight10 := 10
+	weight90 := 90
+	weightNegative := -1
+	webSocketCloseCode1006 := 1006
+	webSocketCloseCode4001 := 4001
 
 	validHTTPUpstream := options.Upstream{
 		ID:   "validHTTPUpstream",
@@ -43,11 +53,29 @@ var _ = Describe("Upstreams", func() {
 	staticWithURIMsg := "upstream \"foo\" has uri, but is a static upstream, this will have no effect."
 	staticWithInsecureMsg := "upstream \"foo\" has insecureSkipTLSVerify, but is a static upstream, this will have no effect."
 	staticWithFlushIntervalMsg := "upstream \"foo\" has flushInterval, but is a static upstream, this will have no effect."
+	staticWithEventStreamFlushIntervalMsg := "upstream \"foo\" has eventStreamFlushInterval, but is a static upstream, this will have no effect."
 	staticWithPassHostHeaderMsg := "upstream \"foo\" has passHostHeader, but is a static upstream, this will have no effect."
 	staticWithProxyWebSocketsMsg := "upstream \"foo\" has proxyWebSockets, but is a static upstream, this will have no effect."
+	staticWithPassAccessTokenMsg := "upstream \"foo\" has passAccessToken, but is a static upstream, this will have no effect."
 	multipleIDsMsg := "multiple upstreams found with id \"foo\": upstream ids must be unique"
-	multiplePathsMsg := "multiple upstreams found with path \"/foo\": upstream paths must be unique"
+	multiplePathsMsg := "multiple upstreams found with path \"/foo\": upstream paths must be unique, unless every upstream with the path sets a weight"
 	staticCodeMsg := "upstream \"foo\" has staticCode (200), but is not a static upstream, set 'static' for a static response"
+	invalidSetCookieHandlingMsg := "upstream \"foo\" has invalid setCookieHandling \"drop\": must be one of [\"passthrough\", \"rewrite\", \"strip\"]"
+	staticWithSetCookieHandlingMsg := "upstream \"foo\" has setCookieHandling, but is a static upstream, this will have no effect."
+	invalidWebSocketSessionExpiryMsg := "upstream \"foo\" has invalid webSocketSessionExpiry \"drop\": must be one of [\"continue\", \"close\"]"
+	webSocketCloseCodeWithoutCloseMsg := "upstream \"foo\" has webSocketCloseCode, but webSocketSessionExpiry is not \"close\", this will have no effect."
+	invalidWebSocketCloseCodeMsg := "upstream \"foo\" has invalid webSocketCloseCode (1006): must be 1000-1003, 1007-1014 or 3000-4999"
+	staticWithWebSocketSessionExpiryMsg := "upstream \"foo\" has webSocketSessionExpiry, but is a static upstream, this will have no effect."
+	staticWithTimeoutMsg := "upstream \"foo\" has timeout, but is a static upstream, this will have no effect."
+	negativeTimeoutMsg := "upstream \"foo\" has negative timeout (-1s): timeouts must not be negative"
+	staticWithRewriteRulesMsg := "upstream \"foo\" has rewriteRules, but is a static upstream, this will have no effect."
+	invalidRewriteTargetMsg := "upstream \"foo\" has rewrite rule 0 with invalid target \"body\": must be one of [\"requestPath\", \"requestHeader\", \"responseHeader\"]"
+	rewritePathWithHeaderMsg := "upstream \"foo\" has rewrite rule 0 with a header, but its target is \"requestPath\", this will have no effect."
+	rewriteEmptyHeaderMsg := "upstream \"foo\" has rewrite rule 1 with empty header: a header is required for target \"responseHeader\""
+	rewriteEmptyMatchMsg := "upstream \"foo\" has rewrite rule 0 with empty match: a match is required for all rewrite rules"
+	rewriteInvalidMatchMsg := "upstream \"foo\" has rewrite rule 1 with invalid match \"(foo\": error parsing regexp: missing closing ): `(foo`"
+	fastCGIWithoutScriptMsg := "upstream \"foo\" has empty fastCGI documentRoot and scriptFilename: one is required for FastCGI upstreams"
+	fastCGIWithoutFastCGIURIMsg := "upstream \"foo\" has fastCGI, but is not a FastCGI upstream, this will have no effect."
 
 	DescribeTable("validateUpstreams",
 		func(o *validateUpstreamTableInput) {
@@ -128,24 +156,49 @@ var _ = Describe("Upstreams", func() {
 		Entry("with a static upstream and invalid optons", &validateUpstreamTableInput{
 			upstreams: options.Upstreams{
 				{
-					ID:                    "foo",
-					Path:                  "/foo",
-					URI:                   "ftp://foo",
-					Static:                true,
-					FlushInterval:         &flushInterval,
-					PassHostHeader:        &truth,
-					ProxyWebSockets:       &truth,
-					InsecureSkipTLSVerify: true,
+					ID:                       "foo",
+					Path:                     "/foo",
+					URI:                      "ftp://foo",
+					Static:                   true,
+					FlushInterval:            &flushInterval,
+					EventStreamFlushInterval: &flushInterval,
+					PassHostHeader:           &truth,
+					ProxyWebSockets:          &truth,
+					PassAccessToken:          true,
+					InsecureSkipTLSVerify:    true,
+					SetCookieHandling:        options.SetCookieStrip,
+					WebSocketSessionExpiry:   options.WebSocketSessionExpiryClose,
+					Timeout:                  &flushInterval,
+					RewriteRules: []options.RewriteRule{
+						{Target: options.RewriteResponseHeader, Header: "Location", Match: "^http://foo"},
+					},
 				},
 			},
 			errStrings: []string{
 				staticWithURIMsg,
 				staticWithInsecureMsg,
 				staticWithFlushIntervalMsg,
+				staticWithEventStreamFlushIntervalMsg,
 				staticWithPassHostHeaderMsg,
 				staticWithProxyWebSocketsMsg,
+				staticWithPassAccessTokenMsg,
+				staticWithSetCookieHandlingMsg,
+				staticWithWebSocketSessionExpiryMsg,
+				staticWithTimeoutMsg,
+				staticWithRewriteRulesMsg,
 			},
 		}),
+		Entry("with a negative timeout", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:      "foo",
+					Path:    "/foo",
+					URI:     "http://localhost:8080",
+					Timeout: &timeoutNegative,
+				},
+			},
+			errStrings: []string{negativeTimeoutMsg},
+		}),
 		Entry("with duplicate IDs", &validateUpstreamTableInput{
 			upstreams: options.Upstreams{
 				{
@@ -186,5 +239,491 @@ var _ = Describe("Upstreams", func() {
 			},
 			errStrings: []string{emptyURIMsg, staticCodeMsg},
 		}),
+		Entry("with a valid set cookie handling", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                "foo",
+					Path:              "/foo",
+					URI:               "http://localhost:8080",
+					SetCookieHandling: options.SetCookieRewrite,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid set cookie handling", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                "foo",
+					Path:              "/foo",
+					URI:               "http://localhost:8080",
+					SetCookieHandling: "drop",
+				},
+			},
+			errStrings: []string{invalidSetCookieHandlingMsg},
+		}),
+		Entry("with valid rewrite rules", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					RewriteRules: []options.RewriteRule{
+						{Target: options.RewriteRequestPath, Match: "^/foo/(.*)$", Replacement: "/$1"},
+						{Target: options.RewriteRequestHeader, Header: "Referer", Match: "^https://example.com", Replacement: "http://localhost:8080"},
+						{Target: options.RewriteResponseHeader, Header: "Location", Match: "^http://localhost:8080", Replacement: "https://example.com"},
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid rewrite rules", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					RewriteRules: []options.RewriteRule{
+						{Target: "body", Match: "foo"},
+						{Target: options.RewriteResponseHeader, Match: "(foo"},
+					},
+				},
+			},
+			errStrings: []string{invalidRewriteTargetMsg, rewriteEmptyHeaderMsg, rewriteInvalidMatchMsg},
+		}),
+		Entry("with a path rewrite rule with a header and no match", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					RewriteRules: []options.RewriteRule{
+						{Target: options.RewriteRequestPath, Header: "Location"},
+					},
+				},
+			},
+			errStrings: []string{rewritePathWithHeaderMsg, rewriteEmptyMatchMsg},
+		}),
+		Entry("with websocket connections closed on session expiry", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                     "foo",
+					Path:                   "/foo",
+					URI:                    "http://localhost:8080",
+					WebSocketSessionExpiry: options.WebSocketSessionExpiryClose,
+					WebSocketCloseCode:     &webSocketCloseCode4001,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid websocket session expiry", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                     "foo",
+					Path:                   "/foo",
+					URI:                    "http://localhost:8080",
+					WebSocketSessionExpiry: "drop",
+				},
+			},
+			errStrings: []string{invalidWebSocketSessionExpiryMsg},
+		}),
+		Entry("with a websocket close code when connections are not closed", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "foo",
+					Path:               "/foo",
+					URI:                "http://localhost:8080",
+					WebSocketCloseCode: &webSocketCloseCode4001,
+				},
+			},
+			errStrings: []string{webSocketCloseCodeWithoutCloseMsg},
+		}),
+		Entry("with a reserved websocket close code", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                     "foo",
+					Path:                   "/foo",
+					URI:                    "http://localhost:8080",
+					WebSocketSessionExpiry: options.WebSocketSessionExpiryClose,
+					WebSocketCloseCode:     &webSocketCloseCode1006,
+				},
+			},
+			errStrings: []string{invalidWebSocketCloseCodeMsg},
+		}),
+		Entry("with a weighted group", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:     "stable",
+					Path:   "/foo",
+					URI:    "http://stable",
+					Weight: &weight90,
+					Sticky: true,
+				},
+				{
+					ID:     "canary",
+					Path:   "/foo",
+					URI:    "http://canary",
+					Weight: &weight10,
+					Sticky: true,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a weighted group with a missing weight", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:     "stable",
+					Path:   "/foo",
+					URI:    "http://stable",
+					Weight: &weight90,
+				},
+				{
+					ID:   "canary",
+					Path: "/foo",
+					URI:  "http://canary",
+				},
+			},
+			errStrings: []string{multiplePathsMsg},
+		}),
+		Entry("with a weighted group with invalid weights", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:     "stable",
+					Path:   "/foo",
+					URI:    "http://stable",
+					Weight: &weightNegative,
+				},
+				{
+					ID:     "canary",
+					Path:   "/foo",
+					URI:    "http://canary",
+					Weight: &weight0,
+				},
+			},
+			errStrings: []string{
+				"upstream \"stable\" has negative weight (-1): weights must not be negative",
+				"upstreams with path \"/foo\" have a total weight of -1: the total weight must be greater than 0",
+			},
+		}),
+		Entry("with a weighted group with mismatched options", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:            "stable",
+					Path:          "^/foo/(.*)$",
+					RewriteTarget: "/$1",
+					URI:           "http://stable",
+					Weight:        &weight90,
+					Sticky:        true,
+				},
+				{
+					ID:     "canary",
+					Path:   "^/foo/(.*)$",
+					URI:    "http://canary",
+					Weight: &weight10,
+				},
+			},
+			errStrings: []string{
+				"upstreams with path \"^/foo/(.*)$\" have different rewriteTargets: upstreams sharing a path must have the same rewriteTarget",
+				"upstreams with path \"^/foo/(.*)$\" have different sticky settings: sticky must be set on all or none of the upstreams sharing a path",
+			},
+		}),
+		Entry("with a claim routed group", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "acme",
+					Path:               "/foo",
+					URI:                "http://acme",
+					RoutingClaim:       "groups",
+					RoutingClaimValues: []string{"acme", "acme-admins"},
+				},
+				{
+					ID:                 "globex",
+					Path:               "/foo",
+					URI:                "http://globex",
+					RoutingClaim:       "groups",
+					RoutingClaimValues: []string{"globex"},
+				},
+				{
+					ID:           "default",
+					Path:         "/foo",
+					URI:          "http://default",
+					RoutingClaim: "groups",
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a single claim routed upstream", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "acme",
+					Path:               "/foo",
+					URI:                "http://acme",
+					RoutingClaim:       "email",
+					RoutingClaimValues: []string{"user@acme.com"},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with routingClaimValues but no routingClaim", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "acme",
+					Path:               "/foo",
+					URI:                "http://acme",
+					RoutingClaimValues: []string{"acme"},
+				},
+			},
+			errStrings: []string{"upstream \"acme\" has routingClaimValues, but no routingClaim, this will have no effect."},
+		}),
+		Entry("with an invalid routingClaim", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "acme",
+					Path:               "/foo",
+					URI:                "http://acme",
+					RoutingClaim:       "access_token",
+					RoutingClaimValues: []string{"acme", ""},
+					Weight:             &weight10,
+				},
+			},
+			errStrings: []string{
+				"upstream \"acme\" has invalid routingClaim \"access_token\": must be one of [\"user\", \"email\", \"groups\", \"preferred_username\"]",
+				"upstream \"acme\" has both a weight and a routingClaim: upstreams sharing a path are selected by either weight or claim",
+				"upstream \"acme\" has an empty routingClaimValue: claim values must not be empty",
+			},
+		}),
+		Entry("with a claim routed group with mismatched options", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                 "acme",
+					Path:               "^/foo/(.*)$",
+					RewriteTarget:      "/$1",
+					URI:                "http://acme",
+					RoutingClaim:       "groups",
+					RoutingClaimValues: []string{"acme", "shared"},
+				},
+				{
+					ID:                 "globex",
+					Path:               "^/foo/(.*)$",
+					URI:                "http://globex",
+					RoutingClaim:       "email",
+					RoutingClaimValues: []string{"shared"},
+				},
+				{
+					ID:   "default",
+					Path: "^/foo/(.*)$",
+					URI:  "http://default",
+				},
+				{
+					ID:           "other-default",
+					Path:         "^/foo/(.*)$",
+					URI:          "http://other-default",
+					RoutingClaim: "groups",
+				},
+			},
+			errStrings: []string{
+				"upstreams with path \"^/foo/(.*)$\" have different routingClaims: routingClaim must be set to the same claim on all of the upstreams sharing a path",
+				"upstreams with path \"^/foo/(.*)$\" have different rewriteTargets: upstreams sharing a path must have the same rewriteTarget",
+				"upstreams \"acme\" and \"globex\" with path \"^/foo/(.*)$\" both route the claim value \"shared\": each value may only be routed to one upstream",
+				"multiple upstreams with path \"^/foo/(.*)$\" have no routingClaimValues: only one upstream sharing a path may be the default",
+			},
+		}),
+		Entry("with valid FastCGI upstreams", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:      "tcp",
+					Path:    "/tcp",
+					URI:     "fcgi://localhost:9000",
+					FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+				},
+				{
+					ID:      "unix",
+					Path:    "/unix",
+					URI:     "fcgi+unix:///run/php/php-fpm.sock",
+					FastCGI: &options.FastCGIOptions{ScriptFilename: "/var/www/index.php"},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a FastCGI upstream without a script", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:      "foo",
+					Path:    "/foo",
+					URI:     "fcgi://localhost:9000",
+					FastCGI: &options.FastCGIOptions{Index: "main.php"},
+				},
+			},
+			errStrings: []string{fastCGIWithoutScriptMsg},
+		}),
+		Entry("with a FastCGI upstream and invalid options", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:                     "foo",
+					Path:                   "/foo",
+					URI:                    "fcgi://localhost:9000",
+					InsecureSkipTLSVerify:  true,
+					ProxyWebSockets:        &truth,
+					SetCookieHandling:      options.SetCookieStrip,
+					WebSocketSessionExpiry: options.WebSocketSessionExpiryClose,
+					RewriteRules: []options.RewriteRule{
+						{Target: options.RewriteResponseHeader, Header: "Location", Match: "^http://foo"},
+					},
+				},
+			},
+			errStrings: []string{
+				fastCGIWithoutScriptMsg,
+				"upstream \"foo\" has insecureSkipTLSVerify, but is a FastCGI upstream, this will have no effect.",
+				"upstream \"foo\" has proxyWebSockets, but is a FastCGI upstream, this will have no effect.",
+				"upstream \"foo\" has setCookieHandling, but is a FastCGI upstream, this will have no effect.",
+				"upstream \"foo\" has webSocketSessionExpiry, but is a FastCGI upstream, this will have no effect.",
+				"upstream \"foo\" has rewriteRules, but is a FastCGI upstream, this will have no effect.",
+			},
+		}),
+		Entry("with fastCGI on an HTTP upstream", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:      "foo",
+					Path:    "/foo",
+					URI:     "http://localhost:8080",
+					FastCGI: &options.FastCGIOptions{DocumentRoot: "/var/www"},
+				},
+			},
+			errStrings: []string{fastCGIWithoutFastCGIURIMsg},
+		}),
+		Entry("with valid auth redirect handling", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					AuthRedirect: &options.AuthRedirectOptions{
+						Hosts:    []string{"idp.example.com", ".login.example.com"},
+						Handling: options.AuthRedirectRewrite,
+						Location: "/foo/",
+					},
+				},
+				{
+					ID:   "bar",
+					Path: "/bar",
+					URI:  "http://localhost:8081",
+					AuthRedirect: &options.AuthRedirectOptions{
+						Hosts:    []string{"idp.example.com"},
+						Handling: options.AuthRedirectError,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid auth redirect handling", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					AuthRedirect: &options.AuthRedirectOptions{
+						Hosts:    []string{"."},
+						Handling: "drop",
+						Location: "/foo/",
+					},
+				},
+			},
+			errStrings: []string{
+				"upstream \"foo\" has invalid authRedirect host \".\": hosts must not be empty",
+				"upstream \"foo\" has invalid authRedirect handling \"drop\": must be one of [\"passthrough\", \"rewrite\", \"error\"]",
+				"upstream \"foo\" has authRedirect location, but does not rewrite redirects, this will have no effect.",
+			},
+		}),
+		Entry("with auth redirect handling without hosts", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:           "foo",
+					Path:         "/foo",
+					URI:          "http://localhost:8080",
+					AuthRedirect: &options.AuthRedirectOptions{Handling: options.AuthRedirectError},
+				},
+			},
+			errStrings: []string{
+				"upstream \"foo\" has empty authRedirect hosts: hosts are required to detect redirects to an identity provider",
+			},
+		}),
+		Entry("with auth redirect handling on a static upstream", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:           "foo",
+					Path:         "/foo",
+					Static:       true,
+					AuthRedirect: &options.AuthRedirectOptions{Hosts: []string{"idp.example.com"}},
+				},
+			},
+			errStrings: []string{
+				"upstream \"foo\" has authRedirect, but is a static upstream, this will have no effect.",
+			},
+		}),
+		Entry("with valid health checks", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:          "foo",
+					Path:        "/foo",
+					URI:         "http://localhost:8080",
+					HealthCheck: &options.HealthCheckOptions{},
+				},
+				{
+					ID:   "bar",
+					Path: "/bar",
+					URI:  "http://localhost:8081",
+					HealthCheck: &options.HealthCheckOptions{
+						Path:               "/healthz",
+						Interval:           &healthCheckInterval,
+						Timeout:            &healthCheckTimeout,
+						HealthyThreshold:   1,
+						UnhealthyThreshold: 5,
+					},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with invalid health checks", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:   "foo",
+					Path: "/foo",
+					URI:  "http://localhost:8080",
+					HealthCheck: &options.HealthCheckOptions{
+						Path:               "healthz",
+						Interval:           &durationZero,
+						Timeout:            &timeoutNegative,
+						HealthyThreshold:   -1,
+						UnhealthyThreshold: -2,
+					},
+				},
+			},
+			errStrings: []string{
+				"upstream \"foo\" has invalid healthCheck path \"healthz\": paths must start with /",
+				"upstream \"foo\" has invalid healthCheck interval (0s): the interval must be greater than 0",
+				"upstream \"foo\" has invalid healthCheck timeout (-1s): the timeout must be greater than 0",
+				"upstream \"foo\" has negative healthCheck healthyThreshold (-1): thresholds must not be negative",
+				"upstream \"foo\" has negative healthCheck unhealthyThreshold (-2): thresholds must not be negative",
+			},
+		}),
+		Entry("with health checks on upstreams that are not HTTP(S)", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:          "foo",
+					Path:        "/foo",
+					Static:      true,
+					HealthCheck: &options.HealthCheckOptions{},
+				},
+				{
+					ID:          "bar",
+					Path:        "/bar",
+					URI:         "file:///var/www/",
+					HealthCheck: &options.HealthCheckOptions{},
+				},
+			},
+			errStrings: []string{
+				"upstream \"foo\" has healthCheck, but is a static upstream, this will have no effect.",
+				"upstream \"bar\" has healthCheck, but is a file system upstream, this will have no effect.",
+			},
+		}),
 	)
 })
@@ -19,6 +19,10 @@ import (
 type AzureProvider struct {
 	*ProviderData
 	Tenant string
+
+	// GraphGroupsURL is used to look up the groups of users whose tokens
+	// contain a groups overage claim rather than their groups
+	GraphGroupsURL *url.URL
 }
 
 var _ Provider = (*AzureProvider)(nil)
@@ -59,8 +63,21 @@ var (
 		Scheme: "https",
 		Host:   "graph.microsoft.com",
 	}
+
+	// Default Graph URL for looking up the groups of a user.
+	// Pre-parsed URL of https://graph.microsoft.com/v1.0/me/transitiveMemberOf/microsoft.graph.group.
+	azureDefaultGraphGroupsURL = &url.URL{
+		Scheme:   "https",
+		Host:     "graph.microsoft.com",
+		Path:     "/v1.0/me/transitiveMemberOf/microsoft.graph.group",
+		RawQuery: "$select=id&$top=999",
+	}
 )
 
+// azureMaxGroupPages limits the number of pages requested when looking up
+// the groups of a user from the Graph API
+const azureMaxGroupPages = 100
+
 // NewAzureProvider initiates a new AzureProvider
 func NewAzureProvider(p *ProviderData) *AzureProvider {
 	p.setProviderDefaults(providerDefaults{
@@ -80,8 +97,9 @@ func NewAzureProvider(p *ProviderData) *AzureProvider {
 	}
 
 	return &AzureProvider{
-		ProviderData: p,
-		Tenant:       "common",
+		ProviderData:   p,
+		Tenant:         "common",
+		GraphGroupsURL: azureDefaultGraphGroupsURL,
 	}
 }
 
@@ -171,6 +189,8 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (*
 		}
 	}
 
+	p.setSessionGroups(ctx, session)
+
 	return session, nil
 }
 
@@ -236,6 +256,101 @@ func (p *AzureProvider) verifyTokenAndExtractEmail(ctx context.Context, token st
 	return email, nil
 }
 
+// setSessionGroups sets the groups on the session from the groups claim of
+// the id_token.
+// When a user is a member of too many groups to fit in the token, Azure
+// replaces the groups claim with an overage claim. In that case the groups
+// are looked up from the Graph API instead.
+func (p *AzureProvider) setSessionGroups(ctx context.Context, s *sessions.SessionState) {
+	if s.IDToken == "" || p.Verifier == nil {
+		return
+	}
+	token, err := p.Verifier.Verify(ctx, s.IDToken)
+	if err != nil {
+		logger.Printf("unable to verify token: %v", err)
+		return
+	}
+	claims, err := p.getClaims(token)
+	if err != nil {
+		logger.Printf("unable to get claims from token: %v", err)
+		return
+	}
+
+	if !hasGroupsOverage(claims.raw, p.GroupsClaim) {
+		s.Groups = claims.Groups
+		return
+	}
+
+	groups, err := p.getGroupsFromGraphAPI(ctx, s.AccessToken)
+	if err != nil {
+		logger.Errorf("unable to get groups from the Graph API with groups overage: %v", err)
+		return
+	}
+	s.Groups = groups
+}
+
+// hasGroupsOverage determines whether the claims contain an overage
+// indicator rather than the groups of the user.
+// Tokens from the authorization code flow reference a claim source for the
+// groups in `_claim_names`, implicit flow tokens set `hasgroups`.
+func hasGroupsOverage(claims map[string]interface{}, groupsClaim string) bool {
+	if claimNames, ok := claims["_claim_names"].(map[string]interface{}); ok {
+		if _, ok := claimNames[groupsClaim]; ok {
+			return true
+		}
+	}
+	hasGroups, ok := claims["hasgroups"].(bool)
+	return ok && hasGroups
+}
+
+// getGroupsFromGraphAPI looks up the IDs of all groups the user is a
+// transitive member of, following each page of results.
+// The access token must be valid for the Graph API with permission to read
+// the user's group memberships (eg. GroupMember.Read.All).
+func (p *AzureProvider) getGroupsFromGraphAPI(ctx context.Context, accessToken string) ([]string, error) {
+	if accessToken == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	groups := []string{}
+	nextLink := p.GraphGroupsURL.String()
+	for page := 0; nextLink != ""; page++ {
+		if page == azureMaxGroupPages {
+			return nil, fmt.Errorf("more than %d pages of groups returned", azureMaxGroupPages)
+		}
+
+		var response struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		err := requests.New(nextLink).
+			WithContext(ctx).
+			WithHeaders(makeAzureHeader(accessToken)).
+			Do().
+			UnmarshalInto(&response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range response.Value {
+			groups = append(groups, group.ID)
+		}
+
+		nextLink = response.NextLink
+		if nextLink != "" {
+			// Only send the access token to the Graph API, and never downgrade
+			// to an unencrypted connection
+			next, err := url.Parse(nextLink)
+			if err != nil || next.Scheme != p.GraphGroupsURL.Scheme || next.Host != p.GraphGroupsURL.Host {
+				return nil, fmt.Errorf("unexpected next page of groups: %q", nextLink)
+			}
+		}
+	}
+	return groups, nil
+}
+
 // RefreshSession uses the RefreshToken to fetch new Access and ID Tokens
 func (p *AzureProvider) RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error) {
 	if s == nil || s.RefreshToken == "" {
@@ -244,7 +359,7 @@ func (p *AzureProvider) RefreshSession(ctx context.Context, s *sessions.SessionS
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -269,13 +384,16 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
+		Do()
+	if err := refreshTokenResultError(result); err != nil {
+		return err
+	}
+	err = result.UnmarshalInto(&jsonResponse)
 	if err != nil {
 		return err
 	}
@@ -308,6 +426,8 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 		}
 	}
 
+	p.setSessionGroups(ctx, s)
+
 	return nil
 }
 
@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -14,6 +16,7 @@ import (
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 
 	. "github.com/onsi/gomega"
@@ -374,3 +377,133 @@ func TestAzureProviderRefresh(t *testing.T) {
 	assert.Equal(t, email, session.Email)
 	assert.Equal(t, timestamp, session.ExpiresOn.UTC())
 }
+
+func newSignedTestAzureToken(claims jwt.MapClaims) (string, error) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+}
+
+func TestAzureProviderGroups(t *testing.T) {
+	overageClaims := jwt.MapClaims{
+		"email":          "foo@example.com",
+		"_claim_names":   map[string]interface{}{"groups": "src1"},
+		"_claim_sources": map[string]interface{}{"src1": map[string]interface{}{"endpoint": "https://graph.windows.net/tenant/users/user/getMemberObjects"}},
+	}
+
+	testCases := []struct {
+		name           string
+		claims         jwt.MapClaims
+		graphResponses []string
+		graphStatus    int
+		expectedGroups []string
+	}{
+		{
+			name: "with groups in the id_token",
+			claims: jwt.MapClaims{
+				"email":  "foo@example.com",
+				"groups": []string{"group1", "group2"},
+			},
+			expectedGroups: []string{"group1", "group2"},
+		},
+		{
+			name:   "with a groups overage claim",
+			claims: overageClaims,
+			graphResponses: []string{
+				`{"value": [{"id": "group1"}, {"id": "group2"}], "@odata.nextLink": "%s/v1.0/me/transitiveMemberOf/microsoft.graph.group?$skiptoken=page2"}`,
+				`{"value": [{"id": "group3"}]}`,
+			},
+			graphStatus:    200,
+			expectedGroups: []string{"group1", "group2", "group3"},
+		},
+		{
+			name: "with a hasgroups overage claim",
+			claims: jwt.MapClaims{
+				"email":     "foo@example.com",
+				"hasgroups": true,
+			},
+			graphResponses: []string{`{"value": [{"id": "group1"}]}`},
+			graphStatus:    200,
+			expectedGroups: []string{"group1"},
+		},
+		{
+			name:           "with a groups overage claim when the Graph API fails",
+			claims:         overageClaims,
+			graphResponses: []string{`{"error": {"code": "Authorization_RequestDenied"}}`},
+			graphStatus:    403,
+			expectedGroups: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			idToken, err := newSignedTestAzureToken(tc.claims)
+			g.Expect(err).ToNot(HaveOccurred())
+			payload, err := json.Marshal(azureOAuthPayload{
+				IDToken:     idToken,
+				AccessToken: "graph_access_token",
+				ExpiresOn:   time.Now().Add(time.Hour).Unix(),
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var graphRequests int
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					w.Write(payload)
+					return
+				}
+
+				g.Expect(r.URL.Path).To(Equal("/v1.0/me/transitiveMemberOf/microsoft.graph.group"))
+				g.Expect(r.Header.Get("Authorization")).To(Equal("Bearer graph_access_token"))
+				g.Expect(graphRequests).To(BeNumerically("<", len(tc.graphResponses)))
+				w.WriteHeader(tc.graphStatus)
+				w.Write([]byte(strings.Replace(tc.graphResponses[graphRequests], "%s", server.URL, 1)))
+				graphRequests++
+			}))
+			defer server.Close()
+
+			serverURL, _ := url.Parse(server.URL)
+			p := testAzureProvider(serverURL.Host)
+			p.GroupsClaim = "groups"
+			p.GraphGroupsURL = &url.URL{
+				Scheme: "http",
+				Host:   serverURL.Host,
+				Path:   "/v1.0/me/transitiveMemberOf/microsoft.graph.group",
+			}
+
+			s, err := p.Redeem(context.Background(), "https://localhost", "1234")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(s.Email).To(Equal("foo@example.com"))
+			g.Expect(s.Groups).To(Equal(tc.expectedGroups))
+			g.Expect(graphRequests).To(Equal(len(tc.graphResponses)))
+		})
+	}
+}
+
+func TestAzureProviderGroupsRejectsUnexpectedNextLink(t *testing.T) {
+	var nextLink string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"value": [{"id": "group1"}], "@odata.nextLink": "%s"}`, nextLink)))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	testCases := map[string]string{
+		"on another host":   "http://evil.example.com/groups",
+		"on another scheme": "https://" + serverURL.Host + "/groups",
+	}
+	for name, link := range testCases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			nextLink = link
+
+			p := testAzureProvider("")
+			p.GraphGroupsURL = serverURL
+
+			_, err := p.getGroupsFromGraphAPI(context.Background(), "graph_access_token")
+			g.Expect(err).To(MatchError(fmt.Sprintf("unexpected next page of groups: %q", link)))
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ClaimAssertionEquals asserts that the claim is the expected value
+	ClaimAssertionEquals = "equals"
+	// ClaimAssertionContains asserts that the claim is a string containing the
+	// expected value, or a list with the expected value as an item
+	ClaimAssertionContains = "contains"
+	// ClaimAssertionMatches asserts that the claim, or an item of a list
+	// claim, matches the expected value as a regular expression, which is
+	// anchored to match the whole claim
+	ClaimAssertionMatches = "matches"
+	// ClaimAssertionIn asserts that the claim is one of the expected values
+	ClaimAssertionIn = "in"
+)
+
+// ErrClaimAssertionFailed is returned when the claims of an ID token do not
+// pass the ClaimAssertions, when logging in or when refreshing a session.
+var ErrClaimAssertionFailed = errors.New("id_token rejected")
+
+// ClaimAssertion is a rule the claims of an ID token must pass for the token
+// to be accepted
+type ClaimAssertion struct {
+	claim    string
+	operator string
+	value    string
+	values   map[string]struct{}
+	pattern  *regexp.Regexp
+}
+
+// NewClaimAssertion creates a ClaimAssertion comparing the claim with the
+// value, or with the values for the `in` operator.
+func NewClaimAssertion(claim, operator, value string, values []string) (*ClaimAssertion, error) {
+	if strings.TrimSpace(claim) == "" {
+		return nil, errors.New("claim must not be empty")
+	}
+
+	a := &ClaimAssertion{
+		claim:    claim,
+		operator: operator,
+		value:    value,
+	}
+	switch operator {
+	case ClaimAssertionEquals, ClaimAssertionContains:
+	case ClaimAssertionMatches:
+		// The pattern is checked on its own before it is anchored, so that
+		// it can't close the group the anchors are applied to
+		if _, err := regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", value, err)
+		}
+		a.pattern = regexp.MustCompile("^(?:" + value + ")$")
+	case ClaimAssertionIn:
+		if len(values) == 0 {
+			return nil, fmt.Errorf("values are required by the %q operator", operator)
+		}
+		if value != "" {
+			return nil, fmt.Errorf("value is not used by the %q operator, use values instead", operator)
+		}
+		a.values = make(map[string]struct{}, len(values))
+		for _, v := range values {
+			a.values[v] = struct{}{}
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q, must be one of %q, %q, %q or %q",
+			operator, ClaimAssertionEquals, ClaimAssertionContains, ClaimAssertionMatches, ClaimAssertionIn)
+	}
+
+	if len(values) > 0 {
+		return nil, fmt.Errorf("values are only used by the %q operator, use value instead", ClaimAssertionIn)
+	}
+	return a, nil
+}
+
+// Check returns an error when the claims do not pass the assertion
+func (a *ClaimAssertion) Check(claims map[string]interface{}) error {
+	claim, ok := getClaimPath(claims, a.claim)
+	if !ok || claim == nil {
+		return fmt.Errorf("claim %q is missing", a.claim)
+	}
+	if !a.passes(claim) {
+		return fmt.Errorf("claim %q does not pass the %q assertion", a.claim, a.operator)
+	}
+	return nil
+}
+
+// passes compares the claim with the expected value
+func (a *ClaimAssertion) passes(claim interface{}) bool {
+	switch a.operator {
+	case ClaimAssertionEquals:
+		return claimString(claim) == a.value
+	case ClaimAssertionContains:
+		if items, ok := claim.([]interface{}); ok {
+			for _, item := range items {
+				if claimString(item) == a.value {
+					return true
+				}
+			}
+			return false
+		}
+		s, ok := claim.(string)
+		return ok && strings.Contains(s, a.value)
+	case ClaimAssertionMatches:
+		if items, ok := claim.([]interface{}); ok {
+			for _, item := range items {
+				if a.pattern.MatchString(claimString(item)) {
+					return true
+				}
+			}
+			return false
+		}
+		return a.pattern.MatchString(claimString(claim))
+	case ClaimAssertionIn:
+		_, ok := a.values[claimString(claim)]
+		return ok
+	}
+	return false
+}
+
+// claimString formats a claim for comparison. Claims that are not strings
+// are formatted as JSON, eg. `true` or `42`.
+func claimString(claim interface{}) string {
+	if s, ok := claim.(string); ok {
+		return s
+	}
+	value, err := json.Marshal(claim)
+	if err != nil {
+		return fmt.Sprint(claim)
+	}
+	return string(value)
+}
+
+// checkClaimAssertions returns an error for the first of the ClaimAssertions
+// the claims do not pass
+func (p *ProviderData) checkClaimAssertions(claims map[string]interface{}) error {
+	for _, assertion := range p.ClaimAssertions {
+		if err := assertion.Check(claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package providers
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestClaimAssertion_Check(t *testing.T) {
+	claims := map[string]interface{}{
+		"iss":            "https://login.example.com/tenant/v2.0",
+		"tid":            "tenant",
+		"email_verified": true,
+		"level":          float64(3),
+		"roles":          []interface{}{"admin", "user"},
+		"tenant": map[string]interface{}{
+			"region": "eu-west",
+		},
+	}
+
+	testCases := map[string]struct {
+		claim         string
+		operator      string
+		value         string
+		values        []string
+		expectedError error
+	}{
+		"passes an equal claim": {
+			claim:    "tid",
+			operator: ClaimAssertionEquals,
+			value:    "tenant",
+		},
+		"fails a claim that is not equal": {
+			claim:         "tid",
+			operator:      ClaimAssertionEquals,
+			value:         "other",
+			expectedError: errors.New("claim \"tid\" does not pass the \"equals\" assertion"),
+		},
+		"compares claims that are not strings as JSON": {
+			claim:    "email_verified",
+			operator: ClaimAssertionEquals,
+			value:    "true",
+		},
+		"compares numeric claims as JSON": {
+			claim:    "level",
+			operator: ClaimAssertionIn,
+			values:   []string{"2", "3"},
+		},
+		"passes a string claim containing the value": {
+			claim:    "iss",
+			operator: ClaimAssertionContains,
+			value:    "/tenant/",
+		},
+		"passes a list claim containing the value": {
+			claim:    "roles",
+			operator: ClaimAssertionContains,
+			value:    "admin",
+		},
+		"does not match substrings of list items": {
+			claim:         "roles",
+			operator:      ClaimAssertionContains,
+			value:         "adm",
+			expectedError: errors.New("claim \"roles\" does not pass the \"contains\" assertion"),
+		},
+		"passes a claim matching the pattern": {
+			claim:    "iss",
+			operator: ClaimAssertionMatches,
+			value:    `https://login\.example\.com/.*`,
+		},
+		"matches the whole claim": {
+			claim:         "iss",
+			operator:      ClaimAssertionMatches,
+			value:         `https://login\.example\.com/`,
+			expectedError: errors.New("claim \"iss\" does not pass the \"matches\" assertion"),
+		},
+		"matches alternatives against the whole claim": {
+			claim:    "tid",
+			operator: ClaimAssertionMatches,
+			value:    `other|tenant`,
+		},
+		"passes a list claim with an item matching the pattern": {
+			claim:    "roles",
+			operator: ClaimAssertionMatches,
+			value:    `us.*`,
+		},
+		"fails a claim not matching the pattern": {
+			claim:         "iss",
+			operator:      ClaimAssertionMatches,
+			value:         `https://evil\.example\.com/.*`,
+			expectedError: errors.New("claim \"iss\" does not pass the \"matches\" assertion"),
+		},
+		"passes a claim in the set": {
+			claim:    "tid",
+			operator: ClaimAssertionIn,
+			values:   []string{"other", "tenant"},
+		},
+		"fails a claim not in the set": {
+			claim:         "tid",
+			operator:      ClaimAssertionIn,
+			values:        []string{"other"},
+			expectedError: errors.New("claim \"tid\" does not pass the \"in\" assertion"),
+		},
+		"uses nested claim paths": {
+			claim:    "tenant.region",
+			operator: ClaimAssertionMatches,
+			value:    `eu-.*`,
+		},
+		"fails missing claims": {
+			claim:         "tenant.id",
+			operator:      ClaimAssertionEquals,
+			value:         "",
+			expectedError: errors.New("claim \"tenant.id\" is missing"),
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			assertion, err := NewClaimAssertion(tc.claim, tc.operator, tc.value, tc.values)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = assertion.Check(claims)
+			if tc.expectedError != nil {
+				g.Expect(err).To(Equal(tc.expectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestNewClaimAssertion(t *testing.T) {
+	testCases := map[string]struct {
+		claim         string
+		operator      string
+		value         string
+		values        []string
+		expectedError string
+	}{
+		"requires a claim": {
+			operator:      ClaimAssertionEquals,
+			value:         "tenant",
+			expectedError: "claim must not be empty",
+		},
+		"rejects unknown operators": {
+			claim:         "tid",
+			operator:      "startsWith",
+			value:         "tenant",
+			expectedError: "unknown operator \"startsWith\", must be one of \"equals\", \"contains\", \"matches\" or \"in\"",
+		},
+		"rejects invalid patterns": {
+			claim:         "iss",
+			operator:      ClaimAssertionMatches,
+			value:         "(",
+			expectedError: "invalid pattern \"(\": error parsing regexp: missing closing ): `(`",
+		},
+		"rejects patterns that would escape the anchors": {
+			claim:         "iss",
+			operator:      ClaimAssertionMatches,
+			value:         "a)|(b",
+			expectedError: "invalid pattern \"a)|(b\": error parsing regexp: unexpected ): `a)|(b`",
+		},
+		"requires values for the in operator": {
+			claim:         "tid",
+			operator:      ClaimAssertionIn,
+			value:         "tenant",
+			expectedError: "values are required by the \"in\" operator",
+		},
+		"rejects a value for the in operator": {
+			claim:         "tid",
+			operator:      ClaimAssertionIn,
+			value:         "tenant",
+			values:        []string{"tenant"},
+			expectedError: "value is not used by the \"in\" operator, use values instead",
+		},
+		"rejects values for the other operators": {
+			claim:         "tid",
+			operator:      ClaimAssertionEquals,
+			values:        []string{"tenant"},
+			expectedError: "values are only used by the \"in\" operator, use value instead",
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := NewClaimAssertion(tc.claim, tc.operator, tc.value, tc.values)
+			g.Expect(err).To(MatchError(tc.expectedError))
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// emailCandidate is an email found in the claims, along with whether the
+// claims state that it is verified, if they do
+type emailCandidate struct {
+	email    string
+	verified *bool
+}
+
+// selectEmail chooses the email of the session from the emails found in the
+// EmailClaim and the ExtraEmailClaims.
+// When PreferVerifiedEmail is set, only verified emails are chosen from, unless
+// none are verified. Emails in the PreferredEmailDomains are chosen first, in
+// the order of the domains. Otherwise the first email is chosen, in the order
+// of the claims and then of the emails within each claim, so that the same
+// email is chosen on every login.
+// It returns the chosen email and whether it is verified, if that is known.
+func (p *ProviderData) selectEmail(claims map[string]interface{}, emailVerified *bool) (string, *bool) {
+	candidates := []emailCandidate{}
+	for _, claim := range append([]string{p.EmailClaim}, p.ExtraEmailClaims...) {
+		value, ok := getClaimPath(claims, claim)
+		if !ok {
+			continue
+		}
+		// Only the standard email claim is described by the email_verified claim
+		var verified *bool
+		if claim == OIDCEmailClaim {
+			verified = emailVerified
+		}
+		candidates = append(candidates, emailCandidates(value, verified)...)
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	if p.PreferVerifiedEmail {
+		if verifiedCandidates := verifiedEmails(candidates); len(verifiedCandidates) > 0 {
+			candidates = verifiedCandidates
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return p.emailDomainRank(candidates[i].email) < p.emailDomainRank(candidates[j].email)
+	})
+	return candidates[0].email, candidates[0].verified
+}
+
+// verifiedEmails returns the candidates that the claims state are verified
+func verifiedEmails(candidates []emailCandidate) []emailCandidate {
+	verified := []emailCandidate{}
+	for _, candidate := range candidates {
+		if candidate.verified != nil && *candidate.verified {
+			verified = append(verified, candidate)
+		}
+	}
+	return verified
+}
+
+// emailDomainRank ranks an email by the first of the PreferredEmailDomains it
+// is in. Emails in none of them are ranked last.
+func (p *ProviderData) emailDomainRank(email string) int {
+	for i, domain := range p.PreferredEmailDomains {
+		domain = strings.TrimPrefix(domain, "@")
+		if strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(domain)) {
+			return i
+		}
+	}
+	return len(p.PreferredEmailDomains)
+}
+
+// emailCandidates extracts the emails from the value of a claim.
+// The value may be an email, a list of emails, or objects holding the email
+// in an `email` or `value` field and whether it is verified in a `verified`
+// or `email_verified` field.
+func emailCandidates(value interface{}, verified *bool) []emailCandidate {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []emailCandidate{{email: v, verified: verified}}
+	case []interface{}:
+		candidates := []emailCandidate{}
+		for _, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				continue
+			}
+			candidates = append(candidates, emailCandidates(item, nil)...)
+		}
+		return candidates
+	case map[string]interface{}:
+		email, ok := v["email"].(string)
+		if !ok {
+			email, _ = v["value"].(string)
+		}
+		if email == "" {
+			return nil
+		}
+		for _, field := range []string{"verified", "email_verified"} {
+			if b, ok := v[field].(bool); ok {
+				return []emailCandidate{{email: email, verified: &b}}
+			}
+		}
+		return []emailCandidate{{email: email}}
+	default:
+		return []emailCandidate{{email: fmt.Sprint(v), verified: verified}}
+	}
+}
+
+// getClaimPath gets the value of a claim by its name. When there is no claim
+// with the name, the name is treated as a dot separated path into nested
+// claims, eg. `emails.0` or `contact.email`.
+func getClaimPath(claims map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+	if !strings.Contains(path, ".") {
+		return nil, false
+	}
+
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
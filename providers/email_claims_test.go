@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_selectEmail(t *testing.T) {
+	verified := true
+	unverified := false
+
+	claims := map[string]interface{}{
+		"email":           "jane@personal.example",
+		"secondary_email": "jane.dobbs@corp.example",
+		"emails": []interface{}{
+			map[string]interface{}{"value": "jane@old.example", "verified": false},
+			map[string]interface{}{"value": "jane@corp.example", "verified": true},
+			"jane@other.example",
+		},
+		"contact": map[string]interface{}{
+			"email": "contact@corp.example",
+		},
+	}
+
+	testCases := map[string]struct {
+		claims                map[string]interface{}
+		emailVerified         *bool
+		extraEmailClaims      []string
+		preferredEmailDomains []string
+		preferVerifiedEmail   bool
+		expectedEmail         string
+		expectedVerified      *bool
+	}{
+		"uses the email claim by default": {
+			claims:           claims,
+			emailVerified:    &unverified,
+			extraEmailClaims: []string{"secondary_email"},
+			expectedEmail:    "jane@personal.example",
+			expectedVerified: &unverified,
+		},
+		"prefers emails in a preferred domain": {
+			claims:                claims,
+			emailVerified:         &verified,
+			extraEmailClaims:      []string{"secondary_email"},
+			preferredEmailDomains: []string{"corp.example"},
+			expectedEmail:         "jane.dobbs@corp.example",
+		},
+		"prefers domains in the order they are given": {
+			claims:                claims,
+			extraEmailClaims:      []string{"emails"},
+			preferredEmailDomains: []string{"other.example", "corp.example"},
+			expectedEmail:         "jane@other.example",
+		},
+		"matches preferred domains case insensitively": {
+			claims:                claims,
+			extraEmailClaims:      []string{"secondary_email"},
+			preferredEmailDomains: []string{"CORP.example"},
+			expectedEmail:         "jane.dobbs@corp.example",
+		},
+		"falls back to the first email when none are in a preferred domain": {
+			claims:                claims,
+			extraEmailClaims:      []string{"secondary_email"},
+			preferredEmailDomains: []string{"missing.example"},
+			expectedEmail:         "jane@personal.example",
+		},
+		"prefers verified emails": {
+			claims:              claims,
+			emailVerified:       &unverified,
+			extraEmailClaims:    []string{"emails"},
+			preferVerifiedEmail: true,
+			expectedEmail:       "jane@corp.example",
+			expectedVerified:    &verified,
+		},
+		"only chooses from verified emails when preferring verified emails": {
+			claims:                claims,
+			emailVerified:         &unverified,
+			extraEmailClaims:      []string{"emails"},
+			preferredEmailDomains: []string{"personal.example"},
+			preferVerifiedEmail:   true,
+			expectedEmail:         "jane@corp.example",
+			expectedVerified:      &verified,
+		},
+		"does not choose an unverified email in a preferred domain over a verified primary email": {
+			claims:                claims,
+			emailVerified:         &verified,
+			extraEmailClaims:      []string{"emails"},
+			preferredEmailDomains: []string{"old.example"},
+			preferVerifiedEmail:   true,
+			expectedEmail:         "jane@personal.example",
+			expectedVerified:      &verified,
+		},
+		"prefers a preferred domain among verified emails": {
+			claims:                claims,
+			emailVerified:         &verified,
+			extraEmailClaims:      []string{"emails"},
+			preferredEmailDomains: []string{"corp.example"},
+			preferVerifiedEmail:   true,
+			expectedEmail:         "jane@corp.example",
+			expectedVerified:      &verified,
+		},
+		"falls back to the first email when none are verified": {
+			claims:              claims,
+			extraEmailClaims:    []string{"secondary_email"},
+			preferVerifiedEmail: true,
+			expectedEmail:       "jane@personal.example",
+		},
+		"uses nested claim paths": {
+			claims:           claims,
+			extraEmailClaims: []string{"contact.email"},
+			expectedEmail:    "jane@personal.example",
+		},
+		"uses claim paths into lists": {
+			claims:           map[string]interface{}{"emails": claims["emails"]},
+			extraEmailClaims: []string{"emails.2"},
+			expectedEmail:    "jane@other.example",
+		},
+		"skips missing claims": {
+			claims:           map[string]interface{}{"contact": claims["contact"]},
+			extraEmailClaims: []string{"secondary_email", "contact.email", "emails"},
+			expectedEmail:    "contact@corp.example",
+		},
+		"uses the first email of a list": {
+			claims:           map[string]interface{}{"emails": claims["emails"]},
+			extraEmailClaims: []string{"emails"},
+			expectedEmail:    "jane@old.example",
+			expectedVerified: &unverified,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{
+				EmailClaim:            OIDCEmailClaim,
+				ExtraEmailClaims:      tc.extraEmailClaims,
+				PreferredEmailDomains: tc.preferredEmailDomains,
+				PreferVerifiedEmail:   tc.preferVerifiedEmail,
+			}
+			email, verified := p.selectEmail(tc.claims, tc.emailVerified)
+			g.Expect(email).To(Equal(tc.expectedEmail))
+			g.Expect(verified).To(Equal(tc.expectedVerified))
+		})
+	}
+}
@@ -131,7 +131,7 @@ func (p *GitLabProvider) RefreshSession(ctx context.Context, s *sessions.Session
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	logger.Printf("refreshed id token %s (expired on %s)\n", s, origExpiration)
@@ -157,13 +157,19 @@ func (p *GitLabProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 	}
 	token, err := c.TokenSource(ctx, t).Token()
 	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+		return fmt.Errorf("failed to get token: %w", refreshTokenError(err))
 	}
 	newSession, err := p.createSession(ctx, token)
 	if err != nil {
 		return fmt.Errorf("unable to update session: %v", err)
 	}
-	*s = *newSession
+	// Only the tokens are refreshed, so the rest of the session is kept,
+	// including when the user authenticated
+	s.AccessToken = newSession.AccessToken
+	s.IDToken = newSession.IDToken
+	s.RefreshToken = newSession.RefreshToken
+	s.CreatedAt = newSession.CreatedAt
+	s.ExpiresOn = newSession.ExpiresOn
 
 	return nil
 }
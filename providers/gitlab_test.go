@@ -2,10 +2,12 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/ginkgo"
@@ -302,4 +304,47 @@ var _ = Describe("Gitlab Provider Tests", func() {
 			}),
 		)
 	})
+
+	Context("when refreshing a session", func() {
+		It("keeps when the user authenticated", func() {
+			idToken, err := newSignedTestIDToken(defaultIDToken)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := json.Marshal(redeemTokenResponse{
+				AccessToken:  accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			server, oidcProvider := newTestOIDCSetup(body)
+			defer server.Close()
+			p = NewGitLabProvider(oidcProvider.ProviderData)
+
+			authenticated := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+			created := time.Now().Add(-time.Hour).Truncate(time.Second)
+			session := &sessions.SessionState{
+				AccessToken:     "old_access_token",
+				IDToken:         "old_id_token",
+				RefreshToken:    "old_refresh_token",
+				CreatedAt:       &created,
+				AuthenticatedAt: &authenticated,
+				Email:           "foo@bar.com",
+				Groups:          []string{"foo"},
+			}
+
+			refreshed, err := p.RefreshSession(context.Background(), session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refreshed).To(BeTrue())
+			Expect(session.AccessToken).To(Equal(accessToken))
+			Expect(session.IDToken).To(Equal(idToken))
+			Expect(session.RefreshToken).To(Equal(refreshToken))
+			Expect(session.CreatedAt.After(created)).To(BeTrue())
+			Expect(*session.AuthenticatedAt).To(Equal(authenticated))
+			Expect(session.GetClaim("authenticated_at")).To(Equal([]string{authenticated.String()}))
+			Expect(session.Email).To(Equal("foo@bar.com"))
+			Expect(session.Groups).To(Equal([]string{"foo"}))
+		})
+	})
 })
@@ -176,6 +176,26 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 	return ss, nil
 }
 
+// AddExtraScopes adds scopes to those requested at login, so that the access
+// token stored in the session can be used to call other Google APIs.
+// Scopes that are already requested are not added again.
+func (p *GoogleProvider) AddExtraScopes(scopes []string) {
+	requested := strings.Fields(p.Scope)
+	requestedSet := make(map[string]struct{}, len(requested))
+	for _, scope := range requested {
+		requestedSet[scope] = struct{}{}
+	}
+
+	for _, scope := range scopes {
+		if _, ok := requestedSet[scope]; ok || scope == "" {
+			continue
+		}
+		requestedSet[scope] = struct{}{}
+		requested = append(requested, scope)
+	}
+	p.Scope = strings.Join(requested, " ")
+}
+
 // EnrichSession checks the listed Google Groups configured and adds any
 // that the user is a member of to session.Groups.
 func (p *GoogleProvider) EnrichSession(_ context.Context, s *sessions.SessionState) error {
@@ -301,24 +321,32 @@ func (p *GoogleProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 	params.Add("grant_type", "refresh_token")
 
 	var data struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int64  `json:"expires_in"`
-		IDToken     string `json:"id_token"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&data)
+		Do()
+	if err := refreshTokenResultError(result); err != nil {
+		return err
+	}
+	err = result.UnmarshalInto(&data)
 	if err != nil {
 		return err
 	}
 
 	s.AccessToken = data.AccessToken
 	s.IDToken = data.IDToken
+	// Google only returns a refresh token on refresh when it has been rotated
+	if data.RefreshToken != "" {
+		s.RefreshToken = data.RefreshToken
+	}
 
 	s.CreatedAtNow()
 	s.ExpiresIn(time.Duration(data.ExpiresIn) * time.Second)
@@ -110,6 +110,67 @@ func TestGoogleProviderGetEmailAddress(t *testing.T) {
 	assert.Equal(t, "refresh12345", session.RefreshToken)
 }
 
+func TestGoogleProviderAddExtraScopes(t *testing.T) {
+	p := NewGoogleProvider(&ProviderData{})
+	p.AddExtraScopes([]string{
+		"https://www.googleapis.com/auth/calendar.readonly",
+		"email",
+		"",
+		"https://www.googleapis.com/auth/calendar.readonly",
+	})
+	assert.Equal(t, "profile email https://www.googleapis.com/auth/calendar.readonly", p.Data().Scope)
+
+	loginURL, err := url.Parse(p.GetLoginURL("http://redirect/", "state", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, "profile email https://www.googleapis.com/auth/calendar.readonly", loginURL.Query().Get("scope"))
+	assert.Equal(t, "offline", loginURL.Query().Get("access_type"))
+}
+
+func TestGoogleProviderRefreshSession(t *testing.T) {
+	testCases := map[string]struct {
+		refreshToken         string
+		expectedRefreshToken string
+	}{
+		"keeps the refresh token when it is not rotated": {
+			refreshToken:         "",
+			expectedRefreshToken: "refresh12345",
+		},
+		"stores the rotated refresh token": {
+			refreshToken:         "refresh67890",
+			expectedRefreshToken: "refresh67890",
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			p := newGoogleProvider()
+			p.AddExtraScopes([]string{"https://www.googleapis.com/auth/calendar.readonly"})
+			body, err := json.Marshal(redeemResponse{
+				AccessToken:  "a5678",
+				ExpiresIn:    10,
+				RefreshToken: tc.refreshToken,
+				IDToken:      "ignored prefix." + base64.URLEncoding.EncodeToString([]byte(`{"email": "michael.bland@gsa.gov", "email_verified":true}`)),
+			})
+			assert.NoError(t, err)
+			var server *httptest.Server
+			p.RedeemURL, server = newRedeemServer(body)
+			defer server.Close()
+
+			session := &sessions.SessionState{
+				AccessToken:  "a1234",
+				RefreshToken: "refresh12345",
+				Email:        "michael.bland@gsa.gov",
+			}
+			refreshed, err := p.RefreshSession(context.Background(), session)
+			assert.NoError(t, err)
+			assert.True(t, refreshed)
+			assert.Equal(t, "a5678", session.AccessToken)
+			assert.Equal(t, tc.expectedRefreshToken, session.RefreshToken)
+			assert.NotNil(t, session.ExpiresOn)
+		})
+	}
+}
+
 func TestGoogleProviderGroupValidator(t *testing.T) {
 	const sessionEmail = "michael.bland@gsa.gov"
 
@@ -3,6 +3,7 @@ package providers
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 )
@@ -12,6 +13,10 @@ const keycloakOIDCProviderName = "Keycloak OIDC"
 // KeycloakOIDCProvider creates a Keycloak provider based on OIDCProvider
 type KeycloakOIDCProvider struct {
 	*OIDCProvider
+
+	// roleClients are the clients whose roles are added to the session.
+	// The roles of all clients are added when it is empty.
+	roleClients map[string]struct{}
 }
 
 // NewKeycloakOIDCProvider makes a KeycloakOIDCProvider using the ProviderData
@@ -38,6 +43,15 @@ func (p *KeycloakOIDCProvider) AddAllowedRoles(roles []string) {
 	}
 }
 
+// SetRoleClients restricts the client roles added to the session to the
+// roles of these clients. Realm roles are always added.
+func (p *KeycloakOIDCProvider) SetRoleClients(clients []string) {
+	p.roleClients = make(map[string]struct{}, len(clients))
+	for _, client := range clients {
+		p.roleClients[client] = struct{}{}
+	}
+}
+
 // EnrichSession is called after Redeem to allow providers to enrich session fields
 // such as User, Email, Groups with provider specific API calls.
 func (p *KeycloakOIDCProvider) EnrichSession(ctx context.Context, s *sessions.SessionState) error {
@@ -68,11 +82,22 @@ func (p *KeycloakOIDCProvider) extractRoles(ctx context.Context, s *sessions.Ses
 
 	var roles []string
 	roles = append(roles, claims.RealmAccess.Roles...)
-	roles = append(roles, getClientRoles(claims)...)
-
-	// Add to groups list with `role:` prefix to distinguish from groups
+	roles = append(roles, getClientRoles(claims, p.roleClients)...)
+
+	// Add to groups list with `role:` prefix to distinguish from groups.
+	// The groups are kept when a refresh doesn't return a new ID token, so
+	// roles that are already present aren't added again.
+	existing := make(map[string]struct{}, len(s.Groups))
+	for _, group := range s.Groups {
+		existing[group] = struct{}{}
+	}
 	for _, role := range roles {
-		s.Groups = append(s.Groups, formatRole(role))
+		group := formatRole(role)
+		if _, ok := existing[group]; ok {
+			continue
+		}
+		existing[group] = struct{}{}
+		s.Groups = append(s.Groups, group)
 	}
 	return nil
 }
@@ -101,7 +126,9 @@ func (p *KeycloakOIDCProvider) getAccessClaims(ctx context.Context, s *sessions.
 }
 
 // getClientRoles extracts client roles from the `resource_access` claim with
-// the format `client:role`.
+// the format `client:role`. Only the roles of the clients given are
+// extracted, or the roles of all clients if there are none.
+// The clients are sorted by name so that the roles are in a stable order.
 //
 // ResourceAccess format:
 // "resource_access": {
@@ -118,20 +145,31 @@ func (p *KeycloakOIDCProvider) getAccessClaims(ctx context.Context, s *sessions.
 //     ]
 //   }
 // }
-func getClientRoles(claims *accessClaims) []string {
+func getClientRoles(claims *accessClaims, clients map[string]struct{}) []string {
+	clientNames := make([]string, 0, len(claims.ResourceAccess))
+	for clientName := range claims.ResourceAccess {
+		if _, ok := clients[clientName]; len(clients) > 0 && !ok {
+			continue
+		}
+		clientNames = append(clientNames, clientName)
+	}
+	sort.Strings(clientNames)
+
 	var clientRoles []string
-	for clientName, access := range claims.ResourceAccess {
-		accessMap, ok := access.(map[string]interface{})
+	for _, clientName := range clientNames {
+		accessMap, ok := claims.ResourceAccess[clientName].(map[string]interface{})
 		if !ok {
 			continue
 		}
 
-		var roles interface{}
-		if roles, ok = accessMap["roles"]; !ok {
+		roles, ok := accessMap["roles"].([]interface{})
+		if !ok {
 			continue
 		}
-		for _, role := range roles.([]interface{}) {
-			clientRoles = append(clientRoles, fmt.Sprintf("%s:%s", clientName, role))
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				clientRoles = append(clientRoles, fmt.Sprintf("%s:%s", clientName, role))
+			}
 		}
 	}
 	return clientRoles
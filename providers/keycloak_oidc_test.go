@@ -11,6 +11,7 @@ import (
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -158,6 +159,87 @@ var _ = Describe("Keycloak OIDC Provider Tests", func() {
 			Expect(err).To(BeNil())
 			Expect(existingSession).To(Equal(expectedSession))
 		})
+
+		It("should only add the roles of the role clients", func() {
+			server, provider := newTestKeycloakOIDCSetup()
+			defer server.Close()
+			provider.SetRoleClients([]string{"other"})
+
+			existingSession := &sessions.SessionState{
+				Email:        "a@b.com",
+				IDToken:      idToken,
+				AccessToken:  getAccessToken(),
+				RefreshToken: refreshToken,
+			}
+
+			err := provider.EnrichSession(context.Background(), existingSession)
+			Expect(err).To(BeNil())
+			Expect(existingSession.Groups).To(Equal([]string{"role:write"}))
+		})
+
+		It("should not add roles that are already in the groups", func() {
+			server, provider := newTestKeycloakOIDCSetup()
+			defer server.Close()
+
+			existingSession := &sessions.SessionState{
+				Email:        "a@b.com",
+				Groups:       []string{"existing", "role:write"},
+				IDToken:      idToken,
+				AccessToken:  getAccessToken(),
+				RefreshToken: refreshToken,
+			}
+
+			Expect(provider.EnrichSession(context.Background(), existingSession)).To(Succeed())
+			Expect(provider.EnrichSession(context.Background(), existingSession)).To(Succeed())
+			Expect(existingSession.Groups).To(Equal([]string{"existing", "role:write", "role:default:read"}))
+		})
+	})
+
+	Context("Client Roles", func() {
+		type clientRolesTableInput struct {
+			resourceAccess map[string]interface{}
+			clients        []string
+			expectedRoles  []string
+		}
+
+		resourceAccess := map[string]interface{}{
+			"clientB": map[string]interface{}{"roles": []interface{}{"roleA", "roleB"}},
+			"clientA": map[string]interface{}{"roles": []interface{}{"roleA"}},
+			"clientC": map[string]interface{}{"roles": []interface{}{"roleC"}},
+		}
+
+		DescribeTable("should extract the client roles",
+			func(in clientRolesTableInput) {
+				clients := make(map[string]struct{})
+				for _, client := range in.clients {
+					clients[client] = struct{}{}
+				}
+				roles := getClientRoles(&accessClaims{ResourceAccess: in.resourceAccess}, clients)
+				Expect(roles).To(Equal(in.expectedRoles))
+			},
+			Entry("of all clients, sorted by client", clientRolesTableInput{
+				resourceAccess: resourceAccess,
+				expectedRoles:  []string{"clientA:roleA", "clientB:roleA", "clientB:roleB", "clientC:roleC"},
+			}),
+			Entry("of the role clients", clientRolesTableInput{
+				resourceAccess: resourceAccess,
+				clients:        []string{"clientC", "clientB"},
+				expectedRoles:  []string{"clientB:roleA", "clientB:roleB", "clientC:roleC"},
+			}),
+			Entry("with a role client without roles", clientRolesTableInput{
+				resourceAccess: resourceAccess,
+				clients:        []string{"clientD"},
+				expectedRoles:  nil,
+			}),
+			Entry("with malformed roles", clientRolesTableInput{
+				resourceAccess: map[string]interface{}{
+					"clientA": "roleA",
+					"clientB": map[string]interface{}{"roles": "roleB"},
+					"clientC": map[string]interface{}{"roles": []interface{}{"roleC", 1, map[string]interface{}{}}},
+				},
+				expectedRoles: []string{"clientC:roleC"},
+			}),
+		)
 	})
 
 	Context("Refresh Session", func() {
@@ -102,9 +102,10 @@ func (p *OIDCProvider) enrichFromProfileURL(ctx context.Context, s *sessions.Ses
 		return err
 	}
 
-	email, err := respJSON.Get(p.EmailClaim).String()
-	if err == nil && s.Email == "" {
-		s.Email = email
+	if s.Email == "" {
+		if claims, err := respJSON.Map(); err == nil {
+			s.Email, _ = p.selectEmail(claims, nil)
+		}
 	}
 
 	if len(s.Groups) > 0 {
@@ -151,7 +152,7 @@ func (p *OIDCProvider) RefreshSession(ctx context.Context, s *sessions.SessionSt
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -178,12 +179,12 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 	}
 	token, err := c.TokenSource(ctx, t).Token()
 	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+		return fmt.Errorf("failed to get token: %w", refreshTokenError(err))
 	}
 
 	newSession, err := p.createSession(ctx, token, true)
 	if err != nil {
-		return fmt.Errorf("unable create new session state from response: %v", err)
+		return fmt.Errorf("unable create new session state from response: %w", err)
 	}
 
 	// It's possible that if the refresh token isn't in the token response the
@@ -195,6 +196,11 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		s.User = newSession.User
 		s.Groups = newSession.Groups
 		s.PreferredUsername = newSession.PreferredUsername
+		// Refreshing doesn't authenticate the user again, so providers may
+		// leave out the amr claim of the original login
+		if len(newSession.AMR) > 0 {
+			s.AMR = newSession.AMR
+		}
 	}
 
 	s.AccessToken = newSession.AccessToken
@@ -217,9 +223,11 @@ func (p *OIDCProvider) CreateSessionFromToken(ctx context.Context, token string)
 		return nil, err
 	}
 
-	// Allow empty Email in Bearer case since we can't hit the ProfileURL
+	// Allow empty Email in Bearer case since we can't hit the ProfileURL.
+	// The subject is used rather than the User, which may have been built
+	// from other claims by the user template.
 	if ss.Email == "" {
-		ss.Email = ss.User
+		ss.Email = idToken.Subject
 	}
 
 	ss.AccessToken = token
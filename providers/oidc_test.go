@@ -530,10 +530,41 @@ func TestOIDCProviderRefreshSessionIfNeededWithIdToken(t *testing.T) {
 	assert.Equal(t, refreshToken, existingSession.RefreshToken)
 }
 
+func TestOIDCProviderRefreshSessionWithFailedClaimAssertion(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	server, provider := newTestOIDCSetup(body)
+	defer server.Close()
+	provider.ClaimAssertions = []*ClaimAssertion{
+		mustNewClaimAssertion("groups", ClaimAssertionContains, "test:admin", nil),
+	}
+
+	existingSession := &sessions.SessionState{
+		AccessToken:  "unchanged",
+		IDToken:      "unchanged",
+		RefreshToken: refreshToken,
+		Email:        "unchanged",
+		User:         "unchanged",
+	}
+	refreshed, err := provider.RefreshSession(context.Background(), existingSession)
+	assert.False(t, refreshed)
+	assert.True(t, errors.Is(err, ErrClaimAssertionFailed))
+	assert.Equal(t, "unchanged", existingSession.AccessToken)
+	assert.Equal(t, "unchanged", existingSession.IDToken)
+}
+
 func TestOIDCProviderCreateSessionFromToken(t *testing.T) {
 	testCases := map[string]struct {
 		IDToken        idTokenClaims
 		GroupsClaim    string
+		UserTemplate   string
 		ExpectedUser   string
 		ExpectedEmail  string
 		ExpectedGroups []string
@@ -552,6 +583,14 @@ func TestOIDCProviderCreateSessionFromToken(t *testing.T) {
 			ExpectedEmail:  "123456789",
 			ExpectedGroups: nil,
 		},
+		"Minimal IDToken with no email claim and a user template": {
+			IDToken:        minimalIDToken,
+			GroupsClaim:    "groups",
+			UserTemplate:   "{{.sub}}@{{.iss}}",
+			ExpectedUser:   "123456789@https://issuer.example.com",
+			ExpectedEmail:  "123456789",
+			ExpectedGroups: nil,
+		},
 		"Custom Groups Claim": {
 			IDToken:        defaultIDToken,
 			GroupsClaim:    "roles",
@@ -571,6 +610,11 @@ func TestOIDCProviderCreateSessionFromToken(t *testing.T) {
 		t.Run(testName, func(t *testing.T) {
 			server, provider := newTestOIDCSetup([]byte(`{}`))
 			provider.GroupsClaim = tc.GroupsClaim
+			if tc.UserTemplate != "" {
+				userTemplate, err := ParseUserTemplate(tc.UserTemplate)
+				assert.NoError(t, err)
+				provider.UserTemplate = userTemplate
+			}
 			defer server.Close()
 
 			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
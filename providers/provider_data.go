@@ -1,13 +1,17 @@
 package providers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -29,6 +33,7 @@ type ProviderData struct {
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
+	IntrospectURL     *url.URL
 	// Auth request params & related, see
 	//https://openid.net/specs/openid-connect-basic-1_0.html#rfc.section.2.1.1.1
 	AcrValues        string
@@ -40,14 +45,23 @@ type ProviderData struct {
 	Prompt           string
 
 	// Common OIDC options for any OIDC-based providers to consume
-	AllowUnverifiedEmail bool
-	EmailClaim           string
-	GroupsClaim          string
-	Verifier             *oidc.IDTokenVerifier
+	AllowUnverifiedEmail  bool
+	EmailClaim            string
+	ExtraEmailClaims      []string
+	PreferredEmailDomains []string
+	PreferVerifiedEmail   bool
+	GroupsClaim           string
+	UserTemplate          *template.Template
+	ClaimAssertions       []*ClaimAssertion
+	Verifier              *oidc.IDTokenVerifier
 
 	// Universal Group authorization data structure
 	// any provider can set to consume
 	AllowedGroups map[string]struct{}
+
+	// allowedGroupsLock guards AllowedGroups as they may be reloaded while
+	// sessions are being authorized
+	allowedGroupsLock sync.RWMutex
 }
 
 // Data returns the ProviderData
@@ -70,10 +84,14 @@ func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 // SetAllowedGroups organizes a group list into the AllowedGroups map
 // to be consumed by Authorize implementations
 func (p *ProviderData) SetAllowedGroups(groups []string) {
-	p.AllowedGroups = make(map[string]struct{}, len(groups))
+	allowedGroups := make(map[string]struct{}, len(groups))
 	for _, group := range groups {
-		p.AllowedGroups[group] = struct{}{}
+		allowedGroups[group] = struct{}{}
 	}
+
+	p.allowedGroupsLock.Lock()
+	defer p.allowedGroupsLock.Unlock()
+	p.AllowedGroups = allowedGroups
 }
 
 type providerDefaults struct {
@@ -151,8 +169,20 @@ func (p *ProviderData) buildSessionFromClaims(idToken *oidc.IDToken) (*sessions.
 	if err != nil {
 		return nil, fmt.Errorf("couldn't extract claims from id_token (%v)", err)
 	}
+	if err := p.checkClaimAssertions(claims.raw); err != nil {
+		return nil, fmt.Errorf("%w (%v)", ErrClaimAssertionFailed, err)
+	}
 
 	ss.User = claims.Subject
+	if p.UserTemplate != nil {
+		ss.User, err = executeUserTemplate(p.UserTemplate, claims.raw)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't build user from id_token claims (%v)", err)
+		}
+		if strings.TrimSpace(ss.User) == "" {
+			return nil, errors.New("couldn't build user from id_token claims (the user template resulted in an empty user)")
+		}
+	}
 	ss.Email = claims.Email
 	ss.Groups = claims.Groups
 
@@ -160,11 +190,11 @@ func (p *ProviderData) buildSessionFromClaims(idToken *oidc.IDToken) (*sessions.
 	if pref, ok := claims.raw["preferred_username"].(string); ok {
 		ss.PreferredUsername = pref
 	}
+	ss.AMR = extractAMR(claims.raw)
 
-	// `email_verified` must be present and explicitly set to `false` to be
-	// considered unverified.
-	verifyEmail := (p.EmailClaim == OIDCEmailClaim) && !p.AllowUnverifiedEmail
-	if verifyEmail && claims.Verified != nil && !*claims.Verified {
+	// The claims must explicitly state that the email is unverified for it
+	// to be considered unverified.
+	if !p.AllowUnverifiedEmail && claims.Verified != nil && !*claims.Verified {
 		return nil, fmt.Errorf("email in id_token (%s) isn't verified", claims.Email)
 	}
 
@@ -184,15 +214,54 @@ func (p *ProviderData) getClaims(idToken *oidc.IDToken) (*OIDCClaims, error) {
 		return nil, fmt.Errorf("failed to parse all id_token claims: %v", err)
 	}
 
-	email := claims.raw[p.EmailClaim]
-	if email != nil {
-		claims.Email = fmt.Sprint(email)
-	}
+	claims.Email, claims.Verified = p.selectEmail(claims.raw, claims.Verified)
 	claims.Groups = p.extractGroups(claims.raw)
 
 	return claims, nil
 }
 
+// ParseUserTemplate parses a template used to build the user from the
+// claims of an ID Token.
+// The template is executed without any claims to catch errors that would
+// otherwise only occur when users log in, eg. accessing fields of claims.
+func ParseUserTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("user").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeUserTemplate(tmpl, map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// executeUserTemplate builds the user from the claims.
+// The claims are converted to strings first, so that missing claims are
+// substituted predictably with an empty string, rather than "<no value>".
+func executeUserTemplate(tmpl *template.Template, claims map[string]interface{}) (string, error) {
+	values := make(map[string]string, len(claims))
+	for name, claim := range claims {
+		switch v := claim.(type) {
+		case string:
+			values[name] = v
+		case nil:
+			values[name] = ""
+		default:
+			value, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("unable to format claim %q: %v", name, err)
+			}
+			values[name] = string(value)
+		}
+	}
+
+	var user bytes.Buffer
+	if err := tmpl.Execute(&user, values); err != nil {
+		return "", err
+	}
+	return user.String(), nil
+}
+
 // checkNonce compares the session's nonce with the IDToken's nonce claim
 func (p *ProviderData) checkNonce(s *sessions.SessionState, idToken *oidc.IDToken) error {
 	claims, err := p.getClaims(idToken)
@@ -205,6 +274,26 @@ func (p *ProviderData) checkNonce(s *sessions.SessionState, idToken *oidc.IDToke
 	return nil
 }
 
+// extractAMR extracts the authentication methods from the amr claim.
+// The claim should be a list of strings, but non-standard singleton strings
+// are also supported.
+func extractAMR(claims map[string]interface{}) []string {
+	switch raw := claims["amr"].(type) {
+	case string:
+		return []string{raw}
+	case []interface{}:
+		methods := []string{}
+		for _, rawMethod := range raw {
+			if method, ok := rawMethod.(string); ok {
+				methods = append(methods, method)
+			}
+		}
+		return methods
+	default:
+		return nil
+	}
+}
+
 // extractGroups extracts groups from a claim to a list in a type safe manner.
 // If the claim isn't present, `nil` is returned. If the groups claim is
 // present but empty, `[]string{}` is returned.
@@ -100,6 +100,7 @@ type idTokenClaims struct {
 	Roles    interface{} `json:"roles,omitempty"`
 	Verified *bool       `json:"email_verified,omitempty"`
 	Nonce    string      `json:"nonce,omitempty"`
+	AMR      interface{} `json:"amr,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -213,6 +214,8 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 		AllowUnverified bool
 		EmailClaim      string
 		GroupsClaim     string
+		UserTemplate    string
+		ClaimAssertions []*ClaimAssertion
 		ExpectedError   error
 		ExpectedSession *sessions.SessionState
 	}{
@@ -259,6 +262,36 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Complex Claim",
 			},
 		},
+		"Authentication Methods": {
+			IDToken: idTokenClaims{
+				Email:          "janed@me.com",
+				Verified:       &verified,
+				AMR:            []string{"pwd", "mfa"},
+				StandardClaims: standardClaims,
+			},
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:  "123456789",
+				Email: "janed@me.com",
+				AMR:   []string{"pwd", "mfa"},
+			},
+		},
+		"Singleton Authentication Method": {
+			IDToken: idTokenClaims{
+				Email:          "janed@me.com",
+				Verified:       &verified,
+				AMR:            "otp",
+				StandardClaims: standardClaims,
+			},
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:  "123456789",
+				Email: "janed@me.com",
+				AMR:   []string{"otp"},
+			},
+		},
 		"Email Claim Switched": {
 			IDToken:         unverifiedIDToken,
 			AllowUnverified: true,
@@ -278,7 +311,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			GroupsClaim:     "groups",
 			ExpectedSession: &sessions.SessionState{
 				User:              "123456789",
-				Email:             "[test:c test:d]",
+				Email:             "test:c",
 				Groups:            []string{"test:a", "test:b"},
 				PreferredUsername: "Mystery Man",
 			},
@@ -319,6 +352,80 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Jane Dobbs",
 			},
 		},
+		"User Template": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			UserTemplate:    "{{.preferred_username}} <{{.sub}}@{{.iss}}>",
+			ExpectedSession: &sessions.SessionState{
+				User:              "Jane Dobbs <123456789@https://issuer.example.com>",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"User Template Missing Claim": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			UserTemplate:    "{{.given_name}}-{{.sub}}",
+			ExpectedSession: &sessions.SessionState{
+				User:              "-123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"User Template Empty Result": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			UserTemplate:    "{{.given_name}}",
+			ExpectedError:   errors.New("couldn't build user from id_token claims (the user template resulted in an empty user)"),
+		},
+		"User Template Non String Claims": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			UserTemplate:    "{{.roles}} {{.email_verified}} {{.iat}}",
+			ExpectedSession: &sessions.SessionState{
+				User:              fmt.Sprintf("[\"test:c\",\"test:d\"] true %d", standardClaims.IssuedAt),
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Claim Assertions Passed": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ClaimAssertions: []*ClaimAssertion{
+				mustNewClaimAssertion("iss", ClaimAssertionEquals, oidcIssuer, nil),
+				mustNewClaimAssertion("groups", ClaimAssertionContains, "test:a", nil),
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Claim Assertions Failed": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ClaimAssertions: []*ClaimAssertion{
+				mustNewClaimAssertion("iss", ClaimAssertionEquals, oidcIssuer, nil),
+				mustNewClaimAssertion("groups", ClaimAssertionContains, "test:e", nil),
+			},
+			ExpectedError: fmt.Errorf("%w (claim \"groups\" does not pass the \"contains\" assertion)", ErrClaimAssertionFailed),
+		},
 	}
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -334,6 +441,12 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			provider.AllowUnverifiedEmail = tc.AllowUnverified
 			provider.EmailClaim = tc.EmailClaim
 			provider.GroupsClaim = tc.GroupsClaim
+			provider.ClaimAssertions = tc.ClaimAssertions
+			if tc.UserTemplate != "" {
+				userTemplate, err := ParseUserTemplate(tc.UserTemplate)
+				g.Expect(err).ToNot(HaveOccurred())
+				provider.UserTemplate = userTemplate
+			}
 
 			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
 			g.Expect(err).ToNot(HaveOccurred())
@@ -343,7 +456,12 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 
 			ss, err := provider.buildSessionFromClaims(idToken)
 			if err != nil {
-				g.Expect(err).To(Equal(tc.ExpectedError))
+				if errors.Is(tc.ExpectedError, ErrClaimAssertionFailed) {
+					g.Expect(errors.Is(err, ErrClaimAssertionFailed)).To(BeTrue())
+					g.Expect(err).To(MatchError(tc.ExpectedError.Error()))
+				} else {
+					g.Expect(err).To(Equal(tc.ExpectedError))
+				}
 			}
 			if ss != nil {
 				g.Expect(ss).To(Equal(tc.ExpectedSession))
@@ -352,6 +470,45 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 	}
 }
 
+func mustNewClaimAssertion(claim, operator, value string, values []string) *ClaimAssertion {
+	assertion, err := NewClaimAssertion(claim, operator, value, values)
+	if err != nil {
+		panic(err)
+	}
+	return assertion
+}
+
+func TestProviderData_ParseUserTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		UserTemplate  string
+		ExpectedError string
+	}{
+		"Valid": {
+			UserTemplate: "{{.given_name}} {{.family_name}}",
+		},
+		"Syntax Error": {
+			UserTemplate:  "{{.given_name",
+			ExpectedError: "template: user:1: unclosed action",
+		},
+		"Nested Claim": {
+			UserTemplate:  "{{.address.locality}}",
+			ExpectedError: "template: user:1:10: executing \"user\" at <.address.locality>: can't evaluate field locality in type string",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := ParseUserTemplate(tc.UserTemplate)
+			if tc.ExpectedError == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(tc.ExpectedError))
+			}
+		})
+	}
+}
+
 func TestProviderData_checkNonce(t *testing.T) {
 	testCases := map[string]struct {
 		Session       *sessions.SessionState
@@ -111,6 +111,9 @@ func (p *ProviderData) EnrichSession(_ context.Context, _ *sessions.SessionState
 // Authorize performs global authorization on an authenticated session.
 // This is not used for fine-grained per route authorization rules.
 func (p *ProviderData) Authorize(_ context.Context, s *sessions.SessionState) (bool, error) {
+	p.allowedGroupsLock.RLock()
+	defer p.allowedGroupsLock.RUnlock()
+
 	if len(p.AllowedGroups) == 0 {
 		return true, nil
 	}
@@ -0,0 +1,144 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrRefreshTokenRevoked is returned when refreshing a session fails
+	// because the provider rejects its refresh token, as it has been revoked
+	// or has expired.
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+
+	// ErrProviderUnavailable is returned when refreshing a session fails
+	// because the provider could not be reached, did not respond in time or
+	// responded with a server error, rather than rejecting the refresh.
+	ErrProviderUnavailable = errors.New("provider unavailable")
+
+	// ErrMissingIntrospectURL is returned when a refresh token can't be
+	// introspected as no introspection endpoint is configured.
+	ErrMissingIntrospectURL = errors.New("missing introspection URL")
+)
+
+// oauthErrorResponse is the body of an error response from an OAuth2 token
+// endpoint
+type oauthErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// isInvalidGrant returns whether the body of a token endpoint response is an
+// invalid_grant error, which is returned for revoked refresh tokens
+func isInvalidGrant(body []byte) bool {
+	var resp oauthErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	return resp.Error == "invalid_grant"
+}
+
+// oauth2FetchErrorPrefix starts the errors of a TokenSource that could not
+// send the request or read the response. The oauth2 package formats the
+// underlying error into the message rather than wrapping it.
+const oauth2FetchErrorPrefix = "oauth2: cannot fetch token: "
+
+// refreshTokenError wraps errors from refreshing tokens with a TokenSource in
+// ErrRefreshTokenRevoked when the provider rejected the refresh token, or in
+// ErrProviderUnavailable when the provider failed to respond
+func refreshTokenError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if isInvalidGrant(retrieveErr.Body) {
+			return fmt.Errorf("%w: %v", ErrRefreshTokenRevoked, err)
+		}
+		if retrieveErr.Response != nil && isServerError(retrieveErr.Response.StatusCode) {
+			return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+		return err
+	}
+	if isNetworkError(err) || strings.HasPrefix(err.Error(), oauth2FetchErrorPrefix) {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return err
+}
+
+// refreshTokenResultError returns ErrRefreshTokenRevoked when the result of a
+// refresh token request is an error because the provider rejected the
+// refresh token, or ErrProviderUnavailable when the provider failed to
+// respond
+func refreshTokenResultError(result requests.Result) error {
+	if err := result.Error(); err != nil {
+		if isNetworkError(err) {
+			return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+		return nil
+	}
+	switch {
+	case result.StatusCode() == http.StatusBadRequest && isInvalidGrant(result.Body()):
+		return fmt.Errorf("%w: unexpected status \"%d\": %s", ErrRefreshTokenRevoked, result.StatusCode(), result.Body())
+	case isServerError(result.StatusCode()):
+		return fmt.Errorf("%w: unexpected status \"%d\": %s", ErrProviderUnavailable, result.StatusCode(), result.Body())
+	}
+	return nil
+}
+
+// isNetworkError returns whether the error is from connecting to the
+// provider, including timeouts
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func isServerError(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError
+}
+
+// IntrospectRefreshToken checks whether the refresh token of the session has
+// been revoked with the provider's token introspection endpoint (RFC 7662).
+// Sessions without a refresh token are never revoked.
+func (p *ProviderData) IntrospectRefreshToken(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	if s.RefreshToken == "" {
+		return false, nil
+	}
+	if p.IntrospectURL == nil || p.IntrospectURL.String() == "" {
+		return false, ErrMissingIntrospectURL
+	}
+
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return false, err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
+	params.Add("client_secret", clientSecret)
+	params.Add("token", s.RefreshToken)
+	params.Add("token_type_hint", "refresh_token")
+
+	var introspection struct {
+		Active bool `json:"active"`
+	}
+	err = requests.New(p.IntrospectURL.String()).
+		WithContext(ctx).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SetHeader("Accept", "application/json").
+		Do().
+		UnmarshalInto(&introspection)
+	if err != nil {
+		return false, fmt.Errorf("error introspecting refresh token: %v", err)
+	}
+	return !introspection.Active, nil
+}
@@ -0,0 +1,168 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIntrospectServer(t *testing.T, status int, body string) (*url.URL, *httptest.Server) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh-token", r.PostForm.Get("token"))
+		assert.Equal(t, "refresh_token", r.PostForm.Get("token_type_hint"))
+		assert.Equal(t, "client-id", r.PostForm.Get("client_id"))
+		assert.Equal(t, "client-secret", r.PostForm.Get("client_secret"))
+
+		rw.Header().Add("content-type", "application/json")
+		rw.WriteHeader(status)
+		_, _ = rw.Write([]byte(body))
+	}))
+	u, _ := url.Parse(s.URL)
+	return u, s
+}
+
+func TestIntrospectRefreshToken(t *testing.T) {
+	testCases := map[string]struct {
+		status          int
+		body            string
+		expectedRevoked bool
+		expectedErr     bool
+	}{
+		"active refresh token": {
+			status:          http.StatusOK,
+			body:            `{"active": true, "token_type": "refresh_token"}`,
+			expectedRevoked: false,
+		},
+		"revoked refresh token": {
+			status:          http.StatusOK,
+			body:            `{"active": false}`,
+			expectedRevoked: true,
+		},
+		"introspection error": {
+			status:      http.StatusUnauthorized,
+			body:        `{"error": "invalid_client"}`,
+			expectedErr: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			introspectURL, server := newIntrospectServer(t, tc.status, tc.body)
+			defer server.Close()
+
+			p := &ProviderData{
+				ClientID:      "client-id",
+				ClientSecret:  "client-secret",
+				IntrospectURL: introspectURL,
+			}
+			revoked, err := p.IntrospectRefreshToken(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedRevoked, revoked)
+		})
+	}
+}
+
+func TestIntrospectRefreshTokenWithoutRefreshToken(t *testing.T) {
+	p := &ProviderData{}
+	revoked, err := p.IntrospectRefreshToken(context.Background(), &sessions.SessionState{})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	_, err = p.IntrospectRefreshToken(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+	assert.Equal(t, ErrMissingIntrospectURL, err)
+}
+
+func TestOIDCProviderRefreshSessionWithRevokedRefreshToken(t *testing.T) {
+	testCases := map[string]struct {
+		status              int
+		body                string
+		unreachable         bool
+		expectedRevoked     bool
+		expectedUnavailable bool
+	}{
+		"invalid grant": {
+			status:          http.StatusBadRequest,
+			body:            `{"error": "invalid_grant", "error_description": "Token is not active"}`,
+			expectedRevoked: true,
+		},
+		"invalid client": {
+			status: http.StatusUnauthorized,
+			body:   `{"error": "invalid_client"}`,
+		},
+		"server error": {
+			status:              http.StatusInternalServerError,
+			body:                `internal server error`,
+			expectedUnavailable: true,
+		},
+		"unreachable provider": {
+			unreachable:         true,
+			expectedUnavailable: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Add("content-type", "application/json")
+				rw.WriteHeader(tc.status)
+				_, _ = rw.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			serverURL, _ := url.Parse(server.URL)
+			provider := newOIDCProvider(serverURL)
+			if tc.unreachable {
+				server.Close()
+			}
+
+			refreshed, err := provider.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+			assert.Error(t, err)
+			assert.False(t, refreshed)
+			assert.Equal(t, tc.expectedRevoked, errors.Is(err, ErrRefreshTokenRevoked))
+			assert.Equal(t, tc.expectedUnavailable, errors.Is(err, ErrProviderUnavailable))
+		})
+	}
+}
+
+func TestGoogleProviderRefreshSessionWithRevokedRefreshToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("content-type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+	}))
+	defer server.Close()
+
+	p := newGoogleProvider()
+	p.RedeemURL, _ = url.Parse(server.URL)
+
+	refreshed, err := p.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+	assert.False(t, refreshed)
+	assert.True(t, errors.Is(err, ErrRefreshTokenRevoked))
+}
+
+func TestGoogleProviderRefreshSessionWithUnavailableProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	p := newGoogleProvider()
+	p.RedeemURL, _ = url.Parse(server.URL)
+
+	refreshed, err := p.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+	assert.False(t, refreshed)
+	assert.True(t, errors.Is(err, ErrProviderUnavailable))
+
+	server.Close()
+	refreshed, err = p.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "refresh-token"})
+	assert.False(t, refreshed)
+	assert.True(t, errors.Is(err, ErrProviderUnavailable))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -26,11 +27,18 @@ func NewUserMap(usersFile string, done <-chan bool, onUpdate func()) *UserMap {
 	atomic.StorePointer(&um.m, unsafe.Pointer(&m)) // #nosec G103
 	if usersFile != "" {
 		logger.Printf("using authenticated emails file %s", usersFile)
-		WatchForUpdates(usersFile, done, func() {
-			um.LoadAuthenticatedEmailsFile()
+		reload := func() {
+			if err := um.LoadAuthenticatedEmailsFile(); err != nil {
+				logger.Errorf("%v, keeping the previous authenticated emails", err)
+				return
+			}
 			onUpdate()
-		})
-		um.LoadAuthenticatedEmailsFile()
+		}
+		WatchForUpdates(usersFile, done, reload)
+		ReloadOnSignal(done, reload)
+		if err := um.LoadAuthenticatedEmailsFile(); err != nil {
+			logger.Fatal(err)
+		}
 	}
 	return um
 }
@@ -43,16 +51,17 @@ func (um *UserMap) IsValid(email string) (result bool) {
 }
 
 // LoadAuthenticatedEmailsFile loads the authenticated emails file from disk
-// and parses the contents as CSV
-func (um *UserMap) LoadAuthenticatedEmailsFile() {
+// and parses the contents as CSV.
+// If the file cannot be read, the previously loaded emails are kept.
+func (um *UserMap) LoadAuthenticatedEmailsFile() error {
 	r, err := os.Open(um.usersFile)
 	if err != nil {
-		logger.Fatalf("failed opening authenticated-emails-file=%q, %s", um.usersFile, err)
+		return fmt.Errorf("failed opening authenticated-emails-file=%q, %s", um.usersFile, err)
 	}
 	defer func(c io.Closer) {
 		cerr := c.Close()
 		if cerr != nil {
-			logger.Fatalf("Error closing authenticated emails file: %s", cerr)
+			logger.Errorf("Error closing authenticated emails file: %s", cerr)
 		}
 	}(r)
 	csvReader := csv.NewReader(r)
@@ -61,8 +70,7 @@ func (um *UserMap) LoadAuthenticatedEmailsFile() {
 	csvReader.TrimLeadingSpace = true
 	records, err := csvReader.ReadAll()
 	if err != nil {
-		logger.Errorf("error reading authenticated-emails-file=%q, %s", um.usersFile, err)
-		return
+		return fmt.Errorf("error reading authenticated-emails-file=%q, %s", um.usersFile, err)
 	}
 	updated := make(map[string]bool)
 	for _, r := range records {
@@ -70,6 +78,7 @@ func (um *UserMap) LoadAuthenticatedEmailsFile() {
 		updated[address] = true
 	}
 	atomic.StorePointer(&um.m, unsafe.Pointer(&updated)) // #nosec G103
+	return nil
 }
 
 func newValidatorImpl(domains []string, usersFile string,